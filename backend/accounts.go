@@ -0,0 +1,195 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ErrDefaultAccount is returned when removing a default account of a keystore. Default accounts can
+// only be hidden.
+var ErrDefaultAccount = errp.New("default accounts can't be removed")
+
+// HiddenAccount is an account which is configured but not loaded.
+type HiddenAccount struct {
+	CoinCode string `json:"coinCode"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+}
+
+// isDefaultAccount returns true if the code belongs to a default account of the registered
+// keystores.
+func (backend *Backend) isDefaultAccount(code string) bool {
+	defer backend.accountsLock.RLock()()
+	for _, defaultAccount := range backend.defaultAccounts {
+		if defaultAccount.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// copySettings replaces the settings map of the accounts config by a copy, so it can be modified
+// without changing the current config, which shares the map.
+func copySettings(accountsConfig *config.AccountsConfig) {
+	settings := map[string]config.AccountSettings{}
+	for code, accountSettings := range accountsConfig.Settings {
+		settings[code] = accountSettings
+	}
+	accountsConfig.Settings = settings
+}
+
+// removedAccountsExcept returns a copy of the removed accounts without the account with the given
+// coin and configuration. A copy is returned, as the slice is shared with the current config.
+func removedAccountsExcept(
+	removed []config.Account, coinCode string, configuration *signing.Configuration) []config.Account {
+	result := []config.Account{}
+	for _, account := range removed {
+		if account.CoinCode != coinCode || account.Configuration.Hash() != configuration.Hash() {
+			result = append(result, account)
+		}
+	}
+	return result
+}
+
+// updateAccountSettings applies f to the settings of the account with the given code and persists
+// them.
+func (backend *Backend) updateAccountSettings(code string, f func(*config.AccountSettings)) error {
+	accountsConfig := backend.config.AccountsConfig()
+	if !backend.isDefaultAccount(code) && accountsConfig.Lookup(code) == nil {
+		return errp.Newf("unknown account %s", code)
+	}
+	copySettings(&accountsConfig)
+	settings := accountsConfig.Settings[code]
+	f(&settings)
+	if settings == (config.AccountSettings{}) {
+		delete(accountsConfig.Settings, code)
+	} else {
+		accountsConfig.Settings[code] = settings
+	}
+	return backend.config.SetAccountsConfig(accountsConfig)
+}
+
+// RenameAccount changes the name of the account with the given code.
+func (backend *Backend) RenameAccount(code string, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errp.New("the name must not be empty")
+	}
+	err := backend.updateAccountSettings(code, func(settings *config.AccountSettings) {
+		settings.Name = name
+	})
+	if err != nil {
+		return err
+	}
+	unlock := backend.accountsLock.RLock()
+	for _, account := range backend.accounts {
+		if account.Code() == code {
+			account.SetName(name)
+		}
+	}
+	unlock()
+	backend.events <- backendEvent{
+		Type: "backend",
+		Data: "accountChanged",
+		Meta: map[string]interface{}{"code": code},
+	}
+	return nil
+}
+
+// SetAccountHidden hides or shows the account with the given code. Hidden accounts are closed and
+// not synced until they are shown again.
+func (backend *Backend) SetAccountHidden(code string, hidden bool) error {
+	err := backend.updateAccountSettings(code, func(settings *config.AccountSettings) {
+		settings.Hidden = hidden
+	})
+	if err != nil {
+		return err
+	}
+	if hidden {
+		backend.removeAccount(code)
+	} else {
+		backend.UpdateAccounts()
+	}
+	return nil
+}
+
+// RemoveAccount removes an added account from the accounts config and closes it.
+func (backend *Backend) RemoveAccount(code string) error {
+	if backend.isDefaultAccount(code) {
+		return errp.WithStack(ErrDefaultAccount)
+	}
+	accountsConfig := backend.config.AccountsConfig()
+	if accountsConfig.Lookup(code) == nil {
+		return errp.Newf("unknown account %s", code)
+	}
+	remaining := []config.Account{}
+	for _, account := range accountsConfig.Accounts {
+		if account.Code != code {
+			remaining = append(remaining, account)
+			continue
+		}
+		accountsConfig.RemovedAccounts = append(
+			removedAccountsExcept(accountsConfig.RemovedAccounts, account.CoinCode, account.Configuration),
+			account)
+	}
+	accountsConfig.Accounts = remaining
+	copySettings(&accountsConfig)
+	delete(accountsConfig.Settings, code)
+	if err := backend.config.SetAccountsConfig(accountsConfig); err != nil {
+		return err
+	}
+	backend.removeAccount(code)
+	return nil
+}
+
+// HiddenAccounts returns the hidden accounts, so they can be shown again.
+func (backend *Backend) HiddenAccounts() []*HiddenAccount {
+	accountsConfig := backend.config.AccountsConfig()
+	result := []*HiddenAccount{}
+	unlock := backend.accountsLock.RLock()
+	for _, defaultAccount := range backend.defaultAccounts {
+		if settings := accountsConfig.Settings[defaultAccount.code]; settings.Hidden {
+			name := defaultAccount.name
+			if settings.Name != "" {
+				name = settings.Name
+			}
+			result = append(result, &HiddenAccount{
+				CoinCode: defaultAccount.coin.Code(),
+				Code:     defaultAccount.code,
+				Name:     name,
+			})
+		}
+	}
+	unlock()
+	for _, account := range accountsConfig.Accounts {
+		if settings := accountsConfig.Settings[account.Code]; settings.Hidden {
+			name := account.Name
+			if settings.Name != "" {
+				name = settings.Name
+			}
+			result = append(result, &HiddenAccount{
+				CoinCode: account.CoinCode,
+				Code:     account.Code,
+				Name:     name,
+			})
+		}
+	}
+	return result
+}
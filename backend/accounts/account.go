@@ -31,6 +31,8 @@ type Interface interface {
 	Coin() coin.Coin
 	// Name returns a human readable long name.
 	Name() string
+	// SetName changes the name, e.g. when the user renames the account.
+	SetName(name string)
 	// Initialize only starts the initialization, the account is not initialized right afterwards.
 	Initialize() error
 	Initialized() bool
@@ -16,17 +16,19 @@ package accounts
 
 import "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 
-// Balance contains the available and incoming balance of an account.
+// Balance contains the available, incoming and frozen balance of an account.
 type Balance struct {
 	available coin.Amount
 	incoming  coin.Amount
+	frozen    coin.Amount
 }
 
 // NewBalance creates a new balance with the given amounts.
-func NewBalance(available coin.Amount, incoming coin.Amount) *Balance {
+func NewBalance(available coin.Amount, incoming coin.Amount, frozen coin.Amount) *Balance {
 	return &Balance{
 		available: available,
 		incoming:  incoming,
+		frozen:    frozen,
 	}
 }
 
@@ -40,3 +42,9 @@ func (balance *Balance) Available() coin.Amount {
 func (balance *Balance) Incoming() coin.Amount {
 	return balance.incoming
 }
+
+// Frozen returns the sum of the unspent coins which the user marked as not to be spent. They are
+// not included in the available balance.
+func (balance *Balance) Frozen() coin.Amount {
+	return balance.frozen
+}
@@ -32,4 +32,7 @@ var (
 	// ErrInsufficientFunds is returned when there are not enough funds to cover the target amount
 	// and fee.
 	ErrInsufficientFunds = TxValidationError("insufficientFunds")
+	// ErrFeeTooLow is returned when a replacement tx would not pay a higher fee rate than the tx it
+	// replaces.
+	ErrFeeTooLow = TxValidationError("feeTooLow")
 )
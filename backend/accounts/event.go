@@ -34,4 +34,12 @@ const (
 
 	// EventFeeTargetsChanged is fired when the fee targets change.
 	EventFeeTargetsChanged Event = "feeTargetsChanged"
+
+	// EventTxConflict is fired when two transactions of the account spend the same coins, i.e. when
+	// a double spend or a replacement of a transaction is detected.
+	EventTxConflict Event = "txConflict"
+
+	// EventFrozenOutputsChanged is fired when an output was frozen or unfrozen, which changes the
+	// balance.
+	EventFrozenOutputsChanged Event = "frozenOutputsChanged"
 )
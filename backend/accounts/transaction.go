@@ -43,6 +43,12 @@ const (
 	TxStatusComplete TxStatus = "complete"
 	// TxStatusFailed means the tx is confirmed but considered failed, e.g. a ETH transaction which
 	TxStatusFailed TxStatus = "failed"
+	// TxStatusReplaced means the tx can't be confirmed anymore, as another tx spending the same
+	// coins was confirmed, e.g. a fee bump replacement or a double spend.
+	TxStatusReplaced TxStatus = "replaced"
+	// TxStatusConflicted means the tx is unconfirmed and another unconfirmed tx spends the same
+	// coins. At most one of them can be confirmed.
+	TxStatusConflicted TxStatus = "conflicted"
 )
 
 // AddressAndAmount holds an address and the corresponding amount.
@@ -0,0 +1,117 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+// requireAccountEvent waits for the backend event with the given data about the account, skipping
+// the other events.
+func requireAccountEvent(t *testing.T, backend *Backend, data string, code string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-backend.events:
+			if event, ok := event.(backendEvent); ok && event.Data == data &&
+				event.Meta.(map[string]interface{})["code"] == code {
+				return
+			}
+		case <-timeout:
+			require.FailNow(t, "missing event", data)
+		}
+	}
+}
+
+func TestRenameAccount(t *testing.T) {
+	backend, template, _ := newDiscoveryBackend(t, nil)
+	code, err := backend.addAccountAtIndex(template, 1)
+	require.NoError(t, err)
+	require.Len(t, backend.Accounts(), 1)
+	account := backend.Accounts()[0]
+	require.Equal(t, "Bitcoin Testnet 2", account.Name())
+
+	require.Error(t, backend.RenameAccount(code, " "))
+	require.Error(t, backend.RenameAccount("unknown", "Savings"))
+
+	// The name can be read while the account is renamed.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = account.Name()
+		}
+	}()
+	require.NoError(t, backend.RenameAccount(code, " Savings "))
+	wg.Wait()
+	require.Equal(t, "Savings", account.Name())
+	require.Equal(t, "Savings", backend.config.AccountsConfig().Settings[code].Name)
+	requireAccountEvent(t, backend, "accountChanged", code)
+
+	// Default accounts are renamed in the settings.
+	require.NoError(t, backend.RenameAccount(template.code, "Main"))
+	require.Equal(t, "Main", backend.config.AccountsConfig().Settings[template.code].Name)
+}
+
+func TestHideAndRemoveAccount(t *testing.T) {
+	backend, template, _ := newDiscoveryBackend(t, nil)
+	code, err := backend.addAccountAtIndex(template, 1)
+	require.NoError(t, err)
+	otherCode, err := backend.addAccountAtIndex(template, 2)
+	require.NoError(t, err)
+	require.NoError(t, backend.RenameAccount(otherCode, "Savings"))
+	require.Empty(t, backend.HiddenAccounts())
+	accountsBefore := backend.Accounts()
+	require.Len(t, accountsBefore, 2)
+
+	// Default accounts can only be hidden.
+	require.Equal(t, ErrDefaultAccount, errp.Cause(backend.RemoveAccount(template.code)))
+	require.NoError(t, backend.SetAccountHidden(template.code, true))
+
+	require.NoError(t, backend.SetAccountHidden(otherCode, true))
+	requireAccountEvent(t, backend, "accountRemoved", otherCode)
+	require.Len(t, backend.Accounts(), 1)
+	require.Equal(t, code, backend.Accounts()[0].Code())
+	// The accounts returned before are not modified by the removal.
+	require.Equal(t, code, accountsBefore[0].Code())
+	require.Equal(t, otherCode, accountsBefore[1].Code())
+	require.Equal(t,
+		[]*HiddenAccount{
+			{CoinCode: coinTBTC, Code: template.code, Name: "Bitcoin Testnet"},
+			{CoinCode: coinTBTC, Code: otherCode, Name: "Savings"},
+		},
+		backend.HiddenAccounts())
+
+	// Removing an account also removes its settings.
+	require.NoError(t, backend.RemoveAccount(otherCode))
+	accountsConfig := backend.config.AccountsConfig()
+	require.Nil(t, accountsConfig.Lookup(otherCode))
+	_, ok := accountsConfig.Settings[otherCode]
+	require.False(t, ok)
+	require.Len(t, backend.HiddenAccounts(), 1)
+	require.Error(t, backend.RemoveAccount(otherCode))
+
+	require.NoError(t, backend.RemoveAccount(code))
+	requireAccountEvent(t, backend, "accountRemoved", code)
+	require.Empty(t, backend.Accounts())
+	require.Empty(t, backend.config.AccountsConfig().Accounts)
+}
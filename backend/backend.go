@@ -17,7 +17,6 @@ package backend
 import (
 	"errors"
 	"fmt"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -40,9 +39,11 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/rates"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	utilConfig "github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
@@ -120,6 +121,13 @@ type Backend struct {
 	events chan interface{}
 
 	notifier *Notifier
+	labels   *labels.Store
+
+	// keyring holds the key with which the stored data is encrypted, if the encryption is enabled.
+	keyring *encryption.Keyring
+	// storageKey is nil if the storage is not encrypted or still locked.
+	storageKey     *encryption.Key
+	storageKeyLock locker.Locker
 
 	devices            map[string]device.Interface
 	bitboxBases        map[string]bitboxbase.Interface
@@ -136,6 +144,18 @@ type Backend struct {
 
 	accounts     []accounts.Interface
 	accountsLock locker.Locker
+	// accountsSynced tracks the first sync of the loaded accounts by account code. Guarded by
+	// accountsLock.
+	accountsSynced map[string]*accountSync
+	// defaultAccounts are the active default accounts of the registered keystores, including the
+	// hidden ones. Further accounts of the same coin and script type are derived from them. Guarded
+	// by accountsLock.
+	defaultAccounts []accountTemplate
+	// discoveryLock makes sure only one account discovery runs at a time.
+	discoveryLock locker.Locker
+	// makeDiscoveryAccount is newDiscoveryAccount, replaced in the tests.
+	makeDiscoveryAccount func(
+		*accountTemplate, *signing.Configuration, string, func(accounts.Event)) (accounts.Interface, error)
 
 	baseManager *mdns.Manager
 
@@ -148,6 +168,13 @@ type Backend struct {
 // NewBackend creates a new backend with the given arguments.
 func NewBackend(arguments *arguments.Arguments, environment Environment) (*Backend, error) {
 	log := logging.Get().WithGroup("backend")
+	keyring, err := encryption.LoadKeyring(keyringFilename(arguments))
+	if err != nil {
+		return nil, err
+	}
+	if err := finishStorageMigration(arguments, keyring.KeyID()); err != nil {
+		return nil, err
+	}
 	config, err := config.NewConfig(arguments.AppConfigFilename(), arguments.AccountsConfigFilename())
 	if err != nil {
 		return nil, errp.WithStack(err)
@@ -163,18 +190,37 @@ func NewBackend(arguments *arguments.Arguments, environment Environment) (*Backe
 		keystores:   keystore.NewKeystores(),
 		coins:       map[string]coin.Coin{},
 		accounts:    []accounts.Interface{},
+		keyring:     keyring,
 		log:         log,
+
+		accountsSynced: map[string]*accountSync{},
 	}
-	notifier, err := NewNotifier(filepath.Join(arguments.MainDirectoryPath(), "notifier.db"))
+	backend.makeDiscoveryAccount = backend.newDiscoveryAccount
+	notifier, err := NewNotifier(notifierFilename(arguments))
 	if err != nil {
 		return nil, err
 	}
 	backend.notifier = notifier
+	backend.labels, err = labels.NewStore(labelsFilename(arguments))
+	if err != nil {
+		return nil, err
+	}
 	backend.socksProxy = socksproxy.NewSocksProxy(
 		backend.config.AppConfig().Backend.Proxy.UseProxy,
 		backend.config.AppConfig().Backend.Proxy.ProxyAddressOrDefault(),
 	)
-	backend.ratesUpdater = rates.NewRateUpdater(backend.socksProxy)
+	ratesProvider, err := rates.NewProvider(backend.config.AppConfig().Backend.RatesProvider)
+	if err != nil {
+		log.WithError(err).Error("Falling back to the default rates provider")
+		ratesProvider, _ = rates.NewProvider("")
+	}
+	backend.ratesUpdater = rates.NewRateUpdater(
+		backend.socksProxy,
+		ratesProvider,
+		ratesHistoryFilename(arguments),
+	)
+	backend.updateRatesCoins(nil)
+	backend.UpdateRatesFiats()
 	backend.baseManager = mdns.NewManager(backend.EmitBitBoxBaseDetected, backend.bitBoxBaseRegister, backend.BitBoxBaseDeregister, backend.config, backend.arguments.BitBoxBaseDirectoryPath(), backend.socksProxy)
 
 	backend.ratesUpdater.Observe(func(event observable.Event) { backend.events <- event })
@@ -184,10 +230,63 @@ func NewBackend(arguments *arguments.Arguments, environment Environment) (*Backe
 
 // addAccount adds the given account to the backend.
 func (backend *Backend) addAccount(account accounts.Interface) {
-	defer backend.accountsLock.Lock()()
+	unlock := backend.accountsLock.Lock()
 	backend.accounts = append(backend.accounts, account)
+	backend.updateRatesCoins(backend.accounts)
 	backend.onAccountInit(account)
-	backend.events <- backendEvent{Type: "backend", Data: "accountsStatusChanged"}
+	unlock()
+	// Emitted without holding the lock, as the events channel can block.
+	backend.events <- backendEvent{
+		Type: "backend",
+		Data: "accountAdded",
+		Meta: map[string]interface{}{"code": account.Code()},
+	}
+}
+
+// accountLoaded returns true if the account with the given code has been added to the backend.
+func (backend *Backend) accountLoaded(code string) bool {
+	defer backend.accountsLock.RLock()()
+	for _, account := range backend.accounts {
+		if account.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAccount closes the account with the given code and removes it from the backend. The
+// accounts config is not changed.
+func (backend *Backend) removeAccount(code string) {
+	removed := func() bool {
+		defer backend.accountsLock.Lock()()
+		for index, account := range backend.accounts {
+			if account.Code() != code {
+				continue
+			}
+			backend.onAccountUninit(account)
+			account.Close()
+			if synced, ok := backend.accountsSynced[code]; ok {
+				synced.close()
+				delete(backend.accountsSynced, code)
+			}
+			// A new slice, as the old one may still be iterated by callers of Accounts().
+			remaining := make([]accounts.Interface, 0, len(backend.accounts)-1)
+			remaining = append(remaining, backend.accounts[:index]...)
+			backend.accounts = append(remaining, backend.accounts[index+1:]...)
+			backend.updateRatesCoins(backend.accounts)
+			return true
+		}
+		return false
+	}()
+	if !removed {
+		return
+	}
+	// Emitted without holding the lock, as the events channel can block.
+	backend.events <- backendEvent{
+		Type: "backend",
+		Data: "accountRemoved",
+		Meta: map[string]interface{}{"code": code},
+	}
 }
 
 func (backend *Backend) notifyNewTxs(account accounts.Interface) {
@@ -214,13 +313,15 @@ func (backend *Backend) notifyNewTxs(account accounts.Interface) {
 }
 
 // CreateAndAddAccount creates an account with the given parameters and adds it to the backend. If
-// persist is true, the configuration is fetched and saved in the accounts configuration.
+// persist is true, the configuration is fetched and saved in the accounts configuration. If
+// watchOnly is true, the account is not signed by the registered keystores.
 func (backend *Backend) CreateAndAddAccount(
 	coin coin.Coin,
 	code string,
 	name string,
 	getSigningConfiguration func() (*signing.Configuration, error),
 	persist bool,
+	watchOnly bool,
 ) error {
 	if persist {
 		configuration, err := getSigningConfiguration()
@@ -238,15 +339,26 @@ func (backend *Backend) CreateAndAddAccount(
 			Code:          code,
 			Name:          name,
 			Configuration: configuration,
+			WatchOnly:     watchOnly,
 		})
+		// Adding the account explicitly undoes a previous removal.
+		accountsConfig.RemovedAccounts = removedAccountsExcept(
+			accountsConfig.RemovedAccounts, coin.Code(), configuration)
 		if err := backend.config.SetAccountsConfig(accountsConfig); err != nil {
 			return err
 		}
 	}
 
 	var account accounts.Interface
+	synced := newAccountSync()
+	unlock := backend.accountsLock.Lock()
+	backend.accountsSynced[code] = synced
+	unlock()
 	onEvent := func(event accounts.Event) {
 		backend.events <- AccountEvent{Type: "account", Code: code, Data: string(event)}
+		if event == accounts.EventSyncDone {
+			synced.close()
+		}
 		if account != nil && event == accounts.EventSyncDone {
 			backend.notifyNewTxs(account)
 		}
@@ -256,14 +368,23 @@ func (backend *Backend) CreateAndAddAccount(
 		return backend.notifier.ForAccount(fmt.Sprintf("%s-%s", configuration.Hash(), coin.Code()))
 	}
 
+	keystores := backend.keystores
+	if watchOnly {
+		// The keys of watch-only accounts are not in any keystore, so signing fails cleanly.
+		keystores = keystore.NewKeystores()
+	}
+
 	switch specificCoin := coin.(type) {
 	case *btc.Coin:
-		account = btc.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(), code, name,
-			getSigningConfiguration, backend.keystores, getNotifier, onEvent, backend.log, backend.ratesUpdater)
+		account = btc.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(),
+			backend.getStorageKey(), code, name,
+			getSigningConfiguration, keystores, getNotifier, backend.labels, onEvent, backend.log,
+			backend.ratesUpdater)
 		backend.addAccount(account)
 	case *eth.Coin:
-		account = eth.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(), code, name,
-			getSigningConfiguration, backend.keystores, getNotifier, onEvent, backend.log, backend.ratesUpdater)
+		account = eth.NewAccount(specificCoin, backend.arguments.CacheDirectoryPath(),
+			backend.getStorageKey(), code, name,
+			getSigningConfiguration, keystores, getNotifier, onEvent, backend.log, backend.ratesUpdater)
 		backend.addAccount(account)
 	default:
 		panic("unknown coin type")
@@ -279,9 +400,8 @@ func (backend *Backend) createAndAddAccount(
 	scriptType signing.ScriptType,
 ) {
 	log := backend.log.WithField("code", code).WithField("name", name)
-	prefix := "eth-erc20-"
-	if strings.HasPrefix(code, prefix) {
-		if !backend.config.AppConfig().Backend.ETH.ERC20TokenActive(code[len(prefix):]) {
+	if strings.HasPrefix(code, erc20CoinCodePrefix) {
+		if !backend.config.AppConfig().Backend.ETH.ERC20TokenActive(code[len(erc20CoinCodePrefix):]) {
 			log.WithField("name", name).Info("skipping inactive erc20 token")
 			return
 		}
@@ -314,7 +434,28 @@ func (backend *Backend) createAndAddAccount(
 	if backend.arguments.Multisig() {
 		name += " Multisig"
 	}
-	err = backend.CreateAndAddAccount(coin, code, name, getSigningConfiguration, false)
+	unlock := backend.accountsLock.Lock()
+	backend.defaultAccounts = append(backend.defaultAccounts, accountTemplate{
+		coin:       coin,
+		code:       code,
+		name:       name,
+		keypath:    absoluteKeypath,
+		scriptType: scriptType,
+	})
+	unlock()
+
+	settings := backend.config.AccountsConfig().Settings[code]
+	if settings.Hidden {
+		log.Info("skipping hidden account")
+		return
+	}
+	if backend.accountLoaded(code) {
+		return
+	}
+	if settings.Name != "" {
+		name = settings.Name
+	}
+	err = backend.CreateAndAddAccount(coin, code, name, getSigningConfiguration, false, false)
 	if err != nil {
 		panic(err)
 	}
@@ -434,26 +575,35 @@ func (backend *Backend) Coin(code string) (coin.Coin, error) {
 			panic(fmt.Sprintf("unknown eth transactions source: %s", source))
 		}
 	}
-	erc20Token := erc20TokenByCode(code)
+	erc20Token := backend.erc20TokenByCode(code)
 	switch {
 	case code == coinRBTC:
 		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinRBTC, "RBTC", &chaincfg.RegressionNetParams, dbFolder, servers, "", backend.socksProxy)
+		coin = btc.NewCoin(coinRBTC, "RBTC", &chaincfg.RegressionNetParams, dbFolder, servers,
+			backend.config.AppConfig().Backend.RBTC.BlockFilterPeer,
+			backend.config.AppConfig().Backend.RBTC.BitcoinCore,
+			backend.config.AppConfig().Backend.RBTC.WalletBirthday, "", backend.socksProxy)
 	case code == coinTBTC:
 		servers := backend.defaultElectrumXServers(code)
 		coin = btc.NewCoin(coinTBTC, "TBTC", &chaincfg.TestNet3Params, dbFolder, servers,
+			backend.config.AppConfig().Backend.TBTC.BlockFilterPeer,
+			backend.config.AppConfig().Backend.TBTC.BitcoinCore,
+			backend.config.AppConfig().Backend.TBTC.WalletBirthday,
 			"https://blockstream.info/testnet/tx/", backend.socksProxy)
 	case code == coinBTC:
 		servers := backend.defaultElectrumXServers(code)
 		coin = btc.NewCoin(coinBTC, "BTC", &chaincfg.MainNetParams, dbFolder, servers,
+			backend.config.AppConfig().Backend.BTC.BlockFilterPeer,
+			backend.config.AppConfig().Backend.BTC.BitcoinCore,
+			backend.config.AppConfig().Backend.BTC.WalletBirthday,
 			"https://blockstream.info/tx/", backend.socksProxy)
 	case code == coinTLTC:
 		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinTLTC, "TLTC", &ltc.TestNet4Params, dbFolder, servers,
+		coin = btc.NewCoin(coinTLTC, "TLTC", &ltc.TestNet4Params, dbFolder, servers, "", nil, 0,
 			"http://explorer.litecointools.com/tx/", backend.socksProxy)
 	case code == coinLTC:
 		servers := backend.defaultElectrumXServers(code)
-		coin = btc.NewCoin(coinLTC, "LTC", &ltc.MainNetParams, dbFolder, servers,
+		coin = btc.NewCoin(coinLTC, "LTC", &ltc.MainNetParams, dbFolder, servers, "", nil, 0,
 			"https://insight.litecore.io/tx/", backend.socksProxy)
 	case code == coinETH:
 		coinConfig := backend.config.AppConfig().Backend.ETH
@@ -507,13 +657,17 @@ func (backend *Backend) Coin(code string) (coin.Coin, error) {
 			coinConfig.TransactionsSource,
 			eth.TransactionsSourceEtherScan("https://api.etherscan.io/api", backend.socksProxy),
 		)
-		coin = eth.NewCoin(erc20Token.code, erc20Token.unit, "ETH", params.MainnetChainConfig,
+		ethCoin := eth.NewCoin(code, erc20Token.Unit, "ETH", params.MainnetChainConfig,
 			"https://etherscan.io/tx/",
 			transactionsSource,
 			coinConfig.NodeURL,
-			erc20Token.token,
+			erc20.NewToken(erc20Token.ContractAddress, erc20Token.Decimals),
 			backend.socksProxy,
 		)
+		if erc20Token.UserAdded {
+			ethCoin = ethCoin.WithoutRates()
+		}
+		coin = ethCoin
 	default:
 		return nil, errp.Newf("unknown coin code %s", code)
 	}
@@ -523,13 +677,25 @@ func (backend *Backend) Coin(code string) (coin.Coin, error) {
 }
 
 func (backend *Backend) initPersistedAccounts() {
-	for _, account := range backend.config.AccountsConfig().Accounts {
+	if backend.storageLocked() {
+		backend.log.Info("Not loading the accounts, the storage is locked")
+		return
+	}
+	accountsConfig := backend.config.AccountsConfig()
+	for _, account := range accountsConfig.Accounts {
 		account := account
 		if _, isTestnet := testnetCoins[account.CoinCode]; isTestnet != backend.Testing() {
 			// Don't load testnet accounts when running normally, nor mainnet accounts when running
 			// in testing mode
 			continue
 		}
+		settings := accountsConfig.Settings[account.Code]
+		if settings.Hidden || backend.accountLoaded(account.Code) {
+			continue
+		}
+		if settings.Name != "" {
+			account.Name = settings.Name
+		}
 		coin, err := backend.Coin(account.CoinCode)
 		if err != nil {
 			backend.log.Errorf("skipping persisted account %s/%s, could not find coin",
@@ -539,7 +705,8 @@ func (backend *Backend) initPersistedAccounts() {
 		getSigningConfiguration := func() (*signing.Configuration, error) {
 			return account.Configuration, nil
 		}
-		err = backend.CreateAndAddAccount(coin, account.Code, account.Name, getSigningConfiguration, false)
+		err = backend.CreateAndAddAccount(
+			coin, account.Code, account.Name, getSigningConfiguration, false, account.WatchOnly)
 		if err != nil {
 			panic(err)
 		}
@@ -549,6 +716,9 @@ func (backend *Backend) initPersistedAccounts() {
 // initDefaultAccounts creates a bunch of default accounts for a set of keystores (not manually
 // user-added). Currently the first bip44 account for all supported and active account types.
 func (backend *Backend) initDefaultAccounts() {
+	unlock := backend.accountsLock.Lock()
+	backend.defaultAccounts = nil
+	unlock()
 	if backend.keystores.Count() == 0 {
 		return
 	}
@@ -558,15 +728,25 @@ func (backend *Backend) initDefaultAccounts() {
 			TBTC, _ := backend.Coin(coinTBTC)
 			backend.createAndAddAccount(TBTC, "tbtc-multisig", "Bitcoin Testnet", "m/48'/1'/0'",
 				signing.ScriptTypeP2PKH)
+			backend.createAndAddAccount(TBTC, "tbtc-multisig-p2wsh-p2sh", "Bitcoin Testnet Segwit",
+				"m/48'/1'/0'/1'", signing.ScriptTypeP2WSHP2SH)
+			backend.createAndAddAccount(TBTC, "tbtc-multisig-p2wsh", "Bitcoin Testnet: bech32",
+				"m/48'/1'/0'/2'", signing.ScriptTypeP2WSH)
 			TLTC, _ := backend.Coin(coinTLTC)
 			backend.createAndAddAccount(TLTC, "tltc-multisig", "Litecoin Testnet", "m/48'/1'/0'",
 				signing.ScriptTypeP2PKH)
+			backend.createAndAddAccount(TLTC, "tltc-multisig-p2wsh-p2sh", "Litecoin Testnet Segwit",
+				"m/48'/1'/0'/1'", signing.ScriptTypeP2WSHP2SH)
+			backend.createAndAddAccount(TLTC, "tltc-multisig-p2wsh", "Litecoin Testnet: bech32",
+				"m/48'/1'/0'/2'", signing.ScriptTypeP2WSH)
 		case backend.arguments.Regtest():
 			RBTC, _ := backend.Coin(coinRBTC)
 			backend.createAndAddAccount(RBTC, "rbtc-p2pkh", "Bitcoin Regtest Legacy", "m/44'/1'/0'",
 				signing.ScriptTypeP2PKH)
 			backend.createAndAddAccount(RBTC, "rbtc-p2wpkh-p2sh", "Bitcoin Regtest Segwit", "m/49'/1'/0'",
 				signing.ScriptTypeP2WPKHP2SH)
+			backend.createAndAddAccount(RBTC, "rbtc-p2tr", "Bitcoin Regtest Taproot", "m/86'/1'/0'",
+				signing.ScriptTypeP2TR)
 		default:
 			TBTC, _ := backend.Coin(coinTBTC)
 			backend.createAndAddAccount(TBTC, "tbtc-p2wpkh-p2sh", "Bitcoin Testnet", "m/49'/1'/0'",
@@ -575,6 +755,8 @@ func (backend *Backend) initDefaultAccounts() {
 				signing.ScriptTypeP2WPKH)
 			backend.createAndAddAccount(TBTC, "tbtc-p2pkh", "Bitcoin Testnet Legacy", "m/44'/1'/0'",
 				signing.ScriptTypeP2PKH)
+			backend.createAndAddAccount(TBTC, "tbtc-p2tr", "Bitcoin Testnet: taproot", "m/86'/1'/0'",
+				signing.ScriptTypeP2TR)
 
 			TLTC, _ := backend.Coin(coinTLTC)
 			backend.createAndAddAccount(TLTC, "tltc-p2wpkh-p2sh", "Litecoin Testnet", "m/49'/1'/0'",
@@ -594,9 +776,17 @@ func (backend *Backend) initDefaultAccounts() {
 			BTC, _ := backend.Coin(coinBTC)
 			backend.createAndAddAccount(BTC, "btc-multisig", "Bitcoin", "m/48'/0'/0'",
 				signing.ScriptTypeP2PKH)
+			backend.createAndAddAccount(BTC, "btc-multisig-p2wsh-p2sh", "Bitcoin Segwit", "m/48'/0'/0'/1'",
+				signing.ScriptTypeP2WSHP2SH)
+			backend.createAndAddAccount(BTC, "btc-multisig-p2wsh", "Bitcoin: bech32", "m/48'/0'/0'/2'",
+				signing.ScriptTypeP2WSH)
 			LTC, _ := backend.Coin(coinLTC)
 			backend.createAndAddAccount(LTC, "ltc-multisig", "Litecoin", "m/48'/2'/0'",
 				signing.ScriptTypeP2PKH)
+			backend.createAndAddAccount(LTC, "ltc-multisig-p2wsh-p2sh", "Litecoin Segwit", "m/48'/2'/0'/1'",
+				signing.ScriptTypeP2WSHP2SH)
+			backend.createAndAddAccount(LTC, "ltc-multisig-p2wsh", "Litecoin: bech32", "m/48'/2'/0'/2'",
+				signing.ScriptTypeP2WSH)
 		} else {
 			BTC, _ := backend.Coin(coinBTC)
 			backend.createAndAddAccount(BTC, "btc-p2wpkh-p2sh", "Bitcoin", "m/49'/0'/0'",
@@ -605,6 +795,8 @@ func (backend *Backend) initDefaultAccounts() {
 				signing.ScriptTypeP2WPKH)
 			backend.createAndAddAccount(BTC, "btc-p2pkh", "Bitcoin Legacy", "m/44'/0'/0'",
 				signing.ScriptTypeP2PKH)
+			backend.createAndAddAccount(BTC, "btc-p2tr", "Bitcoin: taproot", "m/86'/0'/0'",
+				signing.ScriptTypeP2TR)
 
 			LTC, _ := backend.Coin(coinLTC)
 			backend.createAndAddAccount(LTC, "ltc-p2wpkh-p2sh", "Litecoin", "m/49'/2'/0'",
@@ -618,9 +810,10 @@ func (backend *Backend) initDefaultAccounts() {
 			backend.createAndAddAccount(ETH, ethAccountCode, "Ethereum BETA", "m/44'/60'/0'/0/0", signing.ScriptTypeP2WPKH)
 
 			if backend.config.AppConfig().Backend.AccountActive(ethAccountCode) {
-				for _, erc20Token := range erc20Tokens {
-					token, _ := backend.Coin(erc20Token.code)
-					backend.createAndAddAccount(token, erc20Token.code, erc20Token.name, "m/44'/60'/0'/0/0", signing.ScriptTypeP2WPKH)
+				for _, erc20Token := range backend.ERC20Tokens() {
+					code := erc20CoinCodePrefix + erc20Token.Code
+					token, _ := backend.Coin(code)
+					backend.createAndAddAccount(token, code, erc20Token.Name+" BETA", "m/44'/60'/0'/0/0", signing.ScriptTypeP2WPKH)
 				}
 			}
 		}
@@ -631,20 +824,50 @@ func (backend *Backend) initAccounts() {
 	// Since initAccounts replaces all previous accounts, we need to properly close them first.
 	backend.uninitAccounts()
 
+	if backend.storageLocked() {
+		backend.log.Info("Not loading the accounts, the storage is locked")
+		return
+	}
 	backend.initDefaultAccounts()
 	backend.initPersistedAccounts()
 }
 
-// ReinitializeAccounts uninits and then reinits all accounts. This is useful to reload the accounts
-// if the configuration changed (e.g. which accounts are active). This is a stopgap measure until
-// accounts can be added and removed individually.
-func (backend *Backend) ReinitializeAccounts() {
-	backend.log.Info("Reinitializing accounts")
-	backend.initAccounts()
+// UpdateAccounts adds the accounts which became active or visible and removes the ones which became
+// inactive or hidden, e.g. after the settings changed. All other accounts are left untouched, so
+// they don't need to be synced again.
+func (backend *Backend) UpdateAccounts() {
+	if backend.storageLocked() {
+		return
+	}
+	backend.log.Info("Updating accounts")
+	backend.initDefaultAccounts()
+	backend.initPersistedAccounts()
+
+	wanted := map[string]struct{}{}
+	accountsConfig := backend.config.AccountsConfig()
+	unlock := backend.accountsLock.RLock()
+	for _, defaultAccount := range backend.defaultAccounts {
+		wanted[defaultAccount.code] = struct{}{}
+	}
+	for _, account := range accountsConfig.Accounts {
+		wanted[account.Code] = struct{}{}
+	}
+	unwanted := []string{}
+	for _, account := range backend.accounts {
+		_, ok := wanted[account.Code()]
+		if !ok || accountsConfig.Settings[account.Code()].Hidden {
+			unwanted = append(unwanted, account.Code())
+		}
+	}
+	unlock()
+	for _, code := range unwanted {
+		backend.removeAccount(code)
+	}
 }
 
 // AccountsStatus returns whether the accounts have been initialized.
 func (backend *Backend) AccountsStatus() string {
+	defer backend.accountsLock.RLock()()
 	if len(backend.accounts) > 0 {
 		return "initialized"
 	}
@@ -656,9 +879,10 @@ func (backend *Backend) Testing() bool {
 	return backend.arguments.Testing()
 }
 
-// Accounts returns the current accounts of the backend.
+// Accounts returns a copy of the current accounts of the backend.
 func (backend *Backend) Accounts() []accounts.Interface {
-	return backend.accounts
+	defer backend.accountsLock.RLock()()
+	return append([]accounts.Interface{}, backend.accounts...)
 }
 
 // UserLanguage returns the language the UI should be presented in to the user.
@@ -790,7 +1014,12 @@ func (backend *Backend) uninitAccounts() {
 		backend.onAccountUninit(account)
 		account.Close()
 	}
+	for _, synced := range backend.accountsSynced {
+		synced.close()
+	}
 	backend.accounts = []accounts.Interface{}
+	backend.accountsSynced = map[string]*accountSync{}
+	backend.defaultAccounts = nil
 	backend.events <- backendEvent{Type: "backend", Data: "accountsStatusChanged"}
 }
 
@@ -808,7 +1037,11 @@ func (backend *Backend) RegisterKeystore(keystore keystore.Keystore) {
 	if backend.arguments.Multisig() && backend.keystores.Count() != 2 {
 		return
 	}
+	backend.unlockStorageWithKeystore()
 	backend.initAccounts()
+	if !backend.storageLocked() {
+		go backend.discoverAccounts()
+	}
 }
 
 // DeregisterKeystore removes the registered keystore.
@@ -888,6 +1121,11 @@ func (backend *Backend) RatesUpdater() *rates.RateUpdater {
 	return backend.ratesUpdater
 }
 
+// Labels returns the store of the user defined labels of transactions, outputs and addresses.
+func (backend *Backend) Labels() *labels.Store {
+	return backend.labels
+}
+
 // DownloadCert downloads the first element of the remote certificate chain.
 func (backend *Backend) DownloadCert(server string) (string, error) {
 	return electrum.DownloadCert(server, backend.socksProxy)
@@ -34,8 +34,10 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/rates"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/sirupsen/logrus"
@@ -52,8 +54,10 @@ type Account struct {
 
 	coin                    *Coin
 	dbFolder                string
+	dbEncryptionKey         *encryption.Key
 	code                    string
 	name                    string
+	nameLock                locker.Locker
 	db                      transactions.DBInterface
 	getSigningConfiguration func() (*signing.Configuration, error)
 	signingConfiguration    *signing.Configuration
@@ -61,6 +65,8 @@ type Account struct {
 	getNotifier             func(*signing.Configuration) accounts.Notifier
 	notifier                accounts.Notifier
 	blockchain              blockchain.Interface
+	// labels persists the frozen outputs.
+	labels *labels.Store
 
 	receiveAddresses AddressChain
 	changeAddresses  AddressChain
@@ -104,11 +110,13 @@ const (
 func NewAccount(
 	coin *Coin,
 	dbFolder string,
+	dbEncryptionKey *encryption.Key,
 	code string,
 	name string,
 	getSigningConfiguration func() (*signing.Configuration, error),
 	keystores *keystore.Keystores,
 	getNotifier func(*signing.Configuration) accounts.Notifier,
+	labels *labels.Store,
 	onEvent func(accounts.Event),
 	log *logrus.Entry,
 	rateUpdater *rates.RateUpdater,
@@ -120,12 +128,14 @@ func NewAccount(
 	account := &Account{
 		coin:                    coin,
 		dbFolder:                dbFolder,
+		dbEncryptionKey:         dbEncryptionKey,
 		code:                    code,
 		name:                    name,
 		getSigningConfiguration: getSigningConfiguration,
 		signingConfiguration:    nil,
 		keystores:               keystores,
 		getNotifier:             getNotifier,
+		labels:                  labels,
 
 		// feeTargets must be sorted by ascending priority.
 		feeTargets: []*FeeTarget{
@@ -167,9 +177,16 @@ func (account *Account) Code() string {
 
 // Name returns the name of the account.
 func (account *Account) Name() string {
+	defer account.nameLock.RLock()()
 	return account.name
 }
 
+// SetName changes the name of the account.
+func (account *Account) SetName(name string) {
+	defer account.nameLock.Lock()()
+	account.name = name
+}
+
 // Coin returns the coin of the account.
 func (account *Account) Coin() coin.Coin {
 	return account.coin
@@ -200,7 +217,7 @@ func (account *Account) Initialize() error {
 	}
 	dbName := fmt.Sprintf("account-%s-%s.db", account.signingConfiguration.Hash(), account.code)
 	account.log.Debugf("Opening the database '%s' to persist the transactions.", dbName)
-	db, err := transactionsdb.NewDB(path.Join(account.dbFolder, dbName))
+	db, err := transactionsdb.NewDB(path.Join(account.dbFolder, dbName), account.dbEncryptionKey)
 	if err != nil {
 		return err
 	}
@@ -240,7 +257,7 @@ func (account *Account) Initialize() error {
 	})
 	account.transactions = transactions.NewTransactions(
 		account.coin.Net(), account.db, theHeaders, account.synchronizer,
-		account.blockchain, account.notifier, account.log)
+		account.blockchain, account.notifier, account.onEvent, account.log)
 
 	fixGapLimit := gapLimit
 	fixChangeGapLimit := changeGapLimit
@@ -286,6 +303,8 @@ func XPubVersionForScriptType(coin *Coin, scriptType signing.ScriptType) [4]byte
 			signing.ScriptTypeP2PKH:      {0x04, 0x88, 0xb2, 0x1e}, // xpub
 			signing.ScriptTypeP2WPKHP2SH: {0x04, 0x9d, 0x7c, 0xb2}, // ypub
 			signing.ScriptTypeP2WPKH:     {0x04, 0xb2, 0x47, 0x46}, // zpub
+			signing.ScriptTypeP2WSHP2SH:  {0x02, 0x95, 0xb4, 0x3f}, // Ypub
+			signing.ScriptTypeP2WSH:      {0x02, 0xaa, 0x7e, 0xd3}, // Zpub
 		}
 		version, ok := versions[scriptType]
 		if !ok {
@@ -301,6 +320,81 @@ func XPubVersionForScriptType(coin *Coin, scriptType signing.ScriptType) [4]byte
 	}
 }
 
+// Descriptor returns the output descriptor of the account's addresses, see
+// signing.Configuration.Descriptor().
+func (account *Account) Descriptor() (string, error) {
+	return account.signingConfiguration.Descriptor(account.coin.Net())
+}
+
+// testnetXPubVersions are the SLIP-132 version bytes of segwit testnet keys. The app exports all
+// testnet keys as tpub, but other wallets use these.
+var testnetXPubVersions = map[[4]byte]signing.ScriptType{
+	{0x04, 0x4a, 0x52, 0x62}: signing.ScriptTypeP2WPKHP2SH, // upub
+	{0x04, 0x5f, 0x1c, 0xf6}: signing.ScriptTypeP2WPKH,     // vpub
+}
+
+// testnetMultisigXPubVersions are the SLIP-132 version bytes of the keys of segwit testnet multisig
+// cosigners. They are not used to infer the script type of single-key accounts.
+var testnetMultisigXPubVersions = map[[4]byte]signing.ScriptType{
+	{0x02, 0x42, 0x89, 0xef}: signing.ScriptTypeP2WSHP2SH, // Upub
+	{0x02, 0x57, 0x54, 0x83}: signing.ScriptTypeP2WSH,     // Vpub
+}
+
+// isTestnet returns whether the coin is a Bitcoin or Litecoin testnet coin.
+func isTestnet(coin *Coin) bool {
+	net := coin.Net().Net
+	return net == chaincfg.TestNet3Params.Net || net == ltc.TestNet4Params.Net
+}
+
+// XPubMatchesScriptType returns whether the version bytes of the extended public key belong to the
+// network of the coin and do not contradict the script type. On testnet, the SLIP-132 versions of
+// testnetXPubVersions and testnetMultisigXPubVersions are accepted in addition to
+// XPubVersionForScriptType().
+func XPubMatchesScriptType(coin *Coin, xpub *hdkeychain.ExtendedKey, scriptType signing.ScriptType) bool {
+	if xpub.IsForNet(&chaincfg.Params{HDPublicKeyID: XPubVersionForScriptType(coin, scriptType)}) {
+		return true
+	}
+	if !isTestnet(coin) {
+		return false
+	}
+	for _, versions := range []map[[4]byte]signing.ScriptType{testnetXPubVersions, testnetMultisigXPubVersions} {
+		for version, versionScriptType := range versions {
+			if versionScriptType == scriptType && xpub.IsForNet(&chaincfg.Params{HDPublicKeyID: version}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ScriptTypeForXPub infers the script type from the SLIP-132 version bytes of the extended public
+// key, see XPubVersionForScriptType(). false is returned if the version bytes are unknown or shared
+// by multiple script types, as is the case for tpub keys.
+func ScriptTypeForXPub(coin *Coin, xpub *hdkeychain.ExtendedKey) (signing.ScriptType, bool) {
+	if isTestnet(coin) {
+		for version, scriptType := range testnetXPubVersions {
+			if xpub.IsForNet(&chaincfg.Params{HDPublicKeyID: version}) {
+				return scriptType, true
+			}
+		}
+	}
+	matches := []signing.ScriptType{}
+	for _, scriptType := range []signing.ScriptType{
+		signing.ScriptTypeP2PKH,
+		signing.ScriptTypeP2WPKHP2SH,
+		signing.ScriptTypeP2WPKH,
+	} {
+		net := &chaincfg.Params{HDPublicKeyID: XPubVersionForScriptType(coin, scriptType)}
+		if xpub.IsForNet(net) {
+			matches = append(matches, scriptType)
+		}
+	}
+	if len(matches) != 1 {
+		return "", false
+	}
+	return matches[0], true
+}
+
 // Info returns account info, such as the signing configuration (xpubs).
 func (account *Account) Info() *accounts.Info {
 	// The internal extended key representation always uses he same version bytes (prefix xpub). We
@@ -330,7 +424,7 @@ func (account *Account) Info() *accounts.Info {
 			xpubs,
 			account.signingConfiguration.Address(),
 			account.signingConfiguration.SigningThreshold(),
-		),
+		).WithKeyOrigins(account.signingConfiguration.KeyOrigins()),
 	}
 }
 
@@ -452,7 +546,22 @@ func (account *Account) Balance() (*accounts.Balance, error) {
 	if account.fatalError {
 		return nil, errp.New("can't call Balance() after a fatal error")
 	}
-	return account.transactions.Balance(), nil
+	return account.transactions.Balance(account.isFrozen), nil
+}
+
+// isFrozen returns true if the user marked the output as not to be spent.
+func (account *Account) isFrozen(outPoint wire.OutPoint) bool {
+	return !account.labels.Spendable(outPoint.String())
+}
+
+// SetOutputFrozen freezes or unfreezes an output. Frozen outputs are not used in new transactions
+// and not included in the available balance. The setting is persisted.
+func (account *Account) SetOutputFrozen(outPoint wire.OutPoint, frozen bool) error {
+	if err := account.labels.SetSpendable(outPoint.String(), !frozen); err != nil {
+		return err
+	}
+	account.onEvent(accounts.EventFrozenOutputsChanged)
+	return nil
 }
 
 func (account *Account) addresses(change bool) AddressChain {
@@ -541,6 +650,24 @@ func (account *Account) ensureAddresses() {
 	}
 }
 
+// putScanState persists the scan state of the address, so that the blocks are not scanned again
+// after a restart.
+func (account *Account) putScanState(scriptHashHex blockchain.ScriptHashHex, scanState *blockchain.ScanState) {
+	dbTx, err := account.db.Begin()
+	if err != nil {
+		account.log.WithError(err).Error("Could not persist the scan state")
+		return
+	}
+	defer dbTx.Rollback()
+	if err := dbTx.PutScanState(scriptHashHex, scanState); err != nil {
+		account.log.WithError(err).Error("Could not persist the scan state")
+		return
+	}
+	if err := dbTx.Commit(); err != nil {
+		account.log.WithError(err).Error("Could not persist the scan state")
+	}
+}
+
 func (account *Account) subscribeAddress(
 	dbTx transactions.DBTxInterface, address *addresses.AccountAddress) error {
 	addressHistory, err := dbTx.AddressHistory(address.PubkeyScriptHashHex())
@@ -549,6 +676,15 @@ func (account *Account) subscribeAddress(
 	}
 	address.HistoryStatus = addressHistory.Status()
 
+	if registry, ok := account.blockchain.(blockchain.ScriptRegistry); ok {
+		scanState, err := dbTx.ScanState(address.PubkeyScriptHashHex())
+		if err != nil {
+			return err
+		}
+		registry.RegisterScript(address.PubkeyScript(), scanState, func(scanState *blockchain.ScanState) {
+			account.putScanState(address.PubkeyScriptHashHex(), scanState)
+		})
+	}
 	account.blockchain.ScriptHashSubscribe(
 		func() func(error) {
 			done := account.synchronizer.IncRequestsCounter()
@@ -671,12 +807,25 @@ type SpendableOutput struct {
 	OutPoint wire.OutPoint
 }
 
-// SpendableOutputs returns the utxo set, sorted by the value descending.
+// SpendableOutputs returns the utxo set without the frozen outputs, sorted by the value
+// descending.
 func (account *Account) SpendableOutputs() []*SpendableOutput {
+	return account.outputs(false)
+}
+
+// FrozenOutputs returns the unspent outputs which are frozen, sorted by the value descending.
+func (account *Account) FrozenOutputs() []*SpendableOutput {
+	return account.outputs(true)
+}
+
+func (account *Account) outputs(frozen bool) []*SpendableOutput {
 	account.synchronizer.WaitSynchronized()
 	defer account.RLock()()
 	result := []*SpendableOutput{}
 	for outPoint, txOut := range account.transactions.SpendableOutputs() {
+		if account.isFrozen(outPoint) != frozen || txOut.IsIncoming() {
+			continue
+		}
 		result = append(result, &SpendableOutput{OutPoint: outPoint, SpendableOutput: txOut})
 	}
 	sort.Sort(sort.Reverse(&byValue{result}))
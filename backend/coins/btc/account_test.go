@@ -0,0 +1,80 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptTypeForXPub(t *testing.T) {
+	btcCoin := btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+	tbtcCoin := btc.NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+	tltcCoin := btc.NewCoin("tltc", "TLTC", &ltc.TestNet4Params, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	// withVersion returns the public key of master encoded with the given version bytes.
+	withVersion := func(version [4]byte) *hdkeychain.ExtendedKey {
+		xpub, err := master.Neuter()
+		require.NoError(t, err)
+		xpub.SetNet(&chaincfg.Params{HDPublicKeyID: version})
+		parsed, err := hdkeychain.NewKeyFromString(xpub.String())
+		require.NoError(t, err)
+		return parsed
+	}
+
+	for _, test := range []struct {
+		name               string
+		coin               *btc.Coin
+		version            [4]byte
+		expectedScriptType signing.ScriptType
+		expectedOK         bool
+	}{
+		{"xpub", btcCoin, [4]byte{0x04, 0x88, 0xb2, 0x1e}, signing.ScriptTypeP2PKH, true},
+		{"ypub", btcCoin, [4]byte{0x04, 0x9d, 0x7c, 0xb2}, signing.ScriptTypeP2WPKHP2SH, true},
+		{"zpub", btcCoin, [4]byte{0x04, 0xb2, 0x47, 0x46}, signing.ScriptTypeP2WPKH, true},
+		// tpub is used for all script types.
+		{"tpub", tbtcCoin, [4]byte{0x04, 0x35, 0x87, 0xcf}, "", false},
+		{"upub", tbtcCoin, [4]byte{0x04, 0x4a, 0x52, 0x62}, signing.ScriptTypeP2WPKHP2SH, true},
+		{"vpub", tbtcCoin, [4]byte{0x04, 0x5f, 0x1c, 0xf6}, signing.ScriptTypeP2WPKH, true},
+		{"ttub", tltcCoin, ltc.TestNet4Params.HDPublicKeyID, "", false},
+		{"vpub on ltc testnet", tltcCoin, [4]byte{0x04, 0x5f, 0x1c, 0xf6}, signing.ScriptTypeP2WPKH, true},
+		// Keys of the wrong network.
+		{"tpub on mainnet", btcCoin, [4]byte{0x04, 0x35, 0x87, 0xcf}, "", false},
+		{"vpub on mainnet", btcCoin, [4]byte{0x04, 0x5f, 0x1c, 0xf6}, "", false},
+		{"zpub on testnet", tbtcCoin, [4]byte{0x04, 0xb2, 0x47, 0x46}, "", false},
+		// Multisig keys don't identify a single sig script type.
+		{"Zpub", btcCoin, [4]byte{0x02, 0xaa, 0x7e, 0xd3}, "", false},
+		{"unknown", btcCoin, [4]byte{0x01, 0x02, 0x03, 0x04}, "", false},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			scriptType, ok := btc.ScriptTypeForXPub(test.coin, withVersion(test.version))
+			require.Equal(t, test.expectedOK, ok)
+			require.Equal(t, test.expectedScriptType, scriptType)
+		})
+	}
+}
@@ -15,6 +15,7 @@
 package addresses
 
 import (
+	"crypto/sha256"
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec"
@@ -24,6 +25,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/sirupsen/logrus"
 )
@@ -47,6 +49,8 @@ type AccountAddress struct {
 
 	// redeemScript stores the redeem script of a BIP16 P2SH output or nil if address type is P2PKH.
 	redeemScript []byte
+	// witnessScript stores the multisig script of a P2WSH or P2WSH-P2SH output, nil otherwise.
+	witnessScript []byte
 
 	log *logrus.Entry
 }
@@ -61,6 +65,7 @@ func NewAccountAddress(
 
 	var address btcutil.Address
 	var redeemScript []byte
+	var witnessScript []byte
 	configuration, err := accountConfiguration.Derive(keyPath)
 	if err != nil {
 		log.WithError(err).Panic("Failed to derive the configuration.")
@@ -74,6 +79,9 @@ func NewAccountAddress(
 	switch {
 	case configuration.IsAddressBased():
 		address, err = btcutil.DecodeAddress(configuration.Address(), net)
+		if err != nil {
+			address, err = taproot.DecodeAddress(configuration.Address(), net)
+		}
 		if err != nil {
 			log.WithError(err).Panic("invalid address")
 		}
@@ -86,13 +94,38 @@ func NewAccountAddress(
 				log.WithError(err).Panic("Failed to get a P2PK address from a public key.")
 			}
 		}
-		redeemScript, err = txscript.MultiSigScript(addresses, configuration.SigningThreshold())
+		multisigScript, err := txscript.MultiSigScript(addresses, configuration.SigningThreshold())
 		if err != nil {
 			log.WithError(err).Panic("Failed to get the redeem script for multisig.")
 		}
-		address, err = btcutil.NewAddressScriptHash(redeemScript, net)
-		if err != nil {
-			log.WithError(err).Panic("Failed to get a P2SH address for multisig.")
+		switch configuration.ScriptType() {
+		case signing.ScriptTypeP2PKH:
+			redeemScript = multisigScript
+			address, err = btcutil.NewAddressScriptHash(redeemScript, net)
+			if err != nil {
+				log.WithError(err).Panic("Failed to get a P2SH address for multisig.")
+			}
+		case signing.ScriptTypeP2WSHP2SH, signing.ScriptTypeP2WSH:
+			witnessScript = multisigScript
+			scriptHash := sha256.Sum256(witnessScript)
+			var segwitAddress *btcutil.AddressWitnessScriptHash
+			segwitAddress, err = btcutil.NewAddressWitnessScriptHash(scriptHash[:], net)
+			if err != nil {
+				log.WithError(err).Panic("Failed to get a P2WSH address for multisig.")
+			}
+			address = segwitAddress
+			if configuration.ScriptType() == signing.ScriptTypeP2WSHP2SH {
+				redeemScript, err = txscript.PayToAddrScript(segwitAddress)
+				if err != nil {
+					log.WithError(err).Panic("Failed to get redeem script for P2WSH address.")
+				}
+				address, err = btcutil.NewAddressScriptHash(redeemScript, net)
+				if err != nil {
+					log.WithError(err).Panic("Failed to get a P2SH address for P2WSH multisig.")
+				}
+			}
+		default:
+			log.Panic(fmt.Sprintf("Unrecognized multisig script type: %s", configuration.ScriptType()))
 		}
 	default:
 		publicKeyHash := btcutil.Hash160(configuration.PublicKeys()[0].SerializeCompressed())
@@ -121,6 +154,16 @@ func NewAccountAddress(
 			if err != nil {
 				log.WithError(err).Panic("Failed to get p2wpkh addr. from publ. key hash.")
 			}
+		case signing.ScriptTypeP2TR:
+			var outputKey []byte
+			outputKey, err = taproot.TweakPublicKey(configuration.PublicKeys()[0])
+			if err != nil {
+				log.WithError(err).Panic("Failed to get the taproot output key.")
+			}
+			address, err = taproot.NewAddressTaproot(outputKey, net)
+			if err != nil {
+				log.WithError(err).Panic("Failed to get p2tr addr. from output key.")
+			}
 		default:
 			log.Panic(fmt.Sprintf("Unrecognized script type: %s", configuration.ScriptType()))
 		}
@@ -132,6 +175,7 @@ func NewAccountAddress(
 		Configuration:        configuration,
 		HistoryStatus:        "",
 		redeemScript:         redeemScript,
+		witnessScript:        witnessScript,
 		log:                  log,
 	}
 }
@@ -152,7 +196,7 @@ func (address *AccountAddress) isUsed() bool {
 
 // PubkeyScript returns the pubkey script of this address. Use this in a tx output to receive funds.
 func (address *AccountAddress) PubkeyScript() []byte {
-	script, err := txscript.PayToAddrScript(address.Address)
+	script, err := taproot.PayToAddrScript(address.Address)
 	if err != nil {
 		address.log.WithError(err).Panic("Failed to get the pubkey script for an address.")
 	}
@@ -170,6 +214,9 @@ func (address *AccountAddress) PubkeyScriptHashHex() blockchain.ScriptHashHex {
 // from this address.
 func (address *AccountAddress) ScriptForHashToSign() (bool, []byte) {
 	if address.Configuration.Multisig() {
+		if address.witnessScript != nil {
+			return true, address.witnessScript
+		}
 		return false, address.redeemScript
 	}
 	switch address.Configuration.ScriptType() {
@@ -179,12 +226,19 @@ func (address *AccountAddress) ScriptForHashToSign() (bool, []byte) {
 		return true, address.redeemScript
 	case signing.ScriptTypeP2WPKH:
 		return true, address.PubkeyScript()
+	case signing.ScriptTypeP2TR:
+		address.log.Panic("Taproot inputs are signed with the BIP341 signature hash.")
 	default:
 		address.log.Panic("Unrecognized address type.")
 	}
 	panic("The end of the function cannot be reached.")
 }
 
+// RedeemScript returns the redeem script of a P2SH output, or nil if the address is not P2SH.
+func (address *AccountAddress) RedeemScript() []byte {
+	return address.redeemScript
+}
+
 func index(publicKey *btcec.PublicKey, sortedPublicKeys []*btcec.PublicKey) int {
 	for index, sortedPublicKey := range sortedPublicKeys {
 		if sortedPublicKey.IsEqual(publicKey) {
@@ -195,7 +249,9 @@ func index(publicKey *btcec.PublicKey, sortedPublicKeys []*btcec.PublicKey) int
 }
 
 // SignatureScript returns the signature script (and witness) needed to spend from this address.
-// The signatures have to be provided in the order of the configuration (and some can be nil).
+// The signatures have to be provided in the order of the configuration (and some can be nil). For
+// multisig addresses, only the first signatures up to the signing threshold are used in the order
+// of the sorted public keys, as OP_CHECKMULTISIG consumes exactly that many.
 func (address *AccountAddress) SignatureScript(
 	signatures []*btcec.Signature,
 ) ([]byte, wire.TxWitness) {
@@ -210,11 +266,28 @@ func (address *AccountAddress) SignatureScript(
 		for i := 0; i < length; i++ {
 			sortedSignatures[index(publicKeys[i], sortedPublicKeys)] = signatures[i]
 		}
-		scriptBuilder := txscript.NewScriptBuilder().AddOp(txscript.OP_0)
+		usedSignatures := [][]byte{}
 		for _, signature := range sortedSignatures {
-			if signature != nil {
-				scriptBuilder.AddData(append(signature.Serialize(), byte(txscript.SigHashAll)))
+			if signature != nil && len(usedSignatures) < address.Configuration.SigningThreshold() {
+				usedSignatures = append(usedSignatures, append(signature.Serialize(), byte(txscript.SigHashAll)))
+			}
+		}
+		if address.witnessScript != nil {
+			// The empty item is consumed by the off-by-one bug of OP_CHECKMULTISIG.
+			txWitness := append(wire.TxWitness{[]byte{}}, usedSignatures...)
+			txWitness = append(txWitness, address.witnessScript)
+			if address.redeemScript == nil {
+				return []byte{}, txWitness
+			}
+			signatureScript, err := txscript.NewScriptBuilder().AddData(address.redeemScript).Script()
+			if err != nil {
+				address.log.WithError(err).Panic("Failed to build signa. script for P2WSH-P2SH.")
 			}
+			return signatureScript, txWitness
+		}
+		scriptBuilder := txscript.NewScriptBuilder().AddOp(txscript.OP_0)
+		for _, signature := range usedSignatures {
+			scriptBuilder.AddData(signature)
 		}
 		signatureScript, err := scriptBuilder.AddData(address.redeemScript).Script()
 		if err != nil {
@@ -255,8 +328,28 @@ func (address *AccountAddress) SignatureScript(
 			publicKey.SerializeCompressed(),
 		}
 		return []byte{}, txWitness
+	case signing.ScriptTypeP2TR:
+		address.log.Panic("Taproot inputs are signed with Schnorr signatures.")
 	default:
 		address.log.Panic("Unrecognized address type.")
 	}
 	panic("The end of the function cannot be reached.")
 }
+
+// IsTaproot returns true if this is a singlesig taproot address.
+func (address *AccountAddress) IsTaproot() bool {
+	return address.Configuration.Singlesig() &&
+		address.Configuration.ScriptType() == signing.ScriptTypeP2TR
+}
+
+// TaprootWitness returns the witness needed to spend from this taproot address via the key path,
+// given the 64 byte BIP340 Schnorr signature. The signature script is empty.
+func (address *AccountAddress) TaprootWitness(signature []byte) wire.TxWitness {
+	if !address.IsTaproot() {
+		address.log.Panic("Not a taproot address.")
+	}
+	if len(signature) != 64 {
+		address.log.Panic("A taproot signature has to be provided.")
+	}
+	return wire.TxWitness{signature}
+}
@@ -15,12 +15,17 @@
 package addresses_test
 
 import (
+	"crypto/sha256"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses/test"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -61,3 +66,39 @@ func (s *addressTestSuite) TestScriptHashHex() {
 		blockchain.ScriptHashHex("0466d0029406f583feadaccb91c7b5b855eb5d6782316cafa4f390b7c784436b"),
 		s.address.PubkeyScriptHashHex())
 }
+
+func TestTaprootAddress(t *testing.T) {
+	address := test.GetAddress(signing.ScriptTypeP2TR)
+	require.True(t, address.IsTaproot())
+	outputKey, err := taproot.TweakPublicKey(address.Configuration.PublicKeys()[0])
+	require.NoError(t, err)
+	require.Equal(t, outputKey, address.ScriptAddress())
+	require.Equal(t, append([]byte{0x51, 0x20}, outputKey...), address.PubkeyScript())
+
+	decoded, err := taproot.DecodeAddress(address.EncodeAddress(), net)
+	require.NoError(t, err)
+	require.Equal(t, outputKey, decoded.ScriptAddress())
+
+	require.Equal(t, wire.TxWitness{make([]byte, 64)}, address.TaprootWitness(make([]byte, 64)))
+	require.Panics(t, func() { address.ScriptForHashToSign() })
+	require.False(t, test.GetAddress(signing.ScriptTypeP2WPKH).IsTaproot())
+}
+
+func TestMultisigSegwitAddress(t *testing.T) {
+	address := test.GetMultisigAddress(signing.ScriptTypeP2WSH, 2, 3)
+	_, ok := address.Address.(*btcutil.AddressWitnessScriptHash)
+	require.True(t, ok)
+	isSegwit, witnessScript := address.ScriptForHashToSign()
+	require.True(t, isSegwit)
+	scriptHash := sha256.Sum256(witnessScript)
+	require.Equal(t, append([]byte{0x00, 0x20}, scriptHash[:]...), address.PubkeyScript())
+
+	address = test.GetMultisigAddress(signing.ScriptTypeP2WSHP2SH, 2, 3)
+	_, ok = address.Address.(*btcutil.AddressScriptHash)
+	require.True(t, ok)
+	isSegwit, witnessScript = address.ScriptForHashToSign()
+	require.True(t, isSegwit)
+	scriptHash = sha256.Sum256(witnessScript)
+	sigScript, _ := address.SignatureScript(make([]*btcec.Signature, 3))
+	require.Equal(t, append([]byte{0x22, 0x00, 0x20}, scriptHash[:]...), sigScript)
+}
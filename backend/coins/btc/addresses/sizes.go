@@ -14,18 +14,24 @@
 
 package addresses
 
-import "github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+)
 
 // SigScriptWitnessSize returns the maximum possible sigscript size for a given address type.
 func SigScriptWitnessSize(configuration *signing.Configuration) (int, bool) {
 	if configuration.Multisig() {
-		// OP_N (1 byte, signingThreshold)
-		// numberOfSigners*(
-		// OP_DATA_33
-		// 33 bytes of compressed pubkey
-		// )
-		// OP_N (1 byte, numberOfSigners) OP_CHECKMULTISIG (1 byte)
-		redeemScriptSize := 1 + configuration.NumberOfSigners()*(1+33) + 1 + 1
+		switch configuration.ScriptType() {
+		case signing.ScriptTypeP2WSH:
+			return 0, true
+		case signing.ScriptTypeP2WSHP2SH:
+			// OP_0 (1 byte) OP_32 (1 byte) scriptHash (32 bytes)
+			const redeemScriptSize = 1 + 1 + 32
+			// OP_DATA_34 (1 Byte) redeemScript (34 bytes)
+			return 1 + redeemScriptSize, true
+		}
+		redeemScriptSize := multisigScriptSize(configuration)
 		// OP_0 (1 byte)
 		// numSigs*(
 		// OP_DATA_72
@@ -59,7 +65,51 @@ func SigScriptWitnessSize(configuration *signing.Configuration) (int, bool) {
 		return 1 + redeemScriptSize, true
 	case signing.ScriptTypeP2WPKH:
 		return 0, true // hooray
+	case signing.ScriptTypeP2TR:
+		return 0, true
 	default:
 		panic("unknown address type")
 	}
 }
+
+// multisigScriptSize returns the size of the sortedmulti script of a multisig configuration.
+func multisigScriptSize(configuration *signing.Configuration) int {
+	// OP_N (1 byte, signingThreshold)
+	// numberOfSigners*(
+	// OP_DATA_33
+	// 33 bytes of compressed pubkey
+	// )
+	// OP_N (1 byte, numberOfSigners) OP_CHECKMULTISIG (1 byte)
+	return 1 + configuration.NumberOfSigners()*(1+33) + 1 + 1
+}
+
+// WitnessSize returns the maximum possible serialized witness size for a given address type, or 0
+// if it has no witness.
+func WitnessSize(configuration *signing.Configuration) int {
+	if _, hasWitness := SigScriptWitnessSize(configuration); !hasWitness {
+		return 0
+	}
+	if configuration.Multisig() {
+		// <empty> signingThreshold*<serialized sig> <witnessScript>
+		const signatureSize = 73 // including SIGHASH op
+		witnessScriptSize := multisigScriptSize(configuration)
+		return wire.VarIntSerializeSize(uint64(configuration.SigningThreshold()+2)) +
+			wire.VarIntSerializeSize(0) +
+			configuration.SigningThreshold()*(wire.VarIntSerializeSize(signatureSize)+signatureSize) +
+			wire.VarIntSerializeSize(uint64(witnessScriptSize)) + witnessScriptSize
+	}
+	if configuration.ScriptType() == signing.ScriptTypeP2TR {
+		// Key path spend: <64 byte Schnorr signature>, no sighash op for SIGHASH_DEFAULT.
+		const signatureSize = 64
+		return wire.VarIntSerializeSize(1) +
+			wire.VarIntSerializeSize(signatureSize) + signatureSize
+	}
+	// <serialized sig> <serialized compressed pubkey>
+	const (
+		signatureSize = 73 // including SIGHASH op
+		pubkeySize    = 33
+	)
+	return wire.VarIntSerializeSize(2) +
+		wire.VarIntSerializeSize(signatureSize) + signatureSize +
+		wire.VarIntSerializeSize(pubkeySize) + pubkeySize
+}
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses/test"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
@@ -29,6 +30,7 @@ var scriptTypes = []signing.ScriptType{
 	signing.ScriptTypeP2PKH,
 	signing.ScriptTypeP2WPKHP2SH,
 	signing.ScriptTypeP2WPKH,
+	signing.ScriptTypeP2TR,
 }
 
 func TestSigScriptWitnessSize(t *testing.T) {
@@ -45,30 +47,52 @@ func TestSigScriptWitnessSize(t *testing.T) {
 		address := test.GetAddress(scriptType)
 		t.Run(address.Configuration.String(), func(t *testing.T) {
 			sigScriptSize, hasWitness := addresses.SigScriptWitnessSize(address.Configuration)
-			sigScript, witness := address.SignatureScript([]*btcec.Signature{sig})
+			var sigScript []byte
+			var witness wire.TxWitness
+			if address.IsTaproot() {
+				witness = address.TaprootWitness(make([]byte, 64))
+			} else {
+				sigScript, witness = address.SignatureScript([]*btcec.Signature{sig})
+			}
 			require.Equal(t, len(sigScript), sigScriptSize)
 			require.Equal(t, witness != nil, hasWitness)
+			if witness != nil {
+				require.LessOrEqual(t, witness.SerializeSize(), addresses.WitnessSize(address.Configuration))
+			}
 		})
 	}
 
 	// Test all multisig configurations.
-	for numberOfSigners := 2; numberOfSigners <= 15; numberOfSigners++ {
-		numberOfSigners := numberOfSigners // avoids referencing the same variable across loop iterations
-		for signingThreshold := 1; signingThreshold <= numberOfSigners; signingThreshold++ {
-			signingThreshold := signingThreshold // avoids referencing the same variable across loop iterations
-			address := test.GetMultisigAddress(signingThreshold, numberOfSigners)
-			t.Run(address.Configuration.String(), func(t *testing.T) {
-				// create a slice of `n` sigs, `m` of which contain a signature, the rest being
-				// nil. This is how SignatureScript() expects it.
-				sigs := make([]*btcec.Signature, numberOfSigners)
-				for numSigs := 0; numSigs < signingThreshold; numSigs++ {
-					sigs[numSigs] = sig
-				}
-				sigScriptSize, hasWitness := addresses.SigScriptWitnessSize(address.Configuration)
-				sigScript, _ := address.SignatureScript(sigs)
-				require.Equal(t, len(sigScript), sigScriptSize)
-				require.False(t, hasWitness)
-			})
+	multisigScriptTypes := []signing.ScriptType{
+		signing.ScriptTypeP2PKH,
+		signing.ScriptTypeP2WSHP2SH,
+		signing.ScriptTypeP2WSH,
+	}
+	for _, scriptType := range multisigScriptTypes {
+		scriptType := scriptType // avoids referencing the same variable across loop iterations
+		for numberOfSigners := 2; numberOfSigners <= 15; numberOfSigners++ {
+			numberOfSigners := numberOfSigners // avoids referencing the same variable across loop iterations
+			for signingThreshold := 1; signingThreshold <= numberOfSigners; signingThreshold++ {
+				signingThreshold := signingThreshold // avoids referencing the same variable across loop iterations
+				address := test.GetMultisigAddress(scriptType, signingThreshold, numberOfSigners)
+				t.Run(address.Configuration.String(), func(t *testing.T) {
+					// create a slice of `n` sigs, `m` of which contain a signature, the rest being
+					// nil. This is how SignatureScript() expects it.
+					sigs := make([]*btcec.Signature, numberOfSigners)
+					for numSigs := 0; numSigs < signingThreshold; numSigs++ {
+						sigs[numSigs] = sig
+					}
+					sigScriptSize, hasWitness := addresses.SigScriptWitnessSize(address.Configuration)
+					sigScript, witness := address.SignatureScript(sigs)
+					require.Equal(t, len(sigScript), sigScriptSize)
+					require.Equal(t, scriptType != signing.ScriptTypeP2PKH, hasWitness)
+					require.Equal(t, witness != nil, hasWitness)
+					if witness != nil {
+						require.Len(t, witness, signingThreshold+2)
+						require.LessOrEqual(t, witness.SerializeSize(), addresses.WitnessSize(address.Configuration))
+					}
+				})
+			}
 		}
 	}
 }
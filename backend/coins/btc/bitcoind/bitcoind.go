@@ -0,0 +1,72 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bitcoind implements blockchain.Interface using the JSON-RPC interface of Bitcoin Core.
+package bitcoind
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultWallet is the name of the watch-only wallet created in the node if none is configured.
+const defaultWallet = "bitbox"
+
+// isLocal returns true if the node runs on this machine.
+func isLocal(nodeURL string) bool {
+	parsed, err := url.Parse(nodeURL)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NewBitcoindConnection returns a client to communicate with the configured Bitcoin Core node.
+// birthday is the Unix time at which the wallets were created, 0 if unknown. A local node is
+// connected to directly, as the socks proxy can't reach it.
+func NewBitcoindConnection(
+	info *rpc.BitcoinCoreInfo,
+	birthday int64,
+	log *logrus.Entry,
+	socksProxy socksproxy.SocksProxy,
+) (blockchain.Interface, error) {
+	log = log.WithFields(logrus.Fields{"group": "bitcoind", "url": info.URL})
+	log.Debug("Connecting to Bitcoin Core")
+	httpClient := &http.Client{}
+	if !isLocal(info.URL) {
+		var err error
+		httpClient, err = socksProxy.GetHTTPClient()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+	}
+	wallet := info.Wallet
+	if wallet == "" {
+		wallet = defaultWallet
+	}
+	return NewClient(
+		httpClient, info.URL, info.CookieFile, wallet, birthday, info.RescanFromGenesis, log), nil
+}
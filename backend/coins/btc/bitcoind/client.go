@@ -0,0 +1,450 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pollInterval is the interval in which the node is polled for new blocks and transactions.
+	pollInterval = 10 * time.Second
+	// maxHeadersPerBatch is the maximum number of headers returned by one call to Headers().
+	maxHeadersPerBatch = 500
+	// maxListTransactions is the maximum number of wallet transactions fetched per sync.
+	maxListTransactions = 1000000
+)
+
+// walletTx is a transaction touching the scripts of the wallet.
+type walletTx struct {
+	tx *wire.MsgTx
+	// height is the height of the block containing the tx, 0 if unconfirmed.
+	height int
+}
+
+// Client implements blockchain.Interface using the JSON-RPC interface of Bitcoin Core. The scripts
+// are imported as raw descriptors into a watch-only descriptor wallet of the node, which keeps
+// track of their transactions. The scripts must be registered with RegisterScript() before
+// subscribing to them.
+//
+// The scripts are imported with the wallet birthday as their timestamp, so the node rescans the
+// blocks since then once per import and the history is complete. The scripts registered in the
+// meantime are imported together, so the discovery of an account does not cause a rescan per
+// address. Scripts already in the wallet, e.g. imported before the app was restarted, are not
+// imported again. The node is polled for new blocks and transactions.
+type Client struct {
+	rpc    *rpcClient
+	wallet string
+	// birthday is the Unix time from which the node rescans the blocks for imported scripts, 0 if
+	// unknown.
+	birthday int64
+	// rescanFromGenesis is true if the user confirmed that the whole chain is rescanned if the
+	// birthday is unknown.
+	rescanFromGenesis bool
+	log               *logrus.Entry
+
+	lock         locker.Locker
+	status       blockchain.Status
+	walletLoaded bool
+	// tip is the height of the best chain, -1 if unknown.
+	tip int
+
+	scripts *blockchain.Scripts
+	// imported contains the scripts which are in the wallet.
+	imported map[blockchain.ScriptHashHex]bool
+	txs      map[chainhash.Hash]*walletTx
+
+	headersSubscriptions      []func(*blockchain.Header) error
+	connectionStatusCallbacks []func(blockchain.Status)
+
+	// syncChan triggers a sync, e.g. when new scripts are registered.
+	syncChan chan struct{}
+	quitChan chan struct{}
+}
+
+// NewClient creates a new client connecting to the node at the given URL, authenticating with the
+// credentials in the given cookie file. The watch-only wallet with the given name is created if it
+// does not exist yet. If the birthday is 0, scripts are only imported if rescanFromGenesis is true.
+func NewClient(
+	httpClient *http.Client,
+	url string,
+	cookieFile string,
+	wallet string,
+	birthday int64,
+	rescanFromGenesis bool,
+	log *logrus.Entry,
+) *Client {
+	client := &Client{
+		rpc: &rpcClient{
+			httpClient: httpClient,
+			url:        url,
+			cookieFile: cookieFile,
+		},
+		wallet:            wallet,
+		birthday:          birthday,
+		rescanFromGenesis: rescanFromGenesis,
+		log:               log,
+
+		status: blockchain.DISCONNECTED,
+		tip:    -1,
+
+		scripts:  blockchain.NewScripts(log),
+		imported: map[blockchain.ScriptHashHex]bool{},
+		txs:      map[chainhash.Hash]*walletTx{},
+
+		syncChan: make(chan struct{}, 1),
+		quitChan: make(chan struct{}),
+	}
+	go client.run()
+	return client
+}
+
+func parseTX(rawTXHex string) (*wire.MsgTx, error) {
+	rawTX, err := hex.DecodeString(rawTXHex)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.BtcDecode(bytes.NewReader(rawTX), 0, wire.WitnessEncoding); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return tx, nil
+}
+
+func (client *Client) kickSync() {
+	select {
+	case client.syncChan <- struct{}{}:
+	default:
+	}
+}
+
+// RegisterScript implements blockchain.ScriptRegistry. The scan state is not needed, as the node
+// keeps track of the imported scripts in its wallet.
+func (client *Client) RegisterScript(pkScript []byte, _ *blockchain.ScanState, _ func(*blockchain.ScanState)) {
+	defer client.lock.Lock()()
+	if client.scripts.Register(pkScript) {
+		client.kickSync()
+	}
+}
+
+// ScriptHashGetHistory implements blockchain.Interface.
+func (client *Client) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(error),
+) {
+	defer client.lock.RLock()()
+	client.scripts.GetHistory(scriptHashHex, success, cleanup)
+}
+
+// TransactionGet implements blockchain.Interface. Transactions not touching the wallet can only be
+// fetched if they are in the mempool or if the node maintains a transaction index (-txindex).
+func (client *Client) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(error),
+) {
+	defer client.lock.RLock()()
+	if walletTx, ok := client.txs[txHash]; ok {
+		go func() {
+			cleanup(success(walletTx.tx))
+		}()
+		return
+	}
+	go func() {
+		var rawTXHex string
+		if err := client.rpc.call("", &rawTXHex, "getrawtransaction", txHash.String(), false); err != nil {
+			cleanup(err)
+			return
+		}
+		tx, err := parseTX(rawTXHex)
+		if err != nil {
+			cleanup(err)
+			return
+		}
+		cleanup(success(tx))
+	}()
+}
+
+// ScriptHashSubscribe implements blockchain.Interface. The first status is sent once the script
+// was imported into the wallet of the node.
+func (client *Client) ScriptHashSubscribe(
+	setupAndTeardown func() func(error),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	done := setupAndTeardown()
+	defer client.lock.Lock()()
+	client.scripts.Subscribe(done, scriptHashHex, success)
+	client.notifyScripts()
+}
+
+// notifyScripts sends the status of the imported scripts to the subscribers, if it changed.
+// Requires the lock.
+func (client *Client) notifyScripts() {
+	if client.tip == -1 {
+		return
+	}
+	client.scripts.Notify(func(scriptHashHex blockchain.ScriptHashHex) bool {
+		return client.imported[scriptHashHex]
+	})
+}
+
+// HeadersSubscribe implements blockchain.Interface.
+func (client *Client) HeadersSubscribe(
+	setupAndTeardown func() func(error),
+	success func(*blockchain.Header) error,
+) {
+	done := func(error) {}
+	if setupAndTeardown != nil {
+		done = setupAndTeardown()
+	}
+	defer client.lock.Lock()()
+	client.headersSubscriptions = append(client.headersSubscriptions, success)
+	if client.tip != -1 {
+		header := &blockchain.Header{BlockHeight: client.tip}
+		go func() {
+			done(success(header))
+		}()
+		return
+	}
+	go done(nil)
+}
+
+// TransactionBroadcast implements blockchain.Interface.
+func (client *Client) TransactionBroadcast(tx *wire.MsgTx) error {
+	rawTX := &bytes.Buffer{}
+	if err := tx.BtcEncode(rawTX, 0, wire.WitnessEncoding); err != nil {
+		return errp.WithStack(err)
+	}
+	var txID string
+	if err := client.rpc.call("", &txID, "sendrawtransaction", hex.EncodeToString(rawTX.Bytes())); err != nil {
+		return err
+	}
+	client.kickSync()
+	return nil
+}
+
+// RelayFee implements blockchain.Interface.
+func (client *Client) RelayFee(success func(btcutil.Amount), cleanup func(error)) {
+	go func() {
+		var networkInfo struct {
+			RelayFee float64 `json:"relayfee"`
+		}
+		if err := client.rpc.call("", &networkInfo, "getnetworkinfo"); err != nil {
+			cleanup(err)
+			return
+		}
+		amount, err := btcutil.NewAmount(networkInfo.RelayFee)
+		if err != nil {
+			cleanup(errp.WithStack(err))
+			return
+		}
+		success(amount)
+		cleanup(nil)
+	}()
+}
+
+// EstimateFee implements blockchain.Interface.
+func (client *Client) EstimateFee(
+	number int,
+	success func(*btcutil.Amount) error,
+	cleanup func(error),
+) {
+	go func() {
+		var estimate struct {
+			FeeRate *float64 `json:"feerate"`
+		}
+		if err := client.rpc.call("", &estimate, "estimatesmartfee", number); err != nil {
+			cleanup(err)
+			return
+		}
+		if estimate.FeeRate == nil {
+			cleanup(success(nil))
+			return
+		}
+		amount, err := btcutil.NewAmount(*estimate.FeeRate)
+		if err != nil {
+			cleanup(errp.WithStack(err))
+			return
+		}
+		cleanup(success(&amount))
+	}()
+}
+
+// blockHashes returns the hashes of the blocks at the given heights.
+func (client *Client) blockHashes(heights []int) ([]string, error) {
+	blockHashes := make([]string, len(heights))
+	calls := make([]*rpcCall, len(heights))
+	for index, height := range heights {
+		calls[index] = &rpcCall{method: "getblockhash", params: []interface{}{height}, response: &blockHashes[index]}
+	}
+	if err := client.rpc.batch("", calls); err != nil {
+		return nil, err
+	}
+	for _, call := range calls {
+		if call.err != nil {
+			return nil, call.err
+		}
+	}
+	return blockHashes, nil
+}
+
+// Headers implements blockchain.Interface.
+func (client *Client) Headers(
+	startHeight int,
+	count int,
+	success func([]*wire.BlockHeader, int) error,
+	cleanup func(error),
+) {
+	defer client.lock.RLock()()
+	heights := []int{}
+	for height := startHeight; height < startHeight+count && height <= client.tip; height++ {
+		heights = append(heights, height)
+		if len(heights) == maxHeadersPerBatch {
+			break
+		}
+	}
+	go func() {
+		blockHashes, err := client.blockHashes(heights)
+		if err != nil {
+			cleanup(err)
+			return
+		}
+		headersHex := make([]string, len(blockHashes))
+		calls := make([]*rpcCall, len(blockHashes))
+		for index, blockHash := range blockHashes {
+			calls[index] = &rpcCall{
+				method:   "getblockheader",
+				params:   []interface{}{blockHash, false},
+				response: &headersHex[index],
+			}
+		}
+		if err := client.rpc.batch("", calls); err != nil {
+			cleanup(err)
+			return
+		}
+		blockHeaders := make([]*wire.BlockHeader, len(calls))
+		for index, call := range calls {
+			if call.err != nil {
+				cleanup(call.err)
+				return
+			}
+			rawHeader, err := hex.DecodeString(headersHex[index])
+			if err != nil {
+				cleanup(errp.WithStack(err))
+				return
+			}
+			blockHeaders[index] = &wire.BlockHeader{}
+			if err := blockHeaders[index].Deserialize(bytes.NewReader(rawHeader)); err != nil {
+				cleanup(errp.WithStack(err))
+				return
+			}
+		}
+		cleanup(success(blockHeaders, maxHeadersPerBatch))
+	}()
+}
+
+// GetMerkle implements blockchain.Interface using gettxoutproof.
+func (client *Client) GetMerkle(
+	txHash chainhash.Hash,
+	height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(error),
+) {
+	go func() {
+		blockHashes, err := client.blockHashes([]int{height})
+		if err != nil {
+			cleanup(err)
+			return
+		}
+		var proof string
+		err = client.rpc.call("", &proof, "gettxoutproof", []string{txHash.String()}, blockHashes[0])
+		if err != nil {
+			cleanup(err)
+			return
+		}
+		merkle, pos, err := parseTxOutProof(proof, txHash)
+		if err != nil {
+			cleanup(err)
+			return
+		}
+		cleanup(success(merkle, pos))
+	}()
+}
+
+// Close implements blockchain.Interface.
+func (client *Client) Close() {
+	defer client.lock.Lock()()
+	select {
+	case <-client.quitChan:
+	default:
+		close(client.quitChan)
+	}
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (client *Client) ConnectionStatus() blockchain.Status {
+	defer client.lock.RLock()()
+	return client.status
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface.
+func (client *Client) RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged func(blockchain.Status)) {
+	defer client.lock.Lock()()
+	client.connectionStatusCallbacks = append(client.connectionStatusCallbacks, onConnectionStatusChanged)
+}
+
+func (client *Client) setStatus(status blockchain.Status) {
+	defer client.lock.Lock()()
+	if status == blockchain.DISCONNECTED {
+		client.walletLoaded = false
+	}
+	if status == client.status {
+		return
+	}
+	client.status = status
+	for _, callback := range client.connectionStatusCallbacks {
+		go callback(status)
+	}
+}
+
+func (client *Client) run() {
+	for {
+		if err := client.sync(); err != nil {
+			client.log.WithError(err).Warning("Could not sync with the node")
+			client.setStatus(blockchain.DISCONNECTED)
+		} else {
+			client.setStatus(blockchain.CONNECTED)
+		}
+		select {
+		case <-client.quitChan:
+			return
+		case <-client.syncChan:
+		case <-time.After(pollInterval):
+		}
+	}
+}
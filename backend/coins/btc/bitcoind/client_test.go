@@ -0,0 +1,629 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	btcdblockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	ourScript     = []byte{txscript.OP_0, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	foreignScript = []byte{txscript.OP_0, 0x14, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+)
+
+// buildTxOutProof builds the BIP37 partial merkle tree proving the tx at the given position, in
+// the format returned by gettxoutproof.
+func buildTxOutProof(t *testing.T, header wire.BlockHeader, txHashes []chainhash.Hash, pos int) string {
+	t.Helper()
+	tree := &partialMerkleTree{numTxs: uint32(len(txHashes))}
+	nodeHash := func(height int, index int) chainhash.Hash {
+		level := txHashes
+		for ; height > 0; height-- {
+			next := []chainhash.Hash{}
+			for i := 0; i < len(level); i += 2 {
+				right := level[i]
+				if i+1 < len(level) {
+					right = level[i+1]
+				}
+				next = append(next, chainhash.DoubleHashH(append(level[i][:], right[:]...)))
+			}
+			level = next
+		}
+		return level[index]
+	}
+	merkleBlock := wire.NewMsgMerkleBlock(&header)
+	merkleBlock.Transactions = uint32(len(txHashes))
+	var bits []bool
+	var build func(height int, index int)
+	build = func(height int, index int) {
+		parentOfMatch := pos>>uint(height) == index
+		bits = append(bits, parentOfMatch)
+		if height == 0 || !parentOfMatch {
+			hash := nodeHash(height, index)
+			require.NoError(t, merkleBlock.AddTxHash(&hash))
+			return
+		}
+		build(height-1, index*2)
+		if index*2+1 < tree.width(height-1) {
+			build(height-1, index*2+1)
+		}
+	}
+	build(tree.height(), 0)
+	merkleBlock.Flags = make([]byte, (len(bits)+7)/8)
+	for index, bit := range bits {
+		if bit {
+			merkleBlock.Flags[index/8] |= 1 << uint(index%8)
+		}
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, merkleBlock.BtcEncode(buf, wire.ProtocolVersion, wire.BaseEncoding))
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func newTxs(count int) []*btcutil.Tx {
+	txs := make([]*btcutil.Tx, count)
+	for index := range txs {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.LockTime = uint32(index)
+		txs[index] = btcutil.NewTx(tx)
+	}
+	return txs
+}
+
+func TestParseTxOutProof(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		txs := newTxs(count)
+		merkles := btcdblockchain.BuildMerkleTreeStore(txs, false)
+		header := wire.BlockHeader{MerkleRoot: *merkles[len(merkles)-1]}
+		txHashes := make([]chainhash.Hash, count)
+		for index, tx := range txs {
+			txHashes[index] = *tx.Hash()
+		}
+		for pos, txHash := range txHashes {
+			proof := buildTxOutProof(t, header, txHashes, pos)
+			branch, proofPos, err := parseTxOutProof(proof, txHash)
+			require.NoError(t, err)
+			require.Equal(t, pos, proofPos)
+			root := txHash
+			for level, sibling := range branch {
+				if (pos>>uint(level))&1 == 0 {
+					root = chainhash.DoubleHashH(append(root[:], sibling[:]...))
+				} else {
+					root = chainhash.DoubleHashH(append(sibling[:], root[:]...))
+				}
+			}
+			require.Equal(t, header.MerkleRoot, root)
+
+			_, _, err = parseTxOutProof(proof, chainhash.Hash{})
+			require.Error(t, err)
+		}
+	}
+	header := wire.BlockHeader{}
+	_, _, err := parseTxOutProof(buildTxOutProof(t, header, []chainhash.Hash{{1}, {2}}, 0), chainhash.Hash{1})
+	require.Error(t, err, "root mismatch")
+}
+
+// walletEntry is a transaction of the watch-only wallet of the fake node.
+type walletEntry struct {
+	tx     *wire.MsgTx
+	height int
+}
+
+// fakeNode implements the RPC methods of Bitcoin Core used by the client.
+type fakeNode struct {
+	t *testing.T
+
+	lock     sync.Mutex
+	blocks   []*wire.MsgBlock
+	wallets  []string
+	imported []string
+	// timestamps are the timestamps of the imported descriptors.
+	timestamps []int64
+	walletTxs  []walletEntry
+	// failingTxs are the txids for which gettransaction fails.
+	failingTxs map[string]bool
+}
+
+func (node *fakeNode) addBlock(txs ...*wire.MsgTx) *wire.MsgBlock {
+	height := len(node.blocks)
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  []byte{byte(height), 0},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(50*btcutil.SatoshiPerBitcoin, foreignScript))
+	block := &wire.MsgBlock{Transactions: append([]*wire.MsgTx{coinbase}, txs...)}
+	utilTxs := make([]*btcutil.Tx, len(block.Transactions))
+	for index, tx := range block.Transactions {
+		utilTxs[index] = btcutil.NewTx(tx)
+	}
+	merkles := btcdblockchain.BuildMerkleTreeStore(utilTxs, false)
+	block.Header = wire.BlockHeader{
+		Version:    1,
+		PrevBlock:  node.blocks[height-1].BlockHash(),
+		MerkleRoot: *merkles[len(merkles)-1],
+		Timestamp:  time.Unix(int64(1600000000+height*600), 0),
+		Bits:       chaincfg.RegressionNetParams.PowLimitBits,
+	}
+	node.blocks = append(node.blocks, block)
+	return block
+}
+
+func (node *fakeNode) findTx(txID string) (*wire.MsgTx, int) {
+	for height, block := range node.blocks {
+		for _, tx := range block.Transactions {
+			if tx.TxHash().String() == txID {
+				return tx, height
+			}
+		}
+	}
+	for _, entry := range node.walletTxs {
+		if entry.tx.TxHash().String() == txID {
+			return entry.tx, 0
+		}
+	}
+	return nil, -1
+}
+
+// rescan adds the transactions of the blocks mined since the timestamp which pay to or spend from
+// the imported scripts to the wallet.
+func (node *fakeNode) rescan(timestamp int64) {
+	pkScripts := map[string]bool{}
+	for _, descriptor := range node.imported {
+		pkScripts[strings.TrimSuffix(strings.TrimPrefix(descriptor, "raw("), ")#checksum")] = true
+	}
+	inWallet := func(txHash chainhash.Hash) bool {
+		for _, entry := range node.walletTxs {
+			if entry.tx.TxHash() == txHash {
+				return true
+			}
+		}
+		return false
+	}
+	for height, block := range node.blocks {
+		if block.Header.Timestamp.Unix() < timestamp {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			relevant := false
+			for _, txOut := range tx.TxOut {
+				relevant = relevant || pkScripts[hex.EncodeToString(txOut.PkScript)]
+			}
+			for _, txIn := range tx.TxIn {
+				relevant = relevant || inWallet(txIn.PreviousOutPoint.Hash)
+			}
+			if relevant && !inWallet(tx.TxHash()) {
+				node.walletTxs = append(node.walletTxs, walletEntry{tx: tx, height: height})
+			}
+		}
+	}
+}
+
+func (node *fakeNode) blockHeight(blockHash string) int {
+	for height, block := range node.blocks {
+		if block.BlockHash().String() == blockHash {
+			return height
+		}
+	}
+	return -1
+}
+
+func txHex(t *testing.T, tx *wire.MsgTx) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	require.NoError(t, tx.BtcEncode(buf, 0, wire.WitnessEncoding))
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func (node *fakeNode) handle(path string, method string, params []json.RawMessage) (interface{}, *RPCError) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	stringParam := func(index int) string {
+		var value string
+		require.NoError(node.t, json.Unmarshal(params[index], &value))
+		return value
+	}
+	switch method {
+	case "listwallets":
+		return node.wallets, nil
+	case "loadwallet":
+		return nil, &RPCError{Code: rpcErrorWalletNotFound, Message: "wallet not found"}
+	case "createwallet":
+		node.wallets = append(node.wallets, stringParam(0))
+		return map[string]string{"name": stringParam(0)}, nil
+	case "listdescriptors":
+		require.Equal(node.t, "/wallet/bitbox", path)
+		descriptors := []map[string]string{}
+		for _, descriptor := range node.imported {
+			descriptors = append(descriptors, map[string]string{"desc": descriptor})
+		}
+		return map[string]interface{}{"wallet_name": "bitbox", "descriptors": descriptors}, nil
+	case "getdescriptorinfo":
+		return map[string]string{"descriptor": stringParam(0) + "#checksum"}, nil
+	case "importdescriptors":
+		require.Equal(node.t, "/wallet/bitbox", path)
+		var requests []struct {
+			Descriptor string `json:"desc"`
+			Timestamp  int64  `json:"timestamp"`
+		}
+		require.NoError(node.t, json.Unmarshal(params[0], &requests))
+		results := []map[string]bool{}
+		for _, request := range requests {
+			node.imported = append(node.imported, request.Descriptor)
+			node.timestamps = append(node.timestamps, request.Timestamp)
+			results = append(results, map[string]bool{"success": true})
+		}
+		node.rescan(requests[0].Timestamp)
+		return results, nil
+	case "getblockcount":
+		return len(node.blocks) - 1, nil
+	case "listtransactions":
+		require.Equal(node.t, "/wallet/bitbox", path)
+		entries := []map[string]interface{}{}
+		for _, entry := range node.walletTxs {
+			confirmations := 0
+			blockHash := ""
+			if entry.height > 0 {
+				confirmations = len(node.blocks) - entry.height
+				blockHash = node.blocks[entry.height].BlockHash().String()
+			}
+			entries = append(entries, map[string]interface{}{
+				"txid":          entry.tx.TxHash().String(),
+				"confirmations": confirmations,
+				"blockheight":   entry.height,
+				"blockhash":     blockHash,
+			})
+		}
+		return entries, nil
+	case "gettransaction":
+		require.Equal(node.t, "/wallet/bitbox", path)
+		for _, entry := range node.walletTxs {
+			if entry.tx.TxHash().String() == stringParam(0) && !node.failingTxs[stringParam(0)] {
+				return map[string]string{"hex": txHex(node.t, entry.tx)}, nil
+			}
+		}
+		return nil, &RPCError{Code: -5, Message: "Invalid or non-wallet transaction id"}
+	case "getrawtransaction":
+		tx, height := node.findTx(stringParam(0))
+		if tx == nil {
+			return nil, &RPCError{Code: -5, Message: "No such mempool or blockchain transaction"}
+		}
+		// Without a transaction index, confirmed transactions need the block hash.
+		if height > 0 && (len(params) < 3 || node.blockHeight(stringParam(2)) != height) {
+			return nil, &RPCError{Code: -5, Message: "No such mempool transaction"}
+		}
+		return txHex(node.t, tx), nil
+	case "getblockhash":
+		var height int
+		require.NoError(node.t, json.Unmarshal(params[0], &height))
+		return node.blocks[height].BlockHash().String(), nil
+	case "getblockheader":
+		buf := &bytes.Buffer{}
+		require.NoError(node.t, node.blocks[node.blockHeight(stringParam(0))].Header.Serialize(buf))
+		return hex.EncodeToString(buf.Bytes()), nil
+	case "gettxoutproof":
+		var txIDs []string
+		require.NoError(node.t, json.Unmarshal(params[0], &txIDs))
+		block := node.blocks[node.blockHeight(stringParam(1))]
+		txHashes := make([]chainhash.Hash, len(block.Transactions))
+		pos := -1
+		for index, tx := range block.Transactions {
+			txHashes[index] = tx.TxHash()
+			if txHashes[index].String() == txIDs[0] {
+				pos = index
+			}
+		}
+		return buildTxOutProof(node.t, block.Header, txHashes, pos), nil
+	case "sendrawtransaction":
+		rawTX, err := hex.DecodeString(stringParam(0))
+		require.NoError(node.t, err)
+		tx := &wire.MsgTx{}
+		require.NoError(node.t, tx.BtcDecode(bytes.NewReader(rawTX), 0, wire.WitnessEncoding))
+		node.walletTxs = append(node.walletTxs, walletEntry{tx: tx})
+		return tx.TxHash().String(), nil
+	case "getnetworkinfo":
+		return map[string]interface{}{"relayfee": 0.00001}, nil
+	case "estimatesmartfee":
+		return map[string]interface{}{"feerate": 0.0002, "blocks": 2}, nil
+	}
+	return nil, &RPCError{Code: -32601, Message: "Method not found"}
+}
+
+func (node *fakeNode) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	user, password, ok := request.BasicAuth()
+	if !ok || user != "user" || password != "password" {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var requests []struct {
+		ID     uint64            `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	require.NoError(node.t, json.NewDecoder(request.Body).Decode(&requests))
+	responses := []map[string]interface{}{}
+	for _, rpcRequest := range requests {
+		result, rpcErr := node.handle(request.URL.Path, rpcRequest.Method, rpcRequest.Params)
+		responses = append(responses, map[string]interface{}{
+			"id": rpcRequest.ID, "result": result, "error": rpcErr})
+	}
+	require.NoError(node.t, json.NewEncoder(writer).Encode(responses))
+}
+
+// cookieFile writes the given credentials (user:password) to a cookie file and returns its path.
+func cookieFile(t *testing.T, credentials string) string {
+	t.Helper()
+	filename := test.TstTempFile("bitcoind-cookie")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(credentials+"\n"), 0600))
+	return filename
+}
+
+func payTo(prevOut wire.OutPoint, pkScript []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(btcutil.SatoshiPerBitcoin, pkScript))
+	return tx
+}
+
+func waitForStatus(t *testing.T, statuses <-chan string) string {
+	t.Helper()
+	select {
+	case status := <-statuses:
+		return status
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for the script status")
+		return ""
+	}
+}
+
+func TestClient(t *testing.T) {
+	node := &fakeNode{t: t, blocks: []*wire.MsgBlock{chaincfg.RegressionNetParams.GenesisBlock}}
+	// Received before the wallet birthday, not found by the rescan.
+	tooOld := payTo(wire.OutPoint{Index: 1}, ourScript)
+	block1 := node.addBlock(tooOld)
+	// Received before the script was imported, found by the rescan.
+	oldReceive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	block2 := node.addBlock(oldReceive)
+	receive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash(), Index: 1}, ourScript)
+	block3 := node.addBlock(receive)
+	spend := payTo(wire.OutPoint{Hash: receive.TxHash()}, foreignScript)
+	node.walletTxs = []walletEntry{{tx: spend}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	birthday := block2.Header.Timestamp.Unix()
+	client := NewClient(server.Client(), server.URL, cookieFile(t, "user:password"), "bitbox",
+		birthday, false, logging.Get().WithGroup("bitcoind_test"))
+	defer client.Close()
+
+	scriptHash := blockchain.NewScriptHashHex(ourScript)
+	statuses := make(chan string, 10)
+	client.RegisterScript(ourScript, nil, nil)
+	client.ScriptHashSubscribe(
+		func() func(error) { return func(err error) { require.NoError(t, err) } },
+		scriptHash,
+		func(status string) error {
+			statuses <- status
+			return nil
+		},
+	)
+	status := waitForStatus(t, statuses)
+	require.Equal(t, blockchain.CONNECTED, client.ConnectionStatus())
+	require.Equal(t, []string{"bitbox"}, node.wallets)
+	require.Equal(t, []string{"raw(" + hex.EncodeToString(ourScript) + ")#checksum"}, node.imported)
+	require.Equal(t, []int64{birthday}, node.timestamps)
+
+	expectedHistory := blockchain.TxHistory{
+		{Height: 2, TXHash: blockchain.TXHash(oldReceive.TxHash())},
+		{Height: 3, TXHash: blockchain.TXHash(receive.TxHash())},
+		{Height: 0, TXHash: blockchain.TXHash(spend.TxHash())},
+	}
+	require.Equal(t, expectedHistory.Status(), status)
+	histories := make(chan blockchain.TxHistory, 1)
+	client.ScriptHashGetHistory(scriptHash,
+		func(history blockchain.TxHistory) error {
+			histories <- history
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	require.Equal(t, expectedHistory, <-histories)
+
+	txs := make(chan *wire.MsgTx, 1)
+	client.TransactionGet(oldReceive.TxHash(),
+		func(tx *wire.MsgTx) error {
+			txs <- tx
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	require.Equal(t, oldReceive.TxHash(), (<-txs).TxHash())
+
+	headers := make(chan []*wire.BlockHeader, 1)
+	client.Headers(1, 10,
+		func(blockHeaders []*wire.BlockHeader, max int) error {
+			headers <- blockHeaders
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	blockHeaders := <-headers
+	require.Len(t, blockHeaders, 3)
+	require.Equal(t, block3.BlockHash(), blockHeaders[2].BlockHash())
+
+	merkles := make(chan []blockchain.TXHash, 1)
+	client.GetMerkle(receive.TxHash(), 3,
+		func(merkle []blockchain.TXHash, pos int) error {
+			require.Equal(t, 1, pos)
+			merkles <- merkle
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	require.Equal(t, []blockchain.TXHash{blockchain.TXHash(block3.Transactions[0].TxHash())}, <-merkles)
+
+	fees := make(chan *btcutil.Amount, 1)
+	client.EstimateFee(2,
+		func(fee *btcutil.Amount) error {
+			fees <- fee
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	require.Equal(t, btcutil.Amount(20000), *<-fees)
+
+	// A broadcast transaction shows up in the history.
+	spendOld := payTo(wire.OutPoint{Hash: oldReceive.TxHash()}, foreignScript)
+	require.NoError(t, client.TransactionBroadcast(spendOld))
+	status = waitForStatus(t, statuses)
+	expectedHistory = append(expectedHistory,
+		&blockchain.TxInfo{Height: 0, TXHash: blockchain.TXHash(spendOld.TxHash())})
+	sortHistory(expectedHistory)
+	require.Equal(t, expectedHistory.Status(), status)
+}
+
+// TestClientFailingTx checks that a transaction which cannot be fetched does not abort the sync and
+// is fetched in a later sync.
+func TestClientFailingTx(t *testing.T) {
+	node := &fakeNode{t: t, blocks: []*wire.MsgBlock{chaincfg.RegressionNetParams.GenesisBlock}}
+	block1 := node.addBlock()
+	receive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	node.addBlock(receive)
+	spend := payTo(wire.OutPoint{Hash: receive.TxHash()}, foreignScript)
+	node.walletTxs = []walletEntry{{tx: spend}}
+	node.failingTxs = map[string]bool{spend.TxHash().String(): true}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	client := NewClient(server.Client(), server.URL, cookieFile(t, "user:password"), "bitbox", 0,
+		true, logging.Get().WithGroup("bitcoind_test"))
+	defer client.Close()
+
+	statuses := make(chan string, 10)
+	client.RegisterScript(ourScript, nil, nil)
+	client.ScriptHashSubscribe(
+		func() func(error) { return func(err error) { require.NoError(t, err) } },
+		blockchain.NewScriptHashHex(ourScript),
+		func(status string) error {
+			statuses <- status
+			return nil
+		},
+	)
+	expectedHistory := blockchain.TxHistory{
+		{Height: 2, TXHash: blockchain.TXHash(receive.TxHash())},
+	}
+	require.Equal(t, expectedHistory.Status(), waitForStatus(t, statuses))
+	require.Equal(t, blockchain.CONNECTED, client.ConnectionStatus())
+
+	node.lock.Lock()
+	node.failingTxs = nil
+	node.lock.Unlock()
+	client.kickSync()
+	expectedHistory = append(expectedHistory,
+		&blockchain.TxInfo{Height: 0, TXHash: blockchain.TXHash(spend.TxHash())})
+	require.Equal(t, expectedHistory.Status(), waitForStatus(t, statuses))
+}
+
+func TestWrongCredentials(t *testing.T) {
+	node := &fakeNode{t: t}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	client := &rpcClient{httpClient: server.Client(), url: server.URL, cookieFile: cookieFile(t, "user:wrong")}
+	err := client.call("", nil, "getblockcount")
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "credentials"))
+
+	client.cookieFile = cookieFile(t, "invalid")
+	require.Error(t, client.call("", nil, "getblockcount"))
+}
+
+// TestClientRestart checks that the scripts imported before a restart of the app are not imported
+// again, so the node does not rescan the chain on every start.
+func TestClientRestart(t *testing.T) {
+	node := &fakeNode{t: t, blocks: []*wire.MsgBlock{chaincfg.RegressionNetParams.GenesisBlock}}
+	block1 := node.addBlock()
+	receive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	node.addBlock(receive)
+	server := httptest.NewServer(node)
+	defer server.Close()
+	expectedHistory := blockchain.TxHistory{
+		{Height: 2, TXHash: blockchain.TXHash(receive.TxHash())},
+	}
+
+	for run := 0; run < 2; run++ {
+		client := NewClient(server.Client(), server.URL, cookieFile(t, "user:password"), "bitbox",
+			block1.Header.Timestamp.Unix(), false, logging.Get().WithGroup("bitcoind_test"))
+		statuses := make(chan string, 10)
+		client.RegisterScript(ourScript, nil, nil)
+		client.ScriptHashSubscribe(
+			func() func(error) { return func(err error) { require.NoError(t, err) } },
+			blockchain.NewScriptHashHex(ourScript),
+			func(status string) error {
+				statuses <- status
+				return nil
+			},
+		)
+		require.Equal(t, expectedHistory.Status(), waitForStatus(t, statuses))
+		client.Close()
+	}
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	require.Len(t, node.imported, 1)
+}
+
+// TestBirthdayRequired checks that no scripts are imported without the wallet birthday, unless the
+// user confirmed the rescan of the whole chain.
+func TestBirthdayRequired(t *testing.T) {
+	node := &fakeNode{t: t, blocks: []*wire.MsgBlock{chaincfg.RegressionNetParams.GenesisBlock}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	client := NewClient(server.Client(), server.URL, cookieFile(t, "user:password"), "bitbox", 0,
+		false, logging.Get().WithGroup("bitcoind_test"))
+	defer client.Close()
+	client.RegisterScript(ourScript, nil, nil)
+	require.Equal(t, ErrWalletBirthdayRequired, errp.Cause(client.sync()))
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	require.Empty(t, node.imported)
+}
+
+func TestIsLocal(t *testing.T) {
+	require.True(t, isLocal("http://127.0.0.1:8332"))
+	require.True(t, isLocal("http://localhost:8332"))
+	require.True(t, isLocal("http://[::1]:8332"))
+	require.False(t, isLocal("http://192.168.1.10:8332"))
+	require.False(t, isLocal("http://mynode.onion:8332"))
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// partialMerkleTree is the BIP37 partial merkle tree returned by gettxoutproof.
+type partialMerkleTree struct {
+	numTxs uint32
+	hashes []*chainhash.Hash
+	flags  []byte
+
+	hashIndex int
+	bitIndex  int
+	// nodes are the hashes of all visited nodes, by height and position.
+	nodes map[[2]int]chainhash.Hash
+	// matched is the position of the matched tx, -1 if none.
+	matched int
+}
+
+// width returns the number of nodes at the given height of the tree.
+func (tree *partialMerkleTree) width(height int) int {
+	return (int(tree.numTxs) + (1 << uint(height)) - 1) >> uint(height)
+}
+
+func (tree *partialMerkleTree) height() int {
+	height := 0
+	for tree.width(height) > 1 {
+		height++
+	}
+	return height
+}
+
+// traverse computes the hash of the node at the given height and position, consuming the flags
+// and hashes depth first.
+func (tree *partialMerkleTree) traverse(height int, pos int) (chainhash.Hash, error) {
+	if tree.bitIndex >= len(tree.flags)*8 {
+		return chainhash.Hash{}, errp.New("merkle proof: not enough flags")
+	}
+	flag := tree.flags[tree.bitIndex/8]&(1<<uint(tree.bitIndex%8)) != 0
+	tree.bitIndex++
+	var hash chainhash.Hash
+	if height == 0 || !flag {
+		if tree.hashIndex >= len(tree.hashes) {
+			return chainhash.Hash{}, errp.New("merkle proof: not enough hashes")
+		}
+		hash = *tree.hashes[tree.hashIndex]
+		tree.hashIndex++
+		if height == 0 && flag {
+			tree.matched = pos
+		}
+	} else {
+		left, err := tree.traverse(height-1, pos*2)
+		if err != nil {
+			return chainhash.Hash{}, err
+		}
+		right := left
+		if pos*2+1 < tree.width(height-1) {
+			right, err = tree.traverse(height-1, pos*2+1)
+			if err != nil {
+				return chainhash.Hash{}, err
+			}
+		}
+		hash = chainhash.DoubleHashH(append(left[:], right[:]...))
+	}
+	tree.nodes[[2]int{height, pos}] = hash
+	return hash, nil
+}
+
+// parseTxOutProof parses the result of gettxoutproof and returns the merkle branch and position of
+// the given tx in the format of Electrum's blockchain.transaction.get_merkle. The root is checked
+// against the header contained in the proof.
+func parseTxOutProof(proofHex string, txHash chainhash.Hash) ([]blockchain.TXHash, int, error) {
+	proof, err := hex.DecodeString(proofHex)
+	if err != nil {
+		return nil, 0, errp.WithStack(err)
+	}
+	merkleBlock := &wire.MsgMerkleBlock{}
+	if err := merkleBlock.BtcDecode(bytes.NewReader(proof), wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, 0, errp.WithStack(err)
+	}
+	tree := &partialMerkleTree{
+		numTxs:  merkleBlock.Transactions,
+		hashes:  merkleBlock.Hashes,
+		flags:   merkleBlock.Flags,
+		nodes:   map[[2]int]chainhash.Hash{},
+		matched: -1,
+	}
+	if tree.numTxs == 0 {
+		return nil, 0, errp.New("merkle proof: no transactions")
+	}
+	height := tree.height()
+	root, err := tree.traverse(height, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if root != merkleBlock.Header.MerkleRoot {
+		return nil, 0, errp.New("merkle proof: root mismatch")
+	}
+	if tree.matched == -1 || tree.nodes[[2]int{0, tree.matched}] != txHash {
+		return nil, 0, errp.Newf("merkle proof: transaction %s not matched", txHash)
+	}
+	branch := []blockchain.TXHash{}
+	pos := tree.matched
+	for level := 0; level < height; level++ {
+		sibling := pos ^ 1
+		if sibling >= tree.width(level) {
+			sibling = pos
+		}
+		hash, ok := tree.nodes[[2]int{level, sibling}]
+		if !ok {
+			return nil, 0, errp.New("merkle proof: incomplete branch")
+		}
+		branch = append(branch, blockchain.TXHash(hash))
+		pos >>= 1
+	}
+	return branch, tree.matched, nil
+}
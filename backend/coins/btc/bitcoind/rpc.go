@@ -0,0 +1,182 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// RPC error codes of Bitcoin Core used by the client.
+const (
+	rpcErrorWalletNotFound      = -18
+	rpcErrorWalletAlreadyLoaded = -35
+)
+
+// RPCError is an error returned by the node.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (err *RPCError) Error() string {
+	return fmt.Sprintf("bitcoind error %d: %s", err.Code, err.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// rpcCall is one call of a batch. The result is unmarshalled into response.
+type rpcCall struct {
+	method   string
+	params   []interface{}
+	response interface{}
+	err      error
+}
+
+// rpcClient talks to the JSON-RPC interface of Bitcoin Core over HTTP.
+type rpcClient struct {
+	httpClient *http.Client
+	url        string
+	cookieFile string
+	nextID     uint64
+}
+
+// credentials reads the RPC credentials from the cookie file of the node. The file is read for
+// every request, as the node writes a new cookie whenever it starts.
+func (client *rpcClient) credentials() (string, string, error) {
+	cookie, err := ioutil.ReadFile(client.cookieFile)
+	if err != nil {
+		return "", "", errp.WithStack(err)
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", errp.New("bitcoind: invalid cookie file")
+	}
+	return parts[0], parts[1], nil
+}
+
+func (client *rpcClient) post(path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, client.url+path, bytes.NewReader(body))
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	user, password, err := client.credentials()
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.SetBasicAuth(user, password)
+	httpResponse, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	defer func() { _ = httpResponse.Body.Close() }()
+	responseBody, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if httpResponse.StatusCode == http.StatusUnauthorized {
+		return errp.New("bitcoind: wrong RPC credentials")
+	}
+	// Bitcoin Core responds with error status codes for RPC errors, with the error in the body.
+	if err := json.Unmarshal(responseBody, response); err != nil {
+		return errp.Newf("bitcoind: unexpected response with status %d", httpResponse.StatusCode)
+	}
+	return nil
+}
+
+func walletPath(wallet string) string {
+	if wallet == "" {
+		return ""
+	}
+	return "/wallet/" + url.PathEscape(wallet)
+}
+
+// call calls a method of the node, or of the given wallet if not empty.
+func (client *rpcClient) call(wallet string, response interface{}, method string, params ...interface{}) error {
+	calls := []*rpcCall{{method: method, params: params, response: response}}
+	if err := client.batch(wallet, calls); err != nil {
+		return err
+	}
+	return calls[0].err
+}
+
+// batch sends all calls in one request. Errors of the individual calls are stored in the calls.
+func (client *rpcClient) batch(wallet string, calls []*rpcCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	requests := make([]rpcRequest, len(calls))
+	callsByID := map[uint64]*rpcCall{}
+	for index, call := range calls {
+		id := atomic.AddUint64(&client.nextID, 1)
+		params := call.params
+		if params == nil {
+			params = []interface{}{}
+		}
+		requests[index] = rpcRequest{JSONRPC: "1.0", ID: id, Method: call.method, Params: params}
+		callsByID[id] = call
+		call.err = errp.Newf("bitcoind: no response to %s", call.method)
+	}
+	var responses []rpcResponse
+	if err := client.post(walletPath(wallet), requests, &responses); err != nil {
+		return err
+	}
+	for _, response := range responses {
+		call, ok := callsByID[response.ID]
+		if !ok {
+			continue
+		}
+		switch {
+		case response.Error != nil:
+			call.err = response.Error
+		case call.response == nil:
+			call.err = nil
+		default:
+			call.err = errp.WithStack(json.Unmarshal(response.Result, call.response))
+		}
+	}
+	return nil
+}
+
+// isRPCError returns true if the error is an RPC error with the given code.
+func isRPCError(err error, code int) bool {
+	rpcErr, ok := errp.Cause(err).(*RPCError)
+	return ok && rpcErr.Code == code
+}
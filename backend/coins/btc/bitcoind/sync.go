@@ -0,0 +1,358 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bitcoind
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ErrWalletBirthdayRequired is returned if scripts should be imported while the wallet birthday is
+// unknown and the user did not confirm to rescan the whole chain.
+var ErrWalletBirthdayRequired = errp.New(
+	"bitcoind: the wallet birthday must be configured, or a rescan from the genesis block confirmed")
+
+// sync imports the new scripts and fetches the new blocks and transactions of the wallet.
+func (client *Client) sync() error {
+	if err := client.loadWallet(); err != nil {
+		return err
+	}
+	if err := client.importScripts(); err != nil {
+		return err
+	}
+	if err := client.updateTip(); err != nil {
+		return err
+	}
+	return client.updateTxs()
+}
+
+// loadWallet loads the watch-only wallet, creating it if it does not exist.
+func (client *Client) loadWallet() error {
+	unlock := client.lock.RLock()
+	walletLoaded := client.walletLoaded
+	unlock()
+	if walletLoaded {
+		return nil
+	}
+	var wallets []string
+	if err := client.rpc.call("", &wallets, "listwallets"); err != nil {
+		return err
+	}
+	found := false
+	for _, wallet := range wallets {
+		found = found || wallet == client.wallet
+	}
+	if !found {
+		err := client.rpc.call("", nil, "loadwallet", client.wallet)
+		if isRPCError(err, rpcErrorWalletNotFound) {
+			client.log.Infof("Creating the watch-only wallet %s", client.wallet)
+			// Arguments: name, disable_private_keys, blank, passphrase, avoid_reuse, descriptors.
+			err = client.rpc.call("", nil, "createwallet", client.wallet, true, true, "", false, true)
+		}
+		if err != nil && !isRPCError(err, rpcErrorWalletAlreadyLoaded) {
+			return err
+		}
+	}
+	imported, err := client.walletScripts()
+	if err != nil {
+		return err
+	}
+	defer client.lock.Lock()()
+	for scriptHash := range imported {
+		client.imported[scriptHash] = true
+	}
+	client.walletLoaded = true
+	return nil
+}
+
+// walletScripts returns the scripts which were imported into the wallet as raw descriptors.
+func (client *Client) walletScripts() (map[blockchain.ScriptHashHex]struct{}, error) {
+	var response struct {
+		Descriptors []struct {
+			Descriptor string `json:"desc"`
+		} `json:"descriptors"`
+	}
+	if err := client.rpc.call(client.wallet, &response, "listdescriptors"); err != nil {
+		return nil, err
+	}
+	scripts := map[blockchain.ScriptHashHex]struct{}{}
+	for _, descriptor := range response.Descriptors {
+		// The descriptors look like raw(<script hex>)#<checksum>.
+		end := strings.Index(descriptor.Descriptor, ")")
+		if !strings.HasPrefix(descriptor.Descriptor, "raw(") || end == -1 {
+			continue
+		}
+		pkScript, err := hex.DecodeString(descriptor.Descriptor[len("raw("):end])
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		scripts[blockchain.NewScriptHashHex(pkScript)] = struct{}{}
+	}
+	return scripts, nil
+}
+
+// importScripts imports the registered scripts into the wallet. The node rescans the blocks since
+// the wallet birthday for their transactions before importdescriptors returns.
+func (client *Client) importScripts() error {
+	var pkScripts [][]byte
+	func() {
+		defer client.lock.RLock()()
+		for scriptHash, s := range client.scripts.All() {
+			if !client.imported[scriptHash] {
+				pkScripts = append(pkScripts, s.PkScript)
+			}
+		}
+	}()
+	if len(pkScripts) == 0 {
+		return nil
+	}
+	if client.birthday == 0 && !client.rescanFromGenesis {
+		return errp.WithStack(ErrWalletBirthdayRequired)
+	}
+
+	// importdescriptors requires the descriptor checksum.
+	descriptorInfos := make([]struct {
+		Descriptor string `json:"descriptor"`
+	}, len(pkScripts))
+	calls := make([]*rpcCall, len(pkScripts))
+	for index, pkScript := range pkScripts {
+		calls[index] = &rpcCall{
+			method:   "getdescriptorinfo",
+			params:   []interface{}{"raw(" + hex.EncodeToString(pkScript) + ")"},
+			response: &descriptorInfos[index],
+		}
+	}
+	if err := client.rpc.batch("", calls); err != nil {
+		return err
+	}
+	type importRequest struct {
+		Descriptor string `json:"desc"`
+		Timestamp  int64  `json:"timestamp"`
+	}
+	importRequests := make([]importRequest, len(pkScripts))
+	for index, call := range calls {
+		if call.err != nil {
+			return call.err
+		}
+		importRequests[index] = importRequest{
+			Descriptor: descriptorInfos[index].Descriptor,
+			Timestamp:  client.birthday,
+		}
+	}
+
+	client.log.Infof("Importing %d scripts, rescanning from %d", len(pkScripts), client.birthday)
+	var importResults []struct {
+		Success bool      `json:"success"`
+		Error   *RPCError `json:"error"`
+	}
+	if err := client.rpc.call(client.wallet, &importResults, "importdescriptors", importRequests); err != nil {
+		return err
+	}
+	for _, result := range importResults {
+		if !result.Success {
+			if result.Error != nil {
+				return result.Error
+			}
+			return errp.New("bitcoind: could not import descriptor")
+		}
+	}
+
+	defer client.lock.Lock()()
+	for _, pkScript := range pkScripts {
+		client.imported[blockchain.NewScriptHashHex(pkScript)] = true
+	}
+	return nil
+}
+
+// updateTip fetches the height of the best chain and notifies the headers subscribers if it
+// changed.
+func (client *Client) updateTip() error {
+	var tip int
+	if err := client.rpc.call("", &tip, "getblockcount"); err != nil {
+		return err
+	}
+	defer client.lock.Lock()()
+	if tip == client.tip {
+		return nil
+	}
+	client.tip = tip
+	header := &blockchain.Header{BlockHeight: tip}
+	for _, callback := range client.headersSubscriptions {
+		go func(callback func(*blockchain.Header) error) {
+			if err := callback(header); err != nil {
+				client.log.WithError(err).Error("Could not handle the new tip")
+			}
+		}(callback)
+	}
+	return nil
+}
+
+// walletTxHeights returns the transactions of the wallet with the heights of the blocks containing
+// them, 0 if unconfirmed.
+func (client *Client) walletTxHeights() (map[chainhash.Hash]int, error) {
+	var entries []struct {
+		TXID          string `json:"txid"`
+		Confirmations int    `json:"confirmations"`
+		BlockHeight   int    `json:"blockheight"`
+	}
+	err := client.rpc.call(client.wallet, &entries, "listtransactions", "*", maxListTransactions, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	heights := map[chainhash.Hash]int{}
+	for _, entry := range entries {
+		// Negative confirmations mean the tx conflicts with a confirmed tx.
+		if entry.Confirmations < 0 {
+			continue
+		}
+		txHash, err := chainhash.NewHashFromStr(entry.TXID)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		height := 0
+		if entry.Confirmations > 0 {
+			height = entry.BlockHeight
+		}
+		heights[*txHash] = height
+	}
+	return heights, nil
+}
+
+// updateTxs fetches the transactions of the wallet and updates the histories of the scripts. The
+// transactions are fetched from the wallet, so no transaction index is needed. Transactions which
+// cannot be fetched are left out and retried in the next sync.
+func (client *Client) updateTxs() error {
+	heights, err := client.walletTxHeights()
+	if err != nil {
+		return err
+	}
+	var missing []chainhash.Hash
+	func() {
+		defer client.lock.RLock()()
+		for txHash := range heights {
+			if _, ok := client.txs[txHash]; !ok {
+				missing = append(missing, txHash)
+			}
+		}
+	}()
+	walletTxs := make([]struct {
+		Hex string `json:"hex"`
+	}, len(missing))
+	calls := make([]*rpcCall, len(missing))
+	for index, txHash := range missing {
+		calls[index] = &rpcCall{
+			method: "gettransaction",
+			// Arguments: txid, include_watchonly.
+			params:   []interface{}{txHash.String(), true},
+			response: &walletTxs[index],
+		}
+	}
+	if err := client.rpc.batch(client.wallet, calls); err != nil {
+		return err
+	}
+	fetched := map[chainhash.Hash]*wire.MsgTx{}
+	for index, call := range calls {
+		txHash := missing[index]
+		if call.err != nil {
+			client.log.WithError(call.err).Warningf("Could not fetch the transaction %s", txHash)
+			continue
+		}
+		tx, err := parseTX(walletTxs[index].Hex)
+		if err != nil {
+			client.log.WithError(err).Warningf("Could not parse the transaction %s", txHash)
+			continue
+		}
+		fetched[txHash] = tx
+	}
+
+	defer client.lock.Lock()()
+	txs := map[chainhash.Hash]*walletTx{}
+	for txHash, height := range heights {
+		tx, ok := fetched[txHash]
+		if !ok {
+			existing, ok := client.txs[txHash]
+			if !ok {
+				continue
+			}
+			tx = existing.tx
+		}
+		txs[txHash] = &walletTx{tx: tx, height: height}
+	}
+	client.txs = txs
+	client.updateHistories()
+	client.notifyScripts()
+	return nil
+}
+
+// sortHistory orders confirmed transactions by height, followed by the unconfirmed transactions.
+// Transactions of the same height are ordered by hash, so the status is deterministic.
+func sortHistory(history blockchain.TxHistory) {
+	sort.Slice(history, func(i, j int) bool {
+		heightI, heightJ := history[i].Height, history[j].Height
+		if (heightI > 0) != (heightJ > 0) {
+			return heightI > 0
+		}
+		if heightI != heightJ {
+			return heightI < heightJ
+		}
+		return history[i].TXHash.Hash().String() < history[j].TXHash.Hash().String()
+	})
+}
+
+// updateHistories computes the histories of the scripts from the transactions. Requires the lock.
+func (client *Client) updateHistories() {
+	outPoints := map[wire.OutPoint]blockchain.ScriptHashHex{}
+	for txHash, walletTx := range client.txs {
+		for index, txOut := range walletTx.tx.TxOut {
+			scriptHash := blockchain.NewScriptHashHex(txOut.PkScript)
+			if client.scripts.Get(scriptHash) != nil {
+				outPoints[wire.OutPoint{Hash: txHash, Index: uint32(index)}] = scriptHash
+			}
+		}
+	}
+	histories := map[blockchain.ScriptHashHex]blockchain.TxHistory{}
+	for txHash, walletTx := range client.txs {
+		touched := map[blockchain.ScriptHashHex]struct{}{}
+		for _, txIn := range walletTx.tx.TxIn {
+			if scriptHash, ok := outPoints[txIn.PreviousOutPoint]; ok {
+				touched[scriptHash] = struct{}{}
+			}
+		}
+		for _, txOut := range walletTx.tx.TxOut {
+			scriptHash := blockchain.NewScriptHashHex(txOut.PkScript)
+			if client.scripts.Get(scriptHash) != nil {
+				touched[scriptHash] = struct{}{}
+			}
+		}
+		for scriptHash := range touched {
+			histories[scriptHash] = append(histories[scriptHash],
+				&blockchain.TxInfo{Height: walletTx.height, TXHash: blockchain.TXHash(txHash)})
+		}
+	}
+	for scriptHash, s := range client.scripts.All() {
+		history := histories[scriptHash]
+		sortHistory(history)
+		if history == nil {
+			history = blockchain.TxHistory{}
+		}
+		s.History = history
+	}
+}
@@ -112,3 +112,25 @@ type Interface interface {
 	ConnectionStatus() Status
 	RegisterOnConnectionStatusChangedEvent(func(Status))
 }
+
+// ScanState is the progress of a ScriptRegistry scanning the blocks for a script. It is persisted
+// by the account, so that the scan resumes where it left off after a restart.
+type ScanState struct {
+	// ScannedHeight is the height up to which the blocks were scanned, -1 if none were.
+	ScannedHeight int `json:"scannedHeight"`
+	// History is the history of the script found up to ScannedHeight.
+	History TxHistory `json:"history"`
+	// Txs are the transactions of the history, needed to find the transactions spending the
+	// outputs of the script.
+	Txs []*wire.MsgTx `json:"txs"`
+}
+
+// ScriptRegistry is implemented by backends which match the scripts locally instead of querying a
+// server by script hash, and therefore need the scripts themselves. Scripts must be registered
+// before subscribing to their script hash.
+type ScriptRegistry interface {
+	// RegisterScript registers the script. state is the scan state persisted before, nil if there
+	// is none. onScanned is called with the new scan state when the scan advanced, so that it can be
+	// persisted. Backends which do not scan the blocks themselves ignore both.
+	RegisterScript(pkScript []byte, state *ScanState, onScanned func(*ScanState))
+}
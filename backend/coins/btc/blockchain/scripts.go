@@ -0,0 +1,143 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// NewScriptHashHex returns the hash of the pkScript by which it is referred to in Interface.
+func NewScriptHashHex(pkScript []byte) ScriptHashHex {
+	return ScriptHashHex(chainhash.HashH(pkScript).String())
+}
+
+// Script is a pkScript registered with a ScriptRegistry.
+type Script struct {
+	PkScript []byte
+	// History contains the transactions touching the script.
+	History TxHistory
+	// status is the last status sent to the subscribers.
+	status        string
+	subscriptions []*scriptSubscription
+}
+
+type scriptSubscription struct {
+	// done is called after the first status was sent.
+	done     func(error)
+	success  func(string) error
+	notified bool
+}
+
+// Scripts keeps the scripts registered with a ScriptRegistry, their histories and the subscriptions
+// to their status, so backends implementing ScriptRegistry only need to fill in the histories. It
+// is not safe for concurrent use, the backend must guard it with its lock.
+type Scripts struct {
+	scripts map[ScriptHashHex]*Script
+	log     *logrus.Entry
+}
+
+// NewScripts creates an empty set of scripts.
+func NewScripts(log *logrus.Entry) *Scripts {
+	return &Scripts{
+		scripts: map[ScriptHashHex]*Script{},
+		log:     log,
+	}
+}
+
+// Register adds the script with an empty history. Returns false if it was already registered.
+func (scripts *Scripts) Register(pkScript []byte) bool {
+	scriptHashHex := NewScriptHashHex(pkScript)
+	if _, ok := scripts.scripts[scriptHashHex]; ok {
+		return false
+	}
+	scripts.scripts[scriptHashHex] = &Script{PkScript: pkScript, History: TxHistory{}}
+	return true
+}
+
+// Get returns the registered script, or nil if it is not registered.
+func (scripts *Scripts) Get(scriptHashHex ScriptHashHex) *Script {
+	return scripts.scripts[scriptHashHex]
+}
+
+// All returns the registered scripts by their hash. The map must not be modified.
+func (scripts *Scripts) All() map[ScriptHashHex]*Script {
+	return scripts.scripts
+}
+
+// GetHistory implements Interface.ScriptHashGetHistory. A copy of the history is passed to
+// success asynchronously.
+func (scripts *Scripts) GetHistory(
+	scriptHashHex ScriptHashHex,
+	success func(TxHistory) error,
+	cleanup func(error),
+) {
+	s, ok := scripts.scripts[scriptHashHex]
+	if !ok {
+		go cleanup(errp.Newf("unknown script hash %s", scriptHashHex))
+		return
+	}
+	history := make(TxHistory, len(s.History))
+	for index, entry := range s.History {
+		entryCopy := *entry
+		history[index] = &entryCopy
+	}
+	go func() {
+		cleanup(success(history))
+	}()
+}
+
+// Subscribe adds a subscription to the status of the script, see Interface.ScriptHashSubscribe.
+// done is called after the first status was sent by Notify(), or with an error if the script is
+// not registered.
+func (scripts *Scripts) Subscribe(
+	done func(error),
+	scriptHashHex ScriptHashHex,
+	success func(string) error,
+) {
+	s, ok := scripts.scripts[scriptHashHex]
+	if !ok {
+		go done(errp.Newf("script hash %s must be registered first", scriptHashHex))
+		return
+	}
+	s.subscriptions = append(s.subscriptions, &scriptSubscription{done: done, success: success})
+}
+
+// Notify sends the status of the scripts for which ready returns true to the subscribers, if it
+// changed since it was last sent.
+func (scripts *Scripts) Notify(ready func(ScriptHashHex) bool) {
+	for scriptHashHex, s := range scripts.scripts {
+		if !ready(scriptHashHex) {
+			continue
+		}
+		status := s.History.Status()
+		for _, subscription := range s.subscriptions {
+			if subscription.notified && status == s.status {
+				continue
+			}
+			go func(subscription *scriptSubscription, notified bool) {
+				err := subscription.success(status)
+				if !notified {
+					subscription.done(err)
+				} else if err != nil {
+					scripts.log.WithError(err).Error("Could not handle the script status")
+				}
+			}(subscription, subscription.notified)
+			subscription.notified = true
+		}
+		s.status = status
+	}
+}
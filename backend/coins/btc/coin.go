@@ -21,15 +21,21 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcutil"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/bitcoind"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/db/headersdb"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/pool"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/p2p"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
@@ -41,30 +47,43 @@ import (
 
 // Coin models a Bitcoin-related coin.
 type Coin struct {
-	initOnce              sync.Once
-	code                  string
-	unit                  string
-	net                   *chaincfg.Params
-	dbFolder              string
-	servers               []*rpc.ServerInfo
+	initOnce        sync.Once
+	code            string
+	unit            string
+	net             *chaincfg.Params
+	dbFolder        string
+	servers         []*rpc.ServerInfo
+	blockFilterPeer string
+	bitcoinCore     *rpc.BitcoinCoreInfo
+	// walletBirthday is the Unix time at which the wallets were created, 0 if unknown.
+	walletBirthday        int64
 	blockExplorerTxPrefix string
 	socksProxy            socksproxy.SocksProxy
 
 	observable.Implementation
 
 	blockchain blockchain.Interface
-	headers    *headers.Headers
+	// pool is the Electrum server pool if several servers are configured, nil otherwise.
+	pool    *pool.Pool
+	headers *headers.Headers
 
 	log *logrus.Entry
 }
 
-// NewCoin creates a new coin with the given parameters.
+// NewCoin creates a new coin with the given parameters. If bitcoinCore is not nil, the blockchain
+// is accessed through the RPC interface of that node. Otherwise, if blockFilterPeer is not empty,
+// the blockchain is accessed through that node using compact block filters instead of the servers.
+// walletBirthday is the Unix time at which the wallets were created, 0 if unknown, so that older
+// blocks don't need to be scanned.
 func NewCoin(
 	code string,
 	unit string,
 	net *chaincfg.Params,
 	dbFolder string,
 	servers []*rpc.ServerInfo,
+	blockFilterPeer string,
+	bitcoinCore *rpc.BitcoinCoreInfo,
+	walletBirthday int64,
 	blockExplorerTxPrefix string,
 	socksProxy socksproxy.SocksProxy,
 ) *Coin {
@@ -74,6 +93,9 @@ func NewCoin(
 		net:                   net,
 		dbFolder:              dbFolder,
 		servers:               servers,
+		blockFilterPeer:       blockFilterPeer,
+		bitcoinCore:           bitcoinCore,
+		walletBirthday:        walletBirthday,
 		blockExplorerTxPrefix: blockExplorerTxPrefix,
 		socksProxy:            socksProxy,
 
@@ -86,7 +108,37 @@ func NewCoin(
 func (coin *Coin) Initialize() {
 	coin.initOnce.Do(func() {
 		// Init blockchain
-		coin.blockchain = electrum.NewElectrumConnection(coin.servers, coin.log, coin.socksProxy)
+		switch {
+		case coin.bitcoinCore != nil:
+			var err error
+			coin.blockchain, err = bitcoind.NewBitcoindConnection(
+				coin.bitcoinCore, coin.walletBirthday, coin.log, coin.socksProxy)
+			if err != nil {
+				coin.log.WithError(err).Panic("Could not connect to Bitcoin Core")
+			}
+		case coin.blockFilterPeer != "":
+			var birthday time.Time
+			if coin.walletBirthday != 0 {
+				birthday = time.Unix(coin.walletBirthday, 0)
+			}
+			var err error
+			coin.blockchain, err = p2p.NewP2PConnection(
+				coin.blockFilterPeer,
+				coin.net,
+				path.Join(coin.dbFolder, fmt.Sprintf("p2p-headers-%s.bin", coin.code)),
+				path.Join(coin.dbFolder, fmt.Sprintf("p2p-filterheaders-%s.bin", coin.code)),
+				birthday,
+				coin.log,
+				coin.socksProxy)
+			if err != nil {
+				coin.log.WithError(err).Panic("Could not open the compact block filter DB")
+			}
+		case len(coin.servers) > 1:
+			coin.pool = electrum.NewElectrumPool(coin.servers, coin.log, coin.socksProxy)
+			coin.blockchain = coin.pool
+		default:
+			coin.blockchain = electrum.NewElectrumConnection(coin.servers, coin.log, coin.socksProxy)
+		}
 
 		// Init Headers
 
@@ -101,14 +153,23 @@ func (coin *Coin) Initialize() {
 		if err != nil {
 			coin.log.WithError(err).Panic("Could not open headers DB")
 		}
+		// With several servers, each one independently cross-validates the headers. The pool
+		// clients include the server the headers are synced from, which is counted once.
+		validators := []blockchain.Interface{}
+		if coin.pool != nil {
+			validators = coin.pool.Clients()
+		}
 		coin.headers = headers.NewHeaders(
 			coin.net,
 			db,
 			coin.blockchain,
+			validators,
 			coin.log)
 		coin.headers.Initialize()
 		coin.headers.SubscribeEvent(func(event headers.Event) {
-			if event == headers.EventSyncing || event == headers.EventSynced {
+			switch event {
+			case headers.EventSyncing, headers.EventSynced, headers.EventServersDisagree,
+				headers.EventTipAgreed, headers.EventTipUnvalidated:
 				status, err := coin.headers.Status()
 				if err != nil {
 					coin.log.WithError(err).Error("Could not get headers status")
@@ -166,6 +227,15 @@ func (coin *Coin) Headers() *headers.Headers {
 	return coin.headers
 }
 
+// ServersStatus returns the health of the Electrum servers if several servers are used, and an
+// empty list otherwise.
+func (coin *Coin) ServersStatus() []*pool.ServerStatus {
+	if coin.pool == nil {
+		return []*pool.ServerStatus{}
+	}
+	return coin.pool.Status()
+}
+
 func (coin *Coin) String() string {
 	return coin.code
 }
@@ -190,10 +260,38 @@ func (coin *Coin) SmallestUnit() string {
 func (coin *Coin) DecodeAddress(address string) (btcutil.Address, error) {
 	btcAddress, err := btcutil.DecodeAddress(address, coin.Net())
 	if err != nil {
-		return nil, errp.WithStack(errors.ErrInvalidAddress)
+		// The vendored btcutil does not know about bech32m encoded taproot addresses.
+		taprootAddress, taprootErr := taproot.DecodeAddress(address, coin.Net())
+		if taprootErr != nil {
+			return nil, errp.WithStack(errors.ErrInvalidAddress)
+		}
+		btcAddress = taprootAddress
 	}
 	if !btcAddress.IsForNet(coin.Net()) {
 		return nil, errp.WithStack(errors.ErrInvalidAddress)
 	}
 	return btcAddress, nil
 }
+
+// AddressScriptType decodes the address and returns its script type. P2SH addresses are assumed to
+// be wrapped segwit, as the script behind the hash is unknown.
+func (coin *Coin) AddressScriptType(address string) (signing.ScriptType, error) {
+	btcAddress, err := coin.DecodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	switch btcAddress.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return signing.ScriptTypeP2PKH, nil
+	case *btcutil.AddressScriptHash:
+		return signing.ScriptTypeP2WPKHP2SH, nil
+	case *btcutil.AddressWitnessPubKeyHash:
+		return signing.ScriptTypeP2WPKH, nil
+	case *btcutil.AddressWitnessScriptHash:
+		return signing.ScriptTypeP2WSH, nil
+	case *taproot.AddressTaproot:
+		return signing.ScriptTypeP2TR, nil
+	default:
+		return "", errp.WithStack(errors.ErrInvalidAddress)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressScriptType(t *testing.T) {
+	coin := btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+	for _, test := range []struct {
+		address    string
+		scriptType signing.ScriptType
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", signing.ScriptTypeP2PKH},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", signing.ScriptTypeP2WPKHP2SH},
+		{"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", signing.ScriptTypeP2WPKH},
+		{"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", signing.ScriptTypeP2WSH},
+		{"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr", signing.ScriptTypeP2TR},
+	} {
+		scriptType, err := coin.AddressScriptType(test.address)
+		require.NoError(t, err, test.address)
+		require.Equal(t, test.scriptType, scriptType)
+	}
+	// Testnet addresses are rejected.
+	_, err := coin.AddressScriptType("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx")
+	require.Error(t, err)
+}
+
+// TestDescriptorAddress checks that addr() descriptors round-trip.
+func TestDescriptorAddress(t *testing.T) {
+	coin := btc.NewCoin("btc", "BTC", &chaincfg.MainNetParams, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+	address := "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"
+	configuration := signing.NewAddressConfiguration(
+		signing.ScriptTypeP2TR, signing.NewEmptyAbsoluteKeypath(), address)
+	descriptor, err := configuration.Descriptor(coin.Net())
+	require.NoError(t, err)
+	decoded, err := signing.NewConfigurationFromDescriptor(descriptor, coin.Net(), coin.AddressScriptType)
+	require.NoError(t, err)
+	require.Equal(t, configuration.Hash(), decoded.Hash())
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/util"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 )
 
@@ -33,20 +34,23 @@ const (
 	bucketInputs                 = "inputs"
 	bucketOutputs                = "outputs"
 	bucketAddressHistories       = "addressHistories"
+	bucketScanStates             = "scanStates"
 )
 
 // DB is a bbolt key/value database.
 type DB struct {
-	db *bbolt.DB
+	db            *bbolt.DB
+	encryptionKey *encryption.Key
 }
 
-// NewDB creates/opens a new db.
-func NewDB(filename string) (*DB, error) {
+// NewDB creates/opens a new db. If the key is not nil, the records of all buckets are encrypted
+// with it, see encryption.Key.EncryptRecord.
+func NewDB(filename string, encryptionKey *encryption.Key) (*DB, error) {
 	db, err := bbolt.Open(filename, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: db}, nil
+	return &DB{db: db, encryptionKey: encryptionKey}, nil
 }
 
 // Begin implements transactions.Begin.
@@ -75,13 +79,19 @@ func (db *DB) Begin() (transactions.DBTxInterface, error) {
 	if err != nil {
 		return nil, err
 	}
+	bucketScanStates, err := tx.CreateBucketIfNotExists([]byte(bucketScanStates))
+	if err != nil {
+		return nil, err
+	}
 	return &Tx{
 		tx:                           tx,
+		encryptionKey:                db.encryptionKey,
 		bucketTransactions:           bucketTransactions,
 		bucketUnverifiedTransactions: bucketUnverifiedTransactions,
 		bucketInputs:                 bucketInputs,
 		bucketOutputs:                bucketOutputs,
 		bucketAddressHistories:       bucketAddressHistories,
+		bucketScanStates:             bucketScanStates,
 	}, nil
 }
 
@@ -92,13 +102,15 @@ func (db *DB) Close() error {
 
 // Tx implements transactions.DBTxInterface.
 type Tx struct {
-	tx *bbolt.Tx
+	tx            *bbolt.Tx
+	encryptionKey *encryption.Key
 
 	bucketTransactions           *bbolt.Bucket
 	bucketUnverifiedTransactions *bbolt.Bucket
 	bucketInputs                 *bbolt.Bucket
 	bucketOutputs                *bbolt.Bucket
 	bucketAddressHistories       *bbolt.Bucket
+	bucketScanStates             *bbolt.Bucket
 }
 
 // Rollback implements transactions.DBTxInterface.
@@ -128,34 +140,78 @@ func newWalletTransaction() *walletTransaction {
 	}
 }
 
-func readJSON(bucket *bbolt.Bucket, key []byte, value interface{}) (bool, error) {
-	if jsonBytes := bucket.Get(key); jsonBytes != nil {
+// get returns the decrypted value of the record with the given id, or nil if it does not exist.
+func (tx *Tx) get(bucket *bbolt.Bucket, id []byte) ([]byte, error) {
+	dbKey := tx.encryptionKey.HashID(id)
+	dbValue := bucket.Get(dbKey)
+	if dbValue == nil {
+		return nil, nil
+	}
+	_, value, err := tx.encryptionKey.DecryptRecord(dbKey, dbValue)
+	return value, err
+}
+
+// put encrypts and stores the record.
+func (tx *Tx) put(bucket *bbolt.Bucket, id []byte, value []byte) error {
+	dbKey, dbValue, err := tx.encryptionKey.EncryptRecord(id, value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(dbKey, dbValue)
+}
+
+// delete removes the record with the given id.
+func (tx *Tx) delete(bucket *bbolt.Bucket, id []byte) error {
+	return errp.WithStack(bucket.Delete(tx.encryptionKey.HashID(id)))
+}
+
+// forEach calls f with the id and the decrypted value of each record.
+func (tx *Tx) forEach(bucket *bbolt.Bucket, f func(id []byte, value []byte) error) error {
+	cursor := bucket.Cursor()
+	for dbKey, dbValue := cursor.First(); dbKey != nil; dbKey, dbValue = cursor.Next() {
+		id, value, err := tx.encryptionKey.DecryptRecord(dbKey, dbValue)
+		if err != nil {
+			return err
+		}
+		if err := f(id, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) readJSON(bucket *bbolt.Bucket, key []byte, value interface{}) (bool, error) {
+	jsonBytes, err := tx.get(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	if jsonBytes != nil {
 		return true, errp.WithStack(json.Unmarshal(jsonBytes, value))
 	}
 	return false, nil
 }
 
-func writeJSON(bucket *bbolt.Bucket, key []byte, value interface{}) error {
+func (tx *Tx) writeJSON(bucket *bbolt.Bucket, key []byte, value interface{}) error {
 	jsonBytes, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
-	return bucket.Put(key, jsonBytes)
+	return tx.put(bucket, key, jsonBytes)
 }
 
 func (tx *Tx) modifyTx(key []byte, f func(value *walletTransaction)) error {
 	walletTx := newWalletTransaction()
-	if _, err := readJSON(tx.bucketTransactions, key, walletTx); err != nil {
+	if _, err := tx.readJSON(tx.bucketTransactions, key, walletTx); err != nil {
 		return err
 	}
 	f(walletTx)
-	return writeJSON(tx.bucketTransactions, key, walletTx)
+	return tx.writeJSON(tx.bucketTransactions, key, walletTx)
 }
 
 // TxInfo implements transactions.DBTxInterface.
 func (tx *Tx) TxInfo(txHash chainhash.Hash) (*wire.MsgTx, []string, int, *time.Time, error) {
 	walletTx := newWalletTransaction()
-	if _, err := readJSON(tx.bucketTransactions, txHash[:], walletTx); err != nil {
+	if _, err := tx.readJSON(tx.bucketTransactions, txHash[:], walletTx); err != nil {
 		return nil, nil, 0, nil, err
 	}
 	addresses := []string{}
@@ -177,7 +233,7 @@ func (tx *Tx) PutTx(txHash chainhash.Hash, msgTx *wire.MsgTx, height int) error
 		return err
 	}
 	if verified == nil {
-		return tx.bucketUnverifiedTransactions.Put(txHash[:], nil)
+		return tx.put(tx.bucketUnverifiedTransactions, txHash[:], nil)
 	}
 	return nil
 }
@@ -185,8 +241,8 @@ func (tx *Tx) PutTx(txHash chainhash.Hash, msgTx *wire.MsgTx, height int) error
 // DeleteTx implements transactions.DBTxInterface. It panics if called from a read-only db
 // transaction.
 func (tx *Tx) DeleteTx(txHash chainhash.Hash) {
-	if err := tx.bucketTransactions.Delete(txHash[:]); err != nil {
-		panic(errp.WithStack(err))
+	if err := tx.delete(tx.bucketTransactions, txHash[:]); err != nil {
+		panic(err)
 	}
 }
 
@@ -207,33 +263,36 @@ func (tx *Tx) RemoveAddressFromTx(txHash chainhash.Hash, scriptHashHex blockchai
 	return empty, err
 }
 
-func getTransactions(bucket *bbolt.Bucket) ([]chainhash.Hash, error) {
+func (tx *Tx) getTransactions(bucket *bbolt.Bucket) ([]chainhash.Hash, error) {
 	result := []chainhash.Hash{}
-	cursor := bucket.Cursor()
-	for txHashBytes, _ := cursor.First(); txHashBytes != nil; txHashBytes, _ = cursor.Next() {
+	err := tx.forEach(bucket, func(txHashBytes []byte, _ []byte) error {
 		var txHash chainhash.Hash
 		if err := txHash.SetBytes(txHashBytes); err != nil {
-			return nil, errp.WithStack(err)
+			return errp.WithStack(err)
 		}
 		result = append(result, txHash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return result, nil
 }
 
 // Transactions implements transactions.DBTxInterface.
 func (tx *Tx) Transactions() ([]chainhash.Hash, error) {
-	return getTransactions(tx.bucketTransactions)
+	return tx.getTransactions(tx.bucketTransactions)
 }
 
 // UnverifiedTransactions implements transactions.DBTxInterface.
 func (tx *Tx) UnverifiedTransactions() ([]chainhash.Hash, error) {
-	return getTransactions(tx.bucketUnverifiedTransactions)
+	return tx.getTransactions(tx.bucketUnverifiedTransactions)
 }
 
 // MarkTxVerified implements transactions.DBTxInterface.
 func (tx *Tx) MarkTxVerified(txHash chainhash.Hash, headerTimestamp time.Time) error {
-	if err := tx.bucketUnverifiedTransactions.Delete(txHash[:]); err != nil {
-		panic(errp.WithStack(err))
+	if err := tx.delete(tx.bucketUnverifiedTransactions, txHash[:]); err != nil {
+		panic(err)
 	}
 	return tx.modifyTx(txHash[:], func(walletTx *walletTransaction) {
 		truth := true
@@ -242,36 +301,80 @@ func (tx *Tx) MarkTxVerified(txHash chainhash.Hash, headerTimestamp time.Time) e
 	})
 }
 
-// PutInput implements transactions.DBTxInterface.
+// PutInput implements transactions.DBTxInterface. The hashes of all spending transactions are
+// stored concatenated.
 func (tx *Tx) PutInput(outPoint wire.OutPoint, txHash chainhash.Hash) error {
-	return tx.bucketInputs.Put([]byte(outPoint.String()), txHash[:])
+	txHashes, err := tx.Inputs(outPoint)
+	if err != nil {
+		return err
+	}
+	for _, existingTxHash := range txHashes {
+		if existingTxHash == txHash {
+			return nil
+		}
+	}
+	return tx.putInputs(outPoint, append(txHashes, txHash))
+}
+
+func (tx *Tx) putInputs(outPoint wire.OutPoint, txHashes []chainhash.Hash) error {
+	key := []byte(outPoint.String())
+	if len(txHashes) == 0 {
+		return tx.delete(tx.bucketInputs, key)
+	}
+	value := make([]byte, 0, len(txHashes)*chainhash.HashSize)
+	for _, txHash := range txHashes {
+		value = append(value, txHash[:]...)
+	}
+	return tx.put(tx.bucketInputs, key, value)
 }
 
-// Input implements transactions.DBTxInterface.
-func (tx *Tx) Input(outPoint wire.OutPoint) (*chainhash.Hash, error) {
-	if value := tx.bucketInputs.Get([]byte(outPoint.String())); value != nil {
-		return chainhash.NewHash(value)
+// Inputs implements transactions.DBTxInterface.
+func (tx *Tx) Inputs(outPoint wire.OutPoint) ([]chainhash.Hash, error) {
+	value, err := tx.get(tx.bucketInputs, []byte(outPoint.String()))
+	if err != nil {
+		return nil, err
+	}
+	if len(value)%chainhash.HashSize != 0 {
+		return nil, errp.Newf("invalid inputs value of length %d", len(value))
+	}
+	txHashes := []chainhash.Hash{}
+	for i := 0; i < len(value); i += chainhash.HashSize {
+		txHash, err := chainhash.NewHash(value[i : i+chainhash.HashSize])
+		if err != nil {
+			return nil, err
+		}
+		txHashes = append(txHashes, *txHash)
 	}
-	return nil, nil
+	return txHashes, nil
 }
 
 // DeleteInput implements transactions.DBTxInterface. It panics if called from a read-only db
 // transaction.
-func (tx *Tx) DeleteInput(outPoint wire.OutPoint) {
-	if err := tx.bucketInputs.Delete([]byte(outPoint.String())); err != nil {
+func (tx *Tx) DeleteInput(outPoint wire.OutPoint, txHash chainhash.Hash) {
+	txHashes, err := tx.Inputs(outPoint)
+	if err != nil {
+		panic(err)
+	}
+	remaining := []chainhash.Hash{}
+	for _, existingTxHash := range txHashes {
+		if existingTxHash != txHash {
+			remaining = append(remaining, existingTxHash)
+		}
+	}
+	if err := tx.putInputs(outPoint, remaining); err != nil {
 		panic(errp.WithStack(err))
 	}
 }
 
 // PutOutput implements transactions.DBTxInterface.
 func (tx *Tx) PutOutput(outPoint wire.OutPoint, txOut *wire.TxOut) error {
-	return writeJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
+	return tx.writeJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
 }
 
 // Output implements transactions.DBTxInterface.
 func (tx *Tx) Output(outPoint wire.OutPoint) (*wire.TxOut, error) {
 	txOut := &wire.TxOut{}
-	found, err := readJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
+	found, err := tx.readJSON(tx.bucketOutputs, []byte(outPoint.String()), txOut)
 	if err != nil {
 		return nil, err
 	}
@@ -284,17 +387,20 @@ func (tx *Tx) Output(outPoint wire.OutPoint) (*wire.TxOut, error) {
 // Outputs implements transactions.DBTxInterface.
 func (tx *Tx) Outputs() (map[wire.OutPoint]*wire.TxOut, error) {
 	outputs := map[wire.OutPoint]*wire.TxOut{}
-	cursor := tx.bucketOutputs.Cursor()
-	for outPointBytes, txOutJSONBytes := cursor.First(); outPointBytes != nil; outPointBytes, txOutJSONBytes = cursor.Next() {
+	err := tx.forEach(tx.bucketOutputs, func(outPointBytes []byte, txOutJSONBytes []byte) error {
 		txOut := &wire.TxOut{}
 		if err := json.Unmarshal(txOutJSONBytes, txOut); err != nil {
-			return nil, errp.WithStack(err)
+			return errp.WithStack(err)
 		}
 		outPoint, err := util.ParseOutPoint(outPointBytes)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		outputs[*outPoint] = txOut
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return outputs, nil
 }
@@ -302,19 +408,34 @@ func (tx *Tx) Outputs() (map[wire.OutPoint]*wire.TxOut, error) {
 // DeleteOutput implements transactions.DBTxInterface. It panics if called from a read-only db
 // transaction.
 func (tx *Tx) DeleteOutput(outPoint wire.OutPoint) {
-	if err := tx.bucketOutputs.Delete([]byte(outPoint.String())); err != nil {
-		panic(errp.WithStack(err))
+	if err := tx.delete(tx.bucketOutputs, []byte(outPoint.String())); err != nil {
+		panic(err)
 	}
 }
 
 // PutAddressHistory implements transactions.DBTxInterface.
 func (tx *Tx) PutAddressHistory(scriptHashHex blockchain.ScriptHashHex, history blockchain.TxHistory) error {
-	return writeJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), history)
+	return tx.writeJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), history)
 }
 
 // AddressHistory implements transactions.DBTxInterface.
 func (tx *Tx) AddressHistory(scriptHashHex blockchain.ScriptHashHex) (blockchain.TxHistory, error) {
 	history := blockchain.TxHistory{}
-	_, err := readJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), &history)
+	_, err := tx.readJSON(tx.bucketAddressHistories, []byte(string(scriptHashHex)), &history)
 	return history, err
 }
+
+// PutScanState implements transactions.DBTxInterface.
+func (tx *Tx) PutScanState(scriptHashHex blockchain.ScriptHashHex, state *blockchain.ScanState) error {
+	return tx.writeJSON(tx.bucketScanStates, []byte(string(scriptHashHex)), state)
+}
+
+// ScanState implements transactions.DBTxInterface.
+func (tx *Tx) ScanState(scriptHashHex blockchain.ScriptHashHex) (*blockchain.ScanState, error) {
+	state := &blockchain.ScanState{}
+	found, err := tx.readJSON(tx.bucketScanStates, []byte(string(scriptHashHex)), state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return state, nil
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/client"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/electrum/pool"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonrpc"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
@@ -157,6 +158,25 @@ func NewElectrumConnection(servers []*rpc.ServerInfo, log *logrus.Entry, socksPr
 	return client.NewElectrumClient(jsonrpcClient, log)
 }
 
+// NewElectrumPool connects to several Electrum servers. Subscriptions use one connection failing
+// over between the servers, while read-only requests are routed to the best server. The
+// subscription connection, which connects to the first server first, is reused for the first
+// server.
+func NewElectrumPool(servers []*rpc.ServerInfo, log *logrus.Entry, socksProxy socksproxy.SocksProxy) *pool.Pool {
+	primary := NewElectrumConnection(servers, log, socksProxy)
+	serverNames := make([]string, len(servers))
+	clients := make([]blockchain.Interface, len(servers))
+	for index, server := range servers {
+		serverNames[index] = server.Server
+		if index == 0 {
+			clients[index] = primary
+			continue
+		}
+		clients[index] = NewElectrumConnection([]*rpc.ServerInfo{server}, log, socksProxy)
+	}
+	return pool.NewPool(primary, serverNames, clients, log.WithField("group", "electrum-pool"))
+}
+
 // DownloadCert downloads the first element of the remote certificate chain.
 func DownloadCert(server string, socksProxy socksproxy.SocksProxy) (string, error) {
 	var pemCert []byte
@@ -0,0 +1,402 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pool implements a blockchain.Interface distributing requests over several servers.
+package pool
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// latencySmoothing is the weight of a new latency measurement in the moving average.
+	latencySmoothing = 0.2
+	// errorPenalty is added to the score of a server per recent error.
+	errorPenalty = 2 * time.Second
+	// tipPenalty is added to the score of a server per block it is behind the best known tip.
+	tipPenalty = 5 * time.Second
+	// defaultRequestTimeout is the time after which a request is retried with the next server. The
+	// connection of a dead server can stay open, and its pending requests are only resent after
+	// reconnecting, so they would not fail otherwise.
+	defaultRequestTimeout = 15 * time.Second
+)
+
+// member is one server of the pool with its health statistics.
+type member struct {
+	server string
+	client blockchain.Interface
+
+	lock locker.Locker
+	// connected is true once the server responded, and false after the connection dropped or a
+	// request timed out.
+	connected bool
+	// latency is the moving average of the response times, 0 if not measured yet.
+	latency time.Duration
+	// errors is the number of recent errors. It is halved with every successful request.
+	errors    int
+	requests  int
+	tipHeight int
+}
+
+// score returns the score of the member, lower is better. Requires the lock.
+func (m *member) score(bestTipHeight int) time.Duration {
+	score := m.latency + time.Duration(m.errors)*errorPenalty
+	if m.tipHeight < bestTipHeight {
+		score += time.Duration(bestTipHeight-m.tipHeight) * tipPenalty
+	}
+	return score
+}
+
+func (m *member) recordSuccess(latency time.Duration) {
+	defer m.lock.Lock()()
+	m.connected = true
+	m.requests++
+	m.errors /= 2
+	if m.latency == 0 {
+		m.latency = latency
+	} else {
+		m.latency = time.Duration((1-latencySmoothing)*float64(m.latency) + latencySmoothing*float64(latency))
+	}
+}
+
+func (m *member) recordError() {
+	defer m.lock.Lock()()
+	m.requests++
+	m.errors++
+}
+
+// recordTimeout records an unanswered request. The member is treated as disconnected until it
+// responds again.
+func (m *member) recordTimeout() {
+	defer m.lock.Lock()()
+	m.connected = false
+	m.requests++
+	m.errors++
+}
+
+// ServerStatus is the health of a server of the pool, shown to the user for support.
+type ServerStatus struct {
+	Server    string `json:"server"`
+	Connected bool   `json:"connected"`
+	// LatencyMs is the average response time in milliseconds.
+	LatencyMs int64 `json:"latencyMs"`
+	Errors    int   `json:"errors"`
+	Requests  int   `json:"requests"`
+	TipHeight int   `json:"tipHeight"`
+	// Rank is the position of the server in the selection order, starting at 0 for the best one.
+	Rank int `json:"rank"`
+}
+
+// Pool implements blockchain.Interface using several servers. Subscriptions, the history of
+// subscribed scripts and broadcasts go to the primary client, which fails over between the
+// servers, so they stay sticky to one connection. Read-only requests are routed to the server with
+// the best score, based on its latency, recent errors and tip height, and retried with the next
+// best server if they fail.
+type Pool struct {
+	primary blockchain.Interface
+	members []*member
+	// requestTimeout is the time after which a request routed to a member is retried with the next
+	// one.
+	requestTimeout time.Duration
+	log            *logrus.Entry
+}
+
+// NewPool creates a pool. clients are the connections to the individual servers, in the same
+// order as servers. A client can be the primary client itself, so that the server the primary
+// client is connected to is not connected to a second time.
+func NewPool(
+	primary blockchain.Interface,
+	servers []string,
+	clients []blockchain.Interface,
+	log *logrus.Entry,
+) *Pool {
+	pool := &Pool{primary: primary, requestTimeout: defaultRequestTimeout, log: log}
+	for index, client := range clients {
+		m := &member{server: servers[index], client: client}
+		pool.members = append(pool.members, m)
+		client.RegisterOnConnectionStatusChangedEvent(func(status blockchain.Status) {
+			if status != blockchain.CONNECTED {
+				defer m.lock.Lock()()
+				m.connected = false
+			}
+		})
+		client.HeadersSubscribe(nil, func(header *blockchain.Header) error {
+			defer m.lock.Lock()()
+			m.connected = true
+			m.tipHeight = header.BlockHeight
+			return nil
+		})
+	}
+	return pool
+}
+
+// Clients returns the connections to the individual servers.
+func (pool *Pool) Clients() []blockchain.Interface {
+	clients := make([]blockchain.Interface, len(pool.members))
+	for index, m := range pool.members {
+		clients[index] = m.client
+	}
+	return clients
+}
+
+// ranked returns the members ordered by their score, best first. Disconnected members come last.
+// Members with the same score are ordered randomly to spread the load.
+func (pool *Pool) ranked() []*member {
+	type rankedMember struct {
+		member    *member
+		connected bool
+		score     time.Duration
+	}
+	bestTipHeight := 0
+	for _, m := range pool.members {
+		unlock := m.lock.RLock()
+		if m.tipHeight > bestTipHeight {
+			bestTipHeight = m.tipHeight
+		}
+		unlock()
+	}
+	rankedMembers := make([]rankedMember, len(pool.members))
+	for index, m := range pool.members {
+		unlock := m.lock.RLock()
+		rankedMembers[index] = rankedMember{member: m, connected: m.connected, score: m.score(bestTipHeight)}
+		unlock()
+	}
+	rand.Shuffle(len(rankedMembers), func(i, j int) {
+		rankedMembers[i], rankedMembers[j] = rankedMembers[j], rankedMembers[i]
+	})
+	sort.SliceStable(rankedMembers, func(i, j int) bool {
+		if rankedMembers[i].connected != rankedMembers[j].connected {
+			return rankedMembers[i].connected
+		}
+		return rankedMembers[i].score < rankedMembers[j].score
+	})
+	result := make([]*member, len(rankedMembers))
+	for index, rankedMember := range rankedMembers {
+		result[index] = rankedMember.member
+	}
+	return result
+}
+
+// Status returns the health of the servers, ordered by rank.
+func (pool *Pool) Status() []*ServerStatus {
+	result := []*ServerStatus{}
+	for rank, m := range pool.ranked() {
+		unlock := m.lock.RLock()
+		result = append(result, &ServerStatus{
+			Server:    m.server,
+			Connected: m.connected,
+			LatencyMs: int64(m.latency / time.Millisecond),
+			Errors:    m.errors,
+			Requests:  m.requests,
+			TipHeight: m.tipHeight,
+			Rank:      rank,
+		})
+		unlock()
+	}
+	return result
+}
+
+// route performs the request with the best member. If the server fails or does not respond within
+// the request timeout, the request is retried with the next best member, and finally with the
+// primary client. A late response of a server which timed out is ignored. request must pass the
+// success callback to respond once the server responded, and call cleanup when done.
+func (pool *Pool) route(
+	request func(client blockchain.Interface, respond func(func() error) error, cleanup func(error)),
+	cleanup func(error),
+) {
+	candidates := pool.ranked()
+	var try func(index int)
+	try = func(index int) {
+		if index == len(candidates) {
+			request(pool.primary, func(success func() error) error { return success() }, cleanup)
+			return
+		}
+		m := candidates[index]
+		start := time.Now()
+		// The request is finished by the response or the timeout, whichever comes first.
+		var lock locker.Locker
+		responded, abandoned := false, false
+		timer := time.AfterFunc(pool.requestTimeout, func() {
+			unlock := lock.Lock()
+			if responded || abandoned {
+				unlock()
+				return
+			}
+			abandoned = true
+			unlock()
+			m.recordTimeout()
+			pool.log.WithField("server", m.server).Info("Request timed out, trying the next server")
+			try(index + 1)
+		})
+		request(m.client,
+			func(success func() error) error {
+				unlock := lock.Lock()
+				if abandoned {
+					unlock()
+					return nil
+				}
+				responded = true
+				unlock()
+				timer.Stop()
+				m.recordSuccess(time.Since(start))
+				return success()
+			},
+			func(err error) {
+				unlock := lock.Lock()
+				if abandoned {
+					unlock()
+					return
+				}
+				failed := err != nil && !responded
+				abandoned = failed
+				unlock()
+				timer.Stop()
+				if failed {
+					m.recordError()
+					pool.log.WithError(err).WithField("server", m.server).Info("Request failed, trying the next server")
+					try(index + 1)
+					return
+				}
+				cleanup(err)
+			})
+	}
+	try(0)
+}
+
+// ScriptHashGetHistory implements blockchain.Interface. It is sent to the primary client, so the
+// history matches the status of the subscription.
+func (pool *Pool) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(error),
+) {
+	pool.primary.ScriptHashGetHistory(scriptHashHex, success, cleanup)
+}
+
+// TransactionGet implements blockchain.Interface.
+func (pool *Pool) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(error),
+) {
+	pool.route(func(client blockchain.Interface, respond func(func() error) error, cleanup func(error)) {
+		client.TransactionGet(txHash, func(tx *wire.MsgTx) error {
+			return respond(func() error { return success(tx) })
+		}, cleanup)
+	}, cleanup)
+}
+
+// ScriptHashSubscribe implements blockchain.Interface.
+func (pool *Pool) ScriptHashSubscribe(
+	setupAndTeardown func() func(error),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	pool.primary.ScriptHashSubscribe(setupAndTeardown, scriptHashHex, success)
+}
+
+// HeadersSubscribe implements blockchain.Interface.
+func (pool *Pool) HeadersSubscribe(
+	setupAndTeardown func() func(error),
+	success func(*blockchain.Header) error,
+) {
+	pool.primary.HeadersSubscribe(setupAndTeardown, success)
+}
+
+// TransactionBroadcast implements blockchain.Interface.
+func (pool *Pool) TransactionBroadcast(tx *wire.MsgTx) error {
+	return pool.primary.TransactionBroadcast(tx)
+}
+
+// RelayFee implements blockchain.Interface.
+func (pool *Pool) RelayFee(success func(btcutil.Amount), cleanup func(error)) {
+	pool.route(func(client blockchain.Interface, respond func(func() error) error, cleanup func(error)) {
+		client.RelayFee(func(fee btcutil.Amount) {
+			_ = respond(func() error {
+				success(fee)
+				return nil
+			})
+		}, cleanup)
+	}, cleanup)
+}
+
+// EstimateFee implements blockchain.Interface.
+func (pool *Pool) EstimateFee(
+	number int,
+	success func(*btcutil.Amount) error,
+	cleanup func(error),
+) {
+	pool.route(func(client blockchain.Interface, respond func(func() error) error, cleanup func(error)) {
+		client.EstimateFee(number, func(fee *btcutil.Amount) error {
+			return respond(func() error { return success(fee) })
+		}, cleanup)
+	}, cleanup)
+}
+
+// Headers implements blockchain.Interface.
+func (pool *Pool) Headers(
+	startHeight int,
+	count int,
+	success func([]*wire.BlockHeader, int) error,
+	cleanup func(error),
+) {
+	pool.route(func(client blockchain.Interface, respond func(func() error) error, cleanup func(error)) {
+		client.Headers(startHeight, count, func(headers []*wire.BlockHeader, max int) error {
+			return respond(func() error { return success(headers, max) })
+		}, cleanup)
+	}, cleanup)
+}
+
+// GetMerkle implements blockchain.Interface.
+func (pool *Pool) GetMerkle(
+	txHash chainhash.Hash,
+	height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(error),
+) {
+	pool.route(func(client blockchain.Interface, respond func(func() error) error, cleanup func(error)) {
+		client.GetMerkle(txHash, height, func(merkle []blockchain.TXHash, pos int) error {
+			return respond(func() error { return success(merkle, pos) })
+		}, cleanup)
+	}, cleanup)
+}
+
+// Close implements blockchain.Interface.
+func (pool *Pool) Close() {
+	pool.primary.Close()
+	for _, m := range pool.members {
+		if m.client != pool.primary {
+			m.client.Close()
+		}
+	}
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (pool *Pool) ConnectionStatus() blockchain.Status {
+	return pool.primary.ConnectionStatus()
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface.
+func (pool *Pool) RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged func(blockchain.Status)) {
+	pool.primary.RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged)
+}
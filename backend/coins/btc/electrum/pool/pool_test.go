@@ -0,0 +1,177 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	blockchainMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newClient returns a blockchain mock at the given tip height. TransactionGet fails if err is not
+// nil, and responds with an empty tx otherwise.
+func newClient(tipHeight int, err error) *blockchainMock.Interface {
+	client := &blockchainMock.Interface{}
+	client.On("RegisterOnConnectionStatusChangedEvent", mock.Anything).Return()
+	client.On("HeadersSubscribe", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		_ = args.Get(1).(func(*blockchain.Header) error)(&blockchain.Header{BlockHeight: tipHeight})
+	}).Return()
+	client.On("TransactionGet", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		cleanup := args.Get(2).(func(error))
+		if err != nil {
+			cleanup(err)
+			return
+		}
+		cleanup(args.Get(1).(func(*wire.MsgTx) error)(wire.NewMsgTx(wire.TxVersion)))
+	}).Return()
+	return client
+}
+
+func newTestPool(primary blockchain.Interface, clients ...blockchain.Interface) *Pool {
+	servers := make([]string, len(clients))
+	for index := range clients {
+		servers[index] = string(rune('a' + index))
+	}
+	return NewPool(primary, servers, clients, logging.Get().WithGroup("pool_test"))
+}
+
+func transactionGet(t *testing.T, pool *Pool) error {
+	t.Helper()
+	var result error
+	pool.TransactionGet(chainhash.Hash{}, func(*wire.MsgTx) error { return nil },
+		func(err error) { result = err })
+	return result
+}
+
+func TestRouteToBest(t *testing.T) {
+	primary := &blockchainMock.Interface{}
+	fast, slow := newClient(100, nil), newClient(100, nil)
+	pool := newTestPool(primary, slow, fast)
+	pool.members[0].latency = 500 * time.Millisecond
+	pool.members[1].latency = 100 * time.Millisecond
+
+	require.NoError(t, transactionGet(t, pool))
+	fast.AssertNumberOfCalls(t, "TransactionGet", 1)
+	slow.AssertNumberOfCalls(t, "TransactionGet", 0)
+	require.Equal(t, "b", pool.Status()[0].Server)
+	require.Equal(t, 1, pool.Status()[0].Requests)
+
+	// Lagging behind the tip outweighs the latency.
+	pool.members[1].tipHeight = 98
+	require.NoError(t, transactionGet(t, pool))
+	slow.AssertNumberOfCalls(t, "TransactionGet", 1)
+
+	// Disconnected servers come last.
+	pool.members[1].tipHeight = 100
+	pool.members[1].connected = false
+	require.NoError(t, transactionGet(t, pool))
+	slow.AssertNumberOfCalls(t, "TransactionGet", 2)
+	require.False(t, pool.Status()[1].Connected)
+}
+
+func TestRetryOnError(t *testing.T) {
+	primary := newClient(100, nil)
+	failing := newClient(100, errp.New("server error"))
+	working := newClient(100, nil)
+	pool := newTestPool(primary, failing, working)
+	pool.members[1].latency = time.Second
+
+	require.NoError(t, transactionGet(t, pool))
+	failing.AssertNumberOfCalls(t, "TransactionGet", 1)
+	working.AssertNumberOfCalls(t, "TransactionGet", 1)
+	primary.AssertNumberOfCalls(t, "TransactionGet", 0)
+	require.Equal(t, 1, pool.members[0].errors)
+
+	// Errors of the success callback are not retried.
+	handlerErr := errp.New("handler error")
+	var result error
+	pool.TransactionGet(chainhash.Hash{}, func(*wire.MsgTx) error { return handlerErr },
+		func(err error) { result = err })
+	require.Equal(t, handlerErr, result)
+	working.AssertNumberOfCalls(t, "TransactionGet", 2)
+
+	// If all servers fail, the primary client is used.
+	pool = newTestPool(primary, failing)
+	require.NoError(t, transactionGet(t, pool))
+	primary.AssertNumberOfCalls(t, "TransactionGet", 1)
+}
+
+func TestRetryOnTimeout(t *testing.T) {
+	primary := newClient(100, nil)
+	// The connection to this server stays open, but it never answers.
+	hanging := &blockchainMock.Interface{}
+	hanging.On("RegisterOnConnectionStatusChangedEvent", mock.Anything).Return()
+	hanging.On("HeadersSubscribe", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		_ = args.Get(1).(func(*blockchain.Header) error)(&blockchain.Header{BlockHeight: 100})
+	}).Return()
+	hanging.On("TransactionGet", mock.Anything, mock.Anything, mock.Anything).Return()
+	working := newClient(100, nil)
+	pool := newTestPool(primary, hanging, working)
+	pool.requestTimeout = 10 * time.Millisecond
+	pool.members[1].latency = time.Second
+
+	result := make(chan error, 1)
+	pool.TransactionGet(chainhash.Hash{}, func(*wire.MsgTx) error { return nil },
+		func(err error) { result <- err })
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.Fail(t, "the request was not retried with the next server")
+	}
+	hanging.AssertNumberOfCalls(t, "TransactionGet", 1)
+	working.AssertNumberOfCalls(t, "TransactionGet", 1)
+	primary.AssertNumberOfCalls(t, "TransactionGet", 0)
+	require.Equal(t, 1, pool.members[0].errors)
+	require.False(t, pool.members[0].connected)
+
+	// The server which timed out is ranked last.
+	require.NoError(t, transactionGet(t, pool))
+	working.AssertNumberOfCalls(t, "TransactionGet", 2)
+	hanging.AssertNumberOfCalls(t, "TransactionGet", 1)
+}
+
+func TestConnectedAfterResponse(t *testing.T) {
+	silent := &blockchainMock.Interface{}
+	silent.On("RegisterOnConnectionStatusChangedEvent", mock.Anything).Return()
+	silent.On("HeadersSubscribe", mock.Anything, mock.Anything).Return()
+	pool := newTestPool(&blockchainMock.Interface{}, silent, newClient(100, nil))
+	status := map[string]bool{}
+	for _, serverStatus := range pool.Status() {
+		status[serverStatus.Server] = serverStatus.Connected
+	}
+	require.Equal(t, map[string]bool{"a": false, "b": true}, status)
+	require.Equal(t, "b", pool.Status()[0].Server)
+}
+
+func TestSubscriptionsUsePrimary(t *testing.T) {
+	primary := &blockchainMock.Interface{}
+	client := newClient(100, nil)
+	pool := newTestPool(primary, client)
+	primary.On("ScriptHashSubscribe", mock.Anything, blockchain.ScriptHashHex("hash"), mock.Anything).Return()
+	primary.On("ScriptHashGetHistory", blockchain.ScriptHashHex("hash"), mock.Anything, mock.Anything).Return()
+	pool.ScriptHashSubscribe(nil, "hash", nil)
+	pool.ScriptHashGetHistory("hash", nil, nil)
+	primary.AssertExpectations(t)
+	client.AssertNotCalled(t, "ScriptHashSubscribe", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,12 +30,15 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/message"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/util"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/types"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/config"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/gorilla/mux"
@@ -44,13 +48,16 @@ import (
 // Handlers provides a web api to the account.
 type Handlers struct {
 	account accounts.Interface
+	labels  *labels.Store
 	log     *logrus.Entry
 }
 
 // NewHandlers creates a new Handlers instance.
 func NewHandlers(
-	handleFunc func(string, func(*http.Request) (interface{}, error)) *mux.Route, log *logrus.Entry) *Handlers {
-	handlers := &Handlers{log: log}
+	handleFunc func(string, func(*http.Request) (interface{}, error)) *mux.Route,
+	labels *labels.Store,
+	log *logrus.Entry) *Handlers {
+	handlers := &Handlers{labels: labels, log: log}
 
 	handleFunc("/init", handlers.postInit).Methods("POST")
 	handleFunc("/status", handlers.getAccountStatus).Methods("GET")
@@ -58,8 +65,21 @@ func NewHandlers(
 	handleFunc("/export", handlers.ensureAccountInitialized(handlers.postExportTransactions)).Methods("POST")
 	handleFunc("/info", handlers.ensureAccountInitialized(handlers.getAccountInfo)).Methods("GET")
 	handleFunc("/utxos", handlers.ensureAccountInitialized(handlers.getUTXOs)).Methods("GET")
+	handleFunc("/freeze-utxo", handlers.ensureAccountInitialized(handlers.postFreezeUTXO)).Methods("POST")
 	handleFunc("/balance", handlers.ensureAccountInitialized(handlers.getAccountBalance)).Methods("GET")
 	handleFunc("/sendtx", handlers.ensureAccountInitialized(handlers.postAccountSendTx)).Methods("POST")
+	handleFunc("/sendtx-batch", handlers.ensureAccountInitialized(handlers.postAccountSendTxBatch)).Methods("POST")
+	handleFunc("/tx-proposal-batch", handlers.ensureAccountInitialized(handlers.getAccountTxProposalBatch)).Methods("POST")
+	handleFunc("/bump-fee", handlers.ensureAccountInitialized(handlers.postBumpFee)).Methods("POST")
+	handleFunc("/cpfp", handlers.ensureAccountInitialized(handlers.postCPFP)).Methods("POST")
+	handleFunc("/speed-up", handlers.ensureAccountInitialized(handlers.postSpeedUp)).Methods("POST")
+	handleFunc("/cancel", handlers.ensureAccountInitialized(handlers.postCancel)).Methods("POST")
+	handleFunc("/psbt", handlers.ensureAccountInitialized(handlers.postPSBT)).Methods("POST")
+	handleFunc("/psbt-combine", handlers.ensureAccountInitialized(handlers.postCombinePSBTs)).Methods("POST")
+	handleFunc("/psbt-broadcast", handlers.ensureAccountInitialized(handlers.postBroadcastPSBT)).Methods("POST")
+	handleFunc("/descriptor", handlers.ensureAccountInitialized(handlers.getDescriptor)).Methods("GET")
+	handleFunc("/sign-message", handlers.ensureAccountInitialized(handlers.postSignMessage)).Methods("POST")
+	handleFunc("/verify-message", handlers.ensureAccountInitialized(handlers.postVerifyMessage)).Methods("POST")
 	handleFunc("/fee-targets", handlers.ensureAccountInitialized(handlers.getAccountFeeTargets)).Methods("GET")
 	handleFunc("/tx-proposal", handlers.ensureAccountInitialized(handlers.getAccountTxProposal)).Methods("POST")
 	handleFunc("/receive-addresses", handlers.ensureAccountInitialized(handlers.getReceiveAddresses)).Methods("GET")
@@ -68,6 +88,7 @@ func NewHandlers(
 	handleFunc("/verify-extended-public-key", handlers.ensureAccountInitialized(handlers.postVerifyExtendedPublicKey)).Methods("POST")
 	handleFunc("/has-secure-output", handlers.ensureAccountInitialized(handlers.getHasSecureOutput)).Methods("GET")
 	handleFunc("/convert-to-legacy-address", handlers.ensureAccountInitialized(handlers.postConvertToLegacyAddress)).Methods("POST")
+	handleFunc("/label", handlers.ensureAccountInitialized(handlers.postLabel)).Methods("POST")
 	return handlers
 }
 
@@ -111,6 +132,10 @@ type Transaction struct {
 	Fee              FormattedAmount   `json:"fee"`
 	Time             *string           `json:"time"`
 	Addresses        []string          `json:"addresses"`
+	// Note is the label of the transaction set by the user.
+	Note string `json:"note"`
+	// HistoricalConversions is the amount in fiat at the time of the transaction.
+	HistoricalConversions map[string]string `json:"historicalConversions"`
 
 	// BTC specific fields.
 	VSize        int64           `json:"vsize"`
@@ -137,6 +162,9 @@ func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{},
 	if err != nil {
 		return nil, err
 	}
+	// Dates of the transactions whose historical rates are not cached yet.
+	missingDates := []time.Time{}
+	ratesSupported := handlers.account.RateUpdater().Supports(coin.RatesUnit(handlers.account.Coin(), false))
 	for _, txInfo := range txs {
 		var feeString FormattedAmount
 		fee := txInfo.Fee()
@@ -144,10 +172,17 @@ func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{},
 			feeString = handlers.formatAmountAsJSON(*fee, true)
 		}
 		var formattedTime *string
+		var historicalConversions map[string]string
 		timestamp := txInfo.Timestamp()
 		if timestamp != nil {
 			t := timestamp.Format(time.RFC3339)
 			formattedTime = &t
+			var complete bool
+			historicalConversions, complete = coin.HistoricalConversions(
+				txInfo.Amount(), handlers.account.Coin(), false, *timestamp, handlers.account.RateUpdater())
+			if !complete && ratesSupported {
+				missingDates = append(missingDates, *timestamp)
+			}
 		}
 		addresses := []string{}
 		for _, addressAndAmount := range txInfo.Addresses() {
@@ -166,6 +201,9 @@ func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{},
 			Fee:       feeString,
 			Time:      formattedTime,
 			Addresses: addresses,
+			Note:      handlers.labels.Get(labels.TypeTx, txInfo.ID()),
+
+			HistoricalConversions: historicalConversions,
 		}
 		switch specificInfo := txInfo.(type) {
 		case *transactions.TxInfo:
@@ -181,6 +219,11 @@ func (handlers *Handlers) getAccountTransactions(_ *http.Request) (interface{},
 		}
 		result = append(result, txInfoJSON)
 	}
+	if len(missingDates) != 0 {
+		// The frontend is notified to reload the transactions once they are available.
+		handlers.account.RateUpdater().FetchHistoricalRates(
+			coin.RatesUnit(handlers.account.Coin(), false), missingDates)
+	}
 	return result, nil
 }
 
@@ -207,7 +250,14 @@ func (handlers *Handlers) postExportTransactions(_ *http.Request) (interface{},
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	err = writer.Write([]string{
+	rateUpdater := handlers.account.RateUpdater()
+	ratesUnit := coin.RatesUnit(handlers.account.Coin(), false)
+	fiats := rateUpdater.Fiats()
+	ratesSupported := rateUpdater.Supports(ratesUnit)
+	if !ratesSupported {
+		handlers.log.Infof("No rates available for %s, the exported values are left empty.", ratesUnit)
+	}
+	header := []string{
 		"Time",
 		"Type",
 		"Amount",
@@ -215,7 +265,13 @@ func (handlers *Handlers) postExportTransactions(_ *http.Request) (interface{},
 		"Fee",
 		"Address",
 		"Transaction ID",
-	})
+		"Address Label",
+		"Note",
+	}
+	for _, fiat := range fiats {
+		header = append(header, "Value ("+fiat+")")
+	}
+	err = writer.Write(header)
 	if err != nil {
 		return nil, errp.WithStack(err)
 	}
@@ -224,6 +280,17 @@ func (handlers *Handlers) postExportTransactions(_ *http.Request) (interface{},
 	if err != nil {
 		return nil, err
 	}
+	if ratesSupported {
+		// Fetch the missing historical rates of all transactions at once before they are read from
+		// the cache below.
+		dates := []time.Time{}
+		for _, transaction := range transactions {
+			if transaction.Timestamp() != nil {
+				dates = append(dates, *transaction.Timestamp())
+			}
+		}
+		<-rateUpdater.FetchHistoricalRates(ratesUnit, dates)
+	}
 	for _, transaction := range transactions {
 		transactionType := map[accounts.TxType]string{
 			accounts.TxTypeReceive:  "received",
@@ -240,11 +307,21 @@ func (handlers *Handlers) postExportTransactions(_ *http.Request) (interface{},
 		if transaction.Timestamp() != nil {
 			timeString = transaction.Timestamp().Format(time.RFC3339)
 		}
+		note := handlers.labels.Get(labels.TypeTx, transaction.ID())
+		// The rates at the time of the transaction. Missing rates leave the value empty.
+		rates := map[string]float64{}
+		if ratesSupported && transaction.Timestamp() != nil {
+			for _, fiat := range fiats {
+				if rate, ok := rateUpdater.CachedHistoricalRate(ratesUnit, fiat, *transaction.Timestamp()); ok {
+					rates[fiat] = rate
+				}
+			}
+		}
 		for _, addressAndAmount := range transaction.Addresses() {
 			if transactionType == "sent" && addressAndAmount.Ours {
 				transactionType = "sent_to_yourself"
 			}
-			err := writer.Write([]string{
+			row := []string{
 				timeString,
 				transactionType,
 				addressAndAmount.Amount.BigInt().String(),
@@ -252,7 +329,18 @@ func (handlers *Handlers) postExportTransactions(_ *http.Request) (interface{},
 				feeString,
 				addressAndAmount.Address,
 				transaction.ID(),
-			})
+				handlers.labels.Get(labels.TypeAddr, addressAndAmount.Address),
+				note,
+			}
+			for _, fiat := range fiats {
+				value := ""
+				if rate, ok := rates[fiat]; ok {
+					value = strconv.FormatFloat(
+						handlers.account.Coin().ToUnit(addressAndAmount.Amount, false)*rate, 'f', 2, 64)
+				}
+				row = append(row, value)
+			}
+			err := writer.Write(row)
 			if err != nil {
 				return nil, errp.WithStack(err)
 			}
@@ -278,14 +366,22 @@ func (handlers *Handlers) getUTXOs(_ *http.Request) (interface{}, error) {
 		return result, errp.New("Interface must be of type btc.Account")
 	}
 
-	for _, output := range t.SpendableOutputs() {
-		result = append(result,
-			map[string]interface{}{
-				"outPoint": output.OutPoint.String(),
-				"amount":   handlers.formatBTCAmountAsJSON(btcutil.Amount(output.TxOut.Value), false),
-				"address":  output.Address,
-			})
+	addOutputs := func(outputs []*btc.SpendableOutput, frozen bool) {
+		for _, output := range outputs {
+			outPoint := output.OutPoint.String()
+			result = append(result,
+				map[string]interface{}{
+					"outPoint":     outPoint,
+					"amount":       handlers.formatBTCAmountAsJSON(btcutil.Amount(output.TxOut.Value), false),
+					"address":      output.Address,
+					"label":        handlers.labels.Get(labels.TypeOutput, outPoint),
+					"addressLabel": handlers.labels.Get(labels.TypeAddr, output.Address),
+					"frozen":       frozen,
+				})
+		}
 	}
+	addOutputs(t.SpendableOutputs(), false)
+	addOutputs(t.FrozenOutputs(), true)
 
 	return result, nil
 }
@@ -299,9 +395,35 @@ func (handlers *Handlers) getAccountBalance(_ *http.Request) (interface{}, error
 		"available":   handlers.formatAmountAsJSON(balance.Available(), false),
 		"incoming":    handlers.formatAmountAsJSON(balance.Incoming(), false),
 		"hasIncoming": balance.Incoming().BigInt().Sign() > 0,
+		"frozen":      handlers.formatAmountAsJSON(balance.Frozen(), false),
+		"hasFrozen":   balance.Frozen().BigInt().Sign() > 0,
 	}, nil
 }
 
+// postFreezeUTXO freezes or unfreezes an output, so it is not spent by new transactions.
+func (handlers *Handlers) postFreezeUTXO(r *http.Request) (interface{}, error) {
+	var input struct {
+		OutPoint string `json:"outPoint"`
+		Frozen   bool   `json:"frozen"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	account, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("Interface must be of type btc.Account")
+	}
+	outPoint, err := util.ParseOutPoint([]byte(input.OutPoint))
+	if err != nil {
+		return nil, err
+	}
+	if err := account.SetOutputFrozen(*outPoint, input.Frozen); err != nil {
+		handlers.log.WithError(err).Error("Could not freeze the output")
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
 type sendTxInput struct {
 	address       string
 	sendAmount    coin.SendAmount
@@ -348,21 +470,270 @@ func (input *sendTxInput) UnmarshalJSON(jsonBytes []byte) error {
 	return nil
 }
 
+type sendTxBatchInput struct {
+	recipients    []btc.Recipient
+	feeTargetCode accounts.FeeTargetCode
+	selectedUTXOs map[wire.OutPoint]struct{}
+}
+
+func (input *sendTxBatchInput) UnmarshalJSON(jsonBytes []byte) error {
+	jsonBody := struct {
+		Recipients []struct {
+			Address string `json:"address"`
+			SendAll string `json:"sendAll"`
+			Amount  string `json:"amount"`
+		} `json:"recipients"`
+		FeeTarget     string   `json:"feeTarget"`
+		SelectedUTXOS []string `json:"selectedUTXOS"`
+	}{}
+	if err := json.Unmarshal(jsonBytes, &jsonBody); err != nil {
+		return errp.WithStack(err)
+	}
+	var err error
+	input.feeTargetCode, err = accounts.NewFeeTargetCode(jsonBody.FeeTarget)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to retrieve fee target code")
+	}
+	input.recipients = make([]btc.Recipient, len(jsonBody.Recipients))
+	for i, recipient := range jsonBody.Recipients {
+		input.recipients[i].Address = recipient.Address
+		if recipient.SendAll == "yes" {
+			input.recipients[i].Amount = coin.NewSendAmountAll()
+		} else {
+			input.recipients[i].Amount = coin.NewSendAmount(recipient.Amount)
+		}
+	}
+	input.selectedUTXOs = map[wire.OutPoint]struct{}{}
+	for _, outPointString := range jsonBody.SelectedUTXOS {
+		outPoint, err := util.ParseOutPoint([]byte(outPointString))
+		if err != nil {
+			return err
+		}
+		input.selectedUTXOs[*outPoint] = struct{}{}
+	}
+	return nil
+}
+
+func (handlers *Handlers) postAccountSendTxBatch(r *http.Request) (interface{}, error) {
+	var input sendTxBatchInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to support batch payments")
+	}
+	return sendTxResult(btcAccount.SendTxBatch(input.recipients, input.feeTargetCode, input.selectedUTXOs))
+}
+
 func (handlers *Handlers) postAccountSendTx(r *http.Request) (interface{}, error) {
 	var input sendTxInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return nil, errp.WithStack(err)
 	}
-	err := handlers.account.SendTx(
+	return sendTxResult(handlers.account.SendTx(
 		input.address,
 		input.sendAmount,
 		input.feeTargetCode,
 		input.selectedUTXOs,
 		input.data,
-	)
+	))
+}
+
+// sendTxResult returns the response to a send request which failed with err, or succeeded if err is
+// nil.
+func sendTxResult(err error) (interface{}, error) {
+	if errp.Cause(err) == keystore.ErrSigningAborted {
+		return map[string]interface{}{"success": false, "aborted": true}, nil
+	}
+	if errp.Cause(err) == keystore.ErrNoKeystore {
+		return map[string]interface{}{"success": false, "errorCode": "watchOnly"}, nil
+	}
+	if errp.Cause(err) == btc.ErrNotEnoughSignatures {
+		// Multisig accounts with external cosigners need to be signed with a PSBT.
+		return map[string]interface{}{"success": false, "errorCode": "notEnoughSignatures"}, nil
+	}
+	if validationErr, ok := errp.Cause(err).(errors.TxValidationError); ok {
+		return map[string]interface{}{"success": false, "errorCode": validationErr.Error()}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+// accelerateTx decodes a pending tx and a fee target from the request and calls accelerate with
+// them, e.g. to bump the fee of the tx.
+func (handlers *Handlers) accelerateTx(
+	r *http.Request,
+	accelerate func(*btc.Account, *transactions.TxInfo, accounts.FeeTargetCode) error,
+) (interface{}, error) {
+	var input struct {
+		TxID      string `json:"txID"`
+		FeeTarget string `json:"feeTarget"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to support accelerating transactions")
+	}
+	feeTargetCode, err := accounts.NewFeeTargetCode(input.FeeTarget)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to retrieve fee target code")
+	}
+	txs, err := btcAccount.Transactions()
+	if err != nil {
+		return nil, err
+	}
+	var txInfo *transactions.TxInfo
+	for _, tx := range txs {
+		if tx.ID() == input.TxID {
+			txInfo = tx.(*transactions.TxInfo)
+			break
+		}
+	}
+	if txInfo == nil {
+		return nil, errp.New("Transaction not found")
+	}
+	err = accelerate(btcAccount, txInfo, feeTargetCode)
+	if errp.Cause(err) == keystore.ErrSigningAborted {
+		return map[string]interface{}{"success": false, "aborted": true}, nil
+	}
+	if errp.Cause(err) == keystore.ErrNoKeystore {
+		return map[string]interface{}{"success": false, "errorCode": "watchOnly"}, nil
+	}
+	if validationErr, ok := errp.Cause(err).(errors.TxValidationError); ok {
+		return map[string]interface{}{"success": false, "errorCode": validationErr.Error()}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postBumpFee(r *http.Request) (interface{}, error) {
+	return handlers.accelerateTx(r, (*btc.Account).BumpFee)
+}
+
+func (handlers *Handlers) postCPFP(r *http.Request) (interface{}, error) {
+	return handlers.accelerateTx(r, (*btc.Account).ChildPaysForParent)
+}
+
+// replaceETHTx decodes a pending tx ID and a fee target from the request and calls replace with
+// them.
+func (handlers *Handlers) replaceETHTx(
+	r *http.Request,
+	replace func(*eth.Account, string, accounts.FeeTargetCode) error,
+) (interface{}, error) {
+	var input struct {
+		TxID      string `json:"txID"`
+		FeeTarget string `json:"feeTarget"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	ethAccount, ok := handlers.account.(*eth.Account)
+	if !ok {
+		return nil, errp.New("An account must be ETH based to support replacing transactions")
+	}
+	feeTargetCode, err := accounts.NewFeeTargetCode(input.FeeTarget)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to retrieve fee target code")
+	}
+	err = replace(ethAccount, input.TxID, feeTargetCode)
 	if errp.Cause(err) == keystore.ErrSigningAborted {
 		return map[string]interface{}{"success": false, "aborted": true}, nil
 	}
+	if errp.Cause(err) == keystore.ErrNoKeystore {
+		return map[string]interface{}{"success": false, "errorCode": "watchOnly"}, nil
+	}
+	if validationErr, ok := errp.Cause(err).(errors.TxValidationError); ok {
+		return map[string]interface{}{"success": false, "errorCode": validationErr.Error()}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postSpeedUp(r *http.Request) (interface{}, error) {
+	return handlers.replaceETHTx(r, (*eth.Account).SpeedUpTx)
+}
+
+func (handlers *Handlers) postCancel(r *http.Request) (interface{}, error) {
+	return handlers.replaceETHTx(r, (*eth.Account).CancelTx)
+}
+
+// postPSBT creates an unsigned tx and returns it as a base64 encoded PSBT.
+func (handlers *Handlers) postPSBT(r *http.Request) (interface{}, error) {
+	var input sendTxBatchInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return txProposalError(errp.WithStack(err))
+	}
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to support PSBTs")
+	}
+	packet, err := btcAccount.PSBT(input.recipients, input.feeTargetCode, input.selectedUTXOs)
+	if err != nil {
+		return txProposalError(err)
+	}
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "psbt": encoded}, nil
+}
+
+// postCombinePSBTs combines base64 encoded PSBTs of the same tx, e.g. signed by different
+// cosigners.
+func (handlers *Handlers) postCombinePSBTs(r *http.Request) (interface{}, error) {
+	var input struct {
+		PSBTs []string `json:"psbts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	packets := make([]*psbt.Packet, len(input.PSBTs))
+	for i, encoded := range input.PSBTs {
+		packet, err := psbt.NewFromBase64(encoded)
+		if err != nil {
+			return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+		}
+		packets[i] = packet
+	}
+	combined, err := psbt.Combine(packets...)
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	// Finalizing fails as long as signatures are missing, in which case the combined PSBT is
+	// returned as is.
+	complete := combined.FinalizeAll() == nil
+	encoded, err := combined.B64Encode()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "psbt": encoded, "complete": complete}, nil
+}
+
+// postBroadcastPSBT broadcasts the tx of a base64 encoded, fully signed PSBT.
+func (handlers *Handlers) postBroadcastPSBT(r *http.Request) (interface{}, error) {
+	var input struct {
+		PSBT string `json:"psbt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to support PSBTs")
+	}
+	packet, err := psbt.NewFromBase64(input.PSBT)
+	if err == nil {
+		err = btcAccount.BroadcastPSBT(packet)
+	}
 	if err != nil {
 		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
 	}
@@ -402,6 +773,31 @@ func (handlers *Handlers) getAccountTxProposal(r *http.Request) (interface{}, er
 	}, nil
 }
 
+func (handlers *Handlers) getAccountTxProposalBatch(r *http.Request) (interface{}, error) {
+	var input sendTxBatchInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return txProposalError(errp.WithStack(err))
+	}
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to support batch payments")
+	}
+	outputAmount, fee, total, err := btcAccount.TxProposalBatch(
+		input.recipients,
+		input.feeTargetCode,
+		input.selectedUTXOs,
+	)
+	if err != nil {
+		return txProposalError(err)
+	}
+	return map[string]interface{}{
+		"success": true,
+		"amount":  handlers.formatAmountAsJSON(outputAmount, false),
+		"fee":     handlers.formatAmountAsJSON(fee, true),
+		"total":   handlers.formatAmountAsJSON(total, false),
+	}, nil
+}
+
 func (handlers *Handlers) getAccountFeeTargets(_ *http.Request) (interface{}, error) {
 	feeTargets, defaultFeeTarget := handlers.account.FeeTargets()
 	result := []map[string]interface{}{}
@@ -444,15 +840,75 @@ func (handlers *Handlers) getAccountStatus(_ *http.Request) (interface{}, error)
 	return status, nil
 }
 
+func (handlers *Handlers) getDescriptor(_ *http.Request) (interface{}, error) {
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to support descriptors")
+	}
+	return btcAccount.Descriptor()
+}
+
+func (handlers *Handlers) postSignMessage(r *http.Request) (interface{}, error) {
+	var input struct {
+		AddressID string `json:"addressID"`
+		Message   string `json:"message"`
+		Legacy    bool   `json:"legacy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	btcAccount, ok := handlers.account.(*btc.Account)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to sign messages")
+	}
+	signature, err := btcAccount.SignMessage(input.AddressID, input.Message, input.Legacy)
+	if errp.Cause(err) == keystore.ErrSigningAborted {
+		return map[string]interface{}{"success": false, "aborted": true}, nil
+	}
+	if errp.Cause(err) == keystore.ErrNoKeystore {
+		return map[string]interface{}{"success": false, "errorCode": "watchOnly"}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true, "signature": signature}, nil
+}
+
+func (handlers *Handlers) postVerifyMessage(r *http.Request) (interface{}, error) {
+	var input struct {
+		Address   string `json:"address"`
+		Message   string `json:"message"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	btcCoin, ok := handlers.account.Coin().(*btc.Coin)
+	if !ok {
+		return nil, errp.New("An account must be BTC based to verify messages")
+	}
+	err := btcCoin.VerifyMessage(input.Address, input.Message, input.Signature)
+	if errp.Cause(err) == message.ErrInvalidSignature {
+		return map[string]interface{}{"success": true, "valid": false}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true, "valid": true}, nil
+}
+
 func (handlers *Handlers) getReceiveAddresses(_ *http.Request) (interface{}, error) {
 	addresses := []interface{}{}
 	for _, address := range handlers.account.GetUnusedReceiveAddresses() {
+		encodedAddress := address.EncodeForHumans()
 		addresses = append(addresses, struct {
 			Address   string `json:"address"`
 			AddressID string `json:"addressID"`
+			Label     string `json:"label"`
 		}{
-			Address:   address.EncodeForHumans(),
+			Address:   encodedAddress,
 			AddressID: address.ID(),
+			Label:     handlers.labels.Get(labels.TypeAddr, encodedAddress),
 		})
 	}
 	return addresses, nil
@@ -512,3 +968,20 @@ func (handlers *Handlers) postConvertToLegacyAddress(r *http.Request) (interface
 	}
 	return address.EncodeAddress(), nil
 }
+
+// postLabel sets the label of a transaction, output or address. An empty label removes it.
+func (handlers *Handlers) postLabel(r *http.Request) (interface{}, error) {
+	var input struct {
+		Type  labels.Type `json:"type"`
+		Ref   string      `json:"ref"`
+		Label string      `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.labels.SetLabel(input.Type, input.Ref, input.Label); err != nil {
+		handlers.log.WithError(err).Error("Could not set the label")
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
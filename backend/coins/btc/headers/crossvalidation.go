@@ -0,0 +1,297 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	btcdBlockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// validationTimeout is the time to wait for the headers of a validator.
+const validationTimeout = 30 * time.Second
+
+// maxValidationHeaders is the maximum number of headers requested from a validator, which is the
+// maximum batch size of Electrum servers.
+const maxValidationHeaders = 2016
+
+type chainComparison int
+
+const (
+	// chainAgrees means one chain is a prefix of the other.
+	chainAgrees chainComparison = iota
+	// chainLowerWork means the validator reports a fork with less work than ours.
+	chainLowerWork
+	// chainHigherWork means the validator reports a fork with at least as much work as ours, i.e.
+	// the blockchain connection might be hiding the best chain from us (eclipse attack).
+	chainHigherWork
+)
+
+// TipAgreed returns true if the majority of the servers agreed with our tip when it was last
+// cross-validated.
+func (headers *Headers) TipAgreed() bool {
+	return headers.Validation() == ValidationAgreed
+}
+
+// Validation returns the result of the last cross-validation of our tip. Transactions should only
+// be considered verified if it is ValidationAgreed or ValidationUnavailable.
+func (headers *Headers) Validation() Validation {
+	defer headers.validationLock.RLock()()
+	return headers.validation
+}
+
+// subscribeValidators keeps track of the tips of the validators. Our tip is cross-validated again
+// whenever a validator reports a new tip.
+func (headers *Headers) subscribeValidators() {
+	for index, validator := range headers.validators {
+		index := index
+		validator.HeadersSubscribe(
+			nil,
+			func(header *blockchain.Header) error {
+				changed := func() bool {
+					defer headers.validationLock.Lock()()
+					changed := headers.validatorTips[index] != header.BlockHeight
+					headers.validatorTips[index] = header.BlockHeight
+					return changed
+				}()
+				if changed {
+					headers.triggerValidation()
+				}
+				return nil
+			},
+		)
+	}
+}
+
+// kickValidation invalidates the agreement on the tip and triggers a cross-validation.
+func (headers *Headers) kickValidation() {
+	if len(headers.validators) == 0 {
+		return
+	}
+	func() {
+		defer headers.validationLock.Lock()()
+		if headers.validation != ValidationPending {
+			headers.validation = ValidationPending
+			headers.pendingSince = time.Now()
+		}
+	}()
+	headers.triggerValidation()
+}
+
+// triggerValidation triggers a cross-validation without invalidating the agreement on the tip.
+func (headers *Headers) triggerValidation() {
+	select {
+	case headers.validationKickChan <- struct{}{}:
+	default:
+	}
+}
+
+func (headers *Headers) crossValidateLoop() {
+	for range headers.validationKickChan {
+		headers.crossValidate()
+	}
+}
+
+// crossValidate compares our chain with the chains reported by the validators. The validators
+// include the server we synced from, so it is not counted separately: a lying server can't outvote
+// an honest one on its own. Validators which can't be reached do not count. If none could be
+// reached within validationTimeout, the tip is ValidationUnavailable.
+func (headers *Headers) crossValidate() {
+	localTip := headers.tip()
+	if localTip < 0 {
+		return
+	}
+	agree, disagree := 0, 0
+	for index, validator := range headers.validators {
+		validatorTip := func() int {
+			defer headers.validationLock.RLock()()
+			return headers.validatorTips[index]
+		}()
+		if validatorTip < 0 {
+			continue
+		}
+		log := headers.log.WithFields(logrus.Fields{"validator": index, "validatorTip": validatorTip})
+		comparison, err := headers.compareChain(validator, localTip, validatorTip)
+		if err != nil {
+			log.WithError(err).Warning("Could not cross-validate headers")
+			continue
+		}
+		switch comparison {
+		case chainAgrees:
+			agree++
+		case chainLowerWork:
+			log.Warning("Server reports a fork with less work")
+			disagree++
+		case chainHigherWork:
+			log.Warning("Server reports a fork with more work, the chain we synced might be hidden")
+			disagree++
+		}
+	}
+	headers.log.Infof("Cross-validated tip %d: %d servers agree, %d disagree",
+		localTip, agree, disagree)
+	var validation Validation
+	switch {
+	case agree > disagree:
+		validation = ValidationAgreed
+	case disagree > 0:
+		validation = ValidationDisagreed
+	}
+	previous, retryIn := func() (Validation, time.Duration) {
+		defer headers.validationLock.Lock()()
+		previous := headers.validation
+		if validation == "" {
+			// No validator could be reached or knows its tip yet.
+			if previous != ValidationPending {
+				return previous, 0
+			}
+			retryIn := time.Until(headers.pendingSince.Add(validationTimeout))
+			if retryIn > 0 {
+				return previous, retryIn
+			}
+			validation = ValidationUnavailable
+		}
+		headers.validation = validation
+		return previous, 0
+	}()
+	if retryIn > 0 {
+		time.AfterFunc(retryIn, headers.triggerValidation)
+		return
+	}
+	if disagree > 0 {
+		headers.notifyEvent(EventServersDisagree)
+	}
+	if validation == previous {
+		return
+	}
+	switch validation {
+	case ValidationAgreed:
+		headers.notifyEvent(EventTipAgreed)
+	case ValidationUnavailable:
+		headers.log.Warning("No server could cross-validate the tip")
+		headers.notifyEvent(EventTipUnvalidated)
+	}
+}
+
+// fetchHeaders retrieves headers from a server and waits for the result.
+func fetchHeaders(server blockchain.Interface, startHeight int, count int) ([]*wire.BlockHeader, error) {
+	type result struct {
+		blockHeaders []*wire.BlockHeader
+		err          error
+	}
+	resultChan := make(chan result, 2)
+	server.Headers(
+		startHeight, count,
+		func(blockHeaders []*wire.BlockHeader, max int) error {
+			resultChan <- result{blockHeaders: blockHeaders}
+			return nil
+		},
+		func(err error) {
+			if err != nil {
+				resultChan <- result{err: err}
+			}
+		})
+	select {
+	case result := <-resultChan:
+		return result.blockHeaders, result.err
+	case <-time.After(validationTimeout):
+		return nil, errp.New("timeout")
+	}
+}
+
+// checkValidatorHeaders checks that the validator headers are connected and, where we check it for our own
+// chain, have valid proof of work, so a server can't claim more work than it has.
+func (headers *Headers) checkValidatorHeaders(blockHeaders []*wire.BlockHeader) error {
+	checkPoW := headers.net.Net == chaincfg.MainNetParams.Net || headers.net.Net == ltc.MainNetParams.Net
+	for index, header := range blockHeaders {
+		if index > 0 && header.PrevBlock != blockHeaders[index-1].BlockHash() {
+			return errp.New("validator headers do not connect")
+		}
+		if !checkPoW {
+			continue
+		}
+		headerSerialized := &bytes.Buffer{}
+		if err := header.BtcEncode(headerSerialized, 0, wire.BaseEncoding); err != nil {
+			panic(errp.WithStack(err))
+		}
+		powHash := headers.powHash(headerSerialized.Bytes())
+		if btcdBlockchain.HashToBig(&powHash).Cmp(btcdBlockchain.CompactToBig(header.Bits)) > 0 {
+			return errp.Newf("validator header %s has insufficient proof of work", powHash)
+		}
+	}
+	return nil
+}
+
+func work(blockHeaders []*wire.BlockHeader) *big.Int {
+	result := new(big.Int)
+	for _, header := range blockHeaders {
+		result.Add(result, btcdBlockchain.CalcWork(header.Bits))
+	}
+	return result
+}
+
+// compareChain compares our chain with the chain of a validator. The headers of the validator
+// going back up to reorgLimit blocks below the lower tip are fetched to find the fork point.
+func (headers *Headers) compareChain(
+	validator blockchain.Interface, localTip int, validatorTip int) (chainComparison, error) {
+	startHeight := min(localTip, validatorTip) - reorgLimit
+	if startHeight < 0 {
+		startHeight = 0
+	}
+	validatorHeaders, err := fetchHeaders(
+		validator, startHeight, min(validatorTip-startHeight+1, maxValidationHeaders))
+	if err != nil {
+		return 0, err
+	}
+	if len(validatorHeaders) == 0 {
+		return 0, errp.New("validator returned no headers")
+	}
+	if err := headers.checkValidatorHeaders(validatorHeaders); err != nil {
+		return 0, err
+	}
+
+	defer headers.lock.RLock()()
+	localHeaders := []*wire.BlockHeader{}
+	for height := startHeight; height <= localTip; height++ {
+		header, err := headers.db.HeaderByHeight(height)
+		if err != nil {
+			return 0, err
+		}
+		if header == nil {
+			// Our tip was reverted in the meantime.
+			break
+		}
+		localHeaders = append(localHeaders, header)
+	}
+	common := 0
+	for common < len(localHeaders) && common < len(validatorHeaders) &&
+		localHeaders[common].BlockHash() == validatorHeaders[common].BlockHash() {
+		common++
+	}
+	if common == len(localHeaders) || common == len(validatorHeaders) {
+		return chainAgrees, nil
+	}
+	if work(validatorHeaders[common:]).Cmp(work(localHeaders[common:])) < 0 {
+		return chainLowerWork, nil
+	}
+	return chainHigherWork, nil
+}
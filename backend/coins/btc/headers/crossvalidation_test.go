@@ -0,0 +1,220 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	blockchainMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/db/headersdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// extendChain returns the chain with count headers appended. The nonce distinguishes forks.
+func extendChain(chain []*wire.BlockHeader, count int, nonce uint32) []*wire.BlockHeader {
+	result := append([]*wire.BlockHeader{}, chain...)
+	for i := 0; i < count; i++ {
+		header := chaincfg.TestNet3Params.GenesisBlock.Header
+		header.PrevBlock = result[len(result)-1].BlockHash()
+		header.Nonce = nonce
+		result = append(result, &header)
+	}
+	return result
+}
+
+// newValidator returns a blockchain mock serving the given chain.
+func newValidator(chain []*wire.BlockHeader) *blockchainMock.Interface {
+	validator := &blockchainMock.Interface{}
+	validator.On("Headers", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) {
+			startHeight := args.Int(0)
+			end := startHeight + args.Int(1)
+			if end > len(chain) {
+				end = len(chain)
+			}
+			success := args.Get(2).(func([]*wire.BlockHeader, int) error)
+			if err := success(chain[startHeight:end], maxValidationHeaders); err != nil {
+				panic(err)
+			}
+			args.Get(3).(func(error))(nil)
+		})
+	return validator
+}
+
+func newTestHeaders(t *testing.T, chain []*wire.BlockHeader, validatorChains ...[]*wire.BlockHeader) *Headers {
+	t.Helper()
+	db, err := headersdb.NewDB(test.TstTempFile("bitbox-wallet-headers-"))
+	require.NoError(t, err)
+	for height, header := range chain {
+		require.NoError(t, db.PutHeader(height, header))
+	}
+	validators := []blockchain.Interface{}
+	for _, validatorChain := range validatorChains {
+		validators = append(validators, newValidator(validatorChain))
+	}
+	headers := NewHeaders(&chaincfg.TestNet3Params, db, &blockchainMock.Interface{}, validators,
+		logging.Get().WithGroup("headers_test"))
+	for index, validatorChain := range validatorChains {
+		headers.validatorTips[index] = len(validatorChain) - 1
+	}
+	return headers
+}
+
+func subscribe(headers *Headers) <-chan Event {
+	events := make(chan Event, 10)
+	headers.SubscribeEvent(func(event Event) { events <- event })
+	return events
+}
+
+func requireEvent(t *testing.T, events <-chan Event, expected Event) {
+	t.Helper()
+	select {
+	case event := <-events:
+		require.Equal(t, expected, event)
+	case <-time.After(time.Second):
+		require.FailNow(t, "event not fired", expected)
+	}
+}
+
+func TestCrossValidateAgree(t *testing.T) {
+	genesis := chaincfg.TestNet3Params.GenesisBlock.Header
+	chain := extendChain([]*wire.BlockHeader{&genesis}, 5, 1)
+
+	// Without validators, the tip is always agreed.
+	require.True(t, newTestHeaders(t, chain).TipAgreed())
+
+	// The validators are on the same chain, one lagging behind and one ahead.
+	headers := newTestHeaders(t, chain, chain[:4], extendChain(chain, 2, 1))
+	require.Equal(t, ValidationPending, headers.Validation())
+	events := subscribe(headers)
+	headers.crossValidate()
+	require.True(t, headers.TipAgreed())
+	requireEvent(t, events, EventTipAgreed)
+}
+
+func TestCrossValidateFork(t *testing.T) {
+	genesis := chaincfg.TestNet3Params.GenesisBlock.Header
+	chain := extendChain([]*wire.BlockHeader{&genesis}, 5, 1)
+	lowerWorkFork := extendChain(chain[:4], 1, 2)
+	higherWorkFork := extendChain(chain[:4], 4, 2)
+
+	comparison, err := newTestHeaders(t, chain).compareChain(newValidator(lowerWorkFork), 5, 4)
+	require.NoError(t, err)
+	require.Equal(t, chainLowerWork, comparison)
+	comparison, err = newTestHeaders(t, chain).compareChain(newValidator(higherWorkFork), 5, 7)
+	require.NoError(t, err)
+	require.Equal(t, chainHigherWork, comparison)
+
+	// Two validators agree, so the majority agrees with our tip despite the lower-work fork.
+	headers := newTestHeaders(t, chain, chain, chain, lowerWorkFork)
+	events := subscribe(headers)
+	headers.crossValidate()
+	require.True(t, headers.TipAgreed())
+	// Events are delivered concurrently.
+	receivedEvents := []Event{<-events, <-events}
+	require.ElementsMatch(t, []Event{EventServersDisagree, EventTipAgreed}, receivedEvents)
+
+	// Both validators report a different chain.
+	headers = newTestHeaders(t, chain, lowerWorkFork, higherWorkFork)
+	events = subscribe(headers)
+	headers.crossValidate()
+	require.Equal(t, ValidationDisagreed, headers.Validation())
+	requireEvent(t, events, EventServersDisagree)
+
+	// A validator serving headers which don't connect is ignored.
+	brokenChain := extendChain(chain, 1, 1)
+	brokenChain[3] = lowerWorkFork[4]
+	headers = newTestHeaders(t, chain, chain, brokenChain)
+	headers.crossValidate()
+	require.True(t, headers.TipAgreed())
+
+}
+
+// TestCrossValidateUnavailable tests that the validation stays pending while no validator can be
+// reached, and falls back to ValidationUnavailable after validationTimeout.
+func TestCrossValidateUnavailable(t *testing.T) {
+	genesis := chaincfg.TestNet3Params.GenesisBlock.Header
+	chain := extendChain([]*wire.BlockHeader{&genesis}, 5, 1)
+	brokenChain := extendChain(chain, 1, 1)
+	brokenChain[3] = extendChain(chain[:3], 1, 2)[3]
+
+	headers := newTestHeaders(t, chain, brokenChain)
+	events := subscribe(headers)
+	headers.crossValidate()
+	require.Equal(t, ValidationPending, headers.Validation())
+
+	headers.pendingSince = time.Now().Add(-validationTimeout)
+	headers.crossValidate()
+	require.Equal(t, ValidationUnavailable, headers.Validation())
+	require.False(t, headers.TipAgreed())
+	requireEvent(t, events, EventTipUnvalidated)
+
+	// Validators which don't know their tip yet don't count either.
+	headers = newTestHeaders(t, chain, chain)
+	headers.validatorTips[0] = -1
+	headers.pendingSince = time.Now().Add(-validationTimeout)
+	headers.crossValidate()
+	require.Equal(t, ValidationUnavailable, headers.Validation())
+}
+
+// TestValidatorTipChanged tests that a new tip of a validator triggers a cross-validation, without
+// invalidating the agreement on our tip.
+func TestValidatorTipChanged(t *testing.T) {
+	genesis := chaincfg.TestNet3Params.GenesisBlock.Header
+	chain := extendChain([]*wire.BlockHeader{&genesis}, 5, 1)
+	headers := newTestHeaders(t, chain, chain)
+	validator := headers.validators[0].(*blockchainMock.Interface)
+	var onTip func(*blockchain.Header) error
+	validator.On("HeadersSubscribe", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		onTip = args.Get(1).(func(*blockchain.Header) error)
+	})
+	headers.subscribeValidators()
+	headers.crossValidate()
+	require.True(t, headers.TipAgreed())
+
+	require.NoError(t, onTip(&blockchain.Header{BlockHeight: 7}))
+	require.Equal(t, 7, headers.validatorTips[0])
+	require.True(t, headers.TipAgreed())
+	select {
+	case <-headers.validationKickChan:
+	default:
+		require.FailNow(t, "cross-validation not triggered")
+	}
+	// The same tip again does not trigger it.
+	require.NoError(t, onTip(&blockchain.Header{BlockHeight: 7}))
+	require.Empty(t, headers.validationKickChan)
+}
+
+// TestCrossValidatePrimaryLies tests that with two servers, the server we synced from can't
+// outvote the other server, as it is only counted once.
+func TestCrossValidatePrimaryLies(t *testing.T) {
+	genesis := chaincfg.TestNet3Params.GenesisBlock.Header
+	honestChain := extendChain([]*wire.BlockHeader{&genesis}, 8, 1)
+	// The primary server hides the best chain and serves a fork with less work.
+	lyingChain := extendChain(honestChain[:4], 2, 2)
+
+	headers := newTestHeaders(t, lyingChain, lyingChain, honestChain)
+	events := subscribe(headers)
+	headers.crossValidate()
+	require.Equal(t, ValidationDisagreed, headers.Validation())
+	requireEvent(t, events, EventServersDisagree)
+}
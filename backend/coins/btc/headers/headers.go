@@ -46,9 +46,34 @@ const (
 	EventSynced Event = "synced"
 	// EventNewTip is fired when a new tip is known.
 	EventNewTip Event = "newTip"
+	// EventServersDisagree is fired when a server used for cross-validation reports a different
+	// chain than the one we synced, either a fork with less work or a chain with more work.
+	EventServersDisagree Event = "serversDisagree"
+	// EventTipAgreed is fired when the majority of the servers agree with our tip after it was not
+	// cross-validated.
+	EventTipAgreed Event = "tipAgreed"
+	// EventTipUnvalidated is fired when no server could cross-validate our tip within
+	// validationTimeout, see ValidationUnavailable.
+	EventTipUnvalidated Event = "tipUnvalidated"
+)
+
+// Validation is the result of the cross-validation of our tip.
+type Validation string
+
+const (
+	// ValidationPending means the tip is not cross-validated yet.
+	ValidationPending Validation = "pending"
+	// ValidationAgreed means the majority of the servers agreed with our tip.
+	ValidationAgreed Validation = "agreed"
+	// ValidationDisagreed means the majority of the servers did not agree with our tip.
+	ValidationDisagreed Validation = "disagreed"
+	// ValidationUnavailable means no server could be reached or reported its tip within
+	// validationTimeout. The tip is only validated by the blockchain connection it was synced from.
+	ValidationUnavailable Validation = "unvalidated"
 )
 
 // Interface represents the public API of this package.
+//
 //go:generate mockery -name Interface
 type Interface interface {
 	Initialize()
@@ -56,6 +81,7 @@ type Interface interface {
 	HeaderByHeight(int) (*wire.BlockHeader, error)
 	TipHeight() int
 	Status() (*Status, error)
+	Validation() Validation
 }
 
 // Headers manages syncing blockchain headers.
@@ -74,6 +100,20 @@ type Headers struct {
 	tipAtInitTime int
 	kickChan      chan struct{}
 
+	// validators are connections to all servers, used to cross-validate the headers downloaded
+	// from the blockchain connection. Each server is counted once, including the one the
+	// blockchain connection currently uses.
+	validators []blockchain.Interface
+	// validatorTips are the latest tip heights reported by the validators, -1 if unknown.
+	validatorTips []int
+	// validation is the result of the last cross-validation of our tip.
+	validation Validation
+	// pendingSince is the time since which the validation is pending. After validationTimeout
+	// without any validator, the validation falls back to ValidationUnavailable.
+	pendingSince       time.Time
+	validationLock     locker.Locker
+	validationKickChan chan struct{}
+
 	eventCallbacks []func(Event)
 	events         chan Event
 }
@@ -85,14 +125,27 @@ type Status struct {
 	// Only well defined if Tip >= 0
 	TipHashHex   blockchain.TXHash `json:"tipHashHex"`
 	TargetHeight int               `json:"targetHeight"`
+	TipAgreed    bool              `json:"tipAgreed"`
+	Validation   Validation        `json:"validation"`
 }
 
-// NewHeaders creates a new Headers instance.
+// NewHeaders creates a new Headers instance. The headers are downloaded from blockchain and
+// cross-validated with the validators, which should be connections to all configured servers,
+// including the one used by blockchain. Without validators, the tip is always considered agreed.
 func NewHeaders(
 	net *chaincfg.Params,
 	db DBInterface,
 	blockchain blockchain.Interface,
+	validators []blockchain.Interface,
 	log *logrus.Entry) *Headers {
+	validatorTips := make([]int, len(validators))
+	for index := range validatorTips {
+		validatorTips[index] = -1
+	}
+	validation := ValidationPending
+	if len(validators) == 0 {
+		validation = ValidationAgreed
+	}
 	return &Headers{
 		log: log,
 
@@ -106,6 +159,12 @@ func NewHeaders(
 		tipAtInitTime:   0,
 		kickChan:        make(chan struct{}, 1),
 
+		validators:         validators,
+		validatorTips:      validatorTips,
+		validation:         validation,
+		pendingSince:       time.Now(),
+		validationKickChan: make(chan struct{}, 1),
+
 		eventCallbacks: []func(Event){},
 		events:         make(chan Event),
 	}
@@ -132,6 +191,12 @@ func (headers *Headers) Initialize() {
 	headers.tipAtInitTime = headers.tip()
 	headers.log.Infof("last tip loaded: %d", headers.tipAtInitTime)
 	go headers.download()
+	if len(headers.validators) != 0 {
+		go headers.crossValidateLoop()
+		headers.subscribeValidators()
+		// The tip loaded from the DB is validated right away, even if no new headers arrive.
+		headers.triggerValidation()
+	}
 	headers.blockchain.HeadersSubscribe(
 		nil,
 		func(header *blockchain.Header) error {
@@ -347,6 +412,7 @@ func (headers *Headers) processBatch(
 		headers.notifyEvent(EventSyncing)
 	} else if len(blockHeaders) != 0 {
 		headers.log.Debugf("Synced headers; tip: %d", tip)
+		headers.kickValidation()
 		headers.notifyEvent(EventSynced)
 	}
 	headers.headersPerBatch = max
@@ -405,5 +471,7 @@ func (headers *Headers) Status() (*Status, error) {
 		Tip:           tip,
 		TargetHeight:  headers.targetHeight,
 		TipHashHex:    tipHashHex,
+		TipAgreed:     headers.TipAgreed(),
+		Validation:    headers.Validation(),
 	}, nil
 }
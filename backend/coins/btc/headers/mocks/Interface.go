@@ -91,3 +91,17 @@ func (_m *Interface) TipHeight() int {
 
 	return r0
 }
+
+// Validation provides a mock function with given fields:
+func (_m *Interface) Validation() headers.Validation {
+	ret := _m.Called()
+
+	var r0 headers.Validation
+	if rf, ok := ret.Get(0).(func() headers.Validation); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(headers.Validation)
+	}
+
+	return r0
+}
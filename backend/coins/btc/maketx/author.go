@@ -48,3 +48,18 @@ func isDustAmount(
 	// (output size + input size) is greater than 1/3 of the relay fee.
 	return int64(amount)*1000/(3*int64(totalSize)) < int64(relayFeePerKb)
 }
+
+// maxDustAmount returns the largest amount which is still considered dust by isDustAmount, or 0 if
+// no amount is dust.
+func maxDustAmount(
+	pkScriptSize int,
+	configuration *signing.Configuration,
+	relayFeePerKb btcutil.Amount) btcutil.Amount {
+	sigScriptSize, _ := addresses.SigScriptWitnessSize(configuration)
+	totalSize := int64(outputSize(pkScriptSize) + calcInputSize(sigScriptSize))
+	maxDust := (3*totalSize*int64(relayFeePerKb)+999)/1000 - 1
+	if maxDust < 0 {
+		return 0
+	}
+	return btcutil.Amount(maxDust)
+}
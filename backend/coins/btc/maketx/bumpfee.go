@@ -0,0 +1,164 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/txsort"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// incrementalRelayFeePerKb is the minimum fee rate by which a replacement tx has to increase the
+// absolute fee, see rule 4 of BIP125. This is the default of Bitcoin Core.
+const incrementalRelayFeePerKb = btcutil.Amount(1000)
+
+// SignalsRBF returns true if the tx opted in to replace-by-fee (BIP125), i.e. if at least one input
+// has a sequence number lower than MaxTxInSequenceNum-1.
+func SignalsRBF(tx *wire.MsgTx) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTxBumpFee creates a replacement for originalTx which pays a higher fee (BIP125). The outputs
+// of originalTx are kept, and the fee is deducted from the change. If the change is not sufficient,
+// more outputs from spendableOutputs are added as inputs, largest first.
+//
+// previousOutputs must contain the outputs spent by originalTx. changeAddress is the address of the
+// change output of originalTx, or nil if it has none, in which case getChangeAddress is used if new
+// change is needed. spendableOutputs must not contain unconfirmed outputs (rule 2 of BIP125).
+func NewTxBumpFee(
+	coin coin.Coin,
+	inputConfiguration *signing.Configuration,
+	originalTx *wire.MsgTx,
+	previousOutputs map[wire.OutPoint]*wire.TxOut,
+	changeAddress *addresses.AccountAddress,
+	spendableOutputs map[wire.OutPoint]*wire.TxOut,
+	feePerKb btcutil.Amount,
+	getChangeAddress func() *addresses.AccountAddress,
+	log *logrus.Entry,
+) (*TxProposal, error) {
+	originalFee := btcutil.Amount(0)
+	selectedOutPoints := []wire.OutPoint{}
+	selectedOutputsSum := btcutil.Amount(0)
+	for _, txIn := range originalTx.TxIn {
+		previousOutput, ok := previousOutputs[txIn.PreviousOutPoint]
+		if !ok {
+			return nil, errp.New("all inputs of the tx to replace must be known")
+		}
+		selectedOutPoints = append(selectedOutPoints, txIn.PreviousOutPoint)
+		selectedOutputsSum += btcutil.Amount(previousOutput.Value)
+		originalFee += btcutil.Amount(previousOutput.Value)
+	}
+	var changePKScript []byte
+	if changeAddress != nil {
+		changePKScript = changeAddress.PubkeyScript()
+	}
+	var outputs []*wire.TxOut
+	for _, txOut := range originalTx.TxOut {
+		originalFee -= btcutil.Amount(txOut.Value)
+		if changePKScript != nil && bytes.Equal(txOut.PkScript, changePKScript) {
+			continue
+		}
+		outputs = append(outputs, txOut)
+	}
+	if len(outputs) == 0 {
+		return nil, errp.New("the tx to replace has no recipient")
+	}
+	targetAmount := btcutil.Amount(0)
+	for _, output := range outputs {
+		targetAmount += btcutil.Amount(output.Value)
+	}
+	if changeAddress == nil {
+		changeAddress = getChangeAddress()
+		changePKScript = changeAddress.PubkeyScript()
+	}
+	requiredFee := func(inputCount int) btcutil.Amount {
+		txSize := estimateTxSize(inputCount, inputConfiguration, pkScriptSizes(outputs), len(changePKScript))
+		fee := feeForSerializeSize(feePerKb, txSize, log)
+		if minFee := originalFee + feeForSerializeSize(incrementalRelayFeePerKb, txSize, log); fee < minFee {
+			fee = minFee
+		}
+		return fee
+	}
+
+	candidates := []wire.OutPoint{}
+	for _, outPoint := range sortedByValueDesc(spendableOutputs) {
+		if _, ok := previousOutputs[outPoint]; ok {
+			continue
+		}
+		if outPoint.Hash == originalTx.TxHash() {
+			// Can't spend outputs of the tx being replaced.
+			continue
+		}
+		candidates = append(candidates, outPoint)
+	}
+	for selectedOutputsSum-targetAmount < requiredFee(len(selectedOutPoints)) {
+		if len(candidates) == 0 {
+			return nil, errp.WithStack(errors.ErrInsufficientFunds)
+		}
+		selectedOutPoints = append(selectedOutPoints, candidates[0])
+		selectedOutputsSum += btcutil.Amount(spendableOutputs[candidates[0]].Value)
+		candidates = candidates[1:]
+	}
+	maxRequiredFee := requiredFee(len(selectedOutPoints))
+
+	inputs := make([]*wire.TxIn, len(selectedOutPoints))
+	for i, outPoint := range selectedOutPoints {
+		outPoint := outPoint // avoids referencing the same variable across loop iterations
+		inputs[i] = wire.NewTxIn(&outPoint, nil, nil)
+		inputs[i].Sequence = RBFSequence
+	}
+	unsignedTransaction := &wire.MsgTx{
+		Version:  wire.TxVersion,
+		TxIn:     inputs,
+		TxOut:    outputs,
+		LockTime: originalTx.LockTime,
+	}
+	changeAmount := selectedOutputsSum - targetAmount - maxRequiredFee
+	finalFee := maxRequiredFee
+	if isDustAmount(changeAmount, len(changePKScript), changeAddress.Configuration, feePerKb) {
+		log.Info("change is dust")
+		finalFee = selectedOutputsSum - targetAmount
+	}
+	if changeAmount != 0 && finalFee == maxRequiredFee {
+		unsignedTransaction.TxOut = append(unsignedTransaction.TxOut,
+			wire.NewTxOut(int64(changeAmount), changePKScript))
+	} else {
+		changeAddress = nil
+	}
+	txsort.InPlaceSort(unsignedTransaction)
+	log.WithFields(logrus.Fields{"originalFee": originalFee, "fee": finalFee}).
+		Debug("Preparing replacement transaction")
+	return &TxProposal{
+		Coin:                 coin,
+		AccountConfiguration: inputConfiguration,
+		Amount:               targetAmount,
+		Fee:                  finalFee,
+		Transaction:          unsignedTransaction,
+		ChangeAddress:        changeAddress,
+	}, nil
+}
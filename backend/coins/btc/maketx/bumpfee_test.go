@@ -0,0 +1,104 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx_test
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *newTxSuite) bumpFee(
+	originalTx *wire.MsgTx,
+	previousOutputs map[wire.OutPoint]*wire.TxOut,
+	spendableOutputs map[wire.OutPoint]*wire.TxOut,
+	feePerKb btcutil.Amount,
+) (*maketx.TxProposal, error) {
+	return maketx.NewTxBumpFee(
+		tbtc,
+		s.inputConfiguration,
+		originalTx,
+		previousOutputs,
+		s.changeAddress,
+		spendableOutputs,
+		feePerKb,
+		s.getChangeAddress,
+		s.log,
+	)
+}
+
+func (s *newTxSuite) changeValue(tx *wire.MsgTx) int64 {
+	for _, txOut := range tx.TxOut {
+		if bytes.Equal(txOut.PkScript, s.changeAddress.PubkeyScript()) {
+			return txOut.Value
+		}
+	}
+	return 0
+}
+
+func (s *newTxSuite) TestNewTxBumpFee() {
+	const mBTC = 100000
+	amount := btcutil.Amount(1000 * mBTC) // 1 BTC
+
+	utxo := s.buildUTXO(2000 * mBTC)
+	original, err := s.newTx(amount, 1000, utxo)
+	require.NoError(s.T(), err)
+	require.True(s.T(), maketx.SignalsRBF(original.Transaction))
+	require.Equal(s.T(), btcutil.Amount(txSizeOneInput), original.Fee)
+
+	// The new fee is deducted from the change.
+	replacement, err := s.bumpFee(original.Transaction, utxo, nil, 5000)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), btcutil.Amount(5*txSizeOneInput), replacement.Fee)
+	require.Equal(s.T(), amount, replacement.Amount)
+	require.Equal(s.T(), s.changeAddress, replacement.ChangeAddress)
+	require.Len(s.T(), replacement.Transaction.TxIn, 1)
+	require.Equal(s.T(), uint32(maketx.RBFSequence), replacement.Transaction.TxIn[0].Sequence)
+	require.Equal(s.T(), int64(1000*mBTC-5*txSizeOneInput), s.changeValue(replacement.Transaction))
+
+	// The replacement must pay at least the original fee plus the incremental relay fee.
+	replacement, err = s.bumpFee(original.Transaction, utxo, nil, 1500)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), btcutil.Amount(2*txSizeOneInput), replacement.Fee)
+}
+
+func (s *newTxSuite) TestNewTxBumpFeeAddInputs() {
+	const mBTC = 100000
+	amount := btcutil.Amount(1000 * mBTC) // 1 BTC
+
+	utxo := s.buildUTXO(1000*mBTC + txSizeOneInput + 1000)
+	original, err := s.newTx(amount, 1000, utxo)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(1000), s.changeValue(original.Transaction))
+
+	// The change is not sufficient and there are no other coins.
+	_, err = s.bumpFee(original.Transaction, utxo, nil, 10000)
+	require.Equal(s.T(), errors.ErrInsufficientFunds, errp.Cause(err))
+
+	extraCoin := s.coin(5)
+	spendable := map[wire.OutPoint]*wire.TxOut{
+		extraCoin: wire.NewTxOut(mBTC, s.someAddresses[0].PubkeyScript()),
+	}
+	replacement, err := s.bumpFee(original.Transaction, utxo, spendable, 10000)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), replacement.Transaction.TxIn, 2)
+	require.Equal(s.T(), btcutil.Amount(10*txSizeTwoInputs), replacement.Fee)
+	require.Equal(s.T(), int64(mBTC+txSizeOneInput+1000-10*txSizeTwoInputs), s.changeValue(replacement.Transaction))
+}
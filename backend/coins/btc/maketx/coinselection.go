@@ -0,0 +1,431 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// bnbMaxTries limits the number of nodes visited in the branch-and-bound search.
+	bnbMaxTries = 100000
+	// knapsackIterations is the number of random subsets tried by the knapsack solver.
+	knapsackIterations = 1000
+)
+
+// ErrNoChangelessMatch is returned by CoinSelectionBranchAndBound if no subset of the outputs pays
+// the target without creating a change output.
+var ErrNoChangelessMatch = errp.New("no changeless coin selection found")
+
+// CoinSelectionParams contains the info a coin selection strategy needs to decide which outputs
+// to spend. All inputs are assumed to be of the same structure, defined by InputConfiguration.
+type CoinSelectionParams struct {
+	// TargetAmount is the sum of the recipient outputs. The fee is not included.
+	TargetAmount btcutil.Amount
+	// FeePerKb is the fee rate the tx is created with.
+	FeePerKb btcutil.Amount
+	// LongTermFeePerKb is the fee rate we expect to pay when spending outputs in the future. If
+	// the current fee rate is higher, spending fewer inputs is less wasteful, and vice versa.
+	LongTermFeePerKb btcutil.Amount
+	// InputConfiguration defines the structure of every input.
+	InputConfiguration *signing.Configuration
+	// OutputPkScriptSizes are the sizes of the recipient pkScripts.
+	OutputPkScriptSizes []int
+	// ChangePkScriptSize is the size of the change pkScript.
+	ChangePkScriptSize int
+
+	Log *logrus.Entry
+
+	// feeCache caches the results of fee(), which is called very often during the search.
+	feeCache map[int]btcutil.Amount
+}
+
+// fee returns the fee required for a tx spending inputCount inputs. Like everywhere in this
+// package, the fee of the change output is always included.
+func (params *CoinSelectionParams) fee(inputCount int) btcutil.Amount {
+	if fee, ok := params.feeCache[inputCount]; ok {
+		return fee
+	}
+	if params.feeCache == nil {
+		params.feeCache = map[int]btcutil.Amount{}
+	}
+	fee := feeForSerializeSize(
+		params.FeePerKb,
+		estimateTxSize(inputCount, params.InputConfiguration, params.OutputPkScriptSizes, params.ChangePkScriptSize),
+		params.Log,
+	)
+	params.feeCache[inputCount] = fee
+	return fee
+}
+
+// required returns the sum the selected outputs need to cover if inputCount inputs are spent.
+func (params *CoinSelectionParams) required(inputCount int) btcutil.Amount {
+	return params.TargetAmount + params.fee(inputCount)
+}
+
+// inputFee returns the fee for spending one input at the given fee rate.
+func (params *CoinSelectionParams) inputFee(feePerKb btcutil.Amount) btcutil.Amount {
+	inputVSize := estimateTxSize(2, params.InputConfiguration, params.OutputPkScriptSizes, params.ChangePkScriptSize) -
+		estimateTxSize(1, params.InputConfiguration, params.OutputPkScriptSizes, params.ChangePkScriptSize)
+	return feePerKb * btcutil.Amount(inputVSize) / 1000
+}
+
+// changelessWindow returns the maximum excess which is donated to the miners instead of being
+// returned as change, as the change would be dust (see isDustAmount).
+func (params *CoinSelectionParams) changelessWindow() btcutil.Amount {
+	return maxDustAmount(params.ChangePkScriptSize, params.InputConfiguration, params.FeePerKb)
+}
+
+// costOfChange is the cost of creating a change output now and spending it in the future.
+func (params *CoinSelectionParams) costOfChange() btcutil.Amount {
+	return params.FeePerKb*btcutil.Amount(outputSize(params.ChangePkScriptSize))/1000 +
+		params.inputFee(params.LongTermFeePerKb)
+}
+
+// waste computes the waste metric of a selection with the given number of inputs and total
+// value. The waste is the extra fee paid for the inputs now compared to spending them at the long
+// term fee rate, plus either the excess donated to the miners (changeless) or the cost of the
+// change output. Lower is better. The result can be negative if the current fee rate is below
+// the long term fee rate.
+func (params *CoinSelectionParams) waste(inputCount int, total btcutil.Amount) btcutil.Amount {
+	waste := btcutil.Amount(inputCount) * (params.inputFee(params.FeePerKb) - params.inputFee(params.LongTermFeePerKb))
+	excess := total - params.required(inputCount)
+	if excess <= params.changelessWindow() {
+		return waste + excess
+	}
+	return waste + params.costOfChange()
+}
+
+// CoinSelection is a coin selection strategy. It returns a subset of the given outputs which covers
+// params.TargetAmount plus the fee of a tx spending the selected outputs. errors.ErrInsufficientFunds
+// is returned if the outputs are not sufficient.
+type CoinSelection func(
+	params *CoinSelectionParams,
+	outputs map[wire.OutPoint]*wire.TxOut,
+) ([]wire.OutPoint, error)
+
+type byValue struct {
+	outPoints []wire.OutPoint
+	outputs   map[wire.OutPoint]*wire.TxOut
+}
+
+func (p *byValue) Len() int { return len(p.outPoints) }
+func (p *byValue) Less(i, j int) bool {
+	if p.outputs[p.outPoints[i]].Value == p.outputs[p.outPoints[j]].Value {
+		// Secondary sort to make coin selection deterministic.
+		return chainhash.HashH(p.outputs[p.outPoints[i]].PkScript).String() < chainhash.HashH(p.outputs[p.outPoints[j]].PkScript).String()
+	}
+	return p.outputs[p.outPoints[i]].Value < p.outputs[p.outPoints[j]].Value
+}
+func (p *byValue) Swap(i, j int) { p.outPoints[i], p.outPoints[j] = p.outPoints[j], p.outPoints[i] }
+
+// sortedByValueDesc returns the outpoints of the outputs, sorted by value descending.
+func sortedByValueDesc(outputs map[wire.OutPoint]*wire.TxOut) []wire.OutPoint {
+	outPoints := []wire.OutPoint{}
+	for outPoint := range outputs {
+		outPoints = append(outPoints, outPoint)
+	}
+	// Sort by outpoint first, so that outputs with equal values and pkScripts are ordered
+	// deterministically as well.
+	sort.Slice(outPoints, func(i, j int) bool {
+		if cmp := bytes.Compare(outPoints[i].Hash[:], outPoints[j].Hash[:]); cmp != 0 {
+			return cmp < 0
+		}
+		return outPoints[i].Index < outPoints[j].Index
+	})
+	sort.Stable(sort.Reverse(&byValue{outPoints, outputs}))
+	return outPoints
+}
+
+func sumOutputs(outPoints []wire.OutPoint, outputs map[wire.OutPoint]*wire.TxOut) btcutil.Amount {
+	sum := btcutil.Amount(0)
+	for _, outPoint := range outPoints {
+		sum += btcutil.Amount(outputs[outPoint].Value)
+	}
+	return sum
+}
+
+// CoinSelectionLargestFirst spends the largest outputs first until the target and fee are covered.
+func CoinSelectionLargestFirst(
+	params *CoinSelectionParams,
+	outputs map[wire.OutPoint]*wire.TxOut,
+) ([]wire.OutPoint, error) {
+	selectedOutPoints := []wire.OutPoint{}
+	outputsSum := btcutil.Amount(0)
+	for _, outPoint := range sortedByValueDesc(outputs) {
+		if len(selectedOutPoints) > 0 && outputsSum >= params.required(len(selectedOutPoints)) {
+			break
+		}
+		selectedOutPoints = append(selectedOutPoints, outPoint)
+		outputsSum += btcutil.Amount(outputs[outPoint].Value)
+	}
+	if len(selectedOutPoints) == 0 || outputsSum < params.required(len(selectedOutPoints)) {
+		return nil, errp.WithStack(errors.ErrInsufficientFunds)
+	}
+	return selectedOutPoints, nil
+}
+
+// candidates returns the outputs which are worth spending at the current fee rate (the value is
+// higher than the fee to spend it), sorted by value descending.
+func candidates(
+	params *CoinSelectionParams,
+	outputs map[wire.OutPoint]*wire.TxOut,
+) []wire.OutPoint {
+	inputFee := params.inputFee(params.FeePerKb)
+	result := []wire.OutPoint{}
+	for _, outPoint := range sortedByValueDesc(outputs) {
+		if btcutil.Amount(outputs[outPoint].Value) > inputFee {
+			result = append(result, outPoint)
+		}
+	}
+	return result
+}
+
+// CoinSelectionBranchAndBound searches for a subset of the outputs which pays the target without
+// needing a change output, i.e. the excess is small enough to be donated to the miners. Among all
+// such subsets found, the one with the lowest waste is returned. Returns ErrNoChangelessMatch if
+// there is none, or errors.ErrInsufficientFunds if the outputs are not sufficient at all.
+// See https://murch.one/wp-content/uploads/2016/11/erhardt2016coinselection.pdf.
+func CoinSelectionBranchAndBound(
+	params *CoinSelectionParams,
+	outputs map[wire.OutPoint]*wire.TxOut,
+) ([]wire.OutPoint, error) {
+	pool := candidates(params, outputs)
+	if sumOutputs(pool, outputs) < params.required(len(pool)) {
+		return nil, errp.WithStack(errors.ErrInsufficientFunds)
+	}
+	inputFee := params.inputFee(params.FeePerKb)
+	window := params.changelessWindow()
+	// remaining[i] is the sum of the effective values of pool[i:], used to cut branches which
+	// can't reach the target anymore.
+	remaining := make([]btcutil.Amount, len(pool)+1)
+	for i := len(pool) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + btcutil.Amount(outputs[pool[i]].Value) - inputFee
+	}
+
+	var best []wire.OutPoint
+	var bestWaste btcutil.Amount
+	selection := []wire.OutPoint{}
+	total := btcutil.Amount(0)
+	tries := 0
+
+	var search func(index int)
+	search = func(index int) {
+		if tries >= bnbMaxTries {
+			return
+		}
+		tries++
+		if len(selection) > 0 {
+			required := params.required(len(selection))
+			excess := total - required
+			if excess >= 0 {
+				if excess <= window {
+					waste := params.waste(len(selection), total)
+					if best == nil || waste < bestWaste {
+						best = append([]wire.OutPoint{}, selection...)
+						bestWaste = waste
+					}
+				}
+				// Adding more inputs only increases the excess.
+				return
+			}
+			// The effective values of all remaining outputs can't cover the missing amount.
+			if remaining[index] < -excess {
+				return
+			}
+		}
+		if index == len(pool) {
+			return
+		}
+		// Inclusion branch first, as outputs are sorted by value descending.
+		outPoint := pool[index]
+		selection = append(selection, outPoint)
+		total += btcutil.Amount(outputs[outPoint].Value)
+		search(index + 1)
+		selection = selection[:len(selection)-1]
+		total -= btcutil.Amount(outputs[outPoint].Value)
+		// Omission branch. Skip outputs of the same value, as the result would be equivalent.
+		next := index + 1
+		for next < len(pool) && outputs[pool[next]].Value == outputs[outPoint].Value {
+			next++
+		}
+		search(next)
+	}
+	search(0)
+
+	if best == nil {
+		return nil, errp.WithStack(ErrNoChangelessMatch)
+	}
+	params.Log.WithField("tries", tries).Debug("Branch and bound found a changeless coin selection")
+	return best, nil
+}
+
+// newDeterministicRand returns a pseudo random number generator seeded by the outpoints, so that
+// the coin selection is reproducible for the same set of outputs.
+func newDeterministicRand(outPoints []wire.OutPoint) *rand.Rand {
+	var buf bytes.Buffer
+	for _, outPoint := range outPoints {
+		_, _ = buf.Write(outPoint.Hash[:])
+		_ = binary.Write(&buf, binary.LittleEndian, outPoint.Index)
+	}
+	hash := chainhash.HashB(buf.Bytes())
+	return rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(hash))))
+}
+
+// approximateBestSubset tries random subsets of the pool and returns the one with the smallest
+// excess over the required amount. The pool must be sufficient to cover the required amount.
+func approximateBestSubset(
+	params *CoinSelectionParams,
+	pool []wire.OutPoint,
+	outputs map[wire.OutPoint]*wire.TxOut,
+	rng *rand.Rand,
+) []wire.OutPoint {
+	best := append([]wire.OutPoint{}, pool...)
+	bestExcess := sumOutputs(pool, outputs) - params.required(len(pool))
+	included := make([]bool, len(pool))
+	for iteration := 0; iteration < knapsackIterations && bestExcess != 0; iteration++ {
+		for i := range included {
+			included[i] = false
+		}
+		total := btcutil.Amount(0)
+		count := 0
+		reached := false
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i, outPoint := range pool {
+				// The first pass includes outputs randomly, the second pass includes all the
+				// outputs which were not included yet.
+				include := !included[i]
+				if pass == 0 {
+					include = rng.Intn(2) == 1
+				}
+				if !include {
+					continue
+				}
+				value := btcutil.Amount(outputs[outPoint].Value)
+				total += value
+				count++
+				included[i] = true
+				if excess := total - params.required(count); excess >= 0 {
+					reached = true
+					if excess < bestExcess {
+						bestExcess = excess
+						best = best[:0]
+						for j, included := range included {
+							if included {
+								best = append(best, pool[j])
+							}
+						}
+					}
+					total -= value
+					count--
+					included[i] = false
+				}
+			}
+		}
+	}
+	return best
+}
+
+// CoinSelectionKnapsack selects a random subset of the outputs with the smallest excess over the
+// target, similar to Bitcoin Core's knapsack solver. If a single larger output is a better fit, it
+// is taken instead. The randomness is seeded by the outputs, so the result is deterministic.
+func CoinSelectionKnapsack(
+	params *CoinSelectionParams,
+	outputs map[wire.OutPoint]*wire.TxOut,
+) ([]wire.OutPoint, error) {
+	pool := candidates(params, outputs)
+	window := params.changelessWindow()
+	// Outputs which cover the target on their own are only considered as a whole. The smallest of
+	// them is kept as a fallback.
+	var lowestLarger *wire.OutPoint
+	lowers := []wire.OutPoint{}
+	for _, outPoint := range pool {
+		outPoint := outPoint
+		value := btcutil.Amount(outputs[outPoint].Value)
+		excess := value - params.required(1)
+		switch {
+		case excess >= 0 && excess <= window:
+			// A single output is a changeless match.
+			return []wire.OutPoint{outPoint}, nil
+		case excess > window:
+			// pool is sorted descending, so the last one is the lowest.
+			lowestLarger = &outPoint
+		default:
+			lowers = append(lowers, outPoint)
+		}
+	}
+	lowersSum := sumOutputs(lowers, outputs)
+	if len(lowers) == 0 || lowersSum < params.required(len(lowers)) {
+		if lowestLarger == nil {
+			return nil, errp.WithStack(errors.ErrInsufficientFunds)
+		}
+		return []wire.OutPoint{*lowestLarger}, nil
+	}
+	best := approximateBestSubset(params, lowers, outputs, newDeterministicRand(pool))
+	if lowestLarger != nil {
+		bestExcess := sumOutputs(best, outputs) - params.required(len(best))
+		lowestLargerExcess := btcutil.Amount(outputs[*lowestLarger].Value) - params.required(1)
+		if bestExcess > window && lowestLargerExcess <= bestExcess {
+			return []wire.OutPoint{*lowestLarger}, nil
+		}
+	}
+	return best, nil
+}
+
+// CoinSelectionMinimizeWaste runs the branch-and-bound, knapsack and largest-first strategies and
+// returns the selection with the lowest waste (see CoinSelectionParams.waste). This is the default
+// strategy.
+func CoinSelectionMinimizeWaste(
+	params *CoinSelectionParams,
+	outputs map[wire.OutPoint]*wire.TxOut,
+) ([]wire.OutPoint, error) {
+	var best []wire.OutPoint
+	var bestWaste btcutil.Amount
+	for _, strategy := range []struct {
+		name      string
+		selection CoinSelection
+	}{
+		{"branchAndBound", CoinSelectionBranchAndBound},
+		{"knapsack", CoinSelectionKnapsack},
+		{"largestFirst", CoinSelectionLargestFirst},
+	} {
+		selectedOutPoints, err := strategy.selection(params, outputs)
+		if errp.Cause(err) == ErrNoChangelessMatch {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		waste := params.waste(len(selectedOutPoints), sumOutputs(selectedOutPoints, outputs))
+		params.Log.WithFields(logrus.Fields{"strategy": strategy.name, "waste": waste}).
+			Debug("Coin selection candidate")
+		if best == nil || waste < bestWaste {
+			best = selectedOutPoints
+			bestWaste = waste
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/txsort"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/sirupsen/logrus"
+)
+
+// NewTxCPFP creates a child tx spending the given outputs of an unconfirmed parent tx to the given
+// address of the wallet (child-pays-for-parent). The child fee is chosen such that the package of
+// the child and its unconfirmed ancestors pays feePerKb. ancestorsVSize and ancestorsFee are the
+// total virtual size and fee of the parent tx and its unconfirmed ancestors.
+func NewTxCPFP(
+	coin coin.Coin,
+	inputConfiguration *signing.Configuration,
+	ancestorsVSize int64,
+	ancestorsFee btcutil.Amount,
+	parentOutputs map[wire.OutPoint]*wire.TxOut,
+	address *addresses.AccountAddress,
+	feePerKb btcutil.Amount,
+	log *logrus.Entry,
+) (*TxProposal, error) {
+	if len(parentOutputs) == 0 {
+		return nil, errp.New("no outputs to spend")
+	}
+	pkScript := address.PubkeyScript()
+	inputs := []*wire.TxIn{}
+	outputsSum := btcutil.Amount(0)
+	for _, outPoint := range sortedByValueDesc(parentOutputs) {
+		outPoint := outPoint // avoids referencing the same variable across loop iterations
+		input := wire.NewTxIn(&outPoint, nil, nil)
+		input.Sequence = RBFSequence
+		inputs = append(inputs, input)
+		outputsSum += btcutil.Amount(parentOutputs[outPoint].Value)
+	}
+	childSize := estimateTxSize(len(inputs), inputConfiguration, []int{len(pkScript)}, 0)
+	childFee := feeForSerializeSize(feePerKb, int(ancestorsVSize)+childSize, log) - ancestorsFee
+	if childFee <= feeForSerializeSize(feePerKb, childSize, log) {
+		// The ancestors already pay at least the target fee rate.
+		return nil, errp.WithStack(errors.ErrFeeTooLow)
+	}
+	if isDustAmount(outputsSum-childFee, len(pkScript), address.Configuration, feePerKb) {
+		return nil, errp.WithStack(errors.ErrInsufficientFunds)
+	}
+	output := wire.NewTxOut(int64(outputsSum-childFee), pkScript)
+	unsignedTransaction := &wire.MsgTx{
+		Version:  wire.TxVersion,
+		TxIn:     inputs,
+		TxOut:    []*wire.TxOut{output},
+		LockTime: 0,
+	}
+	txsort.InPlaceSort(unsignedTransaction)
+	log.WithFields(logrus.Fields{"ancestorsFee": ancestorsFee, "fee": childFee}).
+		Debug("Preparing child-pays-for-parent transaction")
+	return &TxProposal{
+		Coin:                 coin,
+		AccountConfiguration: inputConfiguration,
+		Amount:               btcutil.Amount(output.Value),
+		Fee:                  childFee,
+		Transaction:          unsignedTransaction,
+		ChangeAddress:        address,
+	}, nil
+}
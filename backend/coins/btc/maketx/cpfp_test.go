@@ -0,0 +1,54 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maketx_test
+
+import (
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *newTxSuite) TestNewTxCPFP() {
+	const mBTC = 100000
+	const parentVSize = 200
+	feePerKb := btcutil.Amount(10000) // 10 sat / vbyte
+
+	parentOutputs := s.buildUTXO(1000 * mBTC)
+	childSize := maketx.TstEstimateTxSize(1, s.inputConfiguration, []int{len(s.changeAddress.PubkeyScript())}, 0)
+	expectedFee := btcutil.Amount(10*(parentVSize+childSize) - parentVSize)
+
+	txProposal, err := maketx.NewTxCPFP(
+		tbtc, s.inputConfiguration, parentVSize, parentVSize, parentOutputs, s.changeAddress, feePerKb, s.log)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), expectedFee, txProposal.Fee)
+	require.Equal(s.T(), s.changeAddress, txProposal.ChangeAddress)
+	require.Len(s.T(), txProposal.Transaction.TxIn, 1)
+	require.Equal(s.T(), s.coin(0), txProposal.Transaction.TxIn[0].PreviousOutPoint)
+	require.Len(s.T(), txProposal.Transaction.TxOut, 1)
+	require.Equal(s.T(), int64(1000*mBTC-expectedFee), txProposal.Transaction.TxOut[0].Value)
+	require.Equal(s.T(), s.changeAddress.PubkeyScript(), txProposal.Transaction.TxOut[0].PkScript)
+
+	// The parent already pays enough.
+	_, err = maketx.NewTxCPFP(
+		tbtc, s.inputConfiguration, parentVSize, 10*parentVSize, parentOutputs, s.changeAddress, feePerKb, s.log)
+	require.Equal(s.T(), errors.ErrFeeTooLow, errp.Cause(err))
+
+	// The output can't cover the fee.
+	_, err = maketx.NewTxCPFP(
+		tbtc, s.inputConfiguration, parentVSize, parentVSize, s.buildUTXO(1000), s.changeAddress, feePerKb, s.log)
+	require.Equal(s.T(), errors.ErrInsufficientFunds, errp.Cause(err))
+}
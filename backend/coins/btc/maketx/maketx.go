@@ -15,9 +15,6 @@
 package maketx
 
 import (
-	"sort"
-
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/txsort"
@@ -29,6 +26,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// RBFSequence is the input sequence number used in new transactions. It signals opt-in
+// replace-by-fee (BIP125), so that the tx can be replaced by a tx paying a higher fee.
+const RBFSequence = wire.MaxTxInSequenceNum - 2
+
 // TxProposal is the data needed for a new transaction to be able to display it and sign it.
 type TxProposal struct {
 	// Coin is the coin this tx was made for.
@@ -48,51 +49,23 @@ func (txProposal *TxProposal) Total() btcutil.Amount {
 	return txProposal.Amount + txProposal.Fee
 }
 
-type byValue struct {
-	outPoints []wire.OutPoint
-	outputs   map[wire.OutPoint]*wire.TxOut
-}
-
-func (p *byValue) Len() int { return len(p.outPoints) }
-func (p *byValue) Less(i, j int) bool {
-	if p.outputs[p.outPoints[i]].Value == p.outputs[p.outPoints[j]].Value {
-		// Secondary sort to make coin selection deterministic.
-		return chainhash.HashH(p.outputs[p.outPoints[i]].PkScript).String() < chainhash.HashH(p.outputs[p.outPoints[j]].PkScript).String()
-	}
-	return p.outputs[p.outPoints[i]].Value < p.outputs[p.outPoints[j]].Value
-}
-func (p *byValue) Swap(i, j int) { p.outPoints[i], p.outPoints[j] = p.outPoints[j], p.outPoints[i] }
-
-func coinSelection(
-	minAmount btcutil.Amount,
-	outputs map[wire.OutPoint]*wire.TxOut,
-) (btcutil.Amount, []wire.OutPoint, error) {
-	outPoints := []wire.OutPoint{}
-	for outPoint := range outputs {
-		outPoints = append(outPoints, outPoint)
-	}
-	sort.Sort(sort.Reverse(&byValue{outPoints, outputs}))
-	selectedOutPoints := []wire.OutPoint{}
-	outputsSum := btcutil.Amount(0)
-
-	for _, outPoint := range outPoints {
-		if outputsSum >= minAmount {
-			break
-		}
-		selectedOutPoints = append(selectedOutPoints, outPoint)
-		outputsSum += btcutil.Amount(outputs[outPoint].Value)
-	}
-	if outputsSum < minAmount {
-		return 0, nil, errp.WithStack(errors.ErrInsufficientFunds)
+// pkScriptSizes returns the sizes of the pkScripts of the outputs.
+func pkScriptSizes(outputs []*wire.TxOut) []int {
+	sizes := make([]int, len(outputs))
+	for i, output := range outputs {
+		sizes[i] = len(output.PkScript)
 	}
-	return outputsSum, selectedOutPoints, nil
+	return sizes
 }
 
-// NewTxSpendAll creates a transaction which spends all available unspent outputs.
+// NewTxSpendAll creates a transaction which spends all available unspent outputs. The outputs are
+// paid first, and the remaining value minus the fee is sent to outputPkScript. outputs can be
+// empty.
 func NewTxSpendAll(
 	coin coin.Coin,
 	inputConfiguration *signing.Configuration,
 	spendableOutputs map[wire.OutPoint]*wire.TxOut,
+	outputs []*wire.TxOut,
 	outputPkScript []byte,
 	feePerKb btcutil.Amount,
 	log *logrus.Entry,
@@ -104,18 +77,31 @@ func NewTxSpendAll(
 		outPoint := outPoint // avoid reference reuse due to range loop
 		selectedOutPoints = append(selectedOutPoints, outPoint)
 		outputsSum += btcutil.Amount(output.Value)
-		inputs = append(inputs, wire.NewTxIn(&outPoint, nil, nil))
+		input := wire.NewTxIn(&outPoint, nil, nil)
+		input.Sequence = RBFSequence
+		inputs = append(inputs, input)
 	}
-	txSize := estimateTxSize(len(selectedOutPoints), inputConfiguration, len(outputPkScript), 0)
+	targetAmount := btcutil.Amount(0)
+	for _, output := range outputs {
+		targetAmount += btcutil.Amount(output.Value)
+	}
+	txSize := estimateTxSize(
+		len(selectedOutPoints), inputConfiguration, append(pkScriptSizes(outputs), len(outputPkScript)), 0)
 	maxRequiredFee := feeForSerializeSize(feePerKb, txSize, log)
-	if outputsSum < maxRequiredFee {
+	if outputsSum < targetAmount+maxRequiredFee {
+		return nil, errp.WithStack(errors.ErrInsufficientFunds)
+	}
+	remainder := outputsSum - targetAmount - maxRequiredFee
+	// The cost of spending the output is estimated using the input configuration, as the script
+	// type of the recipient is unknown.
+	if isDustAmount(remainder, len(outputPkScript), inputConfiguration, feePerKb) {
 		return nil, errp.WithStack(errors.ErrInsufficientFunds)
 	}
-	output := wire.NewTxOut(int64(outputsSum-maxRequiredFee), outputPkScript)
+	output := wire.NewTxOut(int64(remainder), outputPkScript)
 	unsignedTransaction := &wire.MsgTx{
 		Version:  wire.TxVersion,
 		TxIn:     inputs,
-		TxOut:    []*wire.TxOut{output},
+		TxOut:    append(append([]*wire.TxOut{}, outputs...), output),
 		LockTime: 0,
 	}
 	txsort.InPlaceSort(unsignedTransaction)
@@ -123,82 +109,92 @@ func NewTxSpendAll(
 	return &TxProposal{
 		Coin:                 coin,
 		AccountConfiguration: inputConfiguration,
-		Amount:               btcutil.Amount(output.Value),
+		Amount:               targetAmount + btcutil.Amount(output.Value),
 		Fee:                  maxRequiredFee,
 		Transaction:          unsignedTransaction,
 	}, nil
 }
 
-// NewTx creates a transaction from a set of unspent outputs, targeting an output value. A subset of
-// the unspent outputs is selected to cover the needed amount. A change output is added if needed.
+// NewTx creates a transaction from a set of unspent outputs, paying the given outputs. A subset of
+// the unspent outputs is selected by coinSelection to cover the needed amount. A change output is
+// added if needed. longTermFeePerKb is the fee rate expected for spending outputs in the future,
+// used by coin selection strategies to weigh spending more or fewer inputs now.
 func NewTx(
 	coin coin.Coin,
 	inputConfiguration *signing.Configuration,
 	spendableOutputs map[wire.OutPoint]*wire.TxOut,
-	output *wire.TxOut,
+	outputs []*wire.TxOut,
 	feePerKb btcutil.Amount,
+	longTermFeePerKb btcutil.Amount,
+	coinSelection CoinSelection,
 	getChangeAddress func() *addresses.AccountAddress,
 	log *logrus.Entry,
 ) (*TxProposal, error) {
-	targetAmount := btcutil.Amount(output.Value)
-	if targetAmount <= 0 {
-		panic("amount must be positive")
+	if len(outputs) == 0 {
+		panic("at least one output is required")
+	}
+	targetAmount := btcutil.Amount(0)
+	for _, output := range outputs {
+		if output.Value <= 0 {
+			panic("amount must be positive")
+		}
+		targetAmount += btcutil.Amount(output.Value)
 	}
-	outputs := []*wire.TxOut{output}
 	changeAddress := getChangeAddress()
 	changePKScript := changeAddress.PubkeyScript()
-	estimatedSize := estimateTxSize(1, inputConfiguration, len(output.PkScript), len(changePKScript))
-	targetFee := feeForSerializeSize(feePerKb, estimatedSize, log)
-	for {
-		selectedOutputsSum, selectedOutPoints, err := coinSelection(
-			targetAmount+targetFee,
-			spendableOutputs,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		txSize := estimateTxSize(len(selectedOutPoints), inputConfiguration, len(output.PkScript), len(changePKScript))
-		maxRequiredFee := feeForSerializeSize(feePerKb, txSize, log)
-		if selectedOutputsSum-targetAmount < maxRequiredFee {
-			targetFee = maxRequiredFee
-			continue
-		}
+	params := &CoinSelectionParams{
+		TargetAmount:        targetAmount,
+		FeePerKb:            feePerKb,
+		LongTermFeePerKb:    longTermFeePerKb,
+		InputConfiguration:  inputConfiguration,
+		OutputPkScriptSizes: pkScriptSizes(outputs),
+		ChangePkScriptSize:  len(changePKScript),
+		Log:                 log,
+	}
+	selectedOutPoints, err := coinSelection(params, spendableOutputs)
+	if err != nil {
+		return nil, err
+	}
+	selectedOutputsSum := sumOutputs(selectedOutPoints, spendableOutputs)
+	maxRequiredFee := params.fee(len(selectedOutPoints))
+	if selectedOutputsSum-targetAmount < maxRequiredFee {
+		return nil, errp.WithStack(errors.ErrInsufficientFunds)
+	}
 
-		inputs := make([]*wire.TxIn, len(selectedOutPoints))
-		for i, outPoint := range selectedOutPoints {
-			outPoint := outPoint // avoids referencing the same variable across loop iterations
-			inputs[i] = wire.NewTxIn(&outPoint, nil, nil)
-		}
-		unsignedTransaction := &wire.MsgTx{
-			Version:  wire.TxVersion,
-			TxIn:     inputs,
-			TxOut:    outputs,
-			LockTime: 0,
-		}
-		changeAmount := selectedOutputsSum - targetAmount - maxRequiredFee
-		changeIsDust := isDustAmount(
-			changeAmount, len(changePKScript), changeAddress.Configuration, feePerKb)
-		finalFee := maxRequiredFee
-		if changeIsDust {
-			log.Info("change is dust")
-			finalFee = selectedOutputsSum - targetAmount
-		}
-		if changeAmount != 0 && !changeIsDust {
-			unsignedTransaction.TxOut = append(unsignedTransaction.TxOut,
-				wire.NewTxOut(int64(changeAmount), changePKScript))
-		} else {
-			changeAddress = nil
-		}
-		txsort.InPlaceSort(unsignedTransaction)
-		log.WithField("fee", finalFee).Debug("Preparing transaction")
-		return &TxProposal{
-			Coin:                 coin,
-			AccountConfiguration: inputConfiguration,
-			Amount:               targetAmount,
-			Fee:                  finalFee,
-			Transaction:          unsignedTransaction,
-			ChangeAddress:        changeAddress,
-		}, nil
+	inputs := make([]*wire.TxIn, len(selectedOutPoints))
+	for i, outPoint := range selectedOutPoints {
+		outPoint := outPoint // avoids referencing the same variable across loop iterations
+		inputs[i] = wire.NewTxIn(&outPoint, nil, nil)
+		inputs[i].Sequence = RBFSequence
 	}
+	unsignedTransaction := &wire.MsgTx{
+		Version:  wire.TxVersion,
+		TxIn:     inputs,
+		TxOut:    append([]*wire.TxOut{}, outputs...),
+		LockTime: 0,
+	}
+	changeAmount := selectedOutputsSum - targetAmount - maxRequiredFee
+	changeIsDust := isDustAmount(
+		changeAmount, len(changePKScript), changeAddress.Configuration, feePerKb)
+	finalFee := maxRequiredFee
+	if changeIsDust {
+		log.Info("change is dust")
+		finalFee = selectedOutputsSum - targetAmount
+	}
+	if changeAmount != 0 && !changeIsDust {
+		unsignedTransaction.TxOut = append(unsignedTransaction.TxOut,
+			wire.NewTxOut(int64(changeAmount), changePKScript))
+	} else {
+		changeAddress = nil
+	}
+	txsort.InPlaceSort(unsignedTransaction)
+	log.WithField("fee", finalFee).Debug("Preparing transaction")
+	return &TxProposal{
+		Coin:                 coin,
+		AccountConfiguration: inputConfiguration,
+		Amount:               targetAmount,
+		Fee:                  finalFee,
+		Transaction:          unsignedTransaction,
+		ChangeAddress:        changeAddress,
+	}, nil
 }
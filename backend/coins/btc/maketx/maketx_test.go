@@ -39,7 +39,7 @@ import (
 
 var noDust = btcutil.Amount(0)
 
-var tbtc = btc.NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, ".", []*rpc.ServerInfo{}, "https://blockstream.info/testnet/tx/", socksproxy.NewSocksProxy(false, ""))
+var tbtc = btc.NewCoin("tbtc", "TBTC", &chaincfg.TestNet3Params, ".", []*rpc.ServerInfo{}, "", nil, 0, "https://blockstream.info/testnet/tx/", socksproxy.NewSocksProxy(false, ""))
 
 // For reference, tx vsizes assuming two outputs (normal + change), for N inputs:
 // 1 inputs: 226
@@ -67,6 +67,7 @@ type newTxSuite struct {
 	changeAddress      *addresses.AccountAddress
 	getChangeAddress   func() *addresses.AccountAddress
 	outputPkScript     []byte
+	coinSelection      maketx.CoinSelection
 
 	log *logrus.Entry
 }
@@ -81,6 +82,7 @@ func (s *newTxSuite) SetupTest() {
 		return s.changeAddress
 	}
 	s.someAddresses = someAddresses[2:]
+	s.coinSelection = maketx.CoinSelectionLargestFirst
 }
 
 func TestNewTxSuite(t *testing.T) {
@@ -99,8 +101,10 @@ func (s *newTxSuite) newTx(
 		tbtc,
 		s.inputConfiguration,
 		utxo,
-		s.output(amount),
+		[]*wire.TxOut{s.output(amount)},
 		feePerKb,
+		feePerKb,
+		s.coinSelection,
 		s.getChangeAddress,
 		s.log,
 	)
@@ -167,7 +171,7 @@ func (s *newTxSuite) check(
 	for _, txIn := range tx.TxIn {
 		require.Nil(s.T(), txIn.SignatureScript)
 		require.Nil(s.T(), txIn.Witness)
-		require.Equal(s.T(), wire.MaxTxInSequenceNum, txIn.Sequence)
+		require.Equal(s.T(), uint32(maketx.RBFSequence), txIn.Sequence)
 	}
 
 	inputSum := int64(0)
@@ -181,7 +185,7 @@ func (s *newTxSuite) check(
 	// if the change output is not there.
 	expectedFee := maketx.TstFeeForSerializeSize(
 		feePerKb,
-		maketx.TstEstimateTxSize(len(tx.TxIn), s.inputConfiguration, len(output.PkScript), len(s.changeAddress.PubkeyScript())),
+		maketx.TstEstimateTxSize(len(tx.TxIn), s.inputConfiguration, []int{len(output.PkScript)}, len(s.changeAddress.PubkeyScript())),
 		s.log) + expectedDustDonation
 	require.Equal(s.T(), expectedFee, txFee)
 	require.Equal(s.T(), expectedFee, txProposal.Fee)
@@ -292,3 +296,145 @@ func (s *newTxSuite) TestNewTxCoinSelection() {
 	// coins: .5, .3, .1, .1, .9, .8, .6. select .5+.3+.1+.1 to get 1BTC, take .9 to cover the fees.
 	s.check(amount, feePerKb, s.buildUTXO(500*mBTC, 300*mBTC, 100*mBTC, 100*mBTC, 90*mBTC, 80*mBTC, 70*mBTC), s.change(90*mBTC-txSizeFiveInputs), noDust, s.selectCoins(0, 1, 2, 3, 4))
 }
+
+func (s *newTxSuite) TestNewTxBranchAndBound() {
+	const mBTC = 100000
+	amount := btcutil.Amount(1000 * mBTC) // 1 BTC
+
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+
+	s.coinSelection = maketx.CoinSelectionBranchAndBound
+	// Two coins match exactly, the large coin would need change.
+	s.check(amount, feePerKb, s.buildUTXO(600*mBTC, 400*mBTC+txSizeTwoInputs, 1100*mBTC), s.change(0), noDust, s.selectCoins(0, 1))
+	// The excess is small enough to be donated to the miners.
+	s.check(amount, feePerKb, s.buildUTXO(600*mBTC, 400*mBTC+txSizeTwoInputs+100, 1100*mBTC), s.change(0), btcutil.Amount(100), s.selectCoins(0, 1))
+	// No changeless match.
+	_, err := s.newTx(amount, feePerKb, s.buildUTXO(2000*mBTC))
+	require.Equal(s.T(), maketx.ErrNoChangelessMatch, errp.Cause(err))
+}
+
+func (s *newTxSuite) TestNewTxKnapsack() {
+	const mBTC = 100000
+	amount := btcutil.Amount(1000 * mBTC) // 1 BTC
+
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+
+	s.coinSelection = maketx.CoinSelectionKnapsack
+	s.check(amount, feePerKb, s.buildUTXO(300*mBTC, 700*mBTC+txSizeTwoInputs, 50*mBTC, 2000*mBTC), s.change(0), noDust, s.selectCoins(0, 1))
+	// The smaller coins are not sufficient, so the lowest larger coin is used.
+	s.check(amount, feePerKb, s.buildUTXO(300*mBTC, 3000*mBTC, 2000*mBTC), s.change(1000*mBTC-txSizeOneInput), noDust, s.selectCoins(2))
+}
+
+func (s *newTxSuite) TestNewTxMinimizeWaste() {
+	const mBTC = 100000
+	amount := btcutil.Amount(1000 * mBTC) // 1 BTC
+
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+
+	s.coinSelection = maketx.CoinSelectionMinimizeWaste
+	// Changeless is preferred over creating change.
+	s.check(amount, feePerKb, s.buildUTXO(600*mBTC, 400*mBTC+txSizeTwoInputs, 1100*mBTC), s.change(0), noDust, s.selectCoins(0, 1))
+	// No changeless solution, fall back to the solution with the least inputs.
+	s.check(amount, feePerKb, s.buildUTXO(2000*mBTC), s.change(1000*mBTC-txSizeOneInput), noDust, s.selectCoins(0))
+}
+
+func (s *newTxSuite) TestNewTxInsufficientFundsAllStrategies() {
+	const mBTC = 100000
+	amount := btcutil.Amount(1000 * mBTC) // 1 BTC
+
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+
+	for _, coinSelection := range []maketx.CoinSelection{
+		maketx.CoinSelectionLargestFirst,
+		maketx.CoinSelectionBranchAndBound,
+		maketx.CoinSelectionKnapsack,
+		maketx.CoinSelectionMinimizeWaste,
+	} {
+		s.coinSelection = coinSelection
+		_, err := s.newTx(amount, feePerKb, s.buildUTXO())
+		require.Equal(s.T(), errors.ErrInsufficientFunds, errp.Cause(err))
+		_, err = s.newTx(amount, feePerKb, s.buildUTXO(mBTC, 999*mBTC+txSizeTwoInputs-1))
+		require.Equal(s.T(), errors.ErrInsufficientFunds, errp.Cause(err))
+		_, err = s.newTx(amount, feePerKb, s.buildUTXO(mBTC, 999*mBTC+txSizeTwoInputs))
+		require.NoError(s.T(), err)
+	}
+}
+
+func (s *newTxSuite) TestNewTxMultipleOutputs() {
+	const mBTC = 100000
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+
+	outputs := []*wire.TxOut{
+		wire.NewTxOut(300*mBTC, s.outputPkScript),
+		wire.NewTxOut(200*mBTC, s.someAddresses[1].PubkeyScript()),
+	}
+	utxo := s.buildUTXO(1000 * mBTC)
+	txProposal, err := maketx.NewTx(
+		tbtc,
+		s.inputConfiguration,
+		utxo,
+		outputs,
+		feePerKb,
+		feePerKb,
+		s.coinSelection,
+		s.getChangeAddress,
+		s.log,
+	)
+	require.NoError(s.T(), err)
+	expectedFee := maketx.TstFeeForSerializeSize(
+		feePerKb,
+		maketx.TstEstimateTxSize(1, s.inputConfiguration,
+			[]int{len(s.outputPkScript), len(s.someAddresses[1].PubkeyScript())},
+			len(s.changeAddress.PubkeyScript())),
+		s.log)
+	require.Equal(s.T(), expectedFee, txProposal.Fee)
+	require.Equal(s.T(), btcutil.Amount(500*mBTC), txProposal.Amount)
+	require.Len(s.T(), txProposal.Transaction.TxOut, 3)
+	for _, output := range outputs {
+		require.Contains(s.T(), txProposal.Transaction.TxOut, output)
+	}
+	require.Contains(s.T(), txProposal.Transaction.TxOut,
+		wire.NewTxOut(int64(500*mBTC-expectedFee), s.changeAddress.PubkeyScript()))
+}
+
+func (s *newTxSuite) TestNewTxSpendAllMultipleOutputs() {
+	const mBTC = 100000
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+
+	outputs := []*wire.TxOut{wire.NewTxOut(300*mBTC, s.someAddresses[1].PubkeyScript())}
+	utxo := s.buildUTXO(600*mBTC, 400*mBTC)
+	txProposal, err := maketx.NewTxSpendAll(tbtc, s.inputConfiguration, utxo, outputs, s.outputPkScript, feePerKb, s.log)
+	require.NoError(s.T(), err)
+	expectedFee := maketx.TstFeeForSerializeSize(
+		feePerKb,
+		maketx.TstEstimateTxSize(2, s.inputConfiguration,
+			[]int{len(s.someAddresses[1].PubkeyScript()), len(s.outputPkScript)}, 0),
+		s.log)
+	require.Equal(s.T(), expectedFee, txProposal.Fee)
+	require.Equal(s.T(), btcutil.Amount(1000*mBTC)-expectedFee, txProposal.Amount)
+	require.Len(s.T(), txProposal.Transaction.TxIn, 2)
+	require.Len(s.T(), txProposal.Transaction.TxOut, 2)
+	require.Contains(s.T(), txProposal.Transaction.TxOut,
+		wire.NewTxOut(int64(700*mBTC-expectedFee), s.outputPkScript))
+
+	// The fixed outputs can't be paid.
+	_, err = maketx.NewTxSpendAll(tbtc, s.inputConfiguration, s.buildUTXO(300*mBTC), outputs, s.outputPkScript, feePerKb, s.log)
+	require.Equal(s.T(), errors.ErrInsufficientFunds, errp.Cause(err))
+}
+
+// TestNewTxSpendAllDust checks that sweeping fails if the remainder sent to the recipient is dust.
+func (s *newTxSuite) TestNewTxSpendAllDust() {
+	feePerKb := btcutil.Amount(1000) // 1 sat / vbyte
+	fee := maketx.TstFeeForSerializeSize(
+		feePerKb,
+		maketx.TstEstimateTxSize(1, s.inputConfiguration, []int{len(s.outputPkScript)}, 0),
+		s.log)
+	_, err := maketx.NewTxSpendAll(
+		tbtc, s.inputConfiguration, s.buildUTXO(int64(fee)+100), nil, s.outputPkScript, feePerKb, s.log)
+	require.Equal(s.T(), errors.ErrInsufficientFunds, errp.Cause(err))
+
+	txProposal, err := maketx.NewTxSpendAll(
+		tbtc, s.inputConfiguration, s.buildUTXO(int64(fee)+10000), nil, s.outputPkScript, feePerKb, s.log)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), btcutil.Amount(10000), txProposal.Amount)
+}
@@ -36,39 +36,33 @@ func outputSize(pkScriptSize int) int {
 // structure.
 // inputCount is the number of inputs in the tx.
 // inputConfiguration defines the structure of every input.
-// outputPkScriptSize is the size of the output pkScript. One output is assumed (apart from change).
+// outputPkScriptSizes are the sizes of the output pkScripts (apart from change).
 // changePkScriptSize  is the size of the change pkScript. A value of 0 means that there is no change output.
 // This function computes the virtual size of a transaction, taking segwit discount into account.
 func estimateTxSize(
 	inputCount int,
 	inputConfiguration *signing.Configuration,
-	outputPkScriptSize int,
+	outputPkScriptSizes []int,
 	changePkScriptSize int) int {
 	const (
-		outputCount  = 2 // 1 output + 1 change output
 		versionSize  = 4
 		lockTimeSize = 4
 		nonWitness   = 4 // factor for non-witness fields
 	)
+	outputCount := len(outputPkScriptSizes) + 1 // outputs + 1 change output
 	sigScriptSize, hasWitness := addresses.SigScriptWitnessSize(inputConfiguration)
 	inputSize := calcInputSize(sigScriptSize)
 
+	outputsSize := outputSize(changePkScriptSize)
+	for _, outputPkScriptSize := range outputPkScriptSizes {
+		outputsSize += outputSize(outputPkScriptSize)
+	}
 	txWeight := nonWitness * (versionSize + lockTimeSize + wire.VarIntSerializeSize(uint64(inputCount)) +
 		wire.VarIntSerializeSize(uint64(outputCount)) +
 		inputCount*inputSize +
-		outputSize(outputPkScriptSize) +
-		outputSize(changePkScriptSize))
+		outputsSize)
 	if hasWitness {
-		// For now, every input has a witness serialization of this format:
-		// <serialized sig> <serialized compressed pubkey>
-		const (
-			signatureSize = 73 // including SIGHASH op
-			pubkeySize    = 33
-		)
-		witnessSize := wire.VarIntSerializeSize(2) +
-			wire.VarIntSerializeSize(signatureSize) + signatureSize +
-			wire.VarIntSerializeSize(pubkeySize) + pubkeySize
-		txWeight += inputCount * witnessSize
+		txWeight += inputCount * addresses.WitnessSize(inputConfiguration)
 		txWeight += 2 // segwit marker + segwit flag
 	}
 	// return txWeight/4 rounded up.
@@ -18,10 +18,10 @@ import "github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 
 func TstEstimateTxSize(inputCount int,
 	inputConfiguration *signing.Configuration,
-	outputPkScriptSize int,
+	outputPkScriptSizes []int,
 	changePkScriptSize int) int {
 	return estimateTxSize(inputCount,
 		inputConfiguration,
-		outputPkScriptSize,
+		outputPkScriptSizes,
 		changePkScriptSize)
 }
@@ -40,7 +40,8 @@ func TestEstimateTxSize(t *testing.T) {
 	scriptTypeP2PKH := signing.ScriptTypeP2PKH
 	scriptTypeP2WPKHP2SH := signing.ScriptTypeP2WPKHP2SH
 	scriptTypeP2WPKH := signing.ScriptTypeP2WPKH
-	scriptTypes := []signing.ScriptType{scriptTypeP2PKH, scriptTypeP2WPKHP2SH, scriptTypeP2WPKH}
+	scriptTypeP2TR := signing.ScriptTypeP2TR
+	scriptTypes := []signing.ScriptType{scriptTypeP2PKH, scriptTypeP2WPKHP2SH, scriptTypeP2WPKH, scriptTypeP2TR}
 
 	test := func(inputScriptType, outputScriptType signing.ScriptType, changeScriptType *signing.ScriptType) {
 		changeStr := "noChange"
@@ -50,7 +51,13 @@ func TestEstimateTxSize(t *testing.T) {
 		t.Run(fmt.Sprintf("%s/%s/%s", inputScriptType, outputScriptType, changeStr),
 			func(t *testing.T) {
 				inputAddress := addressesTest.GetAddress(inputScriptType)
-				sigScript, witness := inputAddress.SignatureScript([]*btcec.Signature{sig})
+				var sigScript []byte
+				var witness wire.TxWitness
+				if inputAddress.IsTaproot() {
+					witness = inputAddress.TaprootWitness(make([]byte, 64))
+				} else {
+					sigScript, witness = inputAddress.SignatureScript([]*btcec.Signature{sig})
+				}
 				outputPkScript := addressesTest.GetAddress(outputScriptType).PubkeyScript()
 				tx := &wire.MsgTx{
 					Version: wire.TxVersion,
@@ -89,7 +96,7 @@ func TestEstimateTxSize(t *testing.T) {
 				estimatedSize := estimateTxSize(
 					len(tx.TxIn),
 					inputAddress.Configuration,
-					len(outputPkScript), changePkScriptSize)
+					[]int{len(outputPkScript)}, changePkScriptSize)
 				require.Equal(t, mempool.GetTxVirtualSize(btcutil.NewTx(tx)), int64(estimatedSize))
 			})
 	}
@@ -0,0 +1,106 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/message"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/ltc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ProposedMessage contains all the info needed from a keystore to sign a message with the key of
+// an address. The keystore signs SignatureHash with the key of the address and sets Signature.
+type ProposedMessage struct {
+	Address       *addresses.AccountAddress
+	Message       []byte
+	SignatureHash []byte
+	Signature     *btcec.Signature
+}
+
+// messageMagic returns the prefix of the legacy message hash of the given net.
+func messageMagic(net *chaincfg.Params) string {
+	if net == &ltc.MainNetParams || net == &ltc.TestNet4Params {
+		return "Litecoin Signed Message:\n"
+	}
+	return "Bitcoin Signed Message:\n"
+}
+
+// SignMessage signs the message with the key of the given receive address and returns the base64
+// encoded signature. P2PKH addresses are signed in the legacy format (BIP137), segwit addresses
+// with BIP322 unless legacy is true. Returns keystore.ErrSigningAborted if the user aborts, and
+// keystore.ErrNoKeystore if the account is watch-only.
+func (account *Account) SignMessage(addressID string, msg string, legacy bool) (string, error) {
+	if account.signingConfiguration == nil {
+		return "", errp.New("account must be initialized")
+	}
+	if account.signingConfiguration.Multisig() || account.signingConfiguration.IsAddressBased() {
+		return "", errp.New("messages can only be signed with singlesig accounts")
+	}
+	if account.signingConfiguration.ScriptType() == signing.ScriptTypeP2TR {
+		return "", errp.New("messages cannot be signed with taproot accounts yet")
+	}
+	account.synchronizer.WaitSynchronized()
+	unlock := account.RLock()
+	address := account.receiveAddresses.LookupByScriptHashHex(blockchain.ScriptHashHex(addressID))
+	unlock()
+	if address == nil {
+		return "", errp.New("unknown address not found")
+	}
+	account.log.Info("Signing a message")
+
+	scriptType := address.Configuration.ScriptType()
+	legacy = legacy || scriptType == signing.ScriptTypeP2PKH
+	proposedMessage := &ProposedMessage{
+		Address: address,
+		Message: []byte(msg),
+	}
+	if legacy {
+		proposedMessage.SignatureHash = message.Hash(messageMagic(account.coin.Net()), proposedMessage.Message)
+	} else {
+		_, subScript := address.ScriptForHashToSign()
+		hash, err := message.BIP322SignatureHash(address.PubkeyScript(), subScript, proposedMessage.Message)
+		if err != nil {
+			return "", err
+		}
+		proposedMessage.SignatureHash = hash
+	}
+	if err := account.keystores.SignMessage(proposedMessage); err != nil {
+		return "", err
+	}
+	if proposedMessage.Signature == nil {
+		return "", errp.New("the message was not signed")
+	}
+	if legacy {
+		return message.EncodeBIP137(proposedMessage.Signature, address.Configuration.PublicKeys()[0],
+			proposedMessage.SignatureHash, scriptType)
+	}
+	signatureScript, witness := address.SignatureScript([]*btcec.Signature{proposedMessage.Signature})
+	return message.EncodeBIP322(address.PubkeyScript(), proposedMessage.Message, signatureScript, witness)
+}
+
+// VerifyMessage verifies a signature of the message by the given address in the legacy format
+// (BIP137) or BIP322. Returns message.ErrInvalidSignature if the signature is not valid.
+func (coin *Coin) VerifyMessage(address string, msg string, signature string) error {
+	decodedAddress, err := coin.DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	return message.Verify(decodedAddress, coin.Net(), messageMagic(coin.Net()), []byte(msg), signature)
+}
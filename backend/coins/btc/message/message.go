@@ -0,0 +1,310 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package message implements signed messages proving the ownership of an address, in the legacy
+// format (BIP137) and the generic format (BIP322).
+package message
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// bip322Tag is the tag of the hash of the message, see BIP340 for tagged hashes.
+const bip322Tag = "BIP0322-signed-message"
+
+// Header bytes of BIP137 signatures, to which the recovery ID is added.
+const (
+	headerP2PKHUncompressed = 27
+	headerP2PKH             = 31
+	headerP2WPKHP2SH        = 35
+	headerP2WPKH            = 39
+	headerMax               = headerP2WPKH + 3
+)
+
+// ErrInvalidSignature is returned if a signature does not match the message and address.
+var ErrInvalidSignature = errp.New("invalid signature")
+
+// Hash returns the hash signed in the legacy format (BIP137). magic is the coin specific prefix,
+// e.g. "Bitcoin Signed Message:\n".
+func Hash(magic string, message []byte) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, magic)
+	_ = wire.WriteVarBytes(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// EncodeBIP137 encodes the signature of Hash() in the legacy format, which contains the recovery
+// ID from which the public key can be recovered. The header byte depends on the script type.
+func EncodeBIP137(
+	signature *btcec.Signature,
+	publicKey *btcec.PublicKey,
+	hash []byte,
+	scriptType signing.ScriptType,
+) (string, error) {
+	headers := map[signing.ScriptType]byte{
+		signing.ScriptTypeP2PKH:      headerP2PKH,
+		signing.ScriptTypeP2WPKHP2SH: headerP2WPKHP2SH,
+		signing.ScriptTypeP2WPKH:     headerP2WPKH,
+	}
+	header, ok := headers[scriptType]
+	if !ok {
+		return "", errp.Newf("unsupported script type %s", scriptType)
+	}
+	compact := make([]byte, 65)
+	rBytes := signature.R.Bytes()
+	sBytes := signature.S.Bytes()
+	copy(compact[33-len(rBytes):33], rBytes)
+	copy(compact[65-len(sBytes):], sBytes)
+	for recoveryID := byte(0); recoveryID < 4; recoveryID++ {
+		// RecoverCompact() expects the header of compressed P2PKH keys.
+		compact[0] = headerP2PKH + recoveryID
+		recovered, _, err := btcec.RecoverCompact(btcec.S256(), compact, hash)
+		if err == nil && recovered.IsEqual(publicKey) {
+			compact[0] = header + recoveryID
+			return base64.StdEncoding.EncodeToString(compact), nil
+		}
+	}
+	return "", errp.New("the signature does not match the public key")
+}
+
+func taggedHash(tag string, message []byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hash := sha256.New()
+	_, _ = hash.Write(tagHash[:])
+	_, _ = hash.Write(tagHash[:])
+	_, _ = hash.Write(message)
+	return hash.Sum(nil)
+}
+
+// bip322ToSpend returns the virtual tx which creates the output that is spent to sign the message.
+func bip322ToSpend(pkScript []byte, message []byte) *wire.MsgTx {
+	scriptSig, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).
+		AddData(taggedHash(bip322Tag, message)).
+		Script()
+	if err != nil {
+		panic(err)
+	}
+	return &wire.MsgTx{
+		Version: 0,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+			SignatureScript:  scriptSig,
+			Sequence:         0,
+		}},
+		TxOut:    []*wire.TxOut{wire.NewTxOut(0, pkScript)},
+		LockTime: 0,
+	}
+}
+
+// bip322ToSign returns the unsigned virtual tx spending the output of toSpend.
+func bip322ToSign(toSpend *wire.MsgTx) *wire.MsgTx {
+	return &wire.MsgTx{
+		Version: 0,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash(), Index: 0},
+			Sequence:         0,
+		}},
+		TxOut:    []*wire.TxOut{wire.NewTxOut(0, []byte{txscript.OP_RETURN})},
+		LockTime: 0,
+	}
+}
+
+// BIP322SignatureHash returns the hash to be signed for a BIP322 signature of a segwit v0 output
+// with the given pkScript. subScript is the script used when calculating the hash, see
+// addresses.AccountAddress.ScriptForHashToSign().
+func BIP322SignatureHash(pkScript []byte, subScript []byte, message []byte) ([]byte, error) {
+	toSign := bip322ToSign(bip322ToSpend(pkScript, message))
+	hash, err := txscript.CalcWitnessSigHash(
+		subScript, txscript.NewTxSigHashes(toSign), txscript.SigHashAll, toSign, 0, 0)
+	return hash, errp.WithStack(err)
+}
+
+// EncodeBIP322 encodes the signature script and witness spending the output of the virtual tx. If
+// the signature script is empty, the simple format (only the witness) is used, otherwise the full
+// format (the whole virtual tx).
+func EncodeBIP322(
+	pkScript []byte,
+	message []byte,
+	signatureScript []byte,
+	witness wire.TxWitness,
+) (string, error) {
+	var buf bytes.Buffer
+	if len(signatureScript) == 0 {
+		if err := wire.WriteVarInt(&buf, 0, uint64(len(witness))); err != nil {
+			return "", errp.WithStack(err)
+		}
+		for _, item := range witness {
+			if err := wire.WriteVarBytes(&buf, 0, item); err != nil {
+				return "", errp.WithStack(err)
+			}
+		}
+	} else {
+		toSign := bip322ToSign(bip322ToSpend(pkScript, message))
+		toSign.TxIn[0].SignatureScript = signatureScript
+		toSign.TxIn[0].Witness = witness
+		if err := toSign.Serialize(&buf); err != nil {
+			return "", errp.WithStack(err)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// verifyBIP137 verifies a legacy signature. The script type of the header is ignored, as some
+// wallets use the P2PKH header for all address types.
+func verifyBIP137(address btcutil.Address, net *chaincfg.Params, hash []byte, signature []byte) error {
+	header := signature[0]
+	compact := append([]byte{}, signature...)
+	if header >= headerP2PKH {
+		compact[0] = headerP2PKH + (header-headerP2PKH)%4
+	}
+	publicKey, compressed, err := btcec.RecoverCompact(btcec.S256(), compact, hash)
+	if err != nil {
+		return errp.WithStack(ErrInvalidSignature)
+	}
+	var serializedPublicKey []byte
+	if compressed {
+		serializedPublicKey = publicKey.SerializeCompressed()
+	} else {
+		serializedPublicKey = publicKey.SerializeUncompressed()
+	}
+	publicKeyHash := btcutil.Hash160(serializedPublicKey)
+	var recoveredAddress btcutil.Address
+	switch address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		recoveredAddress, err = btcutil.NewAddressPubKeyHash(publicKeyHash, net)
+	case *btcutil.AddressWitnessPubKeyHash:
+		recoveredAddress, err = btcutil.NewAddressWitnessPubKeyHash(publicKeyHash, net)
+	case *btcutil.AddressScriptHash:
+		var segwitAddress *btcutil.AddressWitnessPubKeyHash
+		segwitAddress, err = btcutil.NewAddressWitnessPubKeyHash(publicKeyHash, net)
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		var redeemScript []byte
+		redeemScript, err = txscript.PayToAddrScript(segwitAddress)
+		if err != nil {
+			return errp.WithStack(err)
+		}
+		recoveredAddress, err = btcutil.NewAddressScriptHash(redeemScript, net)
+	default:
+		return errp.New("legacy signatures are not supported for this address type")
+	}
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if recoveredAddress.EncodeAddress() != address.EncodeAddress() {
+		return errp.WithStack(ErrInvalidSignature)
+	}
+	return nil
+}
+
+// parseBIP322Full parses a signature in the full format. false is returned if it is not a valid
+// virtual tx spending toSpend.
+func parseBIP322Full(signature []byte, toSpend *wire.MsgTx) (*wire.MsgTx, bool) {
+	toSign := &wire.MsgTx{}
+	reader := bytes.NewReader(signature)
+	if err := toSign.Deserialize(reader); err != nil || reader.Len() != 0 {
+		return nil, false
+	}
+	expected := bip322ToSign(toSpend)
+	if toSign.Version != expected.Version || toSign.LockTime != expected.LockTime ||
+		len(toSign.TxIn) != 1 || len(toSign.TxOut) != 1 ||
+		toSign.TxIn[0].PreviousOutPoint != expected.TxIn[0].PreviousOutPoint ||
+		toSign.TxIn[0].Sequence != expected.TxIn[0].Sequence ||
+		toSign.TxOut[0].Value != 0 ||
+		!bytes.Equal(toSign.TxOut[0].PkScript, expected.TxOut[0].PkScript) {
+		return nil, false
+	}
+	return toSign, true
+}
+
+// parseBIP322Simple parses a signature in the simple format, the serialized witness.
+func parseBIP322Simple(signature []byte) (wire.TxWitness, error) {
+	reader := bytes.NewReader(signature)
+	count, err := wire.ReadVarInt(reader, 0)
+	if err != nil || count > uint64(len(signature)) {
+		return nil, errp.WithStack(ErrInvalidSignature)
+	}
+	witness := make(wire.TxWitness, count)
+	for i := range witness {
+		witness[i], err = wire.ReadVarBytes(reader, 0, uint32(len(signature)), "witness")
+		if err != nil {
+			return nil, errp.WithStack(ErrInvalidSignature)
+		}
+	}
+	if reader.Len() != 0 {
+		return nil, errp.WithStack(ErrInvalidSignature)
+	}
+	return witness, nil
+}
+
+// verifyBIP322 verifies a signature in the simple or full format by executing the scripts of the
+// virtual tx.
+func verifyBIP322(address btcutil.Address, message []byte, signature []byte) error {
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	toSpend := bip322ToSpend(pkScript, message)
+	toSign, ok := parseBIP322Full(signature, toSpend)
+	if !ok {
+		witness, err := parseBIP322Simple(signature)
+		if err != nil {
+			return err
+		}
+		toSign = bip322ToSign(toSpend)
+		toSign.TxIn[0].Witness = witness
+	}
+	engine, err := txscript.NewEngine(pkScript, toSign, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(toSign), 0)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if err := engine.Execute(); err != nil {
+		return errp.WithStack(ErrInvalidSignature)
+	}
+	return nil
+}
+
+// Verify verifies the base64 encoded signature of the message, which can be in the legacy format
+// (BIP137) or in the simple or full format of BIP322. ErrInvalidSignature is returned if the
+// signature is not valid for the address.
+func Verify(
+	address btcutil.Address,
+	net *chaincfg.Params,
+	magic string,
+	message []byte,
+	signature string,
+) error {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errp.WithStack(ErrInvalidSignature)
+	}
+	if len(decoded) == 65 && decoded[0] >= headerP2PKHUncompressed && decoded[0] <= headerMax {
+		return verifyBIP137(address, net, Hash(magic, message), decoded)
+	}
+	return verifyBIP322(address, message, decoded)
+}
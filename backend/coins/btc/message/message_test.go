@@ -0,0 +1,133 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/message"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+const magic = "Bitcoin Signed Message:\n"
+
+// Test vectors from BIP322.
+const (
+	bip322WIF     = "L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k"
+	bip322Address = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+)
+
+func privateKey(t *testing.T) *btcec.PrivateKey {
+	t.Helper()
+	wif, err := btcutil.DecodeWIF(bip322WIF)
+	require.NoError(t, err)
+	return wif.PrivKey
+}
+
+func decodeAddress(t *testing.T, address string) btcutil.Address {
+	t.Helper()
+	decoded, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestVerifyBIP322Vectors(t *testing.T) {
+	address := decodeAddress(t, bip322Address)
+	vectors := map[string]string{
+		"":            "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+		"Hello World": "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+	}
+	for msg, signature := range vectors {
+		require.NoError(t, message.Verify(address, &chaincfg.MainNetParams, magic, []byte(msg), signature))
+	}
+	err := message.Verify(address, &chaincfg.MainNetParams, magic, []byte("Hello World"), vectors[""])
+	require.Equal(t, message.ErrInvalidSignature, errp.Cause(err))
+}
+
+func TestBIP322SignVerify(t *testing.T) {
+	privateKey := privateKey(t)
+	publicKey := privateKey.PubKey().SerializeCompressed()
+	msg := []byte("Hello World")
+
+	// P2WPKH, simple format.
+	address := decodeAddress(t, bip322Address)
+	pkScript, err := txscript.PayToAddrScript(address)
+	require.NoError(t, err)
+	hash, err := message.BIP322SignatureHash(pkScript, pkScript, msg)
+	require.NoError(t, err)
+	signature, err := privateKey.Sign(hash)
+	require.NoError(t, err)
+	witness := wire.TxWitness{append(signature.Serialize(), byte(txscript.SigHashAll)), publicKey}
+	encoded, err := message.EncodeBIP322(pkScript, msg, nil, witness)
+	require.NoError(t, err)
+	require.NoError(t, message.Verify(address, &chaincfg.MainNetParams, magic, msg, encoded))
+
+	// P2SH-P2WPKH, full format.
+	segwitAddress, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(publicKey), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	redeemScript, err := txscript.PayToAddrScript(segwitAddress)
+	require.NoError(t, err)
+	p2shAddress, err := btcutil.NewAddressScriptHash(redeemScript, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	pkScript, err = txscript.PayToAddrScript(p2shAddress)
+	require.NoError(t, err)
+	hash, err = message.BIP322SignatureHash(pkScript, redeemScript, msg)
+	require.NoError(t, err)
+	signature, err = privateKey.Sign(hash)
+	require.NoError(t, err)
+	signatureScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+	require.NoError(t, err)
+	witness = wire.TxWitness{append(signature.Serialize(), byte(txscript.SigHashAll)), publicKey}
+	encoded, err = message.EncodeBIP322(pkScript, msg, signatureScript, witness)
+	require.NoError(t, err)
+	require.NoError(t, message.Verify(p2shAddress, &chaincfg.MainNetParams, magic, msg, encoded))
+	require.Error(t, message.Verify(p2shAddress, &chaincfg.MainNetParams, magic, []byte("other"), encoded))
+}
+
+func TestBIP137SignVerify(t *testing.T) {
+	privateKey := privateKey(t)
+	msg := []byte("Hello World")
+	hash := message.Hash(magic, msg)
+	signature, err := privateKey.Sign(hash)
+	require.NoError(t, err)
+
+	publicKeyHash := btcutil.Hash160(privateKey.PubKey().SerializeCompressed())
+	p2pkhAddress, err := btcutil.NewAddressPubKeyHash(publicKeyHash, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	addresses := map[signing.ScriptType]btcutil.Address{
+		signing.ScriptTypeP2PKH:  p2pkhAddress,
+		signing.ScriptTypeP2WPKH: decodeAddress(t, bip322Address),
+	}
+	for scriptType, address := range addresses {
+		encoded, err := message.EncodeBIP137(signature, privateKey.PubKey(), hash, scriptType)
+		require.NoError(t, err)
+		require.NoError(t, message.Verify(address, &chaincfg.MainNetParams, magic, msg, encoded))
+		require.Error(t, message.Verify(address, &chaincfg.MainNetParams, magic, []byte("other"), encoded))
+	}
+	// The header of the legacy signature does not need to match the address type.
+	encoded, err := message.EncodeBIP137(signature, privateKey.PubKey(), hash, signing.ScriptTypeP2PKH)
+	require.NoError(t, err)
+	require.NoError(t, message.Verify(
+		addresses[signing.ScriptTypeP2WPKH], &chaincfg.MainNetParams, magic, msg, encoded))
+
+	require.Error(t, message.Verify(p2pkhAddress, &chaincfg.MainNetParams, magic, msg, "invalid"))
+}
@@ -0,0 +1,631 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2p
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// reconnectDelay is the time to wait before reconnecting after the connection was lost.
+	reconnectDelay = 10 * time.Second
+	// requestTimeout is the time to wait for the response to a request.
+	requestTimeout = time.Minute
+	// maxHeadersPerBatch is returned as the maximum batch size by Headers().
+	maxHeadersPerBatch = 2016
+	// minRelayFee is the default minimum relay fee of Bitcoin Core. The P2P protocol does not
+	// provide fee estimates.
+	minRelayFee = btcutil.Amount(1000)
+	// birthdayWindow is subtracted from the wallet birthday to find the first block to scan, as
+	// block timestamps are not strictly increasing. Same as TIMESTAMP_WINDOW in Bitcoin Core.
+	birthdayWindow = 2 * time.Hour
+	// resumeMargin is the number of blocks below the persisted scan height from which the scan
+	// resumes after a restart, in case these blocks were reorganized in the meantime.
+	resumeMargin = 6
+	// scanStateInterval is the number of scanned blocks after which the scan state of a script is
+	// persisted, unless its history changed.
+	scanStateInterval = 144
+)
+
+// merkleRequest is a call of GetMerkle waiting for the block to be downloaded.
+type merkleRequest struct {
+	txHash  chainhash.Hash
+	height  int
+	success func(merkle []blockchain.TXHash, pos int) error
+	cleanup func(error)
+}
+
+// scanStateCallback persists the scan state of a script, see blockchain.ScriptRegistry.
+type scanStateCallback struct {
+	onScanned func(*blockchain.ScanState)
+	// height and status are the scanned height and the history status last persisted.
+	height int
+	status string
+}
+
+// addTx adds the tx to the history, or updates its height if it is already in the history.
+// Confirmed txs are ordered by height, unconfirmed txs come last.
+func addTx(s *blockchain.Script, txHash chainhash.Hash, height int) {
+	found := false
+	for _, entry := range s.History {
+		if entry.TXHash.Hash() == txHash {
+			entry.Height = height
+			found = true
+		}
+	}
+	if !found {
+		s.History = append(s.History, &blockchain.TxInfo{Height: height, TXHash: blockchain.TXHash(txHash)})
+	}
+	sort.SliceStable(s.History, func(i, j int) bool {
+		if s.History[j].Height <= 0 {
+			return s.History[i].Height > 0
+		}
+		return s.History[i].Height > 0 && s.History[i].Height < s.History[j].Height
+	})
+}
+
+// Client implements blockchain.Interface by connecting to a node speaking the Bitcoin P2P
+// protocol. Instead of revealing the addresses of the wallet to a server, the compact block
+// filters (BIP157/BIP158) are downloaded and matched locally against the scripts of the wallet, and
+// only the matching blocks are downloaded. The scripts must be registered with RegisterScript()
+// before subscribing to them.
+//
+// Incoming unconfirmed transactions are never seen, as filters only exist for blocks. Unconfirmed
+// transactions are only known if they were broadcast by us, so incoming payments only show up once
+// they are confirmed.
+//
+// The headers and filter headers are persisted in the DB. The histories of the scripts are
+// persisted by the accounts, see blockchain.ScanState, so after a restart, only the filters of the
+// blocks mined since the last persisted scan are downloaded and matched.
+type Client struct {
+	net  *chaincfg.Params
+	dial func() (net.Conn, error)
+	// db is nil if the chains are only kept in memory.
+	db *DB
+	// birthday is the creation time of the wallet. Blocks mined well before it are not scanned.
+	// The zero value means the whole chain is scanned.
+	birthday time.Time
+	log      *logrus.Entry
+
+	lock locker.Locker
+	// peer is nil while disconnected.
+	peer   *peer
+	status blockchain.Status
+
+	// headers is the best chain known, indexed by height.
+	headers       []*wire.BlockHeader
+	heightByHash  map[chainhash.Hash]int
+	headersSynced bool
+	// notifiedTip is the tip height last sent to the headers subscribers.
+	notifiedTip int
+	// filterHeaders is the verified filter header chain, indexed by height.
+	filterHeaders []chainhash.Hash
+
+	scripts *blockchain.Scripts
+	// scannedHeights are the heights up to which the filters were matched against the scripts, -1
+	// if none were.
+	scannedHeights map[blockchain.ScriptHashHex]int
+	scanStates     map[blockchain.ScriptHashHex]*scanStateCallback
+	// outPoints maps the outputs of the wallet to the script hash they pay to, so the transactions
+	// spending them are found.
+	outPoints map[wire.OutPoint]blockchain.ScriptHashHex
+	txs       map[chainhash.Hash]*wire.MsgTx
+	// blockTxs are the tx hashes of the downloaded blocks by height, used for merkle proofs.
+	blockTxs map[int][]chainhash.Hash
+	// merkleRequests wait for their blocks to be downloaded, e.g. for transactions found before a
+	// restart.
+	merkleRequests []*merkleRequest
+
+	headersSubscriptions      []func(*blockchain.Header) error
+	connectionStatusCallbacks []func(blockchain.Status)
+
+	// syncChan triggers a sync, e.g. when new scripts are registered.
+	syncChan chan struct{}
+	quitChan chan struct{}
+}
+
+// NewClient creates a new client. The connection is established with dial and re-established
+// when lost. The chains are loaded from and stored in db, unless it is nil. Blocks mined well
+// before the wallet birthday are not scanned, unless it is the zero time.
+func NewClient(
+	net *chaincfg.Params,
+	dial func() (net.Conn, error),
+	db *DB,
+	birthday time.Time,
+	log *logrus.Entry,
+) (*Client, error) {
+	genesis := net.GenesisBlock.Header
+	headers := []*wire.BlockHeader{&genesis}
+	var filterHeaders []chainhash.Hash
+	if db != nil {
+		storedHeaders, storedFilterHeaders, err := db.load(net)
+		if err != nil {
+			return nil, err
+		}
+		if len(storedHeaders) == 0 {
+			if err := db.putHeader(0, &genesis); err != nil {
+				return nil, err
+			}
+		} else {
+			headers = storedHeaders
+			filterHeaders = storedFilterHeaders
+		}
+		log.Infof("Loaded %d headers and %d filter headers", len(headers), len(filterHeaders))
+	}
+	heightByHash := make(map[chainhash.Hash]int, len(headers))
+	for height, header := range headers {
+		heightByHash[header.BlockHash()] = height
+	}
+	client := &Client{
+		net:      net,
+		dial:     dial,
+		db:       db,
+		birthday: birthday,
+		log:      log,
+
+		status:        blockchain.DISCONNECTED,
+		headers:       headers,
+		heightByHash:  heightByHash,
+		filterHeaders: filterHeaders,
+
+		scripts:        blockchain.NewScripts(log),
+		scannedHeights: map[blockchain.ScriptHashHex]int{},
+		scanStates:     map[blockchain.ScriptHashHex]*scanStateCallback{},
+		outPoints:      map[wire.OutPoint]blockchain.ScriptHashHex{},
+		txs:            map[chainhash.Hash]*wire.MsgTx{},
+		blockTxs:       map[int][]chainhash.Hash{},
+
+		syncChan: make(chan struct{}, 1),
+		quitChan: make(chan struct{}),
+	}
+	go client.run()
+	return client, nil
+}
+
+func (client *Client) kickSync() {
+	select {
+	case client.syncChan <- struct{}{}:
+	default:
+	}
+}
+
+// RegisterScript implements blockchain.ScriptRegistry. The scan resumes from the given scan state,
+// a few blocks below its scanned height in case of a reorg.
+func (client *Client) RegisterScript(
+	pkScript []byte, state *blockchain.ScanState, onScanned func(*blockchain.ScanState)) {
+	defer client.lock.Lock()()
+	if !client.scripts.Register(pkScript) {
+		return
+	}
+	scriptHash := blockchain.NewScriptHashHex(pkScript)
+	client.scannedHeights[scriptHash] = -1
+	if onScanned != nil {
+		client.scanStates[scriptHash] = &scanStateCallback{onScanned: onScanned, height: -1}
+	}
+	if state != nil {
+		client.resume(scriptHash, state)
+	}
+	client.kickSync()
+}
+
+// resume restores the persisted scan state of the script. Requires the lock.
+func (client *Client) resume(scriptHash blockchain.ScriptHashHex, state *blockchain.ScanState) {
+	scannedHeight := state.ScannedHeight - resumeMargin
+	if scannedHeight < -1 {
+		scannedHeight = -1
+	}
+	txs := map[chainhash.Hash]*wire.MsgTx{}
+	for _, tx := range state.Txs {
+		txs[tx.TxHash()] = tx
+	}
+	// The history is ordered by height, so the outputs are known before the txs spending them.
+	// Unconfirmed txs are kept, the txs in the blocks scanned again are found again.
+	for _, entry := range state.History {
+		tx, ok := txs[entry.TXHash.Hash()]
+		if !ok || (entry.Height > 0 && entry.Height > scannedHeight) {
+			continue
+		}
+		client.addTx(tx, entry.Height)
+	}
+	client.scannedHeights[scriptHash] = scannedHeight
+	if callback, ok := client.scanStates[scriptHash]; ok {
+		callback.height = scannedHeight
+		callback.status = client.scripts.Get(scriptHash).History.Status()
+	}
+}
+
+// changedScanStates returns the callbacks persisting the scan states of the scripts which advanced
+// by scanStateInterval blocks or whose history changed since they were last persisted. Requires
+// the lock.
+func (client *Client) changedScanStates() []func() {
+	callbacks := []func(){}
+	for scriptHash, callback := range client.scanStates {
+		s := client.scripts.Get(scriptHash)
+		scannedHeight := client.scannedHeights[scriptHash]
+		status := s.History.Status()
+		if scannedHeight < callback.height+scanStateInterval && status == callback.status {
+			continue
+		}
+		callback.height = scannedHeight
+		callback.status = status
+		state := &blockchain.ScanState{
+			ScannedHeight: scannedHeight,
+			History:       blockchain.TxHistory{},
+			Txs:           []*wire.MsgTx{},
+		}
+		for _, entry := range s.History {
+			entryCopy := *entry
+			state.History = append(state.History, &entryCopy)
+			if tx, ok := client.txs[entry.TXHash.Hash()]; ok {
+				state.Txs = append(state.Txs, tx)
+			}
+		}
+		onScanned := callback.onScanned
+		callbacks = append(callbacks, func() { onScanned(state) })
+	}
+	return callbacks
+}
+
+// ScriptHashGetHistory implements blockchain.Interface.
+func (client *Client) ScriptHashGetHistory(
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(blockchain.TxHistory) error,
+	cleanup func(error),
+) {
+	defer client.lock.RLock()()
+	client.scripts.GetHistory(scriptHashHex, success, cleanup)
+}
+
+// TransactionGet implements blockchain.Interface.
+func (client *Client) TransactionGet(
+	txHash chainhash.Hash,
+	success func(*wire.MsgTx) error,
+	cleanup func(error),
+) {
+	defer client.lock.RLock()()
+	tx, ok := client.txs[txHash]
+	if !ok {
+		go cleanup(errp.Newf("unknown transaction %s", txHash))
+		return
+	}
+	go func() {
+		cleanup(success(tx))
+	}()
+}
+
+// ScriptHashSubscribe implements blockchain.Interface. The first status is sent once the filters
+// were matched against the script up to the tip.
+func (client *Client) ScriptHashSubscribe(
+	setupAndTeardown func() func(error),
+	scriptHashHex blockchain.ScriptHashHex,
+	success func(string) error,
+) {
+	done := setupAndTeardown()
+	defer client.lock.Lock()()
+	client.scripts.Subscribe(done, scriptHashHex, success)
+	client.notifyScripts()
+}
+
+// notifyScripts sends the status of the scripts which are scanned up to the tip to the
+// subscribers, if it changed. Requires the lock.
+func (client *Client) notifyScripts() {
+	if !client.headersSynced {
+		return
+	}
+	client.scripts.Notify(func(scriptHashHex blockchain.ScriptHashHex) bool {
+		return client.scannedHeights[scriptHashHex] >= client.tip()
+	})
+}
+
+// HeadersSubscribe implements blockchain.Interface.
+func (client *Client) HeadersSubscribe(
+	setupAndTeardown func() func(error),
+	success func(*blockchain.Header) error,
+) {
+	done := func(error) {}
+	if setupAndTeardown != nil {
+		done = setupAndTeardown()
+	}
+	defer client.lock.Lock()()
+	client.headersSubscriptions = append(client.headersSubscriptions, success)
+	if client.headersSynced {
+		header := &blockchain.Header{BlockHeight: client.tip()}
+		go func() {
+			done(success(header))
+		}()
+		return
+	}
+	go done(nil)
+}
+
+// TransactionBroadcast implements blockchain.Interface. The transaction is added to the history of
+// the scripts it touches as unconfirmed.
+func (client *Client) TransactionBroadcast(tx *wire.MsgTx) error {
+	defer client.lock.Lock()()
+	if client.peer == nil {
+		return errp.New("not connected")
+	}
+	if err := client.peer.writeMessage(tx); err != nil {
+		return err
+	}
+	client.addTx(tx, 0)
+	client.notifyScripts()
+	return nil
+}
+
+// RelayFee implements blockchain.Interface.
+func (client *Client) RelayFee(success func(btcutil.Amount), cleanup func(error)) {
+	go func() {
+		success(minRelayFee)
+		cleanup(nil)
+	}()
+}
+
+// EstimateFee implements blockchain.Interface. Fee estimation is not available, so nil is
+// returned, and the minimum relay fee is used instead.
+func (client *Client) EstimateFee(
+	number int,
+	success func(*btcutil.Amount) error,
+	cleanup func(error),
+) {
+	go func() {
+		cleanup(success(nil))
+	}()
+}
+
+// Headers implements blockchain.Interface.
+func (client *Client) Headers(
+	startHeight int,
+	count int,
+	success func([]*wire.BlockHeader, int) error,
+	cleanup func(error),
+) {
+	defer client.lock.RLock()()
+	blockHeaders := []*wire.BlockHeader{}
+	for height := startHeight; height < startHeight+count && height <= client.tip(); height++ {
+		header := *client.headers[height]
+		blockHeaders = append(blockHeaders, &header)
+	}
+	go func() {
+		cleanup(success(blockHeaders, maxHeadersPerBatch))
+	}()
+}
+
+// GetMerkle implements blockchain.Interface.
+func (client *Client) GetMerkle(
+	txHash chainhash.Hash,
+	height int,
+	success func(merkle []blockchain.TXHash, pos int) error,
+	cleanup func(error),
+) {
+	defer client.lock.Lock()()
+	request := &merkleRequest{txHash: txHash, height: height, success: success, cleanup: cleanup}
+	if _, ok := client.blockTxs[height]; ok {
+		client.answerMerkle(request)
+		return
+	}
+	// The block was not downloaded since the start, so it is downloaded in the next sync.
+	client.merkleRequests = append(client.merkleRequests, request)
+	client.kickSync()
+}
+
+// answerMerkle responds to the request using the downloaded block. Requires the lock.
+func (client *Client) answerMerkle(request *merkleRequest) {
+	for pos, blockTxHash := range client.blockTxs[request.height] {
+		if blockTxHash == request.txHash {
+			merkle := merkleBranch(client.blockTxs[request.height], pos)
+			go func(pos int) {
+				request.cleanup(request.success(merkle, pos))
+			}(pos)
+			return
+		}
+	}
+	go request.cleanup(errp.Newf("transaction %s not found in block %d", request.txHash, request.height))
+}
+
+// Close implements blockchain.Interface.
+func (client *Client) Close() {
+	defer client.lock.Lock()()
+	select {
+	case <-client.quitChan:
+	default:
+		close(client.quitChan)
+	}
+	if client.peer != nil {
+		client.peer.close()
+	}
+	if client.db != nil {
+		if err := client.db.Close(); err != nil {
+			client.log.WithError(err).Error("Could not close the DB")
+		}
+		client.db = nil
+	}
+	for _, request := range client.merkleRequests {
+		go request.cleanup(errConnectionClosed)
+	}
+	client.merkleRequests = nil
+}
+
+// ConnectionStatus implements blockchain.Interface.
+func (client *Client) ConnectionStatus() blockchain.Status {
+	defer client.lock.RLock()()
+	return client.status
+}
+
+// RegisterOnConnectionStatusChangedEvent implements blockchain.Interface.
+func (client *Client) RegisterOnConnectionStatusChangedEvent(onConnectionStatusChanged func(blockchain.Status)) {
+	defer client.lock.Lock()()
+	client.connectionStatusCallbacks = append(client.connectionStatusCallbacks, onConnectionStatusChanged)
+}
+
+func (client *Client) setPeer(p *peer) {
+	defer client.lock.Lock()()
+	client.peer = p
+	status := blockchain.DISCONNECTED
+	if p != nil {
+		status = blockchain.CONNECTED
+	}
+	if status == client.status {
+		return
+	}
+	client.status = status
+	for _, callback := range client.connectionStatusCallbacks {
+		go callback(status)
+	}
+}
+
+// tip returns the height of the best chain. Requires the lock.
+func (client *Client) tip() int {
+	return len(client.headers) - 1
+}
+
+// birthdayHeight returns the height of the first block which can contain transactions of the
+// wallet, or the height after the tip if the birthday is in the future. Requires the lock.
+func (client *Client) birthdayHeight() int {
+	if client.birthday.IsZero() {
+		return 0
+	}
+	earliest := client.birthday.Add(-birthdayWindow)
+	return sort.Search(len(client.headers), func(height int) bool {
+		return !client.headers[height].Timestamp.Before(earliest)
+	})
+}
+
+// addTx adds the tx to the histories of the scripts it touches. Requires the lock.
+func (client *Client) addTx(tx *wire.MsgTx, height int) {
+	txHash := tx.TxHash()
+	touched := map[blockchain.ScriptHashHex]struct{}{}
+	for _, txIn := range tx.TxIn {
+		if scriptHash, ok := client.outPoints[txIn.PreviousOutPoint]; ok {
+			touched[scriptHash] = struct{}{}
+		}
+	}
+	for index, txOut := range tx.TxOut {
+		scriptHash := blockchain.NewScriptHashHex(txOut.PkScript)
+		if client.scripts.Get(scriptHash) != nil {
+			touched[scriptHash] = struct{}{}
+			client.outPoints[wire.OutPoint{Hash: txHash, Index: uint32(index)}] = scriptHash
+		}
+	}
+	if len(touched) == 0 {
+		return
+	}
+	client.txs[txHash] = tx
+	for scriptHash := range touched {
+		addTx(client.scripts.Get(scriptHash), txHash, height)
+	}
+}
+
+// reorg removes the blocks above the given height. Requires the lock.
+func (client *Client) reorg(height int) error {
+	client.log.Infof("Reorg to height %d", height)
+	if client.db != nil {
+		if err := client.db.revertTo(height); err != nil {
+			return err
+		}
+	}
+	for _, header := range client.headers[height+1:] {
+		delete(client.heightByHash, header.BlockHash())
+	}
+	client.headers = client.headers[:height+1]
+	if len(client.filterHeaders) > height+1 {
+		client.filterHeaders = client.filterHeaders[:height+1]
+	}
+	for blockHeight := range client.blockTxs {
+		if blockHeight > height {
+			delete(client.blockTxs, blockHeight)
+		}
+	}
+	for scriptHash, s := range client.scripts.All() {
+		history := blockchain.TxHistory{}
+		for _, entry := range s.History {
+			if entry.Height <= height {
+				history = append(history, entry)
+			}
+		}
+		s.History = history
+		if client.scannedHeights[scriptHash] > height {
+			client.scannedHeights[scriptHash] = height
+		}
+	}
+	return nil
+}
+
+func (client *Client) run() {
+	for {
+		err := client.connectAndSync()
+		client.setPeer(nil)
+		select {
+		case <-client.quitChan:
+			return
+		default:
+		}
+		client.log.WithError(err).Warning("Connection to node lost")
+		select {
+		case <-client.quitChan:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (client *Client) connectAndSync() error {
+	conn, err := client.dial()
+	if err != nil {
+		return err
+	}
+	p, err := newPeer(conn, client.net)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer p.close()
+	messages := make(chan wire.Message)
+	go func() {
+		defer close(messages)
+		for {
+			msg, err := p.readMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-client.quitChan:
+				return
+			}
+		}
+	}()
+	client.setPeer(p)
+	s := &session{client: client, peer: p, messages: messages}
+	for {
+		if err := s.sync(); err != nil {
+			return err
+		}
+		if err := s.waitForNews(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,480 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2p
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	btcdblockchain "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/gcs/builder"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testNet       = &chaincfg.RegressionNetParams
+	ourScript     = []byte{txscript.OP_0, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	foreignScript = []byte{txscript.OP_0, 0x14, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+)
+
+// stubNode is a regtest node serving headers, compact block filters and blocks over the P2P
+// protocol.
+type stubNode struct {
+	t *testing.T
+
+	lock      sync.Mutex
+	blocks    []*wire.MsgBlock
+	fetched   []chainhash.Hash
+	broadcast []*wire.MsgTx
+	// cfHeadersStarts are the start heights of the requested filter headers.
+	cfHeadersStarts []uint32
+	// outgoing are the messages to be written to the current connection. net.Pipe() is not
+	// buffered, so they are written asynchronously to avoid deadlocks with the client writing at
+	// the same time.
+	outgoing chan wire.Message
+}
+
+func newStubNode(t *testing.T) *stubNode {
+	t.Helper()
+	return &stubNode{
+		t:      t,
+		blocks: []*wire.MsgBlock{testNet.GenesisBlock},
+	}
+}
+
+// addBlock mines a block with a coinbase paying to foreignScript and the given transactions.
+func (node *stubNode) addBlock(txs ...*wire.MsgTx) *wire.MsgBlock {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	height := len(node.blocks)
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  []byte{byte(height), 0},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(50*btcutil.SatoshiPerBitcoin, foreignScript))
+	block := &wire.MsgBlock{Transactions: append([]*wire.MsgTx{coinbase}, txs...)}
+	utilTxs := make([]*btcutil.Tx, len(block.Transactions))
+	for index, tx := range block.Transactions {
+		utilTxs[index] = btcutil.NewTx(tx)
+	}
+	merkles := btcdblockchain.BuildMerkleTreeStore(utilTxs, false)
+	block.Header = wire.BlockHeader{
+		Version:    1,
+		PrevBlock:  node.blocks[height-1].BlockHash(),
+		MerkleRoot: *merkles[len(merkles)-1],
+		Timestamp:  time.Unix(int64(1600000000+height*600), 0),
+		Bits:       testNet.PowLimitBits,
+	}
+	node.blocks = append(node.blocks, block)
+	return block
+}
+
+// write queues the message for the current connection. Requires the lock.
+func (node *stubNode) write(msg wire.Message) {
+	node.outgoing <- msg
+}
+
+// filter returns the basic filter of the block at the given height.
+func (node *stubNode) filter(height int) []byte {
+	block := node.blocks[height]
+	prevOutScripts := [][]byte{}
+	for _, tx := range block.Transactions[1:] {
+		for _, txIn := range tx.TxIn {
+			for _, prevBlock := range node.blocks[:height+1] {
+				for _, prevTx := range prevBlock.Transactions {
+					if prevTx.TxHash() == txIn.PreviousOutPoint.Hash {
+						prevOutScripts = append(prevOutScripts, prevTx.TxOut[txIn.PreviousOutPoint.Index].PkScript)
+					}
+				}
+			}
+		}
+	}
+	filter, err := builder.BuildBasicFilter(block, prevOutScripts)
+	require.NoError(node.t, err)
+	data, err := filter.NBytes()
+	require.NoError(node.t, err)
+	return data
+}
+
+func (node *stubNode) height(hash chainhash.Hash) int {
+	for height, block := range node.blocks {
+		if block.BlockHash() == hash {
+			return height
+		}
+	}
+	return -1
+}
+
+func (node *stubNode) handle(msg wire.Message) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	switch msg := msg.(type) {
+	case *wire.MsgVersion:
+		me := wire.NewNetAddressIPPort(net.IPv4zero, 0, wire.SFNodeNetwork|wire.SFNodeCF)
+		version := wire.NewMsgVersion(me, me, 0, int32(len(node.blocks)-1))
+		version.Services = wire.SFNodeNetwork | wire.SFNodeCF
+		node.write(version)
+		node.write(wire.NewMsgVerAck())
+	case *wire.MsgGetHeaders:
+		start := 0
+		for _, hash := range msg.BlockLocatorHashes {
+			if height := node.height(*hash); height != -1 {
+				start = height + 1
+				break
+			}
+		}
+		headers := wire.NewMsgHeaders()
+		for _, block := range node.blocks[start:] {
+			header := block.Header
+			require.NoError(node.t, headers.AddBlockHeader(&header))
+		}
+		node.write(headers)
+	case *wire.MsgGetCFHeaders:
+		node.cfHeadersStarts = append(node.cfHeadersStarts, msg.StartHeight)
+		stop := node.height(msg.StopHash)
+		cfHeaders := wire.NewMsgCFHeaders()
+		cfHeaders.FilterType = msg.FilterType
+		cfHeaders.StopHash = msg.StopHash
+		var filterHeader chainhash.Hash
+		for height := 0; height <= stop; height++ {
+			filterHash := chainhash.DoubleHashH(node.filter(height))
+			if height >= int(msg.StartHeight) {
+				require.NoError(node.t, cfHeaders.AddCFHash(&filterHash))
+			} else {
+				cfHeaders.PrevFilterHeader = chainhash.DoubleHashH(append(filterHash[:], filterHeader[:]...))
+			}
+			filterHeader = chainhash.DoubleHashH(append(filterHash[:], filterHeader[:]...))
+		}
+		node.write(cfHeaders)
+	case *wire.MsgGetCFilters:
+		for height := int(msg.StartHeight); height <= node.height(msg.StopHash); height++ {
+			blockHash := node.blocks[height].BlockHash()
+			node.write(wire.NewMsgCFilter(msg.FilterType, &blockHash, node.filter(height)))
+		}
+	case *wire.MsgGetData:
+		for _, invVect := range msg.InvList {
+			if height := node.height(invVect.Hash); height != -1 {
+				node.fetched = append(node.fetched, invVect.Hash)
+				node.write(node.blocks[height])
+			}
+		}
+	case *wire.MsgTx:
+		node.broadcast = append(node.broadcast, msg)
+	}
+}
+
+func (node *stubNode) serve(conn net.Conn) {
+	// Each connection gets its own queue, so messages are not written to a previous connection.
+	outgoing := make(chan wire.Message, 1000)
+	node.lock.Lock()
+	node.outgoing = outgoing
+	node.lock.Unlock()
+	go func() {
+		for msg := range outgoing {
+			if err := wire.WriteMessage(conn, msg, wire.ProtocolVersion, testNet.Net); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, testNet.Net)
+		if err != nil {
+			return
+		}
+		node.handle(msg)
+	}
+}
+
+// announce sends an inv of the tip to the client.
+func (node *stubNode) announce() {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	tipHash := node.blocks[len(node.blocks)-1].BlockHash()
+	inv := wire.NewMsgInv()
+	require.NoError(node.t, inv.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, &tipHash)))
+	node.write(inv)
+}
+
+func (node *stubNode) fetchedBlocks() []chainhash.Hash {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return append([]chainhash.Hash{}, node.fetched...)
+}
+
+func (node *stubNode) requestedCFHeaders() []uint32 {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return append([]uint32{}, node.cfHeadersStarts...)
+}
+
+func newTestClient(t *testing.T, node *stubNode, db *DB, birthday time.Time) *Client {
+	t.Helper()
+	client, err := NewClient(testNet, func() (net.Conn, error) {
+		clientConn, nodeConn := net.Pipe()
+		go node.serve(nodeConn)
+		return clientConn, nil
+	}, db, birthday, logging.Get().WithGroup("p2p_test"))
+	require.NoError(t, err)
+	return client
+}
+
+// subscribe registers and subscribes to ourScript, returning the channel of its statuses.
+func subscribe(t *testing.T, client *Client) <-chan string {
+	t.Helper()
+	statuses := make(chan string, 10)
+	client.RegisterScript(ourScript, nil, nil)
+	client.ScriptHashSubscribe(
+		func() func(error) { return func(err error) { require.NoError(t, err) } },
+		blockchain.NewScriptHashHex(ourScript),
+		func(status string) error {
+			statuses <- status
+			return nil
+		},
+	)
+	return statuses
+}
+
+func payTo(prevOut wire.OutPoint, pkScript []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(btcutil.SatoshiPerBitcoin, pkScript))
+	return tx
+}
+
+func history(t *testing.T, client *Client, scriptHash blockchain.ScriptHashHex) blockchain.TxHistory {
+	t.Helper()
+	result := make(chan blockchain.TxHistory, 1)
+	client.ScriptHashGetHistory(scriptHash,
+		func(history blockchain.TxHistory) error {
+			result <- history
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	return <-result
+}
+
+func waitForStatus(t *testing.T, statuses <-chan string) string {
+	t.Helper()
+	select {
+	case status := <-statuses:
+		return status
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for the script status")
+		return ""
+	}
+}
+
+func TestClient(t *testing.T) {
+	node := newStubNode(t)
+	block1 := node.addBlock()
+	receive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	block2 := node.addBlock(receive)
+	node.addBlock()
+	spend := payTo(wire.OutPoint{Hash: receive.TxHash()}, foreignScript)
+	block4 := node.addBlock(spend)
+
+	client := newTestClient(t, node, nil, time.Time{})
+	defer client.Close()
+
+	scriptHash := blockchain.NewScriptHashHex(ourScript)
+	statuses := subscribe(t, client)
+	status := waitForStatus(t, statuses)
+	require.Equal(t, blockchain.CONNECTED, client.ConnectionStatus())
+
+	expectedHistory := blockchain.TxHistory{
+		{Height: 2, TXHash: blockchain.TXHash(receive.TxHash())},
+		{Height: 4, TXHash: blockchain.TXHash(spend.TxHash())},
+	}
+	require.Equal(t, expectedHistory, history(t, client, scriptHash))
+	require.Equal(t, expectedHistory.Status(), status)
+	// Only the blocks matching the script were downloaded.
+	require.Equal(t, []chainhash.Hash{block2.BlockHash(), block4.BlockHash()}, node.fetchedBlocks())
+
+	txs := make(chan *wire.MsgTx, 1)
+	client.TransactionGet(spend.TxHash(),
+		func(tx *wire.MsgTx) error {
+			txs <- tx
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	require.Equal(t, spend.TxHash(), (<-txs).TxHash())
+
+	// A new block paying to the script is announced.
+	receive2 := payTo(wire.OutPoint{Hash: block4.Transactions[0].TxHash()}, ourScript)
+	block5 := node.addBlock(receive2)
+	node.announce()
+	status = waitForStatus(t, statuses)
+	expectedHistory = append(expectedHistory,
+		&blockchain.TxInfo{Height: 5, TXHash: blockchain.TXHash(receive2.TxHash())})
+	require.Equal(t, expectedHistory.Status(), status)
+	require.Equal(t, expectedHistory, history(t, client, scriptHash))
+	require.Equal(t, block5.BlockHash(), node.fetchedBlocks()[2])
+}
+
+// TestClientDB tests that the headers and filter headers are loaded from the DB after a restart,
+// so only the new filter headers are downloaded.
+func TestClientDB(t *testing.T) {
+	node := newStubNode(t)
+	block1 := node.addBlock()
+	receive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	node.addBlock(receive)
+	node.addBlock()
+
+	headersFilename := test.TstTempFile("bitbox-wallet-p2p-headers-")
+	filterHeadersFilename := test.TstTempFile("bitbox-wallet-p2p-filterheaders-")
+	db, err := NewDB(headersFilename, filterHeadersFilename)
+	require.NoError(t, err)
+	client := newTestClient(t, node, db, time.Time{})
+	waitForStatus(t, subscribe(t, client))
+	client.Close()
+	require.Equal(t, []uint32{0}, node.requestedCFHeaders())
+
+	node.addBlock()
+	db, err = NewDB(headersFilename, filterHeadersFilename)
+	require.NoError(t, err)
+	headers, filterHeaders, err := db.load(testNet)
+	require.NoError(t, err)
+	require.Len(t, headers, 4)
+	require.Len(t, filterHeaders, 4)
+	client = newTestClient(t, node, db, time.Time{})
+	defer client.Close()
+	status := waitForStatus(t, subscribe(t, client))
+	expectedHistory := blockchain.TxHistory{{Height: 2, TXHash: blockchain.TXHash(receive.TxHash())}}
+	require.Equal(t, expectedHistory.Status(), status)
+	// Only the filter header of the new block was downloaded.
+	require.Equal(t, []uint32{0, 4}, node.requestedCFHeaders())
+}
+
+// TestClientBirthday tests that blocks mined well before the wallet birthday are not scanned.
+func TestClientBirthday(t *testing.T) {
+	node := newStubNode(t)
+	block1 := node.addBlock()
+	receiveOld := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	node.addBlock(receiveOld)
+	var block *wire.MsgBlock
+	for i := 0; i < 20; i++ {
+		block = node.addBlock()
+	}
+	receive := payTo(wire.OutPoint{Hash: block.Transactions[0].TxHash()}, ourScript)
+	block23 := node.addBlock(receive)
+
+	// Blocks are mined every 10 minutes, so the birthday window reaches back 12 blocks.
+	client := newTestClient(t, node, nil, block23.Header.Timestamp)
+	defer client.Close()
+	status := waitForStatus(t, subscribe(t, client))
+	expectedHistory := blockchain.TxHistory{{Height: 23, TXHash: blockchain.TXHash(receive.TxHash())}}
+	require.Equal(t, expectedHistory.Status(), status)
+	require.Equal(t, expectedHistory, history(t, client, blockchain.NewScriptHashHex(ourScript)))
+	require.Equal(t, []chainhash.Hash{block23.BlockHash()}, node.fetchedBlocks())
+}
+
+// TestClientResume tests that the scan resumes from the persisted scan state after a restart, and
+// that the merkle proofs of the txs found before are served by downloading their blocks again.
+func TestClientResume(t *testing.T) {
+	node := newStubNode(t)
+	block1 := node.addBlock()
+	receive := payTo(wire.OutPoint{Hash: block1.Transactions[0].TxHash()}, ourScript)
+	block2 := node.addBlock(receive)
+	for i := 0; i < 20; i++ {
+		node.addBlock()
+	}
+
+	scanStates := make(chan *blockchain.ScanState, 10)
+	client := newTestClient(t, node, nil, time.Time{})
+	client.RegisterScript(ourScript, nil, func(state *blockchain.ScanState) { scanStates <- state })
+	waitForStatus(t, subscribe(t, client))
+	client.Close()
+	var state *blockchain.ScanState
+	select {
+	case state = <-scanStates:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for the scan state")
+	}
+	expectedHistory := blockchain.TxHistory{{Height: 2, TXHash: blockchain.TXHash(receive.TxHash())}}
+	require.Equal(t, 22, state.ScannedHeight)
+	require.Equal(t, expectedHistory, state.History)
+	require.Len(t, state.Txs, 1)
+	require.Equal(t, []chainhash.Hash{block2.BlockHash()}, node.fetchedBlocks())
+
+	spend := payTo(wire.OutPoint{Hash: receive.TxHash()}, foreignScript)
+	block23 := node.addBlock(spend)
+	client = newTestClient(t, node, nil, time.Time{})
+	defer client.Close()
+	client.RegisterScript(ourScript, state, nil)
+	status := waitForStatus(t, subscribe(t, client))
+	expectedHistory = append(expectedHistory,
+		&blockchain.TxInfo{Height: 23, TXHash: blockchain.TXHash(spend.TxHash())})
+	require.Equal(t, expectedHistory.Status(), status)
+	// The spend was found from the restored outputs, without scanning from the genesis block.
+	require.Equal(t, []chainhash.Hash{block2.BlockHash(), block23.BlockHash()}, node.fetchedBlocks())
+
+	merkles := make(chan int, 1)
+	client.GetMerkle(receive.TxHash(), 2,
+		func(merkle []blockchain.TXHash, pos int) error {
+			merkles <- pos
+			return nil
+		},
+		func(err error) { require.NoError(t, err) },
+	)
+	select {
+	case pos := <-merkles:
+		require.Equal(t, 1, pos)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for the merkle proof")
+	}
+	require.Equal(t, block2.BlockHash(), node.fetchedBlocks()[2])
+}
+
+func TestMerkleBranch(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		txHashes := make([]chainhash.Hash, count)
+		utilTxs := make([]*btcutil.Tx, count)
+		for index := range txHashes {
+			tx := wire.NewMsgTx(wire.TxVersion)
+			tx.LockTime = uint32(index)
+			utilTxs[index] = btcutil.NewTx(tx)
+			txHashes[index] = tx.TxHash()
+		}
+		merkles := btcdblockchain.BuildMerkleTreeStore(utilTxs, false)
+		expectedRoot := *merkles[len(merkles)-1]
+		require.Equal(t, expectedRoot, merkleRoot(txHashes))
+		for pos, txHash := range txHashes {
+			root := txHash
+			index := pos
+			for _, sibling := range merkleBranch(txHashes, pos) {
+				if index&1 == 0 {
+					root = hashPair(root, sibling.Hash())
+				} else {
+					root = hashPair(sibling.Hash(), root)
+				}
+				index >>= 1
+			}
+			require.Equal(t, expectedRoot, root)
+		}
+	}
+}
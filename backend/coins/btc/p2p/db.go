@@ -0,0 +1,172 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2p
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	headerSize       = 80
+	filterHeaderSize = chainhash.HashSize
+)
+
+// recordFile stores fixed-size records indexed by height.
+type recordFile struct {
+	file       *os.File
+	recordSize int
+}
+
+func openRecordFile(filename string, recordSize int) (*recordFile, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return &recordFile{file: file, recordSize: recordSize}, nil
+}
+
+// readAll returns all complete records.
+func (f *recordFile) readAll() ([][]byte, error) {
+	fileInfo, err := f.file.Stat()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	data := make([]byte, fileInfo.Size()-fileInfo.Size()%int64(f.recordSize))
+	if _, err := f.file.ReadAt(data, 0); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	records := make([][]byte, len(data)/f.recordSize)
+	for index := range records {
+		records[index] = data[index*f.recordSize : (index+1)*f.recordSize]
+	}
+	return records, nil
+}
+
+func (f *recordFile) put(height int, record []byte) error {
+	if len(record) != f.recordSize {
+		panic("invalid record size")
+	}
+	_, err := f.file.WriteAt(record, int64(height*f.recordSize))
+	return errp.WithStack(err)
+}
+
+// truncate keeps the records up to and including the given height.
+func (f *recordFile) truncate(height int) error {
+	return errp.WithStack(f.file.Truncate(int64((height + 1) * f.recordSize)))
+}
+
+// DB persists the header chain and the verified filter header chain of a Client, so they are not
+// downloaded again after a restart.
+type DB struct {
+	headers       *recordFile
+	filterHeaders *recordFile
+}
+
+// NewDB creates/opens the files storing the headers and the filter headers.
+func NewDB(headersFilename string, filterHeadersFilename string) (*DB, error) {
+	headers, err := openRecordFile(headersFilename, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	filterHeaders, err := openRecordFile(filterHeadersFilename, filterHeaderSize)
+	if err != nil {
+		_ = headers.file.Close()
+		return nil, err
+	}
+	return &DB{headers: headers, filterHeaders: filterHeaders}, nil
+}
+
+// load returns the stored chains. The headers start at the genesis block of the network and are
+// connected, and there are at most as many filter headers as headers. Whatever does not satisfy
+// this, e.g. after a crash, is discarded.
+func (db *DB) load(net *chaincfg.Params) ([]*wire.BlockHeader, []chainhash.Hash, error) {
+	headerRecords, err := db.headers.readAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := []*wire.BlockHeader{}
+	for height, record := range headerRecords {
+		header := &wire.BlockHeader{}
+		if err := header.Deserialize(bytes.NewReader(record)); err != nil {
+			return nil, nil, errp.WithStack(err)
+		}
+		if height == 0 && header.BlockHash() != *net.GenesisHash {
+			break
+		}
+		if height > 0 && header.PrevBlock != headers[height-1].BlockHash() {
+			break
+		}
+		headers = append(headers, header)
+	}
+	filterHeaderRecords, err := db.filterHeaders.readAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(filterHeaderRecords) > len(headers) {
+		filterHeaderRecords = filterHeaderRecords[:len(headers)]
+	}
+	filterHeaders := make([]chainhash.Hash, len(filterHeaderRecords))
+	for height, record := range filterHeaderRecords {
+		copy(filterHeaders[height][:], record)
+	}
+	if err := db.headers.truncate(len(headers) - 1); err != nil {
+		return nil, nil, err
+	}
+	if err := db.filterHeaders.truncate(len(filterHeaders) - 1); err != nil {
+		return nil, nil, err
+	}
+	return headers, filterHeaders, nil
+}
+
+func (db *DB) putHeader(height int, header *wire.BlockHeader) error {
+	var headerSer bytes.Buffer
+	if err := header.Serialize(&headerSer); err != nil {
+		return errp.WithStack(err)
+	}
+	return db.headers.put(height, headerSer.Bytes())
+}
+
+func (db *DB) putFilterHeader(height int, filterHeader chainhash.Hash) error {
+	return db.filterHeaders.put(height, filterHeader[:])
+}
+
+// revertTo removes the headers and filter headers above the given height.
+func (db *DB) revertTo(height int) error {
+	if err := db.headers.truncate(height); err != nil {
+		return err
+	}
+	fileInfo, err := db.filterHeaders.file.Stat()
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	if fileInfo.Size() > int64((height+1)*filterHeaderSize) {
+		return db.filterHeaders.truncate(height)
+	}
+	return nil
+}
+
+// Close closes the files.
+func (db *DB) Close() error {
+	if err := db.headers.file.Close(); err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(db.filterHeaders.file.Close())
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2p
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+)
+
+func hashPair(left chainhash.Hash, right chainhash.Hash) chainhash.Hash {
+	return chainhash.DoubleHashH(append(left[:], right[:]...))
+}
+
+// nextLevel returns the parent level of the merkle tree. The last hash of levels with an odd
+// number of hashes is paired with itself.
+func nextLevel(level []chainhash.Hash) []chainhash.Hash {
+	next := make([]chainhash.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, hashPair(level[i], level[i+1]))
+		} else {
+			next = append(next, hashPair(level[i], level[i]))
+		}
+	}
+	return next
+}
+
+// merkleRoot computes the merkle root of the tx hashes of a block.
+func merkleRoot(txHashes []chainhash.Hash) chainhash.Hash {
+	if len(txHashes) == 0 {
+		return chainhash.Hash{}
+	}
+	level := txHashes
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// merkleBranch returns the merkle proof of the tx at the given position in the format of
+// Electrum's blockchain.transaction.get_merkle, i.e. the sibling hashes from the leaf to the root.
+func merkleBranch(txHashes []chainhash.Hash, pos int) []blockchain.TXHash {
+	branch := []blockchain.TXHash{}
+	level := txHashes
+	for len(level) > 1 {
+		sibling := pos ^ 1
+		if sibling >= len(level) {
+			sibling = pos
+		}
+		branch = append(branch, blockchain.TXHash(level[sibling]))
+		level = nextLevel(level)
+		pos >>= 1
+	}
+	return branch
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package p2p implements a light client fetching the wallet history from a Bitcoin node using
+// compact block filters (BIP157/BIP158).
+package p2p
+
+import (
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// NewP2PConnection connects to the node at the given address (host:port) and returns a client to
+// communicate with it. The headers and filter headers are stored in the given files. Blocks mined
+// well before the wallet birthday are not scanned, unless it is the zero time.
+func NewP2PConnection(
+	address string,
+	params *chaincfg.Params,
+	headersFilename string,
+	filterHeadersFilename string,
+	birthday time.Time,
+	log *logrus.Entry,
+	socksProxy socksproxy.SocksProxy,
+) (blockchain.Interface, error) {
+	log = log.WithFields(logrus.Fields{"group": "p2p", "peer": address})
+	log.Debug("Connecting to node")
+	db, err := NewDB(headersFilename, filterHeadersFilename)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(params, func() (net.Conn, error) {
+		dialer, err := socksProxy.GetTCPProxyDialer()
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		return conn, nil
+	}, db, birthday, log)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2p
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// userAgent is sent to the node in the version message.
+const userAgent = "bitbox-wallet"
+
+// peer is a connection to a node speaking the Bitcoin P2P protocol. Messages can be written
+// concurrently, but must only be read from one goroutine.
+type peer struct {
+	conn net.Conn
+	net  *chaincfg.Params
+
+	writeLock sync.Mutex
+}
+
+// newPeer performs the version handshake on the connection. The node must serve compact block
+// filters (BIP157).
+func newPeer(conn net.Conn, params *chaincfg.Params) (*peer, error) {
+	p := &peer{conn: conn, net: params}
+	me := wire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+	you := wire.NewNetAddressIPPort(net.IPv4zero, 0, wire.SFNodeNetwork|wire.SFNodeCF)
+	version := wire.NewMsgVersion(me, you, rand.Uint64(), 0)
+	version.UserAgent = wire.DefaultUserAgent + userAgent + "/"
+	// We are not interested in unconfirmed transactions of others.
+	version.DisableRelayTx = true
+	if err := p.writeMessage(version); err != nil {
+		return nil, err
+	}
+	var gotVersion, gotVerAck bool
+	for !gotVersion || !gotVerAck {
+		msg, err := p.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msg := msg.(type) {
+		case *wire.MsgVersion:
+			if msg.Services&wire.SFNodeCF == 0 {
+				return nil, errp.New("node does not serve compact block filters")
+			}
+			gotVersion = true
+			if err := p.writeMessage(wire.NewMsgVerAck()); err != nil {
+				return nil, err
+			}
+		case *wire.MsgVerAck:
+			gotVerAck = true
+		}
+	}
+	return p, nil
+}
+
+func (p *peer) writeMessage(msg wire.Message) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	return errp.WithStack(wire.WriteMessage(p.conn, msg, wire.ProtocolVersion, p.net.Net))
+}
+
+func (p *peer) readMessage() (wire.Message, error) {
+	for {
+		msg, _, err := wire.ReadMessage(p.conn, wire.ProtocolVersion, p.net.Net)
+		if err != nil {
+			// Unknown messages, e.g. from newer protocol versions, are skipped.
+			if _, ok := err.(*wire.MessageError); ok {
+				continue
+			}
+			return nil, errp.WithStack(err)
+		}
+		return msg, nil
+	}
+}
+
+func (p *peer) close() {
+	_ = p.conn.Close()
+}
@@ -0,0 +1,544 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2p
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/gcs"
+	"github.com/btcsuite/btcutil/gcs/builder"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// session syncs the client with a connected peer. It reads all messages of the peer.
+type session struct {
+	client   *Client
+	peer     *peer
+	messages <-chan wire.Message
+}
+
+var errConnectionClosed = errp.New("connection closed")
+
+// handle answers pings and returns true if the message announces a new block.
+func (s *session) handle(msg wire.Message) (bool, error) {
+	switch msg := msg.(type) {
+	case *wire.MsgPing:
+		return false, s.peer.writeMessage(wire.NewMsgPong(msg.Nonce))
+	case *wire.MsgInv:
+		for _, invVect := range msg.InvList {
+			if invVect.Type == wire.InvTypeBlock || invVect.Type == wire.InvTypeWitnessBlock {
+				return true, nil
+			}
+		}
+	case *wire.MsgHeaders:
+		return len(msg.Headers) != 0, nil
+	}
+	return false, nil
+}
+
+// waitForNews waits until a new block is announced or a sync is requested.
+func (s *session) waitForNews() error {
+	for {
+		select {
+		case msg, ok := <-s.messages:
+			if !ok {
+				return errConnectionClosed
+			}
+			announced, err := s.handle(msg)
+			if err != nil {
+				return err
+			}
+			if announced {
+				return nil
+			}
+		case <-s.client.syncChan:
+			return nil
+		case <-s.client.quitChan:
+			return errConnectionClosed
+		}
+	}
+}
+
+// request sends the message and passes the received messages to onMessage until it returns true.
+func (s *session) request(msg wire.Message, onMessage func(wire.Message) (bool, error)) error {
+	if err := s.peer.writeMessage(msg); err != nil {
+		return err
+	}
+	timeout := time.After(requestTimeout)
+	for {
+		select {
+		case msg, ok := <-s.messages:
+			if !ok {
+				return errConnectionClosed
+			}
+			if _, err := s.handle(msg); err != nil {
+				return err
+			}
+			done, err := onMessage(msg)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		case <-timeout:
+			return errp.Newf("timeout waiting for the response to %s", msg.Command())
+		case <-s.client.quitChan:
+			return errConnectionClosed
+		}
+	}
+}
+
+func (s *session) sync() error {
+	if err := s.syncHeaders(); err != nil {
+		return err
+	}
+	if err := s.syncFilterHeaders(); err != nil {
+		return err
+	}
+	if err := s.scan(); err != nil {
+		return err
+	}
+	return s.answerMerkleRequests()
+}
+
+// answerMerkleRequests downloads the blocks of the pending merkle requests and answers them.
+// Requests for blocks above the tip and requests which failed stay pending for the next sync.
+func (s *session) answerMerkleRequests() error {
+	client := s.client
+	var requests []*merkleRequest
+	var tip int
+	func() {
+		defer client.lock.Lock()()
+		requests = client.merkleRequests
+		client.merkleRequests = nil
+		tip = client.tip()
+	}()
+	pending := []*merkleRequest{}
+	var err error
+	for _, request := range requests {
+		if err != nil || request.height > tip {
+			pending = append(pending, request)
+			continue
+		}
+		var downloaded bool
+		func() {
+			defer client.lock.RLock()()
+			_, downloaded = client.blockTxs[request.height]
+		}()
+		if !downloaded {
+			if err = s.downloadBlock(request.height); err != nil {
+				pending = append(pending, request)
+				continue
+			}
+		}
+		func() {
+			defer client.lock.Lock()()
+			client.answerMerkle(request)
+		}()
+	}
+	defer client.lock.Lock()()
+	client.merkleRequests = append(client.merkleRequests, pending...)
+	return err
+}
+
+// blockLocator returns the hashes of the best chain, dense at the tip and exponentially sparser
+// towards the genesis block. Requires the lock.
+func (client *Client) blockLocator() []*chainhash.Hash {
+	locator := []*chainhash.Hash{}
+	step := 1
+	for height := client.tip(); height > 0; height -= step {
+		hash := client.headers[height].BlockHash()
+		locator = append(locator, &hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	genesisHash := client.headers[0].BlockHash()
+	return append(locator, &genesisHash)
+}
+
+// syncHeaders downloads the headers of the best chain of the peer.
+func (s *session) syncHeaders() error {
+	client := s.client
+	for {
+		getHeaders := wire.NewMsgGetHeaders()
+		func() {
+			defer client.lock.RLock()()
+			getHeaders.BlockLocatorHashes = client.blockLocator()
+		}()
+		var blockHeaders []*wire.BlockHeader
+		err := s.request(getHeaders, func(msg wire.Message) (bool, error) {
+			if headersMsg, ok := msg.(*wire.MsgHeaders); ok {
+				blockHeaders = headersMsg.Headers
+				return true, nil
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := client.connectHeaders(blockHeaders); err != nil {
+			return err
+		}
+		if len(blockHeaders) < wire.MaxBlockHeadersPerMsg {
+			break
+		}
+	}
+	defer client.lock.Lock()()
+	if client.headersSynced && client.notifiedTip == client.tip() {
+		return nil
+	}
+	client.headersSynced = true
+	client.notifiedTip = client.tip()
+	header := &blockchain.Header{BlockHeight: client.tip()}
+	for _, callback := range client.headersSubscriptions {
+		go func(callback func(*blockchain.Header) error) {
+			if err := callback(header); err != nil {
+				client.log.WithError(err).Error("Could not handle the new tip")
+			}
+		}(callback)
+	}
+	return nil
+}
+
+// connectHeaders adds the headers to the best chain, replacing the blocks after the parent of the
+// first header in case of a reorg.
+func (client *Client) connectHeaders(blockHeaders []*wire.BlockHeader) error {
+	if len(blockHeaders) == 0 {
+		return nil
+	}
+	defer client.lock.Lock()()
+	parentHeight, ok := client.heightByHash[blockHeaders[0].PrevBlock]
+	if !ok {
+		return errp.New("headers do not connect to our chain")
+	}
+	for index, header := range blockHeaders[1:] {
+		if header.PrevBlock != blockHeaders[index].BlockHash() {
+			return errp.New("headers do not connect")
+		}
+	}
+	if parentHeight+len(blockHeaders) <= client.tip() {
+		// Not more work than our chain.
+		return nil
+	}
+	if parentHeight < client.tip() {
+		if err := client.reorg(parentHeight); err != nil {
+			return err
+		}
+	}
+	for _, header := range blockHeaders {
+		client.headers = append(client.headers, header)
+		client.heightByHash[header.BlockHash()] = client.tip()
+		if client.db != nil {
+			if err := client.db.putHeader(client.tip(), header); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncFilterHeaders downloads the filter headers up to the tip. The filter header chain is
+// verified from the genesis block on, so the filters can be verified against it when scanning.
+func (s *session) syncFilterHeaders() error {
+	client := s.client
+	for {
+		var startHeight, endHeight int
+		var stopHash, prevFilterHeader chainhash.Hash
+		var err error
+		done := func() bool {
+			defer client.lock.RLock()()
+			startHeight = len(client.filterHeaders)
+			if startHeight > client.tip() {
+				return true
+			}
+			endHeight = startHeight + wire.MaxGetCFiltersReqRange - 1
+			if endHeight > client.tip() {
+				endHeight = client.tip()
+			}
+			stopHash, err = client.blockHash(endHeight)
+			if startHeight > 0 {
+				prevFilterHeader = client.filterHeaders[startHeight-1]
+			}
+			return false
+		}()
+		if done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var filterHashes []*chainhash.Hash
+		err = s.request(
+			wire.NewMsgGetCFHeaders(wire.GCSFilterRegular, uint32(startHeight), &stopHash),
+			func(msg wire.Message) (bool, error) {
+				cfHeaders, ok := msg.(*wire.MsgCFHeaders)
+				if !ok || cfHeaders.StopHash != stopHash {
+					return false, nil
+				}
+				if cfHeaders.PrevFilterHeader != prevFilterHeader {
+					return false, errp.New("filter headers do not connect")
+				}
+				filterHashes = cfHeaders.FilterHashes
+				return true, nil
+			})
+		if err != nil {
+			return err
+		}
+		if len(filterHashes) != endHeight-startHeight+1 {
+			return errp.New("unexpected number of filter headers")
+		}
+		err = func() error {
+			defer client.lock.Lock()()
+			// The chain might have been reorganized in the meantime.
+			if currentStopHash, err := client.blockHash(endHeight); err != nil ||
+				currentStopHash != stopHash || len(client.filterHeaders) != startHeight {
+				return errp.New("chain was reorganized")
+			}
+			filterHeader := prevFilterHeader
+			for _, filterHash := range filterHashes {
+				filterHeader = chainhash.DoubleHashH(append(filterHash[:], filterHeader[:]...))
+				client.filterHeaders = append(client.filterHeaders, filterHeader)
+				if client.db != nil {
+					if err := client.db.putFilterHeader(len(client.filterHeaders)-1, filterHeader); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scanItem is a script to be matched against the filters starting at scannedHeight+1.
+type scanItem struct {
+	scriptHash    blockchain.ScriptHashHex
+	pkScript      []byte
+	scannedHeight int
+}
+
+// scan matches the filters against the scripts which are not scanned up to the tip yet, and
+// downloads the matching blocks. Blocks mined before the wallet birthday are skipped.
+func (s *session) scan() error {
+	client := s.client
+	var items []scanItem
+	var tip int
+	startHeight := -1
+	func() {
+		defer client.lock.Lock()()
+		tip = client.tip()
+		birthdayHeight := client.birthdayHeight()
+		for scriptHash, script := range client.scripts.All() {
+			if client.scannedHeights[scriptHash] < birthdayHeight-1 {
+				client.scannedHeights[scriptHash] = birthdayHeight - 1
+			}
+			scannedHeight := client.scannedHeights[scriptHash]
+			if scannedHeight >= tip {
+				continue
+			}
+			items = append(items, scanItem{scriptHash, script.PkScript, scannedHeight})
+			if startHeight == -1 || scannedHeight+1 < startHeight {
+				startHeight = scannedHeight + 1
+			}
+		}
+	}()
+	for batchStart := startHeight; len(items) != 0 && batchStart <= tip; batchStart += wire.MaxGetCFiltersReqRange {
+		batchEnd := batchStart + wire.MaxGetCFiltersReqRange - 1
+		if batchEnd > tip {
+			batchEnd = tip
+		}
+		matchedHeights, err := s.scanBatch(items, batchStart, batchEnd)
+		if err != nil {
+			return err
+		}
+		for _, height := range matchedHeights {
+			if err := s.downloadBlock(height); err != nil {
+				return err
+			}
+		}
+		func() {
+			defer client.lock.Lock()()
+			for _, item := range items {
+				if client.scannedHeights[item.scriptHash] < batchEnd {
+					client.scannedHeights[item.scriptHash] = batchEnd
+				}
+			}
+		}()
+	}
+	var callbacks []func()
+	func() {
+		defer client.lock.Lock()()
+		client.notifyScripts()
+		callbacks = client.changedScanStates()
+	}()
+	// Persisting the scan states opens the account DBs, so the lock is not held.
+	for _, callback := range callbacks {
+		callback()
+	}
+	return nil
+}
+
+// blockHash returns the hash of the block at the given height. Requires the lock.
+func (client *Client) blockHash(height int) (chainhash.Hash, error) {
+	if height > client.tip() {
+		return chainhash.Hash{}, errp.New("chain was reorganized")
+	}
+	return client.headers[height].BlockHash(), nil
+}
+
+// scanBatch downloads the filters of the blocks from startHeight to endHeight, verifies them against
+// the filter headers and returns the heights of the blocks matching the scripts.
+func (s *session) scanBatch(items []scanItem, startHeight int, endHeight int) ([]int, error) {
+	client := s.client
+	var stopHash chainhash.Hash
+	var err error
+	func() {
+		defer client.lock.RLock()()
+		stopHash, err = client.blockHash(endHeight)
+		if err == nil && endHeight >= len(client.filterHeaders) {
+			err = errp.New("filter headers not synced")
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	matchedHeights := []int{}
+	height := startHeight
+	err = s.request(
+		wire.NewMsgGetCFilters(wire.GCSFilterRegular, uint32(startHeight), &stopHash),
+		func(msg wire.Message) (bool, error) {
+			cfilter, ok := msg.(*wire.MsgCFilter)
+			if !ok {
+				return false, nil
+			}
+			var blockHash, filterHeader, prevFilterHeader chainhash.Hash
+			var err error
+			func() {
+				defer client.lock.RLock()()
+				blockHash, err = client.blockHash(height)
+				if err != nil || height >= len(client.filterHeaders) {
+					err = errp.New("chain was reorganized")
+					return
+				}
+				filterHeader = client.filterHeaders[height]
+				if height > 0 {
+					prevFilterHeader = client.filterHeaders[height-1]
+				}
+			}()
+			if err != nil {
+				return false, err
+			}
+			if cfilter.BlockHash != blockHash {
+				return false, errp.Newf("unexpected filter for block %s", cfilter.BlockHash)
+			}
+			filterHash := chainhash.DoubleHashH(cfilter.Data)
+			if chainhash.DoubleHashH(append(filterHash[:], prevFilterHeader[:]...)) != filterHeader {
+				return false, errp.Newf("filter of block %s does not match its header", blockHash)
+			}
+			matched, err := matchFilter(cfilter.Data, blockHash, items, height)
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				matchedHeights = append(matchedHeights, height)
+			}
+			height++
+			return height > endHeight, nil
+		})
+	return matchedHeights, err
+}
+
+// matchFilter returns true if the filter matches any of the scripts not yet scanned at this
+// height.
+func matchFilter(data []byte, blockHash chainhash.Hash, items []scanItem, height int) (bool, error) {
+	filter, err := gcs.FromNBytes(builder.DefaultP, builder.DefaultM, data)
+	if err != nil {
+		return false, errp.WithStack(err)
+	}
+	if filter.N() == 0 {
+		return false, nil
+	}
+	pkScripts := [][]byte{}
+	for _, item := range items {
+		if item.scannedHeight < height {
+			pkScripts = append(pkScripts, item.pkScript)
+		}
+	}
+	if len(pkScripts) == 0 {
+		return false, nil
+	}
+	matched, err := filter.MatchAny(builder.DeriveKey(&blockHash), pkScripts)
+	return matched, errp.WithStack(err)
+}
+
+// downloadBlock downloads the block at the given height and adds the transactions touching our
+// scripts to their history.
+func (s *session) downloadBlock(height int) error {
+	client := s.client
+	var blockHash chainhash.Hash
+	var err error
+	func() {
+		defer client.lock.RLock()()
+		blockHash, err = client.blockHash(height)
+	}()
+	if err != nil {
+		return err
+	}
+	getData := wire.NewMsgGetData()
+	if err := getData.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &blockHash)); err != nil {
+		return errp.WithStack(err)
+	}
+	var block *wire.MsgBlock
+	err = s.request(getData, func(msg wire.Message) (bool, error) {
+		switch msg := msg.(type) {
+		case *wire.MsgBlock:
+			if msg.BlockHash() != blockHash {
+				return false, nil
+			}
+			block = msg
+			return true, nil
+		case *wire.MsgNotFound:
+			return false, errp.Newf("block %s not found", blockHash)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	txHashes := make([]chainhash.Hash, len(block.Transactions))
+	for index, tx := range block.Transactions {
+		txHashes[index] = tx.TxHash()
+	}
+	if merkleRoot(txHashes) != block.Header.MerkleRoot {
+		return errp.Newf("block %s has an invalid merkle root", blockHash)
+	}
+	defer client.lock.Lock()()
+	if _, err := client.blockHash(height); err != nil {
+		return err
+	}
+	for _, tx := range block.Transactions {
+		client.addTx(tx, height)
+	}
+	client.blockTxs[height] = txHashes
+	return nil
+}
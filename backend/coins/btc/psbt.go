@@ -0,0 +1,222 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// ErrPSBTForeign is returned when broadcasting a PSBT which does not spend any outputs of the
+// account.
+var ErrPSBTForeign = errors.New("the PSBT does not spend any outputs of this account")
+
+// psbtBip32Derivations returns the derivation info of the public keys of the address. Keys of
+// unknown origin are left out, as their master key fingerprint and keypath are unknown.
+func psbtBip32Derivations(address *addresses.AccountAddress) []*psbt.Bip32Derivation {
+	keyOrigins := address.Configuration.KeyOrigins()
+	derivations := []*psbt.Bip32Derivation{}
+	for index, publicKey := range address.Configuration.PublicKeys() {
+		keyOrigin := keyOrigins[index]
+		if keyOrigin == nil {
+			continue
+		}
+		derivations = append(derivations, &psbt.Bip32Derivation{
+			PubKey:               publicKey.SerializeCompressed(),
+			MasterKeyFingerprint: binary.LittleEndian.Uint32(keyOrigin.RootFingerprint),
+			Bip32Path:            keyOrigin.Keypath.ToUInt32(),
+		})
+	}
+	return derivations
+}
+
+// psbtRedeemScript returns the redeem script of a P2SH address, or nil for other addresses.
+func psbtRedeemScript(address *addresses.AccountAddress) []byte {
+	if !txscript.IsPayToScriptHash(address.PubkeyScript()) {
+		return nil
+	}
+	return address.RedeemScript()
+}
+
+// psbtWitnessScript returns the witness script of a P2WSH multisig address, or nil for other
+// addresses.
+func psbtWitnessScript(address *addresses.AccountAddress) []byte {
+	if !address.Configuration.Multisig() {
+		return nil
+	}
+	isSegwit, witnessScript := address.ScriptForHashToSign()
+	if !isSegwit {
+		return nil
+	}
+	return witnessScript
+}
+
+// psbtPartialSigs returns the signatures the local keystores made for the given input, if any.
+func (proposedTransaction *ProposedTransaction) psbtPartialSigs(
+	address *addresses.AccountAddress, inputIndex int) []*psbt.PartialSig {
+	if proposedTransaction.Signatures == nil {
+		return nil
+	}
+	publicKeys := address.Configuration.PublicKeys()
+	partialSigs := []*psbt.PartialSig{}
+	for cosignerIndex, signature := range proposedTransaction.Signatures[inputIndex] {
+		if signature == nil {
+			continue
+		}
+		partialSigs = append(partialSigs, &psbt.PartialSig{
+			PubKey:    publicKeys[cosignerIndex].SerializeCompressed(),
+			Signature: append(signature.Serialize(), byte(txscript.SigHashAll)),
+		})
+	}
+	return partialSigs
+}
+
+// PSBT creates a partially signed bitcoin transaction (BIP174) of the proposed transaction, so it
+// can be signed by other wallets. getPrevTx must return the transaction containing the given spent
+// output, which is required to sign non-segwit inputs.
+func (proposedTransaction *ProposedTransaction) PSBT(
+	getPrevTx func(chainhash.Hash) *wire.MsgTx,
+) (*psbt.Packet, error) {
+	tx := proposedTransaction.TXProposal.Transaction
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	for index, txIn := range tx.TxIn {
+		spentOutput, ok := proposedTransaction.PreviousOutputs[txIn.PreviousOutPoint]
+		if !ok {
+			return nil, errp.New("There needs to be exactly one output being spent per input!")
+		}
+		address := proposedTransaction.GetAddress(spentOutput.ScriptHashHex())
+		if address.IsTaproot() {
+			return nil, errp.New("PSBTs spending taproot outputs are not supported yet")
+		}
+		isSegwit, _ := address.ScriptForHashToSign()
+		input := packet.Inputs[index]
+		input.NonWitnessUtxo = getPrevTx(txIn.PreviousOutPoint.Hash)
+		if isSegwit {
+			input.WitnessUtxo = spentOutput.TxOut
+		} else if input.NonWitnessUtxo == nil {
+			return nil, errp.New("The transaction of a spent output is unknown")
+		}
+		input.SighashType = uint32(txscript.SigHashAll)
+		input.RedeemScript = psbtRedeemScript(address)
+		input.WitnessScript = psbtWitnessScript(address)
+		input.PartialSigs = proposedTransaction.psbtPartialSigs(address, index)
+		input.Bip32Derivation = psbtBip32Derivations(address)
+	}
+	if changeAddress := proposedTransaction.TXProposal.ChangeAddress; changeAddress != nil {
+		for index, txOut := range tx.TxOut {
+			if bytes.Equal(txOut.PkScript, changeAddress.PubkeyScript()) {
+				packet.Outputs[index].RedeemScript = psbtRedeemScript(changeAddress)
+				packet.Outputs[index].WitnessScript = psbtWitnessScript(changeAddress)
+				packet.Outputs[index].Bip32Derivation = psbtBip32Derivations(changeAddress)
+			}
+		}
+	}
+	return packet, nil
+}
+
+// PSBT creates an unsigned tx paying the recipients like SendTxBatch() and returns it as a
+// partially signed bitcoin transaction (BIP174) instead of signing it with the keystores. For
+// multisig accounts, the signatures of the local keystores are included, so the external cosigners
+// only need to add theirs.
+func (account *Account) PSBT(
+	recipients []Recipient,
+	feeTargetCode accounts.FeeTargetCode,
+	selectedUTXOs map[wire.OutPoint]struct{},
+) (*psbt.Packet, error) {
+	account.log.Info("Creating a PSBT")
+	utxo, txProposal, err := account.newTx(recipients, feeTargetCode, selectedUTXOs)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to create transaction")
+	}
+	if account.signingConfiguration.Multisig() && account.keystores.Count() > 0 {
+		proposedTransaction, err := signWithKeystores(
+			account.keystores, txProposal, utxo, account.getAddress)
+		if err != nil {
+			return nil, errp.WithMessage(err, "Failed to sign transaction")
+		}
+		return proposedTransaction.PSBT(account.transactions.Tx)
+	}
+	proposedTransaction := &ProposedTransaction{
+		TXProposal:      txProposal,
+		PreviousOutputs: utxo,
+		GetAddress:      account.getAddress,
+	}
+	return proposedTransaction.PSBT(account.transactions.Tx)
+}
+
+// psbtPreviousOutputs returns the outputs spent by the transaction of the PSBT. At least one of
+// them has to be one of the given spendable outputs of the account, and the PSBT has to state them
+// as they are known to the account. Returns ErrPSBTForeign otherwise.
+func psbtPreviousOutputs(
+	packet *psbt.Packet,
+	tx *wire.MsgTx,
+	spendableOutputs map[wire.OutPoint]*transactions.SpendableOutput,
+) (map[wire.OutPoint]*transactions.SpendableOutput, error) {
+	previousOutputs := make(map[wire.OutPoint]*transactions.SpendableOutput, len(tx.TxIn))
+	spendsAccountOutput := false
+	for index, txIn := range tx.TxIn {
+		prevOutput := packet.PrevOutput(index)
+		if prevOutput == nil {
+			return nil, errp.New("The PSBT is missing the spent outputs")
+		}
+		if spendableOutput, ok := spendableOutputs[txIn.PreviousOutPoint]; ok {
+			if prevOutput.Value != spendableOutput.Value ||
+				!bytes.Equal(prevOutput.PkScript, spendableOutput.PkScript) {
+				return nil, errp.New("The PSBT states a spent output of the account wrongly")
+			}
+			spendsAccountOutput = true
+		}
+		previousOutputs[txIn.PreviousOutPoint] = &transactions.SpendableOutput{TxOut: prevOutput}
+	}
+	if !spendsAccountOutput {
+		return nil, errp.WithStack(ErrPSBTForeign)
+	}
+	return previousOutputs, nil
+}
+
+// BroadcastPSBT finalizes the fully signed PSBT, checks that it spends outputs of the account and
+// its signatures, and broadcasts the transaction.
+func (account *Account) BroadcastPSBT(packet *psbt.Packet) error {
+	account.log.Info("Broadcasting a PSBT")
+	if err := packet.FinalizeAll(); err != nil {
+		return errp.WithMessage(err, "The PSBT can't be finalized")
+	}
+	tx, err := packet.Extract()
+	if err != nil {
+		return err
+	}
+	previousOutputs, err := psbtPreviousOutputs(packet, tx, account.transactions.SpendableOutputs())
+	if err != nil {
+		return err
+	}
+	if err := txScriptCheck(tx, previousOutputs, txscript.NewTxSigHashes(tx)); err != nil {
+		return errp.WithMessage(err, "The PSBT has invalid signatures")
+	}
+	account.log.Info("Signed PSBT transaction is broadcasted")
+	return account.blockchain.TransactionBroadcast(tx)
+}
@@ -0,0 +1,314 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psbt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+func mergeUnknowns(unknowns []*Unknown, others []*Unknown) []*Unknown {
+	for _, other := range others {
+		found := false
+		for _, unknown := range unknowns {
+			if bytes.Equal(unknown.Key, other.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknowns = append(unknowns, other)
+		}
+	}
+	return unknowns
+}
+
+func mergeBip32Derivations(derivations []*Bip32Derivation, others []*Bip32Derivation) []*Bip32Derivation {
+	for _, other := range others {
+		found := false
+		for _, derivation := range derivations {
+			if bytes.Equal(derivation.PubKey, other.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			derivations = append(derivations, other)
+		}
+	}
+	return derivations
+}
+
+func (input *Input) merge(other *Input) {
+	if input.NonWitnessUtxo == nil {
+		input.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if input.WitnessUtxo == nil {
+		input.WitnessUtxo = other.WitnessUtxo
+	}
+	for _, otherSig := range other.PartialSigs {
+		found := false
+		for _, partialSig := range input.PartialSigs {
+			if bytes.Equal(partialSig.PubKey, otherSig.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			input.PartialSigs = append(input.PartialSigs, otherSig)
+		}
+	}
+	if input.SighashType == 0 {
+		input.SighashType = other.SighashType
+	}
+	if input.RedeemScript == nil {
+		input.RedeemScript = other.RedeemScript
+	}
+	if input.WitnessScript == nil {
+		input.WitnessScript = other.WitnessScript
+	}
+	input.Bip32Derivation = mergeBip32Derivations(input.Bip32Derivation, other.Bip32Derivation)
+	if input.FinalScriptSig == nil {
+		input.FinalScriptSig = other.FinalScriptSig
+	}
+	if input.FinalScriptWitness == nil {
+		input.FinalScriptWitness = other.FinalScriptWitness
+	}
+	input.Unknowns = mergeUnknowns(input.Unknowns, other.Unknowns)
+}
+
+func (output *Output) merge(other *Output) {
+	if output.RedeemScript == nil {
+		output.RedeemScript = other.RedeemScript
+	}
+	if output.WitnessScript == nil {
+		output.WitnessScript = other.WitnessScript
+	}
+	output.Bip32Derivation = mergeBip32Derivations(output.Bip32Derivation, other.Bip32Derivation)
+	output.Unknowns = mergeUnknowns(output.Unknowns, other.Unknowns)
+}
+
+// Combine merges the given packets into one, e.g. to collect the signatures of multiple signers.
+// All packets must be for the same unsigned tx.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, errp.New("no psbt to combine")
+	}
+	// Serializing and parsing creates a deep copy.
+	serialized, err := packets[0].Serialize()
+	if err != nil {
+		return nil, err
+	}
+	result, err := Parse(serialized)
+	if err != nil {
+		return nil, err
+	}
+	txHash := result.UnsignedTx.TxHash()
+	for _, packet := range packets[1:] {
+		if packet.UnsignedTx.TxHash() != txHash {
+			return nil, errp.New("can't combine psbts of different transactions")
+		}
+		for i, input := range packet.Inputs {
+			result.Inputs[i].merge(input)
+		}
+		for i, output := range packet.Outputs {
+			result.Outputs[i].merge(output)
+		}
+		result.Unknowns = mergeUnknowns(result.Unknowns, packet.Unknowns)
+	}
+	return result, nil
+}
+
+// IsFinalized returns true if the input at the given index has a final scriptSig or witness.
+func (packet *Packet) IsFinalized(index int) bool {
+	input := packet.Inputs[index]
+	return input.FinalScriptSig != nil || input.FinalScriptWitness != nil
+}
+
+// IsComplete returns true if all inputs are finalized.
+func (packet *Packet) IsComplete() bool {
+	for index := range packet.Inputs {
+		if !packet.IsFinalized(index) {
+			return false
+		}
+	}
+	return true
+}
+
+// partialSig returns the signature of the given public key, or nil if it has not signed yet.
+func (input *Input) partialSig(pubKey []byte) []byte {
+	for _, partialSig := range input.PartialSigs {
+		if bytes.Equal(partialSig.PubKey, pubKey) {
+			return partialSig.Signature
+		}
+	}
+	return nil
+}
+
+// singleSig returns the only partial signature of a singlesig input and its public key.
+func (input *Input) singleSig() ([]byte, []byte, error) {
+	if len(input.PartialSigs) != 1 {
+		return nil, nil, errp.New("expected exactly one signature")
+	}
+	return input.PartialSigs[0].Signature, input.PartialSigs[0].PubKey, nil
+}
+
+// multisigSigs returns the signatures needed to satisfy the given multisig script, in the order of
+// the public keys in the script.
+func (input *Input) multisigSigs(script []byte) ([][]byte, error) {
+	_, threshold, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	pubKeys, err := txscript.PushedData(script)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	sigs := [][]byte{}
+	for _, pubKey := range pubKeys {
+		if len(sigs) == threshold {
+			break
+		}
+		if sig := input.partialSig(pubKey); sig != nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) < threshold {
+		return nil, errp.Newf("not enough signatures: %d of %d", len(sigs), threshold)
+	}
+	return sigs, nil
+}
+
+func pushScript(data ...[]byte) ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	for _, item := range data {
+		builder.AddData(item)
+	}
+	script, err := builder.Script()
+	return script, errp.WithStack(err)
+}
+
+// finalizeWitnessScript returns the witness spending a P2WSH multisig output.
+func (input *Input) finalizeWitnessScript() (wire.TxWitness, error) {
+	if input.WitnessScript == nil {
+		return nil, errp.New("witness script missing")
+	}
+	if txscript.GetScriptClass(input.WitnessScript) != txscript.MultiSigTy {
+		return nil, errp.New("only multisig witness scripts are supported")
+	}
+	sigs, err := input.multisigSigs(input.WitnessScript)
+	if err != nil {
+		return nil, err
+	}
+	// The empty item is consumed by the off-by-one bug of OP_CHECKMULTISIG.
+	witness := wire.TxWitness{nil}
+	witness = append(witness, sigs...)
+	return append(witness, input.WitnessScript), nil
+}
+
+// Finalize builds the final scriptSig and witness of the input at the given index from the partial
+// signatures. Supported are P2PKH, P2WPKH, P2WPKH-P2SH and multisig in P2SH, P2WSH and P2WSH-P2SH.
+func (packet *Packet) Finalize(index int) error {
+	if packet.IsFinalized(index) {
+		return nil
+	}
+	input := packet.Inputs[index]
+	prevOutput := packet.PrevOutput(index)
+	if prevOutput == nil {
+		return errp.Newf("input %d: missing utxo", index)
+	}
+	var scriptSig []byte
+	var witness wire.TxWitness
+	var err error
+	switch txscript.GetScriptClass(prevOutput.PkScript) {
+	case txscript.PubKeyHashTy:
+		var sig, pubKey []byte
+		sig, pubKey, err = input.singleSig()
+		if err == nil {
+			scriptSig, err = pushScript(sig, pubKey)
+		}
+	case txscript.WitnessV0PubKeyHashTy:
+		var sig, pubKey []byte
+		sig, pubKey, err = input.singleSig()
+		witness = wire.TxWitness{sig, pubKey}
+	case txscript.WitnessV0ScriptHashTy:
+		witness, err = input.finalizeWitnessScript()
+	case txscript.ScriptHashTy:
+		if input.RedeemScript == nil {
+			return errp.Newf("input %d: redeem script missing", index)
+		}
+		switch txscript.GetScriptClass(input.RedeemScript) {
+		case txscript.WitnessV0PubKeyHashTy:
+			var sig, pubKey []byte
+			sig, pubKey, err = input.singleSig()
+			witness = wire.TxWitness{sig, pubKey}
+		case txscript.WitnessV0ScriptHashTy:
+			witness, err = input.finalizeWitnessScript()
+		case txscript.MultiSigTy:
+			var sigs [][]byte
+			sigs, err = input.multisigSigs(input.RedeemScript)
+			if err == nil {
+				scriptSig, err = pushScript(append(sigs, input.RedeemScript)...)
+				// OP_0 for the off-by-one bug of OP_CHECKMULTISIG.
+				scriptSig = append([]byte{txscript.OP_0}, scriptSig...)
+			}
+		default:
+			return errp.Newf("input %d: unsupported redeem script", index)
+		}
+		if err == nil && witness != nil {
+			scriptSig, err = pushScript(input.RedeemScript)
+		}
+	default:
+		return errp.Newf("input %d: unsupported output script", index)
+	}
+	if err != nil {
+		return errp.WithMessage(err, fmt.Sprintf("input %d", index))
+	}
+	input.FinalScriptSig = scriptSig
+	input.FinalScriptWitness = witness
+	input.PartialSigs = nil
+	input.SighashType = 0
+	input.RedeemScript = nil
+	input.WitnessScript = nil
+	input.Bip32Derivation = nil
+	return nil
+}
+
+// FinalizeAll finalizes all inputs, see Finalize().
+func (packet *Packet) FinalizeAll() error {
+	for index := range packet.Inputs {
+		if err := packet.Finalize(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract returns the fully signed tx. All inputs must be finalized.
+func (packet *Packet) Extract() (*wire.MsgTx, error) {
+	if !packet.IsComplete() {
+		return nil, errp.New("the psbt is not finalized")
+	}
+	tx := packet.UnsignedTx.Copy()
+	for index, input := range packet.Inputs {
+		tx.TxIn[index].SignatureScript = input.FinalScriptSig
+		tx.TxIn[index].Witness = input.FinalScriptWitness
+	}
+	return tx, nil
+}
@@ -0,0 +1,586 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package psbt implements partially signed bitcoin transactions (BIP174).
+// See https://github.com/bitcoin/bips/blob/master/bip-0174.mediawiki.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// magic is the prefix of every serialized PSBT: "psbt" followed by 0xff.
+var magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// maxValueSize limits the size of keys and values when parsing, to avoid huge allocations.
+const maxValueSize = 4000000
+
+const (
+	globalUnsignedTx = 0x00
+
+	inputNonWitnessUtxo     = 0x00
+	inputWitnessUtxo        = 0x01
+	inputPartialSig         = 0x02
+	inputSighashType        = 0x03
+	inputRedeemScript       = 0x04
+	inputWitnessScript      = 0x05
+	inputBip32Derivation    = 0x06
+	inputFinalScriptSig     = 0x07
+	inputFinalScriptWitness = 0x08
+
+	outputRedeemScript    = 0x00
+	outputWitnessScript   = 0x01
+	outputBip32Derivation = 0x02
+)
+
+// Unknown is a key-value pair of a type not known to this implementation. It is kept as is when
+// combining and serializing.
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// PartialSig is a signature of one of the keys of an input.
+type PartialSig struct {
+	// PubKey is the serialized public key.
+	PubKey []byte
+	// Signature is the DER encoded signature, followed by the sighash type.
+	Signature []byte
+}
+
+// Bip32Derivation describes how a public key was derived.
+type Bip32Derivation struct {
+	// PubKey is the serialized public key.
+	PubKey []byte
+	// MasterKeyFingerprint is the first 4 bytes of the hash160 of the master public key, in little
+	// endian byte order. It is 0 if unknown.
+	MasterKeyFingerprint uint32
+	// Bip32Path is the derivation path from the master key.
+	Bip32Path []uint32
+}
+
+// Input contains the info needed to sign and finalize an input of the unsigned tx.
+type Input struct {
+	NonWitnessUtxo     *wire.MsgTx
+	WitnessUtxo        *wire.TxOut
+	PartialSigs        []*PartialSig
+	SighashType        uint32
+	RedeemScript       []byte
+	WitnessScript      []byte
+	Bip32Derivation    []*Bip32Derivation
+	FinalScriptSig     []byte
+	FinalScriptWitness wire.TxWitness
+	Unknowns           []*Unknown
+}
+
+// Output contains the info needed to identify an output of the unsigned tx, e.g. as change.
+type Output struct {
+	RedeemScript    []byte
+	WitnessScript   []byte
+	Bip32Derivation []*Bip32Derivation
+	Unknowns        []*Unknown
+}
+
+// Packet is a partially signed bitcoin transaction.
+type Packet struct {
+	UnsignedTx *wire.MsgTx
+	Inputs     []*Input
+	Outputs    []*Output
+	Unknowns   []*Unknown
+}
+
+// NewFromUnsignedTx creates a new packet from the unsigned tx. The signature scripts and witnesses
+// of the tx must be empty.
+func NewFromUnsignedTx(tx *wire.MsgTx) (*Packet, error) {
+	for _, txIn := range tx.TxIn {
+		if len(txIn.SignatureScript) != 0 || len(txIn.Witness) != 0 {
+			return nil, errp.New("the transaction must be unsigned")
+		}
+	}
+	packet := &Packet{
+		UnsignedTx: tx.Copy(),
+		Inputs:     make([]*Input, len(tx.TxIn)),
+		Outputs:    make([]*Output, len(tx.TxOut)),
+	}
+	for i := range packet.Inputs {
+		packet.Inputs[i] = &Input{}
+	}
+	for i := range packet.Outputs {
+		packet.Outputs[i] = &Output{}
+	}
+	return packet, nil
+}
+
+// PrevOutput returns the output spent by the input at the given index, or nil if the input
+// contains no utxo info.
+func (packet *Packet) PrevOutput(index int) *wire.TxOut {
+	input := packet.Inputs[index]
+	if input.WitnessUtxo != nil {
+		return input.WitnessUtxo
+	}
+	if input.NonWitnessUtxo != nil {
+		prevIndex := packet.UnsignedTx.TxIn[index].PreviousOutPoint.Index
+		if int(prevIndex) < len(input.NonWitnessUtxo.TxOut) {
+			return input.NonWitnessUtxo.TxOut[prevIndex]
+		}
+	}
+	return nil
+}
+
+func writeKeyValue(writer io.Writer, key []byte, value []byte) error {
+	if err := wire.WriteVarBytes(writer, 0, key); err != nil {
+		return errp.WithStack(err)
+	}
+	return errp.WithStack(wire.WriteVarBytes(writer, 0, value))
+}
+
+func serializeTx(tx *wire.MsgTx) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tx.SerializeNoWitness(&buf); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func serializeTxOut(txOut *wire.TxOut) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, txOut.Value); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := wire.WriteVarBytes(&buf, 0, txOut.PkScript); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func serializeWitness(witness wire.TxWitness) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := wire.WriteVarInt(&buf, 0, uint64(len(witness))); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(&buf, 0, item); err != nil {
+			return nil, errp.WithStack(err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func serializeBip32Path(derivation *Bip32Derivation) []byte {
+	value := make([]byte, 4*(1+len(derivation.Bip32Path)))
+	binary.LittleEndian.PutUint32(value, derivation.MasterKeyFingerprint)
+	for i, element := range derivation.Bip32Path {
+		binary.LittleEndian.PutUint32(value[4*(i+1):], element)
+	}
+	return value
+}
+
+func writeBip32Derivations(writer io.Writer, keyType byte, derivations []*Bip32Derivation) error {
+	sorted := append([]*Bip32Derivation{}, derivations...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].PubKey, sorted[j].PubKey) < 0 })
+	for _, derivation := range sorted {
+		if err := writeKeyValue(writer, append([]byte{keyType}, derivation.PubKey...), serializeBip32Path(derivation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeUnknowns(writer io.Writer, unknowns []*Unknown) error {
+	for _, unknown := range unknowns {
+		if err := writeKeyValue(writer, unknown.Key, unknown.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (input *Input) serialize(writer io.Writer) error {
+	if input.NonWitnessUtxo != nil {
+		var value bytes.Buffer
+		if err := input.NonWitnessUtxo.Serialize(&value); err != nil {
+			return errp.WithStack(err)
+		}
+		if err := writeKeyValue(writer, []byte{inputNonWitnessUtxo}, value.Bytes()); err != nil {
+			return err
+		}
+	}
+	if input.WitnessUtxo != nil {
+		value, err := serializeTxOut(input.WitnessUtxo)
+		if err != nil {
+			return err
+		}
+		if err := writeKeyValue(writer, []byte{inputWitnessUtxo}, value); err != nil {
+			return err
+		}
+	}
+	if input.FinalScriptSig == nil && input.FinalScriptWitness == nil {
+		partialSigs := append([]*PartialSig{}, input.PartialSigs...)
+		sort.Slice(partialSigs, func(i, j int) bool { return bytes.Compare(partialSigs[i].PubKey, partialSigs[j].PubKey) < 0 })
+		for _, partialSig := range partialSigs {
+			if err := writeKeyValue(writer, append([]byte{inputPartialSig}, partialSig.PubKey...), partialSig.Signature); err != nil {
+				return err
+			}
+		}
+		if input.SighashType != 0 {
+			value := make([]byte, 4)
+			binary.LittleEndian.PutUint32(value, input.SighashType)
+			if err := writeKeyValue(writer, []byte{inputSighashType}, value); err != nil {
+				return err
+			}
+		}
+		if input.RedeemScript != nil {
+			if err := writeKeyValue(writer, []byte{inputRedeemScript}, input.RedeemScript); err != nil {
+				return err
+			}
+		}
+		if input.WitnessScript != nil {
+			if err := writeKeyValue(writer, []byte{inputWitnessScript}, input.WitnessScript); err != nil {
+				return err
+			}
+		}
+		if err := writeBip32Derivations(writer, inputBip32Derivation, input.Bip32Derivation); err != nil {
+			return err
+		}
+	}
+	if input.FinalScriptSig != nil {
+		if err := writeKeyValue(writer, []byte{inputFinalScriptSig}, input.FinalScriptSig); err != nil {
+			return err
+		}
+	}
+	if input.FinalScriptWitness != nil {
+		value, err := serializeWitness(input.FinalScriptWitness)
+		if err != nil {
+			return err
+		}
+		if err := writeKeyValue(writer, []byte{inputFinalScriptWitness}, value); err != nil {
+			return err
+		}
+	}
+	return writeUnknowns(writer, input.Unknowns)
+}
+
+func (output *Output) serialize(writer io.Writer) error {
+	if output.RedeemScript != nil {
+		if err := writeKeyValue(writer, []byte{outputRedeemScript}, output.RedeemScript); err != nil {
+			return err
+		}
+	}
+	if output.WitnessScript != nil {
+		if err := writeKeyValue(writer, []byte{outputWitnessScript}, output.WitnessScript); err != nil {
+			return err
+		}
+	}
+	if err := writeBip32Derivations(writer, outputBip32Derivation, output.Bip32Derivation); err != nil {
+		return err
+	}
+	return writeUnknowns(writer, output.Unknowns)
+}
+
+// Serialize encodes the packet in the binary format.
+func (packet *Packet) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	unsignedTx, err := serializeTx(packet.UnsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyValue(&buf, []byte{globalUnsignedTx}, unsignedTx); err != nil {
+		return nil, err
+	}
+	if err := writeUnknowns(&buf, packet.Unknowns); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(0x00)
+	for _, input := range packet.Inputs {
+		if err := input.serialize(&buf); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(0x00)
+	}
+	for _, output := range packet.Outputs {
+		if err := output.serialize(&buf); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(0x00)
+	}
+	return buf.Bytes(), nil
+}
+
+// B64Encode encodes the packet in the base64 format, which is the usual format to exchange PSBTs
+// as text.
+func (packet *Packet) B64Encode() (string, error) {
+	serialized, err := packet.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(serialized), nil
+}
+
+// readKeyValue reads a key-value pair. A nil key is returned at the end of a map (separator).
+func readKeyValue(reader io.Reader) ([]byte, []byte, error) {
+	key, err := wire.ReadVarBytes(reader, 0, maxValueSize, "key")
+	if err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	if len(key) == 0 {
+		return nil, nil, nil
+	}
+	value, err := wire.ReadVarBytes(reader, 0, maxValueSize, "value")
+	if err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	return key, value, nil
+}
+
+// readMap reads all key-value pairs of a map until the separator and calls handle for each of
+// them. Duplicate keys are rejected.
+func readMap(reader io.Reader, handle func(key []byte, value []byte) error) error {
+	seen := map[string]struct{}{}
+	for {
+		key, value, err := readKeyValue(reader)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		if _, ok := seen[string(key)]; ok {
+			return errp.Newf("duplicate key %x", key)
+		}
+		seen[string(key)] = struct{}{}
+		if err := handle(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+func parseTx(value []byte) (*wire.MsgTx, error) {
+	tx := &wire.MsgTx{}
+	if err := tx.DeserializeNoWitness(bytes.NewReader(value)); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return tx, nil
+}
+
+func parseBip32Derivation(key []byte, value []byte) (*Bip32Derivation, error) {
+	if len(key) != 1+33 && len(key) != 1+65 {
+		return nil, errp.New("invalid public key in bip32 derivation")
+	}
+	if len(value) == 0 || len(value)%4 != 0 {
+		return nil, errp.New("invalid bip32 derivation")
+	}
+	derivation := &Bip32Derivation{
+		PubKey:               key[1:],
+		MasterKeyFingerprint: binary.LittleEndian.Uint32(value),
+		Bip32Path:            make([]uint32, len(value)/4-1),
+	}
+	for i := range derivation.Bip32Path {
+		derivation.Bip32Path[i] = binary.LittleEndian.Uint32(value[4*(i+1):])
+	}
+	return derivation, nil
+}
+
+func requireKeyLen(key []byte, length int) error {
+	if len(key) != length {
+		return errp.Newf("invalid key length for type %d", key[0])
+	}
+	return nil
+}
+
+func (input *Input) parse(reader io.Reader) error {
+	return readMap(reader, func(key []byte, value []byte) error {
+		var err error
+		switch key[0] {
+		case inputNonWitnessUtxo:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			tx := &wire.MsgTx{}
+			if err := tx.Deserialize(bytes.NewReader(value)); err != nil {
+				return errp.WithStack(err)
+			}
+			input.NonWitnessUtxo = tx
+		case inputWitnessUtxo:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			valueReader := bytes.NewReader(value)
+			txOut := &wire.TxOut{}
+			if err := binary.Read(valueReader, binary.LittleEndian, &txOut.Value); err != nil {
+				return errp.WithStack(err)
+			}
+			txOut.PkScript, err = wire.ReadVarBytes(valueReader, 0, maxValueSize, "pkScript")
+			if err != nil {
+				return errp.WithStack(err)
+			}
+			input.WitnessUtxo = txOut
+		case inputPartialSig:
+			if len(key) != 1+33 && len(key) != 1+65 {
+				return errp.New("invalid public key in partial signature")
+			}
+			input.PartialSigs = append(input.PartialSigs, &PartialSig{PubKey: key[1:], Signature: value})
+		case inputSighashType:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			if len(value) != 4 {
+				return errp.New("invalid sighash type")
+			}
+			input.SighashType = binary.LittleEndian.Uint32(value)
+		case inputRedeemScript:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			input.RedeemScript = value
+		case inputWitnessScript:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			input.WitnessScript = value
+		case inputBip32Derivation:
+			derivation, err := parseBip32Derivation(key, value)
+			if err != nil {
+				return err
+			}
+			input.Bip32Derivation = append(input.Bip32Derivation, derivation)
+		case inputFinalScriptSig:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			input.FinalScriptSig = value
+		case inputFinalScriptWitness:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			valueReader := bytes.NewReader(value)
+			count, err := wire.ReadVarInt(valueReader, 0)
+			if err != nil {
+				return errp.WithStack(err)
+			}
+			if count > uint64(len(value)) {
+				return errp.New("invalid witness")
+			}
+			input.FinalScriptWitness = make(wire.TxWitness, count)
+			for i := range input.FinalScriptWitness {
+				input.FinalScriptWitness[i], err = wire.ReadVarBytes(valueReader, 0, maxValueSize, "witness")
+				if err != nil {
+					return errp.WithStack(err)
+				}
+			}
+		default:
+			input.Unknowns = append(input.Unknowns, &Unknown{Key: key, Value: value})
+		}
+		return nil
+	})
+}
+
+func (output *Output) parse(reader io.Reader) error {
+	return readMap(reader, func(key []byte, value []byte) error {
+		switch key[0] {
+		case outputRedeemScript:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			output.RedeemScript = value
+		case outputWitnessScript:
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			output.WitnessScript = value
+		case outputBip32Derivation:
+			derivation, err := parseBip32Derivation(key, value)
+			if err != nil {
+				return err
+			}
+			output.Bip32Derivation = append(output.Bip32Derivation, derivation)
+		default:
+			output.Unknowns = append(output.Unknowns, &Unknown{Key: key, Value: value})
+		}
+		return nil
+	})
+}
+
+// Parse decodes a packet in the binary format.
+func Parse(serialized []byte) (*Packet, error) {
+	if !bytes.HasPrefix(serialized, magic) {
+		return nil, errp.New("invalid psbt magic bytes")
+	}
+	reader := bytes.NewReader(serialized[len(magic):])
+	packet := &Packet{}
+	err := readMap(reader, func(key []byte, value []byte) error {
+		if key[0] == globalUnsignedTx {
+			if err := requireKeyLen(key, 1); err != nil {
+				return err
+			}
+			tx, err := parseTx(value)
+			if err != nil {
+				return err
+			}
+			packet.UnsignedTx = tx
+			return nil
+		}
+		packet.Unknowns = append(packet.Unknowns, &Unknown{Key: key, Value: value})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if packet.UnsignedTx == nil {
+		return nil, errp.New("psbt is missing the unsigned transaction")
+	}
+	for _, txIn := range packet.UnsignedTx.TxIn {
+		if len(txIn.SignatureScript) != 0 || len(txIn.Witness) != 0 {
+			return nil, errp.New("the transaction in the psbt must be unsigned")
+		}
+	}
+	packet.Inputs = make([]*Input, len(packet.UnsignedTx.TxIn))
+	for i := range packet.Inputs {
+		packet.Inputs[i] = &Input{}
+		if err := packet.Inputs[i].parse(reader); err != nil {
+			return nil, err
+		}
+		nonWitnessUtxo := packet.Inputs[i].NonWitnessUtxo
+		if nonWitnessUtxo != nil && nonWitnessUtxo.TxHash() != packet.UnsignedTx.TxIn[i].PreviousOutPoint.Hash {
+			return nil, errp.New("non-witness utxo does not match the input")
+		}
+	}
+	packet.Outputs = make([]*Output, len(packet.UnsignedTx.TxOut))
+	for i := range packet.Outputs {
+		packet.Outputs[i] = &Output{}
+		if err := packet.Outputs[i].parse(reader); err != nil {
+			return nil, err
+		}
+	}
+	if reader.Len() != 0 {
+		return nil, errp.New("unexpected data after the psbt")
+	}
+	return packet, nil
+}
+
+// NewFromBase64 decodes a packet in the base64 format.
+func NewFromBase64(encoded string) (*Packet, error) {
+	serialized, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return Parse(serialized)
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psbt_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/stretchr/testify/require"
+)
+
+var net = &chaincfg.TestNet3Params
+
+func privateKey(seed byte) *btcec.PrivateKey {
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), chainhash.DoubleHashB([]byte{seed}))
+	return key
+}
+
+func pubKey(key *btcec.PrivateKey) []byte {
+	return key.PubKey().SerializeCompressed()
+}
+
+func multisigScript(t *testing.T, threshold int, keys ...*btcec.PrivateKey) []byte {
+	addresses := make([]*btcutil.AddressPubKey, len(keys))
+	for i, key := range keys {
+		address, err := btcutil.NewAddressPubKey(pubKey(key), net)
+		require.NoError(t, err)
+		addresses[i] = address
+	}
+	script, err := txscript.MultiSigScript(addresses, threshold)
+	require.NoError(t, err)
+	return script
+}
+
+func payToAddress(t *testing.T, address btcutil.Address) []byte {
+	script, err := txscript.PayToAddrScript(address)
+	require.NoError(t, err)
+	return script
+}
+
+// testPacket contains a packet spending a P2WPKH, a P2SH 2-of-2 multisig and a P2WSH 2-of-3 multisig
+// output.
+type testPacket struct {
+	packet        *psbt.Packet
+	keys          []*btcec.PrivateKey
+	prevTx        *wire.MsgTx
+	redeemScript  []byte
+	witnessScript []byte
+}
+
+func newTestPacket(t *testing.T) *testPacket {
+	keys := []*btcec.PrivateKey{privateKey(0), privateKey(1), privateKey(2)}
+	redeemScript := multisigScript(t, 2, keys[0], keys[1])
+	witnessScript := multisigScript(t, 2, keys[0], keys[1], keys[2])
+	witnessScriptHash := sha256.Sum256(witnessScript)
+
+	p2wpkhAddress, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey(keys[0])), net)
+	require.NoError(t, err)
+	p2shAddress, err := btcutil.NewAddressScriptHash(redeemScript, net)
+	require.NoError(t, err)
+	p2wshAddress, err := btcutil.NewAddressWitnessScriptHash(witnessScriptHash[:], net)
+	require.NoError(t, err)
+
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 7}, []byte{txscript.OP_TRUE}, nil))
+	prevTx.AddTxOut(wire.NewTxOut(1e8, payToAddress(t, p2wpkhAddress)))
+	prevTx.AddTxOut(wire.NewTxOut(2e8, payToAddress(t, p2shAddress)))
+	prevTx.AddTxOut(wire.NewTxOut(3e8, payToAddress(t, p2wshAddress)))
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	prevTxHash := prevTx.TxHash()
+	for index := range prevTx.TxOut {
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevTxHash, uint32(index)), nil, nil))
+	}
+	tx.AddTxOut(wire.NewTxOut(5e8, prevTx.TxOut[0].PkScript))
+
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = prevTx.TxOut[0]
+	packet.Inputs[0].Bip32Derivation = []*psbt.Bip32Derivation{{
+		PubKey:               pubKey(keys[0]),
+		MasterKeyFingerprint: 0x01020304,
+		Bip32Path:            []uint32{84 + 0x80000000, 1 + 0x80000000, 0x80000000, 0, 5},
+	}}
+	packet.Inputs[1].NonWitnessUtxo = prevTx
+	packet.Inputs[1].RedeemScript = redeemScript
+	packet.Inputs[2].WitnessUtxo = prevTx.TxOut[2]
+	packet.Inputs[2].WitnessScript = witnessScript
+	packet.Outputs[0].Bip32Derivation = packet.Inputs[0].Bip32Derivation
+	return &testPacket{
+		packet:        packet,
+		keys:          keys,
+		prevTx:        prevTx,
+		redeemScript:  redeemScript,
+		witnessScript: witnessScript,
+	}
+}
+
+// sign adds the signatures of the given key to all inputs it can sign.
+func (test *testPacket) sign(t *testing.T, packet *psbt.Packet, key *btcec.PrivateKey) {
+	tx := packet.UnsignedTx
+	sigHashes := txscript.NewTxSigHashes(tx)
+	addSig := func(index int, sig []byte, err error) {
+		require.NoError(t, err)
+		packet.Inputs[index].PartialSigs = append(packet.Inputs[index].PartialSigs,
+			&psbt.PartialSig{PubKey: pubKey(key), Signature: sig})
+	}
+	if key == test.keys[0] {
+		sig, err := txscript.RawTxInWitnessSignature(
+			tx, sigHashes, 0, test.prevTx.TxOut[0].Value, test.prevTx.TxOut[0].PkScript,
+			txscript.SigHashAll, key)
+		addSig(0, sig, err)
+	}
+	if key != test.keys[2] {
+		sig, err := txscript.RawTxInSignature(tx, 1, test.redeemScript, txscript.SigHashAll, key)
+		addSig(1, sig, err)
+	}
+	sig, err := txscript.RawTxInWitnessSignature(
+		tx, sigHashes, 2, test.prevTx.TxOut[2].Value, test.witnessScript, txscript.SigHashAll, key)
+	addSig(2, sig, err)
+}
+
+func copyPacket(t *testing.T, packet *psbt.Packet) *psbt.Packet {
+	serialized, err := packet.Serialize()
+	require.NoError(t, err)
+	parsed, err := psbt.Parse(serialized)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestSerialize(t *testing.T) {
+	test := newTestPacket(t)
+	test.packet.Unknowns = []*psbt.Unknown{{Key: []byte{0xfc, 0x01}, Value: []byte{0x02}}}
+
+	encoded, err := test.packet.B64Encode()
+	require.NoError(t, err)
+	require.Equal(t, "cHNidP8B", encoded[:8])
+	decoded, err := psbt.NewFromBase64(encoded)
+	require.NoError(t, err)
+	require.Equal(t, test.packet.UnsignedTx.TxHash(), decoded.UnsignedTx.TxHash())
+	require.Equal(t, test.packet.Inputs[0].Bip32Derivation, decoded.Inputs[0].Bip32Derivation)
+	require.Equal(t, test.packet.Inputs[0].WitnessUtxo, decoded.Inputs[0].WitnessUtxo)
+	require.Equal(t, test.prevTx.TxHash(), decoded.Inputs[1].NonWitnessUtxo.TxHash())
+	require.Equal(t, test.redeemScript, decoded.Inputs[1].RedeemScript)
+	require.Equal(t, test.witnessScript, decoded.Inputs[2].WitnessScript)
+	require.Equal(t, test.packet.Outputs[0].Bip32Derivation, decoded.Outputs[0].Bip32Derivation)
+	require.Equal(t, test.packet.Unknowns, decoded.Unknowns)
+
+	reencoded, err := decoded.B64Encode()
+	require.NoError(t, err)
+	require.Equal(t, encoded, reencoded)
+}
+
+func TestParseInvalid(t *testing.T) {
+	test := newTestPacket(t)
+	serialized, err := test.packet.Serialize()
+	require.NoError(t, err)
+
+	_, err = psbt.Parse(serialized[1:])
+	require.Error(t, err)
+	_, err = psbt.Parse(serialized[:len(serialized)-1])
+	require.Error(t, err)
+	_, err = psbt.Parse(append(serialized, 0x00))
+	require.Error(t, err)
+
+	// The non-witness utxo must be the tx spent by the input.
+	test.packet.Inputs[0].NonWitnessUtxo = test.packet.UnsignedTx
+	serialized, err = test.packet.Serialize()
+	require.NoError(t, err)
+	_, err = psbt.Parse(serialized)
+	require.Error(t, err)
+}
+
+func TestCombineFinalizeExtract(t *testing.T) {
+	test := newTestPacket(t)
+	packet0 := copyPacket(t, test.packet)
+	test.sign(t, packet0, test.keys[0])
+	packet1 := copyPacket(t, test.packet)
+	test.sign(t, packet1, test.keys[1])
+
+	// Not enough signatures for the multisig inputs.
+	require.Error(t, copyPacket(t, packet0).FinalizeAll())
+
+	otherTx := copyPacket(t, test.packet)
+	otherTx.UnsignedTx.LockTime = 1
+	_, err := psbt.Combine(packet0, otherTx)
+	require.Error(t, err)
+
+	combined, err := psbt.Combine(packet0, packet1)
+	require.NoError(t, err)
+	require.Len(t, combined.Inputs[0].PartialSigs, 1)
+	require.Len(t, combined.Inputs[1].PartialSigs, 2)
+	require.Len(t, combined.Inputs[2].PartialSigs, 2)
+	// The inputs were not modified.
+	require.Len(t, packet0.Inputs[1].PartialSigs, 1)
+
+	require.False(t, combined.IsComplete())
+	_, err = combined.Extract()
+	require.Error(t, err)
+	require.NoError(t, combined.FinalizeAll())
+	require.True(t, combined.IsComplete())
+	require.Nil(t, combined.Inputs[1].PartialSigs)
+	require.Nil(t, combined.Inputs[1].RedeemScript)
+	// Finalized packets survive serialization.
+	combined = copyPacket(t, combined)
+	require.True(t, combined.IsComplete())
+
+	tx, err := combined.Extract()
+	require.NoError(t, err)
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for index, txIn := range tx.TxIn {
+		prevOut := test.prevTx.TxOut[txIn.PreviousOutPoint.Index]
+		engine, err := txscript.NewEngine(
+			prevOut.PkScript, tx, index, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value)
+		require.NoError(t, err)
+		require.NoError(t, engine.Execute(), "input %d", index)
+	}
+}
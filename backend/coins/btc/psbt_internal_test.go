@@ -0,0 +1,78 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package btc
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPSBTPreviousOutputs(t *testing.T) {
+	outPoint := func(index uint32) wire.OutPoint {
+		return wire.OutPoint{Hash: chainhash.HashH([]byte("tx")), Index: index}
+	}
+	accountOutput := wire.NewTxOut(1000, []byte{0x00, 0x14, 0x01})
+	foreignOutput := wire.NewTxOut(2000, []byte{0x00, 0x14, 0x02})
+	newPacket := func(prevOutputs ...*wire.TxOut) (*psbt.Packet, *wire.MsgTx) {
+		tx := wire.NewMsgTx(2)
+		for index := range prevOutputs {
+			previousOutPoint := outPoint(uint32(index))
+			tx.AddTxIn(wire.NewTxIn(&previousOutPoint, nil, nil))
+		}
+		tx.AddTxOut(wire.NewTxOut(500, []byte{0x00, 0x14, 0x03}))
+		packet, err := psbt.NewFromUnsignedTx(tx)
+		require.NoError(t, err)
+		for index, prevOutput := range prevOutputs {
+			packet.Inputs[index].WitnessUtxo = prevOutput
+		}
+		return packet, tx
+	}
+	spendableOutputs := map[wire.OutPoint]*transactions.SpendableOutput{
+		outPoint(0): {TxOut: accountOutput},
+	}
+
+	// Foreign inputs are allowed next to the ones of the account, e.g. in a coinjoin.
+	packet, tx := newPacket(accountOutput, foreignOutput)
+	previousOutputs, err := psbtPreviousOutputs(packet, tx, spendableOutputs)
+	require.NoError(t, err)
+	require.Len(t, previousOutputs, 2)
+	require.Equal(t, foreignOutput, previousOutputs[outPoint(1)].TxOut)
+
+	// The account outputs are checked.
+	packet, tx = newPacket(wire.NewTxOut(1001, accountOutput.PkScript))
+	_, err = psbtPreviousOutputs(packet, tx, spendableOutputs)
+	require.Error(t, err)
+
+	// PSBTs not spending any outputs of the account are rejected.
+	_, err = psbtPreviousOutputs(packet, tx, map[wire.OutPoint]*transactions.SpendableOutput{})
+	require.Equal(t, ErrPSBTForeign, errp.Cause(err))
+	packet, tx = newPacket(accountOutput)
+	_, err = psbtPreviousOutputs(packet, tx, map[wire.OutPoint]*transactions.SpendableOutput{
+		outPoint(1): {TxOut: accountOutput},
+	})
+	require.Equal(t, ErrPSBTForeign, errp.Cause(err))
+
+	// The spent outputs are required.
+	packet, tx = newPacket(nil)
+	_, err = psbtPreviousOutputs(packet, tx, spendableOutputs)
+	require.Error(t, err)
+}
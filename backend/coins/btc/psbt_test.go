@@ -0,0 +1,157 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/psbt"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPSBT returns the PSBT of a transaction of the account with the given configuration,
+// spending the receive address 3 and sending the change to the change address 2.
+func newTestPSBT(t *testing.T, coin *btc.Coin, configuration *signing.Configuration) *psbt.Packet {
+	t.Helper()
+	log := logging.Get().WithGroup("psbt_test")
+	net := coin.Net()
+	receiveAddresses := addresses.NewAddressChain(configuration, net, 20, 0, log).EnsureAddresses()
+	changeAddresses := addresses.NewAddressChain(configuration, net, 20, 1, log).EnsureAddresses()
+	getAddress := func(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
+		for _, address := range append(receiveAddresses, changeAddresses...) {
+			if address.PubkeyScriptHashHex() == scriptHashHex {
+				return address
+			}
+		}
+		return nil
+	}
+	outPoint := wire.OutPoint{Hash: chainhash.HashH([]byte("funding-tx")), Index: 0}
+	txOut := wire.NewTxOut(100000, receiveAddresses[3].PubkeyScript())
+	txProposal, err := maketx.NewTx(
+		coin,
+		configuration,
+		map[wire.OutPoint]*wire.TxOut{outPoint: txOut},
+		[]*wire.TxOut{wire.NewTxOut(50000, receiveAddresses[5].PubkeyScript())},
+		1000,
+		1000,
+		maketx.CoinSelectionLargestFirst,
+		func() *addresses.AccountAddress { return changeAddresses[2] },
+		log,
+	)
+	require.NoError(t, err)
+	proposedTransaction := &btc.ProposedTransaction{
+		TXProposal: txProposal,
+		PreviousOutputs: map[wire.OutPoint]*transactions.SpendableOutput{
+			outPoint: {TxOut: txOut},
+		},
+		GetAddress: getAddress,
+	}
+	packet, err := proposedTransaction.PSBT(func(chainhash.Hash) *wire.MsgTx { return nil })
+	require.NoError(t, err)
+	return packet
+}
+
+// TestPSBTBip32Derivations checks that the inputs and the change output of a PSBT contain the root
+// fingerprint and the full keypath of the keystore.
+func TestPSBTBip32Derivations(t *testing.T) {
+	net := &chaincfg.RegressionNetParams
+	coin := btc.NewCoin("rbtc", "RBTC", net, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+
+	softwareKeystore := software.NewKeystoreFromPIN(0, "1234")
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/1'/0'")
+	require.NoError(t, err)
+	configuration, err := keystore.NewKeystores(softwareKeystore).Configuration(
+		coin, signing.ScriptTypeP2WPKH, keypath, 1)
+	require.NoError(t, err)
+	rootFingerprint, err := softwareKeystore.RootFingerprint()
+	require.NoError(t, err)
+	require.Equal(t, rootFingerprint, configuration.KeyOrigins()[0].RootFingerprint)
+
+	packet := newTestPSBT(t, coin, configuration)
+	expectedFingerprint := binary.LittleEndian.Uint32(rootFingerprint)
+	require.Len(t, packet.Inputs[0].Bip32Derivation, 1)
+	require.Equal(t, expectedFingerprint, packet.Inputs[0].Bip32Derivation[0].MasterKeyFingerprint)
+	require.Equal(t,
+		keypath.Child(0, false).Child(3, false).ToUInt32(),
+		packet.Inputs[0].Bip32Derivation[0].Bip32Path)
+	derivationsFound := 0
+	for _, output := range packet.Outputs {
+		for _, derivation := range output.Bip32Derivation {
+			require.Equal(t, expectedFingerprint, derivation.MasterKeyFingerprint)
+			require.Equal(t, keypath.Child(1, false).Child(2, false).ToUInt32(), derivation.Bip32Path)
+			derivationsFound++
+		}
+	}
+	require.Equal(t, 1, derivationsFound)
+}
+
+// TestPSBTMultisigBip32Derivations checks that the derivations of the external cosigners use their
+// own root fingerprint and keypath.
+func TestPSBTMultisigBip32Derivations(t *testing.T) {
+	net := &chaincfg.RegressionNetParams
+	coin := btc.NewCoin("rbtc", "RBTC", net, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+	keypath, err := signing.NewAbsoluteKeypath("m/48'/1'/0'/2'")
+	require.NoError(t, err)
+	localKeystore := software.NewKeystoreFromPIN(0, "1234")
+	localConfiguration, err := keystore.NewKeystores(localKeystore).Configuration(
+		coin, signing.ScriptTypeP2WSH, keypath, 1)
+	require.NoError(t, err)
+
+	// The cosigner uses a different keypath.
+	cosignerKeystore := software.NewKeystoreFromPIN(1, "5678")
+	cosignerKeypath, err := signing.NewAbsoluteKeypath("m/48'/1'/5'/2'")
+	require.NoError(t, err)
+	cosignerKey, err := cosignerKeystore.ExtendedPublicKey(coin, cosignerKeypath)
+	require.NoError(t, err)
+	cosignerFingerprint, err := cosignerKeystore.RootFingerprint()
+	require.NoError(t, err)
+
+	configuration := signing.NewConfiguration(
+		signing.ScriptTypeP2WSH, keypath,
+		append(localConfiguration.ExtendedPublicKeys(), cosignerKey), "", 2,
+	).WithKeyOrigins(append(localConfiguration.KeyOrigins(), &signing.KeyOrigin{
+		RootFingerprint: cosignerFingerprint,
+		Keypath:         cosignerKeypath,
+	}))
+
+	packet := newTestPSBT(t, coin, configuration)
+	localFingerprint, err := localKeystore.RootFingerprint()
+	require.NoError(t, err)
+	expectedPaths := map[uint32][]uint32{
+		binary.LittleEndian.Uint32(localFingerprint):    keypath.Child(0, false).Child(3, false).ToUInt32(),
+		binary.LittleEndian.Uint32(cosignerFingerprint): cosignerKeypath.Child(0, false).Child(3, false).ToUInt32(),
+	}
+	require.Len(t, packet.Inputs[0].Bip32Derivation, 2)
+	for _, derivation := range packet.Inputs[0].Bip32Derivation {
+		require.Equal(t, expectedPaths[derivation.MasterKeyFingerprint], derivation.Bip32Path)
+	}
+}
@@ -15,6 +15,8 @@
 package btc
 
 import (
+	"errors"
+
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
@@ -22,12 +24,18 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrNotEnoughSignatures is returned if the local keystores can't provide the number of signatures
+// required by a multisig account. The transaction has to be exported as a PSBT and signed by the
+// other cosigners.
+var ErrNotEnoughSignatures = errors.New("not enough signatures")
+
 // ProposedTransaction contains all the info needed to sign a btc transaction.
 type ProposedTransaction struct {
 	TXProposal      *maketx.TxProposal
@@ -35,7 +43,34 @@ type ProposedTransaction struct {
 	GetAddress      func(blockchain.ScriptHashHex) *addresses.AccountAddress
 	// Signatures collects the signatures (signatures[transactionInput][cosignerIndex]).
 	Signatures [][]*btcec.Signature
-	SigHashes  *txscript.TxSigHashes
+	// TaprootSignatures collects the BIP340 Schnorr signatures of taproot inputs
+	// (taprootSignatures[transactionInput]). It is nil for all other inputs.
+	TaprootSignatures [][]byte
+	SigHashes         *txscript.TxSigHashes
+}
+
+// TaprootSigHash returns the BIP341 signature hash to be signed for the taproot input at the given
+// index.
+func (proposedTransaction *ProposedTransaction) TaprootSigHash(inputIndex int) ([]byte, error) {
+	return taproot.SigHash(
+		proposedTransaction.TXProposal.Transaction,
+		inputIndex,
+		spentOutputs(proposedTransaction.TXProposal.Transaction, proposedTransaction.PreviousOutputs),
+	)
+}
+
+// spentOutputs returns the outputs spent by the transaction, in the order of the inputs.
+func spentOutputs(
+	transaction *wire.MsgTx,
+	previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
+) []*wire.TxOut {
+	result := make([]*wire.TxOut, len(transaction.TxIn))
+	for index, txIn := range transaction.TxIn {
+		if spentOutput, ok := previousOutputs[txIn.PreviousOutPoint]; ok {
+			result[index] = spentOutput.TxOut
+		}
+	}
+	return result
 }
 
 // SignTransaction signs all inputs. It assumes all outputs spent belong to this
@@ -47,26 +82,30 @@ func SignTransaction(
 	getAddress func(blockchain.ScriptHashHex) *addresses.AccountAddress,
 	log *logrus.Entry,
 ) error {
-	proposedTransaction := &ProposedTransaction{
-		TXProposal:      txProposal,
-		PreviousOutputs: previousOutputs,
-		GetAddress:      getAddress,
-		Signatures:      make([][]*btcec.Signature, len(txProposal.Transaction.TxIn)),
-		SigHashes:       txscript.NewTxSigHashes(txProposal.Transaction),
-	}
-
-	for i := range proposedTransaction.Signatures {
-		// TODO: Replace count with configuration.NumberOfSigners()
-		proposedTransaction.Signatures[i] = make([]*btcec.Signature, keystores.Count())
-	}
-
-	if err := keystores.SignTransaction(proposedTransaction); err != nil {
+	proposedTransaction, err := signWithKeystores(keystores, txProposal, previousOutputs, getAddress)
+	if err != nil {
 		return err
 	}
 
 	for index, input := range txProposal.Transaction.TxIn {
 		spentOutput := previousOutputs[input.PreviousOutPoint]
 		address := proposedTransaction.GetAddress(spentOutput.ScriptHashHex())
+		if address.Configuration.Multisig() {
+			numSignatures := 0
+			for _, signature := range proposedTransaction.Signatures[index] {
+				if signature != nil {
+					numSignatures++
+				}
+			}
+			if numSignatures < address.Configuration.SigningThreshold() {
+				return errp.WithStack(ErrNotEnoughSignatures)
+			}
+		}
+		if address.IsTaproot() {
+			input.SignatureScript = []byte{}
+			input.Witness = address.TaprootWitness(proposedTransaction.TaprootSignatures[index])
+			continue
+		}
 		input.SignatureScript, input.Witness = address.SignatureScript(
 			proposedTransaction.Signatures[index])
 	}
@@ -80,16 +119,65 @@ func SignTransaction(
 	return nil
 }
 
+// signWithKeystores collects the signatures of all keystores. Multisig inputs can end up with fewer
+// signatures than required if some of the cosigners are not available locally.
+func signWithKeystores(
+	keystores *keystore.Keystores,
+	txProposal *maketx.TxProposal,
+	previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
+	getAddress func(blockchain.ScriptHashHex) *addresses.AccountAddress,
+) (*ProposedTransaction, error) {
+	proposedTransaction := &ProposedTransaction{
+		TXProposal:        txProposal,
+		PreviousOutputs:   previousOutputs,
+		GetAddress:        getAddress,
+		Signatures:        make([][]*btcec.Signature, len(txProposal.Transaction.TxIn)),
+		TaprootSignatures: make([][]byte, len(txProposal.Transaction.TxIn)),
+		SigHashes:         txscript.NewTxSigHashes(txProposal.Transaction),
+	}
+
+	for i := range proposedTransaction.Signatures {
+		proposedTransaction.Signatures[i] = make(
+			[]*btcec.Signature, txProposal.AccountConfiguration.NumberOfSigners())
+	}
+
+	if err := keystores.SignTransaction(proposedTransaction); err != nil {
+		return nil, err
+	}
+	return proposedTransaction, nil
+}
+
 func txValidityCheck(transaction *wire.MsgTx, previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
 	sigHashes *txscript.TxSigHashes) error {
 	if !txsort.IsSorted(transaction) {
 		return errp.New("tx not bip69 conformant")
 	}
+	return txScriptCheck(transaction, previousOutputs, sigHashes)
+}
+
+// txScriptCheck executes the scripts of all inputs to see if the signatures are valid.
+func txScriptCheck(transaction *wire.MsgTx, previousOutputs map[wire.OutPoint]*transactions.SpendableOutput,
+	sigHashes *txscript.TxSigHashes) error {
 	for index, txIn := range transaction.TxIn {
 		spentOutput, ok := previousOutputs[txIn.PreviousOutPoint]
 		if !ok {
 			return errp.New("There needs to be exactly one output being spent per input!")
 		}
+		if taproot.IsPayToTaproot(spentOutput.PkScript) {
+			// The vendored script engine does not support taproot yet, so we verify the key path
+			// spend directly.
+			if len(txIn.SignatureScript) != 0 || len(txIn.Witness) != 1 {
+				return errp.New("Invalid taproot key path spend")
+			}
+			sigHash, err := taproot.SigHash(transaction, index, spentOutputs(transaction, previousOutputs))
+			if err != nil {
+				return err
+			}
+			if !taproot.Verify(spentOutput.PkScript[2:], sigHash, txIn.Witness[0]) {
+				return errp.New("Invalid taproot signature")
+			}
+			continue
+		}
 		engine, err := txscript.NewEngine(spentOutput.PkScript, transaction, index,
 			txscript.StandardVerifyFlags, nil, sigHashes, spentOutput.Value)
 		if err != nil {
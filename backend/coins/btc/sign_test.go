@@ -0,0 +1,216 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSignTransactionTaproot receives to and spends from a regtest taproot account using the
+// software keystore.
+func TestSignTransactionTaproot(t *testing.T) {
+	log := logging.Get().WithGroup("sign_test")
+	net := &chaincfg.RegressionNetParams
+	coin := btc.NewCoin("rbtc", "RBTC", net, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+
+	softwareKeystore := software.NewKeystoreFromPIN(0, "1234")
+	keypath, err := signing.NewAbsoluteKeypath("m/86'/1'/0'")
+	require.NoError(t, err)
+	xpub, err := softwareKeystore.ExtendedPublicKey(coin, keypath)
+	require.NoError(t, err)
+	configuration := signing.NewSinglesigConfiguration(signing.ScriptTypeP2TR, keypath, xpub)
+	receiveAddresses := addresses.NewAddressChain(configuration, net, 20, 0, log).EnsureAddresses()
+	changeAddresses := addresses.NewAddressChain(configuration, net, 20, 1, log).EnsureAddresses()
+
+	// Receiving: addresses are bech32m encoded and can be decoded by the coin.
+	for _, address := range receiveAddresses[:2] {
+		require.True(t, strings.HasPrefix(address.EncodeAddress(), "bcrt1p"))
+		decoded, err := coin.DecodeAddress(address.EncodeAddress())
+		require.NoError(t, err)
+		pkScript, err := taproot.PayToAddrScript(decoded)
+		require.NoError(t, err)
+		require.Equal(t, address.PubkeyScript(), pkScript)
+	}
+
+	getAddress := func(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
+		for _, address := range append(receiveAddresses, changeAddresses...) {
+			if address.PubkeyScriptHashHex() == scriptHashHex {
+				return address
+			}
+		}
+		return nil
+	}
+
+	// Sending: two taproot inputs pay to a taproot recipient, with change.
+	previousOutputs := map[wire.OutPoint]*transactions.SpendableOutput{}
+	spendableOutputs := map[wire.OutPoint]*wire.TxOut{}
+	for index, address := range receiveAddresses[:2] {
+		outPoint := wire.OutPoint{Hash: chainhash.HashH([]byte("funding-tx")), Index: uint32(index)}
+		txOut := wire.NewTxOut(100000, address.PubkeyScript())
+		spendableOutputs[outPoint] = txOut
+		previousOutputs[outPoint] = &transactions.SpendableOutput{TxOut: txOut}
+	}
+	recipient, err := coin.DecodeAddress(receiveAddresses[5].EncodeAddress())
+	require.NoError(t, err)
+	recipientPkScript, err := taproot.PayToAddrScript(recipient)
+	require.NoError(t, err)
+	const feePerKb = 1000
+	txProposal, err := maketx.NewTx(
+		coin,
+		configuration,
+		spendableOutputs,
+		[]*wire.TxOut{wire.NewTxOut(150000, recipientPkScript)},
+		feePerKb,
+		feePerKb,
+		maketx.CoinSelectionLargestFirst,
+		func() *addresses.AccountAddress { return changeAddresses[0] },
+		log,
+	)
+	require.NoError(t, err)
+	require.Len(t, txProposal.Transaction.TxIn, 2)
+	require.Len(t, txProposal.Transaction.TxOut, 2)
+
+	require.NoError(t, btc.SignTransaction(
+		keystore.NewKeystores(softwareKeystore), txProposal, previousOutputs, getAddress, log))
+	for _, txIn := range txProposal.Transaction.TxIn {
+		require.Empty(t, txIn.SignatureScript)
+		require.Len(t, txIn.Witness, 1)
+		require.Len(t, txIn.Witness[0], 64)
+	}
+
+	// The fee estimation matches the virtual size of the signed transaction.
+	vsize := mempool.GetTxVirtualSize(btcutil.NewTx(txProposal.Transaction))
+	require.Equal(t, btcutil.Amount(vsize*feePerKb/1000), txProposal.Fee)
+}
+
+// TestSignTransactionMultisig spends from 2-of-3 segwit multisig accounts, of which two keys are
+// available locally.
+func TestSignTransactionMultisig(t *testing.T) {
+	log := logging.Get().WithGroup("sign_test")
+	net := &chaincfg.RegressionNetParams
+	coin := btc.NewCoin("rbtc", "RBTC", net, ".", []*rpc.ServerInfo{}, "", nil, 0, "",
+		socksproxy.NewSocksProxy(false, ""))
+
+	for _, test := range []struct {
+		scriptType signing.ScriptType
+		keypath    string
+	}{
+		{signing.ScriptTypeP2WSHP2SH, "m/48'/1'/0'/1'"},
+		{signing.ScriptTypeP2WSH, "m/48'/1'/0'/2'"},
+	} {
+		test := test
+		t.Run(string(test.scriptType), func(t *testing.T) {
+			keypath, err := signing.NewAbsoluteKeypath(test.keypath)
+			require.NoError(t, err)
+			keystore1 := software.NewKeystoreFromPIN(0, "1234")
+			keystore2 := software.NewKeystoreFromPIN(1, "5678")
+			xpubs := []*hdkeychain.ExtendedKey{}
+			for _, softwareKeystore := range []*software.Keystore{
+				keystore1, keystore2, software.NewKeystoreFromPIN(2, "0000"),
+			} {
+				xpub, err := softwareKeystore.ExtendedPublicKey(coin, keypath)
+				require.NoError(t, err)
+				xpubs = append(xpubs, xpub)
+			}
+			configuration := signing.NewConfiguration(test.scriptType, keypath, xpubs, "", 2)
+			receiveAddresses := addresses.NewAddressChain(configuration, net, 20, 0, log).EnsureAddresses()
+			changeAddresses := addresses.NewAddressChain(configuration, net, 20, 1, log).EnsureAddresses()
+			getAddress := func(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
+				for _, address := range append(receiveAddresses, changeAddresses...) {
+					if address.PubkeyScriptHashHex() == scriptHashHex {
+						return address
+					}
+				}
+				return nil
+			}
+
+			newTx := func() (*maketx.TxProposal, map[wire.OutPoint]*transactions.SpendableOutput) {
+				previousOutputs := map[wire.OutPoint]*transactions.SpendableOutput{}
+				spendableOutputs := map[wire.OutPoint]*wire.TxOut{}
+				for index, address := range receiveAddresses[:2] {
+					outPoint := wire.OutPoint{Hash: chainhash.HashH([]byte("funding-tx")), Index: uint32(index)}
+					txOut := wire.NewTxOut(100000, address.PubkeyScript())
+					spendableOutputs[outPoint] = txOut
+					previousOutputs[outPoint] = &transactions.SpendableOutput{TxOut: txOut}
+				}
+				txProposal, err := maketx.NewTx(
+					coin,
+					configuration,
+					spendableOutputs,
+					[]*wire.TxOut{wire.NewTxOut(150000, receiveAddresses[5].PubkeyScript())},
+					1000,
+					1000,
+					maketx.CoinSelectionLargestFirst,
+					func() *addresses.AccountAddress { return changeAddresses[0] },
+					log,
+				)
+				require.NoError(t, err)
+				return txProposal, previousOutputs
+			}
+
+			// Only one local signature is not enough.
+			txProposal, previousOutputs := newTx()
+			err = btc.SignTransaction(
+				keystore.NewKeystores(keystore1), txProposal, previousOutputs, getAddress, log)
+			require.Equal(t, btc.ErrNotEnoughSignatures, errp.Cause(err))
+
+			txProposal, previousOutputs = newTx()
+			require.NoError(t, btc.SignTransaction(
+				keystore.NewKeystores(keystore1, keystore2), txProposal, previousOutputs, getAddress, log))
+			for _, txIn := range txProposal.Transaction.TxIn {
+				require.Len(t, txIn.Witness, 4)
+				require.Empty(t, txIn.Witness[0])
+			}
+			vsize := mempool.GetTxVirtualSize(btcutil.NewTx(txProposal.Transaction))
+			require.True(t, btcutil.Amount(vsize) <= txProposal.Fee)
+
+			// The signatures are placed by the public keys of the keystores, not by their cosigner
+			// indices, and only as many as required are used.
+			txProposal, previousOutputs = newTx()
+			require.NoError(t, btc.SignTransaction(
+				keystore.NewKeystores(
+					software.NewKeystoreFromPIN(0, "0000"),
+					software.NewKeystoreFromPIN(1, "5678"),
+					software.NewKeystoreFromPIN(2, "1234"),
+				), txProposal, previousOutputs, getAddress, log))
+			for _, txIn := range txProposal.Transaction.TxIn {
+				require.Len(t, txIn.Witness, 4)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taproot
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// witnessVersion is the segwit version of taproot outputs.
+const witnessVersion = 1
+
+// AddressTaproot is a pay-to-taproot (segwit v1) address. It implements btcutil.Address, which in
+// the vendored btcutil does not know about taproot yet.
+type AddressTaproot struct {
+	hrp            string
+	witnessProgram [32]byte
+}
+
+// NewAddressTaproot returns a new taproot address for the given x-only output key.
+func NewAddressTaproot(outputKey []byte, net *chaincfg.Params) (*AddressTaproot, error) {
+	if len(outputKey) != 32 {
+		return nil, errp.Newf("invalid taproot output key length %d", len(outputKey))
+	}
+	address := &AddressTaproot{hrp: net.Bech32HRPSegwit}
+	copy(address.witnessProgram[:], outputKey)
+	return address, nil
+}
+
+// DecodeAddress decodes a bech32m encoded taproot address for the given network.
+func DecodeAddress(address string, net *chaincfg.Params) (*AddressTaproot, error) {
+	version, witnessProgram, err := decodeSegwitAddress(net.Bech32HRPSegwit, address)
+	if err != nil {
+		return nil, err
+	}
+	if version != witnessVersion {
+		return nil, errp.Newf("unsupported witness version %d", version)
+	}
+	return NewAddressTaproot(witnessProgram, net)
+}
+
+// EncodeAddress implements btcutil.Address.
+func (address *AddressTaproot) EncodeAddress() string {
+	encoded, err := encodeSegwitAddress(address.hrp, witnessVersion, address.witnessProgram[:])
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+// ScriptAddress implements btcutil.Address. It returns the x-only output key.
+func (address *AddressTaproot) ScriptAddress() []byte {
+	return address.witnessProgram[:]
+}
+
+// IsForNet implements btcutil.Address.
+func (address *AddressTaproot) IsForNet(net *chaincfg.Params) bool {
+	return address.hrp == net.Bech32HRPSegwit
+}
+
+// String implements btcutil.Address.
+func (address *AddressTaproot) String() string {
+	return address.EncodeAddress()
+}
+
+// PayToAddrScript is like txscript.PayToAddrScript, but also supports taproot addresses.
+func PayToAddrScript(address btcutil.Address) ([]byte, error) {
+	if taprootAddress, ok := address.(*AddressTaproot); ok {
+		return txscript.NewScriptBuilder().
+			AddOp(txscript.OP_1).
+			AddData(taprootAddress.witnessProgram[:]).
+			Script()
+	}
+	return txscript.PayToAddrScript(address)
+}
+
+// IsPayToTaproot returns true if the given pkScript is a segwit v1 output with a 32 byte witness
+// program.
+func IsPayToTaproot(pkScript []byte) bool {
+	return len(pkScript) == 34 &&
+		pkScript[0] == txscript.OP_1 &&
+		pkScript[1] == txscript.OP_DATA_32
+}
+
+// ExtractPkScriptAddress returns the taproot address paid to by the given pkScript, or false if
+// it is not a taproot pkScript.
+func ExtractPkScriptAddress(pkScript []byte, net *chaincfg.Params) (*AddressTaproot, bool) {
+	if !IsPayToTaproot(pkScript) {
+		return nil, false
+	}
+	address, err := NewAddressTaproot(pkScript[2:], net)
+	if err != nil {
+		return nil, false
+	}
+	return address, true
+}
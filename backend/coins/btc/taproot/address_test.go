@@ -0,0 +1,74 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taproot
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddress(t *testing.T) {
+	// Test vector of BIP350.
+	const encoded = "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"
+	address, err := DecodeAddress(encoded, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Equal(t, encoded, address.EncodeAddress())
+	require.Equal(t, encoded, address.String())
+	require.True(t, address.IsForNet(&chaincfg.MainNetParams))
+	require.False(t, address.IsForNet(&chaincfg.TestNet3Params))
+
+	pkScript, err := PayToAddrScript(address)
+	require.NoError(t, err)
+	require.Equal(t,
+		"512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		hex.EncodeToString(pkScript))
+	require.True(t, IsPayToTaproot(pkScript))
+	extracted, ok := ExtractPkScriptAddress(pkScript, &chaincfg.MainNetParams)
+	require.True(t, ok)
+	require.Equal(t, address, extracted)
+
+	// Uppercase is valid as well.
+	_, err = DecodeAddress(
+		"BC1P0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQZK5JJ0", &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	for _, invalid := range []string{
+		// Wrong network.
+		encoded,
+		// bech32 instead of bech32m checksum for v1.
+		"tb1pw508d6qejxtdg4y5r3zarqfsj6c3",
+		// v0 address.
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+		// Mixed case.
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq",
+		// Invalid checksum.
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47zagr",
+	} {
+		_, err := DecodeAddress(invalid, &chaincfg.TestNet3Params)
+		require.Error(t, err, invalid)
+	}
+
+	// Testnet vector of BIP350.
+	const testnetEncoded = "tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c"
+	testnetAddress, err := DecodeAddress(testnetEncoded, &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	require.Equal(t, testnetEncoded, testnetAddress.EncodeAddress())
+
+	// P2WPKH addresses are still handled by txscript.
+	require.False(t, IsPayToTaproot(unhex("0014751e76e8199196d454941c45d1b3a323f1433bd6")))
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taproot
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	// bech32mConst is the checksum constant of bech32m, see BIP350.
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(value)
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	result := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+// encodeBech32m encodes the given 5-bit groups with a bech32m checksum.
+func encodeBech32m(hrp string, data []byte) string {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ bech32mConst
+	var result strings.Builder
+	result.WriteString(hrp)
+	result.WriteByte('1')
+	for _, value := range data {
+		result.WriteByte(bech32Charset[value])
+	}
+	for i := uint(0); i < 6; i++ {
+		result.WriteByte(bech32Charset[(polymod>>(5*(5-i)))&31])
+	}
+	return result.String()
+}
+
+// decodeBech32m decodes a bech32m string and returns the hrp and the 5-bit groups without the
+// checksum.
+func decodeBech32m(encoded string) (string, []byte, error) {
+	if len(encoded) < 8 || len(encoded) > 90 {
+		return "", nil, errp.Newf("invalid bech32m string length %d", len(encoded))
+	}
+	lower := strings.ToLower(encoded)
+	if lower != encoded && strings.ToUpper(encoded) != encoded {
+		return "", nil, errp.New("bech32m string has mixed case")
+	}
+	separator := strings.LastIndexByte(lower, '1')
+	if separator < 1 || separator+7 > len(lower) {
+		return "", nil, errp.New("invalid bech32m separator position")
+	}
+	hrp := lower[:separator]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errp.New("invalid character in bech32m hrp")
+		}
+	}
+	data := make([]byte, 0, len(lower)-separator-1)
+	for _, char := range lower[separator+1:] {
+		value := strings.IndexRune(bech32Charset, char)
+		if value < 0 {
+			return "", nil, errp.Newf("invalid bech32m character %q", char)
+		}
+		data = append(data, byte(value))
+	}
+	if bech32Polymod(append(bech32HrpExpand(hrp), data...)) != bech32mConst {
+		return "", nil, errp.New("invalid bech32m checksum")
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// encodeSegwitAddress encodes a segwit v1+ witness program as a bech32m address.
+func encodeSegwitAddress(hrp string, witnessVersion byte, witnessProgram []byte) (string, error) {
+	converted, err := bech32.ConvertBits(witnessProgram, 8, 5, true)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	return encodeBech32m(hrp, append([]byte{witnessVersion}, converted...)), nil
+}
+
+// decodeSegwitAddress decodes a bech32m encoded segwit v1+ address and returns its witness version
+// and program.
+func decodeSegwitAddress(hrp string, address string) (byte, []byte, error) {
+	decodedHrp, data, err := decodeBech32m(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if decodedHrp != hrp {
+		return 0, nil, errp.Newf("invalid hrp %s, expected %s", decodedHrp, hrp)
+	}
+	if len(data) < 1 {
+		return 0, nil, errp.New("missing witness version")
+	}
+	witnessVersion := data[0]
+	if witnessVersion < 1 || witnessVersion > 16 {
+		return 0, nil, errp.Newf("invalid witness version %d for bech32m", witnessVersion)
+	}
+	witnessProgram, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, errp.WithStack(err)
+	}
+	if len(witnessProgram) < 2 || len(witnessProgram) > 40 {
+		return 0, nil, errp.Newf("invalid witness program length %d", len(witnessProgram))
+	}
+	return witnessVersion, witnessProgram, nil
+}
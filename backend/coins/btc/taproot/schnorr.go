@@ -0,0 +1,213 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taproot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+var curve = btcec.S256()
+
+// TaggedHash computes the BIP340 tagged hash of the concatenation of msgs.
+func TaggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hash := sha256.New()
+	_, _ = hash.Write(tagHash[:])
+	_, _ = hash.Write(tagHash[:])
+	for _, msg := range msgs {
+		_, _ = hash.Write(msg)
+	}
+	return hash.Sum(nil)
+}
+
+// bytes32 serializes the given integer as 32 big endian bytes.
+func bytes32(number *big.Int) []byte {
+	result := make([]byte, 32)
+	numberBytes := number.Bytes()
+	copy(result[32-len(numberBytes):], numberBytes)
+	return result
+}
+
+func hasEvenY(y *big.Int) bool {
+	return y.Bit(0) == 0
+}
+
+// liftX returns the point with the given x coordinate and an even y coordinate.
+func liftX(xBytes []byte) (*big.Int, *big.Int, error) {
+	if len(xBytes) != 32 {
+		return nil, nil, errp.New("x-only public keys must be 32 bytes")
+	}
+	p := curve.Params().P
+	x := new(big.Int).SetBytes(xBytes)
+	if x.Cmp(p) >= 0 {
+		return nil, nil, errp.New("x coordinate is not in the field")
+	}
+	// y^2 = x^3 + 7
+	ySquared := new(big.Int).Exp(x, big.NewInt(3), p)
+	ySquared.Add(ySquared, big.NewInt(7))
+	ySquared.Mod(ySquared, p)
+	// p = 3 mod 4, so the square root is y^2^((p+1)/4).
+	exponent := new(big.Int).Add(p, big.NewInt(1))
+	exponent.Rsh(exponent, 2)
+	y := new(big.Int).Exp(ySquared, exponent, p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(ySquared) != 0 {
+		return nil, nil, errp.New("x coordinate is not on the curve")
+	}
+	if !hasEvenY(y) {
+		y.Sub(p, y)
+	}
+	return x, y, nil
+}
+
+// XOnly serializes the public key as 32 bytes x-only public key, see BIP340.
+func XOnly(publicKey *btcec.PublicKey) []byte {
+	return bytes32(publicKey.X)
+}
+
+// tweak returns the BIP86 tweak for the given x-only internal key, committing to no script tree.
+func tweak(internalKey []byte) (*big.Int, error) {
+	t := new(big.Int).SetBytes(TaggedHash("TapTweak", internalKey))
+	if t.Cmp(curve.Params().N) >= 0 {
+		return nil, errp.New("taproot tweak is out of range")
+	}
+	return t, nil
+}
+
+// TweakPublicKey returns the x-only taproot output key for the given internal key, committing to
+// no script tree as described in BIP86.
+func TweakPublicKey(internalKey *btcec.PublicKey) ([]byte, error) {
+	internalKeyX := XOnly(internalKey)
+	px, py, err := liftX(internalKeyX)
+	if err != nil {
+		return nil, err
+	}
+	t, err := tweak(internalKeyX)
+	if err != nil {
+		return nil, err
+	}
+	tx, ty := curve.ScalarBaseMult(bytes32(t))
+	qx, qy := curve.Add(px, py, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return nil, errp.New("taproot output key is the point at infinity")
+	}
+	return bytes32(qx), nil
+}
+
+// TweakPrivateKey returns the private key of the taproot output key, see TweakPublicKey.
+func TweakPrivateKey(privateKey *btcec.PrivateKey) (*btcec.PrivateKey, error) {
+	n := curve.Params().N
+	publicKey := privateKey.PubKey()
+	d := new(big.Int).Set(privateKey.D)
+	if !hasEvenY(publicKey.Y) {
+		d.Sub(n, d)
+	}
+	t, err := tweak(XOnly(publicKey))
+	if err != nil {
+		return nil, err
+	}
+	d.Add(d, t)
+	d.Mod(d, n)
+	if d.Sign() == 0 {
+		return nil, errp.New("tweaked private key is zero")
+	}
+	tweaked, _ := btcec.PrivKeyFromBytes(curve, bytes32(d))
+	return tweaked, nil
+}
+
+func challenge(r []byte, publicKey []byte, msg []byte) *big.Int {
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", r, publicKey, msg))
+	return e.Mod(e, curve.Params().N)
+}
+
+// Sign creates a BIP340 Schnorr signature of the 32 byte msg using fresh auxiliary randomness.
+func Sign(privateKey *btcec.PrivateKey, msg []byte) ([]byte, error) {
+	auxRand := make([]byte, 32)
+	if _, err := rand.Read(auxRand); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return signWithAuxRand(privateKey, msg, auxRand)
+}
+
+func signWithAuxRand(privateKey *btcec.PrivateKey, msg []byte, auxRand []byte) ([]byte, error) {
+	if len(msg) != 32 {
+		return nil, errp.New("the message to sign must be 32 bytes")
+	}
+	n := curve.Params().N
+	if privateKey.D.Sign() == 0 || privateKey.D.Cmp(n) >= 0 {
+		return nil, errp.New("private key is out of range")
+	}
+	publicKey := privateKey.PubKey()
+	publicKeyX := XOnly(publicKey)
+	d := new(big.Int).Set(privateKey.D)
+	if !hasEvenY(publicKey.Y) {
+		d.Sub(n, d)
+	}
+	t := bytes32(d)
+	auxHash := TaggedHash("BIP0340/aux", auxRand)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	k := new(big.Int).SetBytes(TaggedHash("BIP0340/nonce", t, publicKeyX, msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, errp.New("invalid nonce")
+	}
+	rx, ry := curve.ScalarBaseMult(bytes32(k))
+	if !hasEvenY(ry) {
+		k.Sub(n, k)
+	}
+	r := bytes32(rx)
+	e := challenge(r, publicKeyX, msg)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	signature := append(r, bytes32(s)...)
+	if !Verify(publicKeyX, msg, signature) {
+		return nil, errp.New("created an invalid signature")
+	}
+	return signature, nil
+}
+
+// Verify verifies a BIP340 Schnorr signature of msg against the x-only public key.
+func Verify(publicKey []byte, msg []byte, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	px, py, err := liftX(publicKey)
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(curve.Params().P) >= 0 || s.Cmp(curve.Params().N) >= 0 {
+		return false
+	}
+	e := challenge(signature[:32], publicKey, msg)
+	// R = s*G - e*P
+	sx, sy := curve.ScalarBaseMult(bytes32(s))
+	negE := new(big.Int).Sub(curve.Params().N, e)
+	ex, ey := curve.ScalarMult(px, py, bytes32(negE))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	return hasEvenY(ry) && bytes.Equal(bytes32(rx), signature[:32])
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taproot
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func unhex(s string) []byte {
+	result, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// TestBIP86 checks the test vectors of BIP86.
+func TestBIP86(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed := pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"), 2048, 64, sha512.New)
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		keypath     string
+		internalKey string
+		outputKey   string
+		address     string
+	}{
+		{
+			keypath:     "m/86'/0'/0'/0/0",
+			internalKey: "cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115",
+			outputKey:   "a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c",
+			address:     "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr",
+		},
+		{
+			keypath:     "m/86'/0'/0'/0/1",
+			internalKey: "83dfe85a3151d2517290da461fe2815591ef69f2b18a2ce63f01697a8b313145",
+			outputKey:   "a82f29944d65b86ae6b5e5cc75e294ead6c59391a1edc5e016e3498c67fc7bbb",
+			address:     "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh",
+		},
+		{
+			keypath:     "m/86'/0'/0'/1/0",
+			internalKey: "399f1b2f4393f29a18c937859c5dd8a77350103157eb880f02e8c08214277cef",
+			outputKey:   "882d74e5d0572d5a816cef0041a96b6c1de832f6f9676d9605c44d5e9a97d3dc",
+			address:     "bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7",
+		},
+	} {
+		keypath, err := signing.NewAbsoluteKeypath(test.keypath)
+		require.NoError(t, err)
+		xprv, err := keypath.Derive(master)
+		require.NoError(t, err)
+		privateKey, err := xprv.ECPrivKey()
+		require.NoError(t, err)
+		publicKey := privateKey.PubKey()
+		require.Equal(t, test.internalKey, hex.EncodeToString(XOnly(publicKey)))
+
+		outputKey, err := TweakPublicKey(publicKey)
+		require.NoError(t, err)
+		require.Equal(t, test.outputKey, hex.EncodeToString(outputKey))
+
+		tweakedPrivateKey, err := TweakPrivateKey(privateKey)
+		require.NoError(t, err)
+		require.Equal(t, outputKey, XOnly(tweakedPrivateKey.PubKey()))
+
+		address, err := NewAddressTaproot(outputKey, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		require.Equal(t, test.address, address.EncodeAddress())
+	}
+}
+
+// TestSign checks test vectors of BIP340.
+func TestSign(t *testing.T) {
+	for _, test := range []struct {
+		privateKey string
+		publicKey  string
+		auxRand    string
+		msg        string
+		signature  string
+	}{
+		{
+			privateKey: "0000000000000000000000000000000000000000000000000000000000000003",
+			publicKey:  "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+			auxRand:    "0000000000000000000000000000000000000000000000000000000000000000",
+			msg:        "0000000000000000000000000000000000000000000000000000000000000000",
+			signature: "e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca8215" +
+				"25f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0",
+		},
+		{
+			privateKey: "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef",
+			publicKey:  "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+			auxRand:    "0000000000000000000000000000000000000000000000000000000000000001",
+			msg:        "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			signature: "6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de3341" +
+				"8906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a",
+		},
+	} {
+		privateKey, _ := btcec.PrivKeyFromBytes(curve, unhex(test.privateKey))
+		require.Equal(t, test.publicKey, hex.EncodeToString(XOnly(privateKey.PubKey())))
+		signature, err := signWithAuxRand(privateKey, unhex(test.msg), unhex(test.auxRand))
+		require.NoError(t, err)
+		require.Equal(t, test.signature, hex.EncodeToString(signature))
+		require.True(t, Verify(unhex(test.publicKey), unhex(test.msg), signature))
+
+		// Tampering with the message or signature invalidates it.
+		msg := unhex(test.msg)
+		msg[0] ^= 1
+		require.False(t, Verify(unhex(test.publicKey), msg, signature))
+		signature[63] ^= 1
+		require.False(t, Verify(unhex(test.publicKey), unhex(test.msg), signature))
+	}
+
+	// Signing with fresh randomness produces valid signatures.
+	privateKey, err := btcec.NewPrivateKey(curve)
+	require.NoError(t, err)
+	msg := TaggedHash("test", []byte("message"))
+	signature, err := Sign(privateKey, msg)
+	require.NoError(t, err)
+	require.True(t, Verify(XOnly(privateKey.PubKey()), msg, signature))
+}
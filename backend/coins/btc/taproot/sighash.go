@@ -0,0 +1,91 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taproot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// SigHashDefault is the BIP341 sighash type committing to all inputs and outputs. Signatures using
+// it are 64 bytes, without an appended sighash byte.
+const SigHashDefault = 0x00
+
+func writeUint32(writer hash.Hash, value uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], value)
+	_, _ = writer.Write(buf[:])
+}
+
+// SigHash computes the BIP341 signature hash for a key path spend of the input at inputIndex,
+// using SigHashDefault. prevOuts must contain the outputs spent by all inputs of the transaction,
+// in the order of the inputs.
+func SigHash(transaction *wire.MsgTx, inputIndex int, prevOuts []*wire.TxOut) ([]byte, error) {
+	if len(prevOuts) != len(transaction.TxIn) {
+		return nil, errp.New("the number of previous outputs must match the number of inputs")
+	}
+	if inputIndex < 0 || inputIndex >= len(transaction.TxIn) {
+		return nil, errp.Newf("input index %d out of range", inputIndex)
+	}
+	shaPrevouts := sha256.New()
+	shaAmounts := sha256.New()
+	shaScriptPubKeys := sha256.New()
+	shaSequences := sha256.New()
+	for index, txIn := range transaction.TxIn {
+		if prevOuts[index] == nil {
+			return nil, errp.Newf("missing previous output of input %d", index)
+		}
+		_, _ = shaPrevouts.Write(txIn.PreviousOutPoint.Hash[:])
+		writeUint32(shaPrevouts, txIn.PreviousOutPoint.Index)
+		var amount [8]byte
+		binary.LittleEndian.PutUint64(amount[:], uint64(prevOuts[index].Value))
+		_, _ = shaAmounts.Write(amount[:])
+		if err := wire.WriteVarBytes(shaScriptPubKeys, 0, prevOuts[index].PkScript); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		writeUint32(shaSequences, txIn.Sequence)
+	}
+	shaOutputs := sha256.New()
+	for _, txOut := range transaction.TxOut {
+		if err := wire.WriteTxOut(shaOutputs, 0, transaction.Version, txOut); err != nil {
+			return nil, errp.WithStack(err)
+		}
+	}
+
+	var sigMsg bytes.Buffer
+	// Sighash epoch.
+	sigMsg.WriteByte(0x00)
+	sigMsg.WriteByte(SigHashDefault)
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(transaction.Version))
+	sigMsg.Write(buf[:])
+	binary.LittleEndian.PutUint32(buf[:], transaction.LockTime)
+	sigMsg.Write(buf[:])
+	sigMsg.Write(shaPrevouts.Sum(nil))
+	sigMsg.Write(shaAmounts.Sum(nil))
+	sigMsg.Write(shaScriptPubKeys.Sum(nil))
+	sigMsg.Write(shaSequences.Sum(nil))
+	sigMsg.Write(shaOutputs.Sum(nil))
+	// Spend type: key path spend without annex.
+	sigMsg.WriteByte(0x00)
+	binary.LittleEndian.PutUint32(buf[:], uint32(inputIndex))
+	sigMsg.Write(buf[:])
+	return TaggedHash("TapSighash", sigMsg.Bytes()), nil
+}
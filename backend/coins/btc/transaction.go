@@ -17,13 +17,14 @@ package btc
 import (
 	"math/big"
 
-	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/addresses"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/maketx"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
@@ -32,58 +33,135 @@ import (
 // unitSatoshi is 1 BTC (default unit) in Satoshi.
 const unitSatoshi = 1e8
 
-// newTx creates a new tx to the given recipient address. It also returns a set of used account
-// outputs, which contains all outputs that spent in the tx. Those are needed to be able to sign the
-// transaction. selectedUTXOs restricts the available coins; if empty, no restriction is applied and
-// all unspent coins can be used.
-func (account *Account) newTx(
-	recipientAddress string,
-	amount coin.SendAmount,
-	feeTargetCode accounts.FeeTargetCode,
-	selectedUTXOs map[wire.OutPoint]struct{},
-) (
-	map[wire.OutPoint]*transactions.SpendableOutput, *maketx.TxProposal, error) {
-
-	account.log.Debug("Prepare new transaction")
+// maxUnconfirmedAncestors is the default mempool limit of unconfirmed ancestors of a tx, including
+// the tx itself. A child of a package exceeding it is not relayed.
+const maxUnconfirmedAncestors = 25
 
-	address, err := account.coin.DecodeAddress(recipientAddress)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	var feeTarget *FeeTarget
+// feeTarget returns the fee target for the given code. An error is returned if the fee rate of
+// the target has not been estimated yet.
+func (account *Account) feeTarget(feeTargetCode accounts.FeeTargetCode) (*FeeTarget, error) {
 	for _, target := range account.feeTargets {
 		if target.code == feeTargetCode {
-			feeTarget = target
-			break
+			if target.feeRatePerKb == nil {
+				break
+			}
+			return target, nil
 		}
 	}
-	if feeTarget == nil || feeTarget.feeRatePerKb == nil {
-		return nil, nil, errp.New("Fee could not be estimated")
-	}
+	return nil, errp.New("Fee could not be estimated")
+}
 
-	pkScript, err := txscript.PayToAddrScript(address)
-	if err != nil {
-		return nil, nil, errp.WithStack(err)
+// getAddress returns the receive or change address of the account with the given script hash. It
+// panics if the address is not found.
+func (account *Account) getAddress(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
+	if address := account.receiveAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+		return address
 	}
-	utxo := account.transactions.SpendableOutputs()
-	wireUTXO := make(map[wire.OutPoint]*wire.TxOut, len(utxo))
+	if address := account.changeAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
+		return address
+	}
+	panic("address must be present")
+}
+
+// Recipient is an output of a transaction.
+type Recipient struct {
+	Address string
+	Amount  coin.SendAmount
+}
+
+// selectableUTXO returns the outputs which coin selection may use for a regular transaction: frozen
+// outputs and incoming outputs, which are only spent by CPFP, are excluded. If selectedUTXOs is not
+// empty, only the selected outputs are returned.
+func selectableUTXO(
+	utxo map[wire.OutPoint]*transactions.SpendableOutput,
+	isFrozen func(wire.OutPoint) bool,
+	selectedUTXOs map[wire.OutPoint]struct{},
+) map[wire.OutPoint]*wire.TxOut {
+	result := make(map[wire.OutPoint]*wire.TxOut, len(utxo))
 	for outPoint, txOut := range utxo {
+		if isFrozen(outPoint) || txOut.IsIncoming() {
+			continue
+		}
 		// Apply coin control.
 		if len(selectedUTXOs) != 0 {
 			if _, ok := selectedUTXOs[outPoint]; !ok {
 				continue
 			}
 		}
-		wireUTXO[outPoint] = txOut.TxOut
+		result[outPoint] = txOut.TxOut
 	}
+	return result
+}
+
+// newTx creates a new tx to the given recipients. At most one recipient can receive all remaining
+// funds (coin.NewSendAmountAll()). It also returns a set of used account outputs, which contains
+// all outputs that spent in the tx. Those are needed to be able to sign the transaction.
+// selectedUTXOs restricts the available coins; if empty, no restriction is applied and all unspent
+// coins can be used.
+func (account *Account) newTx(
+	recipients []Recipient,
+	feeTargetCode accounts.FeeTargetCode,
+	selectedUTXOs map[wire.OutPoint]struct{},
+) (
+	map[wire.OutPoint]*transactions.SpendableOutput, *maketx.TxProposal, error) {
+
+	account.log.Debug("Prepare new transaction")
+
+	if len(recipients) == 0 {
+		return nil, nil, errp.New("At least one recipient is required")
+	}
+	outputs := []*wire.TxOut{}
+	var sendAllPkScript []byte
+	for _, recipient := range recipients {
+		address, err := account.coin.DecodeAddress(recipient.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		pkScript, err := taproot.PayToAddrScript(address)
+		if err != nil {
+			return nil, nil, errp.WithStack(err)
+		}
+		if recipient.Amount.SendAll() {
+			if sendAllPkScript != nil {
+				// Only one recipient can receive the remaining funds.
+				return nil, nil, errp.WithStack(errors.ErrInvalidAmount)
+			}
+			sendAllPkScript = pkScript
+			continue
+		}
+		allowZero := false
+		parsedAmount, err := recipient.Amount.Amount(big.NewInt(unitSatoshi), allowZero)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsedAmountInt64, err := parsedAmount.Int64()
+		if err != nil {
+			return nil, nil, errp.WithStack(errors.ErrInvalidAmount)
+		}
+		outputs = append(outputs, wire.NewTxOut(parsedAmountInt64, pkScript))
+	}
+
+	feeTarget, err := account.feeTarget(feeTargetCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The lowest priority fee target is used as an estimate of the fee rate paid when spending
+	// outputs in the future.
+	longTermFeePerKb := *feeTarget.feeRatePerKb
+	if economy := account.feeTargets[0]; economy.feeRatePerKb != nil {
+		longTermFeePerKb = *economy.feeRatePerKb
+	}
+
+	utxo := account.transactions.SpendableOutputs()
+	wireUTXO := selectableUTXO(utxo, account.isFrozen, selectedUTXOs)
 	var txProposal *maketx.TxProposal
-	if amount.SendAll() {
+	if sendAllPkScript != nil {
 		txProposal, err = maketx.NewTxSpendAll(
 			account.coin,
 			account.signingConfiguration,
 			wireUTXO,
-			pkScript,
+			outputs,
+			sendAllPkScript,
 			*feeTarget.feeRatePerKb,
 			account.log,
 		)
@@ -91,21 +169,14 @@ func (account *Account) newTx(
 			return nil, nil, err
 		}
 	} else {
-		allowZero := false
-		parsedAmount, err := amount.Amount(big.NewInt(unitSatoshi), allowZero)
-		if err != nil {
-			return nil, nil, err
-		}
-		parsedAmountInt64, err := parsedAmount.Int64()
-		if err != nil {
-			return nil, nil, errp.WithStack(errors.ErrInvalidAmount)
-		}
 		txProposal, err = maketx.NewTx(
 			account.coin,
 			account.signingConfiguration,
 			wireUTXO,
-			wire.NewTxOut(parsedAmountInt64, pkScript),
+			outputs,
 			*feeTarget.feeRatePerKb,
+			longTermFeePerKb,
+			maketx.CoinSelectionMinimizeWaste,
 			func() *addresses.AccountAddress {
 				return account.changeAddresses.GetUnused()[0]
 			},
@@ -127,27 +198,30 @@ func (account *Account) SendTx(
 	feeTargetCode accounts.FeeTargetCode,
 	selectedUTXOs map[wire.OutPoint]struct{},
 	_ []byte,
+) error {
+	return account.SendTxBatch(
+		[]Recipient{{Address: recipientAddress, Amount: amount}},
+		feeTargetCode,
+		selectedUTXOs,
+	)
+}
+
+// SendTxBatch creates, signs and sends a tx paying all recipients.
+func (account *Account) SendTxBatch(
+	recipients []Recipient,
+	feeTargetCode accounts.FeeTargetCode,
+	selectedUTXOs map[wire.OutPoint]struct{},
 ) error {
 	account.log.Info("Signing and sending transaction")
 	utxo, txProposal, err := account.newTx(
-		recipientAddress,
-		amount,
+		recipients,
 		feeTargetCode,
 		selectedUTXOs,
 	)
 	if err != nil {
 		return errp.WithMessage(err, "Failed to create transaction")
 	}
-	getAddress := func(scriptHashHex blockchain.ScriptHashHex) *addresses.AccountAddress {
-		if address := account.receiveAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
-			return address
-		}
-		if address := account.changeAddresses.LookupByScriptHashHex(scriptHashHex); address != nil {
-			return address
-		}
-		panic("address must be present")
-	}
-	if err := SignTransaction(account.keystores, txProposal, utxo, getAddress, account.log); err != nil {
+	if err := SignTransaction(account.keystores, txProposal, utxo, account.getAddress, account.log); err != nil {
 		return errp.WithMessage(err, "Failed to sign transaction")
 	}
 	account.log.Info("Signed transaction is broadcasted")
@@ -164,11 +238,25 @@ func (account *Account) TxProposal(
 	_ []byte,
 ) (
 	coin.Amount, coin.Amount, coin.Amount, error) {
+	return account.TxProposalBatch(
+		[]Recipient{{Address: recipientAddress, Amount: amount}},
+		feeTargetCode,
+		selectedUTXOs,
+	)
+}
+
+// TxProposalBatch is like TxProposal, but for a tx paying all recipients. The returned amount is
+// the sum of all outputs, excluding change.
+func (account *Account) TxProposalBatch(
+	recipients []Recipient,
+	feeTargetCode accounts.FeeTargetCode,
+	selectedUTXOs map[wire.OutPoint]struct{},
+) (
+	coin.Amount, coin.Amount, coin.Amount, error) {
 
 	account.log.Debug("Proposing transaction")
 	_, txProposal, err := account.newTx(
-		recipientAddress,
-		amount,
+		recipients,
 		feeTargetCode,
 		selectedUTXOs,
 	)
@@ -181,3 +269,138 @@ func (account *Account) TxProposal(
 		coin.NewAmountFromInt64(int64(txProposal.Fee)),
 		coin.NewAmountFromInt64(int64(txProposal.Total())), nil
 }
+
+// BumpFee replaces a pending outgoing transaction by a transaction paying the fee rate of the given
+// fee target (BIP125). The fee is deducted from the change, and more outputs are spent if the
+// change is not sufficient. The replacement is signed and broadcasted.
+func (account *Account) BumpFee(
+	txInfo *transactions.TxInfo,
+	feeTargetCode accounts.FeeTargetCode,
+) error {
+	account.log.Info("Bumping the fee of a transaction")
+	if txInfo.Height > 0 {
+		return errp.New("The transaction is already confirmed")
+	}
+	if !maketx.SignalsRBF(txInfo.Tx) {
+		return errp.New("The transaction does not signal replace-by-fee")
+	}
+	feeTarget, err := account.feeTarget(feeTargetCode)
+	if err != nil {
+		return err
+	}
+	if originalFeeRatePerKb := txInfo.FeeRatePerKb(); originalFeeRatePerKb != nil &&
+		*feeTarget.feeRatePerKb <= *originalFeeRatePerKb {
+		return errp.WithStack(errors.ErrFeeTooLow)
+	}
+	previousOutputs := account.transactions.PreviousOutputs(txInfo.Tx)
+	if len(previousOutputs) != len(txInfo.Tx.TxIn) {
+		return errp.New("Only transactions spending our own outputs can be replaced")
+	}
+	wirePreviousOutputs := make(map[wire.OutPoint]*wire.TxOut, len(previousOutputs))
+	for outPoint, txOut := range previousOutputs {
+		wirePreviousOutputs[outPoint] = txOut.TxOut
+	}
+
+	var changeAddress *addresses.AccountAddress
+	for _, txOut := range txInfo.Tx.TxOut {
+		address := account.changeAddresses.LookupByScriptHashHex(
+			blockchain.ScriptHashHex(chainhash.HashH(txOut.PkScript).String()))
+		if address != nil {
+			changeAddress = address
+			break
+		}
+	}
+
+	// BIP125 does not allow adding unconfirmed inputs, so only confirmed outputs are considered.
+	utxo := account.transactions.SpendableOutputs()
+	wireUTXO := map[wire.OutPoint]*wire.TxOut{}
+	for outPoint, txOut := range utxo {
+		if txOut.Ancestors != nil || account.isFrozen(outPoint) {
+			continue
+		}
+		wireUTXO[outPoint] = txOut.TxOut
+	}
+
+	txProposal, err := maketx.NewTxBumpFee(
+		account.coin,
+		account.signingConfiguration,
+		txInfo.Tx,
+		wirePreviousOutputs,
+		changeAddress,
+		wireUTXO,
+		*feeTarget.feeRatePerKb,
+		func() *addresses.AccountAddress {
+			return account.changeAddresses.GetUnused()[0]
+		},
+		account.log,
+	)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to create replacement transaction")
+	}
+	for outPoint, txOut := range utxo {
+		previousOutputs[outPoint] = txOut
+	}
+	if err := SignTransaction(account.keystores, txProposal, previousOutputs, account.getAddress, account.log); err != nil {
+		return errp.WithMessage(err, "Failed to sign transaction")
+	}
+	account.log.Info("Signed replacement transaction is broadcasted")
+	return account.blockchain.TransactionBroadcast(txProposal.Transaction)
+}
+
+// ChildPaysForParent accelerates an unconfirmed transaction paying to this account by spending
+// its outputs in a child transaction, which pays enough fee for both transactions to reach the fee
+// rate of the given fee target. The child is signed and broadcasted.
+func (account *Account) ChildPaysForParent(
+	txInfo *transactions.TxInfo,
+	feeTargetCode accounts.FeeTargetCode,
+) error {
+	account.log.Info("Accelerating a transaction with a child transaction")
+	feeTarget, err := account.feeTarget(feeTargetCode)
+	if err != nil {
+		return err
+	}
+	// The outputs of the parent are spent together with the unconfirmed ancestors, which are the
+	// same for all of them.
+	txHash := txInfo.Tx.TxHash()
+	parentOutputs := map[wire.OutPoint]*transactions.SpendableOutput{}
+	wireParentOutputs := map[wire.OutPoint]*wire.TxOut{}
+	var ancestors *transactions.Ancestors
+	for outPoint, txOut := range account.transactions.SpendableOutputs() {
+		if outPoint.Hash != txHash || account.isFrozen(outPoint) {
+			continue
+		}
+		parentOutputs[outPoint] = txOut
+		wireParentOutputs[outPoint] = txOut.TxOut
+		ancestors = txOut.Ancestors
+	}
+	if len(parentOutputs) == 0 {
+		return errp.New("transaction has no spendable outputs belonging to the wallet")
+	}
+	if ancestors == nil {
+		return errp.New("transaction is already confirmed")
+	}
+	if ancestors.Fee == nil {
+		return errp.New("the fee of the transaction or one of its ancestors is unknown")
+	}
+	if ancestors.Count >= maxUnconfirmedAncestors {
+		return errp.New("the transaction has too many unconfirmed ancestors")
+	}
+	txProposal, err := maketx.NewTxCPFP(
+		account.coin,
+		account.signingConfiguration,
+		ancestors.VSize,
+		*ancestors.Fee,
+		wireParentOutputs,
+		account.changeAddresses.GetUnused()[0],
+		*feeTarget.feeRatePerKb,
+		account.log,
+	)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to create child transaction")
+	}
+	if err := SignTransaction(account.keystores, txProposal, parentOutputs, account.getAddress, account.log); err != nil {
+		return errp.WithMessage(err, "Failed to sign transaction")
+	}
+	account.log.Info("Signed child transaction is broadcasted")
+	return account.blockchain.TransactionBroadcast(txProposal.Transaction)
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btc
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectableUTXO(t *testing.T) {
+	outPoint := func(index uint32) wire.OutPoint {
+		return wire.OutPoint{Hash: chainhash.HashH([]byte("tx")), Index: index}
+	}
+	confirmed := &transactions.SpendableOutput{TxOut: wire.NewTxOut(1000, nil)}
+	frozen := &transactions.SpendableOutput{TxOut: wire.NewTxOut(2000, nil)}
+	incoming := &transactions.SpendableOutput{
+		TxOut:     wire.NewTxOut(3000, nil),
+		Ancestors: &transactions.Ancestors{Count: 1, Incoming: true},
+	}
+	change := &transactions.SpendableOutput{
+		TxOut:     wire.NewTxOut(4000, nil),
+		Ancestors: &transactions.Ancestors{Count: 1},
+	}
+	utxo := map[wire.OutPoint]*transactions.SpendableOutput{
+		outPoint(0): confirmed,
+		outPoint(1): frozen,
+		outPoint(2): incoming,
+		outPoint(3): change,
+	}
+	isFrozen := func(o wire.OutPoint) bool { return o == outPoint(1) }
+
+	require.Equal(t,
+		map[wire.OutPoint]*wire.TxOut{
+			outPoint(0): confirmed.TxOut,
+			outPoint(3): change.TxOut,
+		},
+		selectableUTXO(utxo, isFrozen, nil),
+	)
+
+	// Frozen outputs are excluded even if selected with coin control.
+	require.Equal(t,
+		map[wire.OutPoint]*wire.TxOut{
+			outPoint(3): change.TxOut,
+		},
+		selectableUTXO(utxo, isFrozen, map[wire.OutPoint]struct{}{
+			outPoint(1): {},
+			outPoint(3): {},
+		}),
+	)
+}
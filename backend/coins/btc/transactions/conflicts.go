@@ -0,0 +1,233 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transactions
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+)
+
+func containsTxHash(txHashes []chainhash.Hash, txHash chainhash.Hash) bool {
+	for _, existingTxHash := range txHashes {
+		if existingTxHash == txHash {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, existingValue := range values {
+		if existingValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+type conflictCandidate struct {
+	txHash chainhash.Hash
+	height int
+	fee    btcutil.Amount
+	vsize  int64
+}
+
+// winsAgainst returns true if the candidate is preferred over the other candidate spending the same
+// output. A confirmed tx wins over unconfirmed ones. Among unconfirmed txs, the one paying the
+// higher fee rate wins, as miners prefer it and a replacement has to pay a higher fee rate than the
+// replaced tx (BIP125). The tx hash is the tie-breaker so the result is deterministic.
+func (candidate *conflictCandidate) winsAgainst(other *conflictCandidate) bool {
+	if (candidate.height > 0) != (other.height > 0) {
+		return candidate.height > 0
+	}
+	if candidate.height > 0 && candidate.height != other.height {
+		return candidate.height < other.height
+	}
+	// Compare fee/vsize without dividing: fee*otherVSize vs. otherFee*vsize.
+	candidateRate := int64(candidate.fee) * other.vsize
+	otherRate := int64(other.fee) * candidate.vsize
+	if candidateRate != otherRate {
+		return candidateRate > otherRate
+	}
+	return candidate.txHash.String() < other.txHash.String()
+}
+
+// markChanged records that the tx was added, modified or removed using the current database
+// transaction, so that the next updateConflicts() recomputes the conflicts it is involved in. tx is
+// needed even for removed txs to find the txs related to it. Requires the transactions lock.
+func (transactions *Transactions) markChanged(txHash chainhash.Hash, tx *wire.MsgTx) {
+	transactions.changedTxs[txHash] = tx
+}
+
+// affectedTxs returns the txs whose conflict status can depend on the changed txs: the changed txs
+// themselves, the txs spending the same outputs and the txs spending their outputs, and
+// transitively the same for all of these. The value is nil for txs which are not in the database
+// anymore.
+func (transactions *Transactions) affectedTxs(dbTx DBTxInterface) map[chainhash.Hash]*wire.MsgTx {
+	affected := map[chainhash.Hash]*wire.MsgTx{}
+	queue := []wire.OutPoint{}
+	enqueue := func(txHash chainhash.Hash, tx *wire.MsgTx) {
+		for _, txIn := range tx.TxIn {
+			queue = append(queue, txIn.PreviousOutPoint)
+		}
+		for index := range tx.TxOut {
+			queue = append(queue, wire.OutPoint{Hash: txHash, Index: uint32(index)})
+		}
+	}
+	txInfo := func(txHash chainhash.Hash) *wire.MsgTx {
+		tx, _, _, _, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		return tx
+	}
+	for txHash, tx := range transactions.changedTxs {
+		affected[txHash] = txInfo(txHash)
+		enqueue(txHash, tx)
+	}
+	visited := map[wire.OutPoint]struct{}{}
+	for len(queue) != 0 {
+		outPoint := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[outPoint]; ok {
+			continue
+		}
+		visited[outPoint] = struct{}{}
+		spenders, err := dbTx.Inputs(outPoint)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve inputs")
+		}
+		for _, spender := range spenders {
+			if _, ok := affected[spender]; ok {
+				continue
+			}
+			tx := txInfo(spender)
+			affected[spender] = tx
+			if tx != nil {
+				enqueue(spender, tx)
+			}
+		}
+	}
+	return affected
+}
+
+// updateConflicts updates the cached conflicts after the database was modified using dbTx. Only
+// the conflicts of the txs affected by the changes recorded with markChanged() are recomputed, see
+// affectedTxs(). Returns true if a tx which was not conflicted before lost against a conflicting
+// tx, i.e. if a new double spend was detected. Requires the transactions lock.
+//
+// A tx spending the same output as another tx and losing against it, see winsAgainst(), has
+// TxStatusReplaced if the winner is confirmed, otherwise TxStatusConflicted. Descendants of losing
+// transactions inherit their status, as they can't be confirmed either.
+func (transactions *Transactions) updateConflicts(dbTx DBTxInterface) bool {
+	affected := transactions.affectedTxs(dbTx)
+	transactions.changedTxs = map[chainhash.Hash]*wire.MsgTx{}
+	conflicts := transactions.conflicts
+	previousConflicts := map[chainhash.Hash]struct{}{}
+	for txHash := range affected {
+		if _, ok := conflicts[txHash]; ok {
+			previousConflicts[txHash] = struct{}{}
+			delete(conflicts, txHash)
+		}
+	}
+
+	candidates := map[chainhash.Hash]*conflictCandidate{}
+	// candidate looks up the tx lazily, as only a few txs are involved in conflicts.
+	candidate := func(txHash chainhash.Hash) *conflictCandidate {
+		if result, ok := candidates[txHash]; ok {
+			return result
+		}
+		tx, scriptHashHexes, height, _, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		if tx == nil {
+			candidates[txHash] = nil
+			return nil
+		}
+		result := &conflictCandidate{
+			txHash: txHash,
+			height: height,
+			vsize:  mempool.GetTxVirtualSize(btcutil.NewTx(tx)),
+		}
+		if fee := transactions.fee(dbTx, txHash, tx, scriptHashHexes); fee != nil {
+			result.fee = *fee
+		}
+		candidates[txHash] = result
+		return result
+	}
+
+	for txHash, tx := range affected {
+		if tx == nil {
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			spenders, err := dbTx.Inputs(txIn.PreviousOutPoint)
+			if err != nil {
+				transactions.log.WithError(err).Panic("Failed to retrieve inputs")
+			}
+			if len(spenders) < 2 {
+				continue
+			}
+			var winner *conflictCandidate
+			for _, spender := range spenders {
+				if spenderCandidate := candidate(spender); spenderCandidate != nil &&
+					(winner == nil || spenderCandidate.winsAgainst(winner)) {
+					winner = spenderCandidate
+				}
+			}
+			if winner == nil || winner.txHash == txHash {
+				continue
+			}
+			if winner.height > 0 {
+				conflicts[txHash] = accounts.TxStatusReplaced
+			} else if conflicts[txHash] != accounts.TxStatusReplaced {
+				conflicts[txHash] = accounts.TxStatusConflicted
+			}
+		}
+	}
+
+	// Propagate the status to the affected descendants until nothing changes anymore. The status of
+	// parents which are not affected is already up to date.
+	for changed := true; changed; {
+		changed = false
+		for txHash, tx := range affected {
+			if tx == nil || conflicts[txHash] == accounts.TxStatusReplaced {
+				continue
+			}
+			for _, txIn := range tx.TxIn {
+				parentStatus, ok := conflicts[txIn.PreviousOutPoint.Hash]
+				if !ok || parentStatus == conflicts[txHash] {
+					continue
+				}
+				if parentStatus == accounts.TxStatusReplaced || conflicts[txHash] == "" {
+					conflicts[txHash] = parentStatus
+					changed = true
+				}
+			}
+		}
+	}
+
+	for txHash := range affected {
+		_, conflicted := conflicts[txHash]
+		if _, previouslyConflicted := previousConflicts[txHash]; conflicted && !previouslyConflicted {
+			return true
+		}
+	}
+	return false
+}
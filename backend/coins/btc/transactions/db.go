@@ -55,16 +55,18 @@ type DBTxInterface interface {
 	// MarkTxVerified marks a tx as verified. Stores timestamp of the header this tx appears in.
 	MarkTxVerified(txHash chainhash.Hash, headerTimestamp time.Time) error
 
-	// PutInput stores a transaction input. It is referenced by output it spends. The transaction
-	// hash of the transaction this input was found in is recorded. TODO: store slice of inputs
-	// along with the txhash they appear in. If there are more than one, a double spend is detected.
+	// PutInput stores a transaction input. It is referenced by the output it spends. The hash of
+	// the transaction this input was found in is added to the transactions spending the output. If
+	// there is more than one, a double spend is detected.
 	PutInput(wire.OutPoint, chainhash.Hash) error
 
-	// Input retrieves an input. `nil, nil` is returned if not found.
-	Input(wire.OutPoint) (*chainhash.Hash, error)
+	// Inputs retrieves the hashes of all transactions spending the output. An empty slice is
+	// returned if not found.
+	Inputs(wire.OutPoint) ([]chainhash.Hash, error)
 
-	// DeleteInput deletes an input (nothing happens if not found).
-	DeleteInput(wire.OutPoint)
+	// DeleteInput deletes the input of the given transaction spending the output (nothing happens
+	// if not found).
+	DeleteInput(wire.OutPoint, chainhash.Hash)
 
 	// PutOutput stores an Output.
 	PutOutput(wire.OutPoint, *wire.TxOut) error
@@ -81,6 +83,12 @@ type DBTxInterface interface {
 
 	// AddressHistory retrieves an address history. If not found, returns an empty history.
 	AddressHistory(blockchain.ScriptHashHex) (blockchain.TxHistory, error)
+
+	// PutScanState stores the scan state of an address, see blockchain.ScanState.
+	PutScanState(blockchain.ScriptHashHex, *blockchain.ScanState) error
+
+	// ScanState retrieves the scan state of an address. If not found, returns nil.
+	ScanState(blockchain.ScriptHashHex) (*blockchain.ScanState, error)
 }
 
 // DBInterface can be implemented by database backends to open database transactions.
@@ -29,6 +29,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/synchronizer"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/sirupsen/logrus"
@@ -38,6 +39,29 @@ import (
 type SpendableOutput struct {
 	*wire.TxOut
 	Address string
+	// Ancestors is nil for outputs of confirmed transactions. Otherwise, it describes the
+	// unconfirmed tx creating the output together with all of its unconfirmed ancestors.
+	Ancestors *Ancestors
+}
+
+// Ancestors is a package of unconfirmed transactions of the wallet. A child tx spending one of
+// their outputs has to pay for the whole package to be mined (CPFP).
+type Ancestors struct {
+	// Count is the number of transactions.
+	Count int
+	// VSize is the total virtual size of the transactions.
+	VSize int64
+	// Fee is the total fee paid by the transactions, or nil if the fee of one of them is unknown.
+	Fee *btcutil.Amount
+	// Incoming is true if one of the transactions is not funded by the wallet alone.
+	Incoming bool
+}
+
+// IsIncoming returns true if the output or one of its unconfirmed ancestors was sent to us by
+// someone else. The sender can still replace such transactions, so these outputs are only spent to
+// accelerate them (CPFP), not in regular transactions.
+func (txOut *SpendableOutput) IsIncoming() bool {
+	return txOut.Ancestors != nil && txOut.Ancestors.Incoming
 }
 
 // ScriptHashHex returns the hash of the PkScript of the output, in hex format.
@@ -58,6 +82,11 @@ type Transactions struct {
 	db           DBInterface
 	headers      headers.Interface
 	requestedTXs map[chainhash.Hash][]func(DBTxInterface, *wire.MsgTx)
+	// conflicts maps the txs which lost against a conflicting tx to their status, updated by
+	// updateConflicts() whenever the database is modified.
+	conflicts map[chainhash.Hash]accounts.TxStatus
+	// changedTxs are the txs modified since the last updateConflicts(), see markChanged().
+	changedTxs map[chainhash.Hash]*wire.MsgTx
 
 	// headersTipHeight is the current chain tip height, so we can compute the number of
 	// confirmations of a transaction.
@@ -68,6 +97,7 @@ type Transactions struct {
 	synchronizer *synchronizer.Synchronizer
 	blockchain   blockchain.Interface
 	notifier     accounts.Notifier
+	onEvent      func(accounts.Event)
 	log          *logrus.Entry
 }
 
@@ -79,6 +109,7 @@ func NewTransactions(
 	synchronizer *synchronizer.Synchronizer,
 	blockchain blockchain.Interface,
 	notifier accounts.Notifier,
+	onEvent func(accounts.Event),
 	log *logrus.Entry,
 ) *Transactions {
 	transactions := &Transactions{
@@ -86,14 +117,34 @@ func NewTransactions(
 		db:           db,
 		headers:      headers,
 		requestedTXs: map[chainhash.Hash][]func(DBTxInterface, *wire.MsgTx){},
+		conflicts:    map[chainhash.Hash]accounts.TxStatus{},
+		changedTxs:   map[chainhash.Hash]*wire.MsgTx{},
 
 		headersTipHeight: headers.TipHeight(),
 
 		synchronizer: synchronizer,
 		blockchain:   blockchain,
 		notifier:     notifier,
+		onEvent:      onEvent,
 		log:          log.WithFields(logrus.Fields{"group": "transactions", "net": net.Name}),
 	}
+	dbTx, err := db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+	txHashes, err := dbTx.Transactions()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve transactions")
+	}
+	for _, txHash := range txHashes {
+		tx, _, _, _, err := dbTx.TxInfo(txHash)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		transactions.markChanged(txHash, tx)
+	}
+	transactions.updateConflicts(dbTx)
 	transactions.unsubscribeHeadersEvent = headers.SubscribeEvent(transactions.onHeadersEvent)
 	return transactions
 }
@@ -126,10 +177,15 @@ func (transactions *Transactions) processTxForAddress(
 		return
 	}
 
-	_, _, previousHeight, _, err := dbTx.TxInfo(txHash)
+	previousTx, previousScriptHashHexes, previousHeight, _, err := dbTx.TxInfo(txHash)
 	if err != nil {
 		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
 	}
+	// A new tx, a new confirmation or new outputs of the address can change the conflicts.
+	if previousTx == nil || previousHeight != height ||
+		!containsString(previousScriptHashHexes, string(scriptHashHex)) {
+		transactions.markChanged(txHash, tx)
+	}
 
 	if err := dbTx.PutTx(txHash, tx, height); err != nil {
 		transactions.log.WithError(err).Panic("Failed to put tx")
@@ -163,13 +219,14 @@ func (transactions *Transactions) processInputsAndOutputsForAddress(
 		// multiple times for different addresses, we index all inputs, even those that didn't
 		// originate from our wallet. At this stage we don't know if it is one of our own inputs,
 		// since the output that it spends might be indexed later.
-		txInTxHash, err := dbTx.Input(txIn.PreviousOutPoint)
+		txInTxHashes, err := dbTx.Inputs(txIn.PreviousOutPoint)
 		if err != nil {
-			transactions.log.WithError(err).Panic("Failed to retrieve input from previous outpoint")
+			transactions.log.WithError(err).Panic("Failed to retrieve inputs from previous outpoint")
 		}
-		if txInTxHash != nil && *txInTxHash != txHash {
+		if len(txInTxHashes) != 0 && !containsTxHash(txInTxHashes, txHash) {
+			// All spending transactions are kept. Which one wins is decided by updateConflicts().
 			transactions.log.WithFields(logrus.Fields{"txIn.PreviousOutPoint": txIn.PreviousOutPoint,
-				"txInTxHash": txInTxHash, "txHash": txHash}).
+				"txInTxHashes": txInTxHashes, "txHash": txHash}).
 				Warning("Double spend detected")
 		}
 		if err := dbTx.PutInput(txIn.PreviousOutPoint, txHash); err != nil {
@@ -205,8 +262,9 @@ func (transactions *Transactions) allInputsOurs(dbTx DBTxInterface, transaction
 }
 
 // SpendableOutputs returns all unspent outputs of the wallet which are eligible to be spent. Those
-// include all unspent outputs of confirmed transactions, and unconfirmed outputs that we created
-// ourselves.
+// include all unspent outputs of confirmed transactions, and unspent outputs of unconfirmed
+// transactions which don't conflict with another transaction. The latter come with their
+// unconfirmed ancestors. Outputs for which IsIncoming() is true must only be spent by CPFP.
 func (transactions *Transactions) SpendableOutputs() map[wire.OutPoint]*SpendableOutput {
 	transactions.synchronizer.WaitSynchronized()
 	defer transactions.RLock()()
@@ -221,17 +279,134 @@ func (transactions *Transactions) SpendableOutputs() map[wire.OutPoint]*Spendabl
 	if err != nil {
 		transactions.log.WithError(err).Panic("Failed to retrieve outputs")
 	}
+	conflicts := transactions.conflicts
+	ancestorsByTx := map[chainhash.Hash]*Ancestors{}
 	result := map[wire.OutPoint]*SpendableOutput{}
 	for outPoint, txOut := range outputs {
-		tx, _, height, _, err := dbTx.TxInfo(outPoint.Hash)
+		if _, conflicted := conflicts[outPoint.Hash]; conflicted {
+			continue
+		}
+		if transactions.isInputSpent(dbTx, outPoint, conflicts) {
+			continue
+		}
+		_, _, height, _, err := dbTx.TxInfo(outPoint.Hash)
 		if err != nil {
 			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
 		}
-		confirmed := height > 0
+		output := &SpendableOutput{
+			TxOut:   txOut,
+			Address: transactions.outputToAddress(txOut.PkScript),
+		}
+		if height <= 0 {
+			ancestors, ok := ancestorsByTx[outPoint.Hash]
+			if !ok {
+				ancestors = transactions.ancestors(dbTx, outPoint.Hash)
+				ancestorsByTx[outPoint.Hash] = ancestors
+			}
+			output.Ancestors = ancestors
+		}
+		result[outPoint] = output
+	}
+	return result
+}
 
-		spent := transactions.isInputSpent(dbTx, outPoint)
-		if !spent && (confirmed || transactions.allInputsOurs(dbTx, tx)) {
-			result[outPoint] = &SpendableOutput{
+// ancestors collects the given unconfirmed tx and all of its unconfirmed ancestors known to the
+// wallet. Ancestors of incoming transactions which don't belong to the wallet are unknown and
+// can't be accounted for.
+func (transactions *Transactions) ancestors(dbTx DBTxInterface, txHash chainhash.Hash) *Ancestors {
+	result := &Ancestors{}
+	fee := btcutil.Amount(0)
+	feeKnown := true
+	visited := map[chainhash.Hash]struct{}{}
+	queue := []chainhash.Hash{txHash}
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[current]; ok {
+			continue
+		}
+		visited[current] = struct{}{}
+		tx, scriptHashHexes, height, _, err := dbTx.TxInfo(current)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+		}
+		if tx == nil || height > 0 {
+			continue
+		}
+		result.Count++
+		result.VSize += mempool.GetTxVirtualSize(btcutil.NewTx(tx))
+		if txFee := transactions.fee(dbTx, current, tx, scriptHashHexes); txFee != nil {
+			fee += *txFee
+		} else {
+			feeKnown = false
+		}
+		if !transactions.allInputsOurs(dbTx, tx) {
+			result.Incoming = true
+		}
+		for _, txIn := range tx.TxIn {
+			queue = append(queue, txIn.PreviousOutPoint.Hash)
+		}
+	}
+	if feeKnown {
+		result.Fee = &fee
+	}
+	return result
+}
+
+// fee returns the fee paid by the tx. If not all inputs of the tx are ours, the fee reported by the
+// blockchain backend for mempool transactions is used. Returns nil if the fee is unknown.
+func (transactions *Transactions) fee(
+	dbTx DBTxInterface, txHash chainhash.Hash, tx *wire.MsgTx, scriptHashHexes []string) *btcutil.Amount {
+	if transactions.allInputsOurs(dbTx, tx) {
+		sum := btcutil.Amount(0)
+		for _, txIn := range tx.TxIn {
+			txOut, err := dbTx.Output(txIn.PreviousOutPoint)
+			if err != nil {
+				transactions.log.WithError(err).Panic("Failed to retrieve output")
+			}
+			sum += btcutil.Amount(txOut.Value)
+		}
+		for _, txOut := range tx.TxOut {
+			sum -= btcutil.Amount(txOut.Value)
+		}
+		return &sum
+	}
+	var fee *btcutil.Amount
+	for _, scriptHashHex := range scriptHashHexes {
+		history, err := dbTx.AddressHistory(blockchain.ScriptHashHex(scriptHashHex))
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve address history")
+		}
+		for _, entry := range history {
+			if entry.TXHash.Hash() == txHash && entry.Fee != nil {
+				historyFee := btcutil.Amount(*entry.Fee)
+				fee = &historyFee
+			}
+		}
+	}
+	return fee
+}
+
+// PreviousOutputs returns the outputs of the wallet which are spent by the given tx. Outputs not
+// belonging to the wallet are not included.
+func (transactions *Transactions) PreviousOutputs(tx *wire.MsgTx) map[wire.OutPoint]*SpendableOutput {
+	transactions.synchronizer.WaitSynchronized()
+	defer transactions.RLock()()
+
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+
+	result := map[wire.OutPoint]*SpendableOutput{}
+	for _, txIn := range tx.TxIn {
+		txOut, err := dbTx.Output(txIn.PreviousOutPoint)
+		if err != nil {
+			transactions.log.WithError(err).Panic("Failed to retrieve output")
+		}
+		if txOut != nil {
+			result[txIn.PreviousOutPoint] = &SpendableOutput{
 				TxOut:   txOut,
 				Address: transactions.outputToAddress(txOut.PkScript),
 			}
@@ -240,12 +415,38 @@ func (transactions *Transactions) SpendableOutputs() map[wire.OutPoint]*Spendabl
 	return result
 }
 
-func (transactions *Transactions) isInputSpent(dbTx DBTxInterface, outPoint wire.OutPoint) bool {
-	input, err := dbTx.Input(outPoint)
+// Tx returns the transaction with the given hash, or nil if it is not a transaction of the wallet.
+func (transactions *Transactions) Tx(txHash chainhash.Hash) *wire.MsgTx {
+	transactions.synchronizer.WaitSynchronized()
+	defer transactions.RLock()()
+
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+
+	tx, _, _, _, err := dbTx.TxInfo(txHash)
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to retrieve tx info")
+	}
+	return tx
+}
+
+// isInputSpent returns true if the output is spent by a transaction which did not lose against a
+// conflicting transaction. conflicts must be the result of updateConflicts().
+func (transactions *Transactions) isInputSpent(
+	dbTx DBTxInterface, outPoint wire.OutPoint, conflicts map[chainhash.Hash]accounts.TxStatus) bool {
+	txHashes, err := dbTx.Inputs(outPoint)
 	if err != nil {
-		transactions.log.WithError(err).Panic("Failed to retrieve input for outPoint")
+		transactions.log.WithError(err).Panic("Failed to retrieve inputs for outPoint")
+	}
+	for _, txHash := range txHashes {
+		if _, conflicted := conflicts[txHash]; !conflicted {
+			return true
+		}
 	}
-	return input != nil
+	return false
 }
 
 func (transactions *Transactions) removeTxForAddress(
@@ -268,10 +469,11 @@ func (transactions *Transactions) removeTxForAddress(
 	}
 	if empty {
 		// Tx is not touching any of our outputs anymore. Remove.
+		transactions.markChanged(txHash, tx)
 
 		for _, txIn := range tx.TxIn {
 			transactions.log.Debug("Deleting transaction iput")
-			dbTx.DeleteInput(txIn.PreviousOutPoint)
+			dbTx.DeleteInput(txIn.PreviousOutPoint, txHash)
 		}
 
 		// Remove the outputs added by this tx.
@@ -293,6 +495,16 @@ func (transactions *Transactions) removeTxForAddress(
 // an address changes (a new transaction that touches it appears or disappears). The transactions
 // are downloaded and indexed.
 func (transactions *Transactions) UpdateAddressHistory(scriptHashHex blockchain.ScriptHashHex, txs []*blockchain.TxInfo) {
+	// The event is emitted after unlocking, as the handlers can query the transactions.
+	if transactions.updateAddressHistory(scriptHashHex, txs) {
+		transactions.onEvent(accounts.EventTxConflict)
+	}
+}
+
+// updateAddressHistory implements UpdateAddressHistory(). Returns true if a new double spend was
+// detected.
+func (transactions *Transactions) updateAddressHistory(
+	scriptHashHex blockchain.ScriptHashHex, txs []*blockchain.TxInfo) bool {
 	defer transactions.Lock()()
 	dbTx, err := transactions.db.Begin()
 	defer dbTx.Rollback()
@@ -331,9 +543,11 @@ func (transactions *Transactions) UpdateAddressHistory(scriptHashHex blockchain.
 			})
 		}(txInfo.TXHash.Hash(), txInfo.Height)
 	}
+	newConflict := transactions.updateConflicts(dbTx)
 	if err := dbTx.Commit(); err != nil {
 		transactions.log.WithError(err).Panic("Failed to commit transaction")
 	}
+	return newConflict
 }
 
 // requires transactions lock
@@ -362,18 +576,15 @@ func (transactions *Transactions) doForTransaction(
 	transactions.blockchain.TransactionGet(
 		txHash,
 		func(tx *wire.MsgTx) error {
-			defer transactions.Lock()()
-			dbTx, err := transactions.db.Begin()
+			newConflict, err := transactions.processRequestedTx(txHash, tx)
 			if err != nil {
-				transactions.log.WithError(err).Panic("Failed to begin transaction")
+				return err
 			}
-			defer dbTx.Rollback()
-
-			for _, callback := range transactions.requestedTXs[txHash] {
-				callback(dbTx, tx)
+			// The event is emitted after unlocking, as the handlers can query the transactions.
+			if newConflict {
+				transactions.onEvent(accounts.EventTxConflict)
 			}
-			delete(transactions.requestedTXs, txHash)
-			return dbTx.Commit()
+			return nil
 		},
 		func(err error) {
 			done()
@@ -384,8 +595,28 @@ func (transactions *Transactions) doForTransaction(
 	)
 }
 
-// Balance computes the confirmed and unconfirmed balance of the account.
-func (transactions *Transactions) Balance() *accounts.Balance {
+// processRequestedTx calls the callbacks waiting for the downloaded tx, see doForTransaction().
+// Returns true if a new double spend was detected.
+func (transactions *Transactions) processRequestedTx(txHash chainhash.Hash, tx *wire.MsgTx) (bool, error) {
+	defer transactions.Lock()()
+	dbTx, err := transactions.db.Begin()
+	if err != nil {
+		transactions.log.WithError(err).Panic("Failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+
+	for _, callback := range transactions.requestedTXs[txHash] {
+		callback(dbTx, tx)
+	}
+	delete(transactions.requestedTXs, txHash)
+	newConflict := transactions.updateConflicts(dbTx)
+	return newConflict, dbTx.Commit()
+}
+
+// Balance computes the confirmed and unconfirmed balance of the account. Outputs for which
+// isFrozen returns true are moved from the available or incoming balance to the frozen balance.
+// isFrozen can be nil if no outputs are frozen.
+func (transactions *Transactions) Balance(isFrozen func(wire.OutPoint) bool) *accounts.Balance {
 	transactions.synchronizer.WaitSynchronized()
 	defer transactions.RLock()()
 	dbTx, err := transactions.db.Begin()
@@ -397,10 +628,15 @@ func (transactions *Transactions) Balance() *accounts.Balance {
 		transactions.log.WithError(err).Panic("Failed to retrieve outputs")
 	}
 	defer dbTx.Rollback()
-	var available, incoming int64
+	conflicts := transactions.conflicts
+	var available, incoming, frozen int64
 	for outPoint, txOut := range outputs {
+		// Outputs of transactions which lost against a conflicting transaction will never exist.
+		if _, conflicted := conflicts[outPoint.Hash]; conflicted {
+			continue
+		}
 		// What is spent can not be available nor incoming.
-		if spent := transactions.isInputSpent(dbTx, outPoint); spent {
+		if spent := transactions.isInputSpent(dbTx, outPoint, conflicts); spent {
 			continue
 		}
 		tx, _, height, _, err := dbTx.TxInfo(outPoint.Hash)
@@ -408,13 +644,20 @@ func (transactions *Transactions) Balance() *accounts.Balance {
 			transactions.log.WithError(err).Panic("Failed to retrieve tx info")
 		}
 		confirmed := height > 0
-		if confirmed || transactions.allInputsOurs(dbTx, tx) {
+		switch {
+		case isFrozen != nil && isFrozen(outPoint):
+			frozen += txOut.Value
+		case confirmed || transactions.allInputsOurs(dbTx, tx):
 			available += txOut.Value
-		} else {
+		default:
 			incoming += txOut.Value
 		}
 	}
-	return accounts.NewBalance(coin.NewAmountFromInt64(available), coin.NewAmountFromInt64(incoming))
+	return accounts.NewBalance(
+		coin.NewAmountFromInt64(available),
+		coin.NewAmountFromInt64(incoming),
+		coin.NewAmountFromInt64(frozen),
+	)
 }
 
 // byHeight defines the methods needed to satisify sort.Interface to sort transactions by their
@@ -446,9 +689,12 @@ type TxInfo struct {
 	// Height is the height this tx was confirmed at. 0 (or -1) for unconfirmed.
 	Height           int
 	numConfirmations int
-	txType           accounts.TxType
-	amount           btcutil.Amount
-	fee              *btcutil.Amount
+	// conflictStatus is TxStatusReplaced or TxStatusConflicted if the tx lost against a conflicting
+	// tx, and empty otherwise.
+	conflictStatus accounts.TxStatus
+	txType         accounts.TxType
+	amount         btcutil.Amount
+	fee            *btcutil.Amount
 	// Time of confirmation. nil for unconfirmed tx or when the headers are not synced yet.
 	timestamp *time.Time
 	// addresses money was sent to / received on (without change addresses).
@@ -495,6 +741,9 @@ func (txInfo *TxInfo) Type() accounts.TxType {
 
 // Status implements accounts.Transaction.
 func (txInfo *TxInfo) Status() accounts.TxStatus {
+	if txInfo.conflictStatus != "" {
+		return txInfo.conflictStatus
+	}
 	if txInfo.NumConfirmations() >= 6 {
 		return accounts.TxStatusComplete
 	}
@@ -512,6 +761,9 @@ func (txInfo *TxInfo) Addresses() []accounts.AddressAndAmount {
 }
 
 func (transactions *Transactions) outputToAddress(pkScript []byte) string {
+	if address, ok := taproot.ExtractPkScriptAddress(pkScript, transactions.net); ok {
+		return address.String()
+	}
 	_, extractedAddresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, transactions.net)
 	// unknown addresses and multisig scripts ignored.
 	if err != nil || len(extractedAddresses) != 1 {
@@ -643,13 +895,16 @@ func (transactions *Transactions) Transactions(
 		// TODO
 		panic(err)
 	}
+	conflicts := transactions.conflicts
 	for _, txHash := range txHashes {
 		tx, _, height, timestamp, err := dbTx.TxInfo(txHash)
 		if err != nil {
 			// TODO
 			panic(err)
 		}
-		txs = append(txs, transactions.txInfo(dbTx, tx, height, timestamp, isChange))
+		txInfo := transactions.txInfo(dbTx, tx, height, timestamp, isChange)
+		txInfo.conflictStatus = conflicts[txHash]
+		txs = append(txs, txInfo)
 	}
 	sort.Sort(sort.Reverse(byHeight(txs)))
 	return txs
@@ -19,6 +19,8 @@ import (
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
@@ -28,10 +30,12 @@ import (
 	blockchainpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain"
 	blockchainMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/blockchain/mocks"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/db/transactionsdb"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers"
 	headersMock "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/headers/mocks"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/synchronizer"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/transactions"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
 	"github.com/sirupsen/logrus"
@@ -111,6 +115,7 @@ type transactionsSuite struct {
 	headersMock    *headersMock.Interface
 	notifierMock   *accountsMock.Notifier
 	transactions   *transactions.Transactions
+	events         []accounts.Event
 
 	log *logrus.Entry
 }
@@ -122,14 +127,21 @@ func (s *transactionsSuite) SetupTest() {
 	_, s.addressChain = addressesTest.NewAddressChain()
 	s.synchronizer = synchronizer.NewSynchronizer(func() {}, func() {}, s.log)
 	s.blockchainMock = NewBlockchainMock()
-	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"))
+	// The db is encrypted to also cover the decryption of the stored values.
+	encryptionKey, err := encryption.NewKey()
+	if err != nil {
+		panic(err)
+	}
+	db, err := transactionsdb.NewDB(test.TstTempFile("bitbox-wallet-db-"), encryptionKey)
 	if err != nil {
 		panic(err)
 	}
 	s.headersMock = &headersMock.Interface{}
 	s.headersMock.On("SubscribeEvent", mock.AnythingOfType("func(headers.Event)")).Return(func() {})
 	s.headersMock.On("TipHeight").Return(15).Once()
+	s.headersMock.On("Validation").Return(headers.ValidationAgreed)
 	s.notifierMock = &accountsMock.Notifier{}
+	s.events = nil
 	s.transactions = transactions.NewTransactions(
 		s.net,
 		db,
@@ -137,6 +149,12 @@ func (s *transactionsSuite) SetupTest() {
 		s.synchronizer,
 		s.blockchainMock,
 		s.notifierMock,
+		func(event accounts.Event) {
+			// Events are emitted without holding the lock, so that handlers can access the
+			// transactions.
+			s.transactions.RLock()()
+			s.events = append(s.events, event)
+		},
 		s.log,
 	)
 }
@@ -218,6 +236,7 @@ func newBalance(available, incoming btcutil.Amount) *accounts.Balance {
 	return accounts.NewBalance(
 		coin.NewAmountFromInt64(int64(available)),
 		coin.NewAmountFromInt64(int64(incoming)),
+		coin.NewAmountFromInt64(0),
 	)
 }
 
@@ -235,7 +254,7 @@ func (s *transactionsSuite) TestUpdateAddressHistorySingleTxReceive() {
 	})
 	require.Equal(s.T(),
 		newBalance(expectedAmount, 0),
-		s.transactions.Balance(),
+		s.transactions.Balance(nil),
 	)
 	utxo := &transactions.SpendableOutput{
 		TxOut:   wire.NewTxOut(int64(expectedAmount), address.PubkeyScript()),
@@ -278,12 +297,12 @@ func (s *transactionsSuite) TestUpdateAddressHistoryOppositeOrder() {
 	s.blockchainMock.CallTransactionGetCallbacks(tx1.TxHash())
 	require.Equal(s.T(),
 		newBalance(0, 0),
-		s.transactions.Balance(),
+		s.transactions.Balance(nil),
 	)
 }
 
-// TestSpendableOutputs checks that the utxo set is correct. Only confirmed (or unconfirmed outputs
-// we own) outputs can be spent.
+// TestSpendableOutputs checks that the utxo set is correct. Outputs of confirmed txs and unconfirmed
+// outputs we own can be spent. Unconfirmed incoming outputs are included, but marked as incoming.
 func (s *transactionsSuite) TestSpendableOutputs() {
 	// Starts out empty.
 	require.Empty(s.T(), s.transactions.SpendableOutputs())
@@ -309,11 +328,25 @@ func (s *transactionsSuite) TestSpendableOutputs() {
 		{TXHash: blockchainpkg.TXHash(tx22.TxHash()), Height: 10},
 	})
 
-	spendableOutputs := s.transactions.SpendableOutputs()
+	// spendableOutputs returns the outputs which can be spent in regular transactions.
+	spendableOutputs := func() map[wire.OutPoint]*transactions.SpendableOutput {
+		result := map[wire.OutPoint]*transactions.SpendableOutput{}
+		for outPoint, output := range s.transactions.SpendableOutputs() {
+			if !output.IsIncoming() {
+				result[outPoint] = output
+			}
+		}
+		return result
+	}
+	allOutputs := s.transactions.SpendableOutputs()
+	require.Len(s.T(), allOutputs, 4)
+	require.True(s.T(), allOutputs[wire.OutPoint{Hash: tx11.TxHash(), Index: 0}].IsIncoming())
+	require.True(s.T(), allOutputs[wire.OutPoint{Hash: tx21.TxHash(), Index: 0}].IsIncoming())
 	// Two confirmed txs.
-	require.Len(s.T(), spendableOutputs, 2)
-	require.Contains(s.T(), spendableOutputs, wire.OutPoint{Hash: tx12.TxHash(), Index: 0})
-	require.Contains(s.T(), spendableOutputs, wire.OutPoint{Hash: tx22.TxHash(), Index: 0})
+	outputs := spendableOutputs()
+	require.Len(s.T(), outputs, 2)
+	require.Contains(s.T(), outputs, wire.OutPoint{Hash: tx12.TxHash(), Index: 0})
+	require.Contains(s.T(), outputs, wire.OutPoint{Hash: tx22.TxHash(), Index: 0})
 	// Spend output generated from tx12 to an external address, the spend being unconfirmed => the
 	// output can't be spent anymore.
 	tx12Spend := newTx(tx12.TxHash(), 0, otherAddress, 1000)
@@ -323,10 +356,10 @@ func (s *transactionsSuite) TestSpendableOutputs() {
 		{TXHash: blockchainpkg.TXHash(tx12.TxHash()), Height: 10},
 		{TXHash: blockchainpkg.TXHash(tx12Spend.TxHash()), Height: 0},
 	})
-	spendableOutputs = s.transactions.SpendableOutputs()
-	require.Len(s.T(), spendableOutputs, 1)
-	require.NotContains(s.T(), spendableOutputs, wire.OutPoint{Hash: tx12.TxHash(), Index: 0})
-	require.Contains(s.T(), spendableOutputs, wire.OutPoint{Hash: tx22.TxHash(), Index: 0})
+	outputs = spendableOutputs()
+	require.Len(s.T(), outputs, 1)
+	require.NotContains(s.T(), outputs, wire.OutPoint{Hash: tx12.TxHash(), Index: 0})
+	require.Contains(s.T(), outputs, wire.OutPoint{Hash: tx22.TxHash(), Index: 0})
 	// Send output generated from tx22 to an internal address, unconfirmed. The new output needs to
 	// be spendable, as it is our own.
 	tx22Spend := newTx(tx22.TxHash(), 0, address2, 4000)
@@ -336,16 +369,51 @@ func (s *transactionsSuite) TestSpendableOutputs() {
 		{TXHash: blockchainpkg.TXHash(tx22.TxHash()), Height: 10},
 		{TXHash: blockchainpkg.TXHash(tx22Spend.TxHash()), Height: 0},
 	})
-	spendableOutputs = s.transactions.SpendableOutputs()
-	require.Len(s.T(), spendableOutputs, 1)
+	outputs = spendableOutputs()
+	require.Len(s.T(), outputs, 1)
 	// tx22 spent, not available anymore
-	require.NotContains(s.T(), spendableOutputs, wire.OutPoint{Hash: tx22.TxHash(), Index: 0})
+	require.NotContains(s.T(), outputs, wire.OutPoint{Hash: tx22.TxHash(), Index: 0})
 	// Output from the spend tx address available.
-	require.Contains(s.T(), spendableOutputs, wire.OutPoint{Hash: tx22Spend.TxHash(), Index: 0})
+	require.Contains(s.T(), outputs, wire.OutPoint{Hash: tx22Spend.TxHash(), Index: 0})
+}
+
+// TestSpendableOutputsAncestors checks that unconfirmed outputs come with the whole chain of their
+// unconfirmed ancestors.
+func (s *transactionsSuite) TestSpendableOutputsAncestors() {
+	addresses := s.addressChain.EnsureAddresses()
+	address1 := addresses[0]
+	address2 := addresses[1]
+	tx1 := newTx(chainhash.HashH(nil), 0, address1, 1000)
+	// txParent pays a fee of 100, txChild spends its output paying a fee of 200.
+	txParent := newTx(tx1.TxHash(), 0, address2, 900)
+	txChild := newTx(txParent.TxHash(), 0, address1, 700)
+	s.blockchainMock.RegisterTxs(tx1, txParent, txChild)
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txParent.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txChild.TxHash()), Height: 0},
+	})
+	s.updateAddressHistory(address2, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(txParent.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txChild.TxHash()), Height: 0},
+	})
+	spendableOutputs := s.transactions.SpendableOutputs()
+	require.Len(s.T(), spendableOutputs, 1)
+	output := spendableOutputs[wire.OutPoint{Hash: txChild.TxHash(), Index: 0}]
+	require.NotNil(s.T(), output)
+	require.False(s.T(), output.IsIncoming())
+	require.NotNil(s.T(), output.Ancestors)
+	require.Equal(s.T(), 2, output.Ancestors.Count)
+	require.Equal(s.T(),
+		mempool.GetTxVirtualSize(btcutil.NewTx(txParent))+mempool.GetTxVirtualSize(btcutil.NewTx(txChild)),
+		output.Ancestors.VSize)
+	require.NotNil(s.T(), output.Ancestors.Fee)
+	require.Equal(s.T(), btcutil.Amount(300), *output.Ancestors.Fee)
 }
 
 func (s *transactionsSuite) TestBalance() {
-	require.Equal(s.T(), newBalance(0, 0), s.transactions.Balance())
+	require.Equal(s.T(), newBalance(0, 0), s.transactions.Balance(nil))
 	addresses := s.addressChain.EnsureAddresses()
 	address1 := addresses[0]
 	otherAddress := addresses[2]
@@ -362,7 +430,7 @@ func (s *transactionsSuite) TestBalance() {
 	})
 	require.Equal(s.T(),
 		newBalance(0, expectedAmount),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	// Confirm it, plus another one incoming.
 	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
 	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
@@ -371,7 +439,7 @@ func (s *transactionsSuite) TestBalance() {
 	})
 	require.Equal(s.T(),
 		newBalance(expectedAmount, expectedAmount2),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	// Spend funds that came from tx1, first unconfirmed. Available balance decreases.
 	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
 		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
@@ -380,7 +448,7 @@ func (s *transactionsSuite) TestBalance() {
 	})
 	require.Equal(s.T(),
 		newBalance(0, expectedAmount2),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	// Confirm it.
 	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
 	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
@@ -390,7 +458,7 @@ func (s *transactionsSuite) TestBalance() {
 	})
 	require.Equal(s.T(),
 		newBalance(0, expectedAmount2),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	// Spend the unconfirmed incoming tx to an internal address, unconfirmed (can't confirm until
 	// the first one is). The funds are still available as we own the unconfirmed output.
 	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
@@ -401,7 +469,39 @@ func (s *transactionsSuite) TestBalance() {
 	})
 	require.Equal(s.T(),
 		newBalance(expectedAmount2, 0),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
+}
+
+// TestBalanceFrozen checks that frozen outputs are moved from the balance they would otherwise be
+// counted in to the frozen balance.
+func (s *transactionsSuite) TestBalanceFrozen() {
+	addresses := s.addressChain.EnsureAddresses()
+	address := addresses[0]
+	confirmedTx := newTx(chainhash.HashH(nil), 0, address, 100)
+	incomingTx := newTx(chainhash.HashH(nil), 1, address, 20)
+	s.blockchainMock.RegisterTxs(confirmedTx, incomingTx)
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(confirmedTx.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(incomingTx.TxHash()), Height: 0},
+	})
+	frozenOutPoints := map[wire.OutPoint]bool{}
+	isFrozen := func(outPoint wire.OutPoint) bool { return frozenOutPoints[outPoint] }
+	newFrozenBalance := func(available, incoming, frozen int64) *accounts.Balance {
+		return accounts.NewBalance(
+			coin.NewAmountFromInt64(available),
+			coin.NewAmountFromInt64(incoming),
+			coin.NewAmountFromInt64(frozen),
+		)
+	}
+	require.Equal(s.T(), newFrozenBalance(100, 20, 0), s.transactions.Balance(isFrozen))
+
+	// A frozen incoming output is not subtracted from the available balance.
+	frozenOutPoints[wire.OutPoint{Hash: incomingTx.TxHash()}] = true
+	require.Equal(s.T(), newFrozenBalance(100, 0, 20), s.transactions.Balance(isFrozen))
+
+	frozenOutPoints[wire.OutPoint{Hash: confirmedTx.TxHash()}] = true
+	require.Equal(s.T(), newFrozenBalance(0, 0, 120), s.transactions.Balance(isFrozen))
 }
 
 func (s *transactionsSuite) TestRemoveTransaction() {
@@ -428,7 +528,7 @@ func (s *transactionsSuite) TestRemoveTransaction() {
 	})
 	require.Equal(s.T(),
 		newBalance(2+10+34, 0),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	// Remove tx3 from the history of address1. It is still referenced by address2, so the index
 	// does not change.
 	tx3Hash := tx3.TxHash()
@@ -438,7 +538,7 @@ func (s *transactionsSuite) TestRemoveTransaction() {
 	})
 	require.Equal(s.T(),
 		newBalance(2+10+34, 0),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	require.Len(s.T(),
 		s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false }),
 		3)
@@ -448,7 +548,7 @@ func (s *transactionsSuite) TestRemoveTransaction() {
 	})
 	require.Equal(s.T(),
 		newBalance(12+34, 0),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	require.Len(s.T(),
 		s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false }),
 		2)
@@ -469,7 +569,103 @@ func (s *transactionsSuite) TestRemoveTransactionPendingDownload() {
 	s.blockchainMock.CallAllTransactionGetCallbacks()
 	require.Equal(s.T(),
 		newBalance(0, 0),
-		s.transactions.Balance())
+		s.transactions.Balance(nil))
 	require.Empty(s.T(),
 		s.transactions.Transactions(func(blockchainpkg.ScriptHashHex) bool { return false }))
 }
+
+func (s *transactionsSuite) txStatuses() map[chainhash.Hash]accounts.TxStatus {
+	statuses := map[chainhash.Hash]accounts.TxStatus{}
+	for _, txInfo := range s.transactions.Transactions(
+		func(blockchainpkg.ScriptHashHex) bool { return false }) {
+		statuses[txInfo.Tx.TxHash()] = txInfo.Status()
+	}
+	return statuses
+}
+
+// TestDoubleSpend tests that all transactions spending the same output are kept, and that the
+// losing side of the conflict is excluded from the balance.
+func (s *transactionsSuite) TestDoubleSpend() {
+	addresses := s.addressChain.EnsureAddresses()
+	address1 := addresses[0]
+	address2 := addresses[1]
+	tx1 := newTx(chainhash.HashH(nil), 0, address1, 1000)
+	// txOriginal sends to address2 paying a fee of 100. txChild spends its output.
+	txOriginal := newTx(tx1.TxHash(), 0, address2, 900)
+	txChild := newTx(txOriginal.TxHash(), 0, address2, 800)
+	// txReplacement spends the same output paying a fee of 300.
+	txReplacement := newTx(tx1.TxHash(), 0, address1, 700)
+	s.blockchainMock.RegisterTxs(tx1, txOriginal, txChild, txReplacement)
+
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txOriginal.TxHash()), Height: 0},
+	})
+	s.updateAddressHistory(address2, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(txOriginal.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txChild.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), newBalance(800, 0), s.transactions.Balance(nil))
+	require.Empty(s.T(), s.events)
+
+	// The replacement appears while the original is still in the history of address2.
+	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txReplacement.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), []accounts.Event{accounts.EventTxConflict}, s.events)
+	require.Equal(s.T(), newBalance(700, 0), s.transactions.Balance(nil))
+	spendableOutputs := s.transactions.SpendableOutputs()
+	require.Len(s.T(), spendableOutputs, 1)
+	require.Contains(s.T(), spendableOutputs, wire.OutPoint{Hash: txReplacement.TxHash(), Index: 0})
+	statuses := s.txStatuses()
+	require.Equal(s.T(), accounts.TxStatusConflicted, statuses[txOriginal.TxHash()])
+	require.Equal(s.T(), accounts.TxStatusConflicted, statuses[txChild.TxHash()])
+	require.Equal(s.T(), accounts.TxStatusPending, statuses[txReplacement.TxHash()])
+
+	// The replacement is confirmed.
+	s.headersMock.On("HeaderByHeight", 11).Return(nil, nil).Once()
+	s.updateAddressHistory(address1, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txReplacement.TxHash()), Height: 11},
+	})
+	require.Equal(s.T(), newBalance(700, 0), s.transactions.Balance(nil))
+	statuses = s.txStatuses()
+	require.Equal(s.T(), accounts.TxStatusReplaced, statuses[txOriginal.TxHash()])
+	require.Equal(s.T(), accounts.TxStatusReplaced, statuses[txChild.TxHash()])
+
+	// The original disappears from the history. The conflict is resolved.
+	txOriginalHash := txOriginal.TxHash()
+	txChildHash := txChild.TxHash()
+	s.notifierMock.On("Delete", txOriginalHash[:]).Return(nil).Once()
+	s.notifierMock.On("Delete", txChildHash[:]).Return(nil).Once()
+	s.updateAddressHistory(address2, []*blockchainpkg.TxInfo{})
+	require.Equal(s.T(), newBalance(700, 0), s.transactions.Balance(nil))
+	require.Len(s.T(), s.txStatuses(), 2)
+}
+
+// TestDoubleSpendFeeRate tests that among unconfirmed conflicting transactions, the one paying the
+// higher fee rate wins, not the one paying the higher absolute fee.
+func (s *transactionsSuite) TestDoubleSpendFeeRate() {
+	address := s.addressChain.EnsureAddresses()[0]
+	tx1 := newTx(chainhash.HashH(nil), 0, address, 1000)
+	// txLarge pays a fee of 300, but is large due to a big OP_RETURN output.
+	txLarge := newTx(tx1.TxHash(), 0, address, 700)
+	opReturn := append([]byte{txscript.OP_RETURN, txscript.OP_PUSHDATA2, 0xe8, 0x03}, make([]byte, 1000)...)
+	txLarge.AddTxOut(wire.NewTxOut(0, opReturn))
+	// txSmall pays a fee of 200 at a much higher fee rate.
+	txSmall := newTx(tx1.TxHash(), 0, address, 800)
+	s.blockchainMock.RegisterTxs(tx1, txLarge, txSmall)
+
+	s.headersMock.On("HeaderByHeight", 10).Return(nil, nil).Once()
+	s.updateAddressHistory(address, []*blockchainpkg.TxInfo{
+		{TXHash: blockchainpkg.TXHash(tx1.TxHash()), Height: 10},
+		{TXHash: blockchainpkg.TXHash(txLarge.TxHash()), Height: 0},
+		{TXHash: blockchainpkg.TXHash(txSmall.TxHash()), Height: 0},
+	})
+	require.Equal(s.T(), newBalance(800, 0), s.transactions.Balance(nil))
+	statuses := s.txStatuses()
+	require.Equal(s.T(), accounts.TxStatusConflicted, statuses[txLarge.TxHash()])
+	require.Equal(s.T(), accounts.TxStatusPending, statuses[txSmall.TxHash()])
+}
@@ -24,7 +24,7 @@ import (
 
 func (transactions *Transactions) onHeadersEvent(event headers.Event) {
 	switch event {
-	case headers.EventSynced:
+	case headers.EventSynced, headers.EventTipAgreed, headers.EventTipUnvalidated:
 		transactions.verifyTransactions()
 	case headers.EventNewTip:
 		done := transactions.synchronizer.IncRequestsCounter()
@@ -81,6 +81,14 @@ func (transactions *Transactions) verifyTransaction(txHash chainhash.Hash, heigh
 	if height <= 0 {
 		return
 	}
+	switch transactions.headers.Validation() {
+	case headers.ValidationAgreed:
+	case headers.ValidationUnavailable:
+		transactions.log.Debugf("Tip could not be cross-validated, verifying tx against the synced headers")
+	default:
+		transactions.log.Debugf("Tip not agreed by the majority of servers, couldn't verify tx")
+		return
+	}
 	header, err := transactions.headers.HeaderByHeight(height)
 	if err != nil {
 		// TODO
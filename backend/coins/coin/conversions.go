@@ -3,6 +3,7 @@ package coin
 import (
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/rates"
 )
@@ -17,15 +18,32 @@ func formatAsCurrency(amount float64) string {
 	return formatted
 }
 
+// unpricedCoin is implemented by coins whose unit does not reliably identify them, e.g. user-added
+// erc20 tokens, whose unit is the symbol reported by the contract.
+type unpricedCoin interface {
+	// HasRates returns false if the rates of the unit must not be used for this coin.
+	HasRates(isFee bool) bool
+}
+
+// RatesUnit returns the unit of the coin as used by the rates updater, or "" if the coin has no
+// rates. Testnet coins use the rates of their mainnet coin.
+func RatesUnit(coin Coin, isFee bool) string {
+	if unpriced, ok := coin.(unpricedCoin); ok && !unpriced.HasRates(isFee) {
+		return ""
+	}
+	unit := coin.Unit(isFee)
+	if len(unit) == 4 && strings.HasPrefix(unit, "T") || unit == "RETH" {
+		unit = unit[1:]
+	}
+	return unit
+}
+
 // Conversions handles fiat conversions
 func Conversions(amount Amount, coin Coin, isFee bool, ratesUpdater *rates.RateUpdater) map[string]string {
 	var conversions map[string]string
 	rates := ratesUpdater.Last()
-	if rates != nil {
-		unit := coin.Unit(isFee)
-		if len(unit) == 4 && strings.HasPrefix(unit, "T") || unit == "RETH" {
-			unit = unit[1:]
-		}
+	unit := RatesUnit(coin, isFee)
+	if rates != nil && unit != "" {
 		float := coin.ToUnit(amount, isFee)
 		conversions = map[string]string{}
 		for key, value := range rates[unit] {
@@ -34,3 +52,23 @@ func Conversions(amount Amount, coin Coin, isFee bool, ratesUpdater *rates.RateU
 	}
 	return conversions
 }
+
+// HistoricalConversions handles fiat conversions at the given time, using only the cached
+// historical rates. The second return value is false if some of the rates are not cached yet.
+func HistoricalConversions(
+	amount Amount, coin Coin, isFee bool, date time.Time, ratesUpdater *rates.RateUpdater,
+) (map[string]string, bool) {
+	unit := RatesUnit(coin, isFee)
+	float := coin.ToUnit(amount, isFee)
+	conversions := map[string]string{}
+	complete := true
+	for _, fiat := range ratesUpdater.Fiats() {
+		rate, ok := ratesUpdater.CachedHistoricalRate(unit, fiat, date)
+		if !ok {
+			complete = false
+			continue
+		}
+		conversions[fiat] = formatAsCurrency(float * rate)
+	}
+	return conversions, complete
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/rates"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	ethereum "github.com/ethereum/go-ethereum"
@@ -47,6 +48,11 @@ import (
 
 var pollInterval = 30 * time.Second
 
+// ErrDynamicFeeUnsupported is returned by keystores which can only sign legacy transactions. Such
+// keystores report it via keystore.Keystore.SupportsEIP1559(), so that legacy transactions are
+// created for them in the first place.
+var ErrDynamicFeeUnsupported = errp.New("The keystore does not support EIP-1559 transactions")
+
 // Account is an Ethereum account, with one address.
 type Account struct {
 	locker.Locker
@@ -54,9 +60,11 @@ type Account struct {
 	synchronizer            *synchronizer.Synchronizer
 	coin                    *Coin
 	dbFolder                string
+	dbEncryptionKey         *encryption.Key
 	db                      db.Interface
 	code                    string
 	name                    string
+	nameLock                locker.Locker
 	getSigningConfiguration func() (*signing.Configuration, error)
 	signingConfiguration    *signing.Configuration
 	keystores               *keystore.Keystores
@@ -76,6 +84,9 @@ type Account struct {
 
 	nextNonce    uint64
 	transactions []accounts.Transaction
+	// feeTargets are derived from the fee history. nil if the node does not support EIP-1559, in
+	// which case legacy transactions with the suggested gas price are created.
+	feeTargets []*FeeTarget
 
 	quitChan chan struct{}
 
@@ -87,6 +98,7 @@ type Account struct {
 func NewAccount(
 	accountCoin *Coin,
 	dbFolder string,
+	dbEncryptionKey *encryption.Key,
 	code string,
 	name string,
 	getSigningConfiguration func() (*signing.Configuration, error),
@@ -103,6 +115,7 @@ func NewAccount(
 	account := &Account{
 		coin:                    accountCoin,
 		dbFolder:                dbFolder,
+		dbEncryptionKey:         dbEncryptionKey,
 		code:                    code,
 		name:                    name,
 		getSigningConfiguration: getSigningConfiguration,
@@ -146,9 +159,16 @@ func (account *Account) Code() string {
 
 // Name implements accounts.Interface.
 func (account *Account) Name() string {
+	defer account.nameLock.RLock()()
 	return account.name
 }
 
+// SetName changes the name of the account.
+func (account *Account) SetName(name string) {
+	defer account.nameLock.Lock()()
+	account.name = name
+}
+
 // Coin implements accounts.Interface.
 func (account *Account) Coin() coin.Coin {
 	return account.coin
@@ -185,7 +205,7 @@ func (account *Account) Initialize() error {
 
 	dbName := fmt.Sprintf("account-%s-%s.db", account.signingConfiguration.Hash(), account.code)
 	account.log.Debugf("Opening the database '%s' to persist the transactions.", dbName)
-	db, err := db.NewDB(path.Join(account.dbFolder, dbName))
+	db, err := db.NewDB(path.Join(account.dbFolder, dbName), account.dbEncryptionKey)
 	if err != nil {
 		return err
 	}
@@ -267,8 +287,24 @@ func (account *Account) updateOutgoingTransactions(tipHeight uint64) {
 		return
 	}
 
+	// minedTxs contains the transaction which was mined per nonce.
+	minedTxs := map[uint64]*ethtypes.TransactionWithMetadata{}
+
+	// minedNonces contains the nonces for which a stored transaction is known to be mined.
+	minedNonces := map[uint64]struct{}{}
+	for _, tx := range outgoingTransactions {
+		if tx.Height != 0 && !tx.Dropped {
+			minedNonces[tx.Transaction.Nonce()] = struct{}{}
+		}
+	}
+
 	// Update the stored txs' metadata if up to 12 confirmations.
 	for _, tx := range outgoingTransactions {
+		// A dropped tx can't be mined anymore once another tx with the same nonce was mined. Replaced
+		// txs are marked as dropped before, so they are polled until either tx is mined.
+		if _, ok := minedNonces[tx.Transaction.Nonce()]; ok && tx.Dropped {
+			continue
+		}
 		remoteTx, err := account.coin.client.TransactionReceiptWithBlockNumber(context.TODO(), tx.Transaction.Hash())
 		if err != nil {
 			account.log.WithError(err).Error("could not fetch transaction")
@@ -277,17 +313,34 @@ func (account *Account) updateOutgoingTransactions(tipHeight uint64) {
 		if remoteTx == nil {
 			continue
 		}
+		minedTxs[tx.Transaction.Nonce()] = tx
 		success := remoteTx.Status == types.ReceiptStatusSuccessful
-		if tx.Height == 0 || (tipHeight-remoteTx.BlockNumber) < ethtypes.NumConfirmationsComplete || tx.Success != success {
+		if tx.Height == 0 || (tipHeight-remoteTx.BlockNumber) < ethtypes.NumConfirmationsComplete ||
+			tx.Success != success || tx.Dropped {
 			tx.Height = remoteTx.BlockNumber
 			tx.GasUsed = remoteTx.GasUsed
+			tx.EffectiveGasPrice = remoteTx.EffectiveGasPrice
 			tx.Success = success
+			tx.Dropped = false
 			if err := dbTx.PutOutgoingTransaction(tx); err != nil {
 				account.log.WithError(err).Error("could not update outgoing tx")
 				continue
 			}
 		}
 	}
+	// Only one transaction per nonce can be mined. All others are dropped, which also covers the
+	// case that a replaced transaction was mined instead of its replacement.
+	for _, tx := range outgoingTransactions {
+		minedTx, ok := minedTxs[tx.Transaction.Nonce()]
+		if !ok || minedTx == tx || (tx.Dropped && tx.Height == 0) {
+			continue
+		}
+		tx.Dropped = true
+		tx.Height = 0
+		if err := dbTx.PutOutgoingTransaction(tx); err != nil {
+			account.log.WithError(err).Error("could not update dropped outgoing tx")
+		}
+	}
 	if err := dbTx.Commit(); err != nil {
 		account.log.WithError(err).Error("could not commit db tx")
 		return
@@ -320,6 +373,15 @@ func (account *Account) outgoingTransactions(allTxs []accounts.Transaction) (
 		if _, ok := allTxHashes[tx.ID()]; ok {
 			continue
 		}
+		// Skip txs which were replaced or will never be mined.
+		if tx.Dropped {
+			continue
+		}
+		// Cancellations of token transfers are ETH self-sends, which are not token transactions.
+		if account.coin.erc20Token != nil &&
+			!ethtypes.IsERC20Transfer(tx.Transaction, account.coin.erc20Token) {
+			continue
+		}
 		transactions = append(transactions,
 			ethtypes.NewTransactionWithConfirmations(tx, account.blockNumber.Uint64(), account.coin.erc20Token))
 	}
@@ -335,6 +397,8 @@ func (account *Account) update() error {
 	}
 	account.blockNumber = header.Number
 
+	account.updateFeeTargets()
+
 	transactionsSource := account.coin.TransactionsSource()
 
 	go account.updateOutgoingTransactions(account.blockNumber.Uint64())
@@ -402,6 +466,28 @@ func (account *Account) update() error {
 	return nil
 }
 
+// updateFeeTargets derives the fee targets from the fee history of the latest blocks.
+func (account *Account) updateFeeTargets() {
+	percentiles := make([]float64, len(feeTargetPercentiles))
+	for i, target := range feeTargetPercentiles {
+		percentiles[i] = target.percentile
+	}
+	var feeTargets []*FeeTarget
+	feeHistory, err := account.coin.client.FeeHistory(context.TODO(), feeHistoryBlocks, percentiles)
+	if err != nil {
+		account.log.WithError(err).Debug("Could not get the fee history, using legacy transactions")
+	} else {
+		feeTargets = newFeeTargets(feeHistory)
+	}
+	unlock := account.Lock()
+	changed := !feeTargetsEqual(account.feeTargets, feeTargets)
+	account.feeTargets = feeTargets
+	unlock()
+	if changed {
+		account.onEvent(accounts.EventFeeTargetsChanged)
+	}
+}
+
 // Initialized implements accounts.Interface.
 func (account *Account) Initialized() bool {
 	return account.initialized
@@ -447,36 +533,90 @@ func (account *Account) Transactions() ([]accounts.Transaction, error) {
 // Balance implements accounts.Interface.
 func (account *Account) Balance() (*accounts.Balance, error) {
 	account.synchronizer.WaitSynchronized()
-	return accounts.NewBalance(account.balance, coin.NewAmountFromInt64(0)), nil
+	return accounts.NewBalance(account.balance, coin.NewAmountFromInt64(0), coin.NewAmountFromInt64(0)), nil
 }
 
 // TxProposal holds all info needed to create and sign a transacstion.
 type TxProposal struct {
 	Coin coin.Coin
-	Tx   *types.Transaction
-	Fee  *big.Int
+	// Tx is a legacy transaction (*types.Transaction) or an EIP-1559 transaction
+	// (*ethtypes.DynamicFeeTx).
+	Tx ethtypes.Transaction
+	// Fee is the expected fee. For EIP-1559 transactions, this is the gas limit times the base fee
+	// plus the priority fee, while up to the gas limit times the max fee per gas can be paid if the
+	// base fee rises.
+	Fee *big.Int
 	// Value can be the same as Tx.Value(), but in case of e.g. ERC20, tx.Value() is zero, while the
 	// Token value is encoded in the contract input data.
 	Value *big.Int
-	// Signer contains the sighash algo, which depends on the block number.
+	// Signer contains the sighash algo of legacy transactions, which depends on the block number.
 	Signer types.Signer
 	// KeyPath is the location of this account's address/pubkey/privkey.
 	Keypath signing.AbsoluteKeypath
 }
 
+// erc20TransferData returns the contract input data of an ERC20 token transfer.
+func erc20TransferData(recipient common.Address, value *big.Int) []byte {
+	parsed, err := abi.JSON(strings.NewReader(erc20.IERC20ABI))
+	if err != nil {
+		panic(errp.WithStack(err))
+	}
+	data, err := parsed.Pack("transfer", &recipient, value)
+	if err != nil {
+		panic(errp.WithStack(err))
+	}
+	return data
+}
+
+// feeTarget returns the fee target with the given code, or nil if there are no fee targets.
+func (account *Account) feeTarget(feeTargetCode accounts.FeeTargetCode) (*FeeTarget, error) {
+	defer account.RLock()()
+	if account.feeTargets == nil {
+		return nil, nil
+	}
+	for _, feeTarget := range account.feeTargets {
+		if feeTarget.code == feeTargetCode {
+			return feeTarget, nil
+		}
+	}
+	return nil, errp.Newf("Could not find fee target %s", feeTargetCode)
+}
+
+// newTx creates a transaction paying the fees of the given fee target. An EIP-1559 transaction is
+// created if the fee targets are known and legacy is false, otherwise a legacy transaction.
 func (account *Account) newTx(
 	recipientAddress string,
 	amount coin.SendAmount,
+	feeTargetCode accounts.FeeTargetCode,
 	data []byte,
+	legacy bool,
 ) (*TxProposal, error) {
 	if !common.IsHexAddress(recipientAddress) {
 		return nil, errp.WithStack(errors.ErrInvalidAddress)
 	}
 
-	suggestedGasPrice, err := account.coin.client.SuggestGasPrice(context.TODO())
+	feeTarget, err := account.feeTarget(feeTargetCode)
 	if err != nil {
 		return nil, err
 	}
+	// gasPrice is the highest price per gas which can be paid by the transaction, while
+	// expectedGasPrice is the price per gas expected to be paid. They only differ for EIP-1559
+	// transactions, where the base fee can rise until the transaction is mined.
+	var gasPrice, expectedGasPrice *big.Int
+	switch {
+	case feeTarget == nil:
+		gasPrice, err = account.coin.client.SuggestGasPrice(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		expectedGasPrice = gasPrice
+	case legacy:
+		gasPrice = feeTarget.GasPrice()
+		expectedGasPrice = gasPrice
+	default:
+		gasPrice = feeTarget.GasFeeCap()
+		expectedGasPrice = feeTarget.GasPrice()
+	}
 
 	var value *big.Int
 	if amount.SendAll() {
@@ -495,20 +635,13 @@ func (account *Account) newTx(
 	var message ethereum.CallMsg
 
 	if account.coin.erc20Token != nil {
-		parsed, err := abi.JSON(strings.NewReader(erc20.IERC20ABI))
-		if err != nil {
-			panic(errp.WithStack(err))
-		}
-		erc20ContractData, err := parsed.Pack("transfer", &address, value)
-		if err != nil {
-			panic(errp.WithStack(err))
-		}
+		erc20ContractData := erc20TransferData(address, value)
 		contractAddress := account.coin.erc20Token.ContractAddress()
 		message = ethereum.CallMsg{
 			From:     account.address.Address,
 			To:       &contractAddress,
 			Gas:      0,
-			GasPrice: suggestedGasPrice,
+			GasPrice: gasPrice,
 			Value:    big.NewInt(0),
 			Data:     erc20ContractData,
 		}
@@ -518,7 +651,7 @@ func (account *Account) newTx(
 			From:     account.address.Address,
 			To:       &address,
 			Gas:      0,
-			GasPrice: suggestedGasPrice,
+			GasPrice: gasPrice,
 			Value:    value,
 			Data:     data,
 		}
@@ -540,7 +673,9 @@ func (account *Account) newTx(
 		return nil, errp.WithStack(errors.ErrInvalidData)
 	}
 
-	fee := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), suggestedGasPrice)
+	// maxFee is reserved from the balance, so the transaction stays valid if the base fee rises.
+	maxFee := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), gasPrice)
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), expectedGasPrice)
 
 	// Adjust amount with fee
 	if account.coin.erc20Token != nil {
@@ -553,22 +688,28 @@ func (account *Account) newTx(
 	} else {
 		if amount.SendAll() {
 			// Set the value correctly and check that the fee is smaller than or equal to the balance.
-			value = new(big.Int).Sub(account.balance.BigInt(), fee)
+			value = new(big.Int).Sub(account.balance.BigInt(), maxFee)
 			message.Value = value
 			if message.Value.Sign() < 0 {
 				return nil, errp.WithStack(errors.ErrInsufficientFunds)
 			}
 		} else {
 			// Check that the entered value and the estimated fee are not greater than the balance.
-			total := new(big.Int).Add(message.Value, fee)
+			total := new(big.Int).Add(message.Value, maxFee)
 			if total.Cmp(account.balance.BigInt()) == 1 {
 				return nil, errp.WithStack(errors.ErrInsufficientFunds)
 			}
 		}
 	}
-	tx := types.NewTransaction(account.nextNonce,
-		*message.To,
-		message.Value, gasLimit, suggestedGasPrice, message.Data)
+	var tx ethtypes.Transaction
+	if feeTarget != nil && !legacy {
+		tx = ethtypes.NewDynamicFeeTx(account.coin.Net().ChainID, account.nextNonce,
+			*message.To, message.Value, gasLimit, feeTarget.gasTipCap, gasPrice, message.Data)
+	} else {
+		tx = types.NewTransaction(account.nextNonce,
+			*message.To,
+			message.Value, gasLimit, gasPrice, message.Data)
+	}
 	return &TxProposal{
 		Coin:    account.coin,
 		Tx:      tx,
@@ -580,7 +721,7 @@ func (account *Account) newTx(
 }
 
 // storePendingOutgoingTransaction puts an outgoing tx into the db with height 0 (pending).
-func (account *Account) storePendingOutgoingTransaction(transaction *types.Transaction) error {
+func (account *Account) storePendingOutgoingTransaction(transaction ethtypes.Transaction) error {
 	dbTx, err := account.db.Begin()
 	if err != nil {
 		return err
@@ -600,24 +741,51 @@ func (account *Account) storePendingOutgoingTransaction(transaction *types.Trans
 	return nil
 }
 
+// legacyOnly returns true if the keystores can only sign legacy transactions, in which case no
+// EIP-1559 transactions must be proposed.
+func (account *Account) legacyOnly() bool {
+	return !account.keystores.SupportsEIP1559()
+}
+
+// signTx creates a transaction using newTx and signs it with the keystores. The transaction type
+// is the same one which is shown in the proposal, see legacyOnly().
+func (account *Account) signTx(newTx func(legacy bool) (*TxProposal, error)) (*TxProposal, error) {
+	txProposal, err := newTx(account.legacyOnly())
+	if err != nil {
+		return nil, err
+	}
+	if err := account.keystores.SignTransaction(txProposal); err != nil {
+		return nil, err
+	}
+	return txProposal, nil
+}
+
+// broadcast sends the signed transaction to the network.
+func (account *Account) broadcast(tx ethtypes.Transaction) error {
+	encodedTx, err := ethtypes.EncodeTransaction(tx)
+	if err != nil {
+		return err
+	}
+	return account.coin.client.SendRawTransaction(context.TODO(), encodedTx)
+}
+
 // SendTx implements accounts.Interface.
 func (account *Account) SendTx(
 	recipientAddress string,
 	amount coin.SendAmount,
-	_ accounts.FeeTargetCode,
+	feeTargetCode accounts.FeeTargetCode,
 	_ map[wire.OutPoint]struct{},
 	data []byte) error {
 	account.log.Info("Signing and sending transaction")
-	txProposal, err := account.newTx(recipientAddress, amount, data)
+	txProposal, err := account.signTx(func(legacy bool) (*TxProposal, error) {
+		return account.newTx(recipientAddress, amount, feeTargetCode, data, legacy)
+	})
 	if err != nil {
 		return err
 	}
-	if err := account.keystores.SignTransaction(txProposal); err != nil {
+	if err := account.broadcast(txProposal.Tx); err != nil {
 		return err
 	}
-	if err := account.coin.client.SendTransaction(context.TODO(), txProposal.Tx); err != nil {
-		return errp.WithStack(err)
-	}
 	if err := account.storePendingOutgoingTransaction(txProposal.Tx); err != nil {
 		return err
 	}
@@ -625,20 +793,29 @@ func (account *Account) SendTx(
 	return nil
 }
 
-// FeeTargets implements accounts.Interface.
+// FeeTargets implements accounts.Interface. No fee targets are returned if the node does not
+// support EIP-1559.
 func (account *Account) FeeTargets() ([]accounts.FeeTarget, accounts.FeeTargetCode) {
-	return nil, ""
+	defer account.RLock()()
+	if account.feeTargets == nil {
+		return nil, ""
+	}
+	feeTargets := make([]accounts.FeeTarget, len(account.feeTargets))
+	for i, feeTarget := range account.feeTargets {
+		feeTargets[i] = feeTarget
+	}
+	return feeTargets, accounts.DefaultFeeTarget
 }
 
 // TxProposal implements accounts.Interface.
 func (account *Account) TxProposal(
 	recipientAddress string,
 	amount coin.SendAmount,
-	_ accounts.FeeTargetCode,
+	feeTargetCode accounts.FeeTargetCode,
 	_ map[wire.OutPoint]struct{},
 	data []byte) (coin.Amount, coin.Amount, coin.Amount, error) {
 
-	txProposal, err := account.newTx(recipientAddress, amount, data)
+	txProposal, err := account.newTx(recipientAddress, amount, feeTargetCode, data, account.legacyOnly())
 	if err != nil {
 		return coin.Amount{}, coin.Amount{}, coin.Amount{}, err
 	}
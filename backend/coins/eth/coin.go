@@ -62,6 +62,7 @@ type Coin struct {
 	blockExplorerTxPrefix string
 	nodeURL               string
 	erc20Token            *erc20.Token
+	withoutRates          bool
 
 	makeTransactionsSource TransactionsSourceMaker
 	transactionsSource     TransactionsSource
@@ -190,7 +191,26 @@ func (coin *Coin) SmallestUnit() string {
 	return "wei"
 }
 
+// WithoutRates disables the fiat rates of the coin, e.g. for user-added erc20 tokens, whose symbol
+// could be the unit of another coin. The fee unit is not affected.
+func (coin *Coin) WithoutRates() *Coin {
+	coin.withoutRates = true
+	return coin
+}
+
+// HasRates returns false if the fiat rates of the coin are disabled, see WithoutRates().
+func (coin *Coin) HasRates(isFee bool) bool {
+	return isFee || !coin.withoutRates
+}
+
 // ERC20Token returns nil for a normal Ethereum coin, or the erc20 token details for an erc20 token.
 func (coin *Coin) ERC20Token() *erc20.Token {
 	return coin.erc20Token
 }
+
+// ERC20TokenInfo queries the name, symbol and decimals of the erc20 token deployed at the given
+// contract address, see erc20.QueryTokenInfo().
+func (coin *Coin) ERC20TokenInfo(contractAddress string) (*erc20.TokenInfo, error) {
+	coin.Initialize()
+	return erc20.QueryTokenInfo(contractAddress, coin.client)
+}
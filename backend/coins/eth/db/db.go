@@ -21,6 +21,7 @@ import (
 
 	bbolt "github.com/coreos/bbolt"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/types"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 )
@@ -31,16 +32,18 @@ const (
 
 // DB is a bbolt key/value database.
 type DB struct {
-	db *bbolt.DB
+	db            *bbolt.DB
+	encryptionKey *encryption.Key
 }
 
-// NewDB creates/opens a new db.
-func NewDB(filename string) (*DB, error) {
+// NewDB creates/opens a new db. If the key is not nil, the records are encrypted with it, see
+// encryption.Key.EncryptRecord.
+func NewDB(filename string, encryptionKey *encryption.Key) (*DB, error) {
 	db, err := bbolt.Open(filename, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: db}, nil
+	return &DB{db: db, encryptionKey: encryptionKey}, nil
 }
 
 // Begin implements transactions.Begin.
@@ -55,6 +58,7 @@ func (db *DB) Begin() (TxInterface, error) {
 	}
 	return &Tx{
 		tx:                         tx,
+		encryptionKey:              db.encryptionKey,
 		bucketOutgoingTransactions: bucketOutgoingTransactions,
 	}, nil
 }
@@ -66,7 +70,8 @@ func (db *DB) Close() error {
 
 // Tx implements DBTxInterface.
 type Tx struct {
-	tx *bbolt.Tx
+	tx            *bbolt.Tx
+	encryptionKey *encryption.Key
 
 	bucketOutgoingTransactions *bbolt.Bucket
 }
@@ -84,9 +89,12 @@ func (tx *Tx) Commit() error {
 
 // PutOutgoingTransaction implements DBTxInterface.
 func (tx *Tx) PutOutgoingTransaction(transaction *types.TransactionWithMetadata) error {
-	return tx.bucketOutgoingTransactions.Put(
-		transaction.Transaction.Hash().Bytes(),
-		jsonp.MustMarshal(transaction))
+	dbKey, dbValue, err := tx.encryptionKey.EncryptRecord(
+		transaction.Transaction.Hash().Bytes(), jsonp.MustMarshal(transaction))
+	if err != nil {
+		return err
+	}
+	return tx.bucketOutgoingTransactions.Put(dbKey, dbValue)
 }
 
 type byNonce []*types.TransactionWithMetadata
@@ -99,7 +107,11 @@ func (txs byNonce) Swap(i, j int)      { txs[i], txs[j] = txs[j], txs[i] }
 func (tx *Tx) OutgoingTransactions() ([]*types.TransactionWithMetadata, error) {
 	transactions := []*types.TransactionWithMetadata{}
 	cursor := tx.bucketOutgoingTransactions.Cursor()
-	for txHash, txSerialized := cursor.First(); txSerialized != nil; txHash, txSerialized = cursor.Next() {
+	for dbKey, dbValue := cursor.First(); dbValue != nil; dbKey, dbValue = cursor.Next() {
+		txHash, txSerialized, err := tx.encryptionKey.DecryptRecord(dbKey, dbValue)
+		if err != nil {
+			return nil, err
+		}
 		transaction := new(types.TransactionWithMetadata)
 		if err := json.Unmarshal(txSerialized, transaction); err != nil {
 			return nil, errp.WithStack(err)
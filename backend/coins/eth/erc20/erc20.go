@@ -14,7 +14,15 @@
 
 package erc20
 
-import "github.com/ethereum/go-ethereum/common"
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNoERC20Contract is returned if there is no contract implementing the ERC20 interface at the
+// given address.
+var ErrNoERC20Contract = errp.New("not an ERC20 contract")
 
 // Token holds infos about the erc20 token needed to fetch balances, format amounts, etc.
 type Token struct {
@@ -44,3 +52,45 @@ func (token *Token) ContractAddress() common.Address {
 func (token *Token) Decimals() uint {
 	return token.decimals
 }
+
+// TokenInfo contains the name, symbol and decimals of an erc20 token contract.
+type TokenInfo struct {
+	Name     string
+	Symbol   string
+	Decimals uint
+	// Standard is false if the contract does not implement the optional name and symbol methods,
+	// or if they return empty values. The missing values are left empty.
+	Standard bool
+}
+
+// QueryTokenInfo fetches the name, symbol and decimals of the erc20 token deployed at the given
+// contract address. ErrNoERC20Contract is returned if the contract does not implement the mandatory
+// totalSupply method. An error is also returned if the decimals can't be fetched, as amounts can't
+// be converted without them.
+func QueryTokenInfo(contractAddress string, caller bind.ContractCaller) (*TokenInfo, error) {
+	if !common.IsHexAddress(contractAddress) {
+		return nil, errp.New("invalid erc20 contract address")
+	}
+	contract, err := NewIERC20Caller(common.HexToAddress(contractAddress), caller)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	opts := &bind.CallOpts{}
+	if _, err := contract.TotalSupply(opts); err != nil {
+		return nil, errp.WithMessage(ErrNoERC20Contract, err.Error())
+	}
+	info := &TokenInfo{Standard: true}
+	// Some tokens, e.g. MKR, return bytes32 instead of a string, which fails to decode.
+	if info.Name, err = contract.Name(opts); err != nil || info.Name == "" {
+		info.Standard = false
+	}
+	if info.Symbol, err = contract.Symbol(opts); err != nil || info.Symbol == "" {
+		info.Standard = false
+	}
+	decimals, err := contract.Decimals(opts)
+	if err != nil {
+		return nil, errp.WithMessage(errp.WithStack(err), "Could not fetch the decimals of the token")
+	}
+	info.Decimals = uint(decimals)
+	return info, nil
+}
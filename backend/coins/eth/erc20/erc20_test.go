@@ -0,0 +1,91 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erc20
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+// contractCallerMock answers contract calls with the given return values, indexed by method name.
+type contractCallerMock struct {
+	t       *testing.T
+	results map[string][]interface{}
+}
+
+func (caller *contractCallerMock) CodeAt(context.Context, common.Address, *big.Int) ([]byte, error) {
+	return []byte{0x60}, nil
+}
+
+func (caller *contractCallerMock) CallContract(
+	_ context.Context, call ethereum.CallMsg, _ *big.Int) ([]byte, error) {
+	parsed, err := abi.JSON(strings.NewReader(IERC20ABI))
+	require.NoError(caller.t, err)
+	for name, method := range parsed.Methods {
+		if !bytes.Equal(call.Data[:4], method.ID()) {
+			continue
+		}
+		result, ok := caller.results[name]
+		if !ok {
+			// Missing methods revert, which results in an empty response.
+			return []byte{}, nil
+		}
+		return method.Outputs.Pack(result...)
+	}
+	return nil, errors.New("unknown method")
+}
+
+func TestQueryTokenInfo(t *testing.T) {
+	const contractAddress = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	info, err := QueryTokenInfo(contractAddress, &contractCallerMock{t: t, results: map[string][]interface{}{
+		"totalSupply": {big.NewInt(1000)},
+		"name":        {"Tether USD"},
+		"symbol":      {"USDT"},
+		"decimals":    {uint8(6)},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &TokenInfo{Name: "Tether USD", Symbol: "USDT", Decimals: 6, Standard: true}, info)
+
+	info, err = QueryTokenInfo(contractAddress, &contractCallerMock{t: t, results: map[string][]interface{}{
+		"totalSupply": {big.NewInt(1000)},
+		"decimals":    {uint8(18)},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &TokenInfo{Decimals: 18, Standard: false}, info)
+
+	// Amounts can't be converted without the decimals.
+	_, err = QueryTokenInfo(contractAddress, &contractCallerMock{t: t, results: map[string][]interface{}{
+		"totalSupply": {big.NewInt(1000)},
+		"name":        {"Tether USD"},
+		"symbol":      {"USDT"},
+	}})
+	require.Error(t, err)
+
+	_, err = QueryTokenInfo(contractAddress, &contractCallerMock{t: t, results: map[string][]interface{}{}})
+	require.Equal(t, ErrNoERC20Contract, errp.Cause(err))
+
+	_, err = QueryTokenInfo("invalid", &contractCallerMock{t: t})
+	require.Error(t, err)
+}
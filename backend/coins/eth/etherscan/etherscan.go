@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"math/big"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
@@ -469,13 +470,113 @@ func (etherScan *EtherScan) SendTransaction(ctx context.Context, tx *types.Trans
 	if err != nil {
 		return errp.WithStack(err)
 	}
+	return etherScan.SendRawTransaction(ctx, encodedTx)
+}
 
+// SendRawTransaction implements rpc.Interface
+func (etherScan *EtherScan) SendRawTransaction(ctx context.Context, encodedTx []byte) error {
 	params := url.Values{}
 	params.Set("action", "eth_sendRawTransaction")
 	params.Set("hex", hexutil.Encode(encodedTx))
 	return etherScan.rpcCall(params, nil)
 }
 
+// nextBaseFee computes the base fee of the block following a block with the given base fee and gas
+// usage, see EIP-1559.
+func nextBaseFee(baseFee *big.Int, gasUsed uint64, gasLimit uint64) *big.Int {
+	const elasticityMultiplier = 2
+	const baseFeeChangeDenominator = 8
+	gasTarget := gasLimit / elasticityMultiplier
+	if gasTarget == 0 || gasUsed == gasTarget {
+		return new(big.Int).Set(baseFee)
+	}
+	var gasDelta uint64
+	if gasUsed > gasTarget {
+		gasDelta = gasUsed - gasTarget
+	} else {
+		gasDelta = gasTarget - gasUsed
+	}
+	delta := new(big.Int).Mul(baseFee, new(big.Int).SetUint64(gasDelta))
+	delta.Div(delta, new(big.Int).SetUint64(gasTarget))
+	delta.Div(delta, big.NewInt(baseFeeChangeDenominator))
+	if gasUsed > gasTarget {
+		if delta.Sign() == 0 {
+			delta.SetInt64(1)
+		}
+		return delta.Add(baseFee, delta)
+	}
+	return delta.Sub(baseFee, delta)
+}
+
+// FeeHistory implements rpc.Interface. The EtherScan proxy does not support eth_feeHistory, so the
+// history is computed from the transactions of the latest block only, weighting the priority fees
+// by the gas limit of the transactions.
+func (etherScan *EtherScan) FeeHistory(
+	ctx context.Context, blockCount uint64, rewardPercentiles []float64) (*rpcclient.FeeHistory, error) {
+	params := url.Values{}
+	params.Set("action", "eth_getBlockByNumber")
+	params.Set("tag", "latest")
+	params.Set("boolean", "true")
+	var block struct {
+		BaseFeePerGas *hexutil.Big   `json:"baseFeePerGas"`
+		GasUsed       hexutil.Uint64 `json:"gasUsed"`
+		GasLimit      hexutil.Uint64 `json:"gasLimit"`
+		Transactions  []struct {
+			Gas                  hexutil.Uint64 `json:"gas"`
+			GasPrice             *hexutil.Big   `json:"gasPrice"`
+			MaxPriorityFeePerGas *hexutil.Big   `json:"maxPriorityFeePerGas"`
+		} `json:"transactions"`
+	}
+	if err := etherScan.rpcCall(params, &block); err != nil {
+		return nil, err
+	}
+	if block.BaseFeePerGas == nil {
+		return nil, errp.New("the latest block has no base fee")
+	}
+	baseFee := block.BaseFeePerGas.ToInt()
+
+	type tip struct {
+		gas uint64
+		tip *big.Int
+	}
+	tips := []tip{}
+	var totalGas uint64
+	for _, tx := range block.Transactions {
+		if tx.GasPrice == nil {
+			continue
+		}
+		// The effective gas price is min(maxFeePerGas, baseFee + maxPriorityFeePerGas).
+		effectiveTip := new(big.Int).Sub(tx.GasPrice.ToInt(), baseFee)
+		if tx.MaxPriorityFeePerGas != nil && tx.MaxPriorityFeePerGas.ToInt().Cmp(effectiveTip) < 0 {
+			effectiveTip = tx.MaxPriorityFeePerGas.ToInt()
+		}
+		if effectiveTip.Sign() < 0 {
+			effectiveTip = new(big.Int)
+		}
+		tips = append(tips, tip{gas: uint64(tx.Gas), tip: effectiveTip})
+		totalGas += uint64(tx.Gas)
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].tip.Cmp(tips[j].tip) < 0 })
+
+	rewards := make([]*big.Int, len(rewardPercentiles))
+	for i, percentile := range rewardPercentiles {
+		rewards[i] = new(big.Int)
+		threshold := uint64(float64(totalGas) * percentile / 100)
+		var cumulativeGas uint64
+		for _, tip := range tips {
+			cumulativeGas += tip.gas
+			rewards[i] = tip.tip
+			if cumulativeGas >= threshold {
+				break
+			}
+		}
+	}
+	return &rpcclient.FeeHistory{
+		BaseFeePerGas: []*big.Int{baseFee, nextBaseFee(baseFee, uint64(block.GasUsed), uint64(block.GasLimit))},
+		Reward:        [][]*big.Int{rewards},
+	}, nil
+}
+
 // SubscribeFilterLogs implements rpc.Interface
 func (etherScan *EtherScan) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
 	panic("not implemented")
@@ -0,0 +1,118 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"math/big"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/rpcclient"
+)
+
+// feeHistoryBlocks is the number of recent blocks from which the priority fees are derived.
+const feeHistoryBlocks = 10
+
+// feeTargetPercentiles are the percentiles of the priority fees paid in recent blocks, per fee
+// target.
+var feeTargetPercentiles = []struct {
+	code       accounts.FeeTargetCode
+	percentile float64
+}{
+	{code: accounts.FeeTargetCodeEconomy, percentile: 10},
+	{code: accounts.FeeTargetCodeNormal, percentile: 50},
+	{code: accounts.FeeTargetCodeHigh, percentile: 90},
+}
+
+// FeeTarget contains the EIP-1559 fees of a specific fee target.
+type FeeTarget struct {
+	code accounts.FeeTargetCode
+
+	// baseFee is the expected base fee per gas of the next block.
+	baseFee *big.Int
+	// gasTipCap is the max priority fee per gas paid to the miner.
+	gasTipCap *big.Int
+}
+
+// Code implements accounts.FeeTarget.
+func (feeTarget *FeeTarget) Code() accounts.FeeTargetCode {
+	return feeTarget.code
+}
+
+// GasFeeCap returns the max fee per gas. Twice the base fee is allowed so the transaction stays
+// valid for a few full blocks with rising base fees.
+func (feeTarget *FeeTarget) GasFeeCap() *big.Int {
+	gasFeeCap := new(big.Int).Mul(feeTarget.baseFee, big.NewInt(2))
+	return gasFeeCap.Add(gasFeeCap, feeTarget.gasTipCap)
+}
+
+// GasPrice returns the gas price to use for a legacy transaction, which is the expected price paid
+// by an EIP-1559 transaction of this fee target.
+func (feeTarget *FeeTarget) GasPrice() *big.Int {
+	return new(big.Int).Add(feeTarget.baseFee, feeTarget.gasTipCap)
+}
+
+// equal returns true if both fee targets have the same code and fees.
+func (feeTarget *FeeTarget) equal(other *FeeTarget) bool {
+	return feeTarget.code == other.code &&
+		feeTarget.baseFee.Cmp(other.baseFee) == 0 &&
+		feeTarget.gasTipCap.Cmp(other.gasTipCap) == 0
+}
+
+// feeTargetsEqual returns true if both lists contain the same fee targets in the same order. A nil
+// list (no EIP-1559 support) only equals another nil list.
+func feeTargetsEqual(feeTargets, other []*FeeTarget) bool {
+	if (feeTargets == nil) != (other == nil) || len(feeTargets) != len(other) {
+		return false
+	}
+	for i, feeTarget := range feeTargets {
+		if !feeTarget.equal(other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// newFeeTargets derives the fee targets from the fee history. The priority fee of a target is the
+// average of its percentile over the recent blocks. Returns nil if the history has no base fee,
+// i.e. if EIP-1559 is not active.
+func newFeeTargets(feeHistory *rpcclient.FeeHistory) []*FeeTarget {
+	if len(feeHistory.BaseFeePerGas) == 0 {
+		return nil
+	}
+	baseFee := feeHistory.BaseFeePerGas[len(feeHistory.BaseFeePerGas)-1]
+	if baseFee == nil || baseFee.Sign() == 0 {
+		return nil
+	}
+	feeTargets := make([]*FeeTarget, len(feeTargetPercentiles))
+	for i, target := range feeTargetPercentiles {
+		sum := new(big.Int)
+		count := int64(0)
+		for _, rewards := range feeHistory.Reward {
+			if i < len(rewards) && rewards[i] != nil {
+				sum.Add(sum, rewards[i])
+				count++
+			}
+		}
+		if count > 0 {
+			sum.Div(sum, big.NewInt(count))
+		}
+		feeTargets[i] = &FeeTarget{
+			code:      target.code,
+			baseFee:   new(big.Int).Set(baseFee),
+			gasTipCap: sum,
+		}
+	}
+	return feeTargets
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/rpcclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFeeTargets(t *testing.T) {
+	feeHistory := &rpcclient.FeeHistory{
+		BaseFeePerGas: []*big.Int{big.NewInt(90), big.NewInt(100), big.NewInt(110)},
+		Reward: [][]*big.Int{
+			{big.NewInt(1), big.NewInt(2), big.NewInt(10)},
+			{big.NewInt(3), big.NewInt(4), big.NewInt(20)},
+		},
+	}
+	feeTargets := newFeeTargets(feeHistory)
+	require.Len(t, feeTargets, 3)
+	require.Equal(t, accounts.FeeTargetCodeEconomy, feeTargets[0].Code())
+	require.Equal(t, big.NewInt(2), feeTargets[0].gasTipCap)
+	require.Equal(t, accounts.FeeTargetCodeHigh, feeTargets[2].Code())
+	require.Equal(t, big.NewInt(15), feeTargets[2].gasTipCap)
+	// The base fee of the next block is used.
+	require.Equal(t, big.NewInt(2*110+15), feeTargets[2].GasFeeCap())
+	require.Equal(t, big.NewInt(110+15), feeTargets[2].GasPrice())
+
+	// No EIP-1559 support.
+	require.Nil(t, newFeeTargets(&rpcclient.FeeHistory{BaseFeePerGas: []*big.Int{big.NewInt(0)}}))
+	require.Nil(t, newFeeTargets(&rpcclient.FeeHistory{}))
+}
+
+func TestFeeTargetsEqual(t *testing.T) {
+	feeHistory := &rpcclient.FeeHistory{
+		BaseFeePerGas: []*big.Int{big.NewInt(100)},
+		Reward:        [][]*big.Int{{big.NewInt(1), big.NewInt(2), big.NewInt(10)}},
+	}
+	require.True(t, feeTargetsEqual(nil, nil))
+	require.True(t, feeTargetsEqual(newFeeTargets(feeHistory), newFeeTargets(feeHistory)))
+	require.False(t, feeTargetsEqual(nil, newFeeTargets(feeHistory)))
+	require.False(t, feeTargetsEqual(newFeeTargets(feeHistory), nil))
+
+	changedTip := newFeeTargets(feeHistory)
+	changedTip[1].gasTipCap = big.NewInt(3)
+	require.False(t, feeTargetsEqual(newFeeTargets(feeHistory), changedTip))
+
+	feeHistory.BaseFeePerGas = []*big.Int{big.NewInt(101)}
+	changedBaseFee := newFeeTargets(feeHistory)
+	feeHistory.BaseFeePerGas = []*big.Int{big.NewInt(100)}
+	require.False(t, feeTargetsEqual(newFeeTargets(feeHistory), changedBaseFee))
+}
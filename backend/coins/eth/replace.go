@@ -0,0 +1,204 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts/errors"
+	ethtypes "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/types"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// bumpGasPrice returns the price increased by a bit more than 10%, the minimum increase for nodes
+// to accept a replacement transaction.
+func bumpGasPrice(price *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(price, big.NewInt(110))
+	bumped.Div(bumped, big.NewInt(100))
+	return bumped.Add(bumped, big.NewInt(1))
+}
+
+func maxBigInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// pendingOutgoingTransaction returns the stored outgoing transaction with the given ID, which must
+// not be mined or replaced yet.
+func (account *Account) pendingOutgoingTransaction(txID string) (*ethtypes.TransactionWithMetadata, error) {
+	dbTx, err := account.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+	outgoingTransactions, err := dbTx.OutgoingTransactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range outgoingTransactions {
+		if tx.ID() != txID {
+			continue
+		}
+		if tx.Height != 0 || tx.Dropped {
+			return nil, errp.New("Only pending transactions can be replaced")
+		}
+		return tx, nil
+	}
+	return nil, errp.New("Transaction not found")
+}
+
+// newReplacementTx creates a transaction with the nonce of the original transaction, paying at
+// least 10% more per gas than the original and at least the fees of the fee target. The
+// transaction type of the original is kept, unless legacy is true.
+func (account *Account) newReplacementTx(
+	original ethtypes.Transaction,
+	to common.Address,
+	value *big.Int,
+	gasLimit uint64,
+	data []byte,
+	feeTargetCode accounts.FeeTargetCode,
+	legacy bool,
+) (*TxProposal, error) {
+	feeTarget, err := account.feeTarget(feeTargetCode)
+	if err != nil {
+		return nil, err
+	}
+	var tx ethtypes.Transaction
+	// gasPrice is the highest price per gas which can be paid, expectedGasPrice the price per gas
+	// expected to be paid, see newTx().
+	var gasPrice, expectedGasPrice *big.Int
+	if dynamicFeeTx, ok := original.(*ethtypes.DynamicFeeTx); ok && !legacy {
+		gasTipCap := bumpGasPrice(dynamicFeeTx.GasTipCap())
+		gasPrice = bumpGasPrice(dynamicFeeTx.GasFeeCap())
+		expectedGasPrice = gasPrice
+		if feeTarget != nil {
+			gasTipCap = maxBigInt(gasTipCap, feeTarget.gasTipCap)
+			gasPrice = maxBigInt(gasPrice, feeTarget.GasFeeCap())
+			expectedGasPrice = new(big.Int).Add(feeTarget.baseFee, gasTipCap)
+			if expectedGasPrice.Cmp(gasPrice) == 1 {
+				expectedGasPrice = gasPrice
+			}
+		}
+		tx = ethtypes.NewDynamicFeeTx(account.coin.Net().ChainID, original.Nonce(),
+			to, value, gasLimit, gasTipCap, gasPrice, data)
+	} else {
+		gasPrice = bumpGasPrice(original.GasPrice())
+		if feeTarget != nil {
+			gasPrice = maxBigInt(gasPrice, feeTarget.GasPrice())
+		} else {
+			suggestedGasPrice, err := account.coin.client.SuggestGasPrice(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+			gasPrice = maxBigInt(gasPrice, suggestedGasPrice)
+		}
+		tx = types.NewTransaction(original.Nonce(), to, value, gasLimit, gasPrice, data)
+		expectedGasPrice = gasPrice
+	}
+	maxFee := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), gasPrice)
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), expectedGasPrice)
+	// For ERC20 tokens, the balance is in the token unit and can't be compared with the fee.
+	if account.coin.erc20Token == nil {
+		total := new(big.Int).Add(value, maxFee)
+		if total.Cmp(account.balance.BigInt()) == 1 {
+			return nil, errp.WithStack(errors.ErrInsufficientFunds)
+		}
+	}
+	return &TxProposal{
+		Coin:    account.coin,
+		Tx:      tx,
+		Fee:     fee,
+		Value:   value,
+		Signer:  types.MakeSigner(account.coin.Net(), account.blockNumber),
+		Keypath: account.signingConfiguration.AbsoluteKeypath(),
+	}, nil
+}
+
+// replaceTx signs and broadcasts a replacement of a pending transaction created by newTx, and
+// marks the replaced transaction as dropped.
+func (account *Account) replaceTx(
+	txID string,
+	newTx func(original ethtypes.Transaction, legacy bool) (*TxProposal, error),
+) error {
+	original, err := account.pendingOutgoingTransaction(txID)
+	if err != nil {
+		return err
+	}
+	txProposal, err := account.signTx(func(legacy bool) (*TxProposal, error) {
+		return newTx(original.Transaction, legacy)
+	})
+	if err != nil {
+		return err
+	}
+	if err := account.broadcast(txProposal.Tx); err != nil {
+		return err
+	}
+
+	dbTx, err := account.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+	original.Dropped = true
+	if err := dbTx.PutOutgoingTransaction(original); err != nil {
+		return err
+	}
+	if err := dbTx.PutOutgoingTransaction(
+		&ethtypes.TransactionWithMetadata{
+			Transaction: txProposal.Tx,
+			Height:      0,
+		}); err != nil {
+		return err
+	}
+	if err := dbTx.Commit(); err != nil {
+		return err
+	}
+	account.log.Infof("replaced pending outgoing tx with nonce: %d", txProposal.Tx.Nonce())
+	account.enqueueUpdateCh <- struct{}{}
+	return nil
+}
+
+// SpeedUpTx replaces a pending outgoing transaction with the same transaction paying a higher gas
+// price, so it is mined faster.
+func (account *Account) SpeedUpTx(txID string, feeTargetCode accounts.FeeTargetCode) error {
+	account.log.Info("Speeding up transaction")
+	return account.replaceTx(txID, func(original ethtypes.Transaction, legacy bool) (*TxProposal, error) {
+		to := original.To()
+		if to == nil {
+			return nil, errp.New("contract creation not supported")
+		}
+		return account.newReplacementTx(original, *to, original.Value(), original.Gas(),
+			original.Data(), feeTargetCode, legacy)
+	})
+}
+
+// CancelTx replaces a pending outgoing transaction with a transaction sending 0 ETH to the
+// account itself, paying a higher gas price. If the replacement is mined, the original transaction
+// can't be mined anymore. This is the same for ERC20 token transfers, as a plain self-send is the
+// cheapest transaction with the same nonce and does not call the token contract.
+func (account *Account) CancelTx(txID string, feeTargetCode accounts.FeeTargetCode) error {
+	account.log.Info("Cancelling transaction")
+	return account.replaceTx(txID, func(original ethtypes.Transaction, legacy bool) (*TxProposal, error) {
+		return account.newReplacementTx(original, account.address.Address, big.NewInt(0), params.TxGas,
+			nil, feeTargetCode, legacy)
+	})
+}
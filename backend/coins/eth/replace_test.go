@@ -0,0 +1,29 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBumpGasPrice(t *testing.T) {
+	require.Equal(t, big.NewInt(111), bumpGasPrice(big.NewInt(100)))
+	require.Equal(t, big.NewInt(1), bumpGasPrice(big.NewInt(0)))
+	// Rounding down is compensated so the increase is always at least 10%.
+	require.Equal(t, big.NewInt(10), bumpGasPrice(big.NewInt(9)))
+}
@@ -34,9 +34,23 @@ type Interface interface {
 		ctx context.Context, hash common.Hash) (*RPCTransactionReceipt, error)
 	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
 	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	// FeeHistory returns the base fees and the priority fees at the given percentiles of the
+	// latest blocks. Returns an error if the node does not support EIP-1559.
+	FeeHistory(ctx context.Context, blockCount uint64, rewardPercentiles []float64) (*FeeHistory, error)
+	// SendRawTransaction broadcasts a transaction of any type, see ethtypes.EncodeTransaction().
+	SendRawTransaction(ctx context.Context, encodedTx []byte) error
 	bind.ContractBackend
 }
 
+// FeeHistory is the result of eth_feeHistory.
+type FeeHistory struct {
+	// BaseFeePerGas contains the base fees of the blocks, followed by the base fee of the next
+	// block.
+	BaseFeePerGas []*big.Int
+	// Reward contains the priority fees at the requested percentiles per block.
+	Reward [][]*big.Int
+}
+
 // RPCClient wraps the high level ethclient, extending it with more functions. Implements Interface.
 type RPCClient struct {
 	*ethclient.Client
@@ -56,10 +70,13 @@ func RPCDial(url string) (*RPCClient, error) {
 	}, nil
 }
 
-// RPCTransactionReceipt is a receipt extended with the block number.
+// RPCTransactionReceipt is a receipt extended with the block number and the effective gas price.
 type RPCTransactionReceipt struct {
 	types.Receipt
 	BlockNumber uint64
+	// EffectiveGasPrice is the price per gas paid by the transaction. For EIP-1559 transactions,
+	// this is the base fee of the block plus the priority fee. nil if the node does not return it.
+	EffectiveGasPrice *big.Int
 }
 
 // UnmarshalJSON implements json.Unmarshaler.
@@ -68,15 +85,50 @@ func (rpcTR *RPCTransactionReceipt) UnmarshalJSON(msg []byte) error {
 		return err
 	}
 	bn := struct {
-		BlockNumber hexutil.Uint64 `json:"blockNumber"`
+		BlockNumber       hexutil.Uint64 `json:"blockNumber"`
+		EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice"`
 	}{}
 	if err := json.Unmarshal(msg, &bn); err != nil {
 		return err
 	}
 	rpcTR.BlockNumber = uint64(bn.BlockNumber)
+	if bn.EffectiveGasPrice != nil {
+		rpcTR.EffectiveGasPrice = bn.EffectiveGasPrice.ToInt()
+	}
 	return nil
 }
 
+// FeeHistory implements Interface.
+func (rpc *RPCClient) FeeHistory(
+	ctx context.Context, blockCount uint64, rewardPercentiles []float64) (*FeeHistory, error) {
+	var result struct {
+		BaseFeePerGas []*hexutil.Big   `json:"baseFeePerGas"`
+		Reward        [][]*hexutil.Big `json:"reward"`
+	}
+	err := rpc.c.CallContext(ctx, &result, "eth_feeHistory",
+		hexutil.Uint64(blockCount), "latest", rewardPercentiles)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	feeHistory := &FeeHistory{}
+	for _, baseFee := range result.BaseFeePerGas {
+		feeHistory.BaseFeePerGas = append(feeHistory.BaseFeePerGas, baseFee.ToInt())
+	}
+	for _, blockRewards := range result.Reward {
+		rewards := make([]*big.Int, len(blockRewards))
+		for i, reward := range blockRewards {
+			rewards[i] = reward.ToInt()
+		}
+		feeHistory.Reward = append(feeHistory.Reward, rewards)
+	}
+	return feeHistory, nil
+}
+
+// SendRawTransaction implements Interface.
+func (rpc *RPCClient) SendRawTransaction(ctx context.Context, encodedTx []byte) error {
+	return errp.WithStack(rpc.c.CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Encode(encodedTx)))
+}
+
 // TransactionReceiptWithBlockNumber is like rpc.TransactionReceipt, but exposes the block number as
 // well. If no receipt was found, `nil, nil` is returned.
 func (rpc *RPCClient) TransactionReceiptWithBlockNumber(
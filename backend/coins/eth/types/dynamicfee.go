@@ -0,0 +1,218 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// DynamicFeeTxType is the EIP-2718 type of EIP-1559 transactions.
+const DynamicFeeTxType = 0x02
+
+// Transaction is implemented by legacy transactions (*types.Transaction) and EIP-1559 transactions
+// (*DynamicFeeTx).
+type Transaction interface {
+	Nonce() uint64
+	Gas() uint64
+	// GasPrice returns the gas price of legacy transactions and the max fee per gas of EIP-1559
+	// transactions, i.e. the highest price that can be paid.
+	GasPrice() *big.Int
+	Value() *big.Int
+	To() *common.Address
+	Data() []byte
+	Hash() common.Hash
+}
+
+type accessTuple struct {
+	Address     common.Address
+	StorageKeys []common.Hash
+}
+
+// dynamicFeeTxData is the RLP encoding of the payload of an EIP-1559 transaction.
+type dynamicFeeTxData struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList []accessTuple
+	V, R, S    *big.Int
+}
+
+// DynamicFeeTx is an EIP-1559 transaction with a max fee and a max priority fee per gas instead of
+// a gas price. go-ethereum in this version only knows legacy transactions, so the encoding and
+// signature hash are implemented here.
+type DynamicFeeTx struct {
+	data dynamicFeeTxData
+}
+
+// NewDynamicFeeTx creates an unsigned EIP-1559 transaction.
+func NewDynamicFeeTx(
+	chainID *big.Int,
+	nonce uint64,
+	to common.Address,
+	value *big.Int,
+	gasLimit uint64,
+	gasTipCap *big.Int,
+	gasFeeCap *big.Int,
+	data []byte,
+) *DynamicFeeTx {
+	return &DynamicFeeTx{data: dynamicFeeTxData{
+		ChainID:    new(big.Int).Set(chainID),
+		Nonce:      nonce,
+		GasTipCap:  new(big.Int).Set(gasTipCap),
+		GasFeeCap:  new(big.Int).Set(gasFeeCap),
+		Gas:        gasLimit,
+		To:         &to,
+		Value:      new(big.Int).Set(value),
+		Data:       common.CopyBytes(data),
+		AccessList: []accessTuple{},
+		V:          new(big.Int),
+		R:          new(big.Int),
+		S:          new(big.Int),
+	}}
+}
+
+// ChainID returns the chain ID the transaction is signed for.
+func (tx *DynamicFeeTx) ChainID() *big.Int { return new(big.Int).Set(tx.data.ChainID) }
+
+// Nonce implements Transaction.
+func (tx *DynamicFeeTx) Nonce() uint64 { return tx.data.Nonce }
+
+// Gas implements Transaction.
+func (tx *DynamicFeeTx) Gas() uint64 { return tx.data.Gas }
+
+// GasPrice implements Transaction. It returns the max fee per gas.
+func (tx *DynamicFeeTx) GasPrice() *big.Int { return tx.GasFeeCap() }
+
+// GasTipCap returns the max priority fee per gas paid to the miner.
+func (tx *DynamicFeeTx) GasTipCap() *big.Int { return new(big.Int).Set(tx.data.GasTipCap) }
+
+// GasFeeCap returns the max fee per gas, including the base fee.
+func (tx *DynamicFeeTx) GasFeeCap() *big.Int { return new(big.Int).Set(tx.data.GasFeeCap) }
+
+// Value implements Transaction.
+func (tx *DynamicFeeTx) Value() *big.Int { return new(big.Int).Set(tx.data.Value) }
+
+// To implements Transaction.
+func (tx *DynamicFeeTx) To() *common.Address {
+	if tx.data.To == nil {
+		return nil
+	}
+	to := *tx.data.To
+	return &to
+}
+
+// Data implements Transaction.
+func (tx *DynamicFeeTx) Data() []byte { return common.CopyBytes(tx.data.Data) }
+
+// SigningHash returns the hash to be signed, keccak256(0x02 || rlp(payload without signature)).
+func (tx *DynamicFeeTx) SigningHash() common.Hash {
+	payload, err := rlp.EncodeToBytes([]interface{}{
+		tx.data.ChainID,
+		tx.data.Nonce,
+		tx.data.GasTipCap,
+		tx.data.GasFeeCap,
+		tx.data.Gas,
+		tx.data.To,
+		tx.data.Value,
+		tx.data.Data,
+		tx.data.AccessList,
+	})
+	if err != nil {
+		panic(errp.WithStack(err))
+	}
+	return crypto.Keccak256Hash([]byte{DynamicFeeTxType}, payload)
+}
+
+// WithSignature returns a copy of the transaction with the given signature in the [R || S || V]
+// format, where V is the recovery ID (0 or 1).
+func (tx *DynamicFeeTx) WithSignature(signature []byte) (*DynamicFeeTx, error) {
+	if len(signature) != crypto.SignatureLength {
+		return nil, errp.Newf("wrong size for signature: got %d, want %d",
+			len(signature), crypto.SignatureLength)
+	}
+	if signature[64] > 1 {
+		return nil, errp.New("invalid signature recovery id")
+	}
+	signed := &DynamicFeeTx{data: tx.data}
+	signed.data.R = new(big.Int).SetBytes(signature[:32])
+	signed.data.S = new(big.Int).SetBytes(signature[32:64])
+	signed.data.V = new(big.Int).SetBytes(signature[64:])
+	return signed, nil
+}
+
+// MarshalBinary returns the EIP-2718 encoding of the transaction, as broadcast to the network.
+func (tx *DynamicFeeTx) MarshalBinary() ([]byte, error) {
+	payload, err := rlp.EncodeToBytes(&tx.data)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return append([]byte{DynamicFeeTxType}, payload...), nil
+}
+
+// Hash implements Transaction.
+func (tx *DynamicFeeTx) Hash() common.Hash {
+	encoded, err := tx.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return crypto.Keccak256Hash(encoded)
+}
+
+// EncodeTransaction returns the network encoding of a legacy or EIP-1559 transaction.
+func EncodeTransaction(tx Transaction) ([]byte, error) {
+	switch specificTx := tx.(type) {
+	case *types.Transaction:
+		encoded, err := rlp.EncodeToBytes(specificTx)
+		return encoded, errp.WithStack(err)
+	case *DynamicFeeTx:
+		return specificTx.MarshalBinary()
+	default:
+		return nil, errp.New("unknown transaction type")
+	}
+}
+
+// DecodeTransaction decodes a transaction encoded with EncodeTransaction().
+func DecodeTransaction(encoded []byte) (Transaction, error) {
+	if len(encoded) == 0 {
+		return nil, errp.New("empty transaction")
+	}
+	// RLP lists start with 0xc0 or higher, typed transactions with their type.
+	if encoded[0] > 0x7f {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(encoded, tx); err != nil {
+			return nil, errp.WithStack(err)
+		}
+		return tx, nil
+	}
+	if encoded[0] != DynamicFeeTxType {
+		return nil, errp.Newf("unsupported transaction type %d", encoded[0])
+	}
+	tx := &DynamicFeeTx{}
+	if err := rlp.DecodeBytes(encoded[1:], &tx.data); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return tx, nil
+}
@@ -25,8 +25,6 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/erc20"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // NumConfirmationsComplete indicates after how many confs the tx is considered complete.
@@ -40,54 +38,79 @@ type EthereumTransaction interface {
 
 // TransactionWithMetadata wraps an outgoing transaction and implements accounts.Transaction.
 type TransactionWithMetadata struct {
-	Transaction *types.Transaction
+	Transaction Transaction
 	// Height is 0 for pending tx.
 	Height uint64
 	// Only applies if Height > 0
 	GasUsed uint64
+	// EffectiveGasPrice is the price per gas paid, taken from the receipt. Only applies if Height >
+	// 0. nil if unknown.
+	EffectiveGasPrice *big.Int
 	// Only applies if Height > 0.
 	// false if contract execution failed, otherwise true.
 	Success bool
+	// Dropped is true if the transaction was replaced by another transaction with the same nonce
+	// (speed up or cancel), or if another transaction with the same nonce was mined instead.
+	Dropped bool
 }
 
 // MarshalJSON implements json.Marshaler. Used for DB serialization.
 func (txh *TransactionWithMetadata) MarshalJSON() ([]byte, error) {
-	txSerialized, err := rlp.EncodeToBytes(txh.Transaction)
+	txSerialized, err := EncodeTransaction(txh.Transaction)
 	if err != nil {
 		return nil, err
 	}
-	return json.Marshal(map[string]interface{}{
+	m := map[string]interface{}{
 		"tx":      txSerialized,
 		"height":  txh.Height,
 		"gasUsed": hexutil.Uint64(txh.GasUsed),
 		"success": txh.Success,
-	})
+		"dropped": txh.Dropped,
+	}
+	if txh.EffectiveGasPrice != nil {
+		m["effectiveGasPrice"] = (*hexutil.Big)(txh.EffectiveGasPrice)
+	}
+	return json.Marshal(m)
 }
 
 // UnmarshalJSON implements json.Unmarshaler. Used for DB serialization.
 func (txh *TransactionWithMetadata) UnmarshalJSON(input []byte) error {
 	m := struct {
-		TransactionRLP []byte         `json:"tx"`
-		Height         uint64         `json:"height"`
-		GasUsed        hexutil.Uint64 `json:"gasUsed"`
-		Success        bool           `json:"success"`
+		TransactionRLP    []byte         `json:"tx"`
+		Height            uint64         `json:"height"`
+		GasUsed           hexutil.Uint64 `json:"gasUsed"`
+		EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice"`
+		Success           bool           `json:"success"`
+		Dropped           bool           `json:"dropped"`
 	}{}
 	if err := json.Unmarshal(input, &m); err != nil {
 		return err
 	}
-	txh.Transaction = new(types.Transaction)
-	if err := rlp.DecodeBytes(m.TransactionRLP, txh.Transaction); err != nil {
+	transaction, err := DecodeTransaction(m.TransactionRLP)
+	if err != nil {
 		return err
 	}
+	txh.Transaction = transaction
 	txh.Height = m.Height
 	txh.GasUsed = uint64(m.GasUsed)
+	if m.EffectiveGasPrice != nil {
+		txh.EffectiveGasPrice = m.EffectiveGasPrice.ToInt()
+	}
 	txh.Success = m.Success
+	txh.Dropped = m.Dropped
 	return nil
 }
 
-// Fee implements accounts.Transaction.
+// Fee implements accounts.Transaction. For mined transactions, this is the gas used times the
+// effective gas price of the receipt. The gas price of the transaction is used if the effective gas
+// price is unknown, which is exact for legacy transactions and the max fee for EIP-1559
+// transactions. For pending transactions, this is the max fee.
 func (txh *TransactionWithMetadata) Fee() *coin.Amount {
-	fee := new(big.Int).Mul(big.NewInt(int64(txh.Transaction.Gas())), txh.Transaction.GasPrice())
+	gasPrice := txh.Transaction.GasPrice()
+	if txh.Height > 0 && txh.EffectiveGasPrice != nil {
+		gasPrice = txh.EffectiveGasPrice
+	}
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(txh.Gas()), gasPrice)
 	amount := coin.NewAmount(fee)
 	return &amount
 }
@@ -128,6 +151,16 @@ func (txh *TransactionWithMetadata) Gas() uint64 {
 	return txh.GasUsed
 }
 
+// IsERC20Transfer returns true if the transaction calls transfer() of the given token contract,
+// without sending any ETH.
+func IsERC20Transfer(tx Transaction, erc20Token *erc20.Token) bool {
+	data := tx.Data()
+	return tx.To() != nil && *tx.To() == erc20Token.ContractAddress() &&
+		len(data) == 68 &&
+		bytes.Equal(data[:4], []byte{0xa9, 0x05, 0x9c, 0xbb}) &&
+		tx.Value().Sign() == 0
+}
+
 // NewTransactionWithConfirmations creates a tx with additional data needed to be able to display it
 // in the frontend.
 func NewTransactionWithConfirmations(
@@ -138,13 +171,8 @@ func NewTransactionWithConfirmations(
 	if erc20Token == nil && len(data) > 0 {
 		panic("invalid config")
 	}
-	if erc20Token != nil {
-		if *tx.Transaction.To() != erc20Token.ContractAddress() ||
-			len(data) != 68 ||
-			!bytes.Equal(data[:4], []byte{0xa9, 0x05, 0x9c, 0xbb}) ||
-			tx.Transaction.Value().Cmp(big.NewInt(0)) != 0 {
-			panic("invalid erc20 tx")
-		}
+	if erc20Token != nil && !IsERC20Transfer(tx.Transaction, erc20Token) {
+		panic("invalid erc20 tx")
 	}
 	return &TransactionWithConfirmations{
 		TransactionWithMetadata: *tx,
@@ -5,10 +5,12 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/erc20"
 	ethtypes "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/types"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,11 +27,106 @@ func TestTransactionWithHeightJSON(t *testing.T) {
 		Height:  352,
 		GasUsed: 21000,
 		Success: true,
+		Dropped: true,
 	}
 	tx2 := new(ethtypes.TransactionWithMetadata)
 	require.NoError(t, json.Unmarshal(jsonp.MustMarshal(tx), tx2))
 	require.Equal(t, tx.Height, tx2.Height)
 	require.Equal(t, tx.GasUsed, tx2.GasUsed)
+	require.Nil(t, tx2.EffectiveGasPrice)
 	require.Equal(t, tx.Success, tx2.Success)
+	require.Equal(t, tx.Dropped, tx2.Dropped)
 	require.Equal(t, tx.Transaction.Hash(), tx2.Transaction.Hash())
 }
+
+func TestDynamicFeeTx(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	tx := ethtypes.NewDynamicFeeTx(
+		big.NewInt(1),
+		7,
+		common.BytesToAddress([]byte("12345678901234567890")),
+		big.NewInt(123456),
+		21000,
+		big.NewInt(2000000000),
+		big.NewInt(50000000000),
+		nil,
+	)
+	signature, err := crypto.Sign(tx.SigningHash().Bytes(), privateKey)
+	require.NoError(t, err)
+	signedTx, err := tx.WithSignature(signature)
+	require.NoError(t, err)
+	require.NotEqual(t, tx.Hash(), signedTx.Hash())
+	// The signature is not part of the signing hash.
+	require.Equal(t, tx.SigningHash(), signedTx.SigningHash())
+
+	publicKey, err := crypto.SigToPub(signedTx.SigningHash().Bytes(), signature)
+	require.NoError(t, err)
+	require.Equal(t, crypto.PubkeyToAddress(privateKey.PublicKey), crypto.PubkeyToAddress(*publicKey))
+
+	encoded, err := ethtypes.EncodeTransaction(signedTx)
+	require.NoError(t, err)
+	require.Equal(t, byte(ethtypes.DynamicFeeTxType), encoded[0])
+	decoded, err := ethtypes.DecodeTransaction(encoded)
+	require.NoError(t, err)
+	require.Equal(t, signedTx.Hash(), decoded.Hash())
+	require.Equal(t, big.NewInt(50000000000), decoded.GasPrice())
+	require.Equal(t, uint64(7), decoded.Nonce())
+
+	txWithMetadata := &ethtypes.TransactionWithMetadata{Transaction: signedTx}
+	txWithMetadata2 := new(ethtypes.TransactionWithMetadata)
+	require.NoError(t, json.Unmarshal(jsonp.MustMarshal(txWithMetadata), txWithMetadata2))
+	require.Equal(t, signedTx.Hash(), txWithMetadata2.Transaction.Hash())
+
+	_, err = ethtypes.DecodeTransaction([]byte{0x01, 0xc0})
+	require.Error(t, err)
+}
+
+func TestTransactionWithMetadataFee(t *testing.T) {
+	tx := &ethtypes.TransactionWithMetadata{
+		Transaction: ethtypes.NewDynamicFeeTx(
+			big.NewInt(1),
+			7,
+			common.BytesToAddress([]byte("12345678901234567890")),
+			big.NewInt(123456),
+			50000,
+			big.NewInt(2),
+			big.NewInt(100),
+			nil,
+		),
+	}
+	// Pending: the max fee.
+	require.Equal(t, "5000000", tx.Fee().BigInt().String())
+
+	// Mined, effective gas price unknown: gas used times the max fee per gas.
+	tx.Height = 10
+	tx.GasUsed = 21000
+	require.Equal(t, "2100000", tx.Fee().BigInt().String())
+
+	// Mined: gas used times the effective gas price.
+	tx.EffectiveGasPrice = big.NewInt(60)
+	require.Equal(t, "1260000", tx.Fee().BigInt().String())
+
+	tx2 := new(ethtypes.TransactionWithMetadata)
+	require.NoError(t, json.Unmarshal(jsonp.MustMarshal(tx), tx2))
+	require.Equal(t, big.NewInt(60), tx2.EffectiveGasPrice)
+	require.Equal(t, tx.Fee(), tx2.Fee())
+}
+
+func TestIsERC20Transfer(t *testing.T) {
+	contractAddress := "0x0000000000000000000000000000000000000001"
+	token := erc20.NewToken(contractAddress, 18)
+	transferData := append([]byte{0xa9, 0x05, 0x9c, 0xbb}, make([]byte, 64)...)
+	transfer := types.NewTransaction(
+		1, common.HexToAddress(contractAddress), big.NewInt(0), 50000, big.NewInt(1), transferData)
+	require.True(t, ethtypes.IsERC20Transfer(transfer, token))
+
+	// A cancellation is a 0 ETH self-send.
+	self := common.BytesToAddress([]byte("12345678901234567890"))
+	cancel := types.NewTransaction(1, self, big.NewInt(0), 21000, big.NewInt(2), nil)
+	require.False(t, ethtypes.IsERC20Transfer(cancel, token))
+
+	sendingETH := types.NewTransaction(
+		1, common.HexToAddress(contractAddress), big.NewInt(1), 50000, big.NewInt(1), transferData)
+	require.False(t, ethtypes.IsERC20Transfer(sendingETH, token))
+}
@@ -22,11 +22,38 @@ type Account struct {
 	Name          string                 `json:"name"`
 	Code          string                 `json:"code"`
 	Configuration *signing.Configuration `json:"configuration"`
+	// WatchOnly is true if the account is not backed by a keystore, e.g. if it was added from an
+	// extended public key. Such accounts can't be signed by the connected keystores.
+	WatchOnly bool `json:"watchOnly"`
+}
+
+// AccountSettings holds the user settings of an account.
+type AccountSettings struct {
+	// Name overrides the name of the account if not empty.
+	Name string `json:"name,omitempty"`
+	// Hidden accounts are not loaded.
+	Hidden bool `json:"hidden,omitempty"`
 }
 
 // AccountsConfig persists the list of accounts added to the app.
 type AccountsConfig struct {
 	Accounts []Account `json:"accounts"`
+	// Settings are indexed by account code. They apply to the default accounts of the keystores as
+	// well as to the added accounts.
+	Settings map[string]AccountSettings `json:"settings,omitempty"`
+	// RemovedAccounts are the accounts removed by the user. They are not added again by the account
+	// discovery.
+	RemovedAccounts []Account `json:"removedAccounts,omitempty"`
+}
+
+// Lookup returns the added account with the given code, or nil if there is none.
+func (accountsConfig *AccountsConfig) Lookup(code string) *Account {
+	for index := range accountsConfig.Accounts {
+		if accountsConfig.Accounts[index].Code == code {
+			return &accountsConfig.Accounts[index]
+		}
+	}
+	return nil
 }
 
 // newDefaultAccountsonfig returns the default accounts config.
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"strings"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
@@ -30,6 +31,16 @@ const defaultProxyAddress = "127.0.0.1:9050"
 // btcCoinConfig holds configurations specific to a btc-based coin.
 type btcCoinConfig struct {
 	ElectrumServers []*rpc.ServerInfo `json:"electrumServers"`
+	// BlockFilterPeer is the address (host:port) of a node serving compact block filters. If set,
+	// it is used instead of the Electrum servers.
+	BlockFilterPeer string `json:"blockFilterPeer"`
+	// BitcoinCore is the Bitcoin Core node to use instead of the Electrum servers, if set.
+	BitcoinCore *rpc.BitcoinCoreInfo `json:"bitcoinCore"`
+	// WalletBirthday is the Unix time at which the wallets were created, 0 if unknown. With
+	// BlockFilterPeer or BitcoinCore, blocks mined well before it are not scanned. With BitcoinCore,
+	// it must be set unless the rescan of the whole chain is confirmed, see
+	// rpc.BitcoinCoreInfo.RescanFromGenesis.
+	WalletBirthday int64 `json:"walletBirthday"`
 }
 
 // ETHTransactionsSource  where to get Ethereum transactions from. See the list of consts
@@ -44,12 +55,28 @@ const (
 	ETHTransactionsSourceEtherScan ETHTransactionsSource = "etherScan"
 )
 
+// ERC20Token is an erc20 token which can be activated as an account.
+type ERC20Token struct {
+	// Code is the token id, e.g. "usdt". The coin code of the token is "eth-erc20-<code>".
+	Code            string `json:"code"`
+	ContractAddress string `json:"contractAddress"`
+	Name            string `json:"name"`
+	Unit            string `json:"unit"`
+	Decimals        uint   `json:"decimals"`
+	// UserAdded is true if the token was added by the user. Its unit is the symbol reported by the
+	// contract, so it is not used to look up the fiat rates.
+	UserAdded bool `json:"userAdded,omitempty"`
+}
+
 // ethCoinConfig holds configurations for ethereum coins.
 type ethCoinConfig struct {
 	NodeURL string `json:"nodeURL"`
 
 	TransactionsSource ETHTransactionsSource `json:"transactionsSource"`
 	ActiveERC20Tokens  []string              `json:"activeERC20Tokens"`
+	// ERC20Tokens are the erc20 tokens added by the user. Together with the built-in tokens, they
+	// can be activated in ActiveERC20Tokens.
+	ERC20Tokens []ERC20Token `json:"erc20Tokens"`
 }
 
 // ERC20TokenActive returns true if this token is configured to be active.
@@ -81,9 +108,14 @@ func (proxy proxyConfig) ProxyAddressOrDefault() string {
 type Backend struct {
 	Proxy proxyConfig `json:"proxy"`
 
+	// RatesProvider is the name of the service the exchange rates are fetched from, see
+	// rates.NewProvider().
+	RatesProvider string `json:"ratesProvider"`
+
 	BitcoinP2PKHActive       bool `json:"bitcoinP2PKHActive"`
 	BitcoinP2WPKHP2SHActive  bool `json:"bitcoinP2WPKHP2SHActive"`
 	BitcoinP2WPKHActive      bool `json:"bitcoinP2WPKHActive"`
+	BitcoinP2TRActive        bool `json:"bitcoinP2TRActive"`
 	LitecoinP2WPKHP2SHActive bool `json:"litecoinP2WPKHP2SHActive"`
 	LitecoinP2WPKHActive     bool `json:"litecoinP2WPKHActive"`
 	EthereumActive           bool `json:"ethereumActive"`
@@ -107,6 +139,8 @@ func (backend Backend) AccountActive(code string) bool {
 		return backend.BitcoinP2WPKHP2SHActive
 	case "tbtc-p2wpkh", "btc-p2wpkh", "rbtc-p2wpkh":
 		return backend.BitcoinP2WPKHActive
+	case "tbtc-p2tr", "btc-p2tr", "rbtc-p2tr":
+		return backend.BitcoinP2TRActive
 	case "tltc-p2wpkh-p2sh", "ltc-p2wpkh-p2sh":
 		return backend.LitecoinP2WPKHP2SHActive
 	case "tltc-p2wpkh", "ltc-p2wpkh":
@@ -173,6 +207,7 @@ func NewDefaultAppConfig() AppConfig {
 			BitcoinP2PKHActive:       true,
 			BitcoinP2WPKHP2SHActive:  true,
 			BitcoinP2WPKHActive:      true,
+			BitcoinP2TRActive:        true,
 			LitecoinP2WPKHP2SHActive: true,
 			LitecoinP2WPKHActive:     true,
 			EthereumActive:           true,
@@ -246,16 +281,19 @@ func NewDefaultAppConfig() AppConfig {
 				NodeURL:            "etherscan+https://api.etherscan.io/api",
 				TransactionsSource: ETHTransactionsSourceEtherScan,
 				ActiveERC20Tokens:  []string{},
+				ERC20Tokens:        []ERC20Token{},
 			},
 			TETH: ethCoinConfig{
 				NodeURL:            "etherscan+https://api-ropsten.etherscan.io/api",
 				TransactionsSource: ETHTransactionsSourceEtherScan,
 				ActiveERC20Tokens:  []string{},
+				ERC20Tokens:        []ERC20Token{},
 			},
 			RETH: ethCoinConfig{
 				NodeURL:            "etherscan+https://api-rinkeby.etherscan.io/api",
 				TransactionsSource: ETHTransactionsSourceEtherScan,
 				ActiveERC20Tokens:  []string{},
+				ERC20Tokens:        []ERC20Token{},
 			},
 		},
 	}
@@ -270,6 +308,13 @@ type Config struct {
 
 	accountsConfigFilename string
 	accountsConfig         AccountsConfig
+
+	// encryptionKey encrypts the accounts config, which contains the xpubs. The app config is not
+	// encrypted, as it is needed before the storage is unlocked, e.g. for the proxy settings.
+	encryptionKey *encryption.Key
+	// accountsConfigLocked is true if the accounts config could not be decrypted. It is not
+	// overwritten until the encryption key is set.
+	accountsConfigLocked bool
 }
 
 // NewConfig creates a new Config, stored in the given location. The filename must be writable, but
@@ -331,7 +376,16 @@ func (config *Config) load() {
 	if err := json.Unmarshal(jsonBytes, &config.appConfig); err != nil {
 		return
 	}
-	jsonBytes, err = ioutil.ReadFile(config.accountsConfigFilename)
+	config.loadAccountsConfig()
+}
+
+func (config *Config) loadAccountsConfig() {
+	jsonBytes, err := ioutil.ReadFile(config.accountsConfigFilename)
+	if err != nil {
+		return
+	}
+	jsonBytes, err = config.encryptionKey.Decrypt(jsonBytes)
+	config.accountsConfigLocked = err != nil
 	if err != nil {
 		return
 	}
@@ -350,7 +404,7 @@ func (config *Config) AppConfig() AppConfig {
 func (config *Config) SetAppConfig(appConfig AppConfig) error {
 	defer config.lock.Lock()()
 	config.appConfig = appConfig
-	return config.save(config.appConfigFilename, config.appConfig)
+	return config.save(config.appConfigFilename, config.appConfig, nil)
 }
 
 // AccountsConfig returns the accounts config.
@@ -362,15 +416,41 @@ func (config *Config) AccountsConfig() AccountsConfig {
 // SetAccountsConfig sets and persists the accounts config.
 func (config *Config) SetAccountsConfig(accountsConfig AccountsConfig) error {
 	defer config.lock.Lock()()
+	if config.accountsConfigLocked {
+		return encryption.ErrLocked
+	}
 	config.accountsConfig = accountsConfig
-	return config.save(config.accountsConfigFilename, config.accountsConfig)
+	return config.save(config.accountsConfigFilename, config.accountsConfig, config.encryptionKey)
+}
+
+// SetEncryptionKey sets the key with which the accounts config is encrypted. If the accounts
+// config was locked, it is loaded with the key. Otherwise, it is written again with the key, which
+// encrypts an unencrypted accounts config.
+func (config *Config) SetEncryptionKey(key *encryption.Key) error {
+	defer config.lock.Lock()()
+	config.encryptionKey = key
+	if config.accountsConfigLocked {
+		config.loadAccountsConfig()
+		if config.accountsConfigLocked {
+			return errp.New("The accounts config could not be decrypted")
+		}
+		return nil
+	}
+	return config.save(config.accountsConfigFilename, config.accountsConfig, key)
 }
 
-func (config *Config) save(filename string, conf interface{}) error {
+func (config *Config) save(filename string, conf interface{}, key *encryption.Key) error {
 	jsonBytes, err := json.MarshalIndent(conf, "", "    ")
 	if err != nil {
 		return errp.WithStack(err)
 	}
+	if key != nil {
+		jsonBytes, err = key.Encrypt(jsonBytes)
+		if err != nil {
+			return err
+		}
+		return errp.WithStack(ioutil.WriteFile(filename, jsonBytes, 0600))
+	}
 	return errp.WithStack(ioutil.WriteFile(filename, jsonBytes, 0644))
 }
 
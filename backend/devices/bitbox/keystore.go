@@ -15,6 +15,7 @@
 package bitbox
 
 import (
+	"crypto/sha256"
 	"fmt"
 
 	"github.com/btcsuite/btcd/txscript"
@@ -26,6 +27,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -51,12 +53,19 @@ func (keystore *keystore) SupportsAccount(
 	coin coin.Coin, multisig bool, meta interface{}) bool {
 	switch coin.(type) {
 	case *btc.Coin:
-		return true
+		// Taproot is not supported by the BitBox01 firmware.
+		scriptType, _ := meta.(signing.ScriptType)
+		return scriptType != signing.ScriptTypeP2TR
 	default:
 		return false
 	}
 }
 
+// SupportsEIP1559 implements keystore.Keystore. The BitBox01 can only sign legacy transactions.
+func (keystore *keystore) SupportsEIP1559() bool {
+	return false
+}
+
 // CanVerifyAddress implements keystore.Keystore.
 func (keystore *keystore) CanVerifyAddress(
 	configuration *signing.Configuration, coin coin.Coin) (bool, bool, error) {
@@ -99,6 +108,34 @@ func (keystore *keystore) ExtendedPublicKey(
 	return keystore.dbb.xpub(keyPath.Encode())
 }
 
+// RootFingerprint implements keystore.Keystore.
+func (keystore *keystore) RootFingerprint() ([]byte, error) {
+	master, err := keystore.dbb.xpub("m")
+	if err != nil {
+		return nil, err
+	}
+	return signing.RootFingerprint(master)
+}
+
+// DeriveSecret implements keystore.Keystore. The secret is the hash of the signature of the
+// challenge, which is deterministic (RFC6979). The user has to confirm the signing on the device.
+func (keystore *keystore) DeriveSecret(keypath signing.AbsoluteKeypath, challenge []byte) ([]byte, error) {
+	keystore.log.Info("Sign secret challenge")
+	hash := sha256.Sum256(challenge)
+	signatures, err := keystore.dbb.Sign(nil, [][]byte{hash[:]}, []string{keypath.Encode()})
+	if isErrorAbort(err) {
+		return nil, errp.WithStack(keystorePkg.ErrSigningAborted)
+	}
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to sign the secret challenge")
+	}
+	if len(signatures) != 1 {
+		panic("expecting one signature")
+	}
+	secret := sha256.Sum256(signatures[0].Signature.Serialize())
+	return secret[:], nil
+}
+
 func (keystore *keystore) signBTCTransaction(btcProposedTx *btc.ProposedTransaction) error {
 	keystore.log.Info("Sign btc transaction")
 	signatureHashes := [][]byte{}
@@ -155,8 +192,12 @@ func (keystore *keystore) signBTCTransaction(btcProposedTx *btc.ProposedTransact
 }
 
 func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
+	tx, ok := txProposal.Tx.(*types.Transaction)
+	if !ok {
+		panic("unsupported transaction type")
+	}
 	signatureHashes := [][]byte{
-		txProposal.Signer.Hash(txProposal.Tx).Bytes(),
+		txProposal.Signer.Hash(tx).Bytes(),
 	}
 	signatures, err := keystore.dbb.Sign(nil, signatureHashes, []string{txProposal.Keypath.Encode()})
 	if isErrorAbort(err) {
@@ -176,7 +217,7 @@ func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
 	copy(sig[:32], math.PaddedBigBytes(signature.R, 32))
 	copy(sig[32:64], math.PaddedBigBytes(signature.S, 32))
 	sig[64] = byte(signature.RecID)
-	signedTx, err := txProposal.Tx.WithSignature(txProposal.Signer, sig)
+	signedTx, err := tx.WithSignature(txProposal.Signer, sig)
 	if err != nil {
 		return err
 	}
@@ -184,6 +225,28 @@ func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
 	return nil
 }
 
+// SignMessage implements keystore.Keystore.
+func (keystore *keystore) SignMessage(proposedMessage interface{}) error {
+	btcProposedMessage, ok := proposedMessage.(*btc.ProposedMessage)
+	if !ok {
+		panic("unknown proposal type")
+	}
+	keystore.log.Info("Sign btc message")
+	signatures, err := keystore.dbb.Sign(nil, [][]byte{btcProposedMessage.SignatureHash},
+		[]string{btcProposedMessage.Address.Configuration.AbsoluteKeypath().Encode()})
+	if isErrorAbort(err) {
+		return errp.WithStack(keystorePkg.ErrSigningAborted)
+	}
+	if err != nil {
+		return errp.WithMessage(err, "Failed to sign message hash")
+	}
+	if len(signatures) != 1 {
+		panic("expecting one signature")
+	}
+	btcProposedMessage.Signature = &signatures[0].Signature
+	return nil
+}
+
 // SignTransaction implements keystore.Keystore.
 func (keystore *keystore) SignTransaction(proposedTx interface{}) error {
 	switch specificProposedTx := proposedTx.(type) {
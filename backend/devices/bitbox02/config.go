@@ -47,8 +47,10 @@ func NewConfig(configDir string) *Config {
 	return &Config{configDir: configDir}
 }
 
+// The config is not encrypted, as it is needed to connect to the device before the storage can be
+// unlocked with the keystore.
 func (config *Config) readConfig() *ConfigData {
-	configFile := fileconfig.NewFile(config.configDir, configFilename)
+	configFile := fileconfig.NewPlaintextFile(config.configDir, configFilename)
 	if !configFile.Exists() {
 		return &ConfigData{}
 	}
@@ -60,7 +62,7 @@ func (config *Config) readConfig() *ConfigData {
 }
 
 func (config *Config) storeConfig(conf *ConfigData) error {
-	configFile := fileconfig.NewFile(config.configDir, configFilename)
+	configFile := fileconfig.NewPlaintextFile(config.configDir, configFilename)
 	return configFile.WriteJSON(conf)
 }
 
@@ -32,6 +32,7 @@ import (
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox02-api-go/api/firmware"
 	"github.com/digitalbitbox/bitbox02-api-go/api/firmware/messages"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -56,7 +57,7 @@ func (keystore *keystore) SupportsAccount(
 			return false
 		}
 		scriptType := meta.(signing.ScriptType)
-		return !multisig && scriptType != signing.ScriptTypeP2PKH
+		return !multisig && scriptType != signing.ScriptTypeP2PKH && scriptType != signing.ScriptTypeP2TR
 	case *eth.Coin:
 		return keystore.device.SupportsETH(coin.Code())
 	default:
@@ -64,6 +65,12 @@ func (keystore *keystore) SupportsAccount(
 	}
 }
 
+// SupportsEIP1559 implements keystore.Keystore. The firmware API only supports legacy
+// transactions.
+func (keystore *keystore) SupportsEIP1559() bool {
+	return false
+}
+
 // CanVerifyAddress implements keystore.Keystore.
 func (keystore *keystore) CanVerifyAddress(configuration *signing.Configuration, coin coinpkg.Coin) (bool, bool, error) {
 	optional := false
@@ -200,6 +207,18 @@ func (keystore *keystore) ExtendedPublicKey(
 	}
 }
 
+// RootFingerprint implements keystore.Keystore. The firmware API does not report the root
+// fingerprint yet.
+func (keystore *keystore) RootFingerprint() ([]byte, error) {
+	return nil, errp.New("unsupported operation")
+}
+
+// DeriveSecret implements keystore.Keystore. The firmware API does not offer a deterministic
+// secret which can't be computed from the public keys.
+func (keystore *keystore) DeriveSecret(signing.AbsoluteKeypath, []byte) ([]byte, error) {
+	return nil, errp.New("The BitBox02 does not support deriving secrets yet.")
+}
+
 func (keystore *keystore) signBTCTransaction(btcProposedTx *btc.ProposedTransaction) error {
 	tx := btcProposedTx.TXProposal.Transaction
 
@@ -290,7 +309,11 @@ func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
 	if !ok {
 		return errp.New("unsupported coin")
 	}
-	tx := txProposal.Tx
+	// The firmware API only supports legacy transactions.
+	tx, ok := txProposal.Tx.(*types.Transaction)
+	if !ok {
+		return errp.WithStack(eth.ErrDynamicFeeUnsupported)
+	}
 	recipient := tx.To()
 	if recipient == nil {
 		return errp.New("contract creation not supported")
@@ -311,7 +334,7 @@ func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
 	if err != nil {
 		return err
 	}
-	signedTx, err := txProposal.Tx.WithSignature(txProposal.Signer, signature)
+	signedTx, err := tx.WithSignature(txProposal.Signer, signature)
 	if err != nil {
 		return err
 	}
@@ -319,6 +342,11 @@ func (keystore *keystore) signETHTransaction(txProposal *eth.TxProposal) error {
 	return nil
 }
 
+// SignMessage implements keystore.Keystore.
+func (keystore *keystore) SignMessage(proposedMessage interface{}) error {
+	return errp.New("The BitBox02 does not support signing messages yet.")
+}
+
 // SignTransaction implements keystore.Keystore.
 func (keystore *keystore) SignTransaction(proposedTx interface{}) error {
 	switch specificProposedTx := proposedTx.(type) {
@@ -41,6 +41,8 @@ var btcMsgOutputTypeMap = map[txscript.ScriptClass]messages.BTCOutputType{
 	txscript.WitnessV0ScriptHashTy: messages.BTCOutputType_P2WSH,
 }
 
+// ethMsgCoinMap only lists the erc20 tokens known to the firmware. User-added tokens are not
+// supported, as the firmware can't show their transfers.
 var ethMsgCoinMap = map[string]messages.ETHCoin{
 	"eth":            messages.ETHCoin_ETH,
 	"eth-erc20-usdt": messages.ETHCoin_ETH,
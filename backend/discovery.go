@@ -0,0 +1,398 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// maxAccountIndex is the highest account index which can be added. The keystores only accept a
+	// limited range of account indexes.
+	maxAccountIndex = 99
+
+	// discoverySyncTimeout is how long the discovery waits for an account to be synced.
+	discoverySyncTimeout = 5 * time.Minute
+)
+
+// ErrMaxAccountsReached is returned when adding an account beyond maxAccountIndex.
+var ErrMaxAccountsReached = errp.New("The maximum number of accounts has been reached")
+
+// accountTemplate describes a default account (account index 0) of the registered keystores, from
+// which the accounts with higher indexes are derived.
+type accountTemplate struct {
+	coin       coin.Coin
+	code       string
+	name       string
+	keypath    signing.AbsoluteKeypath
+	scriptType signing.ScriptType
+}
+
+// accountSync is closed when an account has been synced for the first time, or when it is closed.
+type accountSync struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func newAccountSync() *accountSync {
+	return &accountSync{done: make(chan struct{})}
+}
+
+func (synced *accountSync) close() {
+	synced.once.Do(func() { close(synced.done) })
+}
+
+// accountKeypath returns the keypath of the account with the given index. The account index is the third
+// element of bitcoin based keypaths, e.g. m/84'/0'/<index>', and the last element of ethereum
+// keypaths, e.g. m/44'/60'/0'/0/<index>, as used by most ethereum wallets.
+func (template *accountTemplate) accountKeypath(index uint32) (signing.AbsoluteKeypath, error) {
+	elements := template.keypath.ToUInt32()
+	switch template.coin.(type) {
+	case *btc.Coin:
+		if len(elements) != 3 {
+			return signing.AbsoluteKeypath{}, errp.Newf("unexpected keypath %s", template.keypath.Encode())
+		}
+		elements[2] = hdkeychain.HardenedKeyStart + index
+	case *eth.Coin:
+		if len(elements) != 5 {
+			return signing.AbsoluteKeypath{}, errp.Newf("unexpected keypath %s", template.keypath.Encode())
+		}
+		elements[4] = index
+	default:
+		return signing.AbsoluteKeypath{}, errp.New("unknown coin type")
+	}
+	keypath := "m"
+	for _, element := range elements {
+		if element >= hdkeychain.HardenedKeyStart {
+			keypath += fmt.Sprintf("/%d'", element-hdkeychain.HardenedKeyStart)
+		} else {
+			keypath += fmt.Sprintf("/%d", element)
+		}
+	}
+	return signing.NewAbsoluteKeypath(keypath)
+}
+
+// accountConfiguration returns the signing configuration of the account with the given index.
+func (backend *Backend) accountConfiguration(
+	template *accountTemplate, index uint32) (*signing.Configuration, error) {
+	keypath, err := template.accountKeypath(index)
+	if err != nil {
+		return nil, err
+	}
+	return backend.keystores.Configuration(
+		template.coin, template.scriptType, keypath, backend.keystores.Count())
+}
+
+// accountPersisted returns true if the account with the given configuration is in the accounts
+// config.
+func (backend *Backend) accountPersisted(coin coin.Coin, configuration *signing.Configuration) bool {
+	for _, account := range backend.config.AccountsConfig().Accounts {
+		if account.CoinCode == coin.Code() && account.Configuration.Hash() == configuration.Hash() {
+			return true
+		}
+	}
+	return false
+}
+
+// nextAccountIndex returns the lowest account index above 0 whose account is not persisted yet.
+func (backend *Backend) nextAccountIndex(template *accountTemplate) (uint32, error) {
+	for index := uint32(1); index <= maxAccountIndex; index++ {
+		configuration, err := backend.accountConfiguration(template, index)
+		if err != nil {
+			return 0, err
+		}
+		if !backend.accountPersisted(template.coin, configuration) {
+			return index, nil
+		}
+	}
+	return 0, errp.WithStack(ErrMaxAccountsReached)
+}
+
+// accountRemoved returns true if the account with the given configuration was removed by the
+// user.
+func (backend *Backend) accountRemoved(coin coin.Coin, configuration *signing.Configuration) bool {
+	for _, account := range backend.config.AccountsConfig().RemovedAccounts {
+		if account.CoinCode == coin.Code() && account.Configuration.Hash() == configuration.Hash() {
+			return true
+		}
+	}
+	return false
+}
+
+// lastAccountIndex returns the highest account index above 0 whose account is persisted or was
+// removed by the user, or 0 if there is none.
+func (backend *Backend) lastAccountIndex(template *accountTemplate) (uint32, error) {
+	accountsConfig := backend.config.AccountsConfig()
+	keypaths := map[string]struct{}{}
+	for _, accountList := range [][]config.Account{accountsConfig.Accounts, accountsConfig.RemovedAccounts} {
+		for _, account := range accountList {
+			if account.CoinCode == template.coin.Code() {
+				keypaths[account.Configuration.AbsoluteKeypath().Encode()] = struct{}{}
+			}
+		}
+	}
+	for index := uint32(maxAccountIndex); index > 0; index-- {
+		keypath, err := template.accountKeypath(index)
+		if err != nil {
+			return 0, err
+		}
+		// Only the candidates are derived, as the keystore might have to query the device.
+		if _, ok := keypaths[keypath.Encode()]; !ok {
+			continue
+		}
+		configuration, err := backend.accountConfiguration(template, index)
+		if err != nil {
+			return 0, err
+		}
+		if backend.accountPersisted(template.coin, configuration) ||
+			backend.accountRemoved(template.coin, configuration) {
+			return index, nil
+		}
+	}
+	return 0, nil
+}
+
+// addAccountAtIndex persists the account with the given index and adds it to the backend.
+func (backend *Backend) addAccountAtIndex(template *accountTemplate, index uint32) (string, error) {
+	configuration, err := backend.accountConfiguration(template, index)
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%s-%s", configuration.Hash(), template.coin.Code())
+	name := fmt.Sprintf("%s %d", template.name, index+1)
+	getSigningConfiguration := func() (*signing.Configuration, error) {
+		return configuration, nil
+	}
+	backend.log.WithField("code", code).WithField("name", name).Info("adding account")
+	if err := backend.CreateAndAddAccount(
+		template.coin, code, name, getSigningConfiguration, true, false); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// multipleAccounts returns true if further accounts can be derived from the default account. This
+// is the case for the single-sig accounts of the native coins.
+func (backend *Backend) multipleAccounts(template *accountTemplate) bool {
+	return !backend.arguments.Multisig() && !strings.HasPrefix(template.code, erc20CoinCodePrefix)
+}
+
+func (backend *Backend) accountTemplate(code string) (*accountTemplate, error) {
+	defer backend.accountsLock.RLock()()
+	for _, template := range backend.defaultAccounts {
+		if template.code == code && backend.multipleAccounts(&template) {
+			template := template
+			return &template, nil
+		}
+	}
+	return nil, errp.Newf("unknown account %s", code)
+}
+
+// AddNextAccount adds the next account of the same coin and script type as the default account with
+// the given code, e.g. the account m/84'/0'/1' for the account m/84'/0'/0'. The account is persisted
+// in the accounts config. Returns the code of the new account.
+func (backend *Backend) AddNextAccount(code string) (string, error) {
+	template, err := backend.accountTemplate(code)
+	if err != nil {
+		return "", err
+	}
+	defer backend.discoveryLock.Lock()()
+	index, err := backend.nextAccountIndex(template)
+	if err != nil {
+		return "", err
+	}
+	return backend.addAccountAtIndex(template, index)
+}
+
+// newDiscoveryAccount creates the account synced by accountHasHistory(). The account is not signed,
+// so no keystores are passed.
+func (backend *Backend) newDiscoveryAccount(
+	template *accountTemplate,
+	configuration *signing.Configuration,
+	dbFolder string,
+	onEvent func(accounts.Event),
+) (accounts.Interface, error) {
+	getSigningConfiguration := func() (*signing.Configuration, error) {
+		return configuration, nil
+	}
+	getNotifier := func(configuration *signing.Configuration) accounts.Notifier {
+		return backend.notifier.ForAccount(fmt.Sprintf("%s-%s", configuration.Hash(), template.coin.Code()))
+	}
+	switch specificCoin := template.coin.(type) {
+	case *btc.Coin:
+		return btc.NewAccount(specificCoin, dbFolder, backend.getStorageKey(), "discovery", "",
+			getSigningConfiguration, keystore.NewKeystores(), getNotifier, backend.labels, onEvent,
+			backend.log, backend.ratesUpdater), nil
+	case *eth.Coin:
+		return eth.NewAccount(specificCoin, dbFolder, backend.getStorageKey(), "discovery", "",
+			getSigningConfiguration, keystore.NewKeystores(), getNotifier, onEvent,
+			backend.log, backend.ratesUpdater), nil
+	default:
+		return nil, errp.New("unknown coin type")
+	}
+}
+
+// accountHasHistory syncs the account with the given index in a temporary database and returns true
+// if it has any transactions.
+func (backend *Backend) accountHasHistory(template *accountTemplate, index uint32) (bool, error) {
+	configuration, err := backend.accountConfiguration(template, index)
+	if err != nil {
+		return false, err
+	}
+	dbFolder := filepath.Join(backend.arguments.CacheDirectoryPath(), "discovery")
+	if err := os.MkdirAll(dbFolder, 0700); err != nil {
+		return false, errp.WithStack(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dbFolder); err != nil {
+			backend.log.WithError(err).Error("Could not remove the discovery databases")
+		}
+	}()
+
+	synced := make(chan struct{}, 1)
+	onEvent := func(event accounts.Event) {
+		if event == accounts.EventSyncDone {
+			select {
+			case synced <- struct{}{}:
+			default:
+			}
+		}
+	}
+	account, err := backend.makeDiscoveryAccount(template, configuration, dbFolder, onEvent)
+	if err != nil {
+		return false, err
+	}
+	defer account.Close()
+	if err := account.Initialize(); err != nil {
+		return false, err
+	}
+	select {
+	case <-synced:
+	case <-time.After(discoverySyncTimeout):
+		return false, errp.New("timeout while syncing the account")
+	}
+	transactions, err := account.Transactions()
+	if err != nil {
+		return false, err
+	}
+	return len(transactions) > 0, nil
+}
+
+// loadedAccountHasHistory returns true if the loaded account with the given code has any
+// transactions, once it is synced. The account is not synced again for the discovery. The second
+// return value is false if the account is not loaded, e.g. because it is hidden.
+func (backend *Backend) loadedAccountHasHistory(code string) (bool, bool, error) {
+	var account accounts.Interface
+	unlock := backend.accountsLock.RLock()
+	for _, loadedAccount := range backend.accounts {
+		if loadedAccount.Code() == code {
+			account = loadedAccount
+		}
+	}
+	synced := backend.accountsSynced[code]
+	unlock()
+	if account == nil || synced == nil {
+		return false, false, nil
+	}
+	// Initializing an account again is a no-op.
+	if err := account.Initialize(); err != nil {
+		return false, true, err
+	}
+	select {
+	case <-synced.done:
+	case <-time.After(discoverySyncTimeout):
+		return false, true, errp.New("timeout while syncing the account")
+	}
+	if !backend.accountLoaded(code) {
+		return false, true, errp.New("the account was closed")
+	}
+	transactions, err := account.Transactions()
+	if err != nil {
+		return false, true, err
+	}
+	return len(transactions) > 0, true, nil
+}
+
+// discoverAccounts adds the accounts with history beyond the default accounts, BIP44 style: for
+// each default account, the account indexes after the last persisted or removed account are scanned
+// until an account without history is found. The discovered accounts are persisted in the accounts
+// config. Accounts removed by the user are not added again.
+func (backend *Backend) discoverAccounts() {
+	defer backend.discoveryLock.Lock()()
+	unlock := backend.accountsLock.RLock()
+	templates := append([]accountTemplate{}, backend.defaultAccounts...)
+	unlock()
+	for _, template := range templates {
+		template := template
+		if !backend.multipleAccounts(&template) {
+			continue
+		}
+		log := backend.log.WithField("code", template.code)
+		if backend.keystores.Count() == 0 {
+			// The keystore was deregistered in the meantime.
+			return
+		}
+		lastIndex, err := backend.lastAccountIndex(&template)
+		if err != nil {
+			log.WithError(err).Error("Account discovery failed")
+			continue
+		}
+		// The persisted accounts were used, except maybe the default account, which needs to be
+		// checked. The loaded default account is synced anyway, so only a hidden one is synced
+		// separately.
+		if lastIndex == 0 {
+			hasHistory, loaded, err := backend.loadedAccountHasHistory(template.code)
+			if err == nil && !loaded {
+				hasHistory, err = backend.accountHasHistory(&template, 0)
+			}
+			if err != nil {
+				log.WithError(err).Error("Account discovery failed")
+				continue
+			}
+			if !hasHistory {
+				continue
+			}
+		}
+		for index := lastIndex + 1; index <= maxAccountIndex && backend.keystores.Count() != 0; index++ {
+			hasHistory, err := backend.accountHasHistory(&template, index)
+			if err != nil {
+				log.WithError(err).Error("Account discovery failed")
+				break
+			}
+			if !hasHistory {
+				break
+			}
+			if _, err := backend.addAccountAtIndex(&template, index); err != nil {
+				log.WithError(err).Error("Could not add the discovered account")
+				break
+			}
+		}
+	}
+}
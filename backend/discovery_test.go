@@ -0,0 +1,300 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore/software"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+// discoveryAccount is synced right away and has the given number of transactions. The other
+// methods are not used by the discovery.
+type discoveryAccount struct {
+	accounts.Interface
+	code            string
+	coin            coin.Coin
+	numTransactions int
+	onEvent         func(accounts.Event)
+	closed          bool
+}
+
+func (account *discoveryAccount) Initialize() error {
+	go account.onEvent(accounts.EventSyncDone)
+	return nil
+}
+
+func (account *discoveryAccount) Code() string {
+	return account.code
+}
+
+func (account *discoveryAccount) Coin() coin.Coin {
+	return account.coin
+}
+
+func (account *discoveryAccount) Transactions() ([]accounts.Transaction, error) {
+	return make([]accounts.Transaction, account.numTransactions), nil
+}
+
+func (account *discoveryAccount) Close() {
+	account.closed = true
+}
+
+// newDiscoveryBackend returns a backend with a software keystore and a TBTC default account. The
+// accounts at the indexes of history have transactions. The synced indexes are returned by the
+// second return value.
+func newDiscoveryBackend(t *testing.T, history map[uint32]bool) (*Backend, *accountTemplate, func() []uint32) {
+	t.Helper()
+	backend, err := NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-discovery-"), false, false, false, false, false),
+		nil,
+	)
+	require.NoError(t, err)
+	backend.OnAccountInit(func(accounts.Interface) {})
+	backend.OnAccountUninit(func(accounts.Interface) {})
+	require.NoError(t, backend.keystores.Add(software.NewKeystoreFromPIN(0, "1234")))
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/1'/0'")
+	require.NoError(t, err)
+	template := accountTemplate{
+		coin: btc.NewCoin(coinTBTC, "TBTC", &chaincfg.TestNet3Params, ".", []*rpc.ServerInfo{}, "", nil,
+			0, "", socksproxy.NewSocksProxy(false, "")),
+		code:       "tbtc-p2wpkh",
+		name:       "Bitcoin Testnet",
+		keypath:    keypath,
+		scriptType: signing.ScriptTypeP2WPKH,
+	}
+	backend.defaultAccounts = []accountTemplate{template}
+
+	var lock sync.Mutex
+	synced := []uint32{}
+	backend.makeDiscoveryAccount = func(
+		template *accountTemplate,
+		configuration *signing.Configuration,
+		dbFolder string,
+		onEvent func(accounts.Event),
+	) (accounts.Interface, error) {
+		index := configuration.AbsoluteKeypath().ToUInt32()[2] - 0x80000000
+		defer lock.Unlock()
+		lock.Lock()
+		synced = append(synced, index)
+		numTransactions := 0
+		if history[index] {
+			numTransactions = 1
+		}
+		return &discoveryAccount{numTransactions: numTransactions, onEvent: onEvent}, nil
+	}
+	return backend, &template, func() []uint32 {
+		defer lock.Unlock()
+		lock.Lock()
+		return synced
+	}
+}
+
+// persistedAccountIndexes returns the indexes of the accounts in the accounts config.
+func persistedAccountIndexes(t *testing.T, backend *Backend) []uint32 {
+	t.Helper()
+	indexes := []uint32{}
+	for _, account := range backend.config.AccountsConfig().Accounts {
+		indexes = append(indexes, account.Configuration.AbsoluteKeypath().ToUInt32()[2]-0x80000000)
+	}
+	return indexes
+}
+
+func TestAccountKeypath(t *testing.T) {
+	newTemplate := func(coin coin.Coin, keypath string) *accountTemplate {
+		absoluteKeypath, err := signing.NewAbsoluteKeypath(keypath)
+		require.NoError(t, err)
+		return &accountTemplate{coin: coin, keypath: absoluteKeypath}
+	}
+
+	keypath, err := newTemplate(&btc.Coin{}, "m/84'/0'/0'").accountKeypath(2)
+	require.NoError(t, err)
+	require.Equal(t, "m/84'/0'/2'", keypath.Encode())
+
+	keypath, err = newTemplate(&eth.Coin{}, "m/44'/60'/0'/0/0").accountKeypath(3)
+	require.NoError(t, err)
+	require.Equal(t, "m/44'/60'/0'/0/3", keypath.Encode())
+
+	_, err = newTemplate(&btc.Coin{}, "m/48'/0'/0'/2'").accountKeypath(1)
+	require.Error(t, err)
+}
+
+func TestAccountHasHistory(t *testing.T) {
+	backend, template, synced := newDiscoveryBackend(t, map[uint32]bool{1: true})
+	var account *discoveryAccount
+	makeDiscoveryAccount := backend.makeDiscoveryAccount
+	backend.makeDiscoveryAccount = func(
+		template *accountTemplate,
+		configuration *signing.Configuration,
+		dbFolder string,
+		onEvent func(accounts.Event),
+	) (accounts.Interface, error) {
+		require.DirExists(t, dbFolder)
+		result, err := makeDiscoveryAccount(template, configuration, dbFolder, onEvent)
+		account = result.(*discoveryAccount)
+		return result, err
+	}
+
+	hasHistory, err := backend.accountHasHistory(template, 1)
+	require.NoError(t, err)
+	require.True(t, hasHistory)
+	require.True(t, account.closed)
+	hasHistory, err = backend.accountHasHistory(template, 2)
+	require.NoError(t, err)
+	require.False(t, hasHistory)
+	require.True(t, account.closed)
+	require.Equal(t, []uint32{1, 2}, synced())
+
+	// The temporary databases are removed.
+	_, err = os.Stat(filepath.Join(backend.arguments.CacheDirectoryPath(), "discovery"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestNextAccountIndex(t *testing.T) {
+	backend, template, _ := newDiscoveryBackend(t, nil)
+	index, err := backend.nextAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), index)
+	lastIndex, err := backend.lastAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), lastIndex)
+
+	for _, index := range []uint32{1, 2, 3} {
+		_, err := backend.addAccountAtIndex(template, index)
+		require.NoError(t, err)
+	}
+	index, err = backend.nextAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), index)
+
+	// Hidden accounts are still persisted, so their index is not used again.
+	require.NoError(t, backend.SetAccountHidden(backend.config.AccountsConfig().Accounts[2].Code, true))
+	index, err = backend.nextAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), index)
+	lastIndex, err = backend.lastAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), lastIndex)
+
+	// The index of a removed account is used again by the next added account, but the discovery
+	// continues after the last account.
+	require.NoError(t, backend.RemoveAccount(backend.config.AccountsConfig().Accounts[1].Code))
+	require.Equal(t, []uint32{1, 3}, persistedAccountIndexes(t, backend))
+	index, err = backend.nextAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), index)
+	lastIndex, err = backend.lastAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), lastIndex)
+
+	// The last account is still considered by the discovery after it was removed.
+	require.NoError(t, backend.RemoveAccount(backend.config.AccountsConfig().Accounts[1].Code))
+	require.Equal(t, []uint32{1}, persistedAccountIndexes(t, backend))
+	lastIndex, err = backend.lastAccountIndex(template)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), lastIndex)
+	require.Len(t, backend.config.AccountsConfig().RemovedAccounts, 2)
+
+	// Adding a removed account again undoes the removal.
+	_, err = backend.addAccountAtIndex(template, 3)
+	require.NoError(t, err)
+	require.Len(t, backend.config.AccountsConfig().RemovedAccounts, 1)
+	require.NoError(t, backend.RemoveAccount(backend.config.AccountsConfig().Accounts[1].Code))
+
+	accountsConfig := backend.config.AccountsConfig()
+	for index := uint32(1); index <= maxAccountIndex; index++ {
+		configuration, err := backend.accountConfiguration(template, index)
+		require.NoError(t, err)
+		accountsConfig.Accounts = append(accountsConfig.Accounts, config.Account{
+			CoinCode: coinTBTC, Configuration: configuration,
+		})
+	}
+	require.NoError(t, backend.config.SetAccountsConfig(accountsConfig))
+	_, err = backend.nextAccountIndex(template)
+	require.Equal(t, ErrMaxAccountsReached, errp.Cause(err))
+}
+
+func TestDiscoverAccounts(t *testing.T) {
+	// The default account has no history, so nothing else is checked.
+	backend, _, synced := newDiscoveryBackend(t, map[uint32]bool{1: true})
+	backend.discoverAccounts()
+	require.Equal(t, []uint32{0}, synced())
+	require.Empty(t, persistedAccountIndexes(t, backend))
+
+	// The scan stops at the first account without history, later accounts are not found.
+	backend, _, synced = newDiscoveryBackend(t, map[uint32]bool{0: true, 1: true, 2: true, 4: true})
+	backend.discoverAccounts()
+	require.Equal(t, []uint32{0, 1, 2, 3}, synced())
+	require.Equal(t, []uint32{1, 2}, persistedAccountIndexes(t, backend))
+
+	// The scan continues after the last persisted account, even if accounts before it were
+	// removed.
+	backend, template, synced := newDiscoveryBackend(t, map[uint32]bool{0: true, 4: true, 5: true})
+	for _, index := range []uint32{1, 3} {
+		_, err := backend.addAccountAtIndex(template, index)
+		require.NoError(t, err)
+	}
+	backend.discoverAccounts()
+	require.Equal(t, []uint32{4, 5, 6}, synced())
+	require.Equal(t, []uint32{1, 3, 4, 5}, persistedAccountIndexes(t, backend))
+
+	// A removed account with history is not added again.
+	backend, template, synced = newDiscoveryBackend(t, map[uint32]bool{0: true, 1: true, 2: true})
+	code, err := backend.addAccountAtIndex(template, 1)
+	require.NoError(t, err)
+	require.NoError(t, backend.RemoveAccount(code))
+	backend.discoverAccounts()
+	require.Equal(t, []uint32{2, 3}, synced())
+	require.Equal(t, []uint32{2}, persistedAccountIndexes(t, backend))
+
+	// The loaded default account is not synced again.
+	backend, template, synced = newDiscoveryBackend(t, map[uint32]bool{1: true})
+	backend.accountsSynced[template.code] = newAccountSync()
+	backend.accounts = append(backend.accounts, &discoveryAccount{
+		code:            template.code,
+		coin:            template.coin,
+		numTransactions: 1,
+		onEvent: func(event accounts.Event) {
+			if event == accounts.EventSyncDone {
+				backend.accountsSynced[template.code].close()
+			}
+		},
+	})
+	backend.discoverAccounts()
+	require.Equal(t, []uint32{1, 2}, synced())
+	require.Equal(t, []uint32{1}, persistedAccountIndexes(t, backend))
+
+	// Accounts are not discovered without a keystore.
+	backend, _, synced = newDiscoveryBackend(t, map[uint32]bool{0: true, 1: true})
+	require.NoError(t, backend.keystores.Remove(backend.keystores.Keystores()[0]))
+	backend.discoverAccounts()
+	require.Empty(t, synced())
+}
@@ -14,62 +14,229 @@
 
 package backend
 
-import "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/erc20"
+import (
+	"strings"
 
-type erc20Token struct {
-	code  string
-	name  string
-	unit  string
-	token *erc20.Token
-}
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Note: if you change the coinCode prefix from eth-erc20- to something else, make sure to check for
+// instances of it in the frontend.
+const erc20CoinCodePrefix = "eth-erc20-"
+
+// ErrERC20TokenAlreadyExists is returned when adding a token whose contract address is already
+// configured.
+var ErrERC20TokenAlreadyExists = errp.New("The token has already been added")
+
+// ErrERC20TokenBuiltin is returned when removing a built-in token, which can only be deactivated.
+var ErrERC20TokenBuiltin = errp.New("Built-in tokens can't be removed")
+
+// ERC20TokenWarning is a warning about an added erc20 token which should be shown to the user.
+type ERC20TokenWarning string
+
+const (
+	// ERC20TokenWarningNonStandard means that the contract does not provide its name or symbol, so
+	// the token might not be displayed correctly.
+	ERC20TokenWarningNonStandard ERC20TokenWarning = "nonStandard"
+	// ERC20TokenWarningUnitCollision means that the symbol of the token is the unit of a built-in
+	// coin or token, so the token could be mistaken for it.
+	ERC20TokenWarningUnitCollision ERC20TokenWarning = "unitCollision"
+)
 
-var erc20Tokens = []erc20Token{
-	// Note: if you change the coinCode from eth-erc20- to something else, make sure to check for
-	// instances of it in the frontend.
+// builtinCoinUnits are the units of the coins which are not erc20 tokens, see Coin().
+var builtinCoinUnits = []string{"BTC", "TBTC", "RBTC", "LTC", "TLTC", "ETH", "TETH", "RETH", "TEST"}
+
+// builtinERC20Tokens are the erc20 tokens known to the app. They are not stored in the config, so
+// that updates of the list reach all users.
+// Note: if you change the token codes, make sure to check for instances of the coin codes
+// (eth-erc20-<code>) in the frontend.
+var builtinERC20Tokens = []config.ERC20Token{
 	{
-		code:  "eth-erc20-usdt",
-		name:  "Tether USD BETA",
-		unit:  "USDT",
-		token: erc20.NewToken("0xdac17f958d2ee523a2206206994597c13d831ec7", 6),
+		Code:            "usdt",
+		ContractAddress: "0xdac17f958d2ee523a2206206994597c13d831ec7",
+		Name:            "Tether USD",
+		Unit:            "USDT",
+		Decimals:        6,
 	},
 	{
-		code:  "eth-erc20-bat",
-		name:  "Basic Attention Token BETA",
-		unit:  "BAT",
-		token: erc20.NewToken("0x0d8775f648430679a709e98d2b0cb6250d2887ef", 18),
+		Code:            "bat",
+		ContractAddress: "0x0d8775f648430679a709e98d2b0cb6250d2887ef",
+		Name:            "Basic Attention Token",
+		Unit:            "BAT",
+		Decimals:        18,
 	},
 	{
-		code:  "eth-erc20-dai",
-		name:  "Dai BETA",
-		unit:  "DAI",
-		token: erc20.NewToken("0x89d24a6b4ccb1b6faa2625fe562bdd9a23260359", 18),
+		Code:            "dai",
+		ContractAddress: "0x89d24a6b4ccb1b6faa2625fe562bdd9a23260359",
+		Name:            "Dai",
+		Unit:            "DAI",
+		Decimals:        18,
 	},
 	{
-		code:  "eth-erc20-link",
-		name:  "Chainlink BETA",
-		unit:  "LINK",
-		token: erc20.NewToken("0x514910771af9ca656af840dff83e8264ecf986ca", 18),
+		Code:            "link",
+		ContractAddress: "0x514910771af9ca656af840dff83e8264ecf986ca",
+		Name:            "Chainlink",
+		Unit:            "LINK",
+		Decimals:        18,
 	},
 	{
-		code:  "eth-erc20-mkr",
-		name:  "Maker BETA",
-		unit:  "MKR",
-		token: erc20.NewToken("0x9f8f72aa9304c8b593d555f12ef6589cc3a579a2", 18),
+		Code:            "mkr",
+		ContractAddress: "0x9f8f72aa9304c8b593d555f12ef6589cc3a579a2",
+		Name:            "Maker",
+		Unit:            "MKR",
+		Decimals:        18,
 	},
 	{
-		code:  "eth-erc20-zrx",
-		name:  "0x BETA",
-		unit:  "ZRX",
-		token: erc20.NewToken("0xe41d2489571d322189246dafa5ebde1f4699f498", 18),
+		Code:            "zrx",
+		ContractAddress: "0xe41d2489571d322189246dafa5ebde1f4699f498",
+		Name:            "0x",
+		Unit:            "ZRX",
+		Decimals:        18,
 	},
 }
 
-func erc20TokenByCode(code string) *erc20Token {
-	for _, token := range erc20Tokens {
-		if code == token.code {
+// ERC20Tokens returns the built-in erc20 tokens followed by the ones added by the user.
+func (backend *Backend) ERC20Tokens() []config.ERC20Token {
+	tokens := append([]config.ERC20Token{}, builtinERC20Tokens...)
+	return append(tokens, userERC20Tokens(backend.config.AppConfig().Backend.ETH.ERC20Tokens)...)
+}
+
+// erc20TokenByCode returns the configured erc20 token with the given coin code, e.g.
+// "eth-erc20-usdt", or nil if there is none.
+func (backend *Backend) erc20TokenByCode(code string) *config.ERC20Token {
+	if !strings.HasPrefix(code, erc20CoinCodePrefix) {
+		return nil
+	}
+	for _, token := range backend.ERC20Tokens() {
+		if code == erc20CoinCodePrefix+token.Code {
 			token := token
 			return &token
 		}
 	}
 	return nil
 }
+
+// isBuiltinUnit returns true if the unit is the unit of a built-in coin or token, ignoring the case.
+func (backend *Backend) isBuiltinUnit(unit string) bool {
+	for _, coinUnit := range builtinCoinUnits {
+		if strings.EqualFold(unit, coinUnit) {
+			return true
+		}
+	}
+	for _, token := range backend.ERC20Tokens() {
+		if !token.UserAdded && strings.EqualFold(unit, token.Unit) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddERC20Token adds and activates the erc20 token deployed at the given contract address. The
+// name, symbol and decimals are fetched from the contract. The unit of the token is its symbol,
+// which is not used to look up the fiat rates, as any contract can claim any symbol. The returned
+// warnings should be shown to the user.
+func (backend *Backend) AddERC20Token(contractAddress string) (*config.ERC20Token, []ERC20TokenWarning, error) {
+	if !common.IsHexAddress(contractAddress) {
+		return nil, nil, errp.New("invalid erc20 contract address")
+	}
+	address := common.HexToAddress(contractAddress)
+	for _, token := range backend.ERC20Tokens() {
+		if common.HexToAddress(token.ContractAddress) == address {
+			return nil, nil, errp.WithStack(ErrERC20TokenAlreadyExists)
+		}
+	}
+	ethCoin, err := backend.Coin(coinETH)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := ethCoin.(*eth.Coin).ERC20TokenInfo(address.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+	token := config.ERC20Token{
+		Code:            strings.ToLower(address.Hex()[2:]),
+		ContractAddress: strings.ToLower(address.Hex()),
+		Name:            info.Name,
+		Unit:            info.Symbol,
+		Decimals:        info.Decimals,
+		UserAdded:       true,
+	}
+	if token.Unit == "" {
+		token.Unit = "ERC20"
+	}
+	if token.Name == "" {
+		token.Name = token.Unit
+	}
+	warnings := []ERC20TokenWarning{}
+	if !info.Standard {
+		warnings = append(warnings, ERC20TokenWarningNonStandard)
+	}
+	if backend.isBuiltinUnit(token.Unit) {
+		backend.log.WithField("unit", token.Unit).Warn("erc20 token unit collides with a built-in unit")
+		warnings = append(warnings, ERC20TokenWarningUnitCollision)
+	}
+
+	appConfig := backend.config.AppConfig()
+	ethConfig := &appConfig.Backend.ETH
+	// Copy the slices, as they are shared with the current config. Only user-added tokens are
+	// stored.
+	ethConfig.ERC20Tokens = append(userERC20Tokens(ethConfig.ERC20Tokens), token)
+	ethConfig.ActiveERC20Tokens = append(append([]string{}, ethConfig.ActiveERC20Tokens...), token.Code)
+	if err := backend.config.SetAppConfig(appConfig); err != nil {
+		return nil, nil, err
+	}
+	backend.log.WithField("code", token.Code).Info("added erc20 token")
+	backend.UpdateAccounts()
+	return &token, warnings, nil
+}
+
+// userERC20Tokens returns a copy of the user-added tokens of the config, dropping the copies of the
+// built-in tokens stored by previous versions.
+func userERC20Tokens(tokens []config.ERC20Token) []config.ERC20Token {
+	result := []config.ERC20Token{}
+	for _, token := range tokens {
+		if token.UserAdded {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+// RemoveERC20Token removes the user-added erc20 token with the given token code and closes its
+// account. ErrERC20TokenBuiltin is returned for built-in tokens, which can only be deactivated.
+func (backend *Backend) RemoveERC20Token(code string) error {
+	for _, token := range builtinERC20Tokens {
+		if token.Code == code {
+			return errp.WithStack(ErrERC20TokenBuiltin)
+		}
+	}
+	appConfig := backend.config.AppConfig()
+	ethConfig := &appConfig.Backend.ETH
+	userTokens := userERC20Tokens(ethConfig.ERC20Tokens)
+	tokens := []config.ERC20Token{}
+	for _, token := range userTokens {
+		if token.Code != code {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == len(userTokens) {
+		return errp.Newf("unknown erc20 token %s", code)
+	}
+	activeTokens := []string{}
+	for _, activeCode := range ethConfig.ActiveERC20Tokens {
+		if activeCode != code {
+			activeTokens = append(activeTokens, activeCode)
+		}
+	}
+	ethConfig.ERC20Tokens = tokens
+	ethConfig.ActiveERC20Tokens = activeTokens
+	if err := backend.config.SetAppConfig(appConfig); err != nil {
+		return err
+	}
+	backend.log.WithField("code", code).Info("removed erc20 token")
+	backend.UpdateAccounts()
+	return nil
+}
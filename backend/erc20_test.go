@@ -0,0 +1,116 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	coinpkg "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestERC20Tokens(t *testing.T) {
+	backend, err := NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-erc20-"), false, false, false, false, false),
+		nil,
+	)
+	require.NoError(t, err)
+
+	require.Nil(t, backend.erc20TokenByCode("eth"))
+	require.Nil(t, backend.erc20TokenByCode("eth-erc20-unknown"))
+	token := backend.erc20TokenByCode("eth-erc20-usdt")
+	require.NotNil(t, token)
+	require.Equal(t, "USDT", token.Unit)
+
+	coin, err := backend.Coin("eth-erc20-usdt")
+	require.NoError(t, err)
+	require.Equal(t, "USDT", coin.Unit(false))
+	require.Equal(t, uint(6), coin.Decimals(false))
+	require.Equal(t,
+		common.HexToAddress(token.ContractAddress), coin.(*eth.Coin).ERC20Token().ContractAddress())
+
+	// Adding a known token fails before querying the contract.
+	_, _, err = backend.AddERC20Token("0xDAC17F958D2EE523A2206206994597C13D831EC7")
+	require.Equal(t, ErrERC20TokenAlreadyExists, errp.Cause(err))
+	_, _, err = backend.AddERC20Token("invalid")
+	require.Error(t, err)
+
+	require.True(t, backend.isBuiltinUnit("usdt"))
+	require.True(t, backend.isBuiltinUnit("ETH"))
+	require.False(t, backend.isBuiltinUnit("FOO"))
+
+	// User-added tokens are not priced by their unit, which might collide with a built-in unit.
+	appConfig := backend.config.AppConfig()
+	appConfig.Backend.ETH.ERC20Tokens = append(appConfig.Backend.ETH.ERC20Tokens, config.ERC20Token{
+		Code:            "2f45b6fb2f28a73f110400386da31044b2e953d4",
+		ContractAddress: "0x2f45b6fb2f28a73f110400386da31044b2e953d4",
+		Name:            "Fake Dai",
+		Unit:            "DAI",
+		Decimals:        18,
+		UserAdded:       true,
+	})
+	require.NoError(t, backend.config.SetAppConfig(appConfig))
+	require.True(t, backend.isBuiltinUnit("DAI"))
+	userCoin, err := backend.Coin("eth-erc20-2f45b6fb2f28a73f110400386da31044b2e953d4")
+	require.NoError(t, err)
+	require.Equal(t, "DAI", userCoin.Unit(false))
+	require.Equal(t, "", coinpkg.RatesUnit(userCoin, false))
+	require.Equal(t, "ETH", coinpkg.RatesUnit(userCoin, true))
+	require.Equal(t, "USDT", coinpkg.RatesUnit(coin, false))
+
+	// Only the user-added tokens are stored in the config, the built-in ones are merged.
+	require.Len(t, backend.config.AppConfig().Backend.ETH.ERC20Tokens, 1)
+	require.Len(t, backend.ERC20Tokens(), len(builtinERC20Tokens)+1)
+
+	// Built-in tokens can only be deactivated.
+	userCode := "2f45b6fb2f28a73f110400386da31044b2e953d4"
+	appConfig = backend.config.AppConfig()
+	appConfig.Backend.ETH.ActiveERC20Tokens = []string{"usdt", userCode}
+	require.NoError(t, backend.config.SetAppConfig(appConfig))
+	require.Equal(t, ErrERC20TokenBuiltin, errp.Cause(backend.RemoveERC20Token("usdt")))
+	require.NotNil(t, backend.erc20TokenByCode("eth-erc20-usdt"))
+	require.NoError(t, backend.RemoveERC20Token(userCode))
+	require.Nil(t, backend.erc20TokenByCode("eth-erc20-"+userCode))
+	require.Equal(t, []string{"usdt"}, backend.config.AppConfig().Backend.ETH.ActiveERC20Tokens)
+	require.Empty(t, backend.config.AppConfig().Backend.ETH.ERC20Tokens)
+	require.Error(t, backend.RemoveERC20Token(userCode))
+}
+
+// TestERC20TokensStaleConfig tests that the copies of the built-in tokens stored in the config by
+// previous versions are ignored, so the built-in list is always the current one.
+func TestERC20TokensStaleConfig(t *testing.T) {
+	backend, err := NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-erc20-"), false, false, false, false, false),
+		nil,
+	)
+	require.NoError(t, err)
+	appConfig := backend.config.AppConfig()
+	appConfig.Backend.ETH.ERC20Tokens = []config.ERC20Token{{
+		Code:            "usdt",
+		ContractAddress: "0xdac17f958d2ee523a2206206994597c13d831ec7",
+		Name:            "Tether USD (outdated)",
+		Unit:            "USDT",
+		Decimals:        18,
+	}}
+	require.NoError(t, backend.config.SetAppConfig(appConfig))
+	require.Equal(t, builtinERC20Tokens, backend.ERC20Tokens())
+	require.Equal(t, uint(6), backend.erc20TokenByCode("eth-erc20-usdt").Decimals)
+}
@@ -27,9 +27,9 @@ import (
 	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
@@ -39,6 +39,7 @@ import (
 	accountHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/handlers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/eth/erc20"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox"
 	bitboxHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox/handlers"
@@ -48,9 +49,11 @@ import (
 	bitbox02bootloaderHandlers "github.com/digitalbitbox/bitbox-wallet-app/backend/devices/bitbox02bootloader/handlers"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/device"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/labels"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/rates"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	utilConfig "github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
@@ -77,6 +80,7 @@ type Backend interface {
 		name string,
 		getSigningConfiguration func() (*signing.Configuration, error),
 		persist bool,
+		watchOnly bool,
 	) error
 	UserLanguage() language.Tag
 	OnAccountInit(f func(accounts.Interface))
@@ -95,14 +99,36 @@ type Backend interface {
 	Deregister(deviceID string)
 	TryMakeNewBase(ip string) (bool, error)
 	RatesUpdater() *rates.RateUpdater
+	UpdateRatesFiats()
+	Labels() *labels.Store
+	StorageStatus() backend.StorageStatus
+	UnlockStorage(passphrase string) error
+	EnableStorageEncryption(source encryption.Source, passphrase string) error
+	ChangeStorageSecret(oldPassphrase string, source encryption.Source, newPassphrase string) error
 	BitBoxBaseDeregister(bitboxBaseID string)
 	DownloadCert(string) (string, error)
 	CheckElectrumServer(string, string) error
 	RegisterTestKeystore(string)
 	NotifyUser(string)
 	SystemOpen(string) error
-	ReinitializeAccounts()
-	CheckForUpdateIgnoringErrors() *backend.UpdateFile
+	UpdateAccounts()
+	RenameAccount(code string, name string) error
+	SetAccountHidden(code string, hidden bool) error
+	RemoveAccount(code string) error
+	HiddenAccounts() []*backend.HiddenAccount
+	AddNextAccount(code string) (string, error)
+	ERC20Tokens() []config.ERC20Token
+	AddERC20Token(contractAddress string) (*config.ERC20Token, []backend.ERC20TokenWarning, error)
+	RemoveERC20Token(code string) error
+	CreateMultisigAccount(
+		coinCode string,
+		name string,
+		scriptType signing.ScriptType,
+		signingThreshold int,
+		cosigners []string,
+	) (string, error)
+	CheckForUpdate() (*backend.UpdateFile, error)
+	DownloadUpdate() (string, error)
 }
 
 // Handlers provides a web api to the backend.
@@ -173,23 +199,43 @@ func NewHandlers(
 	getAPIRouter(apiRouter)("/notify-user", handlers.postNotifyHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/open", handlers.postOpenHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/update", handlers.getUpdateHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/update/download", handlers.postDownloadUpdateHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/version", handlers.getVersionHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/testing", handlers.getTestingHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/account-add", handlers.postAddAccountHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/accounts", handlers.getAccountsHandler).Methods("GET")
-	getAPIRouter(apiRouter)("/accounts/reinitialize", handlers.postAccountsReinitializeHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/accounts/add-next", handlers.postAddNextAccountHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/accounts/add-multisig", handlers.postAddMultisigAccountHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/erc20-tokens", handlers.getERC20TokensHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/erc20-tokens/add", handlers.postAddERC20TokenHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/erc20-tokens/remove", handlers.postRemoveERC20TokenHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/accounts/rename", handlers.postRenameAccountHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/accounts/set-hidden", handlers.postSetAccountHiddenHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/accounts/remove", handlers.postRemoveAccountHandler).Methods("POST")
+	getAPIRouter(apiRouter)("/accounts/hidden", handlers.getHiddenAccountsHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/accounts-status", handlers.getAccountsStatusHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/export-account-summary", handlers.postExportAccountSummary).Methods("POST")
 	getAPIRouter(apiRouter)("/account-summary", handlers.getAccountSummary).Methods("GET")
+	getAPIRouter(apiRouter)("/labels/export", handlers.postExportLabels).Methods("POST")
+	getAPIRouter(apiRouter)("/labels/import", handlers.postImportLabels).Methods("POST")
+	getAPIRouter(apiRouter)("/storage/status", handlers.getStorageStatus).Methods("GET")
+	getAPIRouter(apiRouter)("/storage/unlock", handlers.postUnlockStorage).Methods("POST")
+	getAPIRouter(apiRouter)("/storage/enable-encryption", handlers.postEnableStorageEncryption).Methods("POST")
+	getAPIRouter(apiRouter)("/storage/change-secret", handlers.postChangeStorageSecret).Methods("POST")
 	getAPIRouter(apiRouter)("/test/register", handlers.postRegisterTestKeystoreHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/test/deregister", handlers.postDeregisterTestKeystoreHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/rates", handlers.getRatesHandler).Methods("GET")
+	getAPIRouter(apiRouter)("/rates/historical", handlers.getHistoricalRateHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/convertToFiat", handlers.getConvertToFiatHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/convertFromFiat", handlers.getConvertFromFiatHandler).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/tltc/headers/status", handlers.getHeadersStatus("tltc")).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/tbtc/headers/status", handlers.getHeadersStatus("tbtc")).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/ltc/headers/status", handlers.getHeadersStatus("ltc")).Methods("GET")
 	getAPIRouter(apiRouter)("/coins/btc/headers/status", handlers.getHeadersStatus("btc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/tltc/servers/status", handlers.getServersStatus("tltc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/tbtc/servers/status", handlers.getServersStatus("tbtc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/ltc/servers/status", handlers.getServersStatus("ltc")).Methods("GET")
+	getAPIRouter(apiRouter)("/coins/btc/servers/status", handlers.getServersStatus("btc")).Methods("GET")
 	getAPIRouter(apiRouter)("/certs/download", handlers.postCertsDownloadHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/certs/check", handlers.postCertsCheckHandler).Methods("POST")
 	getAPIRouter(apiRouter)("/bitboxbases/connectbase", handlers.postConnectBaseHandler).Methods("POST")
@@ -208,7 +254,7 @@ func NewHandlers(
 		if _, ok := accountHandlersMap[accountCode]; !ok {
 			accountHandlersMap[accountCode] = accountHandlers.NewHandlers(getAPIRouter(
 				apiRouter.PathPrefix(fmt.Sprintf("/account/%s", accountCode)).Subrouter(),
-			), log)
+			), backend.Labels(), log)
 		}
 		accHandlers := accountHandlersMap[accountCode]
 		log.WithField("account-handlers", accHandlers).Debug("Account handlers")
@@ -327,7 +373,13 @@ func (handlers *Handlers) postAppConfigHandler(r *http.Request) (interface{}, er
 	if err := json.NewDecoder(r.Body).Decode(&appConfig); err != nil {
 		return nil, errp.WithStack(err)
 	}
-	return nil, handlers.backend.Config().SetAppConfig(appConfig)
+	if err := handlers.backend.Config().SetAppConfig(appConfig); err != nil {
+		return nil, err
+	}
+	handlers.backend.UpdateRatesFiats()
+	// The settings may have activated or deactivated accounts.
+	handlers.backend.UpdateAccounts()
+	return nil, nil
 }
 
 func (handlers *Handlers) postNotifyHandler(r *http.Request) (interface{}, error) {
@@ -350,7 +402,33 @@ func (handlers *Handlers) postOpenHandler(r *http.Request) (interface{}, error)
 }
 
 func (handlers *Handlers) getUpdateHandler(_ *http.Request) (interface{}, error) {
-	return handlers.backend.CheckForUpdateIgnoringErrors(), nil
+	type response struct {
+		Success      bool                `json:"success"`
+		Update       *backend.UpdateFile `json:"update,omitempty"`
+		ErrorMessage string              `json:"errorMessage,omitempty"`
+	}
+	updateFile, err := handlers.backend.CheckForUpdate()
+	if err != nil {
+		handlers.log.WithError(err).Warn("Check for update failed.")
+		return response{Success: false, ErrorMessage: err.Error()}, nil
+	}
+	return response{Success: true, Update: updateFile}, nil
+}
+
+// postDownloadUpdateHandler downloads the release file of the new version and verifies it against
+// the signed update file. Returns the path of the downloaded file.
+func (handlers *Handlers) postDownloadUpdateHandler(_ *http.Request) (interface{}, error) {
+	type response struct {
+		Success      bool   `json:"success"`
+		Path         string `json:"path,omitempty"`
+		ErrorMessage string `json:"errorMessage,omitempty"`
+	}
+	path, err := handlers.backend.DownloadUpdate()
+	if err != nil {
+		handlers.log.WithError(err).Error("Downloading the update failed.")
+		return response{Success: false, ErrorMessage: err.Error()}, nil
+	}
+	return response{Success: true, Path: path}, nil
 }
 
 func (handlers *Handlers) getVersionHandler(_ *http.Request) (interface{}, error) {
@@ -361,33 +439,182 @@ func (handlers *Handlers) getTestingHandler(_ *http.Request) (interface{}, error
 	return handlers.backend.Testing(), nil
 }
 
+// postAddNextAccountHandler adds the next account of the same coin and script type as the default
+// account with the given code, e.g. `{"code": "btc-p2wpkh"}`.
+func (handlers *Handlers) postAddNextAccountHandler(r *http.Request) (interface{}, error) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	accountCode, err := handlers.backend.AddNextAccount(request.Code)
+	if errp.Cause(err) == backend.ErrMaxAccountsReached {
+		return map[string]interface{}{"success": false, "errorCode": "maxAccountsReached"}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success":      false,
+			"errorCode":    "unknown",
+			"errorMessage": err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success":     true,
+		"accountCode": accountCode,
+	}, nil
+}
+
+// postAddMultisigAccountHandler adds a multisig account signed by the registered keystores and the
+// given external cosigners, e.g. `{"coinCode": "btc", "name": "Savings", "scriptType": "p2wsh",
+// "threshold": 2, "cosigners": ["[d34db33f/48h/0h/0h/2h]Zpub..."]}`.
+func (handlers *Handlers) postAddMultisigAccountHandler(r *http.Request) (interface{}, error) {
+	var request struct {
+		CoinCode   string   `json:"coinCode"`
+		Name       string   `json:"name"`
+		ScriptType string   `json:"scriptType"`
+		Threshold  int      `json:"threshold"`
+		Cosigners  []string `json:"cosigners"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	scriptType, err := signing.DecodeScriptType(request.ScriptType)
+	if err != nil {
+		return nil, err
+	}
+	accountCode, err := handlers.backend.CreateMultisigAccount(
+		request.CoinCode, request.Name, scriptType, request.Threshold, request.Cosigners)
+	errorCodes := map[error]string{
+		backend.ErrAccountAlreadyExists: "alreadyExists",
+		backend.ErrMultisigUnsupported:  "unsupported",
+		backend.ErrInvalidThreshold:     "invalidThreshold",
+		backend.ErrInvalidCosigner:      "xpubInvalid",
+		keystore.ErrNoKeystore:          "noKeystore",
+	}
+	if errorCode, ok := errorCodes[errp.Cause(err)]; ok {
+		return map[string]interface{}{
+			"success":      false,
+			"errorCode":    errorCode,
+			"errorMessage": err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success":      false,
+			"errorCode":    "unknown",
+			"errorMessage": err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success":     true,
+		"accountCode": accountCode,
+	}, nil
+}
+
+// getERC20TokensHandler returns the built-in and the user-added erc20 tokens, which can be
+// activated in the config.
+func (handlers *Handlers) getERC20TokensHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.ERC20Tokens(), nil
+}
+
+// postAddERC20TokenHandler adds the erc20 token with the given contract address, e.g.
+// `{"contractAddress": "0x..."}`. The returned warning codes are "nonStandard" if the contract does
+// not provide its name or symbol, and "unitCollision" if the symbol is the unit of a built-in coin or
+// token.
+func (handlers *Handlers) postAddERC20TokenHandler(r *http.Request) (interface{}, error) {
+	var request struct {
+		ContractAddress string `json:"contractAddress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	token, warnings, err := handlers.backend.AddERC20Token(request.ContractAddress)
+	errorCodes := map[error]string{
+		backend.ErrERC20TokenAlreadyExists: "alreadyExists",
+		erc20.ErrNoERC20Contract:           "noERC20Contract",
+	}
+	if errorCode, ok := errorCodes[errp.Cause(err)]; ok {
+		return map[string]interface{}{"success": false, "errorCode": errorCode}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"success":      false,
+			"errorCode":    "unknown",
+			"errorMessage": err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success":      true,
+		"token":        token,
+		"warningCodes": warnings,
+	}, nil
+}
+
+// postRemoveERC20TokenHandler removes the user-added erc20 token with the given token code, e.g.
+// `{"code": "2f45b6fb2f28a73f110400386da31044b2e953d4"}`. The error code is "builtin" for built-in
+// tokens.
+func (handlers *Handlers) postRemoveERC20TokenHandler(r *http.Request) (interface{}, error) {
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.backend.RemoveERC20Token(request.Code)
+	if errp.Cause(err) == backend.ErrERC20TokenBuiltin {
+		return map[string]interface{}{"success": false, "errorCode": "builtin"}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
 func (handlers *Handlers) postAddAccountHandler(r *http.Request) (interface{}, error) {
 	jsonBody := map[string]string{}
 	if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
 		return nil, errp.WithStack(err)
 	}
-	// The following parameters only work for watch-only singlesig accounts at the moment.
+	// The following parameters only work for watch-only accounts at the moment. For extended public
+	// keys, the script type can be omitted and is inferred from the version bytes. Multisig accounts
+	// can be added with an output descriptor.
 	jsonCoinCode := jsonBody["coinCode"]
 	jsonScriptType := jsonBody["scriptType"]
 	jsonAccountName := jsonBody["accountName"]
 	jsonExtendedPublicKey := jsonBody["extendedPublicKey"]
 	jsonAddress := jsonBody["address"]
+	jsonDescriptor := jsonBody["descriptor"]
 
 	coin, err := handlers.backend.Coin(jsonCoinCode)
 	if err != nil {
 		return nil, err
 	}
 
-	scriptType, err := signing.DecodeScriptType(jsonScriptType)
-	if err != nil {
-		return nil, err
-	}
 	keypath := signing.NewEmptyAbsoluteKeypath()
 
 	var configuration *signing.Configuration
 	var warningCode string
 
-	if jsonAddress != "" {
+	if jsonDescriptor != "" {
+		btcCoin, ok := coin.(*btc.Coin)
+		if !ok {
+			return map[string]interface{}{"success": false, "errorCode": "descriptorInvalid"}, nil
+		}
+		configuration, err = signing.NewConfigurationFromDescriptor(
+			jsonDescriptor, btcCoin.Net(), btcCoin.AddressScriptType)
+		if err != nil {
+			return map[string]interface{}{
+				"success":      false,
+				"errorCode":    "descriptorInvalid",
+				"errorMessage": err.Error(),
+			}, nil
+		}
+	} else if jsonAddress != "" {
+		scriptType, err := signing.DecodeScriptType(jsonScriptType)
+		if err != nil {
+			return nil, err
+		}
 		switch jsonCoinCode {
 		case "btc", "ltc", "tbtc", "tltc":
 			btcCoin, ok := coin.(*btc.Coin)
@@ -414,14 +641,24 @@ func (handlers *Handlers) postAddAccountHandler(r *http.Request) (interface{}, e
 		if extendedPublicKey.IsPrivate() {
 			return map[string]interface{}{"success": false, "errorCode": "xprivEntered"}, nil
 		}
-		if btcCoin, ok := coin.(*btc.Coin); ok {
-			expectedNet := &chaincfg.Params{
-				HDPublicKeyID: btc.XPubVersionForScriptType(btcCoin, scriptType),
+		var scriptType signing.ScriptType
+		btcCoin, isBTC := coin.(*btc.Coin)
+		if jsonScriptType == "" && isBTC {
+			// Infer the script type from the version bytes (xpub, ypub, zpub).
+			inferredScriptType, ok := btc.ScriptTypeForXPub(btcCoin, extendedPublicKey)
+			if !ok {
+				return map[string]interface{}{"success": false, "errorCode": "scriptTypeUnknown"}, nil
 			}
-			if !extendedPublicKey.IsForNet(expectedNet) {
-				warningCode = "xpubWrongNet"
+			scriptType = inferredScriptType
+		} else {
+			scriptType, err = signing.DecodeScriptType(jsonScriptType)
+			if err != nil {
+				return nil, err
 			}
 		}
+		if isBTC && !btc.XPubMatchesScriptType(btcCoin, extendedPublicKey, scriptType) {
+			warningCode = "xpubWrongNet"
+		}
 		configuration = signing.NewSinglesigConfiguration(scriptType, keypath, extendedPublicKey)
 	}
 
@@ -430,7 +667,7 @@ func (handlers *Handlers) postAddAccountHandler(r *http.Request) (interface{}, e
 	}
 	accountCode := fmt.Sprintf("%s-%s", configuration.Hash(), coin.Code())
 	err = handlers.backend.CreateAndAddAccount(
-		coin, accountCode, jsonAccountName, getSigningConfiguration, true)
+		coin, accountCode, jsonAccountName, getSigningConfiguration, true, true)
 	if errp.Cause(err) == backend.ErrAccountAlreadyExists {
 		return map[string]interface{}{"success": false, "errorCode": "alreadyExists"}, nil
 	}
@@ -469,9 +706,97 @@ func (handlers *Handlers) getAccountsHandler(_ *http.Request) (interface{}, erro
 	return accounts, nil
 }
 
-func (handlers *Handlers) postAccountsReinitializeHandler(_ *http.Request) (interface{}, error) {
-	handlers.backend.ReinitializeAccounts()
-	return nil, nil
+func (handlers *Handlers) postRenameAccountHandler(r *http.Request) (interface{}, error) {
+	var request struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.RenameAccount(request.Code, request.Name); err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postSetAccountHiddenHandler(r *http.Request) (interface{}, error) {
+	var request struct {
+		Code   string `json:"code"`
+		Hidden bool   `json:"hidden"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.SetAccountHidden(request.Code, request.Hidden); err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postRemoveAccountHandler(r *http.Request) (interface{}, error) {
+	var code string
+	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.backend.RemoveAccount(code)
+	if errp.Cause(err) == backend.ErrDefaultAccount {
+		return map[string]interface{}{"success": false, "errorCode": "defaultAccount"}, nil
+	}
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) getHiddenAccountsHandler(_ *http.Request) (interface{}, error) {
+	return handlers.backend.HiddenAccounts(), nil
+}
+
+func (handlers *Handlers) getStorageStatus(_ *http.Request) (interface{}, error) {
+	return handlers.backend.StorageStatus(), nil
+}
+
+func (handlers *Handlers) postUnlockStorage(r *http.Request) (interface{}, error) {
+	var passphrase string
+	if err := json.NewDecoder(r.Body).Decode(&passphrase); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.UnlockStorage(passphrase); err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postEnableStorageEncryption(r *http.Request) (interface{}, error) {
+	var input struct {
+		Source     encryption.Source `json:"source"`
+		Passphrase string            `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if err := handlers.backend.EnableStorageEncryption(input.Source, input.Passphrase); err != nil {
+		handlers.log.WithError(err).Error("Could not enable the storage encryption")
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (handlers *Handlers) postChangeStorageSecret(r *http.Request) (interface{}, error) {
+	var input struct {
+		OldPassphrase string            `json:"oldPassphrase"`
+		Source        encryption.Source `json:"source"`
+		NewPassphrase string            `json:"newPassphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	err := handlers.backend.ChangeStorageSecret(input.OldPassphrase, input.Source, input.NewPassphrase)
+	if err != nil {
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
 }
 
 func (handlers *Handlers) getAccountsStatusHandler(_ *http.Request) (interface{}, error) {
@@ -538,6 +863,31 @@ func (handlers *Handlers) getRatesHandler(_ *http.Request) (interface{}, error)
 	return handlers.backend.RatesUpdater().Last(), nil
 }
 
+// getHistoricalRateHandler returns the rate of a coin in a fiat at a given day, e.g.
+// `/rates/historical?coin=BTC&fiat=USD&date=2020-01-31`.
+func (handlers *Handlers) getHistoricalRateHandler(r *http.Request) (interface{}, error) {
+	date, err := time.Parse("2006-01-02", r.URL.Query().Get("date"))
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  "invalid date",
+		}, nil
+	}
+	rate, err := handlers.backend.RatesUpdater().HistoricalRate(
+		r.URL.Query().Get("coin"), r.URL.Query().Get("fiat"), date)
+	if err != nil {
+		handlers.log.WithError(err).Warn("Could not get the historical rate")
+		return map[string]interface{}{
+			"success": false,
+			"errMsg":  err.Error(),
+		}, nil
+	}
+	return map[string]interface{}{
+		"success": true,
+		"rate":    rate,
+	}, nil
+}
+
 func (handlers *Handlers) getConvertToFiatHandler(r *http.Request) (interface{}, error) {
 	from := r.URL.Query().Get("from")
 	to := r.URL.Query().Get("to")
@@ -602,6 +952,16 @@ func (handlers *Handlers) getHeadersStatus(coinCode string) func(*http.Request)
 	}
 }
 
+func (handlers *Handlers) getServersStatus(coinCode string) func(*http.Request) (interface{}, error) {
+	return func(_ *http.Request) (interface{}, error) {
+		coin, err := handlers.backend.Coin(coinCode)
+		if err != nil {
+			return nil, err
+		}
+		return coin.(*btc.Coin).ServersStatus(), nil
+	}
+}
+
 func (handlers *Handlers) postCertsDownloadHandler(r *http.Request) (interface{}, error) {
 	var server string
 	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
@@ -805,6 +1165,48 @@ func (handlers *Handlers) getAccountSummary(_ *http.Request) (interface{}, error
 	}, nil
 }
 
+// postExportLabels writes the labels of all accounts in the BIP329 format to the downloads folder
+// and returns the path of the file.
+func (handlers *Handlers) postExportLabels(_ *http.Request) (interface{}, error) {
+	name := time.Now().Format("2006-01-02-at-15-04-05-") + "labels.jsonl"
+	downloadsDir, err := utilConfig.DownloadsDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(downloadsDir, name)
+	handlers.log.Infof("Export labels to %s.", path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			handlers.log.WithError(err).Error("Could not close the exported labels file.")
+		}
+	}()
+	if err := handlers.backend.Labels().Export(file); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// postImportLabels merges the labels of a BIP329 export, passed as a JSON string, into the
+// existing labels.
+func (handlers *Handlers) postImportLabels(r *http.Request) (interface{}, error) {
+	var export string
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	count, err := handlers.backend.Labels().Import(strings.NewReader(export))
+	if err != nil {
+		handlers.log.WithError(err).Error("Could not import the labels")
+		return map[string]interface{}{"success": false, "errorMessage": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true, "count": count}, nil
+}
+
 func (handlers *Handlers) postExportAccountSummary(_ *http.Request) (interface{}, error) {
 	name := time.Now().Format("2006-01-02-at-15-04-05-") + "Accounts-Summary.csv"
 	downloadsDir, err := utilConfig.DownloadsDir()
@@ -15,15 +15,23 @@
 package handlers_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/devices/usb"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/handlers"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
 )
 
 // List all routes with `go test backend/handlers/handlers_test.go -v`.
@@ -73,3 +81,86 @@ func TestListRoutes(t *testing.T) {
 		fmt.Println(err)
 	}
 }
+
+// testEnvironment implements backend.Environment without any devices.
+type testEnvironment struct{}
+
+func (testEnvironment) NotifyUser(string) {}
+
+func (testEnvironment) DeviceInfos() []usb.DeviceInfo {
+	return []usb.DeviceInfo{}
+}
+
+func (testEnvironment) SystemOpen(string) error {
+	return nil
+}
+
+// TestAddAccountXPubVersion tests that testnet keys with SLIP-132 version bytes are accepted without
+// a warning, while keys of the wrong network are flagged.
+func TestAddAccountXPubVersion(t *testing.T) {
+	backend, err := backend.NewBackend(arguments.NewArguments(
+		test.TstTempDir("bitbox-wallet-addaccount-"), true, false, false, false, false),
+		testEnvironment{},
+	)
+	require.NoError(t, err)
+	router := handlers.NewHandlers(backend, handlers.NewConnectionData(-1, "")).Router
+
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	// withVersion returns a public key derived from master encoded with the given version bytes.
+	withVersion := func(index uint32, version [4]byte) string {
+		child, err := master.Child(hdkeychain.HardenedKeyStart + index)
+		require.NoError(t, err)
+		xpub, err := child.Neuter()
+		require.NoError(t, err)
+		xpub.SetNet(&chaincfg.Params{HDPublicKeyID: version})
+		return xpub.String()
+	}
+	addAccount := func(body map[string]string) map[string]interface{} {
+		jsonBody, err := json.Marshal(body)
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/api/account-add", bytes.NewReader(jsonBody))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		response := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		return response
+	}
+
+	response := addAccount(map[string]string{
+		"coinCode":          "tbtc",
+		"accountName":       "vpub",
+		"extendedPublicKey": withVersion(0, [4]byte{0x04, 0x5f, 0x1c, 0xf6}),
+	})
+	require.Equal(t, true, response["success"], response)
+	require.Equal(t, "", response["warningCode"])
+
+	response = addAccount(map[string]string{
+		"coinCode":          "tbtc",
+		"scriptType":        "p2wpkh-p2sh",
+		"accountName":       "upub",
+		"extendedPublicKey": withVersion(1, [4]byte{0x04, 0x4a, 0x52, 0x62}),
+	})
+	require.Equal(t, true, response["success"], response)
+	require.Equal(t, "", response["warningCode"])
+
+	// A vpub does not match the p2pkh script type.
+	response = addAccount(map[string]string{
+		"coinCode":          "tbtc",
+		"scriptType":        "p2pkh",
+		"accountName":       "vpub p2pkh",
+		"extendedPublicKey": withVersion(2, [4]byte{0x04, 0x5f, 0x1c, 0xf6}),
+	})
+	require.Equal(t, true, response["success"], response)
+	require.Equal(t, "xpubWrongNet", response["warningCode"])
+
+	// A mainnet zpub on testnet.
+	response = addAccount(map[string]string{
+		"coinCode":          "tbtc",
+		"scriptType":        "p2wpkh",
+		"accountName":       "zpub",
+		"extendedPublicKey": withVersion(3, [4]byte{0x04, 0xb2, 0x47, 0x46}),
+	})
+	require.Equal(t, true, response["success"], response)
+	require.Equal(t, "xpubWrongNet", response["warningCode"])
+}
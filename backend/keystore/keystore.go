@@ -25,6 +25,10 @@ import (
 // ErrSigningAborted is used when the user aborts a signing in process (e.g. abort on HW wallet).
 var ErrSigningAborted = errors.New("signing aborted by user")
 
+// ErrNoKeystore is used when signing is requested but there is no keystore, e.g. for watch-only
+// accounts.
+var ErrNoKeystore = errors.New("no keystore available to sign")
+
 // Keystore supports hardened key derivation according to BIP32 and signing of transactions.
 type Keystore interface {
 	// CosignerIndex returns the index at which the keystore signs in a multisig configuration.
@@ -35,6 +39,10 @@ type Keystore interface {
 	// meta is a coin-specific metadata related to the account type.
 	SupportsAccount(coin coin.Coin, multisig bool, meta interface{}) bool
 
+	// SupportsEIP1559 returns true if the keystore can sign Ethereum transactions with dynamic fees
+	// (EIP-1559). Otherwise, only legacy transactions can be signed.
+	SupportsEIP1559() bool
+
 	// CanVerifyAddress returns whether the keystore supports to output an address securely.
 	// This is typically done through a screen on the device or through a paired mobile phone.
 	// optional is true if the user can skip verification, and false if they should be incentivized
@@ -55,7 +63,17 @@ type Keystore interface {
 	// ExtendedPublicKey returns the extended public key at the given absolute keypath.
 	ExtendedPublicKey(coin.Coin, signing.AbsoluteKeypath) (*hdkeychain.ExtendedKey, error)
 
-	// SignMessage(string, *signing.AbsoluteKeypath, accounts.Coin) (*big.Int, error)
+	// RootFingerprint returns the fingerprint of the master key, see signing.KeyOrigin.
+	RootFingerprint() ([]byte, error)
+
+	// DeriveSecret returns a secret derived from the private key at the given keypath and the
+	// challenge. The same keypath and challenge always result in the same secret, which can't be
+	// computed from the public keys. Returns ErrSigningAborted if the user aborts.
+	DeriveSecret(keypath signing.AbsoluteKeypath, challenge []byte) ([]byte, error)
+
+	// SignMessage signs the hash of the given message proposal with the key of its address. Returns
+	// ErrSigningAborted if the user aborts.
+	SignMessage(interface{}) error
 
 	// SignTransaction signs the given transaction proposal. Returns ErrSigningAborted if the user
 	// aborts.
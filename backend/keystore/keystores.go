@@ -65,6 +65,16 @@ func (keystores *Keystores) Remove(keystore Keystore) error {
 	return errp.New("The collection does not contain the given keystore.")
 }
 
+// SupportsEIP1559 returns true if all keystores can sign Ethereum transactions with dynamic fees.
+func (keystores *Keystores) SupportsEIP1559() bool {
+	for _, keystore := range keystores.keystores {
+		if !keystore.SupportsEIP1559() {
+			return false
+		}
+	}
+	return true
+}
+
 // CanVerifyAddresses returns whether any of the keystores can verify an address.
 func (keystores *Keystores) CanVerifyAddresses(
 	configuration *signing.Configuration, coin coin.Coin) (bool, bool, error) {
@@ -117,8 +127,11 @@ func (keystores *Keystores) CanVerifyExtendedPublicKeys() []int {
 }
 
 // SignTransaction signs the given proposed transaction on all keystores. Returns ErrSigningAborted
-// if the user aborts.
+// if the user aborts, and ErrNoKeystore if there are no keystores.
 func (keystores *Keystores) SignTransaction(proposedTransaction interface{}) error {
+	if len(keystores.keystores) == 0 {
+		return errp.WithStack(ErrNoKeystore)
+	}
 	for _, keystore := range keystores.keystores {
 		if err := keystore.SignTransaction(proposedTransaction); err != nil {
 			return err
@@ -127,6 +140,15 @@ func (keystores *Keystores) SignTransaction(proposedTransaction interface{}) err
 	return nil
 }
 
+// SignMessage signs the given proposed message with the first keystore. Returns ErrSigningAborted if
+// the user aborts, and ErrNoKeystore if there are no keystores.
+func (keystores *Keystores) SignMessage(proposedMessage interface{}) error {
+	if len(keystores.keystores) == 0 {
+		return errp.WithStack(ErrNoKeystore)
+	}
+	return keystores.keystores[0].SignMessage(proposedMessage)
+}
+
 // Configuration returns the configuration at the given path with the given signing threshold.
 func (keystores *Keystores) Configuration(
 	coin coinpkg.Coin,
@@ -135,6 +157,7 @@ func (keystores *Keystores) Configuration(
 	signingThreshold int,
 ) (*signing.Configuration, error) {
 	extendedPublicKeys := make([]*hdkeychain.ExtendedKey, len(keystores.keystores))
+	keyOrigins := make([]*signing.KeyOrigin, len(keystores.keystores))
 	for index, keystore := range keystores.keystores {
 		if keystore.CosignerIndex() != index {
 			return nil, errp.New("The keystores are in the wrong order.")
@@ -144,9 +167,17 @@ func (keystores *Keystores) Configuration(
 			return nil, err
 		}
 		extendedPublicKeys[index] = extendedPublicKey
+		// The origin stays unknown if the keystore can't report its root fingerprint.
+		if rootFingerprint, err := keystore.RootFingerprint(); err == nil {
+			keyOrigins[index] = &signing.KeyOrigin{
+				RootFingerprint: rootFingerprint,
+				Keypath:         absoluteKeypath,
+			}
+		}
 	}
 	return signing.NewConfiguration(
-		scriptType, absoluteKeypath, extendedPublicKeys, "", signingThreshold), nil
+		scriptType, absoluteKeypath, extendedPublicKeys, "", signingThreshold,
+	).WithKeyOrigins(keyOrigins), nil
 }
 
 // Keystores returns all keystores.
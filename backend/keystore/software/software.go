@@ -15,6 +15,7 @@
 package software
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc/taproot"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
@@ -80,12 +82,17 @@ func (keystore *Keystore) SupportsAccount(
 	coin coin.Coin, multisig bool, meta interface{}) bool {
 	switch coin.(type) {
 	case *btc.Coin:
-		return !multisig
+		return true
 	default:
 		return false
 	}
 }
 
+// SupportsEIP1559 implements keystore.Keystore. Ethereum is not supported.
+func (keystore *Keystore) SupportsEIP1559() bool {
+	return false
+}
+
 // Identifier implements keystore.Keystore.
 func (keystore *Keystore) Identifier() (string, error) {
 	return keystore.identifier, nil
@@ -122,6 +129,27 @@ func (keystore *Keystore) ExtendedPublicKey(
 	return extendedPrivateKey.Neuter()
 }
 
+// RootFingerprint implements keystore.Keystore.
+func (keystore *Keystore) RootFingerprint() ([]byte, error) {
+	return signing.RootFingerprint(keystore.master)
+}
+
+// DeriveSecret implements keystore.Keystore. The secret is the HMAC of the challenge keyed by the
+// private key.
+func (keystore *Keystore) DeriveSecret(keypath signing.AbsoluteKeypath, challenge []byte) ([]byte, error) {
+	xprv, err := keypath.Derive(keystore.master)
+	if err != nil {
+		return nil, err
+	}
+	prv, err := xprv.ECPrivKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	mac := hmac.New(sha256.New, prv.Serialize())
+	_, _ = mac.Write(challenge)
+	return mac.Sum(nil), nil
+}
+
 func (keystore *Keystore) sign(
 	signatureHashes [][]byte,
 	keyPaths []signing.AbsoluteKeypath,
@@ -148,6 +176,24 @@ func (keystore *Keystore) sign(
 	return signatures, nil
 }
 
+// SignMessage implements keystore.Keystore.
+func (keystore *Keystore) SignMessage(proposedMessage interface{}) error {
+	btcProposedMessage, ok := proposedMessage.(*btc.ProposedMessage)
+	if !ok {
+		panic("Only BTC supported for now.")
+	}
+	keystore.log.Info("Sign message.")
+	signatures, err := keystore.sign(
+		[][]byte{btcProposedMessage.SignatureHash},
+		[]signing.AbsoluteKeypath{btcProposedMessage.Address.Configuration.AbsoluteKeypath()},
+	)
+	if err != nil {
+		return errp.WithMessage(err, "Failed to sign message hash")
+	}
+	btcProposedMessage.Signature = &signatures[0]
+	return nil
+}
+
 // SignTransaction implements keystore.Keystore.
 func (keystore *Keystore) SignTransaction(
 	proposedTransaction interface{},
@@ -159,6 +205,10 @@ func (keystore *Keystore) SignTransaction(
 	keystore.log.Info("Sign transaction.")
 	signatureHashes := [][]byte{}
 	keyPaths := []signing.AbsoluteKeypath{}
+	// inputIndices maps the ECDSA signature hashes to the inputs they belong to.
+	inputIndices := []int{}
+	// signerIndices are the positions of the signatures among the signers of the inputs.
+	signerIndices := []int{}
 	transaction := btcProposedTx.TXProposal.Transaction
 	for index, txIn := range transaction.TxIn {
 		spentOutput, ok := btcProposedTx.PreviousOutputs[txIn.PreviousOutPoint]
@@ -166,6 +216,26 @@ func (keystore *Keystore) SignTransaction(
 			keystore.log.Panic("There needs to be exactly one output being spent per input!")
 		}
 		address := btcProposedTx.GetAddress(spentOutput.ScriptHashHex())
+		if address.IsTaproot() {
+			signature, err := keystore.signTaproot(btcProposedTx, index, address.Configuration.AbsoluteKeypath())
+			if err != nil {
+				return err
+			}
+			btcProposedTx.TaprootSignatures[index] = signature
+			continue
+		}
+		signerIndex := keystore.CosignerIndex()
+		if address.Configuration.Multisig() {
+			var err error
+			signerIndex, err = keystore.signerIndex(address.Configuration)
+			if err != nil {
+				return err
+			}
+			if signerIndex == -1 {
+				// Signed by the other cosigners.
+				continue
+			}
+		}
 		isSegwit, subScript := address.ScriptForHashToSign()
 		var signatureHash []byte
 		if isSegwit {
@@ -188,18 +258,71 @@ func (keystore *Keystore) SignTransaction(
 
 		signatureHashes = append(signatureHashes, signatureHash)
 		keyPaths = append(keyPaths, address.Configuration.AbsoluteKeypath())
+		inputIndices = append(inputIndices, index)
+		signerIndices = append(signerIndices, signerIndex)
 	}
 
 	signatures, err := keystore.sign(signatureHashes, keyPaths)
 	if err != nil {
 		return errp.WithMessage(err, "Failed to sign signature hash")
 	}
-	if len(signatures) != len(transaction.TxIn) {
+	if len(signatures) != len(inputIndices) {
 		panic("number of signatures doesn't match number of inputs")
 	}
 	for i, signature := range signatures {
 		signature := signature
-		btcProposedTx.Signatures[i][keystore.CosignerIndex()] = &signature
+		btcProposedTx.Signatures[inputIndices[i]][signerIndices[i]] = &signature
 	}
 	return nil
 }
+
+// signerIndex returns the position of the public key of the keystore among the public keys of the
+// multisig configuration, or -1 if the keystore is not a signer. The position does not necessarily
+// match the cosigner index, e.g. if the account was created with the keys of external cosigners.
+func (keystore *Keystore) signerIndex(configuration *signing.Configuration) (int, error) {
+	xprv, err := configuration.AbsoluteKeypath().Derive(keystore.master)
+	if err != nil {
+		return 0, err
+	}
+	publicKey, err := xprv.ECPubKey()
+	if err != nil {
+		return 0, errp.WithStack(err)
+	}
+	for index, signerPublicKey := range configuration.PublicKeys() {
+		if signerPublicKey.IsEqual(publicKey) {
+			return index, nil
+		}
+	}
+	return -1, nil
+}
+
+// signTaproot creates the BIP340 Schnorr signature of a taproot key path spend, using the private
+// key tweaked as described in BIP86.
+func (keystore *Keystore) signTaproot(
+	btcProposedTx *btc.ProposedTransaction,
+	inputIndex int,
+	keyPath signing.AbsoluteKeypath,
+) ([]byte, error) {
+	sigHash, err := btcProposedTx.TaprootSigHash(inputIndex)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to calculate taproot signature hash")
+	}
+	xprv, err := keyPath.Derive(keystore.master)
+	if err != nil {
+		return nil, err
+	}
+	prv, err := xprv.ECPrivKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	tweakedPrv, err := taproot.TweakPrivateKey(prv)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := taproot.Sign(tweakedPrv, sigHash)
+	if err != nil {
+		return nil, errp.WithMessage(err, "Failed to sign taproot signature hash")
+	}
+	keystore.log.Debug("Signed taproot input")
+	return signature, nil
+}
@@ -0,0 +1,317 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package labels stores user defined labels of transactions, addresses and outputs in the BIP329
+// format.
+package labels
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/atomicfile"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+// Type is the type of the labelled object, as defined in BIP329.
+type Type string
+
+const (
+	// TypeTx labels a transaction, referenced by its txid.
+	TypeTx Type = "tx"
+	// TypeAddr labels an address.
+	TypeAddr Type = "addr"
+	// TypePubkey labels a public key, referenced in hex.
+	TypePubkey Type = "pubkey"
+	// TypeInput labels a transaction input, referenced by txid:vin.
+	TypeInput Type = "input"
+	// TypeOutput labels a transaction output, referenced by txid:vout.
+	TypeOutput Type = "output"
+	// TypeXpub labels an extended public key, i.e. an account.
+	TypeXpub Type = "xpub"
+)
+
+func (labelType Type) valid() bool {
+	switch labelType {
+	case TypeTx, TypeAddr, TypePubkey, TypeInput, TypeOutput, TypeXpub:
+		return true
+	}
+	return false
+}
+
+// Label is one record of a BIP329 export.
+// https://github.com/bitcoin/bips/blob/master/bip-0329.mediawiki
+type Label struct {
+	Type  Type   `json:"type"`
+	Ref   string `json:"ref"`
+	Label string `json:"label"`
+	// Origin is an optional key origin (descriptor) of the wallet the record belongs to.
+	Origin string `json:"origin,omitempty"`
+	// Spendable is only used for outputs. If false, the output should not be spent.
+	Spendable *bool `json:"spendable,omitempty"`
+}
+
+type key struct {
+	labelType Type
+	ref       string
+}
+
+// Store keeps the labels in a BIP329 JSONL file, which is encrypted if an encryption key is set.
+type Store struct {
+	filename string
+
+	lock          locker.Locker
+	labels        map[key]*Label
+	encryptionKey *encryption.Key
+	// locked is true if the file is encrypted and the key is not set yet.
+	locked bool
+}
+
+// NewStore loads the labels from the given file. The file is created when the first label is set.
+// If the file is encrypted, the labels are loaded when the key is set with SetEncryptionKey().
+func NewStore(filename string) (*Store, error) {
+	store := &Store{filename: filename, labels: map[key]*Label{}}
+	if err := store.load(); err != nil {
+		if errp.Cause(err) != encryption.ErrLocked {
+			return nil, err
+		}
+		store.locked = true
+	}
+	return store, nil
+}
+
+// load reads the labels from the file, if it exists. Requires the lock when called after the
+// construction of the store.
+func (store *Store) load() error {
+	data, err := ioutil.ReadFile(store.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	data, err = store.encryptionKey.Decrypt(data)
+	if err != nil {
+		return err
+	}
+	labels, err := parse(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	store.labels = map[key]*Label{}
+	for _, label := range labels {
+		put(store.labels, label)
+	}
+	return nil
+}
+
+// SetEncryptionKey sets the key with which the file is encrypted. If the file is encrypted, the
+// labels are loaded, otherwise the file is encrypted right away.
+func (store *Store) SetEncryptionKey(encryptionKey *encryption.Key) error {
+	defer store.lock.Lock()()
+	store.encryptionKey = encryptionKey
+	if store.locked {
+		if err := store.load(); err != nil {
+			return err
+		}
+		store.locked = false
+		return nil
+	}
+	return store.save()
+}
+
+// parse returns the records of the JSONL input. Records of unknown types are skipped. Fails if
+// any record is malformed.
+func parse(reader io.Reader) ([]*Label, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	labels := []*Label{}
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		label := &Label{}
+		if err := json.Unmarshal(line, label); err != nil {
+			return nil, errp.Wrap(err, "invalid label record")
+		}
+		// Unknown types are skipped, as allowed by BIP329.
+		if !label.Type.valid() || label.Ref == "" {
+			continue
+		}
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return labels, nil
+}
+
+// put adds or replaces the record in labels. Records without label and spendable flag are removed.
+// Requires the lock.
+func put(labels map[key]*Label, label *Label) {
+	k := key{label.Type, label.Ref}
+	if label.Label == "" && label.Spendable == nil {
+		delete(labels, k)
+		return
+	}
+	labels[k] = label
+}
+
+// sorted returns the records in a stable order. Requires the lock.
+func (store *Store) sorted() []*Label {
+	result := make([]*Label, 0, len(store.labels))
+	for _, label := range store.labels {
+		result = append(result, label)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Ref < result[j].Ref
+	})
+	return result
+}
+
+// write exports all records. Requires the lock.
+func (store *Store) write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	for _, label := range store.sorted() {
+		if err := encoder.Encode(label); err != nil {
+			return errp.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// save writes the records to the file. Requires the lock.
+func (store *Store) save() error {
+	if store.locked {
+		return encryption.ErrLocked
+	}
+	var buf bytes.Buffer
+	if err := store.write(&buf); err != nil {
+		return err
+	}
+	data, err := store.encryptionKey.Encrypt(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(store.filename, data, 0600)
+}
+
+// Get returns the label of the object, or an empty string if it has none.
+func (store *Store) Get(labelType Type, ref string) string {
+	defer store.lock.RLock()()
+	if label, ok := store.labels[key{labelType, ref}]; ok {
+		return label.Label
+	}
+	return ""
+}
+
+// Spendable returns false if the output was marked as not spendable.
+func (store *Store) Spendable(ref string) bool {
+	defer store.lock.RLock()()
+	label, ok := store.labels[key{TypeOutput, ref}]
+	return !ok || label.Spendable == nil || *label.Spendable
+}
+
+// SetLabel sets the label of the object. An empty label removes it.
+func (store *Store) SetLabel(labelType Type, ref string, text string) error {
+	if !labelType.valid() {
+		return errp.Newf("invalid label type %s", labelType)
+	}
+	if ref == "" {
+		return errp.New("missing label reference")
+	}
+	defer store.lock.Lock()()
+	if store.locked {
+		return encryption.ErrLocked
+	}
+	label := &Label{Type: labelType, Ref: ref, Label: text}
+	if existing, ok := store.labels[key{labelType, ref}]; ok {
+		labelCopy := *existing
+		labelCopy.Label = text
+		label = &labelCopy
+	}
+	put(store.labels, label)
+	return store.save()
+}
+
+// SetSpendable marks the output as spendable or not. Outputs are spendable by default.
+func (store *Store) SetSpendable(ref string, spendable bool) error {
+	if ref == "" {
+		return errp.New("missing label reference")
+	}
+	defer store.lock.Lock()()
+	if store.locked {
+		return encryption.ErrLocked
+	}
+	label := &Label{Type: TypeOutput, Ref: ref}
+	if existing, ok := store.labels[key{TypeOutput, ref}]; ok {
+		labelCopy := *existing
+		label = &labelCopy
+	}
+	label.Spendable = nil
+	if !spendable {
+		label.Spendable = &spendable
+	}
+	put(store.labels, label)
+	return store.save()
+}
+
+// Import adds the records of a BIP329 JSONL export, replacing existing labels of the same objects.
+// Records of unknown types are skipped. Returns the number of imported records. Nothing is imported
+// if any record is malformed or the labels can't be saved.
+func (store *Store) Import(reader io.Reader) (int, error) {
+	imported, err := parse(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer store.lock.Lock()()
+	if store.locked {
+		return 0, encryption.ErrLocked
+	}
+	labels := make(map[key]*Label, len(store.labels)+len(imported))
+	for k, label := range store.labels {
+		labels[k] = label
+	}
+	for _, label := range imported {
+		put(labels, label)
+	}
+	previous := store.labels
+	store.labels = labels
+	if err := store.save(); err != nil {
+		store.labels = previous
+		return 0, err
+	}
+	return len(imported), nil
+}
+
+// Export writes all records in the BIP329 JSONL format.
+func (store *Store) Export(writer io.Writer) error {
+	defer store.lock.RLock()()
+	if store.locked {
+		return encryption.ErrLocked
+	}
+	return store.write(writer)
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-labels-")
+	store, err := NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, "", store.Get(TypeTx, "txid"))
+
+	require.NoError(t, store.SetLabel(TypeTx, "txid", "rent"))
+	require.NoError(t, store.SetLabel(TypeAddr, "address", "exchange"))
+	require.Error(t, store.SetLabel(Type("unknown"), "ref", "label"))
+	require.Error(t, store.SetLabel(TypeTx, "", "label"))
+	require.Equal(t, "rent", store.Get(TypeTx, "txid"))
+	require.Equal(t, "", store.Get(TypeAddr, "txid"))
+
+	// The labels are persisted.
+	store, err = NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, "rent", store.Get(TypeTx, "txid"))
+	require.Equal(t, "exchange", store.Get(TypeAddr, "address"))
+
+	// An empty label removes the record.
+	require.NoError(t, store.SetLabel(TypeAddr, "address", ""))
+	var buf bytes.Buffer
+	require.NoError(t, store.Export(&buf))
+	require.Equal(t, `{"type":"tx","ref":"txid","label":"rent"}`+"\n", buf.String())
+}
+
+func TestImport(t *testing.T) {
+	store, err := NewStore(test.TstTempFile("bitbox-wallet-labels-"))
+	require.NoError(t, err)
+	require.NoError(t, store.SetLabel(TypeTx, "txid", "old"))
+
+	export := strings.Join([]string{
+		`{"type":"tx","ref":"txid","label":"new","origin":"wpkh([d34db33f/84'/0'/0'])"}`,
+		``,
+		`{"type":"output","ref":"txid:1","label":"cold","spendable":false}`,
+		`{"type":"future","ref":"ref","label":"skipped"}`,
+	}, "\n")
+	count, err := store.Import(strings.NewReader(export))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, "new", store.Get(TypeTx, "txid"))
+	require.Equal(t, "cold", store.Get(TypeOutput, "txid:1"))
+	require.False(t, store.Spendable("txid:1"))
+	require.True(t, store.Spendable("txid:0"))
+
+	// Changing the label keeps the other fields.
+	require.NoError(t, store.SetLabel(TypeOutput, "txid:1", "savings"))
+	require.False(t, store.Spendable("txid:1"))
+
+	// Spendable outputs don't need a record.
+	require.NoError(t, store.SetSpendable("txid:1", true))
+	require.True(t, store.Spendable("txid:1"))
+	require.Equal(t, "savings", store.Get(TypeOutput, "txid:1"))
+	require.NoError(t, store.SetSpendable("txid:2", false))
+	require.False(t, store.Spendable("txid:2"))
+	require.NoError(t, store.SetSpendable("txid:2", true))
+	var buf bytes.Buffer
+	require.NoError(t, store.Export(&buf))
+	require.NotContains(t, buf.String(), "txid:2")
+
+	_, err = store.Import(strings.NewReader("not json"))
+	require.Error(t, err)
+}
+
+// TestImportMalformed checks that nothing is imported if a later record is malformed.
+func TestImportMalformed(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-labels-")
+	store, err := NewStore(filename)
+	require.NoError(t, err)
+	require.NoError(t, store.SetLabel(TypeTx, "txid", "old"))
+
+	export := strings.Join([]string{
+		`{"type":"tx","ref":"txid","label":"new"}`,
+		`{"type":"addr","ref":"address","label":"exchange"}`,
+		`{"type":"tx","ref":`,
+	}, "\n")
+	_, err = store.Import(strings.NewReader(export))
+	require.Error(t, err)
+	require.Equal(t, "old", store.Get(TypeTx, "txid"))
+	require.Equal(t, "", store.Get(TypeAddr, "address"))
+
+	store, err = NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, "old", store.Get(TypeTx, "txid"))
+	require.Equal(t, "", store.Get(TypeAddr, "address"))
+}
+
+func TestStoreEncryption(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-labels-")
+	store, err := NewStore(filename)
+	require.NoError(t, err)
+	require.NoError(t, store.SetLabel(TypeTx, "txid", "rent"))
+
+	// Setting the key encrypts the existing labels.
+	key, err := encryption.NewKey()
+	require.NoError(t, err)
+	require.NoError(t, store.SetEncryptionKey(key))
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.True(t, encryption.IsEncrypted(data))
+	require.NotContains(t, string(data), "rent")
+	require.Equal(t, "rent", store.Get(TypeTx, "txid"))
+
+	// The encrypted labels are loaded once the key is set.
+	store, err = NewStore(filename)
+	require.NoError(t, err)
+	require.Equal(t, "", store.Get(TypeTx, "txid"))
+	require.Equal(t, encryption.ErrLocked, store.SetLabel(TypeTx, "txid", "other"))
+	require.Equal(t, encryption.ErrLocked, store.Export(&bytes.Buffer{}))
+	require.NoError(t, store.SetEncryptionKey(key))
+	require.Equal(t, "rent", store.Get(TypeTx, "txid"))
+
+	otherKey, err := encryption.NewKey()
+	require.NoError(t, err)
+	store, err = NewStore(filename)
+	require.NoError(t, err)
+	require.Error(t, store.SetEncryptionKey(otherKey))
+}
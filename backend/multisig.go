@@ -0,0 +1,183 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/keystore"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// maxCosigners is the maximum number of cosigners of a multisig account, limited by the number of
+// public keys allowed in a standard multisig script.
+const maxCosigners = 15
+
+var (
+	// ErrMultisigUnsupported is returned if a registered keystore can't sign for a multisig account
+	// of the given coin and script type.
+	ErrMultisigUnsupported = errp.New("The keystore does not support this multisig account")
+	// ErrInvalidThreshold is returned if the signing threshold is not between 1 and the number of
+	// cosigners.
+	ErrInvalidThreshold = errp.New("Invalid signing threshold")
+	// ErrInvalidCosigner is returned if an extended public key of a cosigner is invalid, private,
+	// lacks its key origin, belongs to another network or appears more than once.
+	ErrInvalidCosigner = errp.New("Invalid cosigner extended public key")
+)
+
+// multisigCoinTypes are the BIP44 coin types used in the BIP48 keypaths.
+var multisigCoinTypes = map[string]uint32{
+	coinBTC:  0,
+	coinTBTC: 1,
+	coinRBTC: 1,
+	coinLTC:  2,
+	coinTLTC: 1,
+}
+
+// multisigKeypath returns the BIP48 keypath of the first multisig account of the given coin and
+// script type, m/48'/<coin type>'/0'/<script type>'.
+func multisigKeypath(coinCode string, scriptType signing.ScriptType) (signing.AbsoluteKeypath, error) {
+	coinType, ok := multisigCoinTypes[coinCode]
+	if !ok {
+		return signing.AbsoluteKeypath{}, errp.Newf("multisig is not supported for %s", coinCode)
+	}
+	var scriptTypeIndex int
+	switch scriptType {
+	case signing.ScriptTypeP2WSHP2SH:
+		scriptTypeIndex = 1
+	case signing.ScriptTypeP2WSH:
+		scriptTypeIndex = 2
+	default:
+		return signing.AbsoluteKeypath{}, errp.Newf("unsupported multisig script type %s", scriptType)
+	}
+	return signing.NewAbsoluteKeypath(fmt.Sprintf("m/48'/%d'/0'/%d'", coinType, scriptTypeIndex))
+}
+
+// parseCosigners parses the extended public keys of the external cosigners, given with their key
+// origins as `[fingerprint/keypath]xpub`, and checks that the resulting multisig account of the coin
+// with the given local keys and threshold is valid. The keys must be encoded for the network of the
+// coin, either with its standard version bytes or with the SLIP-132 ones of the script type.
+// Returns the keys and their origins, the local ones first.
+func parseCosigners(
+	coin *btc.Coin,
+	scriptType signing.ScriptType,
+	localKeys []*hdkeychain.ExtendedKey,
+	localKeyOrigins []*signing.KeyOrigin,
+	cosigners []string,
+	signingThreshold int,
+) ([]*hdkeychain.ExtendedKey, []*signing.KeyOrigin, error) {
+	extendedPublicKeys := append([]*hdkeychain.ExtendedKey{}, localKeys...)
+	keyOrigins := append([]*signing.KeyOrigin{}, localKeyOrigins...)
+	seen := map[string]struct{}{}
+	for _, extendedPublicKey := range localKeys {
+		publicKey, err := extendedPublicKey.ECPubKey()
+		if err != nil {
+			return nil, nil, errp.WithStack(err)
+		}
+		seen[string(publicKey.SerializeCompressed())] = struct{}{}
+	}
+	for _, cosigner := range cosigners {
+		keyOrigin, extendedPublicKey, err := signing.ParseKeyWithOrigin(strings.TrimSpace(cosigner))
+		if err != nil {
+			return nil, nil, errp.WithMessage(ErrInvalidCosigner, err.Error())
+		}
+		if !extendedPublicKey.IsForNet(coin.Net()) &&
+			!btc.XPubMatchesScriptType(coin, extendedPublicKey, scriptType) {
+			return nil, nil, errp.WithMessage(ErrInvalidCosigner, "wrong network")
+		}
+		// Stored with the standard version bytes, like the keys of the keystores.
+		extendedPublicKey.SetNet(coin.Net())
+		publicKey, err := extendedPublicKey.ECPubKey()
+		if err != nil {
+			return nil, nil, errp.WithMessage(ErrInvalidCosigner, err.Error())
+		}
+		if _, ok := seen[string(publicKey.SerializeCompressed())]; ok {
+			return nil, nil, errp.WithMessage(ErrInvalidCosigner, "duplicate cosigner")
+		}
+		seen[string(publicKey.SerializeCompressed())] = struct{}{}
+		extendedPublicKeys = append(extendedPublicKeys, extendedPublicKey)
+		keyOrigins = append(keyOrigins, keyOrigin)
+	}
+	if len(extendedPublicKeys) > maxCosigners {
+		return nil, nil, errp.WithMessage(ErrInvalidCosigner, "too many cosigners")
+	}
+	if signingThreshold < 1 || signingThreshold > len(extendedPublicKeys) {
+		return nil, nil, errp.WithStack(ErrInvalidThreshold)
+	}
+	return extendedPublicKeys, keyOrigins, nil
+}
+
+// CreateMultisigAccount creates a sortedmulti account of the given script type (P2WSH or
+// P2WSH-P2SH) which is signed by the registered keystores together with the external cosigners,
+// given by their extended public keys at the BIP48 keypath with their key origins, e.g.
+// `[d34db33f/48h/0h/0h/2h]xpub...`. The key origins are stored in the account configuration, so that
+// the cosigners can find their keys in the exported PSBTs. The account is persisted in the accounts
+// config. Returns the code of the new account.
+func (backend *Backend) CreateMultisigAccount(
+	coinCode string,
+	name string,
+	scriptType signing.ScriptType,
+	signingThreshold int,
+	cosigners []string,
+) (string, error) {
+	coin, err := backend.Coin(coinCode)
+	if err != nil {
+		return "", err
+	}
+	btcCoin, ok := coin.(*btc.Coin)
+	if !ok {
+		return "", errp.Newf("multisig is not supported for %s", coinCode)
+	}
+	if backend.keystores.Count() == 0 {
+		return "", errp.WithStack(keystore.ErrNoKeystore)
+	}
+	for _, keystore := range backend.keystores.Keystores() {
+		if !keystore.SupportsAccount(coin, true, scriptType) {
+			return "", errp.WithStack(ErrMultisigUnsupported)
+		}
+	}
+	keypath, err := multisigKeypath(coinCode, scriptType)
+	if err != nil {
+		return "", err
+	}
+	// The local keys come first, so that the cosigner indices of the keystores match.
+	localConfiguration, err := backend.keystores.Configuration(
+		coin, scriptType, keypath, backend.keystores.Count())
+	if err != nil {
+		return "", err
+	}
+	extendedPublicKeys, keyOrigins, err := parseCosigners(
+		btcCoin, scriptType, localConfiguration.ExtendedPublicKeys(), localConfiguration.KeyOrigins(), cosigners,
+		signingThreshold)
+	if err != nil {
+		return "", err
+	}
+	configuration := signing.NewConfiguration(
+		scriptType, keypath, extendedPublicKeys, "", signingThreshold).WithKeyOrigins(keyOrigins)
+	code := fmt.Sprintf("%s-%s", configuration.Hash(), coin.Code())
+	getSigningConfiguration := func() (*signing.Configuration, error) {
+		return configuration, nil
+	}
+	backend.log.WithField("code", code).WithField("name", name).Info("adding multisig account")
+	if err := backend.CreateAndAddAccount(
+		coin, code, name, getSigningConfiguration, true, false); err != nil {
+		return "", err
+	}
+	return code, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/btc"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/rpc"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultisigKeypath(t *testing.T) {
+	keypath, err := multisigKeypath(coinBTC, signing.ScriptTypeP2WSH)
+	require.NoError(t, err)
+	require.Equal(t, "m/48'/0'/0'/2'", keypath.Encode())
+
+	keypath, err = multisigKeypath(coinTBTC, signing.ScriptTypeP2WSHP2SH)
+	require.NoError(t, err)
+	require.Equal(t, "m/48'/1'/0'/1'", keypath.Encode())
+
+	_, err = multisigKeypath(coinBTC, signing.ScriptTypeP2WPKH)
+	require.Error(t, err)
+	_, err = multisigKeypath(coinETH, signing.ScriptTypeP2WSH)
+	require.Error(t, err)
+}
+
+func TestParseCosigners(t *testing.T) {
+	keypath, err := multisigKeypath(coinTBTC, signing.ScriptTypeP2WSH)
+	require.NoError(t, err)
+	// newCosigner returns the key at the multisig keypath of a new master key, and its origin.
+	newCosigner := func(seedByte byte) (*hdkeychain.ExtendedKey, *signing.KeyOrigin) {
+		seed := make([]byte, hdkeychain.RecommendedSeedLen)
+		seed[0] = seedByte
+		master, err := hdkeychain.NewMaster(seed, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		xprv, err := keypath.Derive(master)
+		require.NoError(t, err)
+		xpub, err := xprv.Neuter()
+		require.NoError(t, err)
+		rootFingerprint, err := signing.RootFingerprint(master)
+		require.NoError(t, err)
+		return xpub, &signing.KeyOrigin{RootFingerprint: rootFingerprint, Keypath: keypath}
+	}
+	withOrigin := func(xpub *hdkeychain.ExtendedKey, keyOrigin *signing.KeyOrigin) string {
+		return "[" + hex.EncodeToString(keyOrigin.RootFingerprint) + "/48h/1h/0h/2h]" + xpub.String()
+	}
+	local, localOrigin := newCosigner(1)
+	cosigner1, cosigner1Origin := newCosigner(2)
+	cosigner2, cosigner2Origin := newCosigner(3)
+	localKeys := []*hdkeychain.ExtendedKey{local}
+	localOrigins := []*signing.KeyOrigin{localOrigin}
+	coin := btc.NewCoin(coinTBTC, "TBTC", &chaincfg.TestNet3Params, ".", []*rpc.ServerInfo{}, "", nil,
+		0, "", socksproxy.NewSocksProxy(false, ""))
+	parseCosigners := func(
+		localKeys []*hdkeychain.ExtendedKey,
+		localKeyOrigins []*signing.KeyOrigin,
+		cosigners []string,
+		signingThreshold int,
+	) ([]*hdkeychain.ExtendedKey, []*signing.KeyOrigin, error) {
+		return parseCosigners(coin, signing.ScriptTypeP2WSH, localKeys, localKeyOrigins, cosigners, signingThreshold)
+	}
+
+	extendedPublicKeys, keyOrigins, err := parseCosigners(
+		localKeys, localOrigins,
+		[]string{withOrigin(cosigner1, cosigner1Origin), " " + withOrigin(cosigner2, cosigner2Origin)}, 2)
+	require.NoError(t, err)
+	require.Len(t, extendedPublicKeys, 3)
+	require.Equal(t, local, extendedPublicKeys[0])
+	require.Equal(t, cosigner2.String(), extendedPublicKeys[2].String())
+	require.Equal(t, []*signing.KeyOrigin{localOrigin, cosigner1Origin, cosigner2Origin}, keyOrigins)
+
+	// The origins of the local keys may be unknown.
+	_, keyOrigins, err = parseCosigners(
+		localKeys, []*signing.KeyOrigin{nil}, []string{withOrigin(cosigner1, cosigner1Origin)}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []*signing.KeyOrigin{nil, cosigner1Origin}, keyOrigins)
+
+	for _, threshold := range []int{0, 4} {
+		_, _, err = parseCosigners(localKeys, localOrigins,
+			[]string{withOrigin(cosigner1, cosigner1Origin), withOrigin(cosigner2, cosigner2Origin)},
+			threshold)
+		require.Equal(t, ErrInvalidThreshold, errp.Cause(err))
+	}
+
+	// withVersion returns a copy of the key encoded with the given version bytes.
+	withVersion := func(xpub *hdkeychain.ExtendedKey, version [4]byte) *hdkeychain.ExtendedKey {
+		result, err := hdkeychain.NewKeyFromString(xpub.String())
+		require.NoError(t, err)
+		result.SetNet(&chaincfg.Params{HDPublicKeyID: version})
+		return result
+	}
+
+	// The SLIP-132 version of the script type is accepted.
+	cosigner1Vpub := withVersion(cosigner1, [4]byte{0x02, 0x57, 0x54, 0x83})
+	extendedPublicKeys, _, err = parseCosigners(
+		localKeys, localOrigins, []string{withOrigin(cosigner1Vpub, cosigner1Origin)}, 1)
+	require.NoError(t, err)
+	require.Equal(t, cosigner1.String(), extendedPublicKeys[1].String())
+
+	xprv, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	cosigner1Mainnet := withVersion(cosigner1, chaincfg.MainNetParams.HDPublicKeyID)
+	cosigner1Upub := withVersion(cosigner1, [4]byte{0x02, 0x42, 0x89, 0xef})
+	for _, cosigners := range [][]string{
+		{"invalid"},
+		{"[00000000]" + xprv.String()},
+		// The key origin is required.
+		{cosigner1.String()},
+		{"[0000]" + cosigner1.String()},
+		// The keypath does not match the depth of the key.
+		{"[" + hex.EncodeToString(cosigner1Origin.RootFingerprint) + "/48h/1h/0h]" + cosigner1.String()},
+		{withOrigin(local, localOrigin)},
+		{withOrigin(cosigner1, cosigner1Origin), withOrigin(cosigner1, cosigner1Origin)},
+		// Keys of another network or script type.
+		{withOrigin(cosigner1Mainnet, cosigner1Origin)},
+		{withOrigin(cosigner1Upub, cosigner1Origin)},
+	} {
+		_, _, err = parseCosigners(localKeys, localOrigins, cosigners, 1)
+		require.Equal(t, ErrInvalidCosigner, errp.Cause(err), cosigners)
+	}
+
+	tooMany := make([]string, maxCosigners)
+	for i := range tooMany {
+		tooMany[i] = withOrigin(newCosigner(byte(10 + i)))
+	}
+	_, _, err = parseCosigners(localKeys, localOrigins, tooMany, 1)
+	require.Equal(t, ErrInvalidCosigner, errp.Cause(err))
+}
@@ -19,7 +19,9 @@ import (
 
 	bbolt "github.com/coreos/bbolt"
 	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 )
 
 const (
@@ -29,7 +31,14 @@ const (
 
 // Notifier implements accounts.Notifier, storing the data of all accounts in a bbolt db.
 type Notifier struct {
-	db *bbolt.DB
+	dbFilename string
+
+	// lock guards db and encryptionKey.
+	lock locker.Locker
+	db   *bbolt.DB
+	// encryptionKey, if not nil, is used to store the ids as encrypted records, see
+	// encryption.Key.EncryptRecord(), so they can be looked up by their keyed hashes.
+	encryptionKey *encryption.Key
 }
 
 // NewNotifier returns a new Notifier.
@@ -38,17 +47,103 @@ func NewNotifier(dbFilename string) (*Notifier, error) {
 	if err != nil {
 		return nil, errp.WithStack(err)
 	}
-	return &Notifier{db: db}, nil
+	return &Notifier{dbFilename: dbFilename, db: db}, nil
+}
+
+// setEncryptionKey sets the key of an encrypted notifier db. It only affects the notifiers of
+// accounts created afterwards.
+func (notifier *Notifier) setEncryptionKey(key *encryption.Key) {
+	defer notifier.lock.Lock()()
+	notifier.encryptionKey = key
+}
+
+// encryptTo writes a copy of the db to the given file, in which the stored ids of all accounts,
+// stored with oldKey, are stored as records encrypted with key. oldKey is nil if the db is not
+// encrypted yet. The db itself is not modified.
+func (notifier *Notifier) encryptTo(oldKey *encryption.Key, key *encryption.Key, filename string) error {
+	unlock := notifier.lock.RLock()
+	err := notifier.db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(filename, 0600)
+	})
+	unlock()
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	db, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	defer func() { _ = db.Close() }()
+	tx, err := db.Begin(true)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	err = tx.ForEach(func(_ []byte, bucketAccount *bbolt.Bucket) error {
+		for _, bucketKey := range []string{bucketUnnotifiedKey, bucketSeenKey} {
+			bucket := bucketAccount.Bucket([]byte(bucketKey))
+			if bucket == nil {
+				continue
+			}
+			ids := [][]byte{}
+			cursor := bucket.Cursor()
+			for dbKey, dbValue := cursor.First(); dbKey != nil; dbKey, dbValue = cursor.Next() {
+				id, _, err := oldKey.DecryptRecord(dbKey, dbValue)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Delete(dbKey); err != nil {
+					return errp.WithStack(err)
+				}
+				ids = append(ids, append([]byte{}, id...))
+			}
+			for _, id := range ids {
+				dbKey, dbValue, err := key.EncryptRecord(id, nil)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put(dbKey, dbValue); err != nil {
+					return errp.WithStack(err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errp.WithStack(tx.Commit())
+}
+
+// reopen closes the db, calls replace, e.g. to replace the db file, and opens the db again.
+func (notifier *Notifier) reopen(replace func() error) error {
+	defer notifier.lock.Lock()()
+	if err := notifier.db.Close(); err != nil {
+		return errp.WithStack(err)
+	}
+	replaceErr := replace()
+	db, err := bbolt.Open(notifier.dbFilename, 0600, nil)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	notifier.db = db
+	return replaceErr
 }
 
 type notifierForAccount struct {
-	db          *bbolt.DB
-	accountCode string
+	db            *bbolt.DB
+	accountCode   string
+	encryptionKey *encryption.Key
 }
 
 // ForAccount returns a Notifier for a specific account.
 func (notifier *Notifier) ForAccount(accountCode string) accounts.Notifier {
-	return &notifierForAccount{db: notifier.db, accountCode: accountCode}
+	defer notifier.lock.RLock()()
+	return &notifierForAccount{
+		db:            notifier.db,
+		accountCode:   accountCode,
+		encryptionKey: notifier.encryptionKey,
+	}
 }
 
 func (notifier *notifierForAccount) write(
@@ -96,16 +191,21 @@ func (notifier *notifierForAccount) read(
 
 // Put implements accounts.Notifier,
 func (notifier *notifierForAccount) Put(id []byte) error {
+	dbKey, dbValue, err := notifier.encryptionKey.EncryptRecord(id, nil)
+	if err != nil {
+		return err
+	}
 	return notifier.write(func(bucketUnnotified, bucketSeen *bbolt.Bucket) error {
-		if bucketSeen.Get(id) != nil {
+		if bucketSeen.Get(dbKey) != nil {
 			return nil
 		}
-		return errp.WithStack(bucketUnnotified.Put(id, nil))
+		return errp.WithStack(bucketUnnotified.Put(dbKey, dbValue))
 	})
 }
 
 // Delete implements accounts.Notifier.
 func (notifier *notifierForAccount) Delete(id []byte) error {
+	id = notifier.encryptionKey.HashID(id)
 	return notifier.write(func(bucketUnnotified, bucketSeen *bbolt.Bucket) error {
 		if err := bucketUnnotified.Delete(id); err != nil {
 			return errp.WithStack(err)
@@ -143,11 +243,12 @@ func (notifier *notifierForAccount) UnnotifiedCount() (int, error) {
 func (notifier *notifierForAccount) MarkAllNotified() error {
 	return notifier.write(func(bucketUnnotified, bucketSeen *bbolt.Bucket) error {
 		cursor := bucketUnnotified.Cursor()
-		for id, _ := cursor.First(); id != nil; id, _ = cursor.Next() {
+		for id, value := cursor.First(); id != nil; id, value = cursor.Next() {
+			value = append([]byte(nil), value...)
 			if err := bucketUnnotified.Delete(id); err != nil {
 				return errp.WithStack(err)
 			}
-			if err := bucketSeen.Put(id, nil); err != nil {
+			if err := bucketSeen.Put(id, value); err != nil {
 				return errp.WithStack(err)
 			}
 		}
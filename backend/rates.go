@@ -0,0 +1,64 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/accounts"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/coins/coin"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/rates"
+)
+
+// updateRatesCoins sets the coins of the rates updater to the units of the given accounts and of
+// the active ERC20 tokens.
+func (backend *Backend) updateRatesCoins(accounts []accounts.Interface) {
+	units := []string{}
+	for _, account := range accounts {
+		for _, isFee := range []bool{false, true} {
+			if unit := coin.RatesUnit(account.Coin(), isFee); unit != "" {
+				units = append(units, unit)
+			}
+		}
+	}
+	ethConfig := backend.config.AppConfig().Backend.ETH
+	for _, token := range backend.ERC20Tokens() {
+		// The unit of user-added tokens is not a reliable rates identifier.
+		if ethConfig.ERC20TokenActive(token.Code) && !token.UserAdded {
+			units = append(units, token.Unit, "ETH")
+		}
+	}
+	backend.ratesUpdater.SetCoins(units)
+}
+
+// UpdateRatesFiats sets the fiats of the rates updater to the ones selected by the user in the
+// frontend config. All supported fiats are used if the user has not selected any.
+func (backend *Backend) UpdateRatesFiats() {
+	fiats := []string{}
+	if frontendConfig, ok := backend.config.AppConfig().Frontend.(map[string]interface{}); ok {
+		if fiatList, ok := frontendConfig["fiatList"].([]interface{}); ok {
+			for _, fiat := range fiatList {
+				if fiat, ok := fiat.(string); ok {
+					fiats = append(fiats, fiat)
+				}
+			}
+		}
+		if fiatCode, ok := frontendConfig["fiatCode"].(string); ok && len(fiats) != 0 {
+			fiats = append(fiats, fiatCode)
+		}
+	}
+	if len(fiats) == 0 {
+		fiats = rates.Fiats
+	}
+	backend.ratesUpdater.SetFiats(fiats)
+}
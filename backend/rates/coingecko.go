@@ -0,0 +1,124 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rates
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const coinGeckoURL = "https://api.coingecko.com/api/v3"
+
+// coinGeckoIDs maps the units to the coin ids of CoinGecko. Coins missing here are not supported.
+var coinGeckoIDs = map[string]string{
+	"BTC":  "bitcoin",
+	"LTC":  "litecoin",
+	"ETH":  "ethereum",
+	"USDT": "tether",
+	"LINK": "chainlink",
+	"MKR":  "maker",
+	"ZRX":  "0x",
+	"DAI":  "dai",
+	"BAT":  "basic-attention-token",
+}
+
+// CoinGecko fetches rates from coingecko.com.
+type CoinGecko struct {
+	apiURL string
+}
+
+// NewCoinGecko returns a new CoinGecko provider.
+func NewCoinGecko() *CoinGecko {
+	return &CoinGecko{apiURL: coinGeckoURL}
+}
+
+// Latest implements Provider.
+func (provider *CoinGecko) Latest(
+	client *http.Client, coins []string, fiats []string) (map[string]map[string]float64, error) {
+	ids := []string{}
+	for _, coin := range coins {
+		if id, ok := coinGeckoIDs[coin]; ok {
+			ids = append(ids, id)
+		}
+	}
+	vsCurrencies := make([]string, len(fiats))
+	for index, fiat := range fiats {
+		vsCurrencies[index] = strings.ToLower(fiat)
+	}
+	// Indexed by coin id and lowercase fiat.
+	var response map[string]map[string]float64
+	err := getJSON(client, fmt.Sprintf("%s/simple/price?ids=%s&vs_currencies=%s",
+		provider.apiURL,
+		url.QueryEscape(strings.Join(ids, ",")),
+		url.QueryEscape(strings.Join(vsCurrencies, ",")),
+	), &response)
+	if err != nil {
+		return nil, err
+	}
+	rates := map[string]map[string]float64{}
+	for _, coin := range coins {
+		coinRates, ok := response[coinGeckoIDs[coin]]
+		if !ok {
+			continue
+		}
+		rates[coin] = map[string]float64{}
+		for _, fiat := range fiats {
+			if rate, ok := coinRates[strings.ToLower(fiat)]; ok {
+				rates[coin][fiat] = rate
+			}
+		}
+	}
+	return rates, nil
+}
+
+// Supports implements Provider. Only the coins of coinGeckoIDs are supported.
+func (provider *CoinGecko) Supports(coin string) bool {
+	_, ok := coinGeckoIDs[coin]
+	return ok
+}
+
+// Historical implements Provider. The response contains the rates in all fiats.
+func (provider *CoinGecko) Historical(
+	client *http.Client, coin string, fiats []string, date time.Time) (map[string]float64, error) {
+	id, ok := coinGeckoIDs[coin]
+	if !ok {
+		return nil, errp.Newf("coin %s not supported by CoinGecko", coin)
+	}
+	var response struct {
+		MarketData struct {
+			CurrentPrice map[string]float64 `json:"current_price"`
+		} `json:"market_data"`
+	}
+	err := getJSON(client, fmt.Sprintf("%s/coins/%s/history?date=%s&localization=false",
+		provider.apiURL,
+		url.PathEscape(id),
+		day(date).Format("02-01-2006"),
+	), &response)
+	if err != nil {
+		return nil, err
+	}
+	rates := map[string]float64{}
+	for _, fiat := range fiats {
+		if rate, ok := response.MarketData.CurrentPrice[strings.ToLower(fiat)]; ok {
+			rates[fiat] = rate
+		}
+	}
+	return rates, nil
+}
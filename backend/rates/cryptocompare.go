@@ -0,0 +1,77 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rates
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const cryptoCompareURL = "https://min-api.cryptocompare.com/data"
+
+// CryptoCompare fetches rates from cryptocompare.com.
+type CryptoCompare struct {
+	apiURL string
+}
+
+// NewCryptoCompare returns a new CryptoCompare provider.
+func NewCryptoCompare() *CryptoCompare {
+	return &CryptoCompare{apiURL: cryptoCompareURL}
+}
+
+// Latest implements Provider.
+func (provider *CryptoCompare) Latest(
+	client *http.Client, coins []string, fiats []string) (map[string]map[string]float64, error) {
+	var rates map[string]map[string]float64
+	err := getJSON(client, fmt.Sprintf("%s/pricemulti?fsyms=%s&tsyms=%s",
+		provider.apiURL,
+		url.QueryEscape(strings.Join(coins, ",")),
+		url.QueryEscape(strings.Join(fiats, ",")),
+	), &rates)
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// Supports implements Provider. CryptoCompare is queried by unit, so all coins are tried.
+func (provider *CryptoCompare) Supports(coin string) bool {
+	return true
+}
+
+// Historical implements Provider.
+func (provider *CryptoCompare) Historical(
+	client *http.Client, coin string, fiats []string, date time.Time) (map[string]float64, error) {
+	var rates map[string]map[string]float64
+	err := getJSON(client, fmt.Sprintf("%s/pricehistorical?fsym=%s&tsyms=%s&ts=%d",
+		provider.apiURL,
+		url.QueryEscape(coin),
+		url.QueryEscape(strings.Join(fiats, ",")),
+		day(date).Unix(),
+	), &rates)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]float64{}
+	for _, fiat := range fiats {
+		if rate, ok := rates[coin][fiat]; ok {
+			result[fiat] = rate
+		}
+	}
+	return result, nil
+}
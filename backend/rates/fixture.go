@@ -0,0 +1,67 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rates
+
+import (
+	"net/http"
+	"time"
+)
+
+// Fixture is a provider serving fixed rates without network access, to be used in tests.
+type Fixture struct {
+	// Rates are returned by Latest, indexed by coin and fiat.
+	Rates map[string]map[string]float64
+	// HistoricalRates are returned by Historical, indexed by coin, fiat and day in the format
+	// "2006-01-02".
+	HistoricalRates map[string]map[string]map[string]float64
+}
+
+// Latest implements Provider.
+func (fixture *Fixture) Latest(
+	_ *http.Client, coins []string, fiats []string) (map[string]map[string]float64, error) {
+	rates := map[string]map[string]float64{}
+	for _, coin := range coins {
+		for _, fiat := range fiats {
+			rate, ok := fixture.Rates[coin][fiat]
+			if !ok {
+				continue
+			}
+			if _, ok := rates[coin]; !ok {
+				rates[coin] = map[string]float64{}
+			}
+			rates[coin][fiat] = rate
+		}
+	}
+	return rates, nil
+}
+
+// Supports implements Provider.
+func (fixture *Fixture) Supports(coin string) bool {
+	_, latest := fixture.Rates[coin]
+	_, historical := fixture.HistoricalRates[coin]
+	return latest || historical
+}
+
+// Historical implements Provider.
+func (fixture *Fixture) Historical(
+	_ *http.Client, coin string, fiats []string, date time.Time) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, fiat := range fiats {
+		if rate, ok := fixture.HistoricalRates[coin][fiat][day(date).Format(dayFormat)]; ok {
+			rates[fiat] = rate
+		}
+	}
+	return rates, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rates
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/atomicfile"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+)
+
+const dayFormat = "2006-01-02"
+
+// day returns the start of the day (UTC) of the given time.
+func day(date time.Time) time.Time {
+	year, month, dayOfMonth := date.UTC().Date()
+	return time.Date(year, month, dayOfMonth, 0, 0, 0, 0, time.UTC)
+}
+
+func historyKey(coin string, fiat string, date time.Time) string {
+	return coin + "/" + fiat + "/" + day(date).Format(dayFormat)
+}
+
+// historyCache stores the historical rates in a JSON file, so they are only fetched once. The file
+// is encrypted if an encryption key is set.
+type historyCache struct {
+	filename string
+	// rates are indexed by historyKey().
+	rates         map[string]float64
+	encryptionKey *encryption.Key
+	// locked is true if the file is encrypted and the key is not set yet. The fetched rates are
+	// only kept in memory until then.
+	locked bool
+	lock   locker.Locker
+}
+
+// loadHistoryCache loads the cache from the file if it exists. If the file is encrypted, the rates
+// are loaded when the key is set with setEncryptionKey().
+func loadHistoryCache(filename string) (*historyCache, error) {
+	cache := &historyCache{
+		filename: filename,
+		rates:    map[string]float64{},
+	}
+	rates, err := cache.load()
+	if errp.Cause(err) == encryption.ErrLocked {
+		cache.locked = true
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache.rates = rates
+	return cache, nil
+}
+
+// load reads the rates from the file. Requires the lock when called after the construction of the
+// cache.
+func (cache *historyCache) load() (map[string]float64, error) {
+	rates := map[string]float64{}
+	data, err := ioutil.ReadFile(cache.filename)
+	if os.IsNotExist(err) {
+		return rates, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	jsonBytes, err := cache.encryptionKey.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jsonBytes, &rates); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return rates, nil
+}
+
+// save writes the rates to the file. Requires the lock.
+func (cache *historyCache) save() error {
+	if cache.locked {
+		return nil
+	}
+	jsonBytes, err := json.Marshal(cache.rates)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	data, err := cache.encryptionKey.Encrypt(jsonBytes)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(cache.filename, data, 0600)
+}
+
+// setEncryptionKey sets the key with which the file is encrypted. If the file is encrypted, the
+// stored rates are loaded and merged with the rates fetched in the meantime, otherwise the file is
+// encrypted right away.
+func (cache *historyCache) setEncryptionKey(key *encryption.Key) error {
+	defer cache.lock.Lock()()
+	cache.encryptionKey = key
+	if cache.locked {
+		rates, err := cache.load()
+		if err != nil {
+			return err
+		}
+		for historyKey, rate := range cache.rates {
+			rates[historyKey] = rate
+		}
+		cache.rates = rates
+		cache.locked = false
+	}
+	return cache.save()
+}
+
+func (cache *historyCache) get(coin string, fiat string, date time.Time) (float64, bool) {
+	defer cache.lock.RLock()()
+	rate, ok := cache.rates[historyKey(coin, fiat, date)]
+	return rate, ok
+}
+
+// put stores the rates of the coin at the given date, indexed by fiat, and writes the file once.
+func (cache *historyCache) put(coin string, date time.Time, rates map[string]float64) error {
+	defer cache.lock.Lock()()
+	for fiat, rate := range rates {
+		cache.rates[historyKey(coin, fiat, date)] = rate
+	}
+	return cache.save()
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rates
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+const (
+	// ProviderCryptoCompare is the name of the CryptoCompare provider.
+	ProviderCryptoCompare = "cryptocompare"
+	// ProviderCoinGecko is the name of the CoinGecko provider.
+	ProviderCoinGecko = "coingecko"
+)
+
+// Provider fetches exchange rates from a rates service. Coins and fiats are identified by their
+// uppercase unit, e.g. "BTC" and "USD".
+type Provider interface {
+	// Latest returns the current rates, indexed by coin and then by fiat. Rates the service does
+	// not know are missing in the result.
+	Latest(client *http.Client, coins []string, fiats []string) (map[string]map[string]float64, error)
+	// Historical returns the rates of the coin in the fiats at the given day (UTC), indexed by fiat.
+	// Rates the service does not know are missing in the result.
+	Historical(client *http.Client, coin string, fiats []string, date time.Time) (map[string]float64, error)
+	// Supports returns false if the service has no rates of the coin. Coins which are not built in,
+	// e.g. user-added tokens, may not be known.
+	Supports(coin string) bool
+}
+
+// NewProvider returns the provider with the given name. The empty name returns the default
+// provider.
+func NewProvider(name string) (Provider, error) {
+	switch name {
+	case "", ProviderCryptoCompare:
+		return NewCryptoCompare(), nil
+	case ProviderCoinGecko:
+		return NewCoinGecko(), nil
+	default:
+		return nil, errp.Newf("unknown rates provider %s", name)
+	}
+}
+
+// getJSON fetches the url and decodes the JSON response into result.
+func getJSON(client *http.Client, url string, result interface{}) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return errp.Newf("%s returned status %d", url, response.StatusCode)
+	}
+	return errp.WithStack(json.NewDecoder(response.Body).Decode(result))
+}
@@ -15,13 +15,14 @@
 package rates
 
 import (
-	"encoding/json"
-	"fmt"
 	"reflect"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/observable/action"
@@ -29,26 +30,53 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var coins = []string{"BTC", "LTC", "ETH", "USDT", "LINK", "MKR", "ZRX", "DAI", "BAT"}
-var fiats = []string{"USD", "EUR", "CHF", "GBP", "JPY", "KRW", "CNY", "RUB", "CAD"}
+// ErrUnsupportedCoin is returned if the provider has no rates of the coin.
+var ErrUnsupportedCoin = errp.New("The rates provider does not support the coin")
+
+// Fiats are the supported fiat currencies.
+var Fiats = []string{"USD", "EUR", "CHF", "GBP", "JPY", "KRW", "CNY", "RUB", "CAD"}
 
 const interval = time.Minute
-const cryptoCompareURL = "https://min-api.cryptocompare.com/data/pricemulti?fsyms=%s&tsyms=%s"
 
 // RateUpdater implements coin.RateUpdater.
 type RateUpdater struct {
 	observable.Implementation
-	last       map[string]map[string]float64
+	provider Provider
+	history  *historyCache
+
+	last  map[string]map[string]float64
+	coins []string
+	fiats []string
+	// pendingHistorical contains the historyKey() of the historical rates being fetched. The
+	// channels are closed once the fetch is done.
+	pendingHistorical map[string]chan struct{}
+	lock              locker.Locker
+
+	// refresh triggers an update of the rates before the next interval.
+	refresh chan struct{}
+
 	log        *logrus.Entry
 	socksProxy socksproxy.SocksProxy
 }
 
-// NewRateUpdater returns a new rates updater.
-func NewRateUpdater(socksProxy socksproxy.SocksProxy) *RateUpdater {
+// NewRateUpdater returns a new rates updater, which fetches the rates from the provider. The
+// historical rates are cached in historyFilename.
+func NewRateUpdater(socksProxy socksproxy.SocksProxy, provider Provider, historyFilename string) *RateUpdater {
+	log := logging.Get().WithGroup("rates")
+	history, err := loadHistoryCache(historyFilename)
+	if err != nil {
+		log.WithError(err).Error("Could not load the historical rates, starting with an empty cache")
+		history = &historyCache{filename: historyFilename, rates: map[string]float64{}}
+	}
 	ratesUpdater := &RateUpdater{
-		last:       map[string]map[string]float64{},
-		log:        logging.Get().WithGroup("rates"),
-		socksProxy: socksProxy,
+		provider:          provider,
+		history:           history,
+		last:              map[string]map[string]float64{},
+		fiats:             Fiats,
+		pendingHistorical: map[string]chan struct{}{},
+		refresh:           make(chan struct{}, 1),
+		log:               log,
+		socksProxy:        socksProxy,
 	}
 	go ratesUpdater.start()
 	return ratesUpdater
@@ -56,42 +84,96 @@ func NewRateUpdater(socksProxy socksproxy.SocksProxy) *RateUpdater {
 
 // Last returns the last rates for a given coin and fiat or nil if not available.
 func (updater *RateUpdater) Last() map[string]map[string]float64 {
+	defer updater.lock.RLock()()
 	return updater.last
 }
 
+// normalize returns the sorted list without duplicates.
+func normalize(list []string) []string {
+	set := map[string]struct{}{}
+	result := []string{}
+	for _, item := range list {
+		if _, ok := set[item]; ok {
+			continue
+		}
+		set[item] = struct{}{}
+		result = append(result, item)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SetCoins sets the coins for which the rates are fetched, e.g. the units of the configured
+// accounts. The rates are updated right away if the coins changed.
+func (updater *RateUpdater) SetCoins(coins []string) {
+	coins = normalize(coins)
+	defer updater.lock.Lock()()
+	if reflect.DeepEqual(coins, updater.coins) {
+		return
+	}
+	updater.coins = coins
+	updater.Refresh()
+}
+
+// Fiats returns the fiat currencies for which the rates are fetched.
+func (updater *RateUpdater) Fiats() []string {
+	defer updater.lock.RLock()()
+	return updater.fiats
+}
+
+// SetFiats sets the fiat currencies for which the rates are fetched, e.g. the ones selected by the
+// user. The rates are updated right away if the fiats changed.
+func (updater *RateUpdater) SetFiats(fiats []string) {
+	fiats = normalize(fiats)
+	defer updater.lock.Lock()()
+	if reflect.DeepEqual(fiats, updater.fiats) {
+		return
+	}
+	updater.fiats = fiats
+	updater.Refresh()
+}
+
+// SetEncryptionKey sets the key with which the cache of the historical rates is encrypted.
+func (updater *RateUpdater) SetEncryptionKey(key *encryption.Key) error {
+	return updater.history.setEncryptionKey(key)
+}
+
+// Refresh updates the rates without waiting for the next interval.
+func (updater *RateUpdater) Refresh() {
+	select {
+	case updater.refresh <- struct{}{}:
+	default:
+		// A refresh is already pending.
+	}
+}
+
 func (updater *RateUpdater) update() {
-	client, err := updater.socksProxy.GetHTTPClient()
-	if err != nil {
-		updater.log.Printf("Error getting http client %v\n", err)
-		updater.last = nil
+	unlock := updater.lock.RLock()
+	coins, fiats := updater.coins, updater.fiats
+	unlock()
+	if len(coins) == 0 || len(fiats) == 0 {
 		return
 	}
 
-	response, err := client.Get(fmt.Sprintf(cryptoCompareURL,
-		strings.Join(coins, ","),
-		strings.Join(fiats, ","),
-	))
+	client, err := updater.socksProxy.GetHTTPClient()
 	if err != nil {
-		updater.log.Printf("Error getting rates: %v\n", err)
-		updater.last = nil
+		updater.log.Printf("Error getting http client %v\n", err)
+		updater.setLast(nil)
 		return
 	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
 
-	var rates map[string]map[string]float64
-	err = json.NewDecoder(response.Body).Decode(&rates)
+	rates, err := updater.provider.Latest(client, coins, fiats)
 	if err != nil {
-		updater.last = nil
+		updater.log.Printf("Error getting rates: %v\n", err)
+		updater.setLast(nil)
 		return
 	}
 
-	if reflect.DeepEqual(rates, updater.last) {
+	if reflect.DeepEqual(rates, updater.Last()) {
 		return
 	}
 
-	updater.last = rates
+	updater.setLast(rates)
 	updater.log.WithField("data", spew.Sprintf("%v", rates)).Debug("Exchange rates changed.")
 	updater.Notify(observable.Event{
 		Subject: "rates",
@@ -100,9 +182,155 @@ func (updater *RateUpdater) update() {
 	})
 }
 
+func (updater *RateUpdater) setLast(rates map[string]map[string]float64) {
+	defer updater.lock.Lock()()
+	updater.last = rates
+}
+
 func (updater *RateUpdater) start() {
 	for {
 		updater.update()
-		time.Sleep(interval)
+		select {
+		case <-updater.refresh:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// isToday returns true if the date is today or in the future. The rates of these days can still
+// change, so the latest rates are used instead of the historical ones.
+func isToday(date time.Time) bool {
+	return !day(date).Before(day(time.Now()))
+}
+
+// Supports returns false if the provider has no rates of the coin, or if the coin is empty, e.g. the
+// rates unit of a user-added token. Historical rates of these coins are not fetched.
+func (updater *RateUpdater) Supports(coin string) bool {
+	return coin != "" && updater.provider.Supports(coin)
+}
+
+// CachedHistoricalRate returns the rate of the coin in the fiat at the given date if it is
+// available without network access.
+func (updater *RateUpdater) CachedHistoricalRate(coin string, fiat string, date time.Time) (float64, bool) {
+	if isToday(date) {
+		rate, ok := updater.Last()[coin][fiat]
+		return rate, ok
+	}
+	return updater.history.get(coin, fiat, date)
+}
+
+// HistoricalRate returns the rate of the coin in the fiat at the given date. It is fetched from the
+// provider and cached if it is not available yet.
+func (updater *RateUpdater) HistoricalRate(coin string, fiat string, date time.Time) (float64, error) {
+	if rate, ok := updater.CachedHistoricalRate(coin, fiat, date); ok {
+		return rate, nil
+	}
+	if isToday(date) {
+		return 0, errp.Newf("no rate for %s/%s", coin, fiat)
+	}
+	if !updater.Supports(coin) {
+		return 0, errp.WithStack(ErrUnsupportedCoin)
+	}
+	// The other fiats are fetched in the same request.
+	rates, err := updater.fetchHistorical(coin, normalize(append([]string{fiat}, updater.Fiats()...)), date)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := rates[fiat]
+	if !ok {
+		return 0, errp.Newf("no historical rate for %s/%s", coin, fiat)
+	}
+	return rate, nil
+}
+
+// fetchHistorical fetches the rates of the coin in the fiats at the given date in one request and
+// caches them.
+func (updater *RateUpdater) fetchHistorical(
+	coin string, fiats []string, date time.Time) (map[string]float64, error) {
+	client, err := updater.socksProxy.GetHTTPClient()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	rates, err := updater.provider.Historical(client, coin, fiats, date)
+	if err != nil {
+		return nil, err
+	}
+	if err := updater.history.put(coin, date, rates); err != nil {
+		updater.log.WithError(err).Error("Could not cache the historical rates")
+	}
+	return rates, nil
+}
+
+// FetchHistoricalRates fetches the missing historical rates of the coin in all fiats at the given
+// dates in the background, one request per day. Once done, observers are notified to reload the
+// historical rates if any of them could be fetched, and the returned channel is closed. Rates
+// already being fetched by a previous call are waited for. Nothing is fetched if the coin is not
+// supported.
+func (updater *RateUpdater) FetchHistoricalRates(coin string, dates []time.Time) <-chan struct{} {
+	done := make(chan struct{})
+	if !updater.Supports(coin) {
+		close(done)
+		return done
+	}
+	// batches are the fiats to fetch by day.
+	batches := map[time.Time][]string{}
+	fetching := make(chan struct{})
+	waitFor := []chan struct{}{}
+	unlock := updater.lock.Lock()
+	for _, date := range dates {
+		if isToday(date) {
+			continue
+		}
+		for _, fiat := range updater.fiats {
+			key := historyKey(coin, fiat, date)
+			if pending, ok := updater.pendingHistorical[key]; ok {
+				if pending != fetching {
+					waitFor = append(waitFor, pending)
+				}
+				continue
+			}
+			if _, ok := updater.history.get(coin, fiat, date); ok {
+				continue
+			}
+			updater.pendingHistorical[key] = fetching
+			batches[day(date)] = append(batches[day(date)], fiat)
+		}
 	}
+	unlock()
+	if len(batches) == 0 && len(waitFor) == 0 {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		fetched := false
+		for date, fiats := range batches {
+			rates, err := updater.fetchHistorical(coin, fiats, date)
+			if err != nil {
+				updater.log.WithError(err).Warn("Could not fetch the historical rates")
+			} else if len(rates) != 0 {
+				fetched = true
+			}
+		}
+		unlock := updater.lock.Lock()
+		for date, fiats := range batches {
+			for _, fiat := range fiats {
+				delete(updater.pendingHistorical, historyKey(coin, fiat, date))
+			}
+		}
+		unlock()
+		close(fetching)
+		for _, pending := range waitFor {
+			<-pending
+		}
+		if !fetched {
+			// Don't trigger a reload, which would request the failed rates again.
+			return
+		}
+		updater.Notify(observable.Event{
+			Subject: "rates/historical",
+			Action:  action.Reload,
+		})
+	}()
+	return done
 }
@@ -0,0 +1,245 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rates
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/observable"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/socksproxy"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateUpdater(t *testing.T) {
+	fixture := &Fixture{
+		Rates: map[string]map[string]float64{
+			"BTC": {"USD": 10000, "EUR": 9000},
+			"ETH": {"USD": 200},
+		},
+		HistoricalRates: map[string]map[string]map[string]float64{
+			"BTC": {"USD": {"2020-01-01": 7000}},
+		},
+	}
+	historyFilename := test.TstTempFile("bitbox-wallet-rates-history")
+	updater := NewRateUpdater(socksproxy.NewSocksProxy(false, ""), fixture, historyFilename)
+	events := make(chan observable.Event, 10)
+	updater.Observe(func(event observable.Event) { events <- event })
+
+	updater.SetFiats([]string{"USD", "EUR", "USD"})
+	require.Equal(t, []string{"EUR", "USD"}, updater.Fiats())
+	updater.SetCoins([]string{"BTC", "BTC"})
+	select {
+	case event := <-events:
+		require.Equal(t, "rates", event.Subject)
+	case <-time.After(time.Second):
+		require.FailNow(t, "rates not updated")
+	}
+	require.Equal(t,
+		map[string]map[string]float64{"BTC": {"USD": 10000, "EUR": 9000}},
+		updater.Last())
+
+	// The rates of today are the latest rates.
+	rate, ok := updater.CachedHistoricalRate("BTC", "EUR", time.Now())
+	require.True(t, ok)
+	require.Equal(t, 9000.0, rate)
+
+	date := time.Date(2020, 1, 1, 15, 30, 0, 0, time.UTC)
+	_, ok = updater.CachedHistoricalRate("BTC", "USD", date)
+	require.False(t, ok)
+	rate, err := updater.HistoricalRate("BTC", "USD", date)
+	require.NoError(t, err)
+	require.Equal(t, 7000.0, rate)
+	_, err = updater.HistoricalRate("BTC", "EUR", date)
+	require.Error(t, err)
+
+	// The historical rate is cached on disk.
+	fixture.HistoricalRates = nil
+	rate, ok = NewRateUpdater(socksproxy.NewSocksProxy(false, ""), fixture, historyFilename).
+		CachedHistoricalRate("BTC", "USD", date)
+	require.True(t, ok)
+	require.Equal(t, 7000.0, rate)
+}
+
+func TestFetchHistoricalRates(t *testing.T) {
+	fixture := &Fixture{
+		HistoricalRates: map[string]map[string]map[string]float64{
+			"BTC": {"USD": {"2020-01-01": 7000, "2020-01-02": 7100}},
+		},
+	}
+	updater := NewRateUpdater(
+		socksproxy.NewSocksProxy(false, ""), fixture, test.TstTempFile("bitbox-wallet-rates-history"))
+	updater.SetFiats([]string{"USD"})
+	dates := []time.Time{
+		time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 20, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC),
+	}
+	select {
+	case <-updater.FetchHistoricalRates("BTC", dates):
+	case <-time.After(time.Second):
+		require.FailNow(t, "historical rates not fetched")
+	}
+	for index, expected := range []float64{7000, 7000, 7100} {
+		rate, ok := updater.CachedHistoricalRate("BTC", "USD", dates[index])
+		require.True(t, ok)
+		require.Equal(t, expected, rate)
+	}
+
+	// Coins unknown to the provider, e.g. user-added tokens, are not fetched.
+	require.False(t, updater.Supports("UNI"))
+	select {
+	case <-updater.FetchHistoricalRates("UNI", dates):
+	default:
+		require.FailNow(t, "unsupported coin fetched")
+	}
+	_, err := updater.HistoricalRate("UNI", "USD", dates[0])
+	require.Equal(t, ErrUnsupportedCoin, errp.Cause(err))
+}
+
+// blockingProvider counts the historical requests and blocks them until released.
+type blockingProvider struct {
+	*Fixture
+	requests chan []string
+	release  chan struct{}
+}
+
+func (provider *blockingProvider) Historical(
+	client *http.Client, coin string, fiats []string, date time.Time) (map[string]float64, error) {
+	provider.requests <- fiats
+	<-provider.release
+	return provider.Fixture.Historical(client, coin, fiats, date)
+}
+
+// TestFetchHistoricalRatesPending tests that the rates of a day are fetched in one request, and
+// that later calls wait for the rates already being fetched.
+func TestFetchHistoricalRatesPending(t *testing.T) {
+	provider := &blockingProvider{
+		Fixture: &Fixture{
+			HistoricalRates: map[string]map[string]map[string]float64{
+				"BTC": {"USD": {"2020-01-01": 7000}, "EUR": {"2020-01-01": 6000}},
+			},
+		},
+		requests: make(chan []string, 10),
+		release:  make(chan struct{}),
+	}
+	updater := NewRateUpdater(
+		socksproxy.NewSocksProxy(false, ""), provider, test.TstTempFile("bitbox-wallet-rates-history"))
+	updater.SetFiats([]string{"USD", "EUR"})
+	dates := []time.Time{time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)}
+
+	first := updater.FetchHistoricalRates("BTC", dates)
+	require.Equal(t, []string{"EUR", "USD"}, <-provider.requests)
+	second := updater.FetchHistoricalRates("BTC", dates)
+	select {
+	case <-second:
+		require.FailNow(t, "the pending rates were not waited for")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(provider.release)
+	<-first
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		require.FailNow(t, "historical rates not fetched")
+	}
+	rate, ok := updater.CachedHistoricalRate("BTC", "EUR", dates[0])
+	require.True(t, ok)
+	require.Equal(t, 6000.0, rate)
+	// The second call did not request the rates again.
+	require.Empty(t, provider.requests)
+}
+
+func TestHistoryEncryption(t *testing.T) {
+	historyFilename := test.TstTempFile("bitbox-wallet-rates-history")
+	date := time.Date(2020, 1, 1, 15, 30, 0, 0, time.UTC)
+	cache, err := loadHistoryCache(historyFilename)
+	require.NoError(t, err)
+	require.NoError(t, cache.put("BTC", date, map[string]float64{"USD": 7000}))
+
+	// Setting the key encrypts the existing rates.
+	key, err := encryption.NewKey()
+	require.NoError(t, err)
+	require.NoError(t, cache.setEncryptionKey(key))
+	data, err := ioutil.ReadFile(historyFilename)
+	require.NoError(t, err)
+	require.True(t, encryption.IsEncrypted(data))
+
+	// Rates fetched before the key is set are kept and merged with the stored ones.
+	cache, err = loadHistoryCache(historyFilename)
+	require.NoError(t, err)
+	_, ok := cache.get("BTC", "USD", date)
+	require.False(t, ok)
+	require.NoError(t, cache.put("BTC", date, map[string]float64{"EUR": 6000}))
+	data, err = ioutil.ReadFile(historyFilename)
+	require.NoError(t, err)
+	require.True(t, encryption.IsEncrypted(data))
+	require.NoError(t, cache.setEncryptionKey(key))
+	rate, ok := cache.get("BTC", "USD", date)
+	require.True(t, ok)
+	require.Equal(t, 7000.0, rate)
+
+	cache, err = loadHistoryCache(historyFilename)
+	require.NoError(t, err)
+	require.NoError(t, cache.setEncryptionKey(key))
+	rate, ok = cache.get("BTC", "EUR", date)
+	require.True(t, ok)
+	require.Equal(t, 6000.0, rate)
+}
+
+func TestProviders(t *testing.T) {
+	date := time.Date(2020, 1, 31, 15, 30, 0, 0, time.UTC)
+	responses := map[string]string{
+		"/pricemulti?fsyms=BTC%2CETH&tsyms=USD%2CEUR":                  `{"BTC":{"USD":10000,"EUR":9000},"ETH":{"USD":200,"EUR":180}}`,
+		"/pricehistorical?fsym=BTC&tsyms=USD%2CEUR&ts=1580428800":      `{"BTC":{"USD":9300,"EUR":8400}}`,
+		"/simple/price?ids=bitcoin%2Cethereum&vs_currencies=usd%2Ceur": `{"bitcoin":{"usd":10000,"eur":9000},"ethereum":{"usd":200,"eur":180}}`,
+		"/coins/bitcoin/history?date=31-01-2020&localization=false":    `{"market_data":{"current_price":{"usd":9300,"eur":8400,"chf":9000}}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	for _, provider := range []Provider{
+		&CryptoCompare{apiURL: server.URL},
+		&CoinGecko{apiURL: server.URL},
+	} {
+		rates, err := provider.Latest(server.Client(), []string{"BTC", "ETH"}, []string{"USD", "EUR"})
+		require.NoError(t, err)
+		require.Equal(t,
+			map[string]map[string]float64{
+				"BTC": {"USD": 10000, "EUR": 9000},
+				"ETH": {"USD": 200, "EUR": 180},
+			},
+			rates)
+		historicalRates, err := provider.Historical(server.Client(), "BTC", []string{"USD", "EUR"}, date)
+		require.NoError(t, err)
+		require.Equal(t, map[string]float64{"USD": 9300, "EUR": 8400}, historicalRates)
+		_, err = provider.Historical(server.Client(), "ETH", []string{"USD"}, date)
+		require.Error(t, err)
+	}
+	require.False(t, (&CoinGecko{}).Supports("UNI"))
+}
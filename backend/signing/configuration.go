@@ -23,11 +23,30 @@ import (
 	"sort"
 
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/jsonp"
 )
 
+// KeyOrigin identifies the master key an extended public key was derived from and the keypath of
+// the derivation, see BIP32 and BIP174.
+type KeyOrigin struct {
+	// RootFingerprint is the first 4 bytes of the hash160 of the master public key.
+	RootFingerprint []byte
+	// Keypath is the keypath from the master key to the extended public key.
+	Keypath AbsoluteKeypath
+}
+
+// RootFingerprint returns the fingerprint of the given master key, see KeyOrigin.
+func RootFingerprint(master *hdkeychain.ExtendedKey) ([]byte, error) {
+	publicKey, err := master.ECPubKey()
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return btcutil.Hash160(publicKey.SerializeCompressed())[:4], nil
+}
+
 // Configuration models a signing configuration, which can be singlesig, multisig or address based.
 type Configuration struct {
 	scriptType         ScriptType // Only used in btc and ltc, dummy for eth
@@ -35,11 +54,15 @@ type Configuration struct {
 	extendedPublicKeys []*hdkeychain.ExtendedKey // Should be empty for address based watch only accounts
 	signingThreshold   int                       // TODO Multisig Only
 	address            string                    // For address based accounts only
+	// keyOrigins are the origins of the extended public keys. An entry is nil if the origin of the
+	// key is unknown.
+	keyOrigins []*KeyOrigin
 }
 
-// NewConfiguration creates a new configuration. At the moment, multisig is a predefined
-// multisig-P2SH script, and is active if there are more than one xpubs. Otherwise, it's single sig
-// and `scriptType` defines the type of script.
+// NewConfiguration creates a new configuration. Multisig is active if there are more than one
+// xpubs, in which case `scriptType` defines how the sortedmulti script is wrapped (P2SH for
+// ScriptTypeP2PKH, P2WSH or P2WSH-P2SH). Otherwise, it's single sig and `scriptType` defines the
+// type of script.
 func NewConfiguration(
 	scriptType ScriptType,
 	absoluteKeypath AbsoluteKeypath,
@@ -84,11 +107,33 @@ func NewAddressConfiguration(
 		scriptType, absoluteKeypath, []*hdkeychain.ExtendedKey{}, address, 1)
 }
 
-// ScriptType returns the configuration's keypath.
-func (configuration *Configuration) ScriptType() ScriptType {
-	if configuration.Multisig() {
-		panic("scriptType is only defined for single sig")
+// WithKeyOrigins returns a copy of the configuration with the given origins of the extended public
+// keys. An entry can be nil if the origin of the key is unknown.
+func (configuration *Configuration) WithKeyOrigins(keyOrigins []*KeyOrigin) *Configuration {
+	if len(keyOrigins) != configuration.NumberOfSigners() {
+		panic("There has to be one key origin per extended public key.")
 	}
+	for _, keyOrigin := range keyOrigins {
+		if keyOrigin != nil && len(keyOrigin.RootFingerprint) != 4 {
+			panic("A root fingerprint has to be 4 bytes long.")
+		}
+	}
+	result := *configuration
+	result.keyOrigins = keyOrigins
+	return &result
+}
+
+// KeyOrigins returns the origins of the extended public keys, one per key. An entry is nil if the
+// origin of the key is unknown.
+func (configuration *Configuration) KeyOrigins() []*KeyOrigin {
+	if configuration.keyOrigins == nil {
+		return make([]*KeyOrigin, configuration.NumberOfSigners())
+	}
+	return configuration.keyOrigins
+}
+
+// ScriptType returns the configuration's script type, see NewConfiguration().
+func (configuration *Configuration) ScriptType() ScriptType {
 	return configuration.scriptType
 }
 
@@ -171,21 +216,42 @@ func (configuration *Configuration) Derive(relativeKeypath RelativeKeypath) (*Co
 		}
 		derivedPublicKeys[index] = derivedPublicKey
 	}
+	var derivedKeyOrigins []*KeyOrigin
+	if configuration.keyOrigins != nil {
+		derivedKeyOrigins = make([]*KeyOrigin, len(configuration.keyOrigins))
+		for index, keyOrigin := range configuration.keyOrigins {
+			if keyOrigin != nil {
+				derivedKeyOrigins[index] = &KeyOrigin{
+					RootFingerprint: keyOrigin.RootFingerprint,
+					Keypath:         keyOrigin.Keypath.Append(relativeKeypath),
+				}
+			}
+		}
+	}
 	return &Configuration{
 		address:            configuration.address,
 		scriptType:         configuration.scriptType,
 		absoluteKeypath:    configuration.absoluteKeypath.Append(relativeKeypath),
 		extendedPublicKeys: derivedPublicKeys,
 		signingThreshold:   configuration.signingThreshold,
+		keyOrigins:         derivedKeyOrigins,
 	}, nil
 }
 
+type keyOriginEncoding struct {
+	RootFingerprint string          `json:"rootFingerprint"`
+	Keypath         AbsoluteKeypath `json:"keypath"`
+}
+
 type configurationEncoding struct {
 	ScriptType string          `json:"scriptType"`
 	Keypath    AbsoluteKeypath `json:"keypath"`
 	Threshold  int             `json:"threshold"`
 	Xpubs      []string        `json:"xpubs"`
 	Address    string          `json:"address"`
+	// KeyOrigins is omitted if no origin is known, so that the encoding of such configurations
+	// stays the same.
+	KeyOrigins []*keyOriginEncoding `json:"keyOrigins,omitempty"`
 }
 
 // MarshalJSON implements json.Marshaler.
@@ -195,12 +261,26 @@ func (configuration Configuration) MarshalJSON() ([]byte, error) {
 	for i := 0; i < length; i++ {
 		xpubs[i] = configuration.extendedPublicKeys[i].String()
 	}
+	var keyOrigins []*keyOriginEncoding
+	for i, keyOrigin := range configuration.keyOrigins {
+		if keyOrigin == nil {
+			continue
+		}
+		if keyOrigins == nil {
+			keyOrigins = make([]*keyOriginEncoding, len(configuration.keyOrigins))
+		}
+		keyOrigins[i] = &keyOriginEncoding{
+			RootFingerprint: hex.EncodeToString(keyOrigin.RootFingerprint),
+			Keypath:         keyOrigin.Keypath,
+		}
+	}
 	return json.Marshal(&configurationEncoding{
 		ScriptType: string(configuration.scriptType),
 		Keypath:    configuration.absoluteKeypath,
 		Threshold:  configuration.signingThreshold,
 		Xpubs:      xpubs,
 		Address:    configuration.address,
+		KeyOrigins: keyOrigins,
 	})
 }
 
@@ -223,20 +303,43 @@ func (configuration *Configuration) UnmarshalJSON(bytes []byte) error {
 			return errp.Wrap(err, "Could not read an extended public key.")
 		}
 	}
+	configuration.keyOrigins = nil
+	if encoding.KeyOrigins != nil {
+		if len(encoding.KeyOrigins) != length {
+			return errp.New("The number of key origins does not match the number of extended public keys.")
+		}
+		configuration.keyOrigins = make([]*KeyOrigin, length)
+		for i, keyOrigin := range encoding.KeyOrigins {
+			if keyOrigin == nil {
+				continue
+			}
+			rootFingerprint, err := hex.DecodeString(keyOrigin.RootFingerprint)
+			if err != nil || len(rootFingerprint) != 4 {
+				return errp.New("Could not read a root fingerprint.")
+			}
+			configuration.keyOrigins[i] = &KeyOrigin{
+				RootFingerprint: rootFingerprint,
+				Keypath:         keyOrigin.Keypath,
+			}
+		}
+	}
 	return nil
 }
 
-// Hash returns a hash of the configuration.
+// Hash returns a hash of the configuration. The key origins are not part of the hash, so that it
+// identifies the same account whether the origins are known or not.
 func (configuration *Configuration) Hash() string {
-	hash := sha256.Sum256(jsonp.MustMarshal(configuration))
+	withoutKeyOrigins := *configuration
+	withoutKeyOrigins.keyOrigins = nil
+	hash := sha256.Sum256(jsonp.MustMarshal(withoutKeyOrigins))
 	return hex.EncodeToString(hash[:])
 }
 
 // String returns a short summary of the configuration to be used in logs, etc.
 func (configuration *Configuration) String() string {
 	if configuration.Multisig() {
-		return fmt.Sprintf("multisig, %d/%d",
-			configuration.SigningThreshold(), configuration.NumberOfSigners())
+		return fmt.Sprintf("multisig, %d/%d, scriptType: %s",
+			configuration.SigningThreshold(), configuration.NumberOfSigners(), configuration.scriptType)
 	}
 	return fmt.Sprintf("single sig, scriptType: %s", configuration.scriptType)
 }
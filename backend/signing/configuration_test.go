@@ -0,0 +1,62 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigurationKeyOrigins(t *testing.T) {
+	master, err := hdkeychain.NewMaster(make([]byte, hdkeychain.RecommendedSeedLen), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	rootFingerprint, err := signing.RootFingerprint(master)
+	require.NoError(t, err)
+	require.Len(t, rootFingerprint, 4)
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/1'/0'")
+	require.NoError(t, err)
+	xprv, err := keypath.Derive(master)
+	require.NoError(t, err)
+	xpub, err := xprv.Neuter()
+	require.NoError(t, err)
+
+	configuration := signing.NewSinglesigConfiguration(signing.ScriptTypeP2WPKH, keypath, xpub)
+	require.Equal(t, []*signing.KeyOrigin{nil}, configuration.KeyOrigins())
+	withKeyOrigins := configuration.WithKeyOrigins(
+		[]*signing.KeyOrigin{{RootFingerprint: rootFingerprint, Keypath: keypath}})
+	// The origins don't change the identity of the configuration.
+	require.Equal(t, configuration.Hash(), withKeyOrigins.Hash())
+
+	encoded, err := json.Marshal(withKeyOrigins)
+	require.NoError(t, err)
+	var decoded signing.Configuration
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, withKeyOrigins.KeyOrigins(), decoded.KeyOrigins())
+
+	// Configurations without origins are encoded as before.
+	encoded, err = json.Marshal(configuration)
+	require.NoError(t, err)
+	require.NotContains(t, string(encoded), "keyOrigins")
+
+	derived, err := withKeyOrigins.Derive(signing.NewEmptyRelativeKeypath().Child(1, false))
+	require.NoError(t, err)
+	require.Equal(t, rootFingerprint, derived.KeyOrigins()[0].RootFingerprint)
+	require.Equal(t, "m/84'/1'/0'/1", derived.KeyOrigins()[0].Keypath.Encode())
+}
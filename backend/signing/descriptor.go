@@ -0,0 +1,364 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// Output descriptors describe the set of output scripts of an account, see
+// https://github.com/bitcoin/bips/blob/master/bip-0380.mediawiki.
+
+const (
+	descriptorInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	descriptorChecksumLength  = 8
+
+	// descriptorKeySuffix derives the receive (0) and change (1) chains of an account (BIP389).
+	descriptorKeySuffix = "/<0;1>/*"
+)
+
+func descriptorPolymod(c uint64, value int) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ uint64(value)
+	for i, generator := range []uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd} {
+		if (c0>>uint(i))&1 != 0 {
+			c ^= generator
+		}
+	}
+	return c
+}
+
+// DescriptorChecksum computes the checksum of the given descriptor (without checksum).
+func DescriptorChecksum(descriptor string) (string, error) {
+	c := uint64(1)
+	class := 0
+	classCount := 0
+	for _, char := range descriptor {
+		position := strings.IndexRune(descriptorInputCharset, char)
+		if position == -1 {
+			return "", errp.Newf("invalid character in descriptor: %q", char)
+		}
+		c = descriptorPolymod(c, position&31)
+		class = class*3 + position>>5
+		classCount++
+		if classCount == 3 {
+			c = descriptorPolymod(c, class)
+			class = 0
+			classCount = 0
+		}
+	}
+	if classCount > 0 {
+		c = descriptorPolymod(c, class)
+	}
+	for i := 0; i < descriptorChecksumLength; i++ {
+		c = descriptorPolymod(c, 0)
+	}
+	c ^= 1
+	checksum := make([]byte, descriptorChecksumLength)
+	for i := range checksum {
+		checksum[i] = descriptorChecksumCharset[(c>>uint(5*(descriptorChecksumLength-1-i)))&31]
+	}
+	return string(checksum), nil
+}
+
+// addDescriptorChecksum returns the descriptor followed by `#` and its checksum.
+func addDescriptorChecksum(descriptor string) (string, error) {
+	checksum, err := DescriptorChecksum(descriptor)
+	if err != nil {
+		return "", err
+	}
+	return descriptor + "#" + checksum, nil
+}
+
+// descriptorKey encodes an extended public key with its origin and the derivation of the receive
+// and change chains, e.g. `[d34db33f/84h/0h/0h]xpub.../<0;1>/*`. The origin is left out if it is
+// unknown.
+func descriptorKey(
+	extendedPublicKey *hdkeychain.ExtendedKey,
+	keyOrigin *KeyOrigin,
+	net *chaincfg.Params,
+) (string, error) {
+	// Descriptors use the xpub/tpub version bytes regardless of the script type.
+	key, err := hdkeychain.NewKeyFromString(extendedPublicKey.String())
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	key.SetNet(net)
+	origin := ""
+	if keyOrigin != nil {
+		origin = "[" + hex.EncodeToString(keyOrigin.RootFingerprint)
+		if len(keyOrigin.Keypath) != 0 {
+			origin += "/" + strings.Replace(keypath(keyOrigin.Keypath).encode(), hardenedKeySymbol, "h", -1)
+		}
+		origin += "]"
+	}
+	return origin + key.String() + descriptorKeySuffix, nil
+}
+
+// Descriptor returns the output descriptor with checksum describing the addresses of this
+// configuration. The extended keys are encoded with the version bytes of the given net.
+func (configuration *Configuration) Descriptor(net *chaincfg.Params) (string, error) {
+	if configuration.IsAddressBased() {
+		return addDescriptorChecksum(fmt.Sprintf("addr(%s)", configuration.address))
+	}
+	keyOrigins := configuration.KeyOrigins()
+	keys := make([]string, len(configuration.extendedPublicKeys))
+	for i, extendedPublicKey := range configuration.extendedPublicKeys {
+		key, err := descriptorKey(extendedPublicKey, keyOrigins[i], net)
+		if err != nil {
+			return "", err
+		}
+		keys[i] = key
+	}
+	if configuration.Multisig() {
+		multi := fmt.Sprintf("sortedmulti(%d,%s)", configuration.signingThreshold, strings.Join(keys, ","))
+		switch configuration.scriptType {
+		case ScriptTypeP2PKH:
+			return addDescriptorChecksum(fmt.Sprintf("sh(%s)", multi))
+		case ScriptTypeP2WSHP2SH:
+			return addDescriptorChecksum(fmt.Sprintf("sh(wsh(%s))", multi))
+		case ScriptTypeP2WSH:
+			return addDescriptorChecksum(fmt.Sprintf("wsh(%s)", multi))
+		default:
+			return "", errp.Newf("unsupported multisig script type %s", configuration.scriptType)
+		}
+	}
+	switch configuration.scriptType {
+	case ScriptTypeP2PKH:
+		return addDescriptorChecksum(fmt.Sprintf("pkh(%s)", keys[0]))
+	case ScriptTypeP2WPKHP2SH:
+		return addDescriptorChecksum(fmt.Sprintf("sh(wpkh(%s))", keys[0]))
+	case ScriptTypeP2WPKH:
+		return addDescriptorChecksum(fmt.Sprintf("wpkh(%s)", keys[0]))
+	case ScriptTypeP2TR:
+		return addDescriptorChecksum(fmt.Sprintf("tr(%s)", keys[0]))
+	default:
+		return "", errp.Newf("unsupported script type %s", configuration.scriptType)
+	}
+}
+
+// unwrapDescriptor returns the argument of `function(argument)`, or false if the descriptor is not
+// of this form.
+func unwrapDescriptor(descriptor string, function string) (string, bool) {
+	if !strings.HasPrefix(descriptor, function+"(") || !strings.HasSuffix(descriptor, ")") {
+		return "", false
+	}
+	return descriptor[len(function)+1 : len(descriptor)-1], true
+}
+
+// splitKeyOrigin parses the origin of a key, e.g. `[d34db33f/48h/0h/0h/2h]xpub...`, and returns it
+// with the rest of the key. The origin is nil if the key has none.
+func splitKeyOrigin(key string) (*KeyOrigin, string, error) {
+	if !strings.HasPrefix(key, "[") {
+		return nil, key, nil
+	}
+	end := strings.Index(key, "]")
+	if end == -1 {
+		return nil, "", errp.New("invalid key origin")
+	}
+	origin := strings.SplitN(key[1:end], "/", 2)
+	rootFingerprint, err := hex.DecodeString(origin[0])
+	if err != nil || len(rootFingerprint) != 4 {
+		return nil, "", errp.New("the fingerprint in the key origin must be 8 hex characters")
+	}
+	keyOrigin := &KeyOrigin{RootFingerprint: rootFingerprint, Keypath: NewEmptyAbsoluteKeypath()}
+	if len(origin) == 2 {
+		path, err := newKeypath(strings.NewReplacer("h", hardenedKeySymbol, "H", hardenedKeySymbol).
+			Replace(origin[1]))
+		if err != nil {
+			return nil, "", err
+		}
+		keyOrigin.Keypath = AbsoluteKeypath(path)
+	}
+	return keyOrigin, key[end+1:], nil
+}
+
+// ParseKeyWithOrigin parses an extended public key with its origin, e.g.
+// `[d34db33f/48h/0h/0h/2h]xpub...`, as exported by other wallets. The origin is required and its
+// keypath must match the depth of the key.
+func ParseKeyWithOrigin(key string) (*KeyOrigin, *hdkeychain.ExtendedKey, error) {
+	keyOrigin, key, err := splitKeyOrigin(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyOrigin == nil {
+		return nil, nil, errp.New("the key origin [fingerprint/keypath] is missing")
+	}
+	extendedPublicKey, err := hdkeychain.NewKeyFromString(key)
+	if err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	if extendedPublicKey.IsPrivate() {
+		return nil, nil, errp.New("private keys are not allowed")
+	}
+	if int(extendedPublicKey.Depth()) != len(keyOrigin.Keypath) {
+		return nil, nil, errp.New("the keypath of the key origin does not match the depth of the key")
+	}
+	return keyOrigin, extendedPublicKey, nil
+}
+
+// parseDescriptorKey parses a key encoded by descriptorKey() and returns its origin, which is nil if
+// the key has none, and its extended public key, which must belong to the given net.
+func parseDescriptorKey(key string, net *chaincfg.Params) (*KeyOrigin, *hdkeychain.ExtendedKey, error) {
+	keyOrigin, key, err := splitKeyOrigin(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasSuffix(key, descriptorKeySuffix) {
+		return nil, nil, errp.Newf("keys must end with %s", descriptorKeySuffix)
+	}
+	extendedPublicKey, err := hdkeychain.NewKeyFromString(strings.TrimSuffix(key, descriptorKeySuffix))
+	if err != nil {
+		return nil, nil, errp.WithStack(err)
+	}
+	if extendedPublicKey.IsPrivate() {
+		return nil, nil, errp.New("private keys are not allowed in descriptors")
+	}
+	if !extendedPublicKey.IsForNet(net) {
+		return nil, nil, errp.New("the key belongs to a different network")
+	}
+	return keyOrigin, extendedPublicKey, nil
+}
+
+// parseSortedMulti parses the arguments of `sortedmulti(threshold,keys...)`.
+func parseSortedMulti(multi string) (int, []string, error) {
+	args := strings.Split(multi, ",")
+	threshold, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, nil, errp.WithStack(err)
+	}
+	keys := args[1:]
+	if len(keys) < 2 || threshold < 1 || threshold > len(keys) {
+		return 0, nil, errp.New("invalid multisig threshold")
+	}
+	return threshold, keys, nil
+}
+
+// NewConfigurationFromDescriptor creates a configuration from an output descriptor as returned by
+// Descriptor(). Supported are addr(), pkh(), wpkh(), sh(wpkh()), tr() and sortedmulti() in sh(),
+// wsh() and sh(wsh()) with keys deriving both the receive and change chain. Keys must belong to the
+// given net. addressScriptType validates the address of addr() and returns its script type. The
+// checksum is optional, but verified if present.
+func NewConfigurationFromDescriptor(
+	descriptor string,
+	net *chaincfg.Params,
+	addressScriptType func(address string) (ScriptType, error),
+) (*Configuration, error) {
+	descriptor = strings.TrimSpace(descriptor)
+	if index := strings.LastIndex(descriptor, "#"); index != -1 {
+		checksum, err := DescriptorChecksum(descriptor[:index])
+		if err != nil {
+			return nil, err
+		}
+		if checksum != descriptor[index+1:] {
+			return nil, errp.New("invalid descriptor checksum")
+		}
+		descriptor = descriptor[:index]
+	}
+
+	if address, ok := unwrapDescriptor(descriptor, "addr"); ok {
+		scriptType, err := addressScriptType(address)
+		if err != nil {
+			return nil, err
+		}
+		return NewAddressConfiguration(scriptType, NewEmptyAbsoluteKeypath(), address), nil
+	}
+
+	scriptType := ScriptTypeP2PKH
+	threshold := 1
+	var keys []string
+	if inner, ok := unwrapDescriptor(descriptor, "sh"); ok {
+		if key, ok := unwrapDescriptor(inner, "wpkh"); ok {
+			scriptType = ScriptTypeP2WPKHP2SH
+			keys = []string{key}
+		} else if multi, ok := unwrapDescriptor(inner, "sortedmulti"); ok {
+			var err error
+			threshold, keys, err = parseSortedMulti(multi)
+			if err != nil {
+				return nil, err
+			}
+		} else if witnessScript, ok := unwrapDescriptor(inner, "wsh"); ok {
+			multi, ok := unwrapDescriptor(witnessScript, "sortedmulti")
+			if !ok {
+				return nil, errp.New("unsupported script in sh(wsh())")
+			}
+			var err error
+			threshold, keys, err = parseSortedMulti(multi)
+			if err != nil {
+				return nil, err
+			}
+			scriptType = ScriptTypeP2WSHP2SH
+		} else {
+			return nil, errp.New("unsupported script in sh()")
+		}
+	} else if witnessScript, ok := unwrapDescriptor(descriptor, "wsh"); ok {
+		multi, ok := unwrapDescriptor(witnessScript, "sortedmulti")
+		if !ok {
+			return nil, errp.New("unsupported script in wsh()")
+		}
+		var err error
+		threshold, keys, err = parseSortedMulti(multi)
+		if err != nil {
+			return nil, err
+		}
+		scriptType = ScriptTypeP2WSH
+	} else if key, ok := unwrapDescriptor(descriptor, "wpkh"); ok {
+		scriptType = ScriptTypeP2WPKH
+		keys = []string{key}
+	} else if key, ok := unwrapDescriptor(descriptor, "tr"); ok {
+		if strings.Contains(key, ",") {
+			return nil, errp.New("taproot script trees are not supported")
+		}
+		scriptType = ScriptTypeP2TR
+		keys = []string{key}
+	} else if key, ok := unwrapDescriptor(descriptor, "pkh"); ok {
+		keys = []string{key}
+	} else {
+		return nil, errp.New("unsupported descriptor")
+	}
+
+	absoluteKeypath := NewEmptyAbsoluteKeypath()
+	extendedPublicKeys := make([]*hdkeychain.ExtendedKey, len(keys))
+	keyOrigins := make([]*KeyOrigin, len(keys))
+	for i, key := range keys {
+		keyOrigin, extendedPublicKey, err := parseDescriptorKey(key, net)
+		if err != nil {
+			return nil, err
+		}
+		keyKeypath := NewEmptyAbsoluteKeypath()
+		if keyOrigin != nil {
+			keyKeypath = keyOrigin.Keypath
+		}
+		if i > 0 && keyKeypath.Encode() != absoluteKeypath.Encode() {
+			return nil, errp.New("all keys must have the same keypath")
+		}
+		absoluteKeypath = keyKeypath
+		extendedPublicKeys[i] = extendedPublicKey
+		keyOrigins[i] = keyOrigin
+	}
+	return NewConfiguration(
+		scriptType, absoluteKeypath, extendedPublicKeys, "", threshold,
+	).WithKeyOrigins(keyOrigins), nil
+}
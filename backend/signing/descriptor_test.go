@@ -0,0 +1,240 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/stretchr/testify/require"
+)
+
+// noAddresses rejects all addresses of addr() descriptors.
+func noAddresses(string) (signing.ScriptType, error) {
+	return "", errp.New("no addresses expected")
+}
+
+// BIP84 test vector account key.
+const descriptorXPub = "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+
+func TestDescriptorChecksum(t *testing.T) {
+	checksum, err := signing.DescriptorChecksum("raw(deadbeef)")
+	require.NoError(t, err)
+	require.Equal(t, "89f8spxm", checksum)
+
+	_, err = signing.DescriptorChecksum("raw(deadbeef)\n")
+	require.Error(t, err)
+}
+
+func TestDescriptorSinglesig(t *testing.T) {
+	// Keys are exported with xpub version bytes, regardless of the SLIP-132 version (zpub).
+	zpub := "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	extendedPublicKey, err := hdkeychain.NewKeyFromString(zpub)
+	require.NoError(t, err)
+	keypath, err := signing.NewAbsoluteKeypath("m/84'/0'/0'")
+	require.NoError(t, err)
+	configuration := signing.NewSinglesigConfiguration(signing.ScriptTypeP2WPKH, keypath, extendedPublicKey)
+
+	// Without a known origin, the key origin is left out.
+	descriptor, err := configuration.Descriptor(&chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Regexp(t, `^wpkh\(`+descriptorXPub+`/<0;1>/\*\)#[a-z0-9]{8}$`, descriptor)
+
+	rootFingerprint := []byte{0xd3, 0x4d, 0xb3, 0x3f}
+	configuration = configuration.WithKeyOrigins(
+		[]*signing.KeyOrigin{{RootFingerprint: rootFingerprint, Keypath: keypath}})
+	descriptor, err = configuration.Descriptor(&chaincfg.MainNetParams)
+	require.NoError(t, err)
+	expected := "wpkh([d34db33f/84h/0h/0h]" + descriptorXPub + "/<0;1>/*)#ctq9jn8j"
+	require.Equal(t, expected, descriptor)
+
+	decoded, err := signing.NewConfigurationFromDescriptor(descriptor, &chaincfg.MainNetParams, noAddresses)
+	require.NoError(t, err)
+	require.Equal(t, signing.ScriptTypeP2WPKH, decoded.ScriptType())
+	require.Equal(t, keypath, decoded.AbsoluteKeypath())
+	require.Equal(t, descriptorXPub, decoded.ExtendedPublicKeys()[0].String())
+	require.Equal(t, rootFingerprint, decoded.KeyOrigins()[0].RootFingerprint)
+	require.Equal(t, keypath, decoded.KeyOrigins()[0].Keypath)
+	reencoded, err := decoded.Descriptor(&chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Equal(t, descriptor, reencoded)
+
+	// The fingerprint must be 8 hex characters.
+	for _, fingerprint := range []string{"d34db33", "d34db33f0", "g34db33f", ""} {
+		_, err = signing.NewConfigurationFromDescriptor(
+			"wpkh(["+fingerprint+"/84h/0h/0h]"+descriptorXPub+"/<0;1>/*)", &chaincfg.MainNetParams, noAddresses)
+		require.Error(t, err, fingerprint)
+	}
+
+	// Keys of a different network are rejected.
+	_, err = signing.NewConfigurationFromDescriptor(descriptor, &chaincfg.TestNet3Params, noAddresses)
+	require.Error(t, err)
+
+	// The checksum is optional.
+	decoded, err = signing.NewConfigurationFromDescriptor(
+		"sh(wpkh("+descriptorXPub+"/<0;1>/*))", &chaincfg.MainNetParams, noAddresses)
+	require.NoError(t, err)
+	require.Equal(t, signing.ScriptTypeP2WPKHP2SH, decoded.ScriptType())
+	require.Equal(t, signing.NewEmptyAbsoluteKeypath(), decoded.AbsoluteKeypath())
+
+	_, err = signing.NewConfigurationFromDescriptor(
+		expected[:len(expected)-1]+"w", &chaincfg.MainNetParams, noAddresses)
+	require.Error(t, err)
+	_, err = signing.NewConfigurationFromDescriptor(
+		"pkh("+descriptorXPub+"/0/*)", &chaincfg.MainNetParams, noAddresses)
+	require.Error(t, err)
+
+	// Taproot key path only descriptors.
+	decoded, err = signing.NewConfigurationFromDescriptor(
+		"tr("+descriptorXPub+"/<0;1>/*)", &chaincfg.MainNetParams, noAddresses)
+	require.NoError(t, err)
+	require.Equal(t, signing.ScriptTypeP2TR, decoded.ScriptType())
+	trDescriptor, err := decoded.Descriptor(&chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Regexp(t, `^tr\(`+descriptorXPub+`/<0;1>/\*\)#[a-z0-9]{8}$`, trDescriptor)
+	_, err = signing.NewConfigurationFromDescriptor(
+		"tr("+descriptorXPub+"/<0;1>/*,pk("+descriptorXPub+"/<0;1>/*))", &chaincfg.MainNetParams, noAddresses)
+	require.Error(t, err)
+}
+
+func TestDescriptorMultisig(t *testing.T) {
+	master, err := hdkeychain.NewMaster(make([]byte, 32), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	keypath, err := signing.NewAbsoluteKeypath("m/48'/1'/0'")
+	require.NoError(t, err)
+	extendedPublicKeys := []*hdkeychain.ExtendedKey{}
+	for i := uint32(0); i < 3; i++ {
+		child, err := master.Child(i)
+		require.NoError(t, err)
+		neutered, err := child.Neuter()
+		require.NoError(t, err)
+		extendedPublicKeys = append(extendedPublicKeys, neutered)
+	}
+	// keyOrigins returns distinct origins of the keys at the given keypath.
+	keyOrigins := func(keypath signing.AbsoluteKeypath) []*signing.KeyOrigin {
+		result := []*signing.KeyOrigin{}
+		for i := range extendedPublicKeys {
+			result = append(result, &signing.KeyOrigin{
+				RootFingerprint: []byte{0, 0, 0, byte(i + 1)},
+				Keypath:         keypath,
+			})
+		}
+		return result
+	}
+	configuration := signing.NewConfiguration(
+		signing.ScriptTypeP2PKH, keypath, extendedPublicKeys, "", 2).WithKeyOrigins(keyOrigins(keypath))
+
+	descriptor, err := configuration.Descriptor(&chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	require.Regexp(t, `^sh\(sortedmulti\(2,\[00000001/48h/1h/0h\]tpub.*,\[00000003/48h/1h/0h\]tpub.*\)\)#[a-z0-9]{8}$`, descriptor)
+
+	decoded, err := signing.NewConfigurationFromDescriptor(descriptor, &chaincfg.TestNet3Params, noAddresses)
+	require.NoError(t, err)
+	require.True(t, decoded.Multisig())
+	require.Equal(t, 2, decoded.SigningThreshold())
+	require.Equal(t, configuration.Hash(), decoded.Hash())
+	require.Equal(t, configuration.KeyOrigins(), decoded.KeyOrigins())
+
+	// Segwit multisig (BIP48 script types 1' and 2').
+	for _, test := range []struct {
+		scriptType signing.ScriptType
+		keypath    string
+		pattern    string
+	}{
+		{
+			scriptType: signing.ScriptTypeP2WSHP2SH,
+			keypath:    "m/48'/1'/0'/1'",
+			pattern:    `^sh\(wsh\(sortedmulti\(2,\[00000001/48h/1h/0h/1h\]tpub.*\)\)\)#[a-z0-9]{8}$`,
+		},
+		{
+			scriptType: signing.ScriptTypeP2WSH,
+			keypath:    "m/48'/1'/0'/2'",
+			pattern:    `^wsh\(sortedmulti\(2,\[00000001/48h/1h/0h/2h\]tpub.*\)\)#[a-z0-9]{8}$`,
+		},
+	} {
+		keypath, err := signing.NewAbsoluteKeypath(test.keypath)
+		require.NoError(t, err)
+		configuration := signing.NewConfiguration(
+			test.scriptType, keypath, extendedPublicKeys, "", 2).WithKeyOrigins(keyOrigins(keypath))
+		descriptor, err := configuration.Descriptor(&chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		require.Regexp(t, test.pattern, descriptor)
+		decoded, err := signing.NewConfigurationFromDescriptor(descriptor, &chaincfg.TestNet3Params, noAddresses)
+		require.NoError(t, err)
+		require.Equal(t, test.scriptType, decoded.ScriptType())
+		require.Equal(t, configuration.Hash(), decoded.Hash())
+	}
+	_, err = signing.NewConfigurationFromDescriptor(
+		"wsh(pkh("+descriptorXPub+"/<0;1>/*))", &chaincfg.MainNetParams, noAddresses)
+	require.Error(t, err)
+
+	// Private keys are rejected.
+	_, err = signing.NewConfigurationFromDescriptor(
+		"pkh("+master.String()+"/<0;1>/*)", &chaincfg.TestNet3Params, noAddresses)
+	require.Error(t, err)
+}
+
+func TestDescriptorAddress(t *testing.T) {
+	// addressScriptType supports the address types known to btcutil.
+	addressScriptType := func(address string) (signing.ScriptType, error) {
+		decoded, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
+		if err != nil {
+			return "", err
+		}
+		if !decoded.IsForNet(&chaincfg.MainNetParams) {
+			return "", errp.New("wrong network")
+		}
+		switch decoded.(type) {
+		case *btcutil.AddressPubKeyHash:
+			return signing.ScriptTypeP2PKH, nil
+		case *btcutil.AddressWitnessPubKeyHash:
+			return signing.ScriptTypeP2WPKH, nil
+		default:
+			return "", errp.New("unsupported address type")
+		}
+	}
+	for _, test := range []struct {
+		address    string
+		scriptType signing.ScriptType
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", signing.ScriptTypeP2PKH},
+		{"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", signing.ScriptTypeP2WPKH},
+	} {
+		configuration := signing.NewAddressConfiguration(
+			test.scriptType, signing.NewEmptyAbsoluteKeypath(), test.address)
+		descriptor, err := configuration.Descriptor(&chaincfg.MainNetParams)
+		require.NoError(t, err)
+		require.Regexp(t, `^addr\(`+test.address+`\)#[a-z0-9]{8}$`, descriptor)
+		decoded, err := signing.NewConfigurationFromDescriptor(
+			descriptor, &chaincfg.MainNetParams, addressScriptType)
+		require.NoError(t, err)
+		require.True(t, decoded.IsAddressBased())
+		require.Equal(t, test.address, decoded.Address())
+		require.Equal(t, test.scriptType, decoded.ScriptType())
+		require.Equal(t, configuration.Hash(), decoded.Hash())
+	}
+
+	// Invalid addresses are rejected.
+	_, err := signing.NewConfigurationFromDescriptor(
+		"addr(3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy)", &chaincfg.MainNetParams, addressScriptType)
+	require.Error(t, err)
+	_, err = signing.NewConfigurationFromDescriptor(
+		"addr(invalid)", &chaincfg.MainNetParams, addressScriptType)
+	require.Error(t, err)
+}
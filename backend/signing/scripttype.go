@@ -16,7 +16,8 @@ package signing
 
 import "github.com/digitalbitbox/bitbox-wallet-app/util/errp"
 
-// ScriptType indicates which type of output should be produced in case of singlesig.
+// ScriptType indicates which type of output should be produced. In case of multisig,
+// ScriptTypeP2PKH stands for a sortedmulti script in a bare P2SH output.
 type ScriptType string
 
 const (
@@ -28,6 +29,15 @@ const (
 
 	// ScriptTypeP2WPKH is a segwit PayToPubKeyHash output.
 	ScriptTypeP2WPKH ScriptType = "p2wpkh"
+
+	// ScriptTypeP2TR is a taproot output spendable by the key path, see BIP86.
+	ScriptTypeP2TR ScriptType = "p2tr"
+
+	// ScriptTypeP2WSHP2SH is a segwit multisig output wrapped in p2sh (BIP48 script type 1').
+	ScriptTypeP2WSHP2SH ScriptType = "p2wsh-p2sh"
+
+	// ScriptTypeP2WSH is a segwit multisig output (BIP48 script type 2').
+	ScriptTypeP2WSH ScriptType = "p2wsh"
 )
 
 // DecodeScriptType decodes the given script type or returns an error.
@@ -39,6 +49,12 @@ func DecodeScriptType(scriptType string) (ScriptType, error) {
 		return ScriptTypeP2WPKHP2SH, nil
 	case "p2wpkh":
 		return ScriptTypeP2WPKH, nil
+	case "p2tr":
+		return ScriptTypeP2TR, nil
+	case "p2wsh-p2sh":
+		return ScriptTypeP2WSHP2SH, nil
+	case "p2wsh":
+		return ScriptTypeP2WSH, nil
 	default:
 		return "", errp.Newf("The given script type %s is unknown.", scriptType)
 	}
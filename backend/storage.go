@@ -0,0 +1,390 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/arguments"
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/signing"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/atomicfile"
+	utilConfig "github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// encryptedCopySuffix is appended to the filenames of the encrypted copies written while the stored
+// data is migrated to a new key, followed by the id of the key.
+const encryptedCopySuffix = ".encrypted-"
+
+func labelsFilename(arguments *arguments.Arguments) string {
+	return filepath.Join(arguments.MainDirectoryPath(), "labels.jsonl")
+}
+
+func notifierFilename(arguments *arguments.Arguments) string {
+	return filepath.Join(arguments.MainDirectoryPath(), "notifier.db")
+}
+
+func ratesHistoryFilename(arguments *arguments.Arguments) string {
+	return filepath.Join(arguments.CacheDirectoryPath(), "rates-history.json")
+}
+
+func keyringFilename(arguments *arguments.Arguments) string {
+	return filepath.Join(arguments.MainDirectoryPath(), "encryption.json")
+}
+
+// migratedFilenames returns the files which are encrypted when the storage encryption is enabled.
+func migratedFilenames(arguments *arguments.Arguments) []string {
+	return []string{
+		arguments.AccountsConfigFilename(),
+		labelsFilename(arguments),
+		ratesHistoryFilename(arguments),
+		notifierFilename(arguments),
+	}
+}
+
+// encryptedFilenames returns the files which are encrypted as a whole, including the config files
+// which were encrypted when they were accessed.
+func encryptedFilenames(arguments *arguments.Arguments) ([]string, error) {
+	result := []string{}
+	err := filepath.Walk(arguments.MainDirectoryPath(), func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.Contains(filepath.Base(filename), encryptedCopySuffix) {
+			return nil
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		header := make([]byte, 16)
+		n, err := io.ReadFull(file, header)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if encryption.IsEncrypted(header[:n]) {
+			result = append(result, filename)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return result, nil
+}
+
+func encryptedCopyFilename(filename string, key *encryption.Key) string {
+	return filename + encryptedCopySuffix + key.ID()
+}
+
+// writeEncryptedCopy writes the file, encrypted with oldKey or unencrypted if oldKey is nil,
+// encrypted with the key next to it. Nothing is written if the file does not exist.
+func writeEncryptedCopy(oldKey *encryption.Key, key *encryption.Key, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	data, err = oldKey.Decrypt(data)
+	if err != nil {
+		return err
+	}
+	encrypted, err := key.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(encryptedCopyFilename(filename, key), encrypted, 0600)
+}
+
+// finishStorageMigration replaces the migrated files by their copies encrypted with the key with
+// the given id, which is the key in the keyring, and removes the copies encrypted with any other
+// key. Called on startup before the files are opened, to complete or roll back an interrupted
+// migration, see migrateStorage().
+func finishStorageMigration(arguments *arguments.Arguments, keyID string) error {
+	err := filepath.Walk(arguments.MainDirectoryPath(), func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		index := strings.LastIndex(filename, encryptedCopySuffix)
+		if info.IsDir() || index == -1 || strings.ContainsRune(filename[index:], filepath.Separator) {
+			return nil
+		}
+		if keyID != "" && filename[index+len(encryptedCopySuffix):] == keyID {
+			return os.Rename(filename, filename[:index])
+		}
+		return os.Remove(filename)
+	})
+	return errp.WithStack(err)
+}
+
+// keystoreSecretKeypath is the keypath of the private key from which the storage secret is derived
+// if the storage is encrypted with the keystore. It is not used by any account.
+const keystoreSecretKeypath = "m/84'/0'/99'"
+
+// keystoreSecretChallenge is the challenge from which the keystore derives the storage secret, see
+// keystore.Keystore.DeriveSecret().
+const keystoreSecretChallenge = "BitBoxApp storage encryption"
+
+// StorageStatus is the status of the encryption of the stored data.
+type StorageStatus struct {
+	Encrypted bool              `json:"encrypted"`
+	Locked    bool              `json:"locked"`
+	Source    encryption.Source `json:"source"`
+}
+
+// StorageStatus returns the status of the encryption of the stored data.
+func (backend *Backend) StorageStatus() StorageStatus {
+	return StorageStatus{
+		Encrypted: backend.keyring.Enabled(),
+		Locked:    backend.storageLocked(),
+		Source:    backend.keyring.Source(),
+	}
+}
+
+// storageLocked returns true if the storage is encrypted and the key is not known yet. Accounts
+// are not loaded while the storage is locked.
+func (backend *Backend) storageLocked() bool {
+	defer backend.storageKeyLock.RLock()()
+	return backend.keyring.Enabled() && backend.storageKey == nil
+}
+
+func (backend *Backend) getStorageKey() *encryption.Key {
+	defer backend.storageKeyLock.RLock()()
+	return backend.storageKey
+}
+
+// keystoreSecret returns the secret derived from a private key of the registered keystore, which
+// is never exported, unlike the public keys.
+func (backend *Backend) keystoreSecret() ([]byte, error) {
+	if backend.keystores.Count() == 0 {
+		return nil, errp.New("No keystore is connected")
+	}
+	keypath, err := signing.NewAbsoluteKeypath(keystoreSecretKeypath)
+	if err != nil {
+		return nil, err
+	}
+	return backend.keystores.Keystores()[0].DeriveSecret(keypath, []byte(keystoreSecretChallenge))
+}
+
+// storageSecret returns the keystore secret for encryption.SourceKeystore, and the passphrase
+// otherwise.
+func (backend *Backend) storageSecret(source encryption.Source, passphrase string) ([]byte, error) {
+	switch source {
+	case encryption.SourceKeystore:
+		return backend.keystoreSecret()
+	case encryption.SourcePassphrase:
+		return []byte(passphrase), nil
+	default:
+		return nil, errp.Newf("Unknown encryption source %s", source)
+	}
+}
+
+// setStorageKey makes all stores use the key.
+func (backend *Backend) setStorageKey(key *encryption.Key) error {
+	if err := backend.config.SetEncryptionKey(key); err != nil {
+		return err
+	}
+	utilConfig.SetEncryptionKey(key)
+	backend.notifier.setEncryptionKey(key)
+	if err := backend.labels.SetEncryptionKey(key); err != nil {
+		return err
+	}
+	if err := backend.ratesUpdater.SetEncryptionKey(key); err != nil {
+		return err
+	}
+	defer backend.storageKeyLock.Lock()()
+	backend.storageKey = key
+	return nil
+}
+
+func (backend *Backend) emitStorageStatusChanged() {
+	backend.events <- backendEvent{Type: "backend", Data: "storageStatusChanged"}
+}
+
+// UnlockStorage decrypts the storage key with the passphrase and loads the accounts.
+func (backend *Backend) UnlockStorage(passphrase string) error {
+	if !backend.storageLocked() {
+		return errp.New("The storage is not locked")
+	}
+	secret, err := backend.storageSecret(backend.keyring.Source(), passphrase)
+	if err != nil {
+		return err
+	}
+	key, err := backend.keyring.Unlock(secret)
+	if err != nil {
+		return err
+	}
+	if err := backend.setStorageKey(key); err != nil {
+		return err
+	}
+	backend.log.Info("Unlocked the storage")
+	backend.emitStorageStatusChanged()
+	backend.initAccounts()
+	return nil
+}
+
+// migrateStorage encrypts the stored data with the key instead of oldKey, which is nil if the data
+// is not encrypted yet. The files in filenames are copied, encrypted with the key, and commit is
+// called to write the keyring, after which the copies replace the files. If the migration is
+// interrupted before the keyring is written, nothing changes. If it is interrupted after, it is
+// completed on the next start, see finishStorageMigration(). The account databases only contain
+// data which can be fetched again, so they are removed and rebuilt with the key.
+func (backend *Backend) migrateStorage(
+	oldKey *encryption.Key, key *encryption.Key, filenames []string, commit func() error) error {
+	backend.uninitAccounts()
+	abort := func(err error) error {
+		if cleanupErr := finishStorageMigration(backend.arguments, backend.keyring.KeyID()); cleanupErr != nil {
+			backend.log.WithError(cleanupErr).Error("Could not remove the encrypted copies")
+		}
+		backend.initAccounts()
+		return err
+	}
+	for _, filename := range filenames {
+		var err error
+		if filename == notifierFilename(backend.arguments) {
+			err = backend.notifier.encryptTo(oldKey, key, encryptedCopyFilename(filename, key))
+		} else {
+			err = writeEncryptedCopy(oldKey, key, filename)
+		}
+		if err != nil {
+			return abort(err)
+		}
+	}
+	// Removed before the keyring is written, so an account database is never used with the wrong
+	// key.
+	dbFiles, err := filepath.Glob(filepath.Join(backend.arguments.CacheDirectoryPath(), "account-*.db"))
+	if err != nil {
+		return abort(errp.WithStack(err))
+	}
+	for _, dbFile := range dbFiles {
+		if err := os.Remove(dbFile); err != nil {
+			return abort(errp.WithStack(err))
+		}
+	}
+	if err := commit(); err != nil {
+		return abort(err)
+	}
+	err = backend.notifier.reopen(func() error {
+		return finishStorageMigration(backend.arguments, key.ID())
+	})
+	if err != nil {
+		return err
+	}
+	if err := backend.setStorageKey(key); err != nil {
+		return err
+	}
+	backend.emitStorageStatusChanged()
+	backend.initAccounts()
+	return nil
+}
+
+// EnableStorageEncryption encrypts the stored data with a new key, protected by the passphrase or
+// by the registered keystore. The accounts config, the labels, the historical rates and the
+// notifier db are migrated right away, the other config files the next time they are accessed.
+func (backend *Backend) EnableStorageEncryption(source encryption.Source, passphrase string) error {
+	if backend.keyring.Enabled() {
+		return errp.New("The storage is already encrypted")
+	}
+	secret, err := backend.storageSecret(source, passphrase)
+	if err != nil {
+		return err
+	}
+	key, err := encryption.NewKey()
+	if err != nil {
+		return err
+	}
+	err = backend.migrateStorage(nil, key, migratedFilenames(backend.arguments), func() error {
+		return backend.keyring.Enable(key, source, secret)
+	})
+	if err != nil {
+		return err
+	}
+	backend.log.Info("Enabled the storage encryption")
+	return nil
+}
+
+// ChangeStorageSecret encrypts the stored data with a new key, protected by a new passphrase or by
+// the registered keystore, so neither the old secret nor a copy of the old keyring can decrypt the
+// data anymore. oldPassphrase is only needed if the storage is protected by a passphrase.
+func (backend *Backend) ChangeStorageSecret(
+	oldPassphrase string, source encryption.Source, newPassphrase string) error {
+	if !backend.keyring.Enabled() {
+		return errp.New("The storage is not encrypted")
+	}
+	if backend.storageLocked() {
+		return errp.New("The storage is locked")
+	}
+	oldSecret, err := backend.storageSecret(backend.keyring.Source(), oldPassphrase)
+	if err != nil {
+		return err
+	}
+	oldKey, err := backend.keyring.Unlock(oldSecret)
+	if err != nil {
+		return err
+	}
+	newSecret, err := backend.storageSecret(source, newPassphrase)
+	if err != nil {
+		return err
+	}
+	key, err := encryption.NewKey()
+	if err != nil {
+		return err
+	}
+	filenames, err := encryptedFilenames(backend.arguments)
+	if err != nil {
+		return err
+	}
+	// The notifier db is encrypted record by record.
+	filenames = append(filenames, notifierFilename(backend.arguments))
+	err = backend.migrateStorage(oldKey, key, filenames, func() error {
+		return backend.keyring.Rotate(oldSecret, key, source, newSecret)
+	})
+	if err != nil {
+		return err
+	}
+	backend.log.Info("Changed the storage key and secret")
+	return nil
+}
+
+// unlockStorageWithKeystore unlocks the storage if it is protected by the keystore.
+func (backend *Backend) unlockStorageWithKeystore() {
+	if !backend.storageLocked() || backend.keyring.Source() != encryption.SourceKeystore {
+		return
+	}
+	secret, err := backend.keystoreSecret()
+	if err != nil {
+		backend.log.WithError(err).Error("Could not derive the storage secret from the keystore")
+		return
+	}
+	key, err := backend.keyring.Unlock(secret)
+	if err != nil {
+		backend.log.WithError(err).Error("Could not unlock the storage with the keystore")
+		return
+	}
+	if err := backend.setStorageKey(key); err != nil {
+		backend.log.WithError(err).Error("Could not unlock the storage with the keystore")
+		return
+	}
+	backend.log.Info("Unlocked the storage with the keystore")
+	backend.emitStorageStatusChanged()
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/backend/config"
+	utilConfig "github.com/digitalbitbox/bitbox-wallet-app/util/config"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeStorageSecret(t *testing.T) {
+	defer utilConfig.SetEncryptionKey(nil)
+	backend, _, _ := newDiscoveryBackend(t, nil)
+	require.Error(t, backend.ChangeStorageSecret("", encryption.SourcePassphrase, "passphrase"))
+	require.NoError(t, backend.EnableStorageEncryption(encryption.SourcePassphrase, "passphrase"))
+	oldKey := backend.getStorageKey()
+	require.Equal(t, oldKey.ID(), backend.keyring.KeyID())
+
+	// A config file encrypted after the encryption was enabled.
+	configFile := utilConfig.NewFile(backend.arguments.MainDirectoryPath(), "test.json")
+	require.NoError(t, configFile.WriteJSON("value"))
+	accountsConfig := backend.config.AccountsConfig()
+	accountsConfig.Settings = map[string]config.AccountSettings{"code": {Name: "Savings"}}
+	require.NoError(t, backend.config.SetAccountsConfig(accountsConfig))
+	notifier := backend.notifier.ForAccount("code")
+	require.NoError(t, notifier.Put([]byte("txid")))
+	require.NoError(t, notifier.MarkAllNotified())
+
+	require.Equal(t, encryption.ErrWrongSecret,
+		backend.ChangeStorageSecret("wrong", encryption.SourceKeystore, ""))
+	require.NoError(t, backend.ChangeStorageSecret("passphrase", encryption.SourceKeystore, ""))
+	key := backend.getStorageKey()
+	require.NotEqual(t, oldKey.ID(), key.ID())
+	require.Equal(t, key.ID(), backend.keyring.KeyID())
+	require.Equal(t, encryption.SourceKeystore, backend.keyring.Source())
+
+	// The keystore secret is not the xpub, but derived from the private key.
+	secret, err := backend.keystoreSecret()
+	require.NoError(t, err)
+	unlockedKey, err := backend.keyring.Unlock(secret)
+	require.NoError(t, err)
+	require.Equal(t, key, unlockedKey)
+
+	// The data is encrypted with the new key only.
+	for _, filename := range []string{configFile.Path(), backend.arguments.AccountsConfigFilename()} {
+		data, err := ioutil.ReadFile(filename)
+		require.NoError(t, err)
+		require.True(t, encryption.IsEncrypted(data))
+		_, err = oldKey.Decrypt(data)
+		require.Error(t, err)
+		_, err = key.Decrypt(data)
+		require.NoError(t, err)
+	}
+	var value string
+	require.NoError(t, configFile.ReadJSON(&value))
+	require.Equal(t, "value", value)
+	require.Equal(t, "Savings", backend.config.AccountsConfig().Settings["code"].Name)
+	// The seen transactions are still known.
+	notifier = backend.notifier.ForAccount("code")
+	require.NoError(t, notifier.Put([]byte("txid")))
+	unnotified, err := notifier.UnnotifiedCount()
+	require.NoError(t, err)
+	require.Equal(t, 0, unnotified)
+	require.NoError(t, notifier.Put([]byte("txid2")))
+	unnotified, err = notifier.UnnotifiedCount()
+	require.NoError(t, err)
+	require.Equal(t, 1, unnotified)
+	copies, err := filepath.Glob(filepath.Join(backend.arguments.MainDirectoryPath(), "*"+encryptedCopySuffix+"*"))
+	require.NoError(t, err)
+	require.Empty(t, copies)
+}
+
+func TestFinishStorageMigration(t *testing.T) {
+	backend, _, _ := newDiscoveryBackend(t, nil)
+	dir := backend.arguments.MainDirectoryPath()
+	for _, filename := range []string{"a.json.encrypted-1", "b.json.encrypted-2", "cache/c.json.encrypted-1"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, filename), []byte(filename), 0600))
+	}
+	// The copies encrypted with the key in the keyring replace the files, the others are removed.
+	require.NoError(t, finishStorageMigration(backend.arguments, "1"))
+	for filename, expected := range map[string]string{
+		"a.json":       "a.json.encrypted-1",
+		"cache/c.json": "cache/c.json.encrypted-1",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filename))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+	}
+	for _, pattern := range []string{"*", "cache/*"} {
+		copies, err := filepath.Glob(filepath.Join(dir, pattern+encryptedCopySuffix+"*"))
+		require.NoError(t, err)
+		require.Empty(t, copies)
+	}
+	_, err := os.Stat(filepath.Join(dir, "b.json"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -15,20 +15,48 @@
 package backend
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
 
+	"github.com/digitalbitbox/bitbox-wallet-app/util/config"
 	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
-	"github.com/digitalbitbox/bitbox-wallet-app/util/logging"
 	"github.com/digitalbitbox/bitbox02-api-go/util/semver"
+	"golang.org/x/crypto/openpgp"
 )
 
-const updateFileURL = "https://shiftcrypto.ch/updates/desktop.json"
+const (
+	updateFileURL = "https://shiftcrypto.ch/updates/desktop.json"
+	// updateFileSignatureURL is the detached signature of the update file, made with one of the
+	// releaseKeys.
+	updateFileSignatureURL = updateFileURL + ".asc"
+
+	// maxUpdateFileSize limits the size of the downloaded update file and signature.
+	maxUpdateFileSize = 1 << 20
+)
 
 var (
 	// Version of the backend as displayed to the user.
 	Version = semver.NewSemVer(4, 14, 1)
 )
 
+// UpdateArtifact is a release file which can be downloaded.
+type UpdateArtifact struct {
+	URL string `json:"url"`
+	// SHA256 is the hex encoded hash of the file.
+	SHA256 string `json:"sha256"`
+}
+
 // UpdateFile is retrieved from the server.
 type UpdateFile struct {
 	// CurrentVersion stores the current version and is not loaded from the server.
@@ -39,28 +67,102 @@ type UpdateFile struct {
 
 	// Description gives additional information on the release.
 	Description string `json:"description"`
+
+	// Artifacts are the release files by operating system, e.g. "windows", "darwin" or "linux".
+	// Optional.
+	Artifacts map[string]*UpdateArtifact `json:"artifacts,omitempty"`
+}
+
+// httpGet downloads the resource, which must not be larger than maxSize.
+func httpGet(client *http.Client, url string, maxSize int64) ([]byte, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return nil, errp.Newf("%s returned status %d", url, response.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, errp.Newf("%s is too large", url)
+	}
+	return body, nil
+}
+
+// releaseKeyRing parses the releaseKeys.
+func releaseKeyRing() (openpgp.EntityList, error) {
+	var keyRing openpgp.EntityList
+	for _, key := range releaseKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+		if err != nil {
+			return nil, errp.WithStack(err)
+		}
+		keyRing = append(keyRing, entities...)
+	}
+	return keyRing, nil
+}
+
+// verifyUpdateFile checks the signature of the update file, which can be ASCII armored or binary,
+// and parses it. Update files older than the current version are rejected, so that an old signed
+// update file can't be replayed to offer a vulnerable release.
+func verifyUpdateFile(
+	keyRing openpgp.EntityList,
+	updateFileBytes []byte,
+	signature []byte,
+	currentVersion *semver.SemVer,
+) (*UpdateFile, error) {
+	checkSignature := openpgp.CheckDetachedSignature
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		checkSignature = openpgp.CheckArmoredDetachedSignature
+	}
+	_, err := checkSignature(keyRing, bytes.NewReader(updateFileBytes), bytes.NewReader(signature))
+	if err != nil {
+		return nil, errp.WithMessage(errp.WithStack(err), "The signature of the update file is invalid")
+	}
+	var updateFile UpdateFile
+	if err := json.Unmarshal(updateFileBytes, &updateFile); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if updateFile.NewVersion == nil {
+		return nil, errp.New("The update file does not contain a version")
+	}
+	if !updateFile.NewVersion.AtLeast(currentVersion) {
+		return nil, errp.Newf("The update file version %s is older than the current version %s",
+			updateFile.NewVersion, currentVersion)
+	}
+	return &updateFile, nil
 }
 
 // CheckForUpdate checks whether a newer version of this application has been released.
 // It returns the retrieved update file if a newer version has been released and nil otherwise.
+// The update file is only accepted if it is signed by one of the release keys.
 func (backend *Backend) CheckForUpdate() (*UpdateFile, error) {
 	client, err := backend.socksProxy.GetHTTPClient()
 	if err != nil {
 		return nil, errp.WithStack(err)
 	}
 
-	response, err := client.Get(updateFileURL)
+	updateFileBytes, err := httpGet(client, updateFileURL, maxUpdateFileSize)
 	if err != nil {
-		return nil, errp.WithStack(err)
+		return nil, err
 	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
-
-	var updateFile UpdateFile
-	err = json.NewDecoder(response.Body).Decode(&updateFile)
+	signature, err := httpGet(client, updateFileSignatureURL, maxUpdateFileSize)
 	if err != nil {
-		return nil, errp.WithStack(err)
+		return nil, err
+	}
+	keyRing, err := releaseKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	updateFile, err := verifyUpdateFile(keyRing, updateFileBytes, signature, Version)
+	if err != nil {
+		return nil, err
 	}
 
 	if Version.AtLeast(updateFile.NewVersion) {
@@ -68,15 +170,88 @@ func (backend *Backend) CheckForUpdate() (*UpdateFile, error) {
 	}
 
 	updateFile.CurrentVersion = Version
-	return &updateFile, nil
+	return updateFile, nil
+}
+
+// downloadArtifact downloads the artifact to the given folder and checks its hash. Returns the path
+// of the downloaded file.
+func downloadArtifact(client *http.Client, artifact *UpdateArtifact, folder string) (string, error) {
+	artifactURL, err := url.Parse(artifact.URL)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	if artifactURL.Scheme != "https" {
+		return "", errp.Newf("The release file %s is not served over https", artifact.URL)
+	}
+	expectedHash, err := hex.DecodeString(artifact.SHA256)
+	if err != nil || len(expectedHash) != sha256.Size {
+		return "", errp.New("The update file contains an invalid hash of the release file")
+	}
+	name := path.Base(artifactURL.Path)
+	if name == "/" || name == "." || strings.HasPrefix(name, ".") {
+		return "", errp.Newf("Invalid release file name %s", name)
+	}
+
+	response, err := client.Get(artifact.URL)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return "", errp.Newf("%s returned status %d", artifact.URL, response.StatusCode)
+	}
+
+	filename := filepath.Join(folder, name)
+	// The file is only renamed to its final name once its hash is verified.
+	partFilename := filename + ".part"
+	file, err := os.Create(partFilename)
+	if err != nil {
+		return "", errp.WithStack(err)
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), response.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partFilename)
+		return "", errp.WithStack(err)
+	}
+	if !bytes.Equal(hasher.Sum(nil), expectedHash) {
+		_ = os.Remove(partFilename)
+		return "", errp.New("The hash of the downloaded release file does not match the update file")
+	}
+	if err := os.Rename(partFilename, filename); err != nil {
+		return "", errp.WithStack(err)
+	}
+	return filename, nil
 }
 
-// CheckForUpdateIgnoringErrors suppresses any errors that are triggered, for example, when offline.
-func (backend *Backend) CheckForUpdateIgnoringErrors() *UpdateFile {
+// DownloadUpdate downloads the release file of the new version for this operating system to the
+// downloads folder and verifies its hash against the signed update file. Returns the path of the
+// downloaded file.
+func (backend *Backend) DownloadUpdate() (string, error) {
 	updateFile, err := backend.CheckForUpdate()
 	if err != nil {
-		logging.Get().WithGroup("update").WithError(err).Warn("Check for update failed.")
-		return nil
+		return "", err
+	}
+	if updateFile == nil {
+		return "", errp.New("No update available")
+	}
+	artifact, ok := updateFile.Artifacts[runtime.GOOS]
+	if !ok {
+		return "", errp.Newf("No release file for %s", runtime.GOOS)
+	}
+	downloadsDir, err := config.DownloadsDir()
+	if err != nil {
+		return "", err
+	}
+	client, err := backend.socksProxy.GetHTTPClient()
+	if err != nil {
+		return "", errp.WithStack(err)
 	}
-	return updateFile
+	backend.log.Infof("Downloading the release file %s", artifact.URL)
+	return downloadArtifact(client, artifact, downloadsDir)
 }
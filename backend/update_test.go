@@ -0,0 +1,188 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/digitalbitbox/bitbox02-api-go/util/semver"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+)
+
+// TestReleaseKeys makes sure the compiled in keys match the keys in pgp/pubkeys.
+func TestReleaseKeys(t *testing.T) {
+	filenames, err := filepath.Glob("../pgp/pubkeys/*.asc")
+	require.NoError(t, err)
+	require.Len(t, releaseKeys, len(filenames))
+	for index, filename := range filenames {
+		key, err := ioutil.ReadFile(filename)
+		require.NoError(t, err)
+		require.Equal(t, string(key), releaseKeys[index])
+	}
+}
+
+// The test key has a signing primary key and a signing subkey. The fixtures were created with:
+// gpg --quick-gen-key "Test Release <test@example.com>" rsa2048 sign never
+// gpg --quick-add-key <fingerprint> rsa2048 sign never
+// gpg --armor --detach-sign desktop.json
+// gpg --detach-sign --default-key <subkey fingerprint>! desktop.json
+const testKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrSM6ABCADdlkjNgwMwVmkBwo8ZChEsIcFrH7QAX4pqCdnrMC89mxDVBGJI
+57eVIqkAby+PHCgHGG5Pfktk1wX8bf4rL7VqLq1niZOEAAxkN0D2Wai7sc12IhfI
+LRBWf0PVBI6UKjGGtILX+z5bq0o8Jnv4h+iizxoElaOwtQ4YX3IYERiUSJwgpaWT
+CBNHHCh6Cgp5s+fpTN4cEiz7NeyWfbFbGIBZILySjPfNg/tMT/sfSdP6joOaTkGG
+lRV1weYkd4uIOepYnbMrBTI/Sz8OU6LCuE1s7sqGlDcoanBWENSV2VsYdgxT5Unj
+lm2NnO1dwTzhoejGSYJx9wogEhb6N/PSkBofABEBAAG0H1Rlc3QgUmVsZWFzZSA8
+dGVzdEBleGFtcGxlLmNvbT6JAU4EEwEKADgWIQS7xofMS0bBZmFRaIIT4q189gsX
+WQUCatIzoAIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRAT4q189gsXWVOB
+B/96W4yfszvEJV+TlfmckJfNgtiLKiCOTjC5qE4OSRwcTB8KUbjwO7++7LGITSPq
+3ClIuOWe4Vy1v47lsXE948bBOsQr52+gUoUpzCF1DmoLZtiAwc67lDHkh6cEYlBl
+zg46vlYmsjR2Vyz4iVS4l0mD55qWMqnSDUhg4yCSVpeGAvjPM5w0ADZ+DJJWHprm
+aZbLYYe7wW3lTACX6QKwBg1uk9mIOWMjT64botO0TUBriwYJFd/RDi052egU5UR8
+8+5AqkHBuTRBUWYiAnXlCQrgduUrOPiMPQJGJqYNA+/Zc0MeFyVxe6XB0a+nAIOP
+9DnN+RAvWA1SXgZ5AP7zsIWmuQENBGrSM6EBCADYL7fNR/NKTwMGAagjQJT0qz/M
+IP4tioCX9VUZE8WD/k756syWCPZ2zef2iFtuKCRCqPk7GaooDTsGjEAJjXpVWynE
+HMEWjM24Q4xzWFLYUePMLaCTLZY8AmC/EOMIydsZi8lBAkdmNEGBlv8IGVsC9nHn
+aNS2UlbMyoZ/I67mDwgo1GAu2vUCt35Sd+LlNnsFNgslGAgmiCWxIDfTU2XmxMpV
+JFRrQ1GmvrFg2O3t2iFdgipnZRwKYPz1UCrQiZZz4yTCFsOQgsLyE0ydwMgsSU5l
+tRhAGeDLBcvAAKYWz/ZboOP3uCI+zBgh8RsAAyxMSnCG/Ub0bC9HU70BEOzxABEB
+AAGJAmwEGAEKACAWIQS7xofMS0bBZmFRaIIT4q189gsXWQUCatIzoQIbAgFACRAT
+4q189gsXWcB0IAQZAQoAHRYhBEKrMBVKj9nrGRz96kvPC5ZWbFrEBQJq0jOhAAoJ
+EEvPC5ZWbFrEu+AH/0tJMpACvMs3L5s0hEHu+89sOHhcDrjz83VuHMt4jjMsMAYE
+GjsHMCfhFyckSLXlH9gub9KneQQxKG+icE8sBhmAlpDTixoFIhls74gT2fgfm8sX
+xbILueJVQFu+sThW+4305NE1+XTSvF/VJ/lLu6drvuxzJboHRLdwxZSuFScw91PB
+pBqwLslHxwbw9PFiSzuQWePOyRnaq3cVHUNZnQ0rNsw9wOIz0Bfu1fnMu+TkbFtZ
+pTD3orjyK0n8cnWqxrG5M1QZD6GBzegqR0naotpyNpMP7KcVQfgBPZFsEiOiULHs
+7FRyFsnW3ClWEXzNQyLJ3xAIn5T9KYvThzDcMMaxdQgAoI8FnY0/4bdza05sJQun
+CLOv0oLx0Sa0Ok+KA+1Fk3Iv96M263VyP1QdBl1FmWue4pZpNAkE2mgPxeQInIbd
+/IgL2aV0fSqTpRd2M7AP7crGQ482cYWDG5mod9PVZOKD1oPQFpI/uz1C6woQSzo1
+jRMNd3tMDwfZbygh079bIjaYOw/YwrjVcAwMCGth0/jpr0YeTCaz27JpVHE65WZ5
+9nKYflfrx/UwwtE+RWBHM9fRJzY2X5r/SRn0mUuYgV4iD4v0K4kkTn1wFL51i0Ap
+M8CKCwqPrGxR01OLNm8OG6FEgizigg1sjfEKHuiLe15jeanM/vvmT8bDo1pJFiVu
+ww==
+=rw8i
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+const testData = `{"version":"4.15.0","description":"test"}`
+
+// testSignature is made by the primary key.
+const testSignature = `-----BEGIN PGP SIGNATURE-----
+
+iQEzBAABCgAdFiEEQqswFUqP2esZHP3qS88LllZsWsQFAmrSM6EACgkQS88LllZs
+WsRk2Af8CigajkX4lAlTl+4l4TsHvPTPq/PboJl+ERcfsXRd3/tEiO743p0ep1Td
+1SujLgAICi4Kw0RhiH0ymy+DI3YEU0TrqUi8DSWvO+Yo8kwX2mufDUFyBR6Rbbk6
+bZEt6u+ylezsg5jpjKoQHe1p8EQt5bw7darNYVf8qup/Mdn+KVCRzDhZzrKxeuj/
+H6UHIF8IPudsR/GYBP0isD81SMq7keHj+3qqGXkdWVLJBgoR2THUwPvr/F+l8QNY
+WSV80e2BnG7fDQ12Zm1XpmFRR2Fu5c0zbsUsVbH6EkIHqdsx/qGJo2kIxR5QfNQg
+xBWVkozBpHtCn9EJ1GzonpRP/ZM9EQ==
+=K6Qm
+-----END PGP SIGNATURE-----
+`
+
+// testSubkeySignature is a binary signature made by the subkey.
+const testSubkeySignature = "8901330400010a001d16210442ab30154a8fd9eb191cfdea4bcf0b96566c5ac405026ad233a1000a09104bcf0b96566c" +
+	"5ac464d807fc0a281a8e45f894095397ee25e13b07bcf4cfabf3dba0997e11171fb1745ddffb4488eef8de9d1ea754dd" +
+	"d52ba32e00080a2e0ac34461887d329b2f832376045344eba948bc0d25af3be628f24c17da6b9f0d4172051e916db93a" +
+	"6d912deaefb295ecec8398e98caa101ded69f0442de5bc3b75aacd6157fcaaea7f31d9fe295091cc3859ceb2b17ae8ff" +
+	"1fa507205f083ee76c47f19804fd22b03f3548cabb91e1e3fb7aaa19791d5952c9060a11d931d4c0fbebfc5fa5f10358" +
+	"59257cd1ed819c6edf0d0d76666d57a6615147616ee5cd336ec52c55b1fa124207a9db31fea189a36908c51e507cd420" +
+	"c41595928cc1a47b429fd109d46ce89e944ffd933d11"
+
+func TestVerifyUpdateFile(t *testing.T) {
+	keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(testKey))
+	require.NoError(t, err)
+	currentVersion := semver.NewSemVer(4, 14, 1)
+
+	updateFile, err := verifyUpdateFile(keyRing, []byte(testData), []byte(testSignature), currentVersion)
+	require.NoError(t, err)
+	require.Equal(t, semver.NewSemVer(4, 15, 0), updateFile.NewVersion)
+	require.Equal(t, "test", updateFile.Description)
+	subkeySignature, err := hex.DecodeString(testSubkeySignature)
+	require.NoError(t, err)
+	_, err = verifyUpdateFile(keyRing, []byte(testData), subkeySignature, currentVersion)
+	require.NoError(t, err)
+
+	// Modified data or signature.
+	_, err = verifyUpdateFile(keyRing, []byte(testData+" "), []byte(testSignature), currentVersion)
+	require.Error(t, err)
+	modifiedSignature := append([]byte{}, subkeySignature...)
+	modifiedSignature[len(modifiedSignature)-1] ^= 1
+	_, err = verifyUpdateFile(keyRing, []byte(testData), modifiedSignature, currentVersion)
+	require.Error(t, err)
+	_, err = verifyUpdateFile(keyRing, []byte(testData), []byte("garbage"), currentVersion)
+	require.Error(t, err)
+
+	// Signed by an unknown key.
+	releaseKeys, err := releaseKeyRing()
+	require.NoError(t, err)
+	_, err = verifyUpdateFile(releaseKeys, []byte(testData), []byte(testSignature), currentVersion)
+	require.Error(t, err)
+
+	// Update files older than the current version are rejected.
+	_, err = verifyUpdateFile(keyRing, []byte(testData), []byte(testSignature), semver.NewSemVer(4, 15, 0))
+	require.NoError(t, err)
+	_, err = verifyUpdateFile(keyRing, []byte(testData), []byte(testSignature), semver.NewSemVer(4, 15, 1))
+	require.Error(t, err)
+}
+
+func TestDownloadArtifact(t *testing.T) {
+	content := []byte("release")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+	hash := sha256.Sum256(content)
+	folder := test.TstTempDir("bitbox-wallet-update-")
+
+	filename, err := downloadArtifact(server.Client(), &UpdateArtifact{
+		URL:    server.URL + "/release.tar.gz",
+		SHA256: hex.EncodeToString(hash[:]),
+	}, folder)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(folder, "release.tar.gz"), filename)
+	downloaded, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, content, downloaded)
+
+	// Hash mismatch.
+	_, err = downloadArtifact(server.Client(), &UpdateArtifact{
+		URL:    server.URL + "/other.tar.gz",
+		SHA256: hex.EncodeToString(make([]byte, sha256.Size)),
+	}, folder)
+	require.Error(t, err)
+	for _, name := range []string{"other.tar.gz", "other.tar.gz.part"} {
+		_, err := os.Stat(filepath.Join(folder, name))
+		require.True(t, os.IsNotExist(err))
+	}
+
+	// Only https.
+	_, err = downloadArtifact(server.Client(), &UpdateArtifact{
+		URL:    "http://example.com/release.tar.gz",
+		SHA256: hex.EncodeToString(hash[:]),
+	}, folder)
+	require.Error(t, err)
+}
@@ -83,6 +83,9 @@ class App extends Component<Props, State> {
             case 'backend':
                 switch (data) {
                 case 'accountsStatusChanged':
+                case 'accountAdded':
+                case 'accountRemoved':
+                case 'accountChanged':
                     this.onAccountsStatusChanged();
                     break;
                 case 'newTxs':
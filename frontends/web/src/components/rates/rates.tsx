@@ -111,6 +111,9 @@ interface ProvidedProps {
     unstyled?: boolean;
     skipUnit?: boolean;
     noAction?: boolean;
+    // Precomputed conversions, e.g. the historical conversions of a transaction, used instead of
+    // the latest rates.
+    conversions?: { [fiat in Fiat]?: string };
 }
 
 type Props = ProvidedProps & SharedProps;
@@ -123,9 +126,10 @@ function Conversion({
     rates,
     active,
     noAction,
+    conversions,
     children,
 }: RenderableProps<Props>): JSX.Element | null {
-    if (!rates) {
+    if (!rates && !conversions) {
         return null;
     }
     const coin = amount.unit;
@@ -136,7 +140,9 @@ function Conversion({
         mainnetCoin = coin as MainnetCoin;
     }
     let formattedValue = '';
-    if (rates[mainnetCoin]) {
+    if (conversions) {
+        formattedValue = conversions[active] || '';
+    } else if (rates && rates[mainnetCoin]) {
         formattedValue = formatAsCurrency(rates[mainnetCoin][active] * Number(amount.amount));
     }
     if (tableRow) {
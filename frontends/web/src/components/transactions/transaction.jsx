@@ -62,6 +62,7 @@ export default class Transaction extends Component {
         time,
         addresses,
         status,
+        historicalConversions,
     }, {
         transactionDialog,
     }) {
@@ -266,6 +267,18 @@ export default class Transaction extends Component {
                                     </span>
                                 </p>
                             </div>
+                            {
+                                historicalConversions && (
+                                    <div className={style.detail}>
+                                        <label>Fiat at time of transaction</label>
+                                        <p>
+                                            <span className={[style.fiat, type === 'send' && style.send].join(' ')}>
+                                                <FiatConversion amount={amount} conversions={historicalConversions} noAction>{type === 'send' && sign} </FiatConversion>
+                                            </span>
+                                        </p>
+                                    </div>
+                                )
+                            }
                             <div className={style.detail}>
                                 <label>Amount</label>
                                 <p>
@@ -29,13 +29,23 @@ interface File {
     description: string;
 }
 
+/**
+ * The update file is only returned if its signature is valid and a newer version is available.
+ */
+interface UpdateResponse {
+    success: boolean;
+    update?: File | null;
+    errorMessage?: string;
+}
+
 interface LoadedProps {
-    file: File | null;
+    response: UpdateResponse;
 }
 
 type Props = LoadedProps & TranslateProps;
 
-function Update({ file, t }: RenderableProps<Props>): JSX.Element | null {
+function Update({ response, t }: RenderableProps<Props>): JSX.Element | null {
+    const file = response.success ? response.update : null;
     return file && (
         <Status dismissable keyName={`update-${file.version}`} type="info">
             {t('app.upgrade', {
@@ -51,6 +61,6 @@ function Update({ file, t }: RenderableProps<Props>): JSX.Element | null {
     );
 }
 
-const HOC = translate()(load<LoadedProps, TranslateProps>({ file: 'update' })(Update));
+const HOC = translate()(load<LoadedProps, TranslateProps>({ response: 'update' })(Update));
 
 export { HOC as Update };
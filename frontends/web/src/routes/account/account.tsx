@@ -141,6 +141,11 @@ class Account extends Component<Props, State> {
         if (!this.props.code) {
             return;
         }
+        if (data.subject === 'rates/historical') {
+            // The fiat values at the time of the transactions were fetched.
+            this.onAccountChanged();
+            return;
+        }
         if (data.type !== 'account' || data.code !== this.props.code) {
             return;
         }
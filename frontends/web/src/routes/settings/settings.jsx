@@ -17,7 +17,7 @@
 import { Component, h } from 'preact';
 import { Link, route } from 'preact-router';
 import { translate } from 'react-i18next';
-import { apiGet, apiPost } from '../../utils/request';
+import { apiGet } from '../../utils/request';
 import { setConfig } from '../../utils/config';
 import { Badge } from '../../components/badge/badge';
 import { debug } from '../../utils/env';
@@ -36,18 +36,10 @@ import * as dialogStyle from '../../components/dialog/dialog.css';
 
 @translate()
 export default class Settings extends Component {
-    erc20TokenCodes = {
-        usdt: 'Tether USD',
-        link: 'Chainlink',
-        bat: 'Basic Attention Token',
-        mkr: 'Maker',
-        zrx: '0x',
-        dai: 'Dai',
-    }
-
     state = {
         restart: false,
         config: null,
+        erc20Tokens: [],
         proxyAddress: undefined,
         activeProxyDialog: false,
     }
@@ -56,6 +48,7 @@ export default class Settings extends Component {
         apiGet('config').then(config => {
             this.setState({ config, proxyAddress: config.backend.proxy.proxyAddress });
         });
+        apiGet('erc20-tokens').then(erc20Tokens => this.setState({ erc20Tokens }));
     }
 
     componentDidUpdate(prevProps) {
@@ -70,10 +63,7 @@ export default class Settings extends Component {
                 [event.target.id]: event.target.checked
             }
         })
-            .then(config => {
-                this.setState({ config });
-                this.reinitializeAccounts();
-            });
+            .then(config => this.setState({ config }));
     }
 
     handleToggleCoinControl = event => {
@@ -85,20 +75,13 @@ export default class Settings extends Component {
             .then(config => this.setState({ config }));
     }
 
-    reinitializeAccounts = () => {
-        apiPost('accounts/reinitialize');
-    }
-
     handleToggleEthereum = event => {
         setConfig({
             backend: {
                 ethereumActive: event.target.checked
             }
         })
-            .then(config => {
-                this.setState({ config });
-                this.reinitializeAccounts();
-            });
+            .then(config => this.setState({ config }));
     }
 
     handleToggleERC20Token = event => {
@@ -116,10 +99,7 @@ export default class Settings extends Component {
         setConfig({
             backend: { eth }
         })
-            .then(config => {
-                this.setState({ config });
-                this.reinitializeAccounts();
-            });
+            .then(config => this.setState({ config }));
     }
 
     handleFormChange = event => {
@@ -178,6 +158,7 @@ export default class Settings extends Component {
         deviceIDs,
     }, {
         config,
+        erc20Tokens,
         restart,
         proxyAddress,
         activeProxyDialog,
@@ -198,6 +179,10 @@ export default class Settings extends Component {
                 name: 'bitcoinP2WPKHP2SHActive',
                 badges: ['BB01', 'BB02', 'BB02-BTC'],
             },
+            {
+                name: 'bitcoinP2TRActive',
+                badges: [],
+            },
             {
                 name: 'litecoinP2WPKHActive',
                 badges: ['BB01', 'BB02'],
@@ -290,7 +275,7 @@ export default class Settings extends Component {
                                                     </div>
                                                     <div className="box slim">
                                                         {
-                                                            Object.entries(this.erc20TokenCodes).map(([tokenCode, tokenName]) => (
+                                                            erc20Tokens.map(({ code: tokenCode, name: tokenName }) => (
                                                                 <div className={[style.currency, !config.backend.ethereumActive ? style.disabled : ''].join(' ')} key={tokenCode}>
                                                                     <p className="m-none">{tokenName}</p>
                                                                     <Toggle
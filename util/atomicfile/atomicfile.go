@@ -0,0 +1,63 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package atomicfile writes files such that a crash leaves either the old or the new content.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// WriteFile writes the data to a temporary file in the same directory, syncs it to disk and
+// renames it to filename, so the file is never partially written. The directory is created if it
+// does not exist.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errp.WithStack(err)
+	}
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(filename)+".tmp")
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	tmpFilename := tmpFile.Name()
+	removeTmp := func() { _ = os.Remove(tmpFilename) }
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		removeTmp()
+		return errp.WithStack(err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		removeTmp()
+		return errp.WithStack(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		removeTmp()
+		return errp.WithStack(err)
+	}
+	if err := os.Chmod(tmpFilename, perm); err != nil {
+		removeTmp()
+		return errp.WithStack(err)
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		removeTmp()
+		return errp.WithStack(err)
+	}
+	return nil
+}
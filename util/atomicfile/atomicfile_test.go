@@ -0,0 +1,45 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitbox-wallet-atomicfile-")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	filename := filepath.Join(dir, "subdir", "file.json")
+	require.NoError(t, WriteFile(filename, []byte("old"), 0600))
+	require.NoError(t, WriteFile(filename, []byte("new"), 0600))
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(filepath.Dir(filename))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
@@ -19,20 +19,60 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/atomicfile"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+)
+
+var (
+	encryptionKeyMu sync.RWMutex
+	encryptionKey   *encryption.Key
 )
 
+// SetEncryptionKey sets the key with which all config files are encrypted. Unencrypted files are
+// encrypted the next time they are read or written.
+func SetEncryptionKey(key *encryption.Key) {
+	encryptionKeyMu.Lock()
+	defer encryptionKeyMu.Unlock()
+	encryptionKey = key
+}
+
+func getEncryptionKey() *encryption.Key {
+	encryptionKeyMu.RLock()
+	defer encryptionKeyMu.RUnlock()
+	return encryptionKey
+}
+
 // File models a config file in the application's directory.
 // Callers can use AppDir function to obtain the default app config dir.
 type File struct {
 	dir  string
 	name string
+	// plaintext is true if the file is never encrypted.
+	plaintext bool
 }
 
-// NewFile creates a new config file with the given name in a directory dir.
+// NewFile creates a new config file with the given name in a directory dir. The file is encrypted
+// if an encryption key is set.
 func NewFile(dir, name string) *File {
 	return &File{dir: dir, name: name}
 }
 
+// NewPlaintextFile creates a new config file which is never encrypted. Use it for files which are
+// needed before the encryption key is known.
+func NewPlaintextFile(dir, name string) *File {
+	return &File{dir: dir, name: name, plaintext: true}
+}
+
+// encryptionKey returns the key with which the file is encrypted, or nil.
+func (file *File) encryptionKey() *encryption.Key {
+	if file.plaintext {
+		return nil
+	}
+	return getEncryptionKey()
+}
+
 // Path returns the absolute path to the config file.
 func (file *File) Path() string {
 	return filepath.Join(file.dir, file.name)
@@ -50,8 +90,20 @@ func (file *File) Remove() error {
 }
 
 // read reads the config file and returns its data (or an error if the config file does not exist).
+// An unencrypted file is encrypted if an encryption key is set.
 func (file *File) read() ([]byte, error) {
-	return ioutil.ReadFile(file.Path())
+	data, err := ioutil.ReadFile(file.Path())
+	if err != nil {
+		return nil, err
+	}
+	key := file.encryptionKey()
+	if encryption.IsEncrypted(data) || key == nil {
+		return key.Decrypt(data)
+	}
+	if err := file.write(data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // ReadJSON reads the config file as JSON to the given object. Make sure the config file exists!
@@ -64,11 +116,20 @@ func (file *File) ReadJSON(object interface{}) error {
 }
 
 // write writes the given data to the config file (and creates parent directories if necessary).
+// The data is encrypted if an encryption key is set. An encrypted file is not overwritten before
+// the key is set.
 func (file *File) write(data []byte) error {
-	if err := os.MkdirAll(file.dir, 0700); err != nil {
+	key := file.encryptionKey()
+	if key == nil {
+		if existing, err := ioutil.ReadFile(file.Path()); err == nil && encryption.IsEncrypted(existing) {
+			return encryption.ErrLocked
+		}
+	}
+	data, err := key.Encrypt(data)
+	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(file.Path(), data, 0600)
+	return atomicfile.WriteFile(file.Path(), data, 0600)
 }
 
 // WriteJSON writes the given object as JSON to the config file.
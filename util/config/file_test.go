@@ -0,0 +1,63 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/encryption"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitbox-wallet-config-")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	defer SetEncryptionKey(nil)
+
+	type object struct{ Value string }
+	file := NewFile(dir, "test.json")
+	require.NoError(t, file.WriteJSON(object{Value: "plain"}))
+
+	// The unencrypted file is encrypted when it is read with a key.
+	key, err := encryption.NewKey()
+	require.NoError(t, err)
+	SetEncryptionKey(key)
+	var result object
+	require.NoError(t, file.ReadJSON(&result))
+	require.Equal(t, "plain", result.Value)
+	data, err := ioutil.ReadFile(file.Path())
+	require.NoError(t, err)
+	require.True(t, encryption.IsEncrypted(data))
+
+	require.NoError(t, file.WriteJSON(object{Value: "secret"}))
+	require.NoError(t, file.ReadJSON(&result))
+	require.Equal(t, "secret", result.Value)
+
+	// Without the key, the file can't be read nor overwritten.
+	SetEncryptionKey(nil)
+	require.Equal(t, encryption.ErrLocked, file.ReadJSON(&result))
+	require.Equal(t, encryption.ErrLocked, file.WriteJSON(object{Value: "plain"}))
+
+	// Plaintext files are never encrypted.
+	SetEncryptionKey(key)
+	plaintextFile := NewPlaintextFile(dir, "plaintext.json")
+	require.NoError(t, plaintextFile.WriteJSON(object{Value: "plain"}))
+	data, err = ioutil.ReadFile(plaintextFile.Path())
+	require.NoError(t, err)
+	require.False(t, encryption.IsEncrypted(data))
+}
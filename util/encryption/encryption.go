@@ -0,0 +1,162 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryption encrypts data stored on disk, so that the addresses, transactions and xpubs
+// of the wallet are not revealed to anyone with access to the disk.
+package encryption
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+)
+
+// magic prefixes all encrypted data, so that unencrypted data written before the encryption was
+// enabled can be detected and migrated.
+var magic = []byte("bbenc\x01")
+
+// keySize is the size of the encryption key and of the authentication key.
+const keySize = 32
+
+// minEncryptedSize is the size of the IV, one AES block and the HMAC.
+const minEncryptedSize = 16 + 16 + sha256.Size
+
+// ErrLocked is returned when encrypted data is accessed before the key is known.
+var ErrLocked = errp.New("The storage is encrypted and locked")
+
+// Key encrypts and authenticates stored data. A nil key is valid and leaves the data unencrypted.
+type Key struct {
+	encryptionKey     []byte
+	authenticationKey []byte
+}
+
+// NewKey creates a new random key.
+func NewKey() (*Key, error) {
+	keyBytes := make([]byte, 2*keySize)
+	if _, err := io.ReadFull(rand.Reader, keyBytes); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return newKeyFromBytes(keyBytes), nil
+}
+
+func newKeyFromBytes(keyBytes []byte) *Key {
+	return &Key{
+		encryptionKey:     keyBytes[:keySize],
+		authenticationKey: keyBytes[keySize:],
+	}
+}
+
+func (key *Key) bytes() []byte {
+	return append(append([]byte{}, key.encryptionKey...), key.authenticationKey...)
+}
+
+// ID identifies the key without revealing it, e.g. to tell which data was encrypted with which key.
+func (key *Key) ID() string {
+	mac := hmac.New(sha256.New, key.authenticationKey)
+	_, _ = mac.Write([]byte("key id"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// IsEncrypted returns true if the data was encrypted by a key.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Encrypt encrypts and authenticates the data. If the key is nil, the data is returned as is.
+func (key *Key) Encrypt(data []byte) ([]byte, error) {
+	if key == nil {
+		return data, nil
+	}
+	encrypted, err := crypto.EncryptThenMAC(data, key.encryptionKey, key.authenticationKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, magic...), encrypted...), nil
+}
+
+// Decrypt authenticates and decrypts data returned by Encrypt. Unencrypted data is returned as is,
+// so that data written before the encryption was enabled can still be read. Returns ErrLocked if
+// the data is encrypted and the key is nil.
+func (key *Key) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, ErrLocked
+	}
+	if len(data)-len(magic) < minEncryptedSize {
+		return nil, errp.New("The encrypted data is too short")
+	}
+	// The data is decrypted in place, and can be read-only memory, e.g. a value of a bbolt db.
+	encrypted := append([]byte{}, data[len(magic):]...)
+	return crypto.MACThenDecrypt(encrypted, key.encryptionKey, key.authenticationKey)
+}
+
+// HashID returns a keyed hash of the id, for data which is only looked up and never needs to be
+// decrypted. If the key is nil, the id is returned as is.
+func (key *Key) HashID(id []byte) []byte {
+	if key == nil {
+		return id
+	}
+	mac := hmac.New(sha256.New, key.authenticationKey)
+	_, _ = mac.Write(id)
+	return mac.Sum(nil)
+}
+
+// EncryptRecord returns the key and value under which a record of a key/value database is stored.
+// The key is the keyed hash of the id, so it does not reveal the id, and the id is stored together
+// with the value, so the records can still be enumerated with DecryptRecord. If the key is nil,
+// the id and the value are returned as is.
+func (key *Key) EncryptRecord(id []byte, value []byte) ([]byte, []byte, error) {
+	if key == nil {
+		return id, value, nil
+	}
+	record := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(id)+len(value))
+	record = append(record[:binary.PutUvarint(record, uint64(len(id)))], id...)
+	encryptedValue, err := key.Encrypt(append(record, value...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.HashID(id), encryptedValue, nil
+}
+
+// DecryptRecord returns the id and the value of a record stored under the key and value returned
+// by EncryptRecord. Unencrypted records are returned as is. Returns ErrLocked if the record is
+// encrypted and the key is nil.
+func (key *Key) DecryptRecord(dbKey []byte, dbValue []byte) ([]byte, []byte, error) {
+	if !IsEncrypted(dbValue) {
+		return dbKey, dbValue, nil
+	}
+	record, err := key.Decrypt(dbValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	idLen, n := binary.Uvarint(record)
+	if n <= 0 || uint64(len(record)-n) < idLen {
+		return nil, nil, errp.New("The encrypted record is corrupt")
+	}
+	id := record[n : n+int(idLen)]
+	// The value must not be moved to another key.
+	if !hmac.Equal(key.HashID(id), dbKey) {
+		return nil, nil, errp.New("The encrypted record does not belong to its key")
+	}
+	return id, record[n+int(idLen):], nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"testing"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	plaintext := []byte("xpub6CUGRUonZSQ4TWtTMmzXdrXDtypWKiKrhko4egpiMZbpiaQL2jkwSB1icqYh2cfDfVxdx4df189oLKnC5fSwqPfgyP3hooxujYzAu3fDVmz")
+
+	encrypted, err := key.Encrypt(plaintext)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(encrypted))
+	require.NotContains(t, string(encrypted), string(plaintext))
+	decrypted, err := key.Decrypt(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	// Unencrypted data is passed through.
+	require.False(t, IsEncrypted(plaintext))
+	decrypted, err = key.Decrypt(plaintext)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	// Without the key, encrypted data can't be read.
+	var noKey *Key
+	_, err = noKey.Decrypt(encrypted)
+	require.Equal(t, ErrLocked, err)
+	unencrypted, err := noKey.Encrypt(plaintext)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, unencrypted)
+
+	// Tampered data is rejected.
+	encrypted[len(encrypted)-1] ^= 1
+	_, err = key.Decrypt(encrypted)
+	require.Error(t, err)
+	_, err = key.Decrypt(magic)
+	require.Error(t, err)
+
+	otherKey, err := NewKey()
+	require.NoError(t, err)
+	require.Equal(t, key.HashID([]byte("id")), key.HashID([]byte("id")))
+	require.NotEqual(t, key.HashID([]byte("id")), otherKey.HashID([]byte("id")))
+	require.Equal(t, []byte("id"), noKey.HashID([]byte("id")))
+}
+
+func TestRecord(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	id := []byte("txid:0")
+	value := []byte(`{"value":1000}`)
+
+	dbKey, dbValue, err := key.EncryptRecord(id, value)
+	require.NoError(t, err)
+	require.Equal(t, key.HashID(id), dbKey)
+	require.NotContains(t, string(dbValue), string(id))
+	decryptedID, decryptedValue, err := key.DecryptRecord(dbKey, dbValue)
+	require.NoError(t, err)
+	require.Equal(t, id, decryptedID)
+	require.Equal(t, value, decryptedValue)
+
+	// Empty values are supported.
+	dbKey, dbValue, err = key.EncryptRecord(id, nil)
+	require.NoError(t, err)
+	decryptedID, decryptedValue, err = key.DecryptRecord(dbKey, dbValue)
+	require.NoError(t, err)
+	require.Equal(t, id, decryptedID)
+	require.Empty(t, decryptedValue)
+
+	// A value can't be moved to another key.
+	_, _, err = key.DecryptRecord(key.HashID([]byte("txid:1")), dbValue)
+	require.Error(t, err)
+
+	// Without a key, records are stored as is.
+	var noKey *Key
+	dbKey, dbValue, err = noKey.EncryptRecord(id, value)
+	require.NoError(t, err)
+	require.Equal(t, id, dbKey)
+	require.Equal(t, value, dbValue)
+	decryptedID, decryptedValue, err = key.DecryptRecord(dbKey, dbValue)
+	require.NoError(t, err)
+	require.Equal(t, id, decryptedID)
+	require.Equal(t, value, decryptedValue)
+}
+
+func TestKeyring(t *testing.T) {
+	filename := test.TstTempFile("bitbox-wallet-keyring-")
+	keyring, err := LoadKeyring(filename)
+	require.NoError(t, err)
+	require.False(t, keyring.Enabled())
+	_, err = keyring.Unlock([]byte("passphrase"))
+	require.Error(t, err)
+
+	key, err := NewKey()
+	require.NoError(t, err)
+	require.Error(t, keyring.Enable(key, SourcePassphrase, nil))
+	require.False(t, keyring.Enabled())
+	require.NoError(t, keyring.Enable(key, SourcePassphrase, []byte("passphrase")))
+	require.True(t, keyring.Enabled())
+	require.Error(t, keyring.Enable(key, SourcePassphrase, []byte("passphrase")))
+
+	keyring, err = LoadKeyring(filename)
+	require.NoError(t, err)
+	require.True(t, keyring.Enabled())
+	require.Equal(t, SourcePassphrase, keyring.Source())
+	_, err = keyring.Unlock([]byte("wrong"))
+	require.Equal(t, ErrWrongSecret, err)
+	unlockedKey, err := keyring.Unlock([]byte("passphrase"))
+	require.NoError(t, err)
+	require.Equal(t, key, unlockedKey)
+
+	require.Equal(t, key.ID(), keyring.KeyID())
+
+	// Rotating replaces the storage key and the secret.
+	newKey, err := NewKey()
+	require.NoError(t, err)
+	require.NotEqual(t, key.ID(), newKey.ID())
+	require.Equal(t, ErrWrongSecret, keyring.Rotate([]byte("wrong"), newKey, SourceKeystore, []byte("secret")))
+	require.Equal(t, key.ID(), keyring.KeyID())
+	require.NoError(t, keyring.Rotate([]byte("passphrase"), newKey, SourceKeystore, []byte("secret")))
+	keyring, err = LoadKeyring(filename)
+	require.NoError(t, err)
+	require.Equal(t, SourceKeystore, keyring.Source())
+	require.Equal(t, newKey.ID(), keyring.KeyID())
+	_, err = keyring.Unlock([]byte("passphrase"))
+	require.Equal(t, ErrWrongSecret, err)
+	unlockedKey, err = keyring.Unlock([]byte("secret"))
+	require.NoError(t, err)
+	require.Equal(t, newKey, unlockedKey)
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Shift Devices AG
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/digitalbitbox/bitbox-wallet-app/util/atomicfile"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/crypto"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/errp"
+	"github.com/digitalbitbox/bitbox-wallet-app/util/locker"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Source is the source of the secret from which the key protecting the storage key is derived.
+type Source string
+
+const (
+	// SourcePassphrase means the secret is a passphrase entered by the user.
+	SourcePassphrase Source = "passphrase"
+	// SourceKeystore means the secret is derived from the keystore, so the storage is unlocked when
+	// the device is connected.
+	SourceKeystore Source = "keystore"
+)
+
+// scrypt parameters of the key derivation, as recommended for interactive logins.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+const keyringVersion = 1
+
+// ErrWrongSecret is returned if the storage key can't be decrypted with the given secret.
+var ErrWrongSecret = errp.New("Wrong passphrase")
+
+// keyringFile is the content of the keyring file. The storage key is stored encrypted with a key
+// derived from the secret, together with its id, see Key.ID().
+type keyringFile struct {
+	Version    int    `json:"version"`
+	Source     Source `json:"source"`
+	Salt       string `json:"salt"`
+	WrappedKey string `json:"wrappedKey"`
+	KeyID      string `json:"keyId"`
+}
+
+// Keyring persists the storage key, protected by a passphrase or by the keystore.
+type Keyring struct {
+	filename string
+
+	lock locker.Locker
+	file *keyringFile
+}
+
+// LoadKeyring loads the keyring from the given file. If the file does not exist, the encryption is
+// disabled.
+func LoadKeyring(filename string) (*Keyring, error) {
+	keyring := &Keyring{filename: filename}
+	jsonBytes, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return keyring, nil
+	}
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	file := &keyringFile{}
+	if err := json.Unmarshal(jsonBytes, file); err != nil {
+		return nil, errp.WithStack(err)
+	}
+	if file.Version != keyringVersion {
+		return nil, errp.Newf("Unsupported keyring version %d", file.Version)
+	}
+	keyring.file = file
+	return keyring, nil
+}
+
+// Enabled returns true if the storage is encrypted.
+func (keyring *Keyring) Enabled() bool {
+	defer keyring.lock.RLock()()
+	return keyring.file != nil
+}
+
+// Source returns the source of the secret. Only valid if the encryption is enabled.
+func (keyring *Keyring) Source() Source {
+	defer keyring.lock.RLock()()
+	if keyring.file == nil {
+		return ""
+	}
+	return keyring.file.Source
+}
+
+// KeyID returns the id of the storage key, see Key.ID(), or an empty string if the encryption is
+// disabled. It is known before the keyring is unlocked.
+func (keyring *Keyring) KeyID() string {
+	defer keyring.lock.RLock()()
+	if keyring.file == nil {
+		return ""
+	}
+	return keyring.file.KeyID
+}
+
+// deriveKey derives the key protecting the storage key from the secret.
+func deriveKey(secret []byte, salt []byte) (*Key, error) {
+	keyBytes, err := scrypt.Key(secret, salt, scryptN, scryptR, scryptP, 2*keySize)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	return newKeyFromBytes(keyBytes), nil
+}
+
+// wrap encrypts the storage key with a key derived from the secret and a new salt. Requires the
+// lock.
+func (keyring *Keyring) wrap(key *Key, source Source, secret []byte) error {
+	if len(secret) == 0 {
+		return errp.New("The secret must not be empty")
+	}
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return errp.WithStack(err)
+	}
+	wrappingKey, err := deriveKey(secret, salt)
+	if err != nil {
+		return err
+	}
+	wrappedKey, err := crypto.EncryptThenMAC(
+		key.bytes(), wrappingKey.encryptionKey, wrappingKey.authenticationKey)
+	if err != nil {
+		return err
+	}
+	file := &keyringFile{
+		Version:    keyringVersion,
+		Source:     source,
+		Salt:       hex.EncodeToString(salt),
+		WrappedKey: hex.EncodeToString(wrappedKey),
+		KeyID:      key.ID(),
+	}
+	jsonBytes, err := json.Marshal(file)
+	if err != nil {
+		return errp.WithStack(err)
+	}
+	// Written atomically, as losing the keyring means losing the encrypted data.
+	if err := atomicfile.WriteFile(keyring.filename, jsonBytes, 0600); err != nil {
+		return err
+	}
+	keyring.file = file
+	return nil
+}
+
+// unwrap decrypts the storage key. Requires the lock.
+func (keyring *Keyring) unwrap(secret []byte) (*Key, error) {
+	if keyring.file == nil {
+		return nil, errp.New("The storage is not encrypted")
+	}
+	salt, err := hex.DecodeString(keyring.file.Salt)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	wrappedKey, err := hex.DecodeString(keyring.file.WrappedKey)
+	if err != nil {
+		return nil, errp.WithStack(err)
+	}
+	wrappingKey, err := deriveKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < minEncryptedSize {
+		return nil, errp.New("The keyring is corrupt")
+	}
+	keyBytes, err := crypto.MACThenDecrypt(
+		wrappedKey, wrappingKey.encryptionKey, wrappingKey.authenticationKey)
+	if err != nil {
+		return nil, ErrWrongSecret
+	}
+	if len(keyBytes) != 2*keySize {
+		return nil, errp.New("The keyring is corrupt")
+	}
+	return newKeyFromBytes(keyBytes), nil
+}
+
+// Enable stores the storage key, protected by the secret. The keyring file is written atomically,
+// so it can be used to commit the migration of the data to the encrypted storage.
+func (keyring *Keyring) Enable(key *Key, source Source, secret []byte) error {
+	defer keyring.lock.Lock()()
+	if keyring.file != nil {
+		return errp.New("The storage is already encrypted")
+	}
+	return keyring.wrap(key, source, secret)
+}
+
+// Unlock returns the storage key. Returns ErrWrongSecret if the secret is wrong.
+func (keyring *Keyring) Unlock(secret []byte) (*Key, error) {
+	defer keyring.lock.RLock()()
+	return keyring.unwrap(secret)
+}
+
+// Rotate replaces the storage key by a new key, protected by a new secret. The old secret has to
+// be given, so the key can only be replaced by someone who could unlock it. Like Enable(), the
+// keyring file is written atomically, so it can be used to commit the migration of the data to the
+// new key.
+func (keyring *Keyring) Rotate(oldSecret []byte, key *Key, source Source, newSecret []byte) error {
+	defer keyring.lock.Lock()()
+	if _, err := keyring.unwrap(oldSecret); err != nil {
+		return err
+	}
+	return keyring.wrap(key, source, newSecret)
+}
@@ -36,7 +36,7 @@ var once sync.Once
 func Get() *Logger {
 	once.Do(func() {
 		var configuration Configuration
-		configFile := config.NewFile(config.AppDir(), configFileName)
+		configFile := config.NewPlaintextFile(config.AppDir(), configFileName)
 		if configFile.Exists() {
 			if err := configFile.ReadJSON(&configuration); err != nil {
 				panic(errp.WithStack(err))
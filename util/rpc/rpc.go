@@ -53,3 +53,18 @@ type Backend interface {
 	EstablishConnection() (io.ReadWriteCloser, error)
 	ServerInfo() *ServerInfo
 }
+
+// BitcoinCoreInfo holds the connection details of a Bitcoin Core node accessed via JSON-RPC.
+type BitcoinCoreInfo struct {
+	// URL is the URL of the RPC interface, e.g. http://127.0.0.1:8332.
+	URL string `json:"url"`
+	// CookieFile is the path of the cookie file of the node, e.g. ~/.bitcoin/.cookie, containing the
+	// RPC credentials. The credentials are not stored in the config.
+	CookieFile string `json:"cookieFile"`
+	// Wallet is the name of the watch-only wallet used in the node.
+	Wallet string `json:"wallet"`
+	// RescanFromGenesis is set by the user to confirm that the whole chain is rescanned for the
+	// imported scripts if the wallet birthday is unknown. Without it, no scripts are imported until
+	// the wallet birthday is configured.
+	RescanFromGenesis bool `json:"rescanFromGenesis"`
+}